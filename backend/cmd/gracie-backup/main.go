@@ -0,0 +1,109 @@
+// Command gracie-backup exports the store selected by DATA_STORE to a
+// compressed archive and imports archives into it (see internal/backup). To
+// move a deployment between backends, export with the old DATA_STORE and
+// import with the new one.
+//
+// Usage:
+//
+//	gracie-backup export [-out FILE]   write an archive (default gracie-<store>-<time>.jsonl.gz, "-" for stdout)
+//	gracie-backup import -in FILE      restore an archive into an empty store and verify the counts
+//	gracie-backup verify -in FILE      check an archive without touching any store
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/backup"
+    "github.com/janvillarosa/gracie-app/backend/internal/config"
+    "github.com/janvillarosa/gracie-app/backend/internal/store/stores"
+)
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: gracie-backup export [-out FILE] | import -in FILE | verify -in FILE")
+    os.Exit(2)
+}
+
+func main() {
+    if len(os.Args) < 2 { usage() }
+    cmd, args := os.Args[1], os.Args[2:]
+
+    fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+    out := fs.String("out", "", "archive to write (\"-\" for stdout)")
+    in := fs.String("in", "", "archive to read (\"-\" for stdin)")
+    _ = fs.Parse(args)
+
+    if cmd == "verify" {
+        r, closeIn := openIn(*in)
+        defer closeIn()
+        h, n, err := backup.Verify(r)
+        if err != nil { log.Fatalf("verify: %v", err) }
+        log.Printf("archive from %s at %s (format %d) is intact: %s", h.Source, h.CreatedAt.Format(time.RFC3339), h.Version, counts(n))
+        return
+    }
+    if cmd != "export" && cmd != "import" { usage() }
+
+    ctx := context.Background()
+    cfg, err := config.Load()
+    if err != nil { log.Fatalf("config: %v", err) }
+
+    st, err := stores.Open(ctx, cfg)
+    if err != nil { log.Fatalf("store: %v", err) }
+    defer st.Close()
+    src := backup.Source{Users: st.UserScanner, Rooms: st.RoomScanner, Lists: st.ListScanner, Items: st.ItemScanner, UserTokens: st.UserTokenScanner, Invites: st.InviteScanner, CategoryIndex: st.CategoryScanner}
+
+    switch cmd {
+    case "export":
+        path := *out
+        if path == "" { path = fmt.Sprintf("gracie-%s-%s.jsonl.gz", cfg.DataStore, time.Now().UTC().Format("20060102T150405Z")) }
+        var w io.Writer = os.Stdout
+        var f *os.File
+        if path != "-" {
+            f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+            if err != nil { fatal(st, "export: %v", err) }
+            w = f
+        }
+        n, err := backup.Export(ctx, src, cfg.DataStore, w)
+        if err == nil && f != nil { err = f.Close() }
+        if err != nil {
+            if f != nil { _ = os.Remove(path) }
+            fatal(st, "export: %v", err)
+        }
+        if src.CategoryIndex == nil { log.Printf("%s: no category index; none exported", cfg.DataStore) }
+        log.Printf("exported %s from %s to %s", counts(n), cfg.DataStore, path)
+    case "import":
+        r, closeIn := openIn(*in)
+        defer closeIn()
+        dst := backup.Target{Users: st.Users, Rooms: st.Rooms, Lists: st.Lists, Items: st.Items, UserTokens: st.UserTokens, Invites: st.Invites, CategoryIndex: st.CategoryIndex, Scan: src}
+        res, err := backup.Import(ctx, dst, r)
+        if err != nil { fatal(st, "import: %v", err) }
+        if res.SkippedCategories > 0 {
+            log.Printf("%s: no category index; skipped %d entries", cfg.DataStore, res.SkippedCategories)
+        }
+        log.Printf("imported %s archive from %s into %s; store now holds %s", res.Header.CreatedAt.Format(time.RFC3339), res.Header.Source, cfg.DataStore, counts(res.Stored))
+    }
+}
+
+// openIn opens the archive named by -in, or stdin for "-".
+func openIn(path string) (io.Reader, func()) {
+    if path == "" { usage() }
+    if path == "-" { return os.Stdin, func() {} }
+    f, err := os.Open(path)
+    if err != nil { log.Fatalf("%v", err) }
+    return f, func() { _ = f.Close() }
+}
+
+// fatal closes the store before exiting, since log.Fatalf skips deferred calls.
+func fatal(st *stores.Set, format string, args ...any) {
+    st.Close()
+    log.Fatalf(format, args...)
+}
+
+func counts(n backup.Counts) string {
+    return fmt.Sprintf("%d users, %d rooms, %d lists, %d items, %d user tokens, %d invites, %d category index entries", n.Users, n.Rooms, n.Lists, n.Items, n.UserTokens, n.Invites, n.CategoryIndex)
+}
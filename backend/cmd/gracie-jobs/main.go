@@ -0,0 +1,62 @@
+// Command gracie-jobs inspects the background job queue of the store selected
+// by DATA_STORE.
+//
+// Usage:
+//
+//	gracie-jobs dead              list dead-lettered jobs
+//	gracie-jobs requeue <job_id>  make a dead job pending again
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/config"
+    "github.com/janvillarosa/gracie-app/backend/internal/store/stores"
+)
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: gracie-jobs dead | requeue <job_id>")
+    os.Exit(2)
+}
+
+func main() {
+    if len(os.Args) < 2 { usage() }
+    ctx := context.Background()
+    cfg, err := config.Load()
+    if err != nil { log.Fatalf("config: %v", err) }
+
+    st, err := stores.Open(ctx, cfg)
+    if err != nil { log.Fatalf("store: %v", err) }
+    defer st.Close()
+
+    switch os.Args[1] {
+    case "dead":
+        dead, err := st.Jobs.ListDead(ctx)
+        if err != nil {
+            st.Close()
+            log.Fatalf("list dead jobs: %v", err)
+        }
+        for _, j := range dead {
+            var payload []string
+            for k, v := range j.Payload { payload = append(payload, k+"="+v) }
+            fmt.Printf("%s\t%s\t%s\tattempts=%d\tupdated=%s\t%s\n",
+                j.JobID, j.Kind, strings.Join(payload, ","), j.Attempts, j.UpdatedAt.Format(time.RFC3339), j.LastError)
+        }
+        if len(dead) == 0 { log.Printf("no dead jobs") }
+    case "requeue":
+        if len(os.Args) != 3 { usage() }
+        now := time.Now().UTC()
+        if err := st.Jobs.Requeue(ctx, os.Args[2], now, now); err != nil {
+            st.Close()
+            log.Fatalf("requeue %s: %v", os.Args[2], err)
+        }
+        log.Printf("requeued %s", os.Args[2])
+    default:
+        usage()
+    }
+}
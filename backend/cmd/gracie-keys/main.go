@@ -0,0 +1,126 @@
+// Command gracie-keys manages the encryption keyring in ENC_KEY_FILE and
+// moves the secrets stored by the DATA_STORE selected store between its keys.
+// A key file holding a single raw key is converted to a keyring the first
+// time it is changed.
+//
+// To rotate on one server: rotate, restart, reencrypt, then retire the old
+// key. With several servers, rotate -stage and roll the file out first, so
+// every server can decrypt with the new key before any encrypts with it;
+// then promote it and roll out again.
+//
+// Any key but the primary can be retired, the root key k0 too. k0 opens
+// secrets from before keyrings and keys the API key hashes, recovery codes
+// and signed tokens issued before the first rotation. Those hashes and tokens
+// cannot be re-encrypted and retire only counts encrypted secrets, so
+// retiring a key signs out the API keys issued under it and the token
+// sessions not refreshed since, and voids its recovery codes and email
+// links; wait out API_KEY_TTL_HOURS and REFRESH_TOKEN_TTL_HOURS first to
+// avoid that.
+//
+// Usage:
+//
+//	gracie-keys list                   list the keys, root and primary marked
+//	gracie-keys rotate [-stage]        add a key and make it primary (-stage: only add it)
+//	gracie-keys promote ID             make key ID primary
+//	gracie-keys reencrypt [-dry-run]   re-encrypt stored secrets under the primary key
+//	gracie-keys retire [-force] ID     remove key ID once no stored secret needs it
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/auth"
+    "github.com/janvillarosa/gracie-app/backend/internal/config"
+    "github.com/janvillarosa/gracie-app/backend/internal/crypto"
+    "github.com/janvillarosa/gracie-app/backend/internal/services"
+    "github.com/janvillarosa/gracie-app/backend/internal/store/stores"
+)
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: gracie-keys list | rotate [-stage] | promote ID | reencrypt [-dry-run] | retire [-force] ID")
+    os.Exit(2)
+}
+
+func main() {
+    if len(os.Args) < 2 { usage() }
+    cmd, args := os.Args[1], os.Args[2:]
+
+    fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+    stage := fs.Bool("stage", false, "add the key without making it primary")
+    dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+    force := fs.Bool("force", false, "retire even if stored secrets still need the key")
+    _ = fs.Parse(args)
+
+    cfg, err := config.Load()
+    if err != nil { log.Fatalf("config: %v", err) }
+    ring, err := crypto.LoadOrCreateKeyring(cfg.EncKeyFile)
+    if err != nil { log.Fatalf("keyring %s: %v", cfg.EncKeyFile, err) }
+
+    switch cmd {
+    case "list":
+        for _, id := range ring.IDs() {
+            var marks string
+            if id == auth.LegacyKeyID { marks += " root" }
+            if id == ring.Primary() { marks += " primary" }
+            fmt.Printf("%s%s\n", id, marks)
+        }
+    case "rotate":
+        id, err := ring.Add(!*stage)
+        if err != nil { log.Fatalf("rotate: %v", err) }
+        save(ring, cfg.EncKeyFile)
+        if *stage {
+            log.Printf("added key %s; roll %s out to every server, then run gracie-keys promote %s", id, cfg.EncKeyFile, id)
+        } else {
+            log.Printf("added key %s as primary; restart the servers, then run gracie-keys reencrypt", id)
+        }
+    case "promote":
+        if fs.NArg() != 1 { usage() }
+        if err := ring.Promote(fs.Arg(0)); err != nil { log.Fatalf("promote %s: %v", fs.Arg(0), err) }
+        save(ring, cfg.EncKeyFile)
+        log.Printf("%s is primary; restart the servers, then run gracie-keys reencrypt", fs.Arg(0))
+    case "reencrypt", "retire":
+        if cmd == "retire" && fs.NArg() != 1 { usage() }
+        ctx := context.Background()
+        st, err := stores.Open(ctx, cfg)
+        if err != nil { log.Fatalf("store: %v", err) }
+        defer st.Close()
+        authSvc, err := services.NewAuthService(st.Users, st.Sessions, cfg.EncKeyFile, cfg.APIKeyTTLHours)
+        if err != nil { fatal(st, "auth service: %v", err) }
+        if cmd == "reencrypt" {
+            res, err := authSvc.ReencryptSecrets(ctx, st.UserScanner, *dryRun)
+            if err != nil { fatal(st, "reencrypt: %v", err) }
+            verb := "re-encrypted"
+            if *dryRun { verb = "would re-encrypt" }
+            log.Printf("%s: %s secrets of %d of %d users under %s", cfg.DataStore, verb, res.Reencrypted, res.Users, ring.Primary())
+            if res.Changed > 0 { log.Printf("%d users changed during the run; run reencrypt again", res.Changed) }
+            for id, n := range res.Remaining { log.Printf("%d secrets still need key %s", n, id) }
+            return
+        }
+        id := fs.Arg(0)
+        // A dry run counts the secrets on every key but the primary.
+        res, err := authSvc.ReencryptSecrets(ctx, st.UserScanner, true)
+        if err != nil { fatal(st, "retire: %v", err) }
+        if n := res.Remaining[id]; n > 0 && !*force {
+            fatal(st, "retire: %d stored secrets still need key %s; run gracie-keys reencrypt first", n, id)
+        }
+        if err := ring.Retire(id); err != nil { fatal(st, "retire: %v", err) }
+        if err := ring.Save(cfg.EncKeyFile); err != nil { fatal(st, "save %s: %v", cfg.EncKeyFile, err) }
+        log.Printf("retired key %s; roll %s out to every server", id, cfg.EncKeyFile)
+    default:
+        usage()
+    }
+}
+
+func save(ring *crypto.Keyring, path string) {
+    if err := ring.Save(path); err != nil { log.Fatalf("save %s: %v", path, err) }
+}
+
+// fatal closes the store before exiting, since log.Fatalf skips deferred calls.
+func fatal(st *stores.Set, format string, args ...any) {
+    st.Close()
+    log.Fatalf(format, args...)
+}
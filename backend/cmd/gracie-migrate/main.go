@@ -0,0 +1,75 @@
+// Command gracie-migrate applies versioned data migrations to the store
+// selected by DATA_STORE. Applied versions are tracked in the store itself.
+//
+// Usage:
+//
+//	gracie-migrate up [-dry-run]   apply pending migrations
+//	gracie-migrate status          list migrations and when they were applied
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/config"
+    "github.com/janvillarosa/gracie-app/backend/internal/migrate"
+    "github.com/janvillarosa/gracie-app/backend/internal/store/stores"
+)
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: gracie-migrate up [-dry-run] | status")
+    os.Exit(2)
+}
+
+func main() {
+    if len(os.Args) < 2 { usage() }
+    cmd, args := os.Args[1], os.Args[2:]
+
+    fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+    dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+    _ = fs.Parse(args)
+
+    ctx := context.Background()
+    cfg, err := config.Load()
+    if err != nil { log.Fatalf("config: %v", err) }
+
+    st, err := stores.Open(ctx, cfg)
+    if err != nil { log.Fatalf("store: %v", err) }
+    defer st.Close()
+
+    runner := migrate.NewRunner(st.Migrations, migrate.Env{Tx: st.Tx, Items: st.Items, ItemScanner: st.ItemScanner, Users: st.Users, UserScanner: st.UserScanner}, migrate.All)
+
+    switch cmd {
+    case "up":
+        results, err := runner.Up(ctx, *dryRun)
+        for _, r := range results {
+            if r.DryRun {
+                log.Printf("%04d_%s: would change %d records (dry run)", r.Version, r.Name, r.Changed)
+            } else {
+                log.Printf("%04d_%s: applied, changed %d records", r.Version, r.Name, r.Changed)
+            }
+        }
+        if err != nil {
+            st.Close()
+            log.Fatalf("%v", err)
+        }
+        if len(results) == 0 { log.Printf("%s: no pending migrations", cfg.DataStore) }
+    case "status":
+        statuses, err := runner.Status(ctx)
+        if err != nil {
+            st.Close()
+            log.Fatalf("status: %v", err)
+        }
+        for _, s := range statuses {
+            applied := "pending"
+            if s.AppliedAt != nil { applied = "applied " + s.AppliedAt.Format(time.RFC3339) }
+            fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+        }
+    default:
+        usage()
+    }
+}
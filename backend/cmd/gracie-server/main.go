@@ -6,16 +6,22 @@ import (
     "net/http"
     "os"
     "os/signal"
+    "strings"
     "syscall"
     "time"
 
+    "github.com/janvillarosa/gracie-app/backend/internal/auth"
     "github.com/janvillarosa/gracie-app/backend/internal/config"
     "github.com/janvillarosa/gracie-app/backend/internal/http/handlers"
     "github.com/janvillarosa/gracie-app/backend/internal/http/router"
-    "github.com/janvillarosa/gracie-app/backend/internal/parse"
+    "github.com/janvillarosa/gracie-app/backend/internal/jobs"
+    "github.com/janvillarosa/gracie-app/backend/internal/mail"
+    "github.com/janvillarosa/gracie-app/backend/internal/migrate"
+    "github.com/janvillarosa/gracie-app/backend/internal/oidc"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
     "github.com/janvillarosa/gracie-app/backend/internal/services"
     "github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
-    mongostore "github.com/janvillarosa/gracie-app/backend/internal/store/mongo"
+    "github.com/janvillarosa/gracie-app/backend/internal/store/stores"
 )
 
 func main() {
@@ -25,55 +31,74 @@ func main() {
     }
 
     ctx := context.Background()
-    mcli, err := mongostore.New(ctx, cfg.MongoURI, cfg.MongoDB)
-    if err != nil { log.Fatalf("mongo connect: %v", err) }
-    usersRepo := mongostore.NewUserRepo(mcli)
-    roomsRepo := mongostore.NewRoomRepo(mcli)
-    listsRepo := mongostore.NewListRepo(mcli)
-    itemsRepo := mongostore.NewListItemRepo(mcli)
-    _ = usersRepo.EnsureIndexes(ctx)
-    _ = roomsRepo.EnsureIndexes(ctx)
-    _ = listsRepo.EnsureIndexes(ctx)
-    _ = itemsRepo.EnsureIndexes(ctx)
-    tx := mongostore.NewTx(mcli)
-
-    var categoryIndex *mongostore.CategoryIndexRepo
-    if cfg.CategoryIndexEnabled {
-        categoryIndex = mongostore.NewCategoryIndexRepo(mcli)
-        if err := categoryIndex.EnsureIndexes(ctx); err != nil {
-            log.Printf("category_index: ensure indexes failed: %v (continuing without cache)", err)
-            categoryIndex = nil
-        } else {
-            seed := make([]mongostore.CategoryIndexEntry, 0, len(categorization.GroceryAnchors))
-            for _, a := range categorization.GroceryAnchors {
-                seed = append(seed, mongostore.CategoryIndexEntry{
-                    Key:      parse.NormalizeKey(a.Term),
-                    Category: a.Category,
-                })
-            }
-            if err := categoryIndex.Seed(ctx, seed); err != nil {
-                log.Printf("category_index: anchor seed failed: %v (continuing)", err)
-            } else {
-                log.Printf("category_index: seeded %d anchors", len(seed))
-            }
-        }
-    }
+    st, err := stores.Open(ctx, cfg)
+    if err != nil { log.Fatalf("store: %v", err) }
+    defer st.Close()
+    usersRepo, roomsRepo, listsRepo, itemsRepo, tx := st.Users, st.Rooms, st.Lists, st.Items, st.Tx
+    warnPendingMigrations(ctx, st)
 
-    userSvc := services.NewUserService(usersRepo, roomsRepo, tx)
+    authSvc, err := services.NewAuthService(usersRepo, st.Sessions, cfg.EncKeyFile, cfg.APIKeyTTLHours)
+    if err != nil { log.Fatalf("auth service: %v", err) }
+    authSvc.UseAuthCache(auth.NewCache(cfg.AuthCacheSize, time.Duration(cfg.AuthCacheTTLSeconds)*time.Second))
+    authSvc.UsePasswordHasher(passwordHasher(cfg))
+    authSvc.UseTokenLifetimes(time.Duration(cfg.AccessTokenTTLMinutes)*time.Minute, time.Duration(cfg.RefreshTokenTTLHours)*time.Hour)
+    mailer, appURL := buildMailer(cfg), strings.TrimRight(cfg.AppBaseURL, "/")
+    authSvc.UsePasswordReset(st.UserTokens, mailer, appURL+"/reset-password", time.Duration(cfg.PasswordResetTTLMinutes)*time.Minute)
+    authSvc.UseEmailVerification(mailer, appURL+"/verify-email", time.Duration(cfg.EmailVerificationTTLHours)*time.Hour, cfg.EmailVerification == "login")
+    counters := rateLimitStore(cfg, st)
+    minutes := func(n int) time.Duration { return time.Duration(n) * time.Minute }
+    authSvc.UseLoginLockout(ratelimit.New(counters, "login_user", ratelimit.Rule{Limit: cfg.LoginLockoutFailures, Window: minutes(cfg.LoginLockoutMinutes)}))
+    authSvc.UseMailLimiter(ratelimit.New(counters, "mail_user", ratelimit.Rule{Limit: cfg.MailUserLimit, Window: minutes(cfg.MailWindowMinutes)}))
+    authSvc.UseOIDC(appURL+"/oidc/callback", buildOIDCProviders(cfg)...)
+    limits := router.Limits{
+        Login:     ratelimit.New(counters, "login_ip", ratelimit.Rule{Limit: cfg.LoginIPLimit, Window: minutes(cfg.LoginIPWindowMinutes)}),
+        Join:      ratelimit.New(counters, "join_ip", ratelimit.Rule{Limit: cfg.JoinIPLimit, Window: minutes(cfg.JoinWindowMinutes)}),
+        Mail:      ratelimit.New(counters, "mail_ip", ratelimit.Rule{Limit: cfg.MailIPLimit, Window: minutes(cfg.MailWindowMinutes)}),
+        Register:  ratelimit.New(counters, "register_ip", ratelimit.Rule{Limit: cfg.RegisterIPLimit, Window: minutes(cfg.RegisterWindowMinutes)}),
+        ProxyHops: cfg.TrustedProxyHops,
+    }
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
     roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
-    roomSvc.UseListRepos(listsRepo, itemsRepo)
-    userSvc.UseListRepos(listsRepo, itemsRepo)
-    categorizers := buildCategorizers(ctx, cfg, indexArg(categoryIndex))
+    roomSvc.UseJobQueue(st.Jobs)
+    roomSvc.UseInvites(st.Invites)
+    roomSvc.UseLists(listsRepo, itemsRepo)
+    roomSvc.RequireVerifiedEmail(cfg.EmailVerification != "off")
+    roomSvc.UseJoinLimiter(ratelimit.New(counters, "join_user", ratelimit.Rule{Limit: cfg.JoinFailureLimit, Window: minutes(cfg.JoinWindowMinutes)}))
+    userSvc.UseJobQueue(st.Jobs)
+    userSvc.UseRoomService(roomSvc)
+    categorizers := buildCategorizers(ctx, cfg, st.CategoryIndex)
     listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorizers["grocery"])
-    authSvc, err := services.NewAuthService(usersRepo, cfg.EncKeyFile, cfg.APIKeyTTLHours)
-    if err != nil { log.Fatalf("auth service: %v", err) }
+    trashRetention := time.Duration(cfg.TrashRetentionDays) * 24 * time.Hour
+    listSvc.UseTrashRetention(trashRetention)
 
     userHandler := handlers.NewUserHandler(userSvc, []byte(cfg.AvatarSalt))
     authHandler := handlers.NewAuthHandler(authSvc)
     roomHandler := handlers.NewRoomHandler(roomSvc, usersRepo, []byte(cfg.AvatarSalt))
     listHandler := handlers.NewListHandler(listSvc)
 
-    r := router.NewRouter(usersRepo, authHandler, userHandler, roomHandler, listHandler)
+    pool := jobs.NewPool(st.Jobs, jobs.Options{Workers: cfg.JobWorkers, MaxAttempts: cfg.JobMaxAttempts})
+    cleanupSvc := services.NewCleanupService(listsRepo, itemsRepo)
+    cleanupSvc.UseTrashRetention(trashRetention)
+    cleanupSvc.UseCounters(st.Counters)
+    cleanupSvc.UseInvites(st.Invites)
+    pool.Handle(services.JobCleanupRoom, cleanupSvc.CleanupRoom)
+    pool.Handle(services.JobMergeRoom, roomSvc.MergeRoom)
+    pool.Handle(services.JobPurgeTrash, cleanupSvc.PurgeTrash)
+    pool.Schedule(services.JobPurgeTrash, time.Hour)
+    pool.Handle(services.JobPurgeSessions, authSvc.PurgeExpiredSessions)
+    pool.Schedule(services.JobPurgeSessions, time.Hour)
+    pool.Handle(services.JobPurgeUserTokens, authSvc.PurgeExpiredUserTokens)
+    pool.Schedule(services.JobPurgeUserTokens, time.Hour)
+    pool.Handle(services.JobPurgeCounters, cleanupSvc.PurgeCounters)
+    pool.Schedule(services.JobPurgeCounters, time.Hour)
+    workerCtx, stopWorkers := context.WithCancel(ctx)
+    workersDone := make(chan struct{})
+    go func() {
+        pool.Run(workerCtx)
+        close(workersDone)
+    }()
+
+    r := router.NewRouter(authSvc, limits, authHandler, userHandler, roomHandler, listHandler)
 
     srv := &http.Server{
         Addr:         ":" + cfg.Port,
@@ -98,6 +123,9 @@ func main() {
     ctxTimeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
     _ = srv.Shutdown(ctxTimeout)
+    // In-flight jobs finish; unstarted ones stay queued for the next start.
+    stopWorkers()
+    <-workersDone
 }
 
 // buildEmbedder loads the shared embedding model once, or returns nil when
@@ -139,16 +167,6 @@ func domainCategorizer(ctx context.Context, emb categorization.Embedder, anchors
 	return categorization.NewChain(fallback, embMember, keyword)
 }
 
-// indexArg converts a possibly-nil concrete repo pointer into a clean typed nil
-// interface, so domainCategorizer's `index != nil` check works correctly.
-// A non-nil interface wrapping a nil pointer would always pass the != nil check.
-func indexArg(r *mongostore.CategoryIndexRepo) categorization.CategoryIndex {
-	if r == nil {
-		return nil
-	}
-	return r
-}
-
 // buildCategorizers returns the per-domain registry. Add a new domain (e.g.
 // list-type suggestion) by adding one line with its anchor set + fallback;
 // the embedding model is shared, not reloaded.
@@ -159,3 +177,55 @@ func buildCategorizers(ctx context.Context, cfg *config.Config, index categoriza
 		// Future: "list_type": domainCategorizer(ctx, emb, categorization.ListTypeAnchors, "", cfg, index),
 	}
 }
+
+// buildMailer returns the mailer selected by MAILER.
+func buildMailer(cfg *config.Config) mail.Mailer {
+    if cfg.Mailer == "smtp" {
+        log.Printf("mail: sending through %s:%d", cfg.SMTPHost, cfg.SMTPPort)
+        return mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+    }
+    if cfg.MailDir != "" {
+        log.Printf("mail: writing messages to %s", cfg.MailDir)
+    } else {
+        log.Printf("mail: logging messages (MAILER=log)")
+    }
+    return mail.NewLogMailer(cfg.MailDir, cfg.MailFrom)
+}
+
+// buildOIDCProviders returns the single sign-on providers in OIDC_PROVIDERS.
+func buildOIDCProviders(cfg *config.Config) []*oidc.Provider {
+    var out []*oidc.Provider
+    for _, p := range cfg.OIDCProviders {
+        log.Printf("oidc: sign-in with %s (%s)", p.ID, p.Issuer)
+        out = append(out, oidc.New(oidc.Config{ID: p.ID, Name: p.Name, Issuer: p.Issuer, ClientID: p.ClientID, ClientSecret: p.ClientSecret, Scopes: p.Scopes}, nil))
+    }
+    return out
+}
+
+// passwordHasher returns the hasher for new passwords selected by PASSWORD_HASH.
+func passwordHasher(cfg *config.Config) auth.PasswordHasher {
+    if cfg.PasswordHash == "bcrypt" { return auth.NewBcrypt(cfg.BcryptCost) }
+    return auth.NewArgon2id(auth.Argon2Params{Memory: uint32(cfg.Argon2MemoryKiB), Time: uint32(cfg.Argon2Time), Parallelism: uint8(cfg.Argon2Parallelism)})
+}
+
+// rateLimitStore returns where rate limit counters live, per RATE_LIMIT_STORE.
+func rateLimitStore(cfg *config.Config, st *stores.Set) ratelimit.Store {
+    if cfg.RateLimitStore == "shared" {
+        log.Printf("ratelimit: counting in the %s store", cfg.DataStore)
+        return st.Counters
+    }
+    return ratelimit.NewMemoryStore()
+}
+
+// warnPendingMigrations logs data migrations that gracie-migrate has not applied yet.
+func warnPendingMigrations(ctx context.Context, st *stores.Set) {
+    runner := migrate.NewRunner(st.Migrations, migrate.Env{Tx: st.Tx, Items: st.Items, ItemScanner: st.ItemScanner, Users: st.Users, UserScanner: st.UserScanner}, migrate.All)
+    pending, err := runner.Pending(ctx)
+    if err != nil {
+        log.Printf("migrations: cannot read applied versions: %v", err)
+        return
+    }
+    for _, m := range pending {
+        log.Printf("migrations: %04d_%s not applied; run gracie-migrate up", m.Version, m.Name)
+    }
+}
@@ -6,6 +6,7 @@ import (
     "log"
     "time"
 
+    "github.com/aws/aws-sdk-go-v2/aws"
     "github.com/aws/aws-sdk-go-v2/service/dynamodb"
     "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
     "github.com/janvillarosa/gracie-app/backend/internal/config"
@@ -15,6 +16,7 @@ import (
 const apiKeyLookupIndex = "api_key_lookup_index"
 const shareTokenIndex = "share_token_index"
 const usernameIndex = "username_index"
+const jobStatusIndex = "status_index"
 
 func main() {
     ctx := context.Background()
@@ -24,7 +26,7 @@ func main() {
         log.Fatalf("config: %v", err)
     }
 
-    client, err := dynamo.New(ctx, cfg.AWSRegion, cfg.DDBEndpoint, dynamo.Tables{Users: cfg.UsersTable, Rooms: cfg.RoomsTable, Lists: cfg.ListsTable, ListItems: cfg.ListItemsTable})
+    client, err := dynamo.New(ctx, cfg.AWSRegion, cfg.DDBEndpoint, dynamo.Tables{Users: cfg.UsersTable, Rooms: cfg.RoomsTable, Lists: cfg.ListsTable, ListItems: cfg.ListItemsTable, Migrations: cfg.MigrationsTable, Jobs: cfg.JobsTable, Sessions: cfg.SessionsTable, UserTokens: cfg.UserTokensTable, Counters: cfg.CountersTable, Invites: cfg.InvitesTable})
     if err != nil {
         log.Fatalf("dynamo client: %v", err)
     }
@@ -41,6 +43,24 @@ func main() {
     if err := ensureListItemsTable(ctx, client.DB, cfg.ListItemsTable); err != nil {
         log.Fatalf("ensure list items table: %v", err)
     }
+    if err := ensureMigrationsTable(ctx, client.DB, cfg.MigrationsTable); err != nil {
+        log.Fatalf("ensure migrations table: %v", err)
+    }
+    if err := ensureJobsTable(ctx, client.DB, cfg.JobsTable); err != nil {
+        log.Fatalf("ensure jobs table: %v", err)
+    }
+    if err := ensureSessionsTable(ctx, client.DB, cfg.SessionsTable); err != nil {
+        log.Fatalf("ensure sessions table: %v", err)
+    }
+    if err := ensureUserTokensTable(ctx, client.DB, cfg.UserTokensTable); err != nil {
+        log.Fatalf("ensure user tokens table: %v", err)
+    }
+    if err := ensureCountersTable(ctx, client.DB, cfg.CountersTable); err != nil {
+        log.Fatalf("ensure counters table: %v", err)
+    }
+    if err := ensureInvitesTable(ctx, client.DB, cfg.InvitesTable); err != nil {
+        log.Fatalf("ensure invites table: %v", err)
+    }
     log.Println("DynamoDB tables are ready ✅")
 }
 
@@ -249,26 +269,29 @@ func ensureListsTable(ctx context.Context, db *dynamodb.Client, table string) er
     return nil
 }
 
-// ListItems table: PK item_id, GSI on list_id
+// ListItems table: PK item_id, GSIs on list_id and room_id
 func ensureListItemsTable(ctx context.Context, db *dynamodb.Client, table string) error {
-    listIndex := "list_id_index"
+    // index name -> hash key attribute
+    indexes := []struct{ name, attr string }{
+        {"list_id_index", "list_id"},
+        {"room_id_index", "room_id"},
+    }
     out, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
     if err == nil {
-        // Ensure GSI exists
-        hasIndex := false
-        if out.Table.GlobalSecondaryIndexes != nil {
+        // Ensure GSIs exist; DynamoDB accepts one GSI creation per UpdateTable call.
+        for _, ix := range indexes {
+            hasIndex := false
             for _, g := range out.Table.GlobalSecondaryIndexes {
-                if g.IndexName != nil && *g.IndexName == listIndex { hasIndex = true }
+                if g.IndexName != nil && *g.IndexName == ix.name { hasIndex = true }
             }
-        }
-        if !hasIndex {
-            log.Printf("adding GSI %s to %s...", listIndex, table)
+            if hasIndex { continue }
+            log.Printf("adding GSI %s to %s...", ix.name, table)
             _, err := db.UpdateTable(ctx, &dynamodb.UpdateTableInput{
                 TableName:            &table,
-                AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr("list_id"), AttributeType: types.ScalarAttributeTypeS}},
+                AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr(ix.attr), AttributeType: types.ScalarAttributeTypeS}},
                 GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{Create: &types.CreateGlobalSecondaryIndexAction{
-                    IndexName: strPtr(listIndex),
-                    KeySchema: []types.KeySchemaElement{{AttributeName: strPtr("list_id"), KeyType: types.KeyTypeHash}},
+                    IndexName: strPtr(ix.name),
+                    KeySchema: []types.KeySchemaElement{{AttributeName: strPtr(ix.attr), KeyType: types.KeyTypeHash}},
                     Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
                 }}}})
             if err != nil { return err }
@@ -283,14 +306,73 @@ func ensureListItemsTable(ctx context.Context, db *dynamodb.Client, table string
         AttributeDefinitions: []types.AttributeDefinition{
             {AttributeName: strPtr("item_id"), AttributeType: types.ScalarAttributeTypeS},
             {AttributeName: strPtr("list_id"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("room_id"), AttributeType: types.ScalarAttributeTypeS},
         },
         KeySchema:  []types.KeySchemaElement{{AttributeName: strPtr("item_id"), KeyType: types.KeyTypeHash}},
         BillingMode: types.BillingModePayPerRequest,
+        GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+            {
+                IndexName: strPtr(indexes[0].name),
+                KeySchema: []types.KeySchemaElement{{AttributeName: strPtr(indexes[0].attr), KeyType: types.KeyTypeHash}},
+                Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+            },
+            {
+                IndexName: strPtr(indexes[1].name),
+                KeySchema: []types.KeySchemaElement{{AttributeName: strPtr(indexes[1].attr), KeyType: types.KeyTypeHash}},
+                Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+            },
+        },
+    })
+    if err != nil { return err }
+    waiter := dynamodb.NewTableExistsWaiter(db)
+    if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: &table}, 30*time.Second); err != nil { return err }
+    log.Printf("created table %s", table)
+    return nil
+}
+
+// Migrations table: PK version (number), written by cmd/gracie-migrate
+func ensureMigrationsTable(ctx context.Context, db *dynamodb.Client, table string) error {
+    _, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
+    if err == nil {
+        log.Printf("table %s exists", table)
+        return nil
+    }
+    if !isNotFound(err) { return err }
+    log.Printf("creating table %s...", table)
+    _, err = db.CreateTable(ctx, &dynamodb.CreateTableInput{
+        TableName:            &table,
+        AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr("version"), AttributeType: types.ScalarAttributeTypeN}},
+        KeySchema:            []types.KeySchemaElement{{AttributeName: strPtr("version"), KeyType: types.KeyTypeHash}},
+        BillingMode:          types.BillingModePayPerRequest,
+    })
+    if err != nil { return err }
+    waiter := dynamodb.NewTableExistsWaiter(db)
+    if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: &table}, 30*time.Second); err != nil { return err }
+    log.Printf("created table %s", table)
+    return nil
+}
+
+func ensureJobsTable(ctx context.Context, db *dynamodb.Client, table string) error {
+    _, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
+    if err == nil {
+        log.Printf("table %s exists", table)
+        return nil
+    }
+    if !isNotFound(err) { return err }
+    log.Printf("creating table %s...", table)
+    _, err = db.CreateTable(ctx, &dynamodb.CreateTableInput{
+        TableName: &table,
+        AttributeDefinitions: []types.AttributeDefinition{
+            {AttributeName: strPtr("job_id"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("status"), AttributeType: types.ScalarAttributeTypeS},
+        },
+        KeySchema: []types.KeySchemaElement{{AttributeName: strPtr("job_id"), KeyType: types.KeyTypeHash}},
         GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
-            IndexName: strPtr(listIndex),
-            KeySchema: []types.KeySchemaElement{{AttributeName: strPtr("list_id"), KeyType: types.KeyTypeHash}},
+            IndexName:  strPtr(jobStatusIndex),
+            KeySchema:  []types.KeySchemaElement{{AttributeName: strPtr("status"), KeyType: types.KeyTypeHash}},
             Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
         }},
+        BillingMode: types.BillingModePayPerRequest,
     })
     if err != nil { return err }
     waiter := dynamodb.NewTableExistsWaiter(db)
@@ -298,3 +380,137 @@ func ensureListItemsTable(ctx context.Context, db *dynamodb.Client, table string
     log.Printf("created table %s", table)
     return nil
 }
+
+// Sessions table: PK session_id, GSIs on key_lookup and user_id
+func ensureSessionsTable(ctx context.Context, db *dynamodb.Client, table string) error {
+    _, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
+    if err == nil {
+        log.Printf("table %s exists", table)
+        return nil
+    }
+    if !isNotFound(err) { return err }
+    log.Printf("creating table %s...", table)
+    gsi := func(name, attr string) types.GlobalSecondaryIndex {
+        return types.GlobalSecondaryIndex{
+            IndexName:  strPtr(name),
+            KeySchema:  []types.KeySchemaElement{{AttributeName: strPtr(attr), KeyType: types.KeyTypeHash}},
+            Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+        }
+    }
+    _, err = db.CreateTable(ctx, &dynamodb.CreateTableInput{
+        TableName: &table,
+        AttributeDefinitions: []types.AttributeDefinition{
+            {AttributeName: strPtr("session_id"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("key_lookup"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("user_id"), AttributeType: types.ScalarAttributeTypeS},
+        },
+        KeySchema:              []types.KeySchemaElement{{AttributeName: strPtr("session_id"), KeyType: types.KeyTypeHash}},
+        GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{gsi("key_lookup_index", "key_lookup"), gsi("user_id_index", "user_id")},
+        BillingMode:            types.BillingModePayPerRequest,
+    })
+    if err != nil { return err }
+    waiter := dynamodb.NewTableExistsWaiter(db)
+    if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: &table}, 30*time.Second); err != nil { return err }
+    log.Printf("created table %s", table)
+    return nil
+}
+
+// UserTokens table: PK token_hash, GSI on user_id
+func ensureUserTokensTable(ctx context.Context, db *dynamodb.Client, table string) error {
+    _, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
+    if err == nil {
+        log.Printf("table %s exists", table)
+        return nil
+    }
+    if !isNotFound(err) { return err }
+    log.Printf("creating table %s...", table)
+    _, err = db.CreateTable(ctx, &dynamodb.CreateTableInput{
+        TableName: &table,
+        AttributeDefinitions: []types.AttributeDefinition{
+            {AttributeName: strPtr("token_hash"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("user_id"), AttributeType: types.ScalarAttributeTypeS},
+        },
+        KeySchema: []types.KeySchemaElement{{AttributeName: strPtr("token_hash"), KeyType: types.KeyTypeHash}},
+        GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+            IndexName:  strPtr("user_id_index"),
+            KeySchema:  []types.KeySchemaElement{{AttributeName: strPtr("user_id"), KeyType: types.KeyTypeHash}},
+            Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+        }},
+        BillingMode: types.BillingModePayPerRequest,
+    })
+    if err != nil { return err }
+    waiter := dynamodb.NewTableExistsWaiter(db)
+    if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: &table}, 30*time.Second); err != nil { return err }
+    log.Printf("created table %s", table)
+    return nil
+}
+
+// Invites table: PK invite_id, GSIs on code, link_token and room_id
+func ensureInvitesTable(ctx context.Context, db *dynamodb.Client, table string) error {
+    _, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
+    if err == nil {
+        log.Printf("table %s exists", table)
+        return nil
+    }
+    if !isNotFound(err) { return err }
+    log.Printf("creating table %s...", table)
+    gsi := func(name, attr string) types.GlobalSecondaryIndex {
+        return types.GlobalSecondaryIndex{
+            IndexName:  strPtr(name),
+            KeySchema:  []types.KeySchemaElement{{AttributeName: strPtr(attr), KeyType: types.KeyTypeHash}},
+            Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+        }
+    }
+    _, err = db.CreateTable(ctx, &dynamodb.CreateTableInput{
+        TableName: &table,
+        AttributeDefinitions: []types.AttributeDefinition{
+            {AttributeName: strPtr("invite_id"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("code"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("link_token"), AttributeType: types.ScalarAttributeTypeS},
+            {AttributeName: strPtr("room_id"), AttributeType: types.ScalarAttributeTypeS},
+        },
+        KeySchema:              []types.KeySchemaElement{{AttributeName: strPtr("invite_id"), KeyType: types.KeyTypeHash}},
+        GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{gsi("code_index", "code"), gsi("link_token_index", "link_token"), gsi("room_id_index", "room_id")},
+        BillingMode:            types.BillingModePayPerRequest,
+    })
+    if err != nil { return err }
+    waiter := dynamodb.NewTableExistsWaiter(db)
+    if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: &table}, 30*time.Second); err != nil { return err }
+    log.Printf("created table %s", table)
+    return nil
+}
+
+// Counters table: PK counter_key, TTL on expires_at (epoch seconds)
+func ensureCountersTable(ctx context.Context, db *dynamodb.Client, table string) error {
+    _, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
+    if err == nil {
+        log.Printf("table %s exists", table)
+        return ensureCountersTTL(ctx, db, table)
+    }
+    if !isNotFound(err) { return err }
+    log.Printf("creating table %s...", table)
+    _, err = db.CreateTable(ctx, &dynamodb.CreateTableInput{
+        TableName:            &table,
+        AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr("counter_key"), AttributeType: types.ScalarAttributeTypeS}},
+        KeySchema:            []types.KeySchemaElement{{AttributeName: strPtr("counter_key"), KeyType: types.KeyTypeHash}},
+        BillingMode:          types.BillingModePayPerRequest,
+    })
+    if err != nil { return err }
+    waiter := dynamodb.NewTableExistsWaiter(db)
+    if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: &table}, 30*time.Second); err != nil { return err }
+    log.Printf("created table %s", table)
+    return ensureCountersTTL(ctx, db, table)
+}
+
+func ensureCountersTTL(ctx context.Context, db *dynamodb.Client, table string) error {
+    out, err := db.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: &table})
+    if err != nil { return err }
+    if d := out.TimeToLiveDescription; d != nil && d.TimeToLiveStatus != types.TimeToLiveStatusDisabled { return nil }
+    _, err = db.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+        TableName:               &table,
+        TimeToLiveSpecification: &types.TimeToLiveSpecification{AttributeName: strPtr("expires_at"), Enabled: aws.Bool(true)},
+    })
+    if err != nil { return err }
+    log.Printf("enabled TTL on %s.expires_at", table)
+    return nil
+}
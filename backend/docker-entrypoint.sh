@@ -12,19 +12,27 @@ fi
 attempts=0
 max_attempts=${SETUP_RETRIES:-30}
 
-if [ "${DATA_STORE}" != "mongo" ]; then
-  echo "[entrypoint] Running setup-ddb (up to $max_attempts attempts)"
-  until /usr/local/bin/setup-ddb; do
-    attempts=$((attempts+1))
-    if [ "$attempts" -ge "$max_attempts" ]; then
-      echo "[entrypoint] setup-ddb failed after $attempts attempts; continuing to start server"
-      break
-    fi
-    echo "[entrypoint] setup-ddb failed (attempt $attempts). Retrying in 2s..."
-    sleep 2
-  done
-else
-  echo "[entrypoint] DATA_STORE=mongo → skipping setup-ddb"
+case "${DATA_STORE}" in
+  mongo|sqlite|postgres)
+    echo "[entrypoint] DATA_STORE=${DATA_STORE} → skipping setup-ddb"
+    ;;
+  *)
+    echo "[entrypoint] Running setup-ddb (up to $max_attempts attempts)"
+    until /usr/local/bin/setup-ddb; do
+      attempts=$((attempts+1))
+      if [ "$attempts" -ge "$max_attempts" ]; then
+        echo "[entrypoint] setup-ddb failed after $attempts attempts; continuing to start server"
+        break
+      fi
+      echo "[entrypoint] setup-ddb failed (attempt $attempts). Retrying in 2s..."
+      sleep 2
+    done
+    ;;
+esac
+
+echo "[entrypoint] Applying data migrations"
+if ! /usr/local/bin/gracie-migrate up; then
+  echo "[entrypoint] gracie-migrate failed; continuing to start server"
 fi
 
 echo "[entrypoint] Starting gracie-server on port ${PORT:-8080}"
@@ -0,0 +1,71 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "strings"
+)
+
+// hmacPrefix marks API key hashes made by APIKeys; anything else stored in
+// APIKeyHash is a legacy bcrypt hash. The ID of the server key follows, then
+// "$" and the HMAC; under LegacyKeyID, the HMAC follows right away.
+const hmacPrefix = "hmac-sha256$"
+
+// APIKeys issues and verifies API keys. Keys are 256 random bits, so a keyed
+// HMAC is as strong as a slow hash and costs microseconds per request. The
+// HMAC key is derived from the server's encryption keys, so a leaked database
+// alone does not let anyone check guesses.
+type APIKeys struct {
+    keys derivedKeys
+}
+
+// NewAPIKeys derives the HMAC keys from the server's encryption keys.
+func NewAPIKeys(keys ServerKeys) *APIKeys {
+    return &APIKeys{keys: deriveKeys(keys, "gracie api key v1")}
+}
+
+// Generate returns a new key and its hash for APIKeyHash.
+func (k *APIKeys) Generate() (plain string, hash string) {
+    plain = randomToken()
+    return plain, k.Hash(plain)
+}
+
+// Hash returns the stored form of plain, under the primary key.
+func (k *APIKeys) Hash(plain string) string {
+    h, _ := k.hashWith(k.keys.primary, plain)
+    return h
+}
+
+// Hashes returns the stored form of plain under every key, primary first, to
+// look up values stored by hash before a rotation.
+func (k *APIKeys) Hashes(plain string) []string {
+    ids := k.keys.ids()
+    out := make([]string, 0, len(ids))
+    for _, id := range ids {
+        if h, ok := k.hashWith(id, plain); ok { out = append(out, h) }
+    }
+    return out
+}
+
+func (k *APIKeys) hashWith(id, plain string) (string, bool) {
+    secret, ok := k.keys.get(id)
+    if !ok { return "", false }
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(plain))
+    if id == LegacyKeyID { return hmacPrefix + hex.EncodeToString(mac.Sum(nil)), true }
+    return hmacPrefix + id + "$" + hex.EncodeToString(mac.Sum(nil)), true
+}
+
+// Verify reports whether plain matches hash. legacy is set when hash is a
+// bcrypt hash from before HMAC keys; callers should replace it with Hash(plain)
+// once it has verified. A hash under a retired key does not verify.
+func (k *APIKeys) Verify(hash string, plain string) (ok bool, legacy bool) {
+    if !strings.HasPrefix(hash, hmacPrefix) {
+        return VerifyAPIKey(hash, plain), true
+    }
+    id, _, labelled := strings.Cut(strings.TrimPrefix(hash, hmacPrefix), "$")
+    if !labelled { id = LegacyKeyID }
+    want, found := k.hashWith(id, plain)
+    return found && constantTimeEqual(hash, want), false
+}
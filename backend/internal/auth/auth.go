@@ -14,16 +14,8 @@ const (
     bearerPrefix = "Bearer "
 )
 
-func GenerateAPIKey() (plain string, hash string, err error) {
-    // Use 32-byte random token base64url
-    plain = randomToken()
-    hashed, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
-    if err != nil {
-        return "", "", err
-    }
-    return plain, string(hashed), nil
-}
-
+// VerifyAPIKey checks plain against a legacy bcrypt API key hash. New keys are
+// HMAC-hashed; see APIKeys.
 func VerifyAPIKey(hash string, plain string) bool {
     // Constant-time compare via bcrypt
     if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)); err != nil {
@@ -2,7 +2,11 @@ package auth
 
 import (
     "net/http"
+    "strings"
     "testing"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
 )
 
 func TestDeriveLookup(t *testing.T) {
@@ -13,16 +17,168 @@ func TestDeriveLookup(t *testing.T) {
 }
 
 func TestGenerateAndVerify(t *testing.T) {
-    plain, hash, err := GenerateAPIKey()
-    if err != nil {
-        t.Fatalf("generate: %v", err)
+    keys := NewAPIKeys(SingleKey([]byte("enc-key")))
+    plain, hash := keys.Generate()
+    if plain == "" || !strings.HasPrefix(hash, hmacPrefix) {
+        t.Fatalf("unexpected key %q hash %q", plain, hash)
+    }
+    if ok, legacy := keys.Verify(hash, plain); !ok || legacy {
+        t.Fatalf("verify failed: ok=%v legacy=%v", ok, legacy)
+    }
+    if ok, _ := keys.Verify(hash, plain+"x"); ok {
+        t.Fatalf("wrong key verified")
+    }
+    if ok, _ := NewAPIKeys(SingleKey([]byte("other-key"))).Verify(hash, plain); ok {
+        t.Fatalf("key verified under a different secret")
+    }
+}
+
+func TestVerifyLegacyBcrypt(t *testing.T) {
+    keys := NewAPIKeys(SingleKey([]byte("enc-key")))
+    b, _ := bcrypt.GenerateFromPassword([]byte("old-key"), bcrypt.MinCost)
+    if ok, legacy := keys.Verify(string(b), "old-key"); !ok || !legacy {
+        t.Fatalf("legacy verify: ok=%v legacy=%v", ok, legacy)
+    }
+    if ok, legacy := keys.Verify(string(b), "nope"); ok || !legacy {
+        t.Fatalf("legacy wrong key: ok=%v legacy=%v", ok, legacy)
+    }
+}
+
+func TestSessionTokens(t *testing.T) {
+    tokens := NewSessionTokens(SingleKey([]byte("enc-key")))
+    now := time.Unix(1700000000, 0)
+
+    access := tokens.Access("ses_1", now.Add(time.Minute))
+    if id, ok := tokens.ParseAccess(access, now); !ok || id != "ses_1" {
+        t.Fatalf("parse access: %q %v", id, ok)
+    }
+    if _, ok := tokens.ParseAccess(access, now.Add(time.Minute)); ok {
+        t.Fatalf("expired access token accepted")
+    }
+    refresh := tokens.Refresh("ses_1", 3)
+    if id, gen, ok := tokens.ParseRefresh(refresh); !ok || id != "ses_1" || gen != 3 {
+        t.Fatalf("parse refresh: %q %d %v", id, gen, ok)
+    }
+    // Kinds do not substitute for each other, and edits break the signature.
+    if _, ok := tokens.ParseAccess(refresh, now); ok {
+        t.Fatalf("refresh token accepted as access token")
+    }
+    if _, _, ok := tokens.ParseRefresh(strings.Replace(refresh, ".3.", ".4.", 1)); ok {
+        t.Fatalf("tampered refresh token accepted")
+    }
+    if _, _, ok := NewSessionTokens(SingleKey([]byte("other-key"))).ParseRefresh(refresh); ok {
+        t.Fatalf("token accepted under a different secret")
+    }
+    challenge := tokens.Challenge("usr_1", now.Add(time.Minute))
+    if id, ok := tokens.ParseChallenge(challenge, now); !ok || id != "usr_1" {
+        t.Fatalf("parse challenge: %q %v", id, ok)
+    }
+    if _, ok := tokens.ParseAccess(challenge, now); ok {
+        t.Fatalf("challenge token accepted as access token")
+    }
+}
+
+func TestTOTP(t *testing.T) {
+    // RFC 6238 appendix B, SHA-1, truncated to six digits.
+    secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+    for _, tc := range []struct{ unix int64; code string }{
+        {59, "287082"}, {1111111109, "081804"}, {1234567890, "005924"}, {20000000000, "353130"},
+    } {
+        got, err := TOTPCode(secret, TOTPStep(time.Unix(tc.unix, 0)))
+        if err != nil || got != tc.code { t.Fatalf("code at %d = %q %v, want %s", tc.unix, got, err, tc.code) }
+    }
+    now := time.Unix(1111111109, 0)
+    prev, _ := TOTPCode(secret, TOTPStep(now)-1)
+    if step, ok := MatchTOTP(secret, prev, now); !ok || step != TOTPStep(now)-1 { t.Fatalf("previous step rejected: %d %v", step, ok) }
+    old, _ := TOTPCode(secret, TOTPStep(now)-2)
+    if _, ok := MatchTOTP(secret, old, now); ok { t.Fatalf("code two steps old accepted") }
+    if _, ok := MatchTOTP(secret, "08180", now); ok { t.Fatalf("short code accepted") }
+
+    uri := TOTPURI("Gracie", "a@b.com", "ABC")
+    if !strings.HasPrefix(uri, "otpauth://totp/Gracie:a@b.com?") || !strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=Gracie") {
+        t.Fatalf("uri = %s", uri)
+    }
+    code := NewRecoveryCode()
+    if len(code) != 11 || NormalizeRecoveryCode(" "+strings.ToUpper(code)+" ") != strings.ReplaceAll(code, "-", "") {
+        t.Fatalf("recovery code %q", code)
+    }
+}
+
+func TestEmailTokens(t *testing.T) {
+    tokens := NewEmailTokens(SingleKey([]byte("enc-key")))
+    now := time.Unix(1700000000, 0)
+
+    tok := tokens.Sign("usr_1", "a@b.com", now.Add(time.Hour))
+    if id, ok := tokens.UserID(tok); !ok || id != "usr_1" {
+        t.Fatalf("user id: %q %v", id, ok)
     }
-    if plain == "" || hash == "" {
-        t.Fatalf("expected non-empty key and hash")
+    if !tokens.Valid(tok, "A@B.com", now) {
+        t.Fatalf("valid token rejected")
     }
-    if !VerifyAPIKey(hash, plain) {
-        t.Fatalf("verify failed")
+    // A link is void once the address changes or it expires.
+    if tokens.Valid(tok, "c@d.com", now) {
+        t.Fatalf("token accepted for another address")
     }
+    if tokens.Valid(tok, "a@b.com", now.Add(time.Hour)) {
+        t.Fatalf("expired token accepted")
+    }
+    if tokens.Valid(strings.Replace(tok, "usr_1", "usr_2", 1), "a@b.com", now) {
+        t.Fatalf("tampered token accepted")
+    }
+}
+
+func TestKeyRotation(t *testing.T) {
+    old := SingleKey([]byte("enc-key"))
+    both := ServerKeys{ByID: map[string][]byte{LegacyKeyID: []byte("enc-key"), "k1": []byte("new-key")}, Primary: "k1"}
+    rotated := ServerKeys{ByID: map[string][]byte{"k1": []byte("new-key")}, Primary: "k1"}
+    now := time.Unix(1700000000, 0)
+
+    // Hashes and tokens from before the rotation verify until k0 is retired.
+    plain, hash := NewAPIKeys(old).Generate()
+    refresh := NewSessionTokens(old).Refresh("ses_1", 1)
+    link := NewEmailTokens(old).Sign("usr_1", "a@b.com", now.Add(time.Hour))
+    if ok, _ := NewAPIKeys(both).Verify(hash, plain); !ok { t.Fatalf("k0 hash rejected") }
+    if _, _, ok := NewSessionTokens(both).ParseRefresh(refresh); !ok { t.Fatalf("k0 token rejected") }
+    if !NewEmailTokens(both).Valid(link, "a@b.com", now) { t.Fatalf("k0 link rejected") }
+    if ok, _ := NewAPIKeys(rotated).Verify(hash, plain); ok { t.Fatalf("hash of retired key accepted") }
+    if _, _, ok := NewSessionTokens(rotated).ParseRefresh(refresh); ok { t.Fatalf("token of retired key accepted") }
+    if NewEmailTokens(rotated).Valid(link, "a@b.com", now) { t.Fatalf("link of retired key accepted") }
+
+    // New ones name the primary key and outlive k0.
+    keys := NewAPIKeys(both)
+    plain, hash = keys.Generate()
+    if !strings.HasPrefix(hash, hmacPrefix+"k1$") { t.Fatalf("hash %q", hash) }
+    if ok, _ := NewAPIKeys(rotated).Verify(hash, plain); !ok { t.Fatalf("k1 hash rejected") }
+    if hs := keys.Hashes("x"); len(hs) != 2 || hs[0] != keys.Hash("x") || hs[1] != NewAPIKeys(old).Hash("x") { t.Fatalf("hashes: %v", hs) }
+    refresh = NewSessionTokens(both).Refresh("ses_1", 2)
+    if _, _, ok := NewSessionTokens(rotated).ParseRefresh(refresh); !ok { t.Fatalf("k1 token rejected") }
+    if _, _, ok := NewSessionTokens(old).ParseRefresh(refresh); ok { t.Fatalf("k1 token accepted without k1") }
+}
+
+func TestCache(t *testing.T) {
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    c := NewCache(2, time.Minute)
+    c.now = func() time.Time { return now }
+
+    c.Put("a", "usr_a", "ha")
+    c.Put("b", "usr_b", "hb")
+    if id, h, ok := c.Get("a"); !ok || id != "usr_a" || h != "ha" { t.Fatalf("get a: %v %v %v", id, h, ok) }
+    // a was used last, so b is evicted.
+    c.Put("c", "usr_c", "hc")
+    if _, _, ok := c.Get("b"); ok { t.Fatalf("b should be evicted") }
+    if c.Len() != 2 { t.Fatalf("len %d", c.Len()) }
+
+    c.Forget("a")
+    if _, _, ok := c.Get("a"); ok { t.Fatalf("a should be forgotten") }
+
+    now = now.Add(2 * time.Minute)
+    if _, _, ok := c.Get("c"); ok { t.Fatalf("c should have expired") }
+    if c.Len() != 0 { t.Fatalf("len %d", c.Len()) }
+
+    var nilCache *Cache
+    nilCache.Put("a", "usr_a", "ha")
+    nilCache.Forget("a")
+    if _, _, ok := nilCache.Get("a"); ok || nilCache.Len() != 0 { t.Fatalf("nil cache should be empty") }
 }
 
 func TestExtractBearer(t *testing.T) {
@@ -36,3 +192,24 @@ func TestExtractBearer(t *testing.T) {
     if tok, ok := ExtractBearer(req("Bearer   ")); ok || tok != "" { t.Fatalf("expected empty token not ok") }
     if tok, ok := ExtractBearer(req("Bearer real")); !ok || tok != "real" { t.Fatalf("unexpected: %v %v", tok, ok) }
 }
+
+func TestPasswordHashers(t *testing.T) {
+    weak := NewArgon2id(Argon2Params{Memory: 64, Time: 1, Parallelism: 1})
+    strong := NewArgon2id(Argon2Params{Memory: 128, Time: 2, Parallelism: 1})
+    h, err := weak.Hash("hunter22")
+    if err != nil || !strings.HasPrefix(h, "$argon2id$v=19$m=64,t=1,p=1$") { t.Fatalf("argon2id hash: %v %q", err, h) }
+    if !VerifyPassword(h, "hunter22") || VerifyPassword(h, "hunter23") { t.Fatal("argon2id verify") }
+    if weak.NeedsRehash(h) || !strong.NeedsRehash(h) { t.Fatal("argon2id rehash by parameters") }
+
+    b, _ := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+    if !VerifyPassword(string(b), "hunter22") || VerifyPassword(string(b), "nope") { t.Fatal("bcrypt verify") }
+    if !strong.NeedsRehash(string(b)) { t.Fatal("bcrypt hash should be upgraded to argon2id") }
+    bc := NewBcrypt(bcrypt.MinCost + 1)
+    if !bc.NeedsRehash(string(b)) || !bc.NeedsRehash(h) { t.Fatal("bcrypt rehash by cost or algorithm") }
+    if hb, _ := bc.Hash("hunter22"); bc.NeedsRehash(hb) || !VerifyPassword(hb, "hunter22") { t.Fatal("bcrypt round trip") }
+
+    for _, bad := range []string{"", "plain", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$aGFzaA", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$"} {
+        if VerifyPassword(bad, "") { t.Fatalf("%q verified", bad) }
+        if !strong.NeedsRehash(bad) { t.Fatalf("%q should need a rehash", bad) }
+    }
+}
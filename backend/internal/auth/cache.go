@@ -0,0 +1,90 @@
+package auth
+
+import (
+    "container/list"
+    "sync"
+    "time"
+)
+
+// Cache remembers API keys that have verified, keyed by their lookup, so
+// repeat requests skip the index query and hash check. It holds the ID of the
+// record that owns the key and the stored hash the key matched: callers
+// reload the record and accept the entry only while the hash is unchanged, so
+// revoking a key takes effect at once on every server. Entries expire after a
+// TTL and the least recently used are evicted beyond a size bound.
+//
+// A nil *Cache caches nothing.
+type Cache struct {
+    mu      sync.Mutex
+    max     int
+    ttl     time.Duration
+    order   *list.List // front is most recently used
+    entries map[string]*list.Element
+    now     func() time.Time
+}
+
+type cacheEntry struct {
+    lookup  string
+    id      string
+    hash    string
+    expires time.Time
+}
+
+// NewCache returns a cache of at most max keys, each kept for ttl.
+func NewCache(max int, ttl time.Duration) *Cache {
+    return &Cache{max: max, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}, now: time.Now}
+}
+
+// Get returns the owner ID and hash cached for lookup.
+func (c *Cache) Get(lookup string) (id string, hash string, ok bool) {
+    if c == nil { return "", "", false }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    el, ok := c.entries[lookup]
+    if !ok { return "", "", false }
+    e := el.Value.(*cacheEntry)
+    if c.now().After(e.expires) {
+        c.remove(el)
+        return "", "", false
+    }
+    c.order.MoveToFront(el)
+    return e.id, e.hash, true
+}
+
+// Put caches a verified key, evicting the least recently used beyond the bound.
+func (c *Cache) Put(lookup, id, hash string) {
+    if c == nil || c.max <= 0 { return }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    e := &cacheEntry{lookup: lookup, id: id, hash: hash, expires: c.now().Add(c.ttl)}
+    if el, ok := c.entries[lookup]; ok {
+        el.Value = e
+        c.order.MoveToFront(el)
+        return
+    }
+    c.entries[lookup] = c.order.PushFront(e)
+    for c.order.Len() > c.max {
+        c.remove(c.order.Back())
+    }
+}
+
+// Forget drops lookup, e.g. when its key is revoked.
+func (c *Cache) Forget(lookup string) {
+    if c == nil { return }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if el, ok := c.entries[lookup]; ok { c.remove(el) }
+}
+
+// Len returns the number of cached keys.
+func (c *Cache) Len() int {
+    if c == nil { return 0 }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.order.Len()
+}
+
+func (c *Cache) remove(el *list.Element) {
+    c.order.Remove(el)
+    delete(c.entries, el.Value.(*cacheEntry).lookup)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "sort"
+    "strings"
+)
+
+// LegacyKeyID is the key of hashes and tokens that name none: they were made
+// before the server's keys had IDs, with what is the root key of its keyring.
+const LegacyKeyID = "k0"
+
+// ServerKeys are the server's encryption keys by ID. API key hashes and
+// signed tokens use HMAC keys derived from them, one per key and use; new
+// ones use Primary's and name its ID, so older ones keep verifying until
+// their key is retired.
+type ServerKeys struct {
+    ByID    map[string][]byte
+    Primary string
+}
+
+// SingleKey returns ServerKeys holding only key, as LegacyKeyID.
+func SingleKey(key []byte) ServerKeys {
+    return ServerKeys{ByID: map[string][]byte{LegacyKeyID: key}, Primary: LegacyKeyID}
+}
+
+// derivedKeys are the HMAC keys of one use, by the ID of the server key they
+// were derived from.
+type derivedKeys struct {
+    primary string
+    byID    map[string][]byte
+}
+
+func deriveKeys(keys ServerKeys, label string) derivedKeys {
+    d := derivedKeys{primary: keys.Primary, byID: make(map[string][]byte, len(keys.ByID))}
+    for id, key := range keys.ByID {
+        mac := hmac.New(sha256.New, key)
+        mac.Write([]byte(label))
+        d.byID[id] = mac.Sum(nil)
+    }
+    return d
+}
+
+// get returns the HMAC key derived from server key id.
+func (d derivedKeys) get(id string) ([]byte, bool) {
+    k, ok := d.byID[id]
+    return k, ok
+}
+
+// ids returns the key IDs, primary first and the rest sorted.
+func (d derivedKeys) ids() []string {
+    out := []string{d.primary}
+    for id := range d.byID {
+        if id != d.primary { out = append(out, id) }
+    }
+    sort.Strings(out[1:])
+    return out
+}
+
+// labelSig prefixes a token's signature with the ID of its key, but for
+// LegacyKeyID, so tokens keep their form until the key is first rotated.
+func labelSig(id, sig string) string {
+    if id == LegacyKeyID { return sig }
+    return id + "~" + sig
+}
+
+// unlabelSig splits what labelSig made; an unlabelled signature is
+// LegacyKeyID's.
+func unlabelSig(s string) (id, sig string) {
+    if id, sig, ok := strings.Cut(s, "~"); ok { return id, sig }
+    return LegacyKeyID, s
+}
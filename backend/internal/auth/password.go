@@ -0,0 +1,118 @@
+package auth
+
+import (
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/base64"
+    "fmt"
+    "strings"
+
+    "golang.org/x/crypto/argon2"
+    "golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes new passwords. Stored hashes are checked with
+// VerifyPassword whichever hasher made them, so the hasher can change
+// without locking anyone out.
+type PasswordHasher interface {
+    Hash(password string) (string, error)
+    // NeedsRehash reports whether encoded was made by another algorithm or
+    // with weaker parameters than this hasher uses, and should be replaced
+    // by Hash once the password is known.
+    NeedsRehash(encoded string) bool
+}
+
+// Argon2Params tune Argon2id. Memory is in KiB.
+type Argon2Params struct {
+    Memory      uint32
+    Time        uint32
+    Parallelism uint8
+}
+
+// DefaultArgon2Params are the OWASP minimum for Argon2id: 19 MiB, two
+// passes, one lane.
+var DefaultArgon2Params = Argon2Params{Memory: 19 * 1024, Time: 2, Parallelism: 1}
+
+const (
+    argon2SaltLen = 16
+    argon2KeyLen  = 32
+)
+
+// Argon2id hashes passwords with Argon2id into PHC strings:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<parallelism>$<salt>$<hash>.
+type Argon2id struct{ Params Argon2Params }
+
+func NewArgon2id(p Argon2Params) *Argon2id { return &Argon2id{Params: p} }
+
+func (a *Argon2id) Hash(password string) (string, error) {
+    salt := make([]byte, argon2SaltLen)
+    if _, err := rand.Read(salt); err != nil { return "", err }
+    p := a.Params
+    key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Parallelism, argon2KeyLen)
+    return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Parallelism,
+        base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+    h, err := parseArgon2id(encoded)
+    if err != nil { return true }
+    return h.params.Memory < a.Params.Memory || h.params.Time < a.Params.Time || h.params.Parallelism < a.Params.Parallelism ||
+        len(h.key) < argon2KeyLen
+}
+
+// Bcrypt hashes passwords with bcrypt at Cost.
+type Bcrypt struct{ Cost int }
+
+func NewBcrypt(cost int) *Bcrypt { return &Bcrypt{Cost: cost} }
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+    h, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+    return string(h), err
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+    cost, err := bcrypt.Cost([]byte(encoded))
+    return err != nil || cost < b.Cost
+}
+
+// VerifyPassword checks password against encoded, an Argon2id PHC string or
+// a bcrypt hash. Anything else never matches.
+func VerifyPassword(encoded, password string) bool {
+    if strings.HasPrefix(encoded, "$argon2id$") {
+        h, err := parseArgon2id(encoded)
+        if err != nil { return false }
+        p := h.params
+        key := argon2.IDKey([]byte(password), h.salt, p.Time, p.Memory, p.Parallelism, uint32(len(h.key)))
+        return subtle.ConstantTimeCompare(key, h.key) == 1
+    }
+    return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+type argon2Hash struct {
+    params    Argon2Params
+    salt, key []byte
+}
+
+func parseArgon2id(encoded string) (*argon2Hash, error) {
+    parts := strings.Split(encoded, "$")
+    if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+        return nil, fmt.Errorf("not an argon2id hash")
+    }
+    var version int
+    if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+        return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+    }
+    var h argon2Hash
+    if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.params.Memory, &h.params.Time, &h.params.Parallelism); err != nil {
+        return nil, fmt.Errorf("bad argon2 parameters %q", parts[3])
+    }
+    if h.params.Memory == 0 || h.params.Time == 0 || h.params.Parallelism == 0 {
+        return nil, fmt.Errorf("bad argon2 parameters %q", parts[3])
+    }
+    var err error
+    if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil { return nil, err }
+    if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+        return nil, fmt.Errorf("bad argon2 hash")
+    }
+    return &h, nil
+}
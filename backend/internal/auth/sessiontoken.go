@@ -0,0 +1,98 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Prefixes of the tokens SessionTokens signs, so a bearer credential can be
+// told apart from an API key without a lookup.
+const (
+    AccessTokenPrefix    = "gat_"
+    RefreshTokenPrefix   = "grt_"
+    ChallengeTokenPrefix = "gmt_"
+)
+
+// SessionTokens signs the tokens of token sessions. Both name the session and
+// carry one number: an access token its expiry, a refresh token the session's
+// refresh generation. A challenge token, handed out by a login waiting for
+// its second factor, names the user and its expiry instead. Nothing secret is
+// stored; the HMAC keys are derived from the server's encryption keys like the
+// API key HMAC, under their own label, and the signature names its key.
+type SessionTokens struct {
+    keys derivedKeys
+}
+
+// NewSessionTokens derives the signing keys from the server's encryption keys.
+func NewSessionTokens(keys ServerKeys) *SessionTokens {
+    return &SessionTokens{keys: deriveKeys(keys, "gracie session token v1")}
+}
+
+// Access returns an access token for sessionID valid until expires.
+func (t *SessionTokens) Access(sessionID string, expires time.Time) string {
+    return t.sign(AccessTokenPrefix, sessionID, expires.Unix())
+}
+
+// ParseAccess returns the session of a genuine access token unexpired at now.
+func (t *SessionTokens) ParseAccess(token string, now time.Time) (sessionID string, ok bool) {
+    sessionID, exp, ok := t.parse(AccessTokenPrefix, token)
+    if !ok || now.Unix() >= exp { return "", false }
+    return sessionID, true
+}
+
+// Refresh returns the refresh token of generation gen of sessionID.
+func (t *SessionTokens) Refresh(sessionID string, gen int64) string {
+    return t.sign(RefreshTokenPrefix, sessionID, gen)
+}
+
+// ParseRefresh returns the session and generation of a genuine refresh token.
+// Whether the generation is still current is up to the caller.
+func (t *SessionTokens) ParseRefresh(token string) (sessionID string, gen int64, ok bool) {
+    return t.parse(RefreshTokenPrefix, token)
+}
+
+// Challenge returns a token proving that userID passed the password check,
+// valid until expires.
+func (t *SessionTokens) Challenge(userID string, expires time.Time) string {
+    return t.sign(ChallengeTokenPrefix, userID, expires.Unix())
+}
+
+// ParseChallenge returns the user of a genuine challenge token unexpired at now.
+func (t *SessionTokens) ParseChallenge(token string, now time.Time) (userID string, ok bool) {
+    userID, exp, ok := t.parse(ChallengeTokenPrefix, token)
+    if !ok || now.Unix() >= exp { return "", false }
+    return userID, true
+}
+
+// sign formats prefix + id "." n "." key ID "~" MAC, the MAC covering all
+// before the last ".".
+func (t *SessionTokens) sign(prefix, id string, n int64) string {
+    body := prefix + id + "." + strconv.FormatInt(n, 10)
+    secret, _ := t.keys.get(t.keys.primary)
+    return body + "." + labelSig(t.keys.primary, tokenMAC(secret, body))
+}
+
+func (t *SessionTokens) parse(prefix, token string) (string, int64, bool) {
+    if !strings.HasPrefix(token, prefix) { return "", 0, false }
+    i := strings.LastIndexByte(token, '.')
+    if i < 0 { return "", 0, false }
+    body, sig := token[:i], token[i+1:]
+    keyID, sig := unlabelSig(sig)
+    secret, ok := t.keys.get(keyID)
+    if !ok || !constantTimeEqual(sig, tokenMAC(secret, body)) { return "", 0, false }
+    sessionID, num, ok := strings.Cut(strings.TrimPrefix(body, prefix), ".")
+    if !ok || sessionID == "" { return "", 0, false }
+    n, err := strconv.ParseInt(num, 10, 64)
+    if err != nil { return "", 0, false }
+    return sessionID, n, true
+}
+
+func tokenMAC(secret []byte, body string) string {
+    m := hmac.New(sha256.New, secret)
+    m.Write([]byte(body))
+    return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
@@ -11,3 +11,15 @@ func randomToken() string {
     return base64.RawURLEncoding.EncodeToString(b)
 }
 
+
+// NewUserToken returns a random single-use token to send to a user, such as a
+// password reset link, and the hash to store for it. The token is 256 random
+// bits, so an unkeyed SHA-256 is enough to keep a leaked table from being
+// redeemed.
+func NewUserToken() (plain string, hash string) {
+    plain = randomToken()
+    return plain, HashUserToken(plain)
+}
+
+// HashUserToken returns the stored form of a token from NewUserToken.
+func HashUserToken(plain string) string { return DeriveLookup(plain) }
@@ -0,0 +1,104 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base32"
+    "encoding/binary"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// TOTP parameters (RFC 6238) as authenticator apps expect them by default:
+// HMAC-SHA1, six digits, thirty-second steps.
+const (
+    TOTPDigits = 6
+    TOTPPeriod = 30 * time.Second
+    // TOTPSkew is how many steps before or after now a code is accepted, to
+    // allow for clock drift and typing time.
+    TOTPSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160-bit secret in unpadded base32, the form
+// authenticator apps take.
+func NewTOTPSecret() string {
+    b := make([]byte, 20)
+    _, _ = rand.Read(b)
+    return totpEncoding.EncodeToString(b)
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps read from a QR
+// code, labelled "issuer:account".
+func TOTPURI(issuer, account, secret string) string {
+    q := url.Values{}
+    q.Set("secret", secret)
+    q.Set("issuer", issuer)
+    q.Set("algorithm", "SHA1")
+    q.Set("digits", fmt.Sprint(TOTPDigits))
+    q.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+    label := url.PathEscape(issuer + ":" + account)
+    return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPStep returns the time step holding t.
+func TOTPStep(t time.Time) int64 { return t.Unix() / int64(TOTPPeriod/time.Second) }
+
+// TOTPCode returns the code of secret at step.
+func TOTPCode(secret string, step int64) (string, error) {
+    key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+    if err != nil { return "", err }
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], uint64(step))
+    mac := hmac.New(sha1.New, key)
+    mac.Write(msg[:])
+    sum := mac.Sum(nil)
+    off := sum[len(sum)-1] & 0x0f
+    n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+    return fmt.Sprintf("%0*d", TOTPDigits, n%1000000), nil
+}
+
+// MatchTOTP returns the step within TOTPSkew steps of now whose code is
+// code. Callers must refuse a step that was already used.
+func MatchTOTP(secret, code string, now time.Time) (int64, bool) {
+    code = strings.ReplaceAll(code, " ", "")
+    if len(code) != TOTPDigits { return 0, false }
+    now0 := TOTPStep(now)
+    for step := now0 - TOTPSkew; step <= now0+TOTPSkew; step++ {
+        want, err := TOTPCode(secret, step)
+        if err != nil { return 0, false }
+        if constantTimeEqual(code, want) { return step, true }
+    }
+    return 0, false
+}
+
+// recoveryAlphabet leaves out characters that are easily misread.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// NewRecoveryCode returns a single-use code to sign in with when the
+// authenticator is lost, e.g. "k7mq2-x9tfa" (about 49 random bits).
+func NewRecoveryCode() string {
+    b := make([]byte, 10)
+    _, _ = rand.Read(b)
+    out := make([]byte, 0, 11)
+    for i, c := range b {
+        if i == 5 { out = append(out, '-') }
+        // 256 % 31 != 0; the bias is too small to matter for a throttled code.
+        out = append(out, recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+    }
+    return string(out)
+}
+
+// NormalizeRecoveryCode drops case, spaces and dashes, so a code is accepted
+// however it was typed.
+func NormalizeRecoveryCode(code string) string {
+    code = strings.ToLower(code)
+    return strings.Map(func(r rune) rune {
+        if r == '-' || r == ' ' { return -1 }
+        return r
+    }, code)
+}
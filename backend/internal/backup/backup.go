@@ -0,0 +1,383 @@
+// Package backup streams the contents of a store to and from a portable
+// archive, so a deployment can be backed up or moved between backends.
+//
+// An archive is gzip-compressed JSON lines. The first line is a Header, the
+// last an "end" record with the Counts of everything before it, and each line
+// in between is one record:
+//
+//	{"format":"gracie-backup","version":2,"created_at":"...","source":"mongo"}
+//	{"type":"user","data":{...}}
+//	{"type":"room","data":{...}}
+//	{"type":"list","data":{...}}
+//	{"type":"item","data":{...}}
+//	{"type":"user_token","data":{...}}
+//	{"type":"invite","data":{...}}
+//	{"type":"category","data":{"key":"milk","category":"dairy"}}
+//	{"type":"end","counts":{"users":1,...}}
+//
+// Records keep their IDs, versions and timestamps, including secrets such as
+// encrypted passwords and recovery code hashes, so archives must be stored as
+// carefully as the database itself. Those secrets are keyed with the server's
+// keyring, which is not in the archive: restore with the same key file.
+//
+// Sessions are not archived, so a restore signs every user out. The only API
+// keys that survive are those issued before sessions and never used since,
+// which are still stored on the user; every other key must be issued again.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+const (
+	// Format identifies gracie archives in their header.
+	Format = "gracie-backup"
+	// FormatVersion is the archive version written by Export. Import reads
+	// this version and older ones. Version 2 added user tokens and invites.
+	FormatVersion = 2
+)
+
+// ErrTargetNotEmpty is returned by Import when the target store already holds
+// users, rooms, lists, items, user tokens or invites.
+var ErrTargetNotEmpty = errors.New("target store is not empty")
+
+// Header is the first line of an archive.
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Source is the DATA_STORE the archive was exported from.
+	Source string `json:"source"`
+}
+
+// Counts tallies the records of an archive or a store by kind.
+type Counts struct {
+	Users         int `json:"users"`
+	Rooms         int `json:"rooms"`
+	Lists         int `json:"lists"`
+	Items         int `json:"items"`
+	UserTokens    int `json:"user_tokens"`
+	Invites       int `json:"invites"`
+	CategoryIndex int `json:"category_index"`
+}
+
+// Source is the store an archive is exported from.
+type Source struct {
+	Users store.UserScanner
+	Rooms store.RoomScanner
+	Lists store.ListScanner
+	Items store.ListItemScanner
+	// UserTokens holds password reset links and two-factor recovery codes.
+	UserTokens store.UserTokenScanner
+	// Invites keep their uses and revocation.
+	Invites store.InviteScanner
+	// CategoryIndex is nil when the store has no category index; the archive
+	// then has no category records.
+	CategoryIndex store.CategoryIndexScanner
+}
+
+// Target is the store an archive is imported into. Scan reads the same store
+// back to check that it starts empty and to verify the import.
+type Target struct {
+	Users      store.UserRepository
+	Rooms      store.RoomRepository
+	Lists      store.ListRepository
+	Items      store.ListItemRepository
+	UserTokens store.UserTokenRepository
+	Invites    store.InviteRepository
+	// CategoryIndex is nil when the store has no category index; category
+	// records are then skipped.
+	CategoryIndex categorization.CategoryIndex
+	Scan          Source
+}
+
+// Result describes an import.
+type Result struct {
+	Header Header
+	// Archive counts the records read from the archive.
+	Archive Counts
+	// Stored counts the records found in the target after the import. The
+	// category index may hold more entries than the archive, since stores
+	// seed it on open.
+	Stored Counts
+	// SkippedCategories counts category records dropped because the target
+	// has no category index.
+	SkippedCategories int
+}
+
+type record struct {
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Counts *Counts         `json:"counts,omitempty"`
+}
+
+const (
+	typeUser     = "user"
+	typeRoom     = "room"
+	typeList     = "list"
+	typeItem     = "item"
+	typeToken    = "user_token"
+	typeInvite   = "invite"
+	typeCategory = "category"
+	typeEnd      = "end"
+)
+
+// Export writes every user, room, list, item, user token, invite and category
+// index entry of src to w as an archive and returns how many of each it
+// wrote. source names the store in the header.
+func Export(ctx context.Context, src Source, source string, w io.Writer) (Counts, error) {
+	var n Counts
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(Header{Format: Format, Version: FormatVersion, CreatedAt: time.Now().UTC(), Source: source}); err != nil {
+		return n, err
+	}
+	write := func(typ string, v any, count *int) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		*count++
+		return enc.Encode(record{Type: typ, Data: data})
+	}
+	steps := []func() error{
+		func() error {
+			return src.Users.ScanAll(ctx, func(u models.User) error { return write(typeUser, fromUser(u), &n.Users) })
+		},
+		func() error {
+			return src.Rooms.ScanAll(ctx, func(rm models.Room) error { return write(typeRoom, fromRoom(rm), &n.Rooms) })
+		},
+		func() error {
+			return src.Lists.ScanAll(ctx, func(l models.List) error { return write(typeList, fromList(l), &n.Lists) })
+		},
+		func() error {
+			return src.Items.ScanAll(ctx, func(it models.ListItem) error { return write(typeItem, fromItem(it), &n.Items) })
+		},
+		func() error {
+			return src.UserTokens.ScanAll(ctx, func(t models.UserToken) error { return write(typeToken, fromUserToken(t), &n.UserTokens) })
+		},
+		func() error {
+			return src.Invites.ScanAll(ctx, func(inv models.Invite) error { return write(typeInvite, fromInvite(inv), &n.Invites) })
+		},
+		func() error {
+			if src.CategoryIndex == nil {
+				return nil
+			}
+			return src.CategoryIndex.ScanAll(ctx, func(key, category string) error {
+				return write(typeCategory, categoryRecord{Key: key, Category: category}, &n.CategoryIndex)
+			})
+		},
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return n, err
+		}
+	}
+	if err := enc.Encode(record{Type: typeEnd, Counts: &n}); err != nil {
+		return n, err
+	}
+	return n, zw.Close()
+}
+
+// Import restores an archive into an empty target, preserving IDs, and then
+// verifies the target holds exactly the users, rooms, lists, items, user
+// tokens and invites of the archive. It returns ErrTargetNotEmpty without writing if the
+// target already has any.
+func Import(ctx context.Context, dst Target, r io.Reader) (Result, error) {
+	var res Result
+	if err := ensureEmpty(ctx, dst.Scan); err != nil {
+		return res, err
+	}
+	var err error
+	res.Header, res.Archive, err = read(r, func(rec record) error {
+		switch rec.Type {
+		case typeUser:
+			return put(rec.Data, func(v userRecord) error { u := v.model(); return dst.Users.Put(ctx, &u) })
+		case typeRoom:
+			return put(rec.Data, func(v roomRecord) error { rm := v.model(); return dst.Rooms.Put(ctx, &rm) })
+		case typeList:
+			return put(rec.Data, func(v listRecord) error { l := v.model(); return dst.Lists.Put(ctx, &l) })
+		case typeItem:
+			return put(rec.Data, func(v itemRecord) error { it := v.model(); return dst.Items.Put(ctx, &it) })
+		case typeToken:
+			return put(rec.Data, func(v userTokenRecord) error { t := v.model(); return dst.UserTokens.Create(ctx, &t) })
+		case typeInvite:
+			return put(rec.Data, func(v inviteRecord) error { inv := v.model(); return dst.Invites.Put(ctx, &inv) })
+		case typeCategory:
+			if dst.CategoryIndex == nil {
+				res.SkippedCategories++
+				return nil
+			}
+			return put(rec.Data, func(v categoryRecord) error { return dst.CategoryIndex.Upsert(ctx, v.Key, v.Category) })
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	if res.Stored, err = count(ctx, dst.Scan); err != nil {
+		return res, fmt.Errorf("verify: %w", err)
+	}
+	a, s := res.Archive, res.Stored
+	if a.Users != s.Users || a.Rooms != s.Rooms || a.Lists != s.Lists || a.Items != s.Items || a.UserTokens != s.UserTokens || a.Invites != s.Invites ||
+		(dst.CategoryIndex != nil && dst.Scan.CategoryIndex != nil && s.CategoryIndex < a.CategoryIndex) {
+		return res, fmt.Errorf("verify: archive has %+v, target has %+v", a, s)
+	}
+	return res, nil
+}
+
+// Verify reads a whole archive without restoring it and checks its header,
+// records and end counts.
+func Verify(r io.Reader) (Header, Counts, error) {
+	return read(r, func(rec record) error {
+		switch rec.Type {
+		case typeUser:
+			return put(rec.Data, func(userRecord) error { return nil })
+		case typeRoom:
+			return put(rec.Data, func(roomRecord) error { return nil })
+		case typeList:
+			return put(rec.Data, func(listRecord) error { return nil })
+		case typeItem:
+			return put(rec.Data, func(itemRecord) error { return nil })
+		case typeToken:
+			return put(rec.Data, func(userTokenRecord) error { return nil })
+		case typeInvite:
+			return put(rec.Data, func(inviteRecord) error { return nil })
+		case typeCategory:
+			return put(rec.Data, func(categoryRecord) error { return nil })
+		}
+		return nil
+	})
+}
+
+// read decodes an archive, calling fn for each record, and checks the end
+// counts against the records it saw. Unknown record types are an error.
+func read(r io.Reader, fn func(rec record) error) (Header, Counts, error) {
+	var h Header
+	var n Counts
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return h, n, fmt.Errorf("not a gracie archive: %w", err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	if err := dec.Decode(&h); err != nil || h.Format != Format {
+		return h, n, errors.New("not a gracie archive: bad header")
+	}
+	if h.Version < 1 || h.Version > FormatVersion {
+		return h, n, fmt.Errorf("unsupported archive version %d (this build reads up to %d)", h.Version, FormatVersion)
+	}
+	for line := 2; ; line++ {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return h, n, errors.New("truncated archive: no end record")
+			}
+			return h, n, fmt.Errorf("record %d: %w", line, err)
+		}
+		switch rec.Type {
+		case typeUser:
+			n.Users++
+		case typeRoom:
+			n.Rooms++
+		case typeList:
+			n.Lists++
+		case typeItem:
+			n.Items++
+		case typeToken:
+			n.UserTokens++
+		case typeInvite:
+			n.Invites++
+		case typeCategory:
+			n.CategoryIndex++
+		case typeEnd:
+			if rec.Counts == nil || *rec.Counts != n {
+				return h, n, fmt.Errorf("archive end counts %+v do not match records %+v", rec.Counts, n)
+			}
+			return h, n, nil
+		default:
+			return h, n, fmt.Errorf("record %d: unknown type %q", line, rec.Type)
+		}
+		if err := fn(rec); err != nil {
+			return h, n, fmt.Errorf("record %d (%s): %w", line, rec.Type, err)
+		}
+	}
+}
+
+// put decodes data as a T and passes it to fn.
+func put[T any](data json.RawMessage, fn func(T) error) error {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return fn(v)
+}
+
+var errFound = errors.New("found")
+
+// ensureEmpty returns ErrTargetNotEmpty if src has any user, room, list, item,
+// user token or invite.
+func ensureEmpty(ctx context.Context, src Source) error {
+	found := func(err error) error {
+		if errors.Is(err, errFound) {
+			return ErrTargetNotEmpty
+		}
+		return err
+	}
+	if err := found(src.Users.ScanAll(ctx, func(models.User) error { return errFound })); err != nil {
+		return err
+	}
+	if err := found(src.Rooms.ScanAll(ctx, func(models.Room) error { return errFound })); err != nil {
+		return err
+	}
+	if err := found(src.Lists.ScanAll(ctx, func(models.List) error { return errFound })); err != nil {
+		return err
+	}
+	if err := found(src.Items.ScanAll(ctx, func(models.ListItem) error { return errFound })); err != nil {
+		return err
+	}
+	if err := found(src.UserTokens.ScanAll(ctx, func(models.UserToken) error { return errFound })); err != nil {
+		return err
+	}
+	return found(src.Invites.ScanAll(ctx, func(models.Invite) error { return errFound }))
+}
+
+// count tallies the records of src.
+func count(ctx context.Context, src Source) (Counts, error) {
+	var n Counts
+	if err := src.Users.ScanAll(ctx, func(models.User) error { n.Users++; return nil }); err != nil {
+		return n, err
+	}
+	if err := src.Rooms.ScanAll(ctx, func(models.Room) error { n.Rooms++; return nil }); err != nil {
+		return n, err
+	}
+	if err := src.Lists.ScanAll(ctx, func(models.List) error { n.Lists++; return nil }); err != nil {
+		return n, err
+	}
+	if err := src.Items.ScanAll(ctx, func(models.ListItem) error { n.Items++; return nil }); err != nil {
+		return n, err
+	}
+	if err := src.UserTokens.ScanAll(ctx, func(models.UserToken) error { n.UserTokens++; return nil }); err != nil {
+		return n, err
+	}
+	if err := src.Invites.ScanAll(ctx, func(models.Invite) error { n.Invites++; return nil }); err != nil {
+		return n, err
+	}
+	if src.CategoryIndex != nil {
+		if err := src.CategoryIndex.ScanAll(ctx, func(string, string) error { n.CategoryIndex++; return nil }); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,358 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apiauth "github.com/janvillarosa/gracie-app/backend/internal/auth"
+	"github.com/janvillarosa/gracie-app/backend/internal/crypto"
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/services"
+	"github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
+)
+
+// categories is an in-memory category index.
+type categories map[string]string
+
+func (c categories) Lookup(_ context.Context, key string) (string, bool, error) {
+	v, ok := c[key]
+	return v, ok, nil
+}
+
+func (c categories) Upsert(_ context.Context, key, category string) error {
+	c[key] = category
+	return nil
+}
+
+func (c categories) ScanAll(_ context.Context, fn func(key, category string) error) error {
+	for k, v := range c {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fixture struct {
+	users   *memstore.UserRepo
+	rooms   *memstore.RoomRepo
+	lists   *memstore.ListRepo
+	items   *memstore.ListItemRepo
+	tokens  *memstore.UserTokenRepo
+	invites *memstore.InviteRepo
+	cats    categories
+}
+
+func newFixture() fixture {
+	st := memstore.NewStore()
+	return fixture{memstore.NewUserRepo(st), memstore.NewRoomRepo(st), memstore.NewListRepo(st), memstore.NewListItemRepo(st), memstore.NewUserTokenRepo(st), memstore.NewInviteRepo(st), categories{}}
+}
+
+func (f fixture) source() Source {
+	return Source{Users: f.users, Rooms: f.rooms, Lists: f.lists, Items: f.items, UserTokens: f.tokens, Invites: f.invites, CategoryIndex: f.cats}
+}
+
+func (f fixture) target() Target {
+	return Target{Users: f.users, Rooms: f.rooms, Lists: f.lists, Items: f.items, UserTokens: f.tokens, Invites: f.invites, CategoryIndex: f.cats, Scan: f.source()}
+}
+
+var t0 = time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+
+func seed(t *testing.T, f fixture) {
+	t.Helper()
+	ctx := context.Background()
+	roomID, token, expires := "room_1", "share_1", t0.Add(time.Hour)
+	users := []models.User{
+		{UserID: "usr_1", Name: "Alice", Username: "alice", PasswordEnc: "enc_1", APIKeyHash: "hash_1", APIKeyLookup: "lk_1", APIKeyExpiresAt: &expires, EmailVerifiedAt: &t0, RoomID: &roomID, RoomIDs: []string{roomID}, CreatedAt: t0, UpdatedAt: t0},
+		{UserID: "usr_2", Name: "Bob", PasswordEnc: "enc_2", RoomID: &roomID, CreatedAt: t0, UpdatedAt: t0},
+	}
+	for i := range users {
+		if err := f.users.Put(ctx, &users[i]); err != nil {
+			t.Fatalf("put user: %v", err)
+		}
+	}
+	rm := &models.Room{RoomID: roomID, MemberIDs: []string{"usr_1", "usr_2"}, DisplayName: "Home", ShareToken: &token,
+		DeletionVotes: map[string]string{"usr_1": t0.Format(time.RFC3339)}, Version: 4, CreatedAt: t0, UpdatedAt: t0}
+	if err := f.rooms.Put(ctx, rm); err != nil {
+		t.Fatalf("put room: %v", err)
+	}
+	for _, l := range []models.List{
+		{ListID: "list_1", RoomID: roomID, Name: "Groceries", Notes: "eggs", Icon: "cart", Version: 2, CreatedAt: t0, UpdatedAt: t0},
+		{ListID: "list_2", RoomID: roomID, Name: "Old", IsDeleted: true, DeletionVotes: map[string]string{"usr_2": t0.Format(time.RFC3339)}, Version: 3, CreatedAt: t0, UpdatedAt: t0},
+	} {
+		if err := f.lists.Put(ctx, &l); err != nil {
+			t.Fatalf("put list: %v", err)
+		}
+	}
+	for _, it := range []models.ListItem{
+		{ItemID: "it_1", ListID: "list_1", RoomID: roomID, Order: 0, Description: "milk", Quantity: "2", Unit: "l", Category: "dairy", IsStarred: true, Version: 5, CreatedAt: t0, UpdatedAt: t0},
+		{ItemID: "it_2", ListID: "list_1", RoomID: roomID, Order: 1000, Description: "bread", Completed: true, IsArchived: true, CreatedAt: t0, UpdatedAt: t0},
+		{ItemID: "it_3", ListID: "list_2", RoomID: roomID, Order: 1000, Description: "tea", IsDeleted: true, Version: 1, CreatedAt: t0, UpdatedAt: t0},
+	} {
+		if err := f.items.Put(ctx, &it); err != nil {
+			t.Fatalf("put item: %v", err)
+		}
+	}
+	recovery := &models.UserToken{TokenHash: "rc_1", Purpose: models.TokenRecoveryCode, UserID: "usr_1", CreatedAt: t0, ExpiresAt: t0.AddDate(10, 0, 0)}
+	if err := f.tokens.Create(ctx, recovery); err != nil {
+		t.Fatalf("put token: %v", err)
+	}
+	revoked := t0.Add(time.Minute)
+	for _, inv := range []models.Invite{
+		{InviteID: "inv_1", RoomID: roomID, Name: "For Bob", Code: "CODE1", LinkToken: "link_1", CreatedBy: "usr_1", MaxUses: 3, Uses: 2, CreatedAt: t0, ExpiresAt: expires},
+		{InviteID: "inv_2", RoomID: roomID, Code: "CODE2", CreatedBy: "usr_1", Uses: 1, CreatedAt: t0, ExpiresAt: expires, RevokedAt: &revoked},
+	} {
+		if err := f.invites.Put(ctx, &inv); err != nil {
+			t.Fatalf("put invite: %v", err)
+		}
+	}
+	f.cats["milk"] = "dairy"
+	f.cats["bread"] = "bakery"
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newFixture()
+	seed(t, src)
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, src.source(), "memory", &buf)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	want := Counts{Users: 2, Rooms: 1, Lists: 2, Items: 3, UserTokens: 1, Invites: 2, CategoryIndex: 2}
+	if n != want {
+		t.Fatalf("export counts: %+v", n)
+	}
+
+	dst := newFixture()
+	dst.cats["egg"] = "dairy" // seeded anchors survive the import
+	res, err := Import(ctx, dst.target(), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if res.Header.Source != "memory" || res.Header.Version != FormatVersion || res.Archive != want {
+		t.Fatalf("import result: %+v", res)
+	}
+	if stored := (Counts{Users: 2, Rooms: 1, Lists: 2, Items: 3, UserTokens: 1, Invites: 2, CategoryIndex: 3}); res.Stored != stored {
+		t.Fatalf("stored counts: %+v", res.Stored)
+	}
+
+	for _, id := range []string{"usr_1", "usr_2"} {
+		a, _ := src.users.GetByID(ctx, id)
+		b, err := dst.users.GetByID(ctx, id)
+		if err != nil || !reflect.DeepEqual(a, b) {
+			t.Fatalf("user %s: %+v != %+v (%v)", id, b, a, err)
+		}
+	}
+	a, _ := src.rooms.GetByID(ctx, "room_1")
+	b, err := dst.rooms.GetByID(ctx, "room_1")
+	if err != nil || !reflect.DeepEqual(a, b) {
+		t.Fatalf("room: %+v != %+v (%v)", b, a, err)
+	}
+	for _, id := range []string{"list_1", "list_2"} {
+		a, _ := src.lists.GetByID(ctx, id)
+		b, err := dst.lists.GetByID(ctx, id)
+		if err != nil || !reflect.DeepEqual(a, b) {
+			t.Fatalf("list %s: %+v != %+v (%v)", id, b, a, err)
+		}
+	}
+	for _, id := range []string{"it_1", "it_2", "it_3"} {
+		a, _ := src.items.GetByID(ctx, id)
+		b, err := dst.items.GetByID(ctx, id)
+		if err != nil || !reflect.DeepEqual(a, b) {
+			t.Fatalf("item %s: %+v != %+v (%v)", id, b, a, err)
+		}
+	}
+	for _, id := range []string{"inv_1", "inv_2"} {
+		a, _ := src.invites.GetByID(ctx, id)
+		b, err := dst.invites.GetByID(ctx, id)
+		if err != nil || !reflect.DeepEqual(a, b) {
+			t.Fatalf("invite %s: %+v != %+v (%v)", id, b, a, err)
+		}
+	}
+	tok, err := dst.tokens.Consume(ctx, "rc_1", models.TokenRecoveryCode)
+	if err != nil || tok.UserID != "usr_1" || !tok.CreatedAt.Equal(t0) || !tok.ExpiresAt.Equal(t0.AddDate(10, 0, 0)) {
+		t.Fatalf("user token: %+v (%v)", tok, err)
+	}
+	if dst.cats["bread"] != "bakery" || dst.cats["egg"] != "dairy" {
+		t.Fatalf("category index: %v", dst.cats)
+	}
+
+	// A store with data is never merged into.
+	if _, err := Import(ctx, dst.target(), bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrTargetNotEmpty) {
+		t.Fatalf("import into non-empty store: got %v", err)
+	}
+}
+
+// TestRecoveryCodesRoundTrip moves a two-factor user to another store with
+// the same key file and signs in there with a recovery code.
+func TestRecoveryCodesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	keyPath := filepath.Join(t.TempDir(), "enc.key")
+	newAuth := func(f fixture) *services.AuthService {
+		auth, err := services.NewAuthService(f.users, memstore.NewSessionRepo(memstore.NewStore()), keyPath, 1)
+		if err != nil {
+			t.Fatalf("auth: %v", err)
+		}
+		auth.UsePasswordReset(f.tokens, nil, "", time.Hour)
+		return auth
+	}
+
+	src := newFixture()
+	auth := newAuth(src)
+	if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	lr, err := auth.Login(ctx, "a@b.com", "password123", "")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	setup, err := auth.StartTOTP(ctx, lr.User.UserID)
+	if err != nil {
+		t.Fatalf("start totp: %v", err)
+	}
+	code, _ := apiauth.TOTPCode(setup.Secret, apiauth.TOTPStep(time.Now()))
+	recovery, err := auth.ConfirmTOTP(ctx, lr.User.UserID, code)
+	if err != nil {
+		t.Fatalf("confirm totp: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := Export(ctx, src.source(), "memory", &buf); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	dst := newFixture()
+	if _, err := Import(ctx, dst.target(), &buf); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	moved := newAuth(dst)
+	lr, err = moved.Login(ctx, "a@b.com", "password123", "")
+	if err != nil || lr.Challenge == "" {
+		t.Fatalf("login after restore: %v %+v", err, lr)
+	}
+	if res, err := moved.LoginTOTP(ctx, lr.Challenge, recovery[0], ""); err != nil || res.Tokens == nil {
+		t.Fatalf("recovery code after restore: %v", err)
+	}
+}
+
+// TestSessionsAreNotArchived checks what a restore does to sign-ins: session
+// keys stop working, while a key stored on the user before sessions survives.
+func TestSessionsAreNotArchived(t *testing.T) {
+	ctx := context.Background()
+	keyPath := filepath.Join(t.TempDir(), "enc.key")
+	src, srcSessions := newFixture(), memstore.NewSessionRepo(memstore.NewStore())
+	auth, err := services.NewAuthService(src.users, srcSessions, keyPath, 1)
+	if err != nil {
+		t.Fatalf("auth: %v", err)
+	}
+	if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	u, _ := src.users.GetByUsername(ctx, "a@b.com")
+	sessionKey, _, err := auth.IssueSession(ctx, u.UserID, "Laptop")
+	if err != nil {
+		t.Fatalf("issue session: %v", err)
+	}
+	ring, err := crypto.LoadOrCreateKeyring(keyPath)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	legacyKey, hash := apiauth.NewAPIKeys(apiauth.ServerKeys{ByID: ring.Keys(), Primary: ring.Primary()}).Generate()
+	if err := src.users.SetAPIKey(ctx, u.UserID, hash, apiauth.DeriveLookup(legacyKey), nil, t0); err != nil {
+		t.Fatalf("set legacy key: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := Export(ctx, src.source(), "memory", &buf); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	dst, dstSessions := newFixture(), memstore.NewSessionRepo(memstore.NewStore())
+	if _, err := Import(ctx, dst.target(), &buf); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if got, err := dstSessions.ListByUser(ctx, u.UserID); err != nil || len(got) != 0 {
+		t.Fatalf("sessions after restore: %+v (%v)", got, err)
+	}
+
+	moved, err := services.NewAuthService(dst.users, dstSessions, keyPath, 1)
+	if err != nil {
+		t.Fatalf("auth: %v", err)
+	}
+	if _, _, err := moved.Authenticate(ctx, sessionKey); !errors.Is(err, derr.ErrUnauthorized) {
+		t.Fatalf("session key after restore: want unauthorized, got %v", err)
+	}
+	if got, _, err := moved.Authenticate(ctx, legacyKey); err != nil || got.UserID != u.UserID {
+		t.Fatalf("legacy key after restore: %v", err)
+	}
+}
+
+func TestImportWithoutCategoryIndex(t *testing.T) {
+	ctx := context.Background()
+	src := newFixture()
+	seed(t, src)
+	var buf bytes.Buffer
+	if _, err := Export(ctx, src.source(), "memory", &buf); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	dst := newFixture()
+	target := dst.target()
+	target.CategoryIndex, target.Scan.CategoryIndex = nil, nil
+	res, err := Import(ctx, target, &buf)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if res.SkippedCategories != 2 || res.Stored.Items != 3 || len(dst.cats) != 0 {
+		t.Fatalf("import result: %+v", res)
+	}
+}
+
+// archive gzips lines into an archive.
+func archive(lines ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	_ = zw.Close()
+	return &buf
+}
+
+func TestVerifyRejectsDamagedArchives(t *testing.T) {
+	header, _ := json.Marshal(Header{Format: Format, Version: FormatVersion, CreatedAt: t0, Source: "memory"})
+	user := `{"type":"user","data":{"user_id":"usr_1","name":"Alice","password_enc":"enc","created_at":"2024-03-01T00:00:00Z","updated_at":"2024-03-01T00:00:00Z"}}`
+
+	if _, n, err := Verify(archive(string(header), user, `{"type":"end","counts":{"users":1,"rooms":0,"lists":0,"items":0,"category_index":0}}`)); err != nil || n.Users != 1 {
+		t.Fatalf("valid archive: %v, %+v", err, n)
+	}
+	for name, buf := range map[string]*bytes.Buffer{
+		"not gzip":       bytes.NewBufferString("plain text"),
+		"bad header":     archive(`{"format":"other","version":1}`),
+		"future version": archive(`{"format":"gracie-backup","version":99}`),
+		"truncated":      archive(string(header), user),
+		"count mismatch": archive(string(header), user, `{"type":"end","counts":{"users":2,"rooms":0,"lists":0,"items":0,"category_index":0}}`),
+		"unknown type":   archive(string(header), `{"type":"widget","data":{}}`),
+		"malformed data": archive(string(header), `{"type":"item","data":{"order":"first"}}`),
+		"malformed line": archive(string(header), `{"type":`),
+		"missing counts": archive(string(header), `{"type":"end"}`),
+	} {
+		if _, _, err := Verify(buf); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// A damaged archive fails the import.
+	dst := newFixture()
+	if _, err := Import(context.Background(), dst.target(), archive(string(header), user)); err == nil {
+		t.Fatalf("import truncated archive: expected error")
+	}
+}
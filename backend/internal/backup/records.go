@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// The record types fix the archive's field names independently of the API's
+// JSON, which hides secrets and internal IDs. They convert directly to and
+// from the models, so a model field missing here fails to compile.
+
+type userRecord struct {
+	UserID          string     `json:"user_id"`
+	Name            string     `json:"name"`
+	Username        string     `json:"username,omitempty"`
+	PasswordEnc     string     `json:"password_enc"`
+	APIKeyHash      string     `json:"api_key_hash,omitempty"`
+	APIKeyLookup    string     `json:"api_key_lookup,omitempty"`
+	APIKeyExpiresAt *time.Time `json:"api_key_expires_at,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	TOTPSecretEnc   string     `json:"totp_secret_enc,omitempty"`
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at,omitempty"`
+	RoomID          *string    `json:"room_id,omitempty"`
+	RoomIDs         []string   `json:"room_ids,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func fromUser(u models.User) userRecord { return userRecord(u) }
+
+func (r userRecord) model() models.User { return models.User(r) }
+
+type roomRecord struct {
+	RoomID        string                 `json:"room_id"`
+	MemberIDs     []string               `json:"member_ids"`
+	Roles         map[string]models.Role `json:"roles,omitempty"`
+	DisplayName   string                 `json:"display_name,omitempty"`
+	Description   string                 `json:"description,omitempty"`
+	ShareToken    *string                `json:"share_token,omitempty"`
+	DeletionVotes map[string]string      `json:"deletion_votes,omitempty"`
+	Version       int64                  `json:"version"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+}
+
+func fromRoom(rm models.Room) roomRecord { return roomRecord(rm) }
+
+func (r roomRecord) model() models.Room { return models.Room(r) }
+
+type listRecord struct {
+	ListID        string            `json:"list_id"`
+	RoomID        string            `json:"room_id"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description,omitempty"`
+	Notes         string            `json:"notes,omitempty"`
+	Icon          string            `json:"icon,omitempty"`
+	DeletionVotes map[string]string `json:"deletion_votes,omitempty"`
+	IsDeleted     bool              `json:"is_deleted,omitempty"`
+	Version       int64             `json:"version"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+func fromList(l models.List) listRecord { return listRecord(l) }
+
+func (r listRecord) model() models.List { return models.List(r) }
+
+type itemRecord struct {
+	ItemID      string    `json:"item_id"`
+	ListID      string    `json:"list_id"`
+	RoomID      string    `json:"room_id"`
+	Order       float64   `json:"order"`
+	Description string    `json:"description"`
+	Quantity    string    `json:"quantity,omitempty"`
+	Unit        string    `json:"unit,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	IsStarred   bool      `json:"is_starred,omitempty"`
+	IsArchived  bool      `json:"is_archived,omitempty"`
+	IsDeleted   bool      `json:"is_deleted,omitempty"`
+	Completed   bool      `json:"completed"`
+	Version     int64     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func fromItem(it models.ListItem) itemRecord { return itemRecord(it) }
+
+func (r itemRecord) model() models.ListItem { return models.ListItem(r) }
+
+type categoryRecord struct {
+	Key      string `json:"key"`
+	Category string `json:"category"`
+}
+
+// userTokenRecord carries a user token such as a two-factor recovery code.
+// Its hash is keyed with the server's keys, so it only redeems on a server
+// with the same keyring.
+type userTokenRecord struct {
+	TokenHash string    `json:"token_hash"`
+	Purpose   string    `json:"purpose"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func fromUserToken(t models.UserToken) userTokenRecord { return userTokenRecord(t) }
+
+func (r userTokenRecord) model() models.UserToken { return models.UserToken(r) }
+
+type inviteRecord struct {
+	InviteID  string     `json:"invite_id"`
+	RoomID    string     `json:"room_id"`
+	Name      string     `json:"name,omitempty"`
+	Code      string     `json:"code"`
+	LinkToken string     `json:"link_token,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func fromInvite(inv models.Invite) inviteRecord { return inviteRecord(inv) }
+
+func (r inviteRecord) model() models.Invite { return models.Invite(r) }
@@ -3,6 +3,8 @@ package config
 import (
     "fmt"
     "os"
+    "regexp"
+    "strings"
 )
 
 type Config struct {
@@ -13,13 +15,30 @@ type Config struct {
     RoomsTable  string
     ListsTable  string
     ListItemsTable string
+    MigrationsTable string
+    JobsTable   string
+    SessionsTable string
+    UserTokensTable string
+    CountersTable string
+    InvitesTable string
     EncKeyFile  string
     APIKeyTTLHours int
-    // Store selection: "dynamo" (default) or "mongo"
+    // Token sessions: access token lifetime, and how long a session may go
+    // without refreshing
+    AccessTokenTTLMinutes int
+    RefreshTokenTTLHours  int
+    // Verified API keys cached per server: how many, and for how long
+    AuthCacheSize       int
+    AuthCacheTTLSeconds int
+    // Store selection: "mongo" (default), "dynamo", "sqlite" or "postgres"
     DataStore   string
     // Mongo settings (used when DataStore == "mongo")
     MongoURI    string
     MongoDB     string
+    // SQLitePath is the database file (used when DataStore == "sqlite")
+    SQLitePath  string
+    // PostgresURL is the connection URL (used when DataStore == "postgres")
+    PostgresURL string
     // AvatarSalt is used to derive deterministic avatar keys (HMAC of user_id).
     AvatarSalt  string
     // Embedding categorization
@@ -28,6 +47,75 @@ type Config struct {
     EmbedThreshold     float64
     EmbedTopK          int
     CategoryIndexEnabled bool
+    // Background jobs: worker goroutines per server and attempts before a job is dead-lettered
+    JobWorkers     int
+    JobMaxAttempts int
+    // TrashRetentionDays is how long deleted lists and items stay restorable before the purge job removes them
+    TrashRetentionDays int
+    // Outgoing mail: "log" (default) keeps messages in MailDir, or the log
+    // when it is empty; "smtp" sends through SMTPHost
+    Mailer       string
+    MailFrom     string
+    MailDir      string
+    SMTPHost     string
+    SMTPPort     int
+    SMTPUsername string
+    SMTPPassword string
+    // AppBaseURL is the frontend's public URL, used for links in emails
+    AppBaseURL string
+    // PasswordResetTTLMinutes is how long a password reset link stays valid
+    PasswordResetTTLMinutes int
+    // EmailVerification is what an unverified email address blocks: "off"
+    // (default), "join" (joining rooms) or "login" (signing in and joining)
+    EmailVerification         string
+    EmailVerificationTTLHours int
+    // RateLimitStore keeps rate limit counters: "memory" (default; each
+    // server counts alone) or "shared" (the data store, for several servers)
+    RateLimitStore string
+    // TrustedProxyHops is how many reverse proxies in front of the server
+    // append to X-Forwarded-For; 0 takes the client IP from the connection
+    TrustedProxyHops int
+    // Login attempts per client IP per LoginIPWindowMinutes
+    LoginIPLimit         int
+    LoginIPWindowMinutes int
+    // Failed passwords per username that lock the account, counted over
+    // LoginLockoutMinutes
+    LoginLockoutFailures int
+    LoginLockoutMinutes  int
+    // Room joins per client IP and failed joins (wrong code) per user, per
+    // JoinWindowMinutes
+    JoinIPLimit       int
+    JoinFailureLimit  int
+    JoinWindowMinutes int
+    // Requests for emails (password resets and verification links) per
+    // client IP and emails of each kind sent per user, per MailWindowMinutes
+    MailIPLimit       int
+    MailUserLimit     int
+    MailWindowMinutes int
+    // Sign-ups per client IP per RegisterWindowMinutes
+    RegisterIPLimit       int
+    RegisterWindowMinutes int
+    // OIDCProviders are the single sign-on providers, listed by ID in
+    // OIDC_PROVIDERS and configured by OIDC_<ID>_ISSUER, _CLIENT_ID,
+    // _CLIENT_SECRET, _NAME and _SCOPES
+    OIDCProviders []OIDCProvider
+    // PasswordHash hashes new passwords: "argon2id" (default) or "bcrypt".
+    // Hashes made otherwise, or with lower settings, are replaced at login
+    PasswordHash      string
+    Argon2MemoryKiB   int
+    Argon2Time        int
+    Argon2Parallelism int
+    BcryptCost        int
+}
+
+// OIDCProvider is an OpenID Connect provider users can sign in with.
+type OIDCProvider struct {
+    ID           string
+    Name         string
+    Issuer       string
+    ClientID     string
+    ClientSecret string
+    Scopes       []string
 }
 
 func getEnv(key, def string) string {
@@ -46,11 +134,23 @@ func Load() (*Config, error) {
         RoomsTable:  getEnv("ROOMS_TABLE", "Rooms"),
         ListsTable:  getEnv("LISTS_TABLE", "Lists"),
         ListItemsTable: getEnv("LIST_ITEMS_TABLE", "ListItems"),
+        MigrationsTable: getEnv("MIGRATIONS_TABLE", "Migrations"),
+        JobsTable:   getEnv("JOBS_TABLE", "Jobs"),
+        SessionsTable: getEnv("SESSIONS_TABLE", "Sessions"),
+        UserTokensTable: getEnv("USER_TOKENS_TABLE", "UserTokens"),
+        CountersTable: getEnv("COUNTERS_TABLE", "Counters"),
+        InvitesTable: getEnv("INVITES_TABLE", "Invites"),
         EncKeyFile:  getEnv("ENC_KEY_FILE", "/app/secrets/enc.key"),
         APIKeyTTLHours: getEnvInt("API_KEY_TTL_HOURS", 720),
+        AccessTokenTTLMinutes: getEnvInt("ACCESS_TOKEN_TTL_MINUTES", 15),
+        RefreshTokenTTLHours:  getEnvInt("REFRESH_TOKEN_TTL_HOURS", 720),
+        AuthCacheSize:  getEnvInt("AUTH_CACHE_SIZE", 10000),
+        AuthCacheTTLSeconds: getEnvInt("AUTH_CACHE_TTL_SECONDS", 300),
         DataStore:   getEnv("DATA_STORE", "mongo"),
         MongoURI:    getEnv("MONGODB_URI", "mongodb://localhost:27017"),
         MongoDB:     getEnv("MONGODB_DB", "gracie"),
+        SQLitePath:  getEnv("SQLITE_PATH", "/app/data/gracie.db"),
+        PostgresURL: getEnv("POSTGRES_URL", "postgres://localhost:5432/gracie?sslmode=disable"),
         AvatarSalt:  getEnv("AVATAR_SALT", "local-avatar-salt"),
     }
 
@@ -59,6 +159,39 @@ func Load() (*Config, error) {
     cfg.EmbedThreshold = getEnvFloat("EMBED_THRESHOLD", 0.45)
     cfg.EmbedTopK = getEnvInt("EMBED_TOPK", 5)
     cfg.CategoryIndexEnabled = getEnv("CATEGORY_INDEX_ENABLED", "true") == "true"
+    cfg.JobWorkers = getEnvInt("JOB_WORKERS", 2)
+    cfg.JobMaxAttempts = getEnvInt("JOB_MAX_ATTEMPTS", 8)
+    cfg.TrashRetentionDays = getEnvInt("TRASH_RETENTION_DAYS", 30)
+    cfg.Mailer = getEnv("MAILER", "log")
+    cfg.MailFrom = getEnv("MAIL_FROM", "Gracie <no-reply@localhost>")
+    cfg.MailDir = getEnv("MAIL_DIR", "")
+    cfg.SMTPHost = getEnv("SMTP_HOST", "")
+    cfg.SMTPPort = getEnvInt("SMTP_PORT", 587)
+    cfg.SMTPUsername = getEnv("SMTP_USERNAME", "")
+    cfg.SMTPPassword = getEnv("SMTP_PASSWORD", "")
+    cfg.AppBaseURL = getEnv("APP_BASE_URL", "http://localhost:3000")
+    cfg.PasswordResetTTLMinutes = getEnvInt("PASSWORD_RESET_TTL_MINUTES", 60)
+    cfg.EmailVerification = getEnv("EMAIL_VERIFICATION", "off")
+    cfg.EmailVerificationTTLHours = getEnvInt("EMAIL_VERIFICATION_TTL_HOURS", 48)
+    cfg.RateLimitStore = getEnv("RATE_LIMIT_STORE", "memory")
+    cfg.TrustedProxyHops = getEnvInt("TRUSTED_PROXY_HOPS", 0)
+    cfg.LoginIPLimit = getEnvInt("LOGIN_IP_LIMIT", 30)
+    cfg.LoginIPWindowMinutes = getEnvInt("LOGIN_IP_WINDOW_MINUTES", 10)
+    cfg.LoginLockoutFailures = getEnvInt("LOGIN_LOCKOUT_FAILURES", 5)
+    cfg.LoginLockoutMinutes = getEnvInt("LOGIN_LOCKOUT_MINUTES", 15)
+    cfg.JoinIPLimit = getEnvInt("JOIN_IP_LIMIT", 30)
+    cfg.JoinFailureLimit = getEnvInt("JOIN_FAILURE_LIMIT", 10)
+    cfg.JoinWindowMinutes = getEnvInt("JOIN_WINDOW_MINUTES", 15)
+    cfg.MailIPLimit = getEnvInt("MAIL_IP_LIMIT", 10)
+    cfg.MailUserLimit = getEnvInt("MAIL_USER_LIMIT", 3)
+    cfg.MailWindowMinutes = getEnvInt("MAIL_WINDOW_MINUTES", 60)
+    cfg.RegisterIPLimit = getEnvInt("REGISTER_IP_LIMIT", 10)
+    cfg.RegisterWindowMinutes = getEnvInt("REGISTER_WINDOW_MINUTES", 60)
+    cfg.PasswordHash = getEnv("PASSWORD_HASH", "argon2id")
+    cfg.Argon2MemoryKiB = getEnvInt("ARGON2_MEMORY_KIB", 19456)
+    cfg.Argon2Time = getEnvInt("ARGON2_TIME", 2)
+    cfg.Argon2Parallelism = getEnvInt("ARGON2_PARALLELISM", 1)
+    cfg.BcryptCost = getEnvInt("BCRYPT_COST", 10)
 
     // If DDB_ENDPOINT is explicitly set to "aws", use AWS-managed DynamoDB (no custom endpoint)
     if v, ok := os.LookupEnv("DDB_ENDPOINT"); ok {
@@ -67,12 +200,73 @@ func Load() (*Config, error) {
         }
     }
 
-    if cfg.UsersTable == "" || cfg.RoomsTable == "" || cfg.ListsTable == "" || cfg.ListItemsTable == "" {
+    if cfg.UsersTable == "" || cfg.RoomsTable == "" || cfg.ListsTable == "" || cfg.ListItemsTable == "" || cfg.MigrationsTable == "" || cfg.JobsTable == "" || cfg.SessionsTable == "" || cfg.UserTokensTable == "" || cfg.CountersTable == "" || cfg.InvitesTable == "" {
         return nil, fmt.Errorf("missing table names")
     }
+    switch cfg.DataStore {
+    case "mongo", "dynamo", "sqlite", "postgres":
+    default:
+        return nil, fmt.Errorf("unsupported DATA_STORE %q (want mongo, dynamo, sqlite or postgres)", cfg.DataStore)
+    }
+    switch cfg.Mailer {
+    case "log":
+    case "smtp":
+        if cfg.SMTPHost == "" { return nil, fmt.Errorf("MAILER=smtp needs SMTP_HOST") }
+    default:
+        return nil, fmt.Errorf("unsupported MAILER %q (want log or smtp)", cfg.Mailer)
+    }
+    switch cfg.EmailVerification {
+    case "off", "join", "login":
+    default:
+        return nil, fmt.Errorf("unsupported EMAIL_VERIFICATION %q (want off, join or login)", cfg.EmailVerification)
+    }
+    switch cfg.RateLimitStore {
+    case "memory", "shared":
+    default:
+        return nil, fmt.Errorf("unsupported RATE_LIMIT_STORE %q (want memory or shared)", cfg.RateLimitStore)
+    }
+    switch cfg.PasswordHash {
+    case "argon2id":
+        if cfg.Argon2MemoryKiB < 8*cfg.Argon2Parallelism || cfg.Argon2Time < 1 || cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > 255 {
+            return nil, fmt.Errorf("invalid Argon2 settings: ARGON2_MEMORY_KIB must be at least 8 per lane, ARGON2_TIME at least 1, ARGON2_PARALLELISM 1-255")
+        }
+    case "bcrypt":
+        if cfg.BcryptCost < 10 || cfg.BcryptCost > 31 { return nil, fmt.Errorf("BCRYPT_COST %d out of range (10-31)", cfg.BcryptCost) }
+    default:
+        return nil, fmt.Errorf("unsupported PASSWORD_HASH %q (want argon2id or bcrypt)", cfg.PasswordHash)
+    }
+    providers, err := loadOIDCProviders(getEnv("OIDC_PROVIDERS", ""))
+    if err != nil { return nil, err }
+    cfg.OIDCProviders = providers
     return cfg, nil
 }
 
+var providerIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// loadOIDCProviders reads the providers listed in ids, e.g. "google,okta".
+func loadOIDCProviders(ids string) ([]OIDCProvider, error) {
+    var out []OIDCProvider
+    seen := map[string]bool{}
+    for _, id := range strings.Split(ids, ",") {
+        id = strings.ToLower(strings.TrimSpace(id))
+        if id == "" { continue }
+        if !providerIDRe.MatchString(id) || seen[id] { return nil, fmt.Errorf("invalid OIDC_PROVIDERS entry %q", id) }
+        seen[id] = true
+        prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+        p := OIDCProvider{
+            ID:           id,
+            Name:         getEnv(prefix+"NAME", id),
+            Issuer:       getEnv(prefix+"ISSUER", ""),
+            ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+            ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+            Scopes:       strings.Fields(strings.ReplaceAll(getEnv(prefix+"SCOPES", ""), ",", " ")),
+        }
+        if p.Issuer == "" || p.ClientID == "" { return nil, fmt.Errorf("OIDC provider %q needs %sISSUER and %sCLIENT_ID", id, prefix, prefix) }
+        out = append(out, p)
+    }
+    return out, nil
+}
+
 func getEnvInt(key string, def int) int {
     if v := os.Getenv(key); v != "" {
         var n int
@@ -34,6 +34,29 @@ func TestLoadEnvOverrides(t *testing.T) {
     if cfg.UsersTable != "U" || cfg.RoomsTable != "R" { t.Fatalf("tables not applied") }
 }
 
+func TestDataStoreSelection(t *testing.T) {
+    t.Setenv("DATA_STORE", "")
+    cfg, err := Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.DataStore != "mongo" { t.Fatalf("data store default: %s", cfg.DataStore) }
+
+    t.Setenv("DATA_STORE", "dynamo")
+    cfg, err = Load()
+    if err != nil || cfg.DataStore != "dynamo" { t.Fatalf("dynamo: %v %v", err, cfg) }
+
+    t.Setenv("DATA_STORE", "sqlite")
+    t.Setenv("SQLITE_PATH", "/tmp/g.db")
+    cfg, err = Load()
+    if err != nil || cfg.DataStore != "sqlite" || cfg.SQLitePath != "/tmp/g.db" { t.Fatalf("sqlite: %v %v", err, cfg) }
+
+    t.Setenv("DATA_STORE", "postgres")
+    cfg, err = Load()
+    if err != nil || cfg.DataStore != "postgres" || cfg.PostgresURL == "" { t.Fatalf("postgres: %v %v", err, cfg) }
+
+    t.Setenv("DATA_STORE", "cassandra")
+    if _, err := Load(); err == nil { t.Fatalf("expected error for unknown data store") }
+}
+
 func TestEmbeddingDefaults(t *testing.T) {
 	for _, k := range []string{"EMBEDDING_ENABLED", "EMBEDDING_MODEL_PATH", "EMBED_THRESHOLD", "EMBED_TOPK", "CATEGORY_INDEX_ENABLED"} {
 		os.Unsetenv(k)
@@ -59,3 +82,91 @@ func TestEmbeddingDefaults(t *testing.T) {
 	}
 }
 
+
+func TestJobSettings(t *testing.T) {
+    t.Setenv("JOB_WORKERS", "")
+    t.Setenv("JOB_MAX_ATTEMPTS", "")
+    t.Setenv("JOBS_TABLE", "")
+    cfg, err := Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.JobWorkers != 2 || cfg.JobMaxAttempts != 8 || cfg.JobsTable != "Jobs" { t.Fatalf("job defaults: %d %d %s", cfg.JobWorkers, cfg.JobMaxAttempts, cfg.JobsTable) }
+
+    t.Setenv("JOB_WORKERS", "4")
+    t.Setenv("JOB_MAX_ATTEMPTS", "3")
+    cfg, err = Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.JobWorkers != 4 || cfg.JobMaxAttempts != 3 { t.Fatalf("job overrides: %d %d", cfg.JobWorkers, cfg.JobMaxAttempts) }
+}
+
+func TestTrashRetention(t *testing.T) {
+    t.Setenv("TRASH_RETENTION_DAYS", "")
+    cfg, err := Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.TrashRetentionDays != 30 { t.Fatalf("trash retention default: %d", cfg.TrashRetentionDays) }
+
+    t.Setenv("TRASH_RETENTION_DAYS", "7")
+    cfg, err = Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.TrashRetentionDays != 7 { t.Fatalf("trash retention override: %d", cfg.TrashRetentionDays) }
+}
+
+func TestRateLimitSettings(t *testing.T) {
+    t.Setenv("RATE_LIMIT_STORE", "")
+    t.Setenv("LOGIN_LOCKOUT_FAILURES", "")
+    t.Setenv("TRUSTED_PROXY_HOPS", "")
+    cfg, err := Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.RateLimitStore != "memory" || cfg.LoginLockoutFailures != 5 || cfg.TrustedProxyHops != 0 { t.Fatalf("rate limit defaults: %s %d %d", cfg.RateLimitStore, cfg.LoginLockoutFailures, cfg.TrustedProxyHops) }
+    if cfg.MailIPLimit != 10 || cfg.MailUserLimit != 3 || cfg.MailWindowMinutes != 60 { t.Fatalf("mail limit defaults: %d %d %d", cfg.MailIPLimit, cfg.MailUserLimit, cfg.MailWindowMinutes) }
+    if cfg.RegisterIPLimit != 10 || cfg.RegisterWindowMinutes != 60 { t.Fatalf("register limit defaults: %d %d", cfg.RegisterIPLimit, cfg.RegisterWindowMinutes) }
+
+    t.Setenv("RATE_LIMIT_STORE", "shared")
+    t.Setenv("TRUSTED_PROXY_HOPS", "1")
+    cfg, err = Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.RateLimitStore != "shared" || cfg.TrustedProxyHops != 1 { t.Fatalf("rate limit overrides: %s %d", cfg.RateLimitStore, cfg.TrustedProxyHops) }
+
+    t.Setenv("RATE_LIMIT_STORE", "redis")
+    if _, err := Load(); err == nil { t.Fatalf("expected error for unknown rate limit store") }
+}
+
+func TestOIDCProviders(t *testing.T) {
+    t.Setenv("OIDC_PROVIDERS", "")
+    cfg, err := Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if len(cfg.OIDCProviders) != 0 { t.Fatalf("providers by default: %+v", cfg.OIDCProviders) }
+
+    t.Setenv("OIDC_PROVIDERS", "Google, my-idp")
+    t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+    t.Setenv("OIDC_GOOGLE_CLIENT_ID", "gid")
+    t.Setenv("OIDC_GOOGLE_NAME", "Google")
+    t.Setenv("OIDC_MY_IDP_ISSUER", "https://idp.example")
+    t.Setenv("OIDC_MY_IDP_CLIENT_ID", "mid")
+    t.Setenv("OIDC_MY_IDP_SCOPES", "openid,email")
+    cfg, err = Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if len(cfg.OIDCProviders) != 2 { t.Fatalf("providers: %+v", cfg.OIDCProviders) }
+    g, m := cfg.OIDCProviders[0], cfg.OIDCProviders[1]
+    if g.ID != "google" || g.Name != "Google" || g.ClientID != "gid" { t.Fatalf("google: %+v", g) }
+    if m.ID != "my-idp" || m.Name != "my-idp" || len(m.Scopes) != 2 || m.Scopes[1] != "email" { t.Fatalf("my-idp: %+v", m) }
+
+    t.Setenv("OIDC_MY_IDP_CLIENT_ID", "")
+    if _, err := Load(); err == nil { t.Fatalf("expected error for provider without client id") }
+}
+
+func TestPasswordHashSettings(t *testing.T) {
+    t.Setenv("PASSWORD_HASH", "")
+    t.Setenv("ARGON2_MEMORY_KIB", "")
+    cfg, err := Load()
+    if err != nil { t.Fatalf("load: %v", err) }
+    if cfg.PasswordHash != "argon2id" || cfg.Argon2MemoryKiB != 19456 || cfg.Argon2Time != 2 || cfg.Argon2Parallelism != 1 { t.Fatalf("password hash defaults: %+v", cfg) }
+
+    t.Setenv("ARGON2_MEMORY_KIB", "4")
+    if _, err := Load(); err == nil { t.Fatalf("expected error for too little Argon2 memory") }
+    t.Setenv("PASSWORD_HASH", "bcrypt")
+    t.Setenv("BCRYPT_COST", "12")
+    cfg, err = Load()
+    if err != nil || cfg.BcryptCost != 12 { t.Fatalf("bcrypt: %v %+v", err, cfg) }
+    t.Setenv("PASSWORD_HASH", "md5")
+    if _, err := Load(); err == nil { t.Fatalf("expected error for unknown password hash") }
+}
@@ -37,3 +37,46 @@ func TestEncryptDecrypt(t *testing.T) {
     if _, err := Decrypt(key, "not-base64"); err == nil { t.Fatalf("expected base64 error") }
 }
 
+
+func TestKeyringRotation(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "enc.key")
+    legacy, err := LoadOrCreateKey(path)
+    if err != nil { t.Fatalf("create key: %v", err) }
+    old, _ := Encrypt(legacy, []byte("before keyrings"))
+
+    // A raw key file loads as a one-key ring that still opens old data.
+    ring, err := LoadOrCreateKeyring(path)
+    if err != nil { t.Fatalf("load ring: %v", err) }
+    if string(ring.Root()) != string(legacy) || ring.Primary() != "k0" { t.Fatalf("legacy ring: primary %s", ring.Primary()) }
+    if pt, err := ring.Decrypt(old); err != nil || string(pt) != "before keyrings" { t.Fatalf("decrypt legacy: %v %q", err, pt) }
+
+    // Staged keys decrypt but do not encrypt until promoted.
+    id, err := ring.Add(false)
+    if err != nil || ring.Primary() != "k0" { t.Fatalf("add staged: %v primary %s", err, ring.Primary()) }
+    if err := ring.Promote(id); err != nil { t.Fatalf("promote: %v", err) }
+    ct, err := ring.Encrypt([]byte("after"))
+    if err != nil || ring.KeyID(ct) != id || ring.KeyID(old) != "k0" { t.Fatalf("encrypt: %v %q", err, ct) }
+    if err := ring.Save(path); err != nil { t.Fatalf("save: %v", err) }
+    info, _ := os.Stat(path)
+    if info.Mode().Perm()&0o077 != 0 { t.Fatalf("keyring file should be 0600: %v", info.Mode()) }
+
+    reloaded, err := LoadOrCreateKeyring(path)
+    if err != nil { t.Fatalf("reload: %v", err) }
+    if reloaded.Primary() != id || string(reloaded.Root()) != string(legacy) { t.Fatalf("reload lost keys: %v", reloaded.IDs()) }
+    for _, c := range []string{old, ct} {
+        if _, err := reloaded.Decrypt(c); err != nil { t.Fatalf("decrypt after reload: %v", err) }
+    }
+
+    // The primary key stays; others can go, and their data with them.
+    if err := reloaded.Retire(id); err == nil { t.Fatal("retired the primary key") }
+    other, _ := reloaded.Add(false)
+    sealed, _ := Encrypt(legacy, []byte("x"))
+    if err := reloaded.Retire(other); err != nil { t.Fatalf("retire: %v", err) }
+    if _, err := reloaded.Decrypt(other + ":" + sealed); err != ErrUnknownKey { t.Fatalf("retired key: want ErrUnknownKey, got %v", err) }
+    // So can the root, once old data is re-encrypted.
+    if err := reloaded.Retire("k0"); err != nil || reloaded.Root() != nil { t.Fatalf("retire root: %v", err) }
+    if _, err := reloaded.Decrypt(old); err != ErrUnknownKey || reloaded.KeyID(old) != "k0" { t.Fatalf("unlabelled after root retired: %v", err) }
+    if keys := reloaded.Keys(); len(keys) != 1 || keys[id] == nil { t.Fatalf("keys: %v", reloaded.IDs()) }
+
+    if _, err := ParseKeyring([]byte(keyringHeader + "\nk1 c2hvcnQ= primary\n")); err == nil { t.Fatal("accepted a short key") }
+}
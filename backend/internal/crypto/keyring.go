@@ -0,0 +1,217 @@
+package crypto
+
+import (
+    "bufio"
+    "bytes"
+    crand "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// keyringHeader starts a keyring file. A file without it holds a single raw
+// key, the format written by LoadOrCreateKey.
+const keyringHeader = "# gracie keyring v1"
+
+// rootKeyID names the first key of a new keyring, or the key of a raw key
+// file. Unlabelled ciphertexts belong to it.
+const rootKeyID = "k0"
+
+var keyIDRe = regexp.MustCompile(`^[a-z0-9]{1,16}$`)
+
+// ErrUnknownKey is returned when decrypting data sealed with a key the
+// keyring no longer holds.
+var ErrUnknownKey = errors.New("crypto: ciphertext needs a key not in the keyring")
+
+// Keyring holds the keys data at rest may be encrypted with. Encrypt seals
+// with the primary key and labels the ciphertext with its ID; Decrypt opens
+// anything sealed with a key still in the ring.
+//
+// The root key, k0, opens ciphertexts from before keyrings (unlabelled
+// base64(nonce||ct)). API key hashes and signed tokens are keyed with secrets
+// derived from every key (see Keys) and name the key they used, those from
+// before keyrings the root. Any key but the primary can be retired, the root
+// too once nothing needs it.
+type Keyring struct {
+    keys    []ringKey
+    primary int
+}
+
+type ringKey struct {
+    id  string
+    key []byte
+}
+
+// LoadOrCreateKeyring reads the keyring at path, or a raw key file as
+// written by LoadOrCreateKey. A missing file is created with a single key,
+// with 0600 permissions.
+func LoadOrCreateKeyring(path string) (*Keyring, error) {
+    b, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        key, err := newKey()
+        if err != nil { return nil, err }
+        r := &Keyring{keys: []ringKey{{id: rootKeyID, key: key}}}
+        if err := os.MkdirAll(dirOf(path), 0o700); err != nil && !errors.Is(err, os.ErrExist) {
+            // ignore if cannot create dir; try write anyway
+        }
+        if err := r.Save(path); err != nil { return nil, err }
+        return r, nil
+    }
+    if err != nil { return nil, err }
+    return ParseKeyring(b)
+}
+
+// ParseKeyring reads a keyring file, or a raw key of at least 32 bytes.
+func ParseKeyring(b []byte) (*Keyring, error) {
+    if !bytes.HasPrefix(b, []byte(keyringHeader)) {
+        if len(b) < 32 { return nil, errors.New("crypto: key file is shorter than 32 bytes") }
+        return &Keyring{keys: []ringKey{{id: rootKeyID, key: append([]byte(nil), b[:32]...)}}}, nil
+    }
+    r := &Keyring{primary: -1}
+    sc := bufio.NewScanner(bytes.NewReader(b))
+    for n := 1; sc.Scan(); n++ {
+        line := strings.TrimSpace(sc.Text())
+        if line == "" || strings.HasPrefix(line, "#") { continue }
+        f := strings.Fields(line)
+        if len(f) < 2 || len(f) > 3 || (len(f) == 3 && f[2] != "primary") {
+            return nil, fmt.Errorf("crypto: keyring line %d: want \"<id> <base64 key> [primary]\"", n)
+        }
+        key, err := base64.StdEncoding.DecodeString(f[1])
+        if err != nil || len(key) != 32 { return nil, fmt.Errorf("crypto: keyring line %d: key must be 32 bytes of base64", n) }
+        if !keyIDRe.MatchString(f[0]) || r.index(f[0]) >= 0 { return nil, fmt.Errorf("crypto: keyring line %d: bad or repeated key id %q", n, f[0]) }
+        if len(f) == 3 {
+            if r.primary >= 0 { return nil, fmt.Errorf("crypto: keyring line %d: second primary key", n) }
+            r.primary = len(r.keys)
+        }
+        r.keys = append(r.keys, ringKey{id: f[0], key: key})
+    }
+    if err := sc.Err(); err != nil { return nil, err }
+    if len(r.keys) == 0 { return nil, errors.New("crypto: keyring has no keys") }
+    if r.primary < 0 { r.primary = 0 }
+    return r, nil
+}
+
+// Save writes the keyring to path with 0600 permissions, replacing the file
+// atomically.
+func (r *Keyring) Save(path string) error {
+    var buf bytes.Buffer
+    buf.WriteString(keyringHeader + "\n")
+    buf.WriteString("# <id> <base64 key> [primary]\n")
+    for i, k := range r.keys {
+        fmt.Fprintf(&buf, "%s %s", k.id, base64.StdEncoding.EncodeToString(k.key))
+        if i == r.primary { buf.WriteString(" primary") }
+        buf.WriteString("\n")
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil { return err }
+    if err := os.Rename(tmp, path); err != nil {
+        _ = os.Remove(tmp)
+        return err
+    }
+    return nil
+}
+
+// IDs returns the key IDs, oldest first.
+func (r *Keyring) IDs() []string {
+    out := make([]string, len(r.keys))
+    for i, k := range r.keys { out[i] = k.id }
+    return out
+}
+
+// Primary returns the ID of the key new data is encrypted with.
+func (r *Keyring) Primary() string { return r.keys[r.primary].id }
+
+// Root returns the root key, which opens ciphertexts from before keyrings, or
+// nil once it is retired.
+func (r *Keyring) Root() []byte {
+    if i := r.index(rootKeyID); i >= 0 { return r.keys[i].key }
+    return nil
+}
+
+// Keys returns a copy of the keys by ID, from which secrets for hashing and
+// signing are derived.
+func (r *Keyring) Keys() map[string][]byte {
+    out := make(map[string][]byte, len(r.keys))
+    for _, k := range r.keys { out[k.id] = append([]byte(nil), k.key...) }
+    return out
+}
+
+// Add generates a key and adds it to the ring, as primary if primary is set.
+// Servers can decrypt with a key before any of them encrypts with it, so
+// with several servers add it first and Promote it once all have it.
+func (r *Keyring) Add(primary bool) (string, error) {
+    key, err := newKey()
+    if err != nil { return "", err }
+    var id string
+    for id == "" || r.index(id) >= 0 {
+        b := make([]byte, 4)
+        if _, err := io.ReadFull(crand.Reader, b); err != nil { return "", err }
+        id = "k" + hex.EncodeToString(b)
+    }
+    r.keys = append(r.keys, ringKey{id: id, key: key})
+    if primary { r.primary = len(r.keys) - 1 }
+    return id, nil
+}
+
+// Promote makes the key id primary.
+func (r *Keyring) Promote(id string) error {
+    i := r.index(id)
+    if i < 0 { return ErrUnknownKey }
+    r.primary = i
+    return nil
+}
+
+// Retire removes the key id. The primary key cannot be retired; data still
+// encrypted with the key can no longer be decrypted, nor hashes and tokens
+// keyed with it verified.
+func (r *Keyring) Retire(id string) error {
+    i := r.index(id)
+    if i < 0 { return ErrUnknownKey }
+    if i == r.primary { return fmt.Errorf("crypto: %s is the primary key; promote another first", id) }
+    r.keys = append(r.keys[:i], r.keys[i+1:]...)
+    if r.primary > i { r.primary-- }
+    return nil
+}
+
+// Encrypt seals plaintext with the primary key as "<key id>:" followed by
+// base64(nonce || ciphertext).
+func (r *Keyring) Encrypt(plaintext []byte) (string, error) {
+    k := r.keys[r.primary]
+    ct, err := Encrypt(k.key, plaintext)
+    if err != nil { return "", err }
+    return k.id + ":" + ct, nil
+}
+
+// Decrypt opens what Encrypt or, with the root key, the package-level
+// Encrypt produced.
+func (r *Keyring) Decrypt(s string) ([]byte, error) {
+    i := r.index(r.KeyID(s))
+    if i < 0 { return nil, ErrUnknownKey }
+    if j := strings.IndexByte(s, ':'); j >= 0 { s = s[j+1:] }
+    return Decrypt(r.keys[i].key, s)
+}
+
+// KeyID returns the ID of the key s was encrypted with; unlabelled
+// ciphertexts belong to the root key.
+func (r *Keyring) KeyID(s string) string {
+    if i := strings.IndexByte(s, ':'); i >= 0 { return s[:i] }
+    return rootKeyID
+}
+
+func (r *Keyring) index(id string) int {
+    for i, k := range r.keys {
+        if k.id == id { return i }
+    }
+    return -1
+}
+
+func newKey() ([]byte, error) {
+    key := make([]byte, 32)
+    if _, err := io.ReadFull(crand.Reader, key); err != nil { return nil, err }
+    return key, nil
+}
@@ -8,5 +8,14 @@ var (
     ErrConflict           = errors.New("conflict")
     ErrNotFound           = errors.New("not found")
     ErrForbidden          = errors.New("forbidden")
+    // ErrPreconditionFailed reports a conditional write whose expected
+    // version no longer matches the stored record.
+    ErrPreconditionFailed = errors.New("precondition failed")
+    // ErrEmailUnverified reports an action that needs a verified email
+    // address; it is reported like ErrForbidden.
+    ErrEmailUnverified    = errors.New("email not verified")
+    // ErrRateLimited reports an action refused because it was attempted too
+    // often; ratelimit.Error carries how long to wait.
+    ErrRateLimited        = errors.New("too many attempts")
 )
 
@@ -9,5 +9,8 @@ func TestSentinelErrors(t *testing.T) {
     if ErrConflict.Error() != "conflict" { t.Fatalf("unexpected msg: %v", ErrConflict) }
     if ErrNotFound.Error() != "not found" { t.Fatalf("unexpected msg: %v", ErrNotFound) }
     if ErrForbidden.Error() != "forbidden" { t.Fatalf("unexpected msg: %v", ErrForbidden) }
+    if ErrPreconditionFailed.Error() != "precondition failed" { t.Fatalf("unexpected msg: %v", ErrPreconditionFailed) }
+    if ErrEmailUnverified.Error() != "email not verified" { t.Fatalf("unexpected msg: %v", ErrEmailUnverified) }
+    if ErrRateLimited.Error() != "too many attempts" { t.Fatalf("unexpected msg: %v", ErrRateLimited) }
 }
 
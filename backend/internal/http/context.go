@@ -7,7 +7,10 @@ import (
 
 type ctxKey string
 
-const userKey ctxKey = "user"
+const (
+    userKey    ctxKey = "user"
+    sessionKey ctxKey = "session"
+)
 
 func WithUser(ctx context.Context, u *models.User) context.Context {
     return context.WithValue(ctx, userKey, u)
@@ -21,3 +24,15 @@ func UserFrom(ctx context.Context) (*models.User, bool) {
     }
     return nil, false
 }
+
+// WithSession records the session the request authenticated with.
+func WithSession(ctx context.Context, s *models.Session) context.Context {
+    return context.WithValue(ctx, sessionKey, s)
+}
+
+func SessionFrom(ctx context.Context) (*models.Session, bool) {
+    if s, ok := ctx.Value(sessionKey).(*models.Session); ok && s != nil {
+        return s, true
+    }
+    return nil, false
+}
@@ -17,3 +17,11 @@ func TestUserContextRoundTrip(t *testing.T) {
     if !ok || got == nil || got.UserID != "usr_test" { t.Fatalf("user not round-tripped: %v %v", got, ok) }
 }
 
+
+func TestSessionContextRoundTrip(t *testing.T) {
+    base := context.Background()
+    if _, ok := SessionFrom(base); ok { t.Fatalf("unexpected session in base context") }
+    ctx := WithSession(base, &models.Session{SessionID: "ses_test"})
+    got, ok := SessionFrom(ctx)
+    if !ok || got.SessionID != "ses_test" { t.Fatalf("session not round-tripped: %v %v", got, ok) }
+}
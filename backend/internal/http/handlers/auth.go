@@ -1,10 +1,14 @@
 package handlers
 
 import (
+    "errors"
+    "log"
     "net/http"
 
+    "github.com/go-chi/chi/v5"
     api "github.com/janvillarosa/gracie-app/backend/internal/http"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
     "github.com/janvillarosa/gracie-app/backend/internal/services"
 )
 
@@ -37,8 +41,16 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 type loginReq struct {
-    Username string `json:"username"`
-    Password string `json:"password"`
+    Username   string `json:"username"`
+    Password   string `json:"password"`
+    DeviceName string `json:"device_name"`
+}
+
+// deviceName names the session a request signs in: the name the client gave,
+// or else its User-Agent.
+func deviceName(r *http.Request, given string) string {
+    if given != "" { return given }
+    return r.UserAgent()
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -47,14 +59,138 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
         api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
         return
     }
-    res, err := h.Auth.Login(r.Context(), req.Username, req.Password)
+    res, err := h.Auth.Login(r.Context(), req.Username, req.Password, deviceName(r, req.DeviceName))
+    if api.WriteRateLimited(w, err) { return }
     if err != nil {
         code := http.StatusUnauthorized
         if err == derr.ErrBadRequest { code = http.StatusBadRequest }
+        if err == derr.ErrEmailUnverified { code = http.StatusForbidden }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, loginBody(res))
+}
+
+// loginBody renders a sign-in: the tokens, user and session, or the challenge
+// of a user with two-factor login, to send to /auth/login/totp with a code.
+func loginBody(res *services.LoginResult) map[string]any {
+    if res.Challenge != "" { return map[string]any{"mfa_required": true, "challenge": res.Challenge} }
+    body := tokenBody(res.Tokens)
+    body["user"], body["session"] = res.User, res.Session
+    return body
+}
+
+type loginTOTPReq struct {
+    Challenge  string `json:"challenge"`
+    Code       string `json:"code"`
+    DeviceName string `json:"device_name"`
+}
+
+// LoginTOTP finishes a login that answered mfa_required with an authenticator
+// code or a recovery code.
+func (h *AuthHandler) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+    var req loginTOTPReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.Challenge == "" || req.Code == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    res, err := h.Auth.LoginTOTP(r.Context(), req.Challenge, req.Code, deviceName(r, req.DeviceName))
+    if api.WriteRateLimited(w, err) { return }
+    if err != nil {
+        code := http.StatusInternalServerError
+        if err == derr.ErrUnauthorized { code = http.StatusUnauthorized }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, loginBody(res))
+}
+
+// OIDCProviders lists the single sign-on providers for the login page.
+func (h *AuthHandler) OIDCProviders(w http.ResponseWriter, r *http.Request) {
+    out := []map[string]string{}
+    for _, p := range h.Auth.OIDCProviders() {
+        out = append(out, map[string]string{"id": p.ID(), "name": p.Name()})
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]any{"providers": out})
+}
+
+// StartOIDC returns the provider URL to send the browser to and the flow the
+// client keeps until the provider redirects back.
+func (h *AuthHandler) StartOIDC(w http.ResponseWriter, r *http.Request) {
+    authURL, flow, err := h.Auth.StartOIDC(r.Context(), chi.URLParam(r, "provider"))
+    if err != nil {
+        code := http.StatusBadGateway
+        if err == derr.ErrNotFound { code = http.StatusNotFound }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]string{"auth_url": authURL, "flow": flow})
+}
+
+type oidcCallbackReq struct {
+    Code       string `json:"code"`
+    State      string `json:"state"`
+    Flow       string `json:"flow"`
+    DeviceName string `json:"device_name"`
+}
+
+// OIDCCallback signs in with the code the provider redirected back with. The
+// response is that of Login.
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+    var req oidcCallbackReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.Code == "" || req.State == "" || req.Flow == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    res, err := h.Auth.FinishOIDC(r.Context(), chi.URLParam(r, "provider"), req.Flow, req.Code, req.State, deviceName(r, req.DeviceName))
+    if err != nil {
+        code := http.StatusInternalServerError
+        switch err {
+        case derr.ErrUnauthorized:
+            code = http.StatusUnauthorized
+        case derr.ErrEmailUnverified:
+            code = http.StatusForbidden
+        case derr.ErrNotFound:
+            code = http.StatusNotFound
+        }
         api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
     }
-    api.WriteJSON(w, http.StatusOK, map[string]any{"user": res.User, "api_key": res.APIKey})
+    api.WriteJSON(w, http.StatusOK, loginBody(res))
+}
+
+// tokenBody renders a token pair in the shape of an OAuth 2 token response.
+func tokenBody(t *services.TokenPair) map[string]any {
+    return map[string]any{
+        "access_token":  t.AccessToken,
+        "refresh_token": t.RefreshToken,
+        "token_type":    "Bearer",
+        "expires_in":    int(t.ExpiresIn.Seconds()),
+    }
+}
+
+type refreshReq struct {
+    RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token. A
+// refresh token can be used once; replaying one revokes its session.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+    var req refreshReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.RefreshToken == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    tokens, ses, err := h.Auth.Refresh(r.Context(), req.RefreshToken)
+    if err != nil {
+        code := http.StatusInternalServerError
+        if err == derr.ErrUnauthorized { code = http.StatusUnauthorized }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    body := tokenBody(tokens)
+    body["session"] = ses
+    api.WriteJSON(w, http.StatusOK, body)
 }
 
 type changePwdReq struct {
@@ -62,7 +198,8 @@ type changePwdReq struct {
     Next    string `json:"new_password"`
 }
 
-// ChangePassword requires authentication (wired under auth middleware) and rotates API key.
+// ChangePassword requires authentication (wired under auth middleware). It signs
+// out every session and returns the tokens of a new one for the calling device.
 func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
     u, ok := api.UserFrom(r.Context())
     if !ok {
@@ -74,12 +211,254 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
         api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
         return
     }
-    key, err := h.Auth.ChangePassword(r.Context(), u.UserID, req.Current, req.Next)
+    device := r.UserAgent()
+    if ses, ok := api.SessionFrom(r.Context()); ok { device = ses.Name }
+    tokens, err := h.Auth.ChangePassword(r.Context(), u.UserID, req.Current, req.Next, device)
     if err != nil {
         code := http.StatusBadRequest
         if err == derr.ErrUnauthorized { code = http.StatusUnauthorized }
         api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
     }
-    api.WriteJSON(w, http.StatusOK, map[string]any{"api_key": key})
+    api.WriteJSON(w, http.StatusOK, tokenBody(tokens))
+}
+
+type forgotPwdReq struct {
+    Username string `json:"username"`
+}
+
+// ForgotPassword mails a password reset link. It answers 202 whether or not
+// the account exists, so it cannot be used to probe for usernames.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+    var req forgotPwdReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.Username == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    if err := h.Auth.RequestPasswordReset(r.Context(), req.Username); err != nil {
+        log.Printf("password reset for %q: %v", req.Username, err)
+    }
+    w.WriteHeader(http.StatusAccepted)
+}
+
+type resetPwdReq struct {
+    Token      string `json:"token"`
+    Next       string `json:"new_password"`
+    DeviceName string `json:"device_name"`
+}
+
+// ResetPassword sets a new password with a reset token, signs out every
+// session and signs the caller in like Login.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+    var req resetPwdReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.Token == "" || req.Next == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    res, err := h.Auth.ResetPassword(r.Context(), req.Token, req.Next, deviceName(r, req.DeviceName))
+    if err != nil {
+        code := http.StatusInternalServerError
+        if err == derr.ErrBadRequest { code = http.StatusBadRequest }
+        if err == derr.ErrUnauthorized { code = http.StatusUnauthorized }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, loginBody(res))
+}
+
+type verifyEmailReq struct {
+    Token string `json:"token"`
+}
+
+// VerifyEmail redeems the link mailed to a user's address and returns the
+// user with email_verified_at set.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+    var req verifyEmailReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.Token == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    u, err := h.Auth.VerifyEmail(r.Context(), req.Token)
+    if err != nil {
+        code := http.StatusInternalServerError
+        if err == derr.ErrUnauthorized { code = http.StatusUnauthorized }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]any{"user": u})
+}
+
+// ResendVerification mails a new verification link. Like ForgotPassword it
+// answers 202 whether or not the account exists.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+    var req forgotPwdReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.Username == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    if err := h.Auth.RequestVerification(r.Context(), req.Username); err != nil {
+        log.Printf("email verification for %q: %v", req.Username, err)
+    }
+    w.WriteHeader(http.StatusAccepted)
+}
+
+type totpCodeReq struct {
+    Code string `json:"code"`
+}
+
+var errInvalidCode = errors.New("invalid code")
+
+// writeTOTPError answers a failed two-factor settings request. A wrong code
+// is 403 rather than 401, which clients take for an expired access token.
+func writeTOTPError(w http.ResponseWriter, err error) {
+    if api.WriteRateLimited(w, err) { return }
+    code := http.StatusInternalServerError
+    switch err {
+    case derr.ErrUnauthorized:
+        code, err = http.StatusForbidden, errInvalidCode
+    case derr.ErrBadRequest:
+        code = http.StatusBadRequest
+    case derr.ErrConflict:
+        code = http.StatusConflict
+    case derr.ErrNotFound:
+        code = http.StatusNotFound
+    }
+    api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// StartTOTP creates a TOTP secret for the caller's authenticator app. Two-factor
+// login is turned on by confirming a code from it with ConfirmTOTP.
+func (h *AuthHandler) StartTOTP(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    setup, err := h.Auth.StartTOTP(r.Context(), u.UserID)
+    if err != nil {
+        writeTOTPError(w, err)
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]string{"secret": setup.Secret, "otpauth_uri": setup.URI})
+}
+
+// ConfirmTOTP turns two-factor login on and returns the recovery codes, which
+// are shown only this once.
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+    h.totpCodeRequest(w, r, func(userID, code string) (any, error) {
+        codes, err := h.Auth.ConfirmTOTP(r.Context(), userID, code)
+        return map[string]any{"recovery_codes": codes}, err
+    })
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes.
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+    h.totpCodeRequest(w, r, func(userID, code string) (any, error) {
+        codes, err := h.Auth.RegenerateRecoveryCodes(r.Context(), userID, code)
+        return map[string]any{"recovery_codes": codes}, err
+    })
+}
+
+// DisableTOTP turns two-factor login off.
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+    h.totpCodeRequest(w, r, func(userID, code string) (any, error) {
+        return nil, h.Auth.DisableTOTP(r.Context(), userID, code)
+    })
+}
+
+// totpCodeRequest runs fn with the caller and the code in the request body
+// and writes its result, or 204 when it returns none.
+func (h *AuthHandler) totpCodeRequest(w http.ResponseWriter, r *http.Request, fn func(userID, code string) (any, error)) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    var req totpCodeReq
+    if err := api.DecodeJSON(r, &req); err != nil {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    out, err := fn(u.UserID, req.Code)
+    if err != nil {
+        writeTOTPError(w, err)
+        return
+    }
+    if out == nil {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, out)
+}
+
+// Logout revokes the session the request authenticated with.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+    u, uok := api.UserFrom(r.Context())
+    ses, sok := api.SessionFrom(r.Context())
+    if !uok || !sok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    if err := h.Auth.RevokeSession(r.Context(), u.UserID, ses.SessionID); err != nil && err != derr.ErrNotFound {
+        api.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+type sessionView struct {
+    *models.Session
+    Current bool `json:"current"`
+}
+
+// ListSessions returns the caller's signed-in devices, marking the current one.
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    cur, _ := api.SessionFrom(r.Context())
+    sessions, err := h.Auth.ListSessions(r.Context(), u.UserID)
+    if err != nil {
+        api.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+        return
+    }
+    out := make([]sessionView, len(sessions))
+    for i := range sessions {
+        out[i] = sessionView{Session: &sessions[i], Current: cur != nil && cur.SessionID == sessions[i].SessionID}
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]any{"sessions": out})
+}
+
+// RevokeSession signs out one of the caller's devices.
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    if err := h.Auth.RevokeSession(r.Context(), u.UserID, chi.URLParam(r, "session_id")); err != nil {
+        code := http.StatusInternalServerError
+        if err == derr.ErrNotFound { code = http.StatusNotFound }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs out every device but the caller's.
+func (h *AuthHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+    u, uok := api.UserFrom(r.Context())
+    ses, sok := api.SessionFrom(r.Context())
+    if !uok || !sok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    n, err := h.Auth.RevokeOtherSessions(r.Context(), u.UserID, ses.SessionID)
+    if err != nil {
+        api.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+        return
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]int{"revoked": n})
 }
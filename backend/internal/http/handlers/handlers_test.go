@@ -2,31 +2,39 @@ package handlers_test
 
 import (
     "bytes"
+    "context"
     "encoding/json"
     "net/http"
     "net/http/httptest"
+    "strings"
     "testing"
+    "time"
 
+    apiauth "github.com/janvillarosa/gracie-app/backend/internal/auth"
     handlers "github.com/janvillarosa/gracie-app/backend/internal/http/handlers"
     "github.com/janvillarosa/gracie-app/backend/internal/http/router"
+    "github.com/janvillarosa/gracie-app/backend/internal/mail"
+    "github.com/janvillarosa/gracie-app/backend/internal/oidc"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
     "github.com/janvillarosa/gracie-app/backend/internal/services"
     "github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
     "github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
+    "github.com/janvillarosa/gracie-app/backend/internal/testutil/oidcmock"
 )
 
 func TestHTTPFlow(t *testing.T) {
     tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
-    userSvc := services.NewUserService(usersRepo, roomsRepo, tx)
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
     roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
     listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
-    authSvc, err := services.NewAuthService(usersRepo, "/tmp/gracie-test-enc.key", 720)
-    if err != nil { t.Fatalf("auth svc: %v", err) }
 
     ah := handlers.NewAuthHandler(authSvc)
     uh := handlers.NewUserHandler(userSvc, []byte("salt"))
     rh := handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt"))
     lh := handlers.NewListHandler(listSvc)
-    r := router.NewRouter(usersRepo, ah, uh, rh, lh)
+    r := router.NewRouter(authSvc, router.Limits{}, ah, uh, rh, lh)
 
     // Create user A
     aResp := struct{ User struct{ UserID, Name, CreatedAt, UpdatedAt string; RoomID *string `json:"room_id"` }; APIKey string `json:"api_key"` }{}
@@ -56,6 +64,338 @@ func TestHTTPFlow(t *testing.T) {
     doPostAuthJSON(t, r, "/rooms/deletion/vote", aResp.APIKey, nil, &del, http.StatusOK)
     doPostAuthJSON(t, r, "/rooms/deletion/vote", bResp.APIKey, nil, &del, http.StatusOK)
     if !del.Deleted { t.Fatalf("expected deleted true on second vote") }
+
+    // Sessions: two devices, list, then log one out
+    doPostJSON[any](t, r, "/auth/register", map[string]string{"username": "c@d.com", "password": "password123", "name": "Cleo"}, nil, http.StatusCreated)
+    var laptop, phone struct{ AccessToken string `json:"access_token"`; RefreshToken string `json:"refresh_token"` }
+    doPostJSON(t, r, "/auth/login", map[string]string{"username": "c@d.com", "password": "password123", "device_name": "Laptop"}, &laptop, http.StatusOK)
+    doPostJSON(t, r, "/auth/login", map[string]string{"username": "c@d.com", "password": "password123", "device_name": "Phone"}, &phone, http.StatusOK)
+    var sessions struct{ Sessions []struct{ Name string; Current bool } }
+    doGetAuthJSON(t, r, "/me/sessions", laptop.AccessToken, &sessions, http.StatusOK)
+    if len(sessions.Sessions) != 2 { t.Fatalf("expected 2 sessions, got %+v", sessions) }
+    for _, s := range sessions.Sessions {
+        if s.Current != (s.Name == "Laptop") { t.Fatalf("wrong current session: %+v", sessions) }
+    }
+
+    // Refresh rotates the pair; the old refresh token is spent
+    var renewed struct{ AccessToken string `json:"access_token"`; ExpiresIn int `json:"expires_in"` }
+    doPostJSON(t, r, "/auth/refresh", map[string]string{"refresh_token": phone.RefreshToken}, &renewed, http.StatusOK)
+    if renewed.AccessToken == "" || renewed.ExpiresIn <= 0 { t.Fatalf("refresh failed: %+v", renewed) }
+    doGetAuthJSON[any](t, r, "/me", renewed.AccessToken, nil, http.StatusOK)
+    doPostJSON[any](t, r, "/auth/refresh", map[string]string{"refresh_token": "grt_bogus"}, nil, http.StatusUnauthorized)
+
+    doPostAuthJSON[any](t, r, "/auth/logout", laptop.AccessToken, nil, nil, http.StatusNoContent)
+    doGetAuthJSON[any](t, r, "/me", laptop.AccessToken, nil, http.StatusUnauthorized)
+    doGetAuthJSON[any](t, r, "/me", renewed.AccessToken, nil, http.StatusOK)
+
+    // Password reset: forgot answers alike for unknown accounts, the mailed
+    // token signs in once and ends the other sessions
+    box := &outbox{}
+    authSvc.UsePasswordReset(memstore.NewUserTokenRepo(memstore.NewStore()), box, "https://gracie.example/reset-password", time.Hour)
+    doPostJSON[any](t, r, "/auth/password/forgot", map[string]string{"username": "nobody@d.com"}, nil, http.StatusAccepted)
+    doPostJSON[any](t, r, "/auth/password/forgot", map[string]string{"username": "c@d.com"}, nil, http.StatusAccepted)
+    if len(box.sent) != 1 { t.Fatalf("expected 1 reset mail, got %d", len(box.sent)) }
+    token := strings.Fields(box.sent[0].Body[strings.Index(box.sent[0].Body, "?token=")+len("?token="):])[0]
+    doPostJSON[any](t, r, "/auth/password/reset", map[string]string{"token": token, "new_password": "short"}, nil, http.StatusBadRequest)
+    var reset struct{ AccessToken string `json:"access_token"`; User struct{ Username string } }
+    doPostJSON(t, r, "/auth/password/reset", map[string]string{"token": token, "new_password": "resetpass1", "device_name": "Desktop"}, &reset, http.StatusOK)
+    if reset.AccessToken == "" || reset.User.Username != "c@d.com" { t.Fatalf("reset failed: %+v", reset) }
+    doPostJSON[any](t, r, "/auth/password/reset", map[string]string{"token": token, "new_password": "resetpass2"}, nil, http.StatusUnauthorized)
+    doGetAuthJSON[any](t, r, "/me", renewed.AccessToken, nil, http.StatusUnauthorized)
+    doGetAuthJSON[any](t, r, "/me", reset.AccessToken, nil, http.StatusOK)
+
+    // Email verification: with login gated, sign-in waits for the mailed link
+    authSvc.UseEmailVerification(box, "https://gracie.example/verify-email", time.Hour, true)
+    doPostJSON[any](t, r, "/auth/register", map[string]string{"username": "e@f.com", "password": "password123", "name": "Eve"}, nil, http.StatusCreated)
+    doPostJSON[any](t, r, "/auth/login", map[string]string{"username": "e@f.com", "password": "password123"}, nil, http.StatusForbidden)
+    doPostJSON[any](t, r, "/auth/verify/resend", map[string]string{"username": "e@f.com"}, nil, http.StatusAccepted)
+    last := box.sent[len(box.sent)-1].Body
+    verifyToken := strings.Fields(last[strings.Index(last, "?token=")+len("?token="):])[0]
+    doPostJSON[any](t, r, "/auth/verify", map[string]string{"token": "gvt_bogus.1.x"}, nil, http.StatusUnauthorized)
+    doPostJSON[any](t, r, "/auth/verify", map[string]string{"token": verifyToken}, nil, http.StatusOK)
+    var eve struct{ AccessToken string `json:"access_token"` }
+    doPostJSON(t, r, "/auth/login", map[string]string{"username": "e@f.com", "password": "password123"}, &eve, http.StatusOK)
+    var eveMe struct{ EmailVerified bool `json:"email_verified"` }
+    doGetAuthJSON(t, r, "/me", eve.AccessToken, &eveMe, http.StatusOK)
+    if !eveMe.EmailVerified { t.Fatalf("expected email_verified after verifying") }
+
+    // Two-factor login: after confirming a code, the password alone answers
+    // with a challenge that a recovery code redeems
+    var setup struct{ Secret string; OTPAuthURI string `json:"otpauth_uri"` }
+    doPostAuthJSON(t, r, "/me/totp", eve.AccessToken, nil, &setup, http.StatusOK)
+    if setup.Secret == "" || !strings.HasPrefix(setup.OTPAuthURI, "otpauth://totp/") { t.Fatalf("totp setup: %+v", setup) }
+    doPostAuthJSON[any](t, r, "/me/totp/confirm", eve.AccessToken, map[string]string{"code": "000000"}, nil, http.StatusForbidden)
+    code, _ := apiauth.TOTPCode(setup.Secret, apiauth.TOTPStep(time.Now()))
+    var confirmed struct{ RecoveryCodes []string `json:"recovery_codes"` }
+    doPostAuthJSON(t, r, "/me/totp/confirm", eve.AccessToken, map[string]string{"code": code}, &confirmed, http.StatusOK)
+    if len(confirmed.RecoveryCodes) == 0 { t.Fatalf("no recovery codes") }
+    var challenge struct{ MFARequired bool `json:"mfa_required"`; Challenge string; AccessToken string `json:"access_token"` }
+    doPostJSON(t, r, "/auth/login", map[string]string{"username": "e@f.com", "password": "password123"}, &challenge, http.StatusOK)
+    if !challenge.MFARequired || challenge.Challenge == "" || challenge.AccessToken != "" { t.Fatalf("login with totp: %+v", challenge) }
+    doPostJSON[any](t, r, "/auth/login/totp", map[string]string{"challenge": challenge.Challenge, "code": "bad"}, nil, http.StatusUnauthorized)
+    var eve2 struct{ AccessToken string `json:"access_token"` }
+    doPostJSON(t, r, "/auth/login/totp", map[string]string{"challenge": challenge.Challenge, "code": confirmed.RecoveryCodes[0]}, &eve2, http.StatusOK)
+    doPostAuthJSON[any](t, r, "/me/totp/disable", eve2.AccessToken, map[string]string{"code": confirmed.RecoveryCodes[1]}, nil, http.StatusNoContent)
+}
+
+func TestRateLimits(t *testing.T) {
+    tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    counters := ratelimit.NewMemoryStore()
+    authSvc.UseLoginLockout(ratelimit.New(counters, "login_user", ratelimit.Rule{Limit: 2, Window: time.Hour}))
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
+    roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
+    roomSvc.UseJoinLimiter(ratelimit.New(counters, "join_user", ratelimit.Rule{Limit: 1, Window: time.Hour}))
+    listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+    limits := router.Limits{Login: ratelimit.New(counters, "login_ip", ratelimit.Rule{Limit: 5, Window: time.Hour})}
+    r := router.NewRouter(authSvc, limits, handlers.NewAuthHandler(authSvc), handlers.NewUserHandler(userSvc, []byte("salt")), handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt")), handlers.NewListHandler(listSvc))
+
+    // Two wrong passwords lock the account, even for the right one.
+    doPostJSON[any](t, r, "/auth/register", map[string]string{"username": "a@b.com", "password": "password123", "name": "Ana"}, nil, http.StatusCreated)
+    doPostJSON[any](t, r, "/auth/login", map[string]string{"username": "a@b.com", "password": "wrong"}, nil, http.StatusUnauthorized)
+    doPostJSON[any](t, r, "/auth/login", map[string]string{"username": "a@b.com", "password": "wrong"}, nil, http.StatusUnauthorized)
+    req, _ := http.NewRequest("POST", "/auth/login", strings.NewReader(`{"username":"a@b.com","password":"password123"}`))
+    rr := httptest.NewRecorder()
+    r.ServeHTTP(rr, req)
+    var limited struct{ Error string; RetryAfter int `json:"retry_after"` }
+    _ = json.NewDecoder(rr.Body).Decode(&limited)
+    if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" || limited.RetryAfter <= 0 { t.Fatalf("locked login: %d %q %+v", rr.Code, rr.Header().Get("Retry-After"), limited) }
+
+    // Every login counts against the client's limit of five, refused ones too.
+    doPostJSON[any](t, r, "/auth/login", map[string]string{"username": "b@b.com", "password": "wrong"}, nil, http.StatusUnauthorized)
+    doPostJSON[any](t, r, "/auth/login", map[string]string{"username": "c@b.com", "password": "wrong"}, nil, http.StatusUnauthorized)
+    doPostJSON[any](t, r, "/auth/login", map[string]string{"username": "d@b.com", "password": "wrong"}, nil, http.StatusTooManyRequests)
+
+    // One wrong share code and joining is refused for a while.
+    var a, b struct{ APIKey string `json:"api_key"` }
+    doPostJSON(t, r, "/users", map[string]string{"name": "Alice"}, &a, http.StatusCreated)
+    doPostJSON(t, r, "/users", map[string]string{"name": "Bob"}, &b, http.StatusCreated)
+    var share struct{ Token string `json:"token"` }
+    doPostAuthJSON(t, r, "/rooms/share", a.APIKey, nil, &share, http.StatusOK)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]string{"token": "ZZZZZ"}, nil, http.StatusNotFound)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]string{"token": share.Token}, nil, http.StatusTooManyRequests)
+}
+
+func TestOIDCLogin(t *testing.T) {
+    tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    mock := oidcmock.New(t, "gracie", "", oidcmock.User{Subject: "sub-1", Email: "ann@example.com", EmailVerified: true, Name: "Ann"})
+    authSvc.UseOIDC("https://gracie.example/oidc/callback", oidc.New(oidc.Config{ID: "mock", Name: "Mock", Issuer: mock.Issuer(), ClientID: "gracie"}, nil))
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
+    roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
+    listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+    r := router.NewRouter(authSvc, router.Limits{}, handlers.NewAuthHandler(authSvc), handlers.NewUserHandler(userSvc, []byte("salt")), handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt")), handlers.NewListHandler(listSvc))
+
+    var providers struct{ Providers []struct{ ID, Name string } }
+    req, _ := http.NewRequest("GET", "/auth/oidc/providers", nil)
+    rr := httptest.NewRecorder()
+    r.ServeHTTP(rr, req)
+    _ = json.NewDecoder(rr.Body).Decode(&providers)
+    if rr.Code != http.StatusOK || len(providers.Providers) != 1 || providers.Providers[0].ID != "mock" { t.Fatalf("providers: %d %+v", rr.Code, providers) }
+
+    doPostJSON[any](t, r, "/auth/oidc/nope/start", nil, nil, http.StatusNotFound)
+    var start struct{ AuthURL string `json:"auth_url"`; Flow string }
+    doPostJSON(t, r, "/auth/oidc/mock/start", nil, &start, http.StatusOK)
+    code, state, err := mock.Authorize(start.AuthURL)
+    if err != nil { t.Fatalf("authorize: %v", err) }
+    doPostJSON[any](t, r, "/auth/oidc/mock/callback", map[string]string{"code": code, "state": "forged", "flow": start.Flow}, nil, http.StatusUnauthorized)
+    doPostJSON(t, r, "/auth/oidc/mock/start", nil, &start, http.StatusOK)
+    code, state, _ = mock.Authorize(start.AuthURL)
+    var login struct{ AccessToken string `json:"access_token"`; User struct{ Username string } }
+    doPostJSON(t, r, "/auth/oidc/mock/callback", map[string]string{"code": code, "state": state, "flow": start.Flow}, &login, http.StatusOK)
+    if login.AccessToken == "" || login.User.Username != "ann@example.com" { t.Fatalf("callback: %+v", login) }
+    doGetAuthJSON[any](t, r, "/me", login.AccessToken, nil, http.StatusOK)
+}
+
+func TestMultipleRooms(t *testing.T) {
+    tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
+    roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
+    listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+    r := router.NewRouter(authSvc, router.Limits{}, handlers.NewAuthHandler(authSvc), handlers.NewUserHandler(userSvc, []byte("salt")), handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt")), handlers.NewListHandler(listSvc))
+
+    var a, b struct{ User struct{ RoomID string `json:"room_id"` }; APIKey string `json:"api_key"` }
+    doPostJSON(t, r, "/users", map[string]string{"name": "Alice"}, &a, http.StatusCreated)
+    doPostJSON(t, r, "/users", map[string]string{"name": "Bob"}, &b, http.StatusCreated)
+    var share struct{ Token string `json:"token"` }
+    doPostAuthJSON(t, r, "/rooms/share", a.APIKey, nil, &share, http.StatusOK)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]string{"token": share.Token}, nil, http.StatusOK)
+
+    var rooms struct{ Rooms []struct{ RoomID string `json:"room_id"`; Active bool; Members []string } }
+    doGetAuthJSON(t, r, "/rooms", b.APIKey, &rooms, http.StatusOK)
+    if len(rooms.Rooms) != 2 || rooms.Rooms[0].RoomID != b.User.RoomID || rooms.Rooms[0].Active || !rooms.Rooms[1].Active || len(rooms.Rooms[1].Members) != 2 {
+        t.Fatalf("rooms: %+v", rooms)
+    }
+    // B still reaches the lists of the room they had before joining.
+    doPostAuthJSON[any](t, r, "/rooms/"+b.User.RoomID+"/lists", b.APIKey, map[string]string{"name": "Groceries"}, nil, http.StatusCreated)
+
+    doPutAuthJSON[any](t, r, "/rooms/active", b.APIKey, map[string]string{"room_id": "room_nope"}, nil, http.StatusNotFound)
+    doPutAuthJSON[any](t, r, "/rooms/active", a.APIKey, map[string]string{"room_id": b.User.RoomID}, nil, http.StatusForbidden)
+    doPutAuthJSON[any](t, r, "/rooms/active", b.APIKey, map[string]string{"room_id": b.User.RoomID}, nil, http.StatusOK)
+    var me struct{ RoomID string `json:"room_id"` }
+    doGetAuthJSON(t, r, "/me", b.APIKey, &me, http.StatusOK)
+    if me.RoomID != b.User.RoomID { t.Fatalf("active room not switched: %+v", me) }
+}
+
+func TestRoomRoles(t *testing.T) {
+    tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
+    roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
+    listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+    r := router.NewRouter(authSvc, router.Limits{}, handlers.NewAuthHandler(authSvc), handlers.NewUserHandler(userSvc, []byte("salt")), handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt")), handlers.NewListHandler(listSvc))
+
+    var a, b struct{ User struct{ RoomID string `json:"room_id"` }; APIKey string `json:"api_key"` }
+    doPostJSON(t, r, "/users", map[string]string{"name": "Alice"}, &a, http.StatusCreated)
+    doPostJSON(t, r, "/users", map[string]string{"name": "Bob"}, &b, http.StatusCreated)
+    var share struct{ Token string `json:"token"` }
+    doPostAuthJSON(t, r, "/rooms/share", a.APIKey, nil, &share, http.StatusOK)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]string{"token": share.Token}, nil, http.StatusOK)
+
+    type roomView struct {
+        MyRole        string   `json:"my_role"`
+        MyPermissions []string `json:"my_permissions"`
+        MembersMeta   []struct{ Name, Role string; AvatarKey string `json:"avatar_key"` } `json:"members_meta"`
+    }
+    var view roomView
+    doGetAuthJSON(t, r, "/rooms/me", b.APIKey, &view, http.StatusOK)
+    if view.MyRole != "member" || len(view.MembersMeta) != 2 || view.MembersMeta[0].Role != "owner" || view.MembersMeta[1].Role != "member" {
+        t.Fatalf("member view: %+v", view)
+    }
+    bobKey := view.MembersMeta[1].AvatarKey
+
+    // Members cannot share the house; the owner makes Bob a guest.
+    doPostAuthJSON[any](t, r, "/rooms/share", b.APIKey, nil, nil, http.StatusForbidden)
+    doPutAuthJSON[any](t, r, "/rooms/members/"+bobKey+"/role", b.APIKey, map[string]string{"role": "guest"}, nil, http.StatusBadRequest)
+    doPutAuthJSON[any](t, r, "/rooms/members/"+bobKey+"/role", a.APIKey, map[string]string{"role": "king"}, nil, http.StatusBadRequest)
+    doPutAuthJSON[any](t, r, "/rooms/members/nobody/role", a.APIKey, map[string]string{"role": "guest"}, nil, http.StatusNotFound)
+    doPutAuthJSON[any](t, r, "/rooms/members/"+bobKey+"/role", a.APIKey, map[string]string{"role": "guest"}, nil, http.StatusOK)
+    doGetAuthJSON(t, r, "/rooms/me", b.APIKey, &view, http.StatusOK)
+    if view.MyRole != "guest" || len(view.MyPermissions) != 1 || view.MyPermissions[0] != "check_items" { t.Fatalf("guest view: %+v", view) }
+    doPostAuthJSON[any](t, r, "/rooms/"+a.User.RoomID+"/lists", b.APIKey, map[string]string{"name": "Groceries"}, nil, http.StatusForbidden)
+    doPostAuthJSON[any](t, r, "/rooms/deletion/vote", b.APIKey, nil, nil, http.StatusForbidden)
+}
+
+func TestRoomInvites(t *testing.T) {
+    tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
+    roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
+    roomSvc.UseInvites(memstore.NewInviteRepo(memstore.NewStore()))
+    listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+    r := router.NewRouter(authSvc, router.Limits{}, handlers.NewAuthHandler(authSvc), handlers.NewUserHandler(userSvc, []byte("salt")), handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt")), handlers.NewListHandler(listSvc))
+
+    var a, b, c struct{ APIKey string `json:"api_key"` }
+    doPostJSON(t, r, "/users", map[string]string{"name": "Alice"}, &a, http.StatusCreated)
+    doPostJSON(t, r, "/users", map[string]string{"name": "Bob"}, &b, http.StatusCreated)
+    doPostJSON(t, r, "/users", map[string]string{"name": "Cleo"}, &c, http.StatusCreated)
+
+    type invite struct {
+        InviteID  string `json:"invite_id"`
+        Name      string `json:"name"`
+        Code      string `json:"code"`
+        LinkToken string `json:"link_token"`
+        MaxUses   int    `json:"max_uses"`
+        Uses      int    `json:"uses"`
+        CreatedBy string `json:"created_by"`
+    }
+    var inv invite
+    doPostAuthJSON[any](t, r, "/rooms/invites", a.APIKey, map[string]any{"expires_in_hours": 24 * 365}, nil, http.StatusBadRequest)
+    doPostAuthJSON(t, r, "/rooms/invites", a.APIKey, map[string]any{"name": "Roommates", "max_uses": 2, "link": true}, &inv, http.StatusCreated)
+    if inv.Code == "" || inv.LinkToken == "" || inv.CreatedBy != "Alice" || inv.MaxUses != 2 { t.Fatalf("create: %+v", inv) }
+
+    // Both roommates join with the same invite.
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]string{"token": inv.Code}, nil, http.StatusOK)
+    doPostAuthJSON[any](t, r, "/rooms/join", c.APIKey, map[string]string{"token": inv.LinkToken}, nil, http.StatusOK)
+    var list struct{ Invites []invite `json:"invites"` }
+    doGetAuthJSON(t, r, "/rooms/invites", a.APIKey, &list, http.StatusOK)
+    if len(list.Invites) != 0 { t.Fatalf("used up invite listed: %+v", list) }
+
+    // Members neither see nor manage invites; revoking takes effect.
+    doGetAuthJSON[any](t, r, "/rooms/invites", b.APIKey, nil, http.StatusForbidden)
+    doPostAuthJSON(t, r, "/rooms/invites", a.APIKey, map[string]any{}, &inv, http.StatusCreated)
+    doGetAuthJSON(t, r, "/rooms/invites", a.APIKey, &list, http.StatusOK)
+    if len(list.Invites) != 1 || list.Invites[0].Uses != 0 || list.Invites[0].MaxUses != 0 { t.Fatalf("list: %+v", list) }
+    for _, tc := range []struct{ key, id string; want int }{{b.APIKey, inv.InviteID, http.StatusForbidden}, {a.APIKey, "inv_nope", http.StatusNotFound}, {a.APIKey, inv.InviteID, http.StatusNoContent}} {
+        req, _ := http.NewRequest("DELETE", "/rooms/invites/"+tc.id, nil)
+        req.Header.Set("Authorization", "Bearer "+tc.key)
+        rr := httptest.NewRecorder()
+        r.ServeHTTP(rr, req)
+        if rr.Code != tc.want { t.Fatalf("DELETE %s: want %d got %d", tc.id, tc.want, rr.Code) }
+    }
+    doGetAuthJSON(t, r, "/rooms/invites", a.APIKey, &list, http.StatusOK)
+    if len(list.Invites) != 0 { t.Fatalf("revoked invite listed: %+v", list) }
+}
+
+func TestLeaveRoom(t *testing.T) {
+    tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
+    roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
+    roomSvc.UseLists(listsRepo, itemsRepo)
+    listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+    r := router.NewRouter(authSvc, router.Limits{}, handlers.NewAuthHandler(authSvc), handlers.NewUserHandler(userSvc, []byte("salt")), handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt")), handlers.NewListHandler(listSvc))
+
+    var a, b, c struct{ APIKey string `json:"api_key"` }
+    doPostJSON(t, r, "/users", map[string]string{"name": "Alice"}, &a, http.StatusCreated)
+    doPostJSON(t, r, "/users", map[string]string{"name": "Bob"}, &b, http.StatusCreated)
+    doPostJSON(t, r, "/users", map[string]string{"name": "Cleo"}, &c, http.StatusCreated)
+    for _, key := range []string{b.APIKey, c.APIKey} {
+        var share struct{ Token string `json:"token"` }
+        doPostAuthJSON(t, r, "/rooms/share", a.APIKey, nil, &share, http.StatusOK)
+        doPostAuthJSON[any](t, r, "/rooms/join", key, map[string]string{"token": share.Token}, nil, http.StatusOK)
+    }
+    type member struct {
+        Name      string `json:"name"`
+        AvatarKey string `json:"avatar_key"`
+        Role      string `json:"role"`
+    }
+    var room struct{ MembersMeta []member `json:"members_meta"` }
+    doGetAuthJSON(t, r, "/rooms/me", a.APIKey, &room, http.StatusOK)
+    cleo := room.MembersMeta[2].AvatarKey
+
+    // Only the owner names who takes over, and only a member of the room.
+    doPostAuthJSON[any](t, r, "/rooms/leave", b.APIKey, map[string]string{"new_owner": cleo}, nil, http.StatusForbidden)
+    doPostAuthJSON[any](t, r, "/rooms/leave", a.APIKey, map[string]string{"new_owner": "nobody"}, nil, http.StatusNotFound)
+    var solo struct {
+        RoomID  string   `json:"room_id"`
+        Members []string `json:"members"`
+        Active  bool     `json:"active"`
+    }
+    doPostAuthJSON(t, r, "/rooms/leave", a.APIKey, map[string]string{"new_owner": cleo}, &solo, http.StatusOK)
+    if solo.RoomID == "" || !solo.Active || len(solo.Members) != 1 || solo.Members[0] != "Alice" { t.Fatalf("solo room: %+v", solo) }
+    doGetAuthJSON(t, r, "/rooms/me", b.APIKey, &room, http.StatusOK)
+    if len(room.MembersMeta) != 2 || room.MembersMeta[1].Name != "Cleo" || room.MembersMeta[1].Role != "owner" { t.Fatalf("room after owner left: %+v", room) }
+
+    // Without a body a member just leaves; the last member cannot.
+    doPostAuthJSON[any](t, r, "/rooms/leave", b.APIKey, nil, nil, http.StatusOK)
+    doPostAuthJSON[any](t, r, "/rooms/leave", c.APIKey, nil, nil, http.StatusConflict)
+
+    // Rejoining, Bob folds the solo room he was given back into the house.
+    var share struct{ Token string `json:"token"` }
+    doPostAuthJSON(t, r, "/rooms/share", c.APIKey, nil, &share, http.StatusOK)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]any{"token": share.Token, "solo_room": "nope"}, nil, http.StatusBadRequest)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]any{"token": share.Token, "dedupe_lists": true}, nil, http.StatusBadRequest)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]any{"token": share.Token, "solo_room": "discard", "dedupe_lists": true}, nil, http.StatusBadRequest)
+    doPostAuthJSON[any](t, r, "/rooms/join", b.APIKey, map[string]any{"token": share.Token, "solo_room": "merge", "dedupe_lists": true}, nil, http.StatusOK)
+    var rooms struct{ Rooms []struct{ Members []string `json:"members"` } `json:"rooms"` }
+    doGetAuthJSON(t, r, "/rooms", b.APIKey, &rooms, http.StatusOK)
+    if len(rooms.Rooms) != 2 || len(rooms.Rooms[1].Members) != 2 { t.Fatalf("Bob's rooms after merging: %+v", rooms) }
+}
+
+// outbox is a mail.Mailer that keeps what it is sent.
+type outbox struct{ sent []mail.Message }
+
+func (o *outbox) Send(_ context.Context, m mail.Message) error {
+    o.sent = append(o.sent, m)
+    return nil
 }
 
 // Helpers using in-process router.ServeHTTP (no network)
@@ -84,6 +424,19 @@ func doPostAuthJSON[T any](t *testing.T, h http.Handler, path, apiKey string, bo
     if out != nil { _ = json.NewDecoder(rr.Body).Decode(out) }
 }
 
+func doPutAuthJSON[T any](t *testing.T, h http.Handler, path, apiKey string, body any, out *T, want int) {
+    t.Helper()
+    var buf bytes.Buffer
+    if body != nil { _ = json.NewEncoder(&buf).Encode(body) }
+    req, _ := http.NewRequest("PUT", path, &buf)
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+apiKey)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != want { t.Fatalf("%s %s: want %d got %d", req.Method, path, want, rr.Code) }
+    if out != nil { _ = json.NewDecoder(rr.Body).Decode(out) }
+}
+
 func doGetAuthJSON[T any](t *testing.T, h http.Handler, path, apiKey string, out *T, want int) {
     t.Helper()
     req, _ := http.NewRequest("GET", path, nil)
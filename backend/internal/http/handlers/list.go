@@ -41,6 +41,7 @@ func (h *ListHandler) CreateList(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, code, map[string]string{"error": err.Error()})
 		return
 	}
+	api.SetETag(w, l.Version)
 	api.WriteJSON(w, http.StatusCreated, l)
 }
 
@@ -51,14 +52,37 @@ func (h *ListHandler) ListLists(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	roomID := chi.URLParam(r, "room_id")
-	ls, err := h.Lists.ListLists(r.Context(), u, roomID)
+	page, err := api.PageFrom(r)
+	if err != nil {
+		api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		return
+	}
+	ls, next, err := h.Lists.ListLists(r.Context(), u, roomID, page)
 	if err != nil {
 		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
 		return
 	}
+	api.SetNextLink(w, r, next)
 	api.WriteJSON(w, http.StatusOK, ls)
 }
 
+func (h *ListHandler) GetList(w http.ResponseWriter, r *http.Request) {
+	u, ok := api.UserFrom(r.Context())
+	if !ok {
+		api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	roomID := chi.URLParam(r, "room_id")
+	listID := chi.URLParam(r, "list_id")
+	l, err := h.Lists.GetList(r.Context(), u, roomID, listID)
+	if err != nil {
+		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
+		return
+	}
+	api.SetETag(w, l.Version)
+	api.WriteJSON(w, http.StatusOK, l)
+}
+
 func (h *ListHandler) GetPantry(w http.ResponseWriter, r *http.Request) {
 	u, ok := api.UserFrom(r.Context())
 	if !ok {
@@ -129,11 +153,21 @@ func (h *ListHandler) UpdateList(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	l, err := h.Lists.UpdateList(r.Context(), u, roomID, listID, req.Name, req.Description, req.Icon, req.Notes)
+	ifVersion, err := api.IfMatch(r)
+	if err != nil {
+		api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid If-Match"})
+		return
+	}
+	l, err := h.Lists.UpdateList(r.Context(), u, roomID, listID, req.Name, req.Description, req.Icon, req.Notes, ifVersion)
+	if err == derr.ErrPreconditionFailed {
+		h.writeCurrentList(w, r, roomID, listID, err)
+		return
+	}
 	if err != nil {
 		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
 		return
 	}
+	api.SetETag(w, l.Version)
 	api.WriteJSON(w, http.StatusOK, l)
 }
 
@@ -163,6 +197,7 @@ func (h *ListHandler) CreateItem(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
 		return
 	}
+	api.SetETag(w, it.Version)
 	api.WriteJSON(w, http.StatusCreated, it)
 }
 
@@ -180,14 +215,38 @@ func (h *ListHandler) ListItems(w http.ResponseWriter, r *http.Request) {
 		b, _ := strconv.ParseBool(q)
 		includeCompleted = b
 	}
-	items, err := h.Lists.ListItems(r.Context(), u, roomID, listID, includeCompleted)
+	page, err := api.PageFrom(r)
+	if err != nil {
+		api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		return
+	}
+	items, next, err := h.Lists.ListItems(r.Context(), u, roomID, listID, includeCompleted, page)
 	if err != nil {
 		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
 		return
 	}
+	api.SetNextLink(w, r, next)
 	api.WriteJSON(w, http.StatusOK, items)
 }
 
+func (h *ListHandler) GetItem(w http.ResponseWriter, r *http.Request) {
+	u, ok := api.UserFrom(r.Context())
+	if !ok {
+		api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	roomID := chi.URLParam(r, "room_id")
+	listID := chi.URLParam(r, "list_id")
+	itemID := chi.URLParam(r, "item_id")
+	it, err := h.Lists.GetItem(r.Context(), u, roomID, listID, itemID)
+	if err != nil {
+		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
+		return
+	}
+	api.SetETag(w, it.Version)
+	api.WriteJSON(w, http.StatusOK, it)
+}
+
 type updateItemReq struct {
 	Description *string `json:"description"`
 	Completed   *bool   `json:"completed"`
@@ -211,11 +270,21 @@ func (h *ListHandler) UpdateItem(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
 		return
 	}
-	it, err := h.Lists.UpdateItem(r.Context(), u, roomID, listID, itemID, req.Description, req.Completed, req.Quantity, req.Unit, req.Category, req.Starred)
+	ifVersion, err := api.IfMatch(r)
+	if err != nil {
+		api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid If-Match"})
+		return
+	}
+	it, err := h.Lists.UpdateItem(r.Context(), u, roomID, listID, itemID, req.Description, req.Completed, req.Quantity, req.Unit, req.Category, req.Starred, ifVersion)
+	if err == derr.ErrPreconditionFailed {
+		h.writeCurrentItem(w, r, roomID, listID, itemID, err)
+		return
+	}
 	if err != nil {
 		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
 		return
 	}
+	api.SetETag(w, it.Version)
 	api.WriteJSON(w, http.StatusOK, it)
 }
 
@@ -250,6 +319,55 @@ func (h *ListHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Trash endpoints
+func (h *ListHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	u, ok := api.UserFrom(r.Context())
+	if !ok {
+		api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	roomID := chi.URLParam(r, "room_id")
+	trash, err := h.Lists.GetTrash(r.Context(), u, roomID)
+	if err != nil {
+		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, trash)
+}
+
+func (h *ListHandler) RestoreList(w http.ResponseWriter, r *http.Request) {
+	u, ok := api.UserFrom(r.Context())
+	if !ok {
+		api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	roomID := chi.URLParam(r, "room_id")
+	listID := chi.URLParam(r, "list_id")
+	l, err := h.Lists.RestoreList(r.Context(), u, roomID, listID)
+	if err != nil {
+		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, l)
+}
+
+func (h *ListHandler) RestoreItem(w http.ResponseWriter, r *http.Request) {
+	u, ok := api.UserFrom(r.Context())
+	if !ok {
+		api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	roomID := chi.URLParam(r, "room_id")
+	listID := chi.URLParam(r, "list_id")
+	itemID := chi.URLParam(r, "item_id")
+	it, err := h.Lists.RestoreItem(r.Context(), u, roomID, listID, itemID)
+	if err != nil {
+		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, it)
+}
+
 // Reorder endpoint
 type updateItemPositionReq struct {
 	PrevID *string `json:"prev_id"`
@@ -270,14 +388,50 @@ func (h *ListHandler) UpdateItemPosition(w http.ResponseWriter, r *http.Request)
 		api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
 		return
 	}
-	it, err := h.Lists.UpdateItemPosition(r.Context(), u, roomID, listID, itemID, req.PrevID, req.NextID)
+	ifVersion, err := api.IfMatch(r)
+	if err != nil {
+		api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid If-Match"})
+		return
+	}
+	it, err := h.Lists.UpdateItemPosition(r.Context(), u, roomID, listID, itemID, req.PrevID, req.NextID, ifVersion)
+	if err == derr.ErrPreconditionFailed {
+		h.writeCurrentItem(w, r, roomID, listID, itemID, err)
+		return
+	}
 	if err != nil {
 		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
 		return
 	}
+	api.SetETag(w, it.Version)
 	api.WriteJSON(w, http.StatusOK, it)
 }
 
+// writeCurrentList answers a failed If-Match with 412 and the list as it is
+// now, so the client can merge; if the list cannot be read it reports err.
+func (h *ListHandler) writeCurrentList(w http.ResponseWriter, r *http.Request, roomID, listID string, err error) {
+	u, _ := api.UserFrom(r.Context())
+	cur, gerr := h.Lists.GetList(r.Context(), u, roomID, listID)
+	if gerr != nil {
+		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
+		return
+	}
+	api.SetETag(w, cur.Version)
+	api.WriteJSON(w, http.StatusPreconditionFailed, cur)
+}
+
+// writeCurrentItem answers a failed If-Match with 412 and the item as it is
+// now, so the client can merge; if the item cannot be read it reports err.
+func (h *ListHandler) writeCurrentItem(w http.ResponseWriter, r *http.Request, roomID, listID, itemID string, err error) {
+	u, _ := api.UserFrom(r.Context())
+	cur, gerr := h.Lists.GetItem(r.Context(), u, roomID, listID, itemID)
+	if gerr != nil {
+		api.WriteJSON(w, statusFromErr(err), map[string]string{"error": err.Error()})
+		return
+	}
+	api.SetETag(w, cur.Version)
+	api.WriteJSON(w, http.StatusPreconditionFailed, cur)
+}
+
 func statusFromErr(err error) int {
 	switch err {
 	case derr.ErrUnauthorized:
@@ -290,6 +444,8 @@ func statusFromErr(err error) int {
 		return http.StatusConflict
 	case derr.ErrBadRequest:
 		return http.StatusBadRequest
+	case derr.ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
 	default:
 		return http.StatusBadRequest
 	}
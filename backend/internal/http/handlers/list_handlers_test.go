@@ -5,6 +5,7 @@ import (
     "encoding/json"
     "net/http"
     "net/http/httptest"
+    "strings"
     "testing"
 
     handlers "github.com/janvillarosa/gracie-app/backend/internal/http/handlers"
@@ -17,17 +18,17 @@ import (
 func TestListsFlow(t *testing.T) {
     tx, usersRepo, roomsRepo, listsRepo, itemsRepo := memstore.Compose()
 
-    userSvc := services.NewUserService(usersRepo, roomsRepo, tx)
+    authSvc, err := services.NewAuthService(usersRepo, memstore.NewSessionRepo(memstore.NewStore()), "/tmp/gracie-test-enc.key", 720)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    userSvc := services.NewUserService(usersRepo, roomsRepo, tx, authSvc)
     roomSvc := services.NewRoomService(usersRepo, roomsRepo, tx)
     listSvc := services.NewListService(usersRepo, roomsRepo, listsRepo, itemsRepo, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
-    authSvc, err := services.NewAuthService(usersRepo, "/tmp/gracie-test-enc.key", 720)
-    if err != nil { t.Fatalf("auth svc: %v", err) }
 
     ah := handlers.NewAuthHandler(authSvc)
     uh := handlers.NewUserHandler(userSvc, []byte("salt"))
     rh := handlers.NewRoomHandler(roomSvc, usersRepo, []byte("salt"))
     lh := handlers.NewListHandler(listSvc)
-    r := router.NewRouter(usersRepo, ah, uh, rh, lh)
+    r := router.NewRouter(authSvc, router.Limits{}, ah, uh, rh, lh)
 
     // Create user A and B; B joins A via token
     aResp := struct{ User struct{ UserID string; RoomID *string }; APIKey string `json:"api_key"` }{}
@@ -107,6 +108,75 @@ func TestListsFlow(t *testing.T) {
     var lists []map[string]any
     getAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists", aResp.APIKey, &lists, http.StatusOK)
     if len(lists) != 0 { t.Fatalf("expected 0 lists after deletion, got %d", len(lists)) }
+
+    // The deleted list and the earlier deleted item2 sit in the trash until restored.
+    var trash struct {
+        Lists []struct{ ListID string `json:"list_id"`; PurgeAt string `json:"purge_at"` } `json:"lists"`
+        Items []struct{ ItemID string `json:"item_id"` } `json:"items"`
+    }
+    getAuthJSONLocal(t, r, "/rooms/"+roomID+"/trash", bResp.APIKey, &trash, http.StatusOK)
+    if len(trash.Lists) != 1 || trash.Lists[0].ListID != list.ListID || trash.Lists[0].PurgeAt == "" { t.Fatalf("unexpected trash lists %+v", trash.Lists) }
+    if len(trash.Items) != 0 { t.Fatalf("items of a trashed list listed on their own: %+v", trash.Items) }
+    postAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists/"+list.ListID+"/items/"+item2.ItemID+"/restore", aResp.APIKey, nil, &struct{}{}, http.StatusConflict)
+    postAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists/"+list.ListID+"/restore", aResp.APIKey, nil, &struct{}{}, http.StatusOK)
+    postAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists/"+list.ListID+"/restore", aResp.APIKey, nil, &struct{}{}, http.StatusNotFound)
+    trash.Lists, trash.Items = nil, nil
+    getAuthJSONLocal(t, r, "/rooms/"+roomID+"/trash", aResp.APIKey, &trash, http.StatusOK)
+    if len(trash.Lists) != 0 || len(trash.Items) != 1 || trash.Items[0].ItemID != item2.ItemID { t.Fatalf("unexpected trash after list restore %+v", trash) }
+    postAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists/"+list.ListID+"/items/"+item2.ItemID+"/restore", aResp.APIKey, nil, &struct{}{}, http.StatusOK)
+    items = nil
+    getAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists/"+list.ListID+"/items?include_completed=true", aResp.APIKey, &items, http.StatusOK)
+    if len(items) != 5 { t.Fatalf("expected 5 items after restores, got %d", len(items)) }
+
+    // Paging with limit follows the Link header through the same items.
+    var paged []any
+    next := "/rooms/" + roomID + "/lists/" + list.ListID + "/items?include_completed=true&limit=2"
+    for pages := 0; next != ""; pages++ {
+        if pages == 3 { t.Fatalf("more than 3 pages of 2 for 5 items") }
+        req, _ := http.NewRequest("GET", next, nil)
+        req.Header.Set("Authorization", "Bearer "+aResp.APIKey)
+        rr := httptest.NewRecorder()
+        r.ServeHTTP(rr, req)
+        if rr.Code != http.StatusOK { t.Fatalf("paged items: want 200 got %d", rr.Code) }
+        var page []any
+        _ = json.NewDecoder(rr.Body).Decode(&page)
+        paged = append(paged, page...)
+        next = strings.TrimSuffix(strings.TrimPrefix(rr.Header().Get("Link"), "<"), `>; rel="next"`)
+    }
+    if len(paged) != 5 { t.Fatalf("expected 5 paged items, got %d", len(paged)) }
+    getAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists?limit=0", aResp.APIKey, &lists, http.StatusBadRequest)
+    getAuthJSONLocal(t, r, "/rooms/"+roomID+"/lists?limit=1&cursor=bogus", aResp.APIKey, &lists, http.StatusBadRequest)
+
+    // Conditional updates: If-Match takes the ETag of the last read.
+    itemPath := "/rooms/" + roomID + "/lists/" + list.ListID + "/items/" + item1.ItemID
+    send := func(method, path, ifMatch string, body any) *httptest.ResponseRecorder {
+        var buf bytes.Buffer
+        if body != nil { _ = json.NewEncoder(&buf).Encode(body) }
+        req, _ := http.NewRequest(method, path, &buf)
+        req.Header.Set("Authorization", "Bearer "+aResp.APIKey)
+        if ifMatch != "" { req.Header.Set("If-Match", ifMatch) }
+        rr := httptest.NewRecorder()
+        r.ServeHTTP(rr, req)
+        return rr
+    }
+    rr = send("GET", itemPath, "", nil)
+    etag := rr.Header().Get("ETag")
+    if rr.Code != http.StatusOK || etag == "" { t.Fatalf("get item: %d etag %q", rr.Code, etag) }
+    rr = send("PATCH", itemPath, etag, map[string]any{"description": "Oat milk"})
+    if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag { t.Fatalf("conditional patch: %d etag %q", rr.Code, rr.Header().Get("ETag")) }
+    current := rr.Header().Get("ETag")
+    rr = send("PATCH", itemPath, etag, map[string]any{"description": "Soy milk"})
+    if rr.Code != http.StatusPreconditionFailed || rr.Header().Get("ETag") != current { t.Fatalf("stale patch: %d etag %q", rr.Code, rr.Header().Get("ETag")) }
+    stale := struct{ Description string `json:"description"` }{}
+    _ = json.NewDecoder(rr.Body).Decode(&stale)
+    if stale.Description != "Oat milk" { t.Fatalf("412 body should be the current item, got %+v", stale) }
+    if rr = send("PATCH", itemPath, "soon", map[string]any{"description": "x"}); rr.Code != http.StatusBadRequest { t.Fatalf("malformed If-Match: %d", rr.Code) }
+    if rr = send("PATCH", itemPath, "*", map[string]any{"description": "Milk"}); rr.Code != http.StatusOK { t.Fatalf("If-Match *: %d", rr.Code) }
+
+    listPath := "/rooms/" + roomID + "/lists/" + list.ListID
+    rr = send("GET", listPath, "", nil)
+    if rr.Code != http.StatusOK || rr.Header().Get("ETag") == "" { t.Fatalf("get list: %d etag %q", rr.Code, rr.Header().Get("ETag")) }
+    if rr = send("PATCH", listPath, `"999"`, map[string]any{"name": "Food"}); rr.Code != http.StatusPreconditionFailed { t.Fatalf("stale list patch: %d", rr.Code) }
 }
 
 // local helper for PATCH authenticated JSON
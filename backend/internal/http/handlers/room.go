@@ -1,11 +1,15 @@
 package handlers
 
 import (
+    "errors"
+    "io"
     "net/http"
+    "time"
 
     "github.com/go-chi/chi/v5"
     api "github.com/janvillarosa/gracie-app/backend/internal/http"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
     "github.com/janvillarosa/gracie-app/backend/internal/services"
     "github.com/janvillarosa/gracie-app/backend/internal/store"
     "github.com/janvillarosa/gracie-app/backend/pkg/ids"
@@ -32,11 +36,27 @@ func (h *RoomHandler) GetMyRoom(w http.ResponseWriter, r *http.Request) {
         code := http.StatusInternalServerError
         if err == derr.ErrNotFound {
             code = http.StatusNotFound
+        } else if err == derr.ErrForbidden {
+            code = http.StatusForbidden
         }
         api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
     }
-    // Build a view without internal IDs
+    myVote := false
+    if rm.DeletionVotes != nil {
+        if _, ok := rm.DeletionVotes[u.UserID]; ok { myVote = true }
+    }
+    view := h.view(r, rm)
+    view["my_deletion_vote"] = myVote
+    view["my_role"] = rm.RoleOf(u.UserID)
+    view["my_permissions"] = services.PermissionsOf(rm.RoleOf(u.UserID))
+    api.SetETag(w, rm.Version)
+    api.WriteJSON(w, http.StatusOK, view)
+}
+
+// view builds the room representation returned to members, without internal
+// IDs. Members are told apart by avatar_key, which SetMemberRole takes.
+func (h *RoomHandler) view(r *http.Request, rm *models.Room) map[string]any {
     members := []string{}
     membersMeta := make([]map[string]any, 0, len(rm.MemberIDs))
     for _, mid := range rm.MemberIDs {
@@ -45,22 +65,72 @@ func (h *RoomHandler) GetMyRoom(w http.ResponseWriter, r *http.Request) {
             membersMeta = append(membersMeta, map[string]any{
                 "name": m.Name,
                 "avatar_key": ids.DeriveAvatarKey(m.UserID, h.AvatarSalt),
+                "role": rm.RoleOf(mid),
             })
         }
     }
-    myVote := false
-    if rm.DeletionVotes != nil {
-        if _, ok := rm.DeletionVotes[u.UserID]; ok { myVote = true }
-    }
-    view := map[string]any{
+    return map[string]any{
         "display_name": rm.DisplayName,
         "description":  rm.Description,
         "members":      members,
         "members_meta": membersMeta,
+        "version":      rm.Version,
         "created_at":   rm.CreatedAt,
         "updated_at":   rm.UpdatedAt,
-        "my_deletion_vote": myVote,
     }
+}
+
+// ListRooms returns every room the user belongs to. Unlike the other room
+// views these carry room_id, which SwitchRoom and the list routes take.
+func (h *RoomHandler) ListRooms(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    rooms, err := h.Rooms.ListRooms(r.Context(), u)
+    if err != nil {
+        api.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+        return
+    }
+    out := make([]map[string]any, 0, len(rooms))
+    for i := range rooms {
+        view := h.view(r, &rooms[i])
+        view["room_id"] = rooms[i].RoomID
+        view["active"] = u.RoomID != nil && *u.RoomID == rooms[i].RoomID
+        out = append(out, view)
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]any{"rooms": out})
+}
+
+type switchRoomReq struct {
+    RoomID string `json:"room_id"`
+}
+
+// SwitchRoom makes another of the user's rooms the active one.
+func (h *RoomHandler) SwitchRoom(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    var req switchRoomReq
+    if err := api.DecodeJSON(r, &req); err != nil || req.RoomID == "" {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    rm, err := h.Rooms.SwitchRoom(r.Context(), u, req.RoomID)
+    if err != nil {
+        code := http.StatusInternalServerError
+        if err == derr.ErrNotFound { code = http.StatusNotFound }
+        if err == derr.ErrForbidden { code = http.StatusForbidden }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    view := h.view(r, rm)
+    view["room_id"] = rm.RoomID
+    view["active"] = true
+    api.SetETag(w, rm.Version)
     api.WriteJSON(w, http.StatusOK, view)
 }
 
@@ -90,15 +160,121 @@ func (h *RoomHandler) ShareRoom(w http.ResponseWriter, r *http.Request) {
     }
     token, err := h.Rooms.RotateShareToken(r.Context(), u)
     if err != nil {
-        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+        code := http.StatusBadRequest
+        if err == derr.ErrForbidden { code = http.StatusForbidden }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
     }
     // Do not expose internal room_id
     api.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
 }
 
+type createInviteReq struct {
+    Name           string `json:"name"`
+    MaxUses        int    `json:"max_uses"`
+    ExpiresInHours int    `json:"expires_in_hours"`
+    Link           bool   `json:"link"`
+}
+
+// CreateInvite adds an invite to the active room.
+func (h *RoomHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    var req createInviteReq
+    if err := api.DecodeJSON(r, &req); err != nil {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    inv, err := h.Rooms.CreateInvite(r.Context(), u, services.InviteOptions{
+        Name:    req.Name,
+        MaxUses: req.MaxUses,
+        TTL:     time.Duration(req.ExpiresInHours) * time.Hour,
+        Link:    req.Link,
+    })
+    if err != nil {
+        writeInviteErr(w, err)
+        return
+    }
+    api.WriteJSON(w, http.StatusCreated, h.inviteView(r, inv))
+}
+
+// ListInvites returns the active room's usable invites with their use counts.
+func (h *RoomHandler) ListInvites(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    invs, err := h.Rooms.ListInvites(r.Context(), u)
+    if err != nil {
+        writeInviteErr(w, err)
+        return
+    }
+    out := make([]map[string]any, 0, len(invs))
+    for i := range invs {
+        out = append(out, h.inviteView(r, &invs[i]))
+    }
+    api.WriteJSON(w, http.StatusOK, map[string]any{"invites": out})
+}
+
+// RevokeInvite stops an invite of the active room from working.
+func (h *RoomHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    if err := h.Rooms.RevokeInvite(r.Context(), u, chi.URLParam(r, "invite_id")); err != nil {
+        writeInviteErr(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// inviteView is an invite as shown to members; the creator is named, not
+// identified.
+func (h *RoomHandler) inviteView(r *http.Request, inv *models.Invite) map[string]any {
+    view := map[string]any{
+        "invite_id":  inv.InviteID,
+        "name":       inv.Name,
+        "code":       inv.Code,
+        "max_uses":   inv.MaxUses,
+        "uses":       inv.Uses,
+        "created_at": inv.CreatedAt,
+        "expires_at": inv.ExpiresAt,
+    }
+    if inv.LinkToken != "" { view["link_token"] = inv.LinkToken }
+    if m, err := h.Users.GetByID(r.Context(), inv.CreatedBy); err == nil { view["created_by"] = m.Name }
+    return view
+}
+
+func writeInviteErr(w http.ResponseWriter, err error) {
+    code := http.StatusInternalServerError
+    switch err {
+    case derr.ErrBadRequest:
+        code = http.StatusBadRequest
+    case derr.ErrNotFound:
+        code = http.StatusNotFound
+    case derr.ErrForbidden:
+        code = http.StatusForbidden
+    }
+    api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// joinReq is the body of both join routes. solo_room is "merge" or "discard"
+// to fold the caller's solo room into the joined one or drop it; see
+// services.JoinOptions.
 type joinReq struct {
-    Token string `json:"token"`
+    Token       string                  `json:"token"`
+    SoloRoom    services.SoloRoomAction `json:"solo_room,omitempty"`
+    DedupeLists bool                    `json:"dedupe_lists,omitempty"`
+}
+
+func (req joinReq) options() services.JoinOptions {
+    return services.JoinOptions{SoloRoom: req.SoloRoom, DedupeLists: req.DedupeLists}
 }
 
 func (h *RoomHandler) JoinRoom(w http.ResponseWriter, r *http.Request) {
@@ -113,12 +289,13 @@ func (h *RoomHandler) JoinRoom(w http.ResponseWriter, r *http.Request) {
         api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
         return
     }
-    rm, err := h.Rooms.JoinRoom(r.Context(), u, roomID, req.Token)
+    rm, err := h.Rooms.JoinRoom(r.Context(), u, roomID, req.Token, req.options())
+    if api.WriteRateLimited(w, err) { return }
     if err != nil {
         code := http.StatusBadRequest
         if err == derr.ErrConflict {
             code = http.StatusConflict
-        } else if err == derr.ErrForbidden {
+        } else if err == derr.ErrForbidden || err == derr.ErrEmailUnverified {
             code = http.StatusForbidden
         }
         api.WriteJSON(w, code, map[string]string{"error": err.Error()})
@@ -158,11 +335,12 @@ func (h *RoomHandler) JoinByToken(w http.ResponseWriter, r *http.Request) {
         api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
         return
     }
-    rm, err := h.Rooms.JoinRoomByToken(r.Context(), u, req.Token)
+    rm, err := h.Rooms.JoinRoomByToken(r.Context(), u, req.Token, req.options())
+    if api.WriteRateLimited(w, err) { return }
     if err != nil {
         code := http.StatusBadRequest
         if err == derr.ErrConflict { code = http.StatusConflict }
-        if err == derr.ErrForbidden { code = http.StatusForbidden }
+        if err == derr.ErrForbidden || err == derr.ErrEmailUnverified { code = http.StatusForbidden }
         if err == derr.ErrNotFound { code = http.StatusNotFound }
         api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
@@ -196,7 +374,9 @@ func (h *RoomHandler) VoteDeletion(w http.ResponseWriter, r *http.Request) {
     }
     deleted, err := h.Rooms.VoteDeletion(r.Context(), u)
     if err != nil {
-        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+        code := http.StatusBadRequest
+        if err == derr.ErrForbidden { code = http.StatusForbidden }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
     }
     api.WriteJSON(w, http.StatusOK, map[string]bool{"deleted": deleted})
@@ -209,7 +389,9 @@ func (h *RoomHandler) CancelDeletion(w http.ResponseWriter, r *http.Request) {
         return
     }
     if err := h.Rooms.CancelDeletionVote(r.Context(), u); err != nil {
-        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+        code := http.StatusBadRequest
+        if err == derr.ErrForbidden { code = http.StatusForbidden }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
     }
     w.WriteHeader(http.StatusNoContent)
@@ -255,7 +437,23 @@ func (h *RoomHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(http.StatusNoContent)
         return
     }
-    if err := h.Rooms.UpdateRoomSettings(r.Context(), u, req.DisplayName, req.Description); err != nil {
+    ifVersion, err := api.IfMatch(r)
+    if err != nil {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid If-Match"})
+        return
+    }
+    rm, err := h.Rooms.UpdateRoomSettings(r.Context(), u, req.DisplayName, req.Description, ifVersion)
+    code := http.StatusOK
+    if err == derr.ErrPreconditionFailed {
+        // Return the current room for the client to merge against.
+        code = http.StatusPreconditionFailed
+        rm, err = h.Rooms.GetMyRoom(r.Context(), u)
+        if err != nil {
+            api.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+            return
+        }
+    }
+    if err != nil {
         code := http.StatusBadRequest
         if err == derr.ErrNotFound { code = http.StatusNotFound }
         if err == derr.ErrForbidden { code = http.StatusForbidden }
@@ -263,28 +461,108 @@ func (h *RoomHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
         return
     }
     // Return sanitized, updated view
+    api.SetETag(w, rm.Version)
+    api.WriteJSON(w, code, h.view(r, rm))
+}
+
+type setRoleReq struct {
+    Role models.Role `json:"role"`
+}
+
+// SetMemberRole changes the role of the member of the active room whose
+// avatar_key is in the path.
+func (h *RoomHandler) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    var req setRoleReq
+    if err := api.DecodeJSON(r, &req); err != nil || !req.Role.Valid() {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role"})
+        return
+    }
     rm, err := h.Rooms.GetMyRoom(r.Context(), u)
+    if err == nil {
+        memberID := h.memberByKey(rm, chi.URLParam(r, "member"))
+        if memberID == "" {
+            err = derr.ErrNotFound
+        } else {
+            rm, err = h.Rooms.SetMemberRole(r.Context(), u, memberID, req.Role)
+        }
+    }
     if err != nil {
-        api.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+        code := http.StatusInternalServerError
+        switch err {
+        case derr.ErrBadRequest:
+            code = http.StatusBadRequest
+        case derr.ErrNotFound:
+            code = http.StatusNotFound
+        case derr.ErrForbidden:
+            code = http.StatusForbidden
+        case derr.ErrConflict:
+            code = http.StatusConflict
+        }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
         return
     }
-    members := []string{}
-    membersMeta := make([]map[string]any, 0, len(rm.MemberIDs))
+    api.SetETag(w, rm.Version)
+    api.WriteJSON(w, http.StatusOK, h.view(r, rm))
+}
+
+// memberByKey returns the ID of the member of rm whose avatar_key is key, or
+// "" if there is none.
+func (h *RoomHandler) memberByKey(rm *models.Room, key string) string {
     for _, mid := range rm.MemberIDs {
-        if m, err := h.Users.GetByID(r.Context(), mid); err == nil {
-            members = append(members, m.Name)
-            membersMeta = append(membersMeta, map[string]any{
-                "name": m.Name,
-                "avatar_key": ids.DeriveAvatarKey(m.UserID, h.AvatarSalt),
-            })
+        if ids.DeriveAvatarKey(mid, h.AvatarSalt) == key { return mid }
+    }
+    return ""
+}
+
+type leaveRoomReq struct {
+    NewOwner string `json:"new_owner,omitempty"`
+}
+
+// LeaveRoom takes the user out of their active room and returns the solo room
+// they are moved to. An owner may name who takes over by avatar_key in
+// new_owner; the body is optional.
+func (h *RoomHandler) LeaveRoom(w http.ResponseWriter, r *http.Request) {
+    u, ok := api.UserFrom(r.Context())
+    if !ok {
+        api.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+        return
+    }
+    var req leaveRoomReq
+    if err := api.DecodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+        api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+        return
+    }
+    newOwnerID := ""
+    var err error
+    if req.NewOwner != "" {
+        var rm *models.Room
+        rm, err = h.Rooms.GetMyRoom(r.Context(), u)
+        if err == nil {
+            if newOwnerID = h.memberByKey(rm, req.NewOwner); newOwnerID == "" { err = derr.ErrNotFound }
         }
     }
-    api.WriteJSON(w, http.StatusOK, map[string]any{
-        "display_name": rm.DisplayName,
-        "description":  rm.Description,
-        "members":      members,
-        "members_meta": membersMeta,
-        "created_at":   rm.CreatedAt,
-        "updated_at":   rm.UpdatedAt,
-    })
+    var solo *models.Room
+    if err == nil { solo, err = h.Rooms.LeaveRoom(r.Context(), u, newOwnerID) }
+    if err != nil {
+        code := http.StatusInternalServerError
+        switch err {
+        case derr.ErrNotFound:
+            code = http.StatusNotFound
+        case derr.ErrForbidden:
+            code = http.StatusForbidden
+        case derr.ErrConflict:
+            code = http.StatusConflict
+        }
+        api.WriteJSON(w, code, map[string]string{"error": err.Error()})
+        return
+    }
+    view := h.view(r, solo)
+    view["room_id"] = solo.RoomID
+    view["active"] = true
+    api.WriteJSON(w, http.StatusOK, view)
 }
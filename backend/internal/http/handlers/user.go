@@ -18,7 +18,8 @@ func NewUserHandler(users *services.UserService, avatarSalt []byte) *UserHandler
 }
 
 type createUserReq struct {
-    Name string `json:"name"`
+    Name       string `json:"name"`
+    DeviceName string `json:"device_name"`
 }
 type createUserResp struct {
     User   interface{} `json:"user"`
@@ -31,7 +32,7 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
         api.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
         return
     }
-    cu, err := h.Users.CreateUserWithSoloRoom(r.Context(), req.Name)
+    cu, err := h.Users.CreateUserWithSoloRoom(r.Context(), req.Name, deviceName(r, req.DeviceName))
     if err != nil {
         api.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
         return
@@ -57,6 +58,8 @@ func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
         "user_id":    u.UserID,
         "name":       u.Name,
         "username":   u.Username,
+        "email_verified": services.EmailVerified(u),
+        "totp_enabled": services.TOTPEnabled(u),
         "room_id":    u.RoomID,
         "created_at": u.CreatedAt,
         "updated_at": u.UpdatedAt,
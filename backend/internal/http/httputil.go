@@ -2,7 +2,14 @@ package api
 
 import (
     "encoding/json"
+    "errors"
     "net/http"
+    "strconv"
+    "strings"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
 )
 
 func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
@@ -11,8 +18,61 @@ func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
     _ = json.NewEncoder(w).Encode(v)
 }
 
+// WriteRateLimited answers 429 with a Retry-After header if err is a
+// *ratelimit.Error, and reports whether it did.
+func WriteRateLimited(w http.ResponseWriter, err error) bool {
+    var rl *ratelimit.Error
+    if !errors.As(err, &rl) { return false }
+    w.Header().Set("Retry-After", strconv.Itoa(rl.Seconds()))
+    WriteJSON(w, http.StatusTooManyRequests, map[string]any{"error": rl.Error(), "retry_after": rl.Seconds()})
+    return true
+}
+
 func DecodeJSON(r *http.Request, dst interface{}) error {
     dec := json.NewDecoder(r.Body)
     dec.DisallowUnknownFields()
     return dec.Decode(dst)
 }
+
+// PageFrom reads the limit and cursor query parameters. Without a limit every
+// result is returned.
+func PageFrom(r *http.Request) (store.Page, error) {
+    q := r.URL.Query()
+    page := store.Page{Cursor: q.Get("cursor")}
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 { return store.Page{}, derr.ErrBadRequest }
+        page.Limit = n
+    }
+    return page, nil
+}
+
+// SetNextLink advertises the next page in a Link header (rel="next"): the
+// request URL with its cursor replaced by next. It does nothing after the
+// last page.
+func SetNextLink(w http.ResponseWriter, r *http.Request, next string) {
+    if next == "" { return }
+    u := *r.URL
+    q := u.Query()
+    q.Set("cursor", next)
+    u.RawQuery = q.Encode()
+    w.Header().Set("Link", "<"+u.RequestURI()+`>; rel="next"`)
+}
+
+// SetETag sets the ETag of a versioned resource: its version, quoted.
+func SetETag(w http.ResponseWriter, version int64) {
+    w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// IfMatch reads the If-Match header as the version a conditional update
+// expects. Without the header, or with "*", any version is accepted
+// (store.AnyVersion); anything but a single ETag from SetETag is
+// derr.ErrBadRequest.
+func IfMatch(r *http.Request) (int64, error) {
+    v := strings.TrimSpace(r.Header.Get("If-Match"))
+    if v == "" || v == "*" { return store.AnyVersion, nil }
+    if len(v) < 3 || v[0] != '"' || v[len(v)-1] != '"' { return 0, derr.ErrBadRequest }
+    n, err := strconv.ParseInt(v[1:len(v)-1], 10, 64)
+    if err != nil || n < 0 { return 0, derr.ErrBadRequest }
+    return n, nil
+}
@@ -3,8 +3,14 @@ package api
 import (
     "bytes"
     "encoding/json"
+    "fmt"
     "net/http/httptest"
     "testing"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
 )
 
 func TestWriteJSONAndDecodeJSON(t *testing.T) {
@@ -22,8 +28,52 @@ func TestWriteJSONAndDecodeJSON(t *testing.T) {
     if err := DecodeJSON(req2, &dst2); err != nil || dst2["name"] != "A" { t.Fatalf("decode: %v %v", err, dst2) }
 }
 
+func TestPageFromAndNextLink(t *testing.T) {
+    req := httptest.NewRequest("GET", "/rooms/r1/lists?limit=2&cursor=abc", nil)
+    page, err := PageFrom(req)
+    if err != nil || page.Limit != 2 || page.Cursor != "abc" { t.Fatalf("page: %+v %v", page, err) }
+    if page, err := PageFrom(httptest.NewRequest("GET", "/", nil)); err != nil || page != (store.Page{}) { t.Fatalf("no params: %+v %v", page, err) }
+    for _, bad := range []string{"0", "-1", "x"} {
+        if _, err := PageFrom(httptest.NewRequest("GET", "/?limit="+bad, nil)); err != derr.ErrBadRequest { t.Fatalf("limit %s: %v", bad, err) }
+    }
+
+    rr := httptest.NewRecorder()
+    SetNextLink(rr, req, "def")
+    if got, want := rr.Header().Get("Link"), `</rooms/r1/lists?cursor=def&limit=2>; rel="next"`; got != want { t.Fatalf("link: got %q, want %q", got, want) }
+    rr = httptest.NewRecorder()
+    SetNextLink(rr, req, "")
+    if got := rr.Header().Get("Link"); got != "" { t.Fatalf("link after last page: %q", got) }
+}
+
+func TestETagAndIfMatch(t *testing.T) {
+    rr := httptest.NewRecorder()
+    SetETag(rr, 7)
+    if got := rr.Header().Get("ETag"); got != `"7"` { t.Fatalf("etag: %q", got) }
+
+    req := httptest.NewRequest("PATCH", "/", nil)
+    if v, err := IfMatch(req); err != nil || v != store.AnyVersion { t.Fatalf("no header: %d %v", v, err) }
+    req.Header.Set("If-Match", "*")
+    if v, err := IfMatch(req); err != nil || v != store.AnyVersion { t.Fatalf("star: %d %v", v, err) }
+    req.Header.Set("If-Match", `"7"`)
+    if v, err := IfMatch(req); err != nil || v != 7 { t.Fatalf("etag: %d %v", v, err) }
+    for _, bad := range []string{`7`, `W/"7"`, `"x"`, `"-1"`, `"1", "2"`} {
+        req.Header.Set("If-Match", bad)
+        if _, err := IfMatch(req); err != derr.ErrBadRequest { t.Fatalf("If-Match %s: %v", bad, err) }
+    }
+}
+
 func mustJSON(v any) []byte {
     b, _ := json.Marshal(v)
     return b
 }
 
+func TestWriteRateLimited(t *testing.T) {
+    rr := httptest.NewRecorder()
+    if WriteRateLimited(rr, derr.ErrUnauthorized) { t.Fatalf("wrote a response for another error") }
+    err := fmt.Errorf("login: %w", &ratelimit.Error{RetryAfter: 90 * time.Second})
+    if !WriteRateLimited(rr, err) { t.Fatalf("rate limit not written") }
+    if rr.Code != 429 || rr.Header().Get("Retry-After") != "90" { t.Fatalf("got %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After")) }
+    var body map[string]any
+    _ = json.Unmarshal(rr.Body.Bytes(), &body)
+    if body["error"] != "too many attempts" || body["retry_after"] != float64(90) { t.Fatalf("body: %v", body) }
+}
@@ -1,9 +1,8 @@
 package middleware
 
 import (
-    "context"
-    "time"
-    stdhttp "net/http"
+	"context"
+	stdhttp "net/http"
 
 	authpkg "github.com/janvillarosa/gracie-app/backend/internal/auth"
 	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
@@ -11,12 +10,16 @@ import (
 	"github.com/janvillarosa/gracie-app/backend/internal/models"
 )
 
-// UserFinder defines the minimal interface auth middleware needs from user repo.
-type UserFinder interface {
-	GetByAPIKeyLookup(ctx context.Context, lookup string) (*models.User, error)
+// Authenticator resolves an access token or API key to its user and session
+// (services.AuthService).
+type Authenticator interface {
+	Authenticate(ctx context.Context, apiKey string) (*models.User, *models.Session, error)
 }
 
-func AuthMiddleware(users UserFinder) func(next stdhttp.Handler) stdhttp.Handler {
+// AuthMiddleware puts the user and session owning the bearer access token or
+// API key into the request context (api.UserFrom, api.SessionFrom), or
+// answers 401.
+func AuthMiddleware(authn Authenticator) func(next stdhttp.Handler) stdhttp.Handler {
 	return func(next stdhttp.Handler) stdhttp.Handler {
 		return stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
 			token, ok := authpkg.ExtractBearer(r)
@@ -24,23 +27,14 @@ func AuthMiddleware(users UserFinder) func(next stdhttp.Handler) stdhttp.Handler
 				httpError(w, derr.ErrUnauthorized, stdhttp.StatusUnauthorized)
 				return
 			}
-			lookup := authpkg.DeriveLookup(token)
-			u, err := users.GetByAPIKeyLookup(r.Context(), lookup)
+			u, ses, err := authn.Authenticate(r.Context(), token)
 			if err != nil || u == nil {
 				httpError(w, derr.ErrUnauthorized, stdhttp.StatusUnauthorized)
 				return
 			}
-        if !authpkg.VerifyAPIKey(u.APIKeyHash, token) {
-            httpError(w, derr.ErrUnauthorized, stdhttp.StatusUnauthorized)
-            return
-        }
-        if u.APIKeyExpiresAt != nil && time.Now().UTC().After(*u.APIKeyExpiresAt) {
-            httpError(w, derr.ErrUnauthorized, stdhttp.StatusUnauthorized)
-            return
-        }
-        ctx := api.WithUser(r.Context(), u)
-        r = r.WithContext(ctx)
-        next.ServeHTTP(w, r)
+			ctx := api.WithSession(api.WithUser(r.Context(), u), ses)
+			r = r.WithContext(ctx)
+			next.ServeHTTP(w, r)
 		})
 	}
 }
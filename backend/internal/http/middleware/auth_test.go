@@ -6,32 +6,31 @@ import (
     "net/http/httptest"
     "testing"
 
-    "golang.org/x/crypto/bcrypt"
-
-    authpkg "github.com/janvillarosa/gracie-app/backend/internal/auth"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     api "github.com/janvillarosa/gracie-app/backend/internal/http"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
 )
 
-type fakeFinder struct{ user *models.User; lookup string }
+type fakeAuthenticator struct{ user *models.User; session *models.Session; key string }
 
-func (f *fakeFinder) GetByAPIKeyLookup(_ context.Context, l string) (*models.User, error) {
-    if l == f.lookup { return f.user, nil }
-    return nil, nil
+func (f *fakeAuthenticator) Authenticate(_ context.Context, k string) (*models.User, *models.Session, error) {
+    if k == f.key { return f.user, f.session, nil }
+    return nil, nil, derr.ErrUnauthorized
 }
 
 func TestAuthMiddleware(t *testing.T) {
     plain := "good-key"
-    lookup := authpkg.DeriveLookup(plain)
-    hash, _ := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.MinCost)
-    user := &models.User{UserID: "usr_test", Name: "T", APIKeyHash: string(hash)}
+    user := &models.User{UserID: "usr_test", Name: "T"}
 
-    mw := AuthMiddleware(&fakeFinder{user: user, lookup: lookup})
+    mw := AuthMiddleware(&fakeAuthenticator{user: user, session: &models.Session{SessionID: "ses_test"}, key: plain})
 
     handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         if u, ok := api.UserFrom(r.Context()); !ok || u.UserID != "usr_test" {
             t.Fatalf("user not in context")
         }
+        if s, ok := api.SessionFrom(r.Context()); !ok || s.SessionID != "ses_test" {
+            t.Fatalf("session not in context")
+        }
         w.WriteHeader(http.StatusOK)
     }))
 
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net"
+	stdhttp "net/http"
+	"strings"
+
+	api "github.com/janvillarosa/gracie-app/backend/internal/http"
+	"github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
+)
+
+// RateLimit counts every request per client IP (ClientIP) with l and answers
+// 429 with a Retry-After header once an IP is over the limit. A nil l lets
+// every request through.
+func RateLimit(l *ratelimit.Limiter, proxyHops int) func(next stdhttp.Handler) stdhttp.Handler {
+	return func(next stdhttp.Handler) stdhttp.Handler {
+		return stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			if err := l.Allow(r.Context(), ClientIP(r, proxyHops)); err != nil {
+				api.WriteRateLimited(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIP returns the IP of the client behind proxyHops trusted reverse
+// proxies. Each proxy appends the address it was connected from to
+// X-Forwarded-For, so the client is the entry proxyHops from the end;
+// entries before it are whatever the client sent and are ignored. With no
+// proxies the connection's address is used.
+func ClientIP(r *stdhttp.Request, proxyHops int) string {
+	if proxyHops > 0 {
+		var hops []string
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			for _, ip := range strings.Split(h, ",") {
+				hops = append(hops, strings.TrimSpace(ip))
+			}
+		}
+		if len(hops) > 0 {
+			return hops[max(0, len(hops)-proxyHops)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
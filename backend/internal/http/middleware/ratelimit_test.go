@@ -0,0 +1,48 @@
+package middleware
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
+)
+
+func TestClientIP(t *testing.T) {
+    req := httptest.NewRequest("POST", "/auth/login", nil)
+    req.RemoteAddr = "10.0.0.2:5555"
+    req.Header.Set("X-Forwarded-For", "1.1.1.1, 203.0.113.7")
+    if ip := ClientIP(req, 0); ip != "10.0.0.2" { t.Fatalf("no proxies: %s", ip) }
+    if ip := ClientIP(req, 1); ip != "203.0.113.7" { t.Fatalf("one proxy: %s", ip) }
+    if ip := ClientIP(req, 2); ip != "1.1.1.1" { t.Fatalf("two proxies: %s", ip) }
+    if ip := ClientIP(req, 5); ip != "1.1.1.1" { t.Fatalf("more proxies than hops: %s", ip) }
+    req.Header.Del("X-Forwarded-For")
+    if ip := ClientIP(req, 1); ip != "10.0.0.2" { t.Fatalf("no header: %s", ip) }
+}
+
+func TestRateLimit(t *testing.T) {
+    l := ratelimit.New(ratelimit.NewMemoryStore(), "test", ratelimit.Rule{Limit: 2, Window: time.Hour})
+    handler := RateLimit(l, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+    send := func(addr string) *httptest.ResponseRecorder {
+        rr := httptest.NewRecorder()
+        req := httptest.NewRequest("POST", "/auth/login", nil)
+        req.RemoteAddr = addr
+        handler.ServeHTTP(rr, req)
+        return rr
+    }
+    for i := 0; i < 2; i++ {
+        if rr := send("10.0.0.2:5555"); rr.Code != http.StatusOK { t.Fatalf("request %d: %d", i+1, rr.Code) }
+    }
+    rr := send("10.0.0.2:6666")
+    if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" { t.Fatalf("over limit: %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After")) }
+    if rr := send("10.0.0.3:5555"); rr.Code != http.StatusOK { t.Fatalf("other client: %d", rr.Code) }
+
+    // Without a limiter nothing is limited.
+    open := RateLimit(nil, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+    for i := 0; i < 3; i++ {
+        rr := httptest.NewRecorder()
+        open.ServeHTTP(rr, httptest.NewRequest("POST", "/", nil))
+        if rr.Code != http.StatusOK { t.Fatalf("nil limiter: %d", rr.Code) }
+    }
+}
@@ -9,9 +9,24 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/janvillarosa/gracie-app/backend/internal/http/handlers"
 	authmw "github.com/janvillarosa/gracie-app/backend/internal/http/middleware"
+	"github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
 )
 
-func NewRouter(userFinder authmw.UserFinder, authHandler *handlers.AuthHandler, userHandler *handlers.UserHandler, roomHandler *handlers.RoomHandler, listHandler *handlers.ListHandler) http.Handler {
+// Limits are the per client IP rate limits of sensitive routes. A nil
+// limiter leaves its routes unlimited.
+type Limits struct {
+	Login *ratelimit.Limiter
+	Join  *ratelimit.Limiter
+	// Mail limits the public routes that send email.
+	Mail *ratelimit.Limiter
+	// Register limits sign-ups.
+	Register *ratelimit.Limiter
+	// ProxyHops is how many trusted reverse proxies sit in front of the
+	// server (see authmw.ClientIP).
+	ProxyHops int
+}
+
+func NewRouter(authn authmw.Authenticator, limits Limits, authHandler *handlers.AuthHandler, userHandler *handlers.UserHandler, roomHandler *handlers.RoomHandler, listHandler *handlers.ListHandler) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
@@ -29,49 +44,81 @@ func NewRouter(userFinder authmw.UserFinder, authHandler *handlers.AuthHandler,
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match", "X-Requested-With"},
+		ExposedHeaders:   []string{"ETag", "Link", "Retry-After"},
 		AllowCredentials: allowCreds,
 		MaxAge:           300,
 	}))
 
 	// Public endpoints
-	r.Post("/auth/register", authHandler.Register)
-	r.Post("/auth/login", authHandler.Login)
+	r.With(authmw.RateLimit(limits.Register, limits.ProxyHops)).Post("/auth/register", authHandler.Register)
+	loginLimit := authmw.RateLimit(limits.Login, limits.ProxyHops)
+	mailLimit := authmw.RateLimit(limits.Mail, limits.ProxyHops)
+	r.With(loginLimit).Post("/auth/login", authHandler.Login)
+	r.With(loginLimit).Post("/auth/login/totp", authHandler.LoginTOTP)
+	r.Get("/auth/oidc/providers", authHandler.OIDCProviders)
+	r.With(loginLimit).Post("/auth/oidc/{provider}/start", authHandler.StartOIDC)
+	r.With(loginLimit).Post("/auth/oidc/{provider}/callback", authHandler.OIDCCallback)
+	r.Post("/auth/refresh", authHandler.Refresh)
+	r.With(mailLimit).Post("/auth/password/forgot", authHandler.ForgotPassword)
+	r.Post("/auth/password/reset", authHandler.ResetPassword)
+	r.Post("/auth/verify", authHandler.VerifyEmail)
+	r.With(mailLimit).Post("/auth/verify/resend", authHandler.ResendVerification)
 	r.Post("/users", userHandler.CreateUser)
 
 	// Authenticated endpoints
 	r.Group(func(ar chi.Router) {
-		ar.Use(authmw.AuthMiddleware(userFinder))
+		ar.Use(authmw.AuthMiddleware(authn))
 
 		ar.Get("/me", userHandler.GetMe)
 		ar.Put("/me", userHandler.UpdateMe)
 		ar.Patch("/me", userHandler.UpdateMePartial)
 		ar.Post("/me/password", authHandler.ChangePassword)
+		ar.Get("/me/sessions", authHandler.ListSessions)
+		ar.Delete("/me/sessions", authHandler.RevokeOtherSessions)
+		ar.Delete("/me/sessions/{session_id}", authHandler.RevokeSession)
+		ar.Post("/me/totp", authHandler.StartTOTP)
+		ar.Post("/me/totp/confirm", authHandler.ConfirmTOTP)
+		ar.Post("/me/totp/recovery-codes", authHandler.RegenerateRecoveryCodes)
+		ar.Post("/me/totp/disable", authHandler.DisableTOTP)
+		ar.Post("/auth/logout", authHandler.Logout)
 		ar.Delete("/me", userHandler.DeleteMe)
 
+		ar.Get("/rooms", roomHandler.ListRooms)
 		ar.Get("/rooms/me", roomHandler.GetMyRoom)
+		ar.Put("/rooms/active", roomHandler.SwitchRoom)
 		ar.Get("/rooms/{room_id}/pantry", listHandler.GetPantry)
+		ar.Get("/rooms/{room_id}/trash", listHandler.GetTrash)
 		ar.Post("/rooms", roomHandler.CreateSoloRoom)
 		ar.Post("/rooms/share", roomHandler.ShareRoom)
-		ar.Post("/rooms/join", roomHandler.JoinByToken)
-		ar.Post("/rooms/{room_id}/join", roomHandler.JoinRoom)
+		ar.Get("/rooms/invites", roomHandler.ListInvites)
+		ar.Post("/rooms/invites", roomHandler.CreateInvite)
+		ar.Delete("/rooms/invites/{invite_id}", roomHandler.RevokeInvite)
+		joinLimit := authmw.RateLimit(limits.Join, limits.ProxyHops)
+		ar.With(joinLimit).Post("/rooms/join", roomHandler.JoinByToken)
+		ar.With(joinLimit).Post("/rooms/{room_id}/join", roomHandler.JoinRoom)
 		ar.Put("/rooms/settings", roomHandler.UpdateSettings)
+		ar.Put("/rooms/members/{member}/role", roomHandler.SetMemberRole)
+		ar.Post("/rooms/leave", roomHandler.LeaveRoom)
 		ar.Post("/rooms/deletion/vote", roomHandler.VoteDeletion)
 		ar.Post("/rooms/deletion/cancel", roomHandler.CancelDeletion)
 
 		// Lists
 		ar.Post("/rooms/{room_id}/lists", listHandler.CreateList)
 		ar.Get("/rooms/{room_id}/lists", listHandler.ListLists)
+		ar.Get("/rooms/{room_id}/lists/{list_id}", listHandler.GetList)
 		ar.Patch("/rooms/{room_id}/lists/{list_id}", listHandler.UpdateList)
 		ar.Post("/rooms/{room_id}/lists/{list_id}/deletion/vote", listHandler.VoteListDeletion)
 		ar.Post("/rooms/{room_id}/lists/{list_id}/deletion/cancel", listHandler.CancelListDeletionVote)
+		ar.Post("/rooms/{room_id}/lists/{list_id}/restore", listHandler.RestoreList)
 		ar.Post("/rooms/{room_id}/lists/{list_id}/clear", listHandler.ArchiveCompleted)
 		ar.Post("/rooms/{room_id}/lists/{list_id}/items", listHandler.CreateItem)
 		ar.Get("/rooms/{room_id}/lists/{list_id}/items", listHandler.ListItems)
+		ar.Get("/rooms/{room_id}/lists/{list_id}/items/{item_id}", listHandler.GetItem)
 		ar.Patch("/rooms/{room_id}/lists/{list_id}/items/{item_id}", listHandler.UpdateItem)
 		ar.Patch("/rooms/{room_id}/lists/{list_id}/items/{item_id}/position", listHandler.UpdateItemPosition)
 		ar.Delete("/rooms/{room_id}/lists/{list_id}/items/{item_id}", listHandler.DeleteItem)
+		ar.Post("/rooms/{room_id}/lists/{list_id}/items/{item_id}/restore", listHandler.RestoreItem)
 	})
 
 	return r
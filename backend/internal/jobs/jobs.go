@@ -0,0 +1,270 @@
+// Package jobs runs background work that is persisted in the active store.
+//
+// Jobs are enqueued through store.JobRepository, usually in the same
+// transaction as the change that needs follow-up work, so they survive
+// restarts. A Pool of workers claims due jobs, runs the handler registered for
+// their kind and retries failures with exponential backoff until the job's
+// attempts are used up, at which point it is dead-lettered for inspection
+// (see cmd/gracie-jobs). Delivery is at-least-once: handlers must be
+// idempotent.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
+	"github.com/janvillarosa/gracie-app/backend/pkg/ids"
+)
+
+// DefaultMaxAttempts applies to jobs enqueued without their own limit.
+const DefaultMaxAttempts = 8
+
+// Handler performs one job. Returning an error schedules a retry unless the
+// error is wrapped with Permanent.
+type Handler func(ctx context.Context, job models.Job) error
+
+// New returns a pending job due now. MaxAttempts is left at zero, which means
+// the pool's limit applies.
+func New(kind string, payload map[string]string, now time.Time) *models.Job {
+	return &models.Job{
+		JobID:     ids.NewID("job"),
+		Kind:      kind,
+		Payload:   payload,
+		Status:    models.JobPending,
+		RunAt:     now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying; the job is dead-lettered at once.
+func Permanent(err error) error { return permanentError{err: err} }
+
+// Options tunes a Pool. Zero values select the defaults.
+type Options struct {
+	Workers      int           // concurrent workers (default 2)
+	MaxAttempts  int           // for jobs without their own limit (default DefaultMaxAttempts)
+	PollInterval time.Duration // idle wait between claims (default 2s)
+	Lease        time.Duration // how long a claim is held; also the handler timeout (default 5m)
+	BaseBackoff  time.Duration // delay after the first failure (default 5s)
+	MaxBackoff   time.Duration // upper bound for the delay (default 1h)
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 2
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 2 * time.Second
+	}
+	if o.Lease <= 0 {
+		o.Lease = 5 * time.Minute
+	}
+	if o.BaseBackoff <= 0 {
+		o.BaseBackoff = 5 * time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Hour
+	}
+	return o
+}
+
+// Backoff returns the delay before retrying after the given (1-based) failed
+// attempt: base doubled per attempt, capped at max.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= max || d <= 0 {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// Pool claims and runs jobs from a JobRepository.
+type Pool struct {
+	repo      store.JobRepository
+	opts      Options
+	handlers  map[string]Handler
+	schedules []schedule
+	now       func() time.Time
+}
+
+type schedule struct {
+	kind  string
+	every time.Duration
+}
+
+func NewPool(repo store.JobRepository, opts Options) *Pool {
+	return &Pool{
+		repo:     repo,
+		opts:     opts.withDefaults(),
+		handlers: map[string]Handler{},
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Handle registers h for jobs of kind. Register handlers before Run.
+func (p *Pool) Handle(kind string, h Handler) { p.handlers[kind] = h }
+
+// Schedule enqueues a job of kind when Run starts and then at every multiple
+// of every (wall clock). Register schedules before Run.
+//
+// The job ID is derived from the kind and the interval, so servers sharing a
+// store enqueue one job per interval between them while it is pending; a
+// duplicate Enqueue fails with derr.ErrConflict and is ignored. Once that job
+// has completed a late server may enqueue another, so scheduled handlers must
+// tolerate running more than once per interval.
+func (p *Pool) Schedule(kind string, every time.Duration) {
+	p.schedules = append(p.schedules, schedule{kind: kind, every: every})
+}
+
+// Run starts the workers and the schedules, and blocks until ctx is cancelled
+// and every in-flight job has finished.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	for _, s := range p.schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.tick(ctx, s)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) tick(ctx context.Context, s schedule) {
+	for {
+		if err := p.enqueueScheduled(ctx, s); err != nil {
+			log.Printf("jobs: schedule %s: %v", s.kind, err)
+		}
+		now := p.now()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(now.Truncate(s.every).Add(s.every).Sub(now)):
+		}
+	}
+}
+
+// enqueueScheduled enqueues the job for the interval containing now, unless
+// it is already queued.
+func (p *Pool) enqueueScheduled(ctx context.Context, s schedule) error {
+	now := p.now()
+	job := New(s.kind, nil, now)
+	job.JobID = fmt.Sprintf("job_%s_%d", s.kind, now.Truncate(s.every).Unix())
+	err := p.repo.Enqueue(ctx, job)
+	if errors.Is(err, derr.ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for {
+		ran, err := p.RunOnce(ctx)
+		if err != nil {
+			log.Printf("jobs: %v", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.opts.PollInterval):
+		}
+	}
+}
+
+// RunOnce claims one due job and runs it. It reports whether a job was
+// claimed; the error covers store failures, not handler failures.
+func (p *Pool) RunOnce(ctx context.Context) (bool, error) {
+	if ctx.Err() != nil {
+		return false, nil
+	}
+	now := p.now()
+	job, err := p.repo.ClaimNext(ctx, now, now.Add(p.opts.Lease))
+	if errors.Is(err, derr.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claim: %w", err)
+	}
+	// A claimed job runs to completion even if ctx is cancelled meanwhile;
+	// the lease bounds how long that can take.
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.opts.Lease)
+	defer cancel()
+	return true, p.finish(runCtx, job, p.run(runCtx, job))
+}
+
+func (p *Pool) run(ctx context.Context, job *models.Job) (err error) {
+	h, ok := p.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for kind %q", job.Kind))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, *job)
+}
+
+// finish records the outcome of one attempt.
+func (p *Pool) finish(ctx context.Context, job *models.Job, runErr error) error {
+	var err error
+	if runErr == nil {
+		err = p.repo.Complete(ctx, job.JobID, job.Attempts)
+	} else {
+		maxAttempts := job.MaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = p.opts.MaxAttempts
+		}
+		now := p.now()
+		var permanent permanentError
+		if errors.As(runErr, &permanent) || job.Attempts >= maxAttempts {
+			log.Printf("jobs: %s %s dead after %d attempts: %v", job.Kind, job.JobID, job.Attempts, runErr)
+			err = p.repo.Bury(ctx, job.JobID, job.Attempts, runErr.Error(), now)
+		} else {
+			delay := Backoff(job.Attempts, p.opts.BaseBackoff, p.opts.MaxBackoff)
+			log.Printf("jobs: %s %s attempt %d failed, retrying in %s: %v", job.Kind, job.JobID, job.Attempts, delay, runErr)
+			err = p.repo.Retry(ctx, job.JobID, job.Attempts, now.Add(delay), runErr.Error(), now)
+		}
+	}
+	if errors.Is(err, derr.ErrConflict) {
+		// The lease expired and another worker has the job now.
+		log.Printf("jobs: %s %s lease lost after attempt %d", job.Kind, job.JobID, job.Attempts)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: record outcome: %w", job.Kind, job.JobID, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestPool returns a pool on a fresh memstore whose clock is *now.
+func newTestPool(opts Options) (*Pool, *memstore.JobRepo, *time.Time) {
+	repo := memstore.NewJobRepo(memstore.NewStore())
+	now := t0
+	p := NewPool(repo, opts)
+	p.now = func() time.Time { return now }
+	return p, repo, &now
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 5*time.Second, time.Minute
+	for attempt, want := range map[int]time.Duration{1: 5 * time.Second, 2: 10 * time.Second, 3: 20 * time.Second, 4: 40 * time.Second, 5: time.Minute, 60: time.Minute} {
+		if got := Backoff(attempt, base, max); got != want {
+			t.Fatalf("Backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestRunOnceCompletes(t *testing.T) {
+	ctx := context.Background()
+	p, repo, _ := newTestPool(Options{})
+	var got models.Job
+	p.Handle("k", func(_ context.Context, j models.Job) error { got = j; return nil })
+	job := New("k", map[string]string{"room_id": "room1"}, t0)
+	if err := repo.Enqueue(ctx, job); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	ran, err := p.RunOnce(ctx)
+	if err != nil || !ran {
+		t.Fatalf("RunOnce: %v %v", ran, err)
+	}
+	if got.Payload["room_id"] != "room1" || got.Attempts != 1 {
+		t.Fatalf("handler got %+v", got)
+	}
+	if _, err := repo.GetByID(ctx, job.JobID); err == nil {
+		t.Fatalf("completed job still stored")
+	}
+	if ran, _ := p.RunOnce(ctx); ran {
+		t.Fatalf("RunOnce on empty queue ran a job")
+	}
+}
+
+func TestRetryThenDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	p, repo, now := newTestPool(Options{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute})
+	calls := 0
+	p.Handle("k", func(context.Context, models.Job) error { calls++; return errors.New("flaky") })
+	job := New("k", nil, t0)
+	if err := repo.Enqueue(ctx, job); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	if ran, err := p.RunOnce(ctx); err != nil || !ran {
+		t.Fatalf("attempt 1: %v %v", ran, err)
+	}
+	got, _ := repo.GetByID(ctx, job.JobID)
+	if got.Status != models.JobPending || got.LastError != "flaky" || !got.RunAt.Equal(t0.Add(time.Second)) {
+		t.Fatalf("after attempt 1: %+v", got)
+	}
+	// Not due until the backoff has passed.
+	if ran, _ := p.RunOnce(ctx); ran {
+		t.Fatalf("retried before backoff elapsed")
+	}
+	*now = t0.Add(time.Second)
+	p.RunOnce(ctx)
+	got, _ = repo.GetByID(ctx, job.JobID)
+	if !got.RunAt.Equal(now.Add(2 * time.Second)) {
+		t.Fatalf("second backoff: run_at %v", got.RunAt)
+	}
+	*now = now.Add(2 * time.Second)
+	p.RunOnce(ctx)
+	if calls != 3 {
+		t.Fatalf("handler calls = %d, want 3", calls)
+	}
+	dead, _ := repo.ListDead(ctx)
+	if len(dead) != 1 || dead[0].JobID != job.JobID || dead[0].Attempts != 3 {
+		t.Fatalf("dead letters: %+v", dead)
+	}
+
+	// A requeued job runs again.
+	if err := repo.Requeue(ctx, job.JobID, *now, *now); err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	p.Handle("k", func(context.Context, models.Job) error { return nil })
+	if ran, err := p.RunOnce(ctx); err != nil || !ran {
+		t.Fatalf("requeued run: %v %v", ran, err)
+	}
+	if _, err := repo.GetByID(ctx, job.JobID); err == nil {
+		t.Fatalf("requeued job not completed")
+	}
+}
+
+func TestPermanentFailuresAreDeadLettered(t *testing.T) {
+	ctx := context.Background()
+	p, repo, _ := newTestPool(Options{})
+	p.Handle("bad", func(context.Context, models.Job) error { return Permanent(errors.New("invalid payload")) })
+	p.Handle("panics", func(context.Context, models.Job) error { panic("boom") })
+	for _, kind := range []string{"bad", "unknown"} {
+		if err := repo.Enqueue(ctx, New(kind, nil, t0)); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		p.RunOnce(ctx)
+	}
+	dead, _ := repo.ListDead(ctx)
+	if len(dead) != 2 {
+		t.Fatalf("dead letters: %+v", dead)
+	}
+
+	// A panic is an ordinary failure and is retried.
+	job := New("panics", nil, t0)
+	repo.Enqueue(ctx, job)
+	if ran, err := p.RunOnce(ctx); err != nil || !ran {
+		t.Fatalf("RunOnce panic: %v %v", ran, err)
+	}
+	got, _ := repo.GetByID(ctx, job.JobID)
+	if got.Status != models.JobPending || got.LastError != "panic: boom" {
+		t.Fatalf("after panic: %+v", got)
+	}
+}
+
+func TestExpiredLeaseIsReclaimed(t *testing.T) {
+	ctx := context.Background()
+	p, repo, now := newTestPool(Options{Lease: time.Minute})
+	job := New("k", nil, t0)
+	repo.Enqueue(ctx, job)
+	// A worker claims the job and dies.
+	if _, err := repo.ClaimNext(ctx, t0, t0.Add(time.Minute)); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	p.Handle("k", func(context.Context, models.Job) error { return nil })
+	if ran, _ := p.RunOnce(ctx); ran {
+		t.Fatalf("claimed a leased job")
+	}
+	*now = t0.Add(2 * time.Minute)
+	if ran, err := p.RunOnce(ctx); err != nil || !ran {
+		t.Fatalf("reclaim: %v %v", ran, err)
+	}
+	// The first worker's late outcome is rejected.
+	if err := repo.Complete(ctx, job.JobID, 1); err == nil {
+		t.Fatalf("stale complete accepted")
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	repo := memstore.NewJobRepo(memstore.NewStore())
+	p := NewPool(repo, Options{Workers: 3, PollInterval: time.Millisecond})
+	var done atomic.Int32
+	p.Handle("k", func(context.Context, models.Job) error { done.Add(1); return nil })
+	for i := 0; i < 5; i++ {
+		repo.Enqueue(context.Background(), New("k", nil, time.Now().UTC()))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() { p.Run(ctx); close(stopped) }()
+	deadline := time.After(5 * time.Second)
+	for done.Load() < 5 {
+		select {
+		case <-deadline:
+			t.Fatalf("only %d of 5 jobs ran", done.Load())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not return after cancel")
+	}
+}
+
+func TestScheduleEnqueuesOncePerInterval(t *testing.T) {
+	ctx := context.Background()
+	p, repo, now := newTestPool(Options{})
+	s := schedule{kind: "purge", every: time.Hour}
+	*now = t0.Add(10 * time.Minute)
+	if err := p.enqueueScheduled(ctx, s); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// A second server in the same interval finds the job already queued.
+	*now = t0.Add(50 * time.Minute)
+	if err := p.enqueueScheduled(ctx, s); err != nil {
+		t.Fatalf("enqueue duplicate: %v", err)
+	}
+	*now = t0.Add(70 * time.Minute)
+	if err := p.enqueueScheduled(ctx, s); err != nil {
+		t.Fatalf("enqueue next interval: %v", err)
+	}
+
+	ran := 0
+	p.Handle("purge", func(context.Context, models.Job) error { ran++; return nil })
+	for {
+		ok, err := p.RunOnce(ctx)
+		if err != nil {
+			t.Fatalf("RunOnce: %v", err)
+		}
+		if !ok {
+			break
+		}
+	}
+	if ran != 2 {
+		t.Fatalf("ran %d scheduled jobs, want 2", ran)
+	}
+	if _, err := repo.GetByID(ctx, "job_purge_"+strconv.FormatInt(t0.Unix(), 10)); err == nil {
+		t.Fatalf("scheduled job not completed")
+	}
+}
@@ -0,0 +1,137 @@
+// Package mail sends email to users through a Mailer. SMTPMailer delivers
+// through an SMTP relay; LogMailer keeps messages on disk or in the log so
+// local development and tests can read them without a mail server.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ErrBadHeader reports a recipient or subject that would inject headers.
+var ErrBadHeader = errors.New("mail: line break in header")
+
+// SMTPMailer sends through an SMTP server, upgrading to TLS when the server
+// offers STARTTLS. Credentials are optional for relays that do not need them.
+type SMTPMailer struct {
+	host     string
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer returns a mailer for the server at host:port sending as from,
+// e.g. "Gracie <no-reply@example.com>".
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{host: host, addr: net.JoinHostPort(host, strconv.Itoa(port)), username: username, password: password, from: from}
+}
+
+// Send delivers msg. net/smtp takes no context, so cancellation is only
+// checked before connecting.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	sender := m.from
+	if a, err := netmail.ParseAddress(m.from); err == nil {
+		sender = a.Address
+	}
+	if err := smtp.SendMail(m.addr, auth, sender, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("mail: send to %s: %w", m.addr, err)
+	}
+	return nil
+}
+
+// LogMailer writes each message as an .eml file in dir, or to the log when
+// dir is empty. It never fails for lack of a mail server.
+type LogMailer struct {
+	dir  string
+	from string
+	mu   sync.Mutex
+	n    int
+}
+
+// NewLogMailer returns a mailer that keeps messages in dir ("" for the log).
+func NewLogMailer(dir, from string) *LogMailer {
+	return &LogMailer{dir: dir, from: from}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	now := time.Now()
+	data, err := format(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+	if m.dir == "" {
+		log.Printf("mail: to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.n++
+	name := fmt.Sprintf("%s-%03d.eml", now.UTC().Format("20060102T150405.000000000Z"), m.n)
+	m.mu.Unlock()
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o600)
+}
+
+// format renders msg as an RFC 5322 message with a quoted-printable UTF-8 body.
+func format(from string, msg Message, now time.Time) ([]byte, error) {
+	for _, h := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, ErrBadHeader
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,136 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var msg = Message{To: "ana@example.com", Subject: "Reset your password", Body: "Open this link:\nhttps://gracie.example/reset?token=abc\n"}
+
+func TestLogMailerWritesFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewLogMailer(dir, "Gracie <no-reply@example.com>")
+	for i := 0; i < 2; i++ {
+		if err := m.Send(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("files = %v, want 2", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"From: Gracie <no-reply@example.com>\r\n", "To: ana@example.com\r\n", "Subject: Reset your password\r\n", "token=3Dabc"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("message lacks %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestHeaderInjectionRejected(t *testing.T) {
+	m := NewLogMailer(t.TempDir(), "no-reply@example.com")
+	bad := msg
+	bad.To = "ana@example.com\r\nBcc: eve@example.com"
+	if err := m.Send(context.Background(), bad); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("err = %v, want ErrBadHeader", err)
+	}
+}
+
+// fakeSMTP accepts one message without authentication and returns what it got.
+func fakeSMTP(t *testing.T) (port int, got <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var lines []string
+		_ = tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				ch <- lines
+				return
+			}
+			lines = append(lines, line)
+			switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+			case "EHLO", "HELO":
+				_ = tp.PrintfLine("250 fake")
+			case "DATA":
+				_ = tp.PrintfLine("354 go ahead")
+				body, _ := io.ReadAll(tp.DotReader())
+				lines = append(lines, string(body))
+				_ = tp.PrintfLine("250 queued")
+			case "QUIT":
+				_ = tp.PrintfLine("221 bye")
+				ch <- lines
+				return
+			default:
+				_ = tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, ch
+}
+
+func TestSMTPMailerSends(t *testing.T) {
+	port, got := fakeSMTP(t)
+	m := NewSMTPMailer("127.0.0.1", port, "", "", "Gracie <no-reply@example.com>")
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-got
+	all := strings.Join(lines, "\n")
+	for _, want := range []string{"MAIL FROM:<no-reply@example.com>", "RCPT TO:<ana@example.com>"} {
+		if !strings.Contains(all, want) {
+			t.Fatalf("session lacks %q:\n%s", want, all)
+		}
+	}
+	data := lines[len(lines)-2]
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(data)))
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("Subject") != msg.Subject || h.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Fatalf("headers = %v", h)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(r.R))
+	if !strings.Contains(string(body), "https://gracie.example/reset?token=abc") {
+		t.Fatalf("body = %q", body)
+	}
+}
+
+func TestSMTPMailerReportsFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	m := NewSMTPMailer("127.0.0.1", port, "", "", "no-reply@example.com")
+	if err := m.Send(context.Background(), msg); err == nil || !strings.Contains(err.Error(), strconv.Itoa(port)) {
+		t.Fatalf("err = %v, want a send error naming the server", err)
+	}
+}
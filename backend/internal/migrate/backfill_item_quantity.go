@@ -0,0 +1,63 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/parse"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// backfillItemQuantity splits the quantity and unit out of descriptions written
+// before ListItem had Quantity/Unit fields, e.g. "2 lbs chicken breast". It
+// replaces the read-time normalization the list service used to apply; the
+// service still splits trashed items, which this skips, when they are
+// listed in the trash or restored.
+var backfillItemQuantity = Migration{
+	Version: 1,
+	Name:    "backfill_item_quantity",
+	Up: func(ctx context.Context, env Env, dryRun bool) (int, error) {
+		// Collect first so no scan is open while writing. Trashed items
+		// reject updates and are skipped.
+		var legacy []models.ListItem
+		err := env.ItemScanner.ScanAll(ctx, func(it models.ListItem) error {
+			if it.IsDeleted {
+				return nil
+			}
+			if split, ok := splitLegacyQuantity(it); ok {
+				legacy = append(legacy, split)
+			}
+			return nil
+		})
+		if err != nil || dryRun {
+			return len(legacy), err
+		}
+		for i, it := range legacy {
+			// Keep UpdatedAt: archive ordering depends on it.
+			err := env.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+				if err := env.Items.UpdateDescription(ctx, it.ItemID, it.Description, store.AnyVersion, it.UpdatedAt); err != nil {
+					return err
+				}
+				if err := env.Items.UpdateQuantity(ctx, it.ItemID, it.Quantity, store.AnyVersion, it.UpdatedAt); err != nil {
+					return err
+				}
+				return env.Items.UpdateUnit(ctx, it.ItemID, it.Unit, store.AnyVersion, it.UpdatedAt)
+			})
+			if err != nil {
+				return i, err
+			}
+		}
+		return len(legacy), nil
+	},
+}
+
+// splitLegacyQuantity returns it with quantity and unit parsed out of the
+// description, and whether anything changed (see parse.SplitLegacyQuantity).
+func splitLegacyQuantity(it models.ListItem) (models.ListItem, bool) {
+	desc, qty, unit, ok := parse.SplitLegacyQuantity(it.Description, it.Quantity)
+	if !ok {
+		return it, false
+	}
+	it.Description, it.Quantity, it.Unit = desc, qty, unit
+	return it, true
+}
@@ -0,0 +1,39 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// backfillUserRooms records the room of users stored before User.RoomIDs, when
+// a user could only be in one room, among their rooms. The SQL stores fill
+// user_rooms in their schema migration, so there it finds nothing to do.
+var backfillUserRooms = Migration{
+	Version: 2,
+	Name:    "backfill_user_rooms",
+	Up: func(ctx context.Context, env Env, dryRun bool) (int, error) {
+		// Collect first so no scan is open while writing.
+		var legacy []models.User
+		err := env.UserScanner.ScanAll(ctx, func(u models.User) error {
+			if u.RoomID != nil && *u.RoomID != "" && !slices.Contains(u.RoomIDs, *u.RoomID) {
+				legacy = append(legacy, u)
+			}
+			return nil
+		})
+		if err != nil || dryRun {
+			return len(legacy), err
+		}
+		for i, u := range legacy {
+			// Keep UpdatedAt; a user deleted or moved on since the scan is skipped.
+			err := env.Users.AddRoom(ctx, u.UserID, *u.RoomID, u.UpdatedAt)
+			if err != nil && !errors.Is(err, derr.ErrNotFound) && !errors.Is(err, derr.ErrConflict) {
+				return i, err
+			}
+		}
+		return len(legacy), nil
+	},
+}
@@ -0,0 +1,143 @@
+// Package migrate applies versioned data migrations to the active store.
+//
+// Each store records applied versions through store.MigrationRepository, so a
+// migration runs once per store. Migrations must be idempotent: a run that
+// fails part-way is retried from the start on the next "up".
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// Migration is one versioned data change.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the change and returns how many records it changed. With
+	// dryRun set it must not write, only count what it would change.
+	Up func(ctx context.Context, env Env, dryRun bool) (int, error)
+}
+
+// Env is the store access available to migrations.
+type Env struct {
+	Tx          store.TxRunner
+	Items       store.ListItemRepository
+	ItemScanner store.ListItemScanner
+	Users       store.UserRepository
+	UserScanner store.UserScanner
+}
+
+// All is the ordered list of migrations shipped with the app.
+var All = []Migration{
+	backfillItemQuantity,
+	backfillUserRooms,
+}
+
+// Status describes one known migration and whether it has been applied.
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Result reports one migration executed by Up.
+type Result struct {
+	Version int
+	Name    string
+	Changed int
+	DryRun  bool
+}
+
+type Runner struct {
+	log        store.MigrationRepository
+	env        Env
+	migrations []Migration
+	now        func() time.Time
+}
+
+// NewRunner returns a runner for migrations, which are applied in version order.
+func NewRunner(log store.MigrationRepository, env Env, migrations []Migration) *Runner {
+	ms := append([]Migration(nil), migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return &Runner{log: log, env: env, migrations: ms, now: func() time.Time { return time.Now().UTC() }}
+}
+
+func (r *Runner) applied(ctx context.Context) (map[int]models.MigrationRecord, error) {
+	recs, err := r.log.ListApplied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]models.MigrationRecord, len(recs))
+	for _, rec := range recs {
+		out[rec.Version] = rec
+	}
+	return out, nil
+}
+
+// Status lists every known migration with its applied time, if any.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, 0, len(r.migrations))
+	for _, m := range r.migrations {
+		st := Status{Version: m.Version, Name: m.Name}
+		if rec, ok := applied[m.Version]; ok {
+			at := rec.AppliedAt
+			st.AppliedAt = &at
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// Pending returns the migrations not yet applied, in version order.
+func (r *Runner) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, m := range r.migrations {
+		if _, ok := applied[m.Version]; !ok {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Up runs every pending migration in order and records it as applied. With
+// dryRun set nothing is written or recorded. It stops at the first failure.
+func (r *Runner) Up(ctx context.Context, dryRun bool) ([]Result, error) {
+	for i := 1; i < len(r.migrations); i++ {
+		if r.migrations[i].Version == r.migrations[i-1].Version {
+			return nil, fmt.Errorf("migrate: duplicate version %d", r.migrations[i].Version)
+		}
+	}
+	pending, err := r.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, m := range pending {
+		n, err := m.Up(ctx, r.env, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migrate: %04d_%s: %w", m.Version, m.Name, err)
+		}
+		results = append(results, Result{Version: m.Version, Name: m.Name, Changed: n, DryRun: dryRun})
+		if dryRun {
+			continue
+		}
+		if err := r.log.Record(ctx, models.MigrationRecord{Version: m.Version, Name: m.Name, AppliedAt: r.now()}); err != nil {
+			return results, fmt.Errorf("migrate: record %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return results, nil
+}
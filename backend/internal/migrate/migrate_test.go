@@ -0,0 +1,199 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
+)
+
+func newTestRunner(t *testing.T, items ...models.ListItem) (*Runner, *memstore.ListItemRepo, *memstore.MigrationRepo) {
+	t.Helper()
+	st := memstore.NewStore()
+	itemsRepo := memstore.NewListItemRepo(st)
+	log := memstore.NewMigrationRepo(st)
+	for i := range items {
+		if err := itemsRepo.Put(context.Background(), &items[i]); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	usersRepo := memstore.NewUserRepo(st)
+	env := Env{Tx: memstore.Tx{}, Items: itemsRepo, ItemScanner: itemsRepo, Users: usersRepo, UserScanner: usersRepo}
+	return NewRunner(log, env, All), itemsRepo, log
+}
+
+var created = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func legacyItems() []models.ListItem {
+	return []models.ListItem{
+		{ItemID: "it_legacy", ListID: "l1", RoomID: "r1", Description: "2 lbs chicken breast", CreatedAt: created, UpdatedAt: created},
+		{ItemID: "it_split", ListID: "l1", RoomID: "r1", Description: "milk", Quantity: "1", Unit: "l", CreatedAt: created, UpdatedAt: created},
+		{ItemID: "it_plain", ListID: "l1", RoomID: "r1", Description: "bell peppers", CreatedAt: created, UpdatedAt: created},
+	}
+}
+
+func TestUpBackfillsLegacyItems(t *testing.T) {
+	ctx := context.Background()
+	runner, items, log := newTestRunner(t, legacyItems()...)
+
+	results, err := runner.Up(ctx, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if len(results) != len(All) || results[0].Version != 1 || results[0].Changed != 1 || results[0].DryRun {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	it, _ := items.GetByID(ctx, "it_legacy")
+	if it.Description != "chicken breast" || it.Quantity != "2" || it.Unit != "lbs" {
+		t.Fatalf("legacy item not split: %+v", it)
+	}
+	if !it.UpdatedAt.Equal(created) {
+		t.Fatalf("UpdatedAt changed: %v", it.UpdatedAt)
+	}
+	it, _ = items.GetByID(ctx, "it_split")
+	if it.Description != "milk" || it.Quantity != "1" || it.Unit != "l" {
+		t.Fatalf("split item changed: %+v", it)
+	}
+	it, _ = items.GetByID(ctx, "it_plain")
+	if it.Description != "bell peppers" || it.Quantity != "" {
+		t.Fatalf("plain item changed: %+v", it)
+	}
+
+	recs, _ := log.ListApplied(ctx)
+	if len(recs) != len(All) || recs[0].Version != 1 || recs[0].Name != "backfill_item_quantity" {
+		t.Fatalf("migration not recorded: %+v", recs)
+	}
+	// A second run has nothing pending.
+	results, err = runner.Up(ctx, false)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("second up: results=%+v err=%v", results, err)
+	}
+}
+
+func TestUpDryRunWritesNothing(t *testing.T) {
+	ctx := context.Background()
+	runner, items, log := newTestRunner(t, legacyItems()...)
+
+	results, err := runner.Up(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(results) != len(All) || results[0].Changed != 1 || !results[0].DryRun {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	it, _ := items.GetByID(ctx, "it_legacy")
+	if it.Description != "2 lbs chicken breast" || it.Quantity != "" {
+		t.Fatalf("dry run wrote item: %+v", it)
+	}
+	if recs, _ := log.ListApplied(ctx); len(recs) != 0 {
+		t.Fatalf("dry run recorded migrations: %+v", recs)
+	}
+}
+
+func TestUpBackfillsUserRooms(t *testing.T) {
+	ctx := context.Background()
+	st := memstore.NewStore()
+	users := memstore.NewUserRepo(st)
+	home, family := "room_home", "room_family"
+	for _, u := range []models.User{
+		{UserID: "usr_legacy", RoomID: &home, CreatedAt: created, UpdatedAt: created},
+		{UserID: "usr_current", RoomID: &family, RoomIDs: []string{home, family}, CreatedAt: created, UpdatedAt: created},
+		{UserID: "usr_roomless", CreatedAt: created, UpdatedAt: created},
+	} {
+		if err := users.Put(ctx, &u); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	env := Env{Tx: memstore.Tx{}, Users: users, UserScanner: users}
+	results, err := NewRunner(memstore.NewMigrationRepo(st), env, []Migration{backfillUserRooms}).Up(ctx, false)
+	if err != nil || len(results) != 1 || results[0].Changed != 1 {
+		t.Fatalf("up: %+v %v", results, err)
+	}
+	u, _ := users.GetByID(ctx, "usr_legacy")
+	if len(u.RoomIDs) != 1 || u.RoomIDs[0] != home || !u.UpdatedAt.Equal(created) {
+		t.Fatalf("legacy user not backfilled: %+v", u)
+	}
+	u, _ = users.GetByID(ctx, "usr_current")
+	if len(u.RoomIDs) != 2 {
+		t.Fatalf("current user changed: %+v", u)
+	}
+}
+
+func TestStatusAndPending(t *testing.T) {
+	ctx := context.Background()
+	runner, _, _ := newTestRunner(t)
+
+	pending, err := runner.Pending(ctx)
+	if err != nil || len(pending) != len(All) {
+		t.Fatalf("pending before up: %d, %v", len(pending), err)
+	}
+	if _, err := runner.Up(ctx, false); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Fatalf("%04d_%s not applied", s.Version, s.Name)
+		}
+	}
+	if pending, _ := runner.Pending(ctx); len(pending) != 0 {
+		t.Fatalf("pending after up: %d", len(pending))
+	}
+}
+
+func TestUpStopsAtFailure(t *testing.T) {
+	ctx := context.Background()
+	st := memstore.NewStore()
+	log := memstore.NewMigrationRepo(st)
+	boom := errors.New("boom")
+	ran := false
+	runner := NewRunner(log, Env{}, []Migration{
+		{Version: 2, Name: "second", Up: func(context.Context, Env, bool) (int, error) { ran = true; return 0, nil }},
+		{Version: 1, Name: "first", Up: func(context.Context, Env, bool) (int, error) { return 0, boom }},
+	})
+	if _, err := runner.Up(ctx, false); !errors.Is(err, boom) {
+		t.Fatalf("got %v, want boom", err)
+	}
+	if ran {
+		t.Fatalf("later migration ran after failure")
+	}
+	if recs, _ := log.ListApplied(ctx); len(recs) != 0 {
+		t.Fatalf("failed migration recorded: %+v", recs)
+	}
+}
+
+func TestUpRejectsDuplicateVersions(t *testing.T) {
+	noop := func(context.Context, Env, bool) (int, error) { return 0, nil }
+	runner := NewRunner(memstore.NewMigrationRepo(memstore.NewStore()), Env{}, []Migration{
+		{Version: 1, Name: "a", Up: noop},
+		{Version: 1, Name: "b", Up: noop},
+	})
+	if _, err := runner.Up(context.Background(), false); err == nil {
+		t.Fatalf("expected duplicate version error")
+	}
+}
+
+func TestSplitLegacyQuantity(t *testing.T) {
+	cases := []struct {
+		in              models.ListItem
+		desc, qty, unit string
+		changed         bool
+	}{
+		{models.ListItem{Description: "2 lbs chicken breast"}, "chicken breast", "2", "lbs", true},
+		{models.ListItem{Description: "chicken breast", Quantity: "2", Unit: "lbs"}, "chicken breast", "2", "lbs", false},
+		{models.ListItem{Description: "bell peppers"}, "bell peppers", "", "", false},
+	}
+	for _, c := range cases {
+		got, changed := splitLegacyQuantity(c.in)
+		if changed != c.changed || got.Description != c.desc || got.Quantity != c.qty || got.Unit != c.unit {
+			t.Fatalf("splitLegacyQuantity(%q) = (%q, %q, %q, %v), want (%q, %q, %q, %v)",
+				c.in.Description, got.Description, got.Quantity, got.Unit, changed, c.desc, c.qty, c.unit, c.changed)
+		}
+	}
+}
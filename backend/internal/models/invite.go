@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Invite lets people join a room. Each invite has a short Code to type in
+// and, optionally, a long LinkToken for invite links; either one joins. An
+// invite works until it expires, is revoked or has been used MaxUses times.
+type Invite struct {
+    InviteID  string     `bson:"invite_id"  dynamodbav:"invite_id"  json:"invite_id"`
+    RoomID    string     `bson:"room_id"    dynamodbav:"room_id"    json:"-"`
+    // Name tells invites apart, e.g. who it was meant for.
+    Name      string     `bson:"name,omitempty" dynamodbav:"name,omitempty" json:"name,omitempty"`
+    Code      string     `bson:"code"       dynamodbav:"code"       json:"code"`
+    LinkToken string     `bson:"link_token,omitempty" dynamodbav:"link_token,omitempty" json:"link_token,omitempty"`
+    CreatedBy string     `bson:"created_by" dynamodbav:"created_by" json:"-"`
+    // MaxUses is the number of joins allowed; 0 means unlimited.
+    MaxUses   int        `bson:"max_uses"   dynamodbav:"max_uses"   json:"max_uses"`
+    Uses      int        `bson:"uses"       dynamodbav:"uses"       json:"uses"`
+    CreatedAt time.Time  `bson:"created_at" dynamodbav:"created_at" json:"created_at"`
+    ExpiresAt time.Time  `bson:"expires_at" dynamodbav:"expires_at" json:"expires_at"`
+    RevokedAt *time.Time `bson:"revoked_at,omitempty" dynamodbav:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Usable reports whether the invite can still be used to join at now.
+func (i *Invite) Usable(now time.Time) bool {
+    if i.RevokedAt != nil || !now.Before(i.ExpiresAt) { return false }
+    return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
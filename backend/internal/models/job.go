@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Job statuses. A job is deleted when it completes, so only pending, running
+// and dead jobs are ever stored.
+const (
+    JobPending = "pending"
+    JobRunning = "running"
+    JobDead    = "dead"
+)
+
+// Job is a unit of background work persisted in the active store (see internal/jobs).
+// While running, RunAt holds the lease expiry; Attempts counts claims.
+type Job struct {
+    JobID       string            `bson:"job_id"       dynamodbav:"job_id"       json:"job_id"`
+    Kind        string            `bson:"kind"         dynamodbav:"kind"         json:"kind"`
+    Payload     map[string]string `bson:"payload,omitempty" dynamodbav:"payload,omitempty" json:"payload,omitempty"`
+    Status      string            `bson:"status"       dynamodbav:"status"       json:"status"`
+    Attempts    int               `bson:"attempts"     dynamodbav:"attempts"     json:"attempts"`
+    MaxAttempts int               `bson:"max_attempts" dynamodbav:"max_attempts" json:"max_attempts"`
+    RunAt       time.Time         `bson:"run_at"       dynamodbav:"run_at"       json:"run_at"`
+    LastError   string            `bson:"last_error,omitempty" dynamodbav:"last_error,omitempty" json:"last_error,omitempty"`
+    CreatedAt   time.Time         `bson:"created_at"   dynamodbav:"created_at"   json:"created_at"`
+    UpdatedAt   time.Time         `bson:"updated_at"   dynamodbav:"updated_at"   json:"updated_at"`
+}
@@ -3,7 +3,9 @@ package models
 import "time"
 
 // List represents a collaborative checklist owned by a room (house).
-// Deletion is a soft-delete gated by member votes.
+// Deletion is a soft-delete gated by member votes: a deleted list sits in the
+// room's trash, where UpdatedAt records when it was deleted, until it is
+// restored or purged.
 type List struct {
     ListID        string            `bson:"list_id"        dynamodbav:"list_id"        json:"list_id"`
     RoomID        string            `bson:"room_id"        dynamodbav:"room_id"        json:"room_id"`
@@ -13,6 +15,8 @@ type List struct {
     Icon          string            `bson:"icon,omitempty"  dynamodbav:"icon,omitempty"  json:"icon,omitempty"`
     DeletionVotes map[string]string `bson:"deletion_votes,omitempty" dynamodbav:"deletion_votes,omitempty" json:"deletion_votes,omitempty"`
     IsDeleted     bool              `bson:"is_deleted,omitempty"   dynamodbav:"is_deleted,omitempty"   json:"is_deleted"`
+    // Version is bumped by every write; see store.AnyVersion.
+    Version       int64             `bson:"version"        dynamodbav:"version"        json:"version"`
     CreatedAt     time.Time         `bson:"created_at"     dynamodbav:"created_at"     json:"created_at"`
     UpdatedAt     time.Time         `bson:"updated_at"     dynamodbav:"updated_at"     json:"updated_at"`
 }
@@ -3,7 +3,9 @@ package models
 import "time"
 
 // ListItem represents an item within a list. Items can be completed (toggled)
-// and can be deleted by any room member. Completion does not delete the item.
+// and can be deleted by any room member. Completion does not delete the item;
+// deletion moves it to the room's trash (IsDeleted) until it is restored or
+// purged.
 type ListItem struct {
     ItemID      string    `bson:"item_id"      dynamodbav:"item_id"      json:"item_id"`
     ListID      string    `bson:"list_id"      dynamodbav:"list_id"      json:"list_id"`
@@ -17,7 +19,10 @@ type ListItem struct {
     Category    string    `bson:"category,omitempty"   dynamodbav:"category,omitempty"  json:"category,omitempty"`
     IsStarred   bool      `bson:"is_starred,omitempty" dynamodbav:"is_starred,omitempty" json:"is_starred"`
     IsArchived  bool      `bson:"is_archived,omitempty" dynamodbav:"is_archived,omitempty" json:"is_archived"`
+    IsDeleted   bool      `bson:"is_deleted,omitempty"  dynamodbav:"is_deleted,omitempty"  json:"is_deleted,omitempty"`
     Completed   bool      `bson:"completed"    dynamodbav:"completed"    json:"completed"`
+    // Version is bumped by every write; see store.AnyVersion.
+    Version     int64     `bson:"version"      dynamodbav:"version"      json:"version"`
     CreatedAt   time.Time `bson:"created_at"   dynamodbav:"created_at"   json:"created_at"`
     UpdatedAt   time.Time `bson:"updated_at"   dynamodbav:"updated_at"   json:"updated_at"`
 }
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// MigrationRecord marks a data migration (see internal/migrate) as applied to a store.
+type MigrationRecord struct {
+    Version   int       `bson:"version"    dynamodbav:"version"    json:"version"`
+    Name      string    `bson:"name"       dynamodbav:"name"       json:"name"`
+    AppliedAt time.Time `bson:"applied_at" dynamodbav:"applied_at" json:"applied_at"`
+}
@@ -2,13 +2,76 @@ package models
 
 import "time"
 
+// Room is a shared house. Roles holds each member's Role; members without an
+// entry count as RoleMember. See RoleOf for who owns rooms created before
+// roles existed.
 type Room struct {
     RoomID        string            `bson:"room_id"        dynamodbav:"room_id"        json:"-"`
     MemberIDs     []string          `bson:"member_ids"     dynamodbav:"member_ids"     json:"-"`
+    Roles         map[string]Role   `bson:"roles,omitempty" dynamodbav:"roles,omitempty" json:"-"`
     DisplayName   string            `bson:"display_name,omitempty" dynamodbav:"display_name,omitempty" json:"display_name,omitempty"`
     Description   string            `bson:"description,omitempty"  dynamodbav:"description,omitempty"  json:"description,omitempty"`
     ShareToken    *string           `bson:"share_token,omitempty"  dynamodbav:"share_token,omitempty"  json:"share_token,omitempty"`
     DeletionVotes map[string]string `bson:"deletion_votes,omitempty" dynamodbav:"deletion_votes,omitempty" json:"deletion_votes,omitempty"`
+    // Version is bumped by every write; see store.AnyVersion.
+    Version       int64             `bson:"version"        dynamodbav:"version"        json:"version"`
     CreatedAt     time.Time         `bson:"created_at"     dynamodbav:"created_at"     json:"created_at"`
     UpdatedAt     time.Time         `bson:"updated_at"     dynamodbav:"updated_at"     json:"updated_at"`
 }
+
+// Role is what a member may do in a room.
+type Role string
+
+const (
+    // RoleOwner may do everything, including handing ownership to another
+    // member. A room has one owner.
+    RoleOwner Role = "owner"
+    // RoleAdmin manages the house: sharing, settings, deletion and the roles
+    // of members and guests.
+    RoleAdmin Role = "admin"
+    // RoleMember works with lists and items.
+    RoleMember Role = "member"
+    // RoleGuest can read lists and check items off, nothing more.
+    RoleGuest Role = "guest"
+)
+
+// Valid reports whether r is one of the defined roles.
+func (r Role) Valid() bool {
+    switch r {
+    case RoleOwner, RoleAdmin, RoleMember, RoleGuest:
+        return true
+    }
+    return false
+}
+
+// RoleOf returns the role of userID, "" if they are not a member. While no
+// member holds RoleOwner, as in rooms older than roles or after the owner
+// left, the earliest admin is the owner, else the earliest member; a guest
+// only owns a room that has nobody else.
+func (rm *Room) RoleOf(userID string) Role {
+    owner := ""
+    for _, mid := range rm.MemberIDs {
+        if rm.Roles[mid] == RoleOwner { owner = mid; break }
+    }
+    if owner == "" { owner = rm.standInOwner() }
+    for _, mid := range rm.MemberIDs {
+        if mid != userID { continue }
+        if mid == owner { return RoleOwner }
+        if r := rm.Roles[mid]; r.Valid() && r != RoleOwner { return r }
+        return RoleMember
+    }
+    return ""
+}
+
+// standInOwner picks the owner of a room without one by the stored roles.
+func (rm *Room) standInOwner() string {
+    for _, role := range []Role{RoleAdmin, RoleMember} {
+        for _, mid := range rm.MemberIDs {
+            r := rm.Roles[mid]
+            if !r.Valid() || r == RoleOwner { r = RoleMember }
+            if r == role { return mid }
+        }
+    }
+    if len(rm.MemberIDs) > 0 { return rm.MemberIDs[0] }
+    return ""
+}
@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestRoomRoleOf(t *testing.T) {
+    // Rooms from before roles: the first member owns it, the rest are members.
+    legacy := &Room{MemberIDs: []string{"a", "b"}}
+    if legacy.RoleOf("a") != RoleOwner || legacy.RoleOf("b") != RoleMember || legacy.RoleOf("z") != "" {
+        t.Fatalf("legacy roles: %s %s %q", legacy.RoleOf("a"), legacy.RoleOf("b"), legacy.RoleOf("z"))
+    }
+    rm := &Room{MemberIDs: []string{"a", "b", "c"}, Roles: map[string]Role{"a": RoleGuest, "b": RoleOwner, "c": "bogus"}}
+    if rm.RoleOf("a") != RoleGuest || rm.RoleOf("b") != RoleOwner || rm.RoleOf("c") != RoleMember {
+        t.Fatalf("roles: %s %s %s", rm.RoleOf("a"), rm.RoleOf("b"), rm.RoleOf("c"))
+    }
+    // The owner left: the earliest remaining member takes over.
+    left := &Room{MemberIDs: []string{"a", "c"}, Roles: map[string]Role{"a": RoleAdmin}}
+    if left.RoleOf("a") != RoleOwner || left.RoleOf("c") != RoleMember {
+        t.Fatalf("after owner left: %s %s", left.RoleOf("a"), left.RoleOf("c"))
+    }
+    // A guest who joined early does not take over from later members.
+    guestFirst := &Room{MemberIDs: []string{"g", "m", "a"}, Roles: map[string]Role{"g": RoleGuest, "a": RoleAdmin}}
+    if guestFirst.RoleOf("g") != RoleGuest || guestFirst.RoleOf("m") != RoleMember || guestFirst.RoleOf("a") != RoleOwner {
+        t.Fatalf("guest first, admin later: %s %s %s", guestFirst.RoleOf("g"), guestFirst.RoleOf("m"), guestFirst.RoleOf("a"))
+    }
+    noAdmin := &Room{MemberIDs: []string{"g", "m"}, Roles: map[string]Role{"g": RoleGuest}}
+    if noAdmin.RoleOf("g") != RoleGuest || noAdmin.RoleOf("m") != RoleOwner {
+        t.Fatalf("guest first, member later: %s %s", noAdmin.RoleOf("g"), noAdmin.RoleOf("m"))
+    }
+    // Only guests left: someone has to be able to manage the room.
+    guests := &Room{MemberIDs: []string{"g", "h"}, Roles: map[string]Role{"g": RoleGuest, "h": RoleGuest}}
+    if guests.RoleOf("g") != RoleOwner || guests.RoleOf("h") != RoleGuest {
+        t.Fatalf("only guests: %s %s", guests.RoleOf("g"), guests.RoleOf("h"))
+    }
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Session is one signed-in device with a name, usage timestamps and an
+// optional expiry. A user has any number of sessions; revoking one leaves the
+// others signed in.
+//
+// A session authenticates either with a long-lived API key (KeyHash and
+// KeyLookup) or with short-lived access tokens renewed by single-use refresh
+// tokens (RefreshGen > 0), never both.
+type Session struct {
+    SessionID  string     `bson:"session_id"   dynamodbav:"session_id"   json:"session_id"`
+    UserID     string     `bson:"user_id"      dynamodbav:"user_id"      json:"-"`
+    // Name describes the device, e.g. its user agent.
+    Name       string     `bson:"name,omitempty" dynamodbav:"name,omitempty" json:"name"`
+    KeyHash    string     `bson:"key_hash,omitempty"   dynamodbav:"key_hash,omitempty"   json:"-"`
+    KeyLookup  string     `bson:"key_lookup,omitempty" dynamodbav:"key_lookup,omitempty" json:"-"`
+    // RefreshGen counts the refresh tokens issued; only the latest is valid.
+    RefreshGen int64      `bson:"refresh_gen"  dynamodbav:"refresh_gen"  json:"-"`
+    CreatedAt  time.Time  `bson:"created_at"   dynamodbav:"created_at"   json:"created_at"`
+    // LastUsedAt is refreshed at most once a minute.
+    LastUsedAt time.Time  `bson:"last_used_at" dynamodbav:"last_used_at" json:"last_used_at"`
+    ExpiresAt  *time.Time `bson:"expires_at,omitempty" dynamodbav:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
@@ -2,6 +2,16 @@ package models
 
 import "time"
 
+// User is an account. The APIKey fields hold the single key issued before
+// sessions existed; it is moved into a Session the first time it is used.
+// EmailVerifiedAt is set once the user proves they receive mail at Username
+// and cleared when Username changes. TOTPSecretEnc is the encrypted secret of
+// the user's authenticator app; two-factor login is on once TOTPEnabledAt is
+// set, until then the secret awaits a first code.
+//
+// RoomIDs are the rooms the user belongs to, in the order they joined; the
+// rooms' MemberIDs remain the authority on membership. RoomID is the active
+// one of them, the room the app opens on.
 type User struct {
     UserID          string     `bson:"user_id"       dynamodbav:"user_id"       json:"user_id"`
     Name            string     `bson:"name"          dynamodbav:"name"          json:"name"`
@@ -10,7 +20,11 @@ type User struct {
     APIKeyHash      string     `bson:"api_key_hash,omitempty" dynamodbav:"api_key_hash,omitempty" json:"-"`
     APIKeyLookup    string     `bson:"api_key_lookup,omitempty" dynamodbav:"api_key_lookup,omitempty" json:"-"`
     APIKeyExpiresAt *time.Time `bson:"api_key_expires_at,omitempty" dynamodbav:"api_key_expires_at,omitempty" json:"-"`
+    EmailVerifiedAt *time.Time `bson:"email_verified_at,omitempty" dynamodbav:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+    TOTPSecretEnc   string     `bson:"totp_secret_enc,omitempty" dynamodbav:"totp_secret_enc,omitempty" json:"-"`
+    TOTPEnabledAt   *time.Time `bson:"totp_enabled_at,omitempty" dynamodbav:"totp_enabled_at,omitempty" json:"totp_enabled_at,omitempty"`
     RoomID          *string    `bson:"room_id,omitempty" dynamodbav:"room_id,omitempty" json:"room_id,omitempty"`
+    RoomIDs         []string   `bson:"room_ids,omitempty" dynamodbav:"room_ids,omitempty" json:"room_ids,omitempty"`
     CreatedAt       time.Time  `bson:"created_at"    dynamodbav:"created_at"    json:"created_at"`
     UpdatedAt       time.Time  `bson:"updated_at"    dynamodbav:"updated_at"    json:"updated_at"`
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Purposes of user tokens. A token only redeems for the purpose it was made for.
+const (
+    TokenPasswordReset = "password_reset"
+    // TokenRecoveryCode is a two-factor recovery code; it expires only when
+    // replaced.
+    TokenRecoveryCode = "totp_recovery"
+    // TokenTOTPStep records a TOTP code that was used, so it cannot be
+    // replayed while it is still accepted.
+    TokenTOTPStep = "totp_step"
+)
+
+// UserToken is a single-use secret sent to a user out of band, e.g. a
+// password reset link or a recovery code. Only a hash of the token is
+// stored; redeeming it deletes the record.
+type UserToken struct {
+    TokenHash string    `bson:"token_hash" dynamodbav:"token_hash" json:"-"`
+    Purpose   string    `bson:"purpose"    dynamodbav:"purpose"    json:"purpose"`
+    UserID    string    `bson:"user_id"    dynamodbav:"user_id"    json:"-"`
+    CreatedAt time.Time `bson:"created_at" dynamodbav:"created_at" json:"created_at"`
+    ExpiresAt time.Time `bson:"expires_at" dynamodbav:"expires_at" json:"expires_at"`
+}
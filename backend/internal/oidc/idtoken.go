@@ -0,0 +1,269 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// leeway allows for clock drift between us and the provider.
+const leeway = time.Minute
+
+// keySet is the provider's JWKS, indexed by key ID.
+type keySet struct {
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// jwk is a JSON Web Key (RFC 7517) of the kinds ID tokens are signed with.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verify checks an ID token's signature and claims (OpenID Connect Core
+// 3.1.3.7) and returns what it says about the user.
+func (p *Provider) verify(ctx context.Context, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := checkSignature(header.Alg, key, digest[:], sig); err != nil {
+		return nil, err
+	}
+
+	var c struct {
+		Iss           string          `json:"iss"`
+		Sub           string          `json:"sub"`
+		Aud           audience        `json:"aud"`
+		Azp           string          `json:"azp"`
+		Exp           int64           `json:"exp"`
+		Iat           int64           `json:"iat"`
+		Nonce         string          `json:"nonce"`
+		Email         string          `json:"email"`
+		EmailVerified json.RawMessage `json:"email_verified"`
+		Name          string          `json:"name"`
+	}
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	now := p.now()
+	switch {
+	case strings.TrimSuffix(c.Iss, "/") != p.cfg.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Iss)
+	case !c.Aud.has(p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	case len(c.Aud) > 1 && c.Azp != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: authorized party", ErrInvalidToken)
+	case c.Exp == 0 || now.After(time.Unix(c.Exp, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.Iat != 0 && time.Unix(c.Iat, 0).After(now.Add(leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce", ErrInvalidToken)
+	case c.Sub == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return &Claims{
+		Issuer:        c.Iss,
+		Subject:       c.Sub,
+		Email:         c.Email,
+		EmailVerified: parseVerified(c.EmailVerified),
+		Name:          c.Name,
+	}, nil
+}
+
+// key returns the provider key with ID kid. The JWKS is refetched when kid
+// is unknown, since that is how a key rotation shows up, but at most once a
+// minute so forged kids cannot hammer the provider.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys != nil {
+		if k, ok := p.keys.lookup(kid); ok {
+			return k, nil
+		}
+		if p.now().Sub(p.keys.fetched) < time.Minute {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+		}
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &doc); err != nil {
+		return nil, fmt.Errorf("oidc: fetch keys: %w", err)
+	}
+	ks := &keySet{keys: map[string]crypto.PublicKey{}, fetched: p.now()}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			ks.keys[k.Kid] = pub
+		}
+	}
+	p.keys = ks
+	if k, ok := ks.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// lookup finds kid, or the only key when the token names none.
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// checkSignature verifies a SHA-256 signature. The algorithm must match the
+// key type, so a token cannot pick a weaker check than the key implies.
+func checkSignature(alg string, key crypto.PublicKey, digest, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil {
+			return nil
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if ok && len(sig) == 64 {
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(pub, digest, r, s) {
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+// audience is the aud claim, which may be a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) has(clientID string) bool {
+	for _, v := range a {
+		if v == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVerified reads email_verified, which some providers send as a string.
+func parseVerified(raw json.RawMessage) bool {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b
+	}
+	var s string
+	return json.Unmarshal(raw, &s) == nil && s == "true"
+}
+
+func decodeSegment(seg string, out any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("bad key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,216 @@
+// Package oidc signs users in with an OpenID Connect provider through the
+// authorization code flow with PKCE (RFC 7636). A Provider discovers its
+// endpoints from the issuer's /.well-known/openid-configuration, exchanges
+// codes at the token endpoint and verifies the ID token it gets back against
+// the provider's published keys (RS256 or ES256).
+//
+// Nothing is kept between the two halves of a login: the caller keeps the
+// state, nonce and PKCE verifier from Begin and passes them to Exchange.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes a provider and this app's client registration with it.
+type Config struct {
+	// ID names the provider in URLs, e.g. "google".
+	ID string
+	// Name is shown to users, e.g. "Google".
+	Name   string
+	Issuer string
+	// ClientSecret may be empty for a public client.
+	ClientID     string
+	ClientSecret string
+	// Scopes default to openid, email and profile.
+	Scopes []string
+}
+
+// Claims are what a verified ID token says about the user.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// ErrInvalidToken is returned for an ID token that fails verification.
+var ErrInvalidToken = errors.New("oidc: invalid id token")
+
+// Provider is one configured identity provider. It is safe for concurrent use.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+// metadata is the part of the discovery document a login needs.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New returns a provider for cfg. Discovery happens on first use, so a
+// provider that is down at startup does not stop the server. A nil client
+// uses one with a ten-second timeout.
+func New(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &Provider{cfg: cfg, client: client, now: time.Now}
+}
+
+func (p *Provider) ID() string   { return p.cfg.ID }
+func (p *Provider) Name() string { return p.cfg.Name }
+
+// Flow holds the secrets of one login between Begin and Exchange. The
+// caller must keep them away from the browser's URL: State is compared
+// with the state the provider redirects back with, Nonce with the ID token,
+// and Verifier proves to the token endpoint that the code is ours.
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// Begin starts a login and returns the URL to send the user to. The
+// provider redirects back to redirectURI with a code and the flow's state.
+func (p *Provider) Begin(ctx context.Context, redirectURI string) (string, *Flow, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	f := &Flow{State: randomString(), Nonce: randomString(), Verifier: randomString()}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", f.State)
+	q.Set("nonce", f.Nonce)
+	q.Set("code_challenge", challengeS256(f.Verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), f, nil
+}
+
+// Exchange redeems code for an ID token and returns its verified claims.
+// redirectURI must be the one given to Begin.
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI string, f *Flow) (*Claims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", f.Verifier)
+	form.Set("client_id", p.cfg.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token response has no id_token")
+	}
+	return p.verify(ctx, tok.IDToken, f.Nonce)
+}
+
+// metadata returns the discovery document, fetching it on first use. A
+// failed fetch is retried on the next call.
+func (p *Provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var m metadata
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("oidc: discovery for %s: %w", p.cfg.Issuer, err)
+	}
+	// The issuer must be the one configured (OpenID Connect Discovery 4.3).
+	if strings.TrimSuffix(m.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery for %s returned issuer %q", p.cfg.Issuer, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery for %s is missing endpoints", p.cfg.Issuer)
+	}
+	p.meta = &m
+	return p.meta, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// challengeS256 is the PKCE code challenge of verifier.
+func challengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/testutil/oidcmock"
+)
+
+const redirectURI = "http://app.test/oidc/callback"
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	user := oidcmock.User{Subject: "u-1", Email: "ann@example.com", EmailVerified: true, Name: "Ann"}
+	mock := oidcmock.New(t, "gracie", "s3cret", user)
+	p := New(Config{ID: "mock", Name: "Mock", Issuer: mock.Issuer() + "/", ClientID: "gracie", ClientSecret: "s3cret"}, nil)
+
+	authURL, flow, err := p.Begin(ctx, redirectURI)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if q := u.Query(); q.Get("code_challenge") != challengeS256(flow.Verifier) || q.Get("scope") != "openid email profile" {
+		t.Fatalf("auth url query: %v", q)
+	}
+	code, state, err := mock.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if state != flow.State {
+		t.Fatalf("state: got %q want %q", state, flow.State)
+	}
+	claims, err := p.Exchange(ctx, code, redirectURI, flow)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if claims.Subject != "u-1" || claims.Email != "ann@example.com" || !claims.EmailVerified || claims.Name != "Ann" {
+		t.Fatalf("claims: %+v", claims)
+	}
+
+	// A code is single use, and only the flow that asked for it can redeem it.
+	if _, err := p.Exchange(ctx, code, redirectURI, flow); err == nil {
+		t.Fatal("code redeemed twice")
+	}
+	authURL, flow, _ = p.Begin(ctx, redirectURI)
+	code, _, _ = mock.Authorize(authURL)
+	other := *flow
+	other.Verifier = "wrong"
+	if _, err := p.Exchange(ctx, code, redirectURI, &other); err == nil {
+		t.Fatal("exchange with wrong verifier succeeded")
+	}
+
+	// A provider rejects a client with the wrong secret.
+	bad := New(Config{Issuer: mock.Issuer(), ClientID: "gracie", ClientSecret: "nope"}, nil)
+	authURL, flow, _ = bad.Begin(ctx, redirectURI)
+	code, _, _ = mock.Authorize(authURL)
+	if _, err := bad.Exchange(ctx, code, redirectURI, flow); err == nil {
+		t.Fatal("exchange with wrong client secret succeeded")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	mock := oidcmock.New(t, "gracie", "", oidcmock.User{})
+	p := New(Config{Issuer: mock.Issuer(), ClientID: "gracie"}, nil)
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss":            mock.Issuer(),
+			"sub":            "u-1",
+			"aud":            []string{"gracie", "other"},
+			"azp":            "gracie",
+			"exp":            now.Add(time.Minute).Unix(),
+			"iat":            now.Unix(),
+			"nonce":          "n",
+			"email":          "ann@example.com",
+			"email_verified": "true",
+		}
+	}
+	tok, _ := mock.Sign(valid())
+	c, err := p.verify(ctx, tok, "n")
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !c.EmailVerified {
+		t.Fatal("string email_verified not read")
+	}
+
+	cases := map[string]func(map[string]any){
+		"issuer":   func(m map[string]any) { m["iss"] = "https://evil.example" },
+		"audience": func(m map[string]any) { m["aud"] = "other" },
+		"azp":      func(m map[string]any) { m["azp"] = "other" },
+		"expired":  func(m map[string]any) { m["exp"] = now.Add(-2 * time.Minute).Unix() },
+		"future":   func(m map[string]any) { m["iat"] = now.Add(time.Hour).Unix() },
+		"nonce":    func(m map[string]any) { m["nonce"] = "x" },
+		"subject":  func(m map[string]any) { delete(m, "sub") },
+	}
+	for name, mutate := range cases {
+		claims := valid()
+		mutate(claims)
+		tok, _ := mock.Sign(claims)
+		if _, err := p.verify(ctx, tok, "n"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	// A token with a tampered signature or an alg the key cannot check fails.
+	tampered := tok[:len(tok)-4] + "AAAA"
+	if _, err := p.verify(ctx, tampered, "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("tampered signature: got %v", err)
+	}
+	parts := strings.Split(tok, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"mock"}`)) + "." + parts[1] + "."
+	if _, err := p.verify(ctx, none, "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("alg none: got %v", err)
+	}
+}
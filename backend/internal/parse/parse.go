@@ -66,3 +66,18 @@ func NormalizeKey(input string) string {
 	desc = strings.Join(strings.Fields(desc), " ")
 	return desc
 }
+
+// SplitLegacyQuantity parses the quantity and unit out of a description
+// written before items had them. Items that already have a quantity are left
+// alone, so descriptions are never stripped twice; ok reports whether
+// anything was split off.
+func SplitLegacyQuantity(description, quantity string) (desc, qty, unit string, ok bool) {
+	if quantity != "" {
+		return description, quantity, "", false
+	}
+	desc, qty, unit = ParseInput(description)
+	if qty == "" {
+		return description, "", "", false
+	}
+	return desc, qty, unit, true
+}
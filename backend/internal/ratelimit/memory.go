@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval bounds how often MemoryStore drops expired counters.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps counters in the memory of one server. Each server counts
+// on its own, so with N servers behind a load balancer a client gets up to N
+// times the limit; use the shared store there.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]memoryCounter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryCounter struct {
+	count   int64
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: map[string]memoryCounter{}, now: time.Now}
+}
+
+func (s *MemoryStore) Incr(_ context.Context, key string, expiresAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, c := range s.counters {
+			if !now.Before(c.expires) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = memoryCounter{expires: expiresAt}
+	}
+	c.count++
+	s.counters[key] = c
+	return c.count, nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expires) {
+		return 0, nil
+	}
+	return c.count, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
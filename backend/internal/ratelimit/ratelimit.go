@@ -0,0 +1,159 @@
+// Package ratelimit counts attempts per key in sliding windows, e.g. logins
+// per client IP or failed passwords per username, and refuses keys that go
+// over their limit with an Error telling the caller when to retry.
+//
+// A window is approximated from two fixed windows: the count of the current
+// one plus the count of the previous one, weighted by how much of it still
+// overlaps the sliding window. Counters live in a Store, either in memory
+// (MemoryStore) or in the database (store.CounterRepository) when several
+// servers must share them.
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+)
+
+// Store keeps expiring counters. store.CounterRepository implements it.
+type Store interface {
+	// Incr adds one to the counter key and returns the new count. A new
+	// counter expires at expiresAt.
+	Incr(ctx context.Context, key string, expiresAt time.Time) (int64, error)
+	// Get returns the count of key, or 0 if it is missing or expired at now.
+	Get(ctx context.Context, key string, now time.Time) (int64, error)
+	// Delete removes the counter key; a missing counter is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Rule allows Limit attempts per key in any Window.
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Error refuses an attempt over the limit. It matches derr.ErrRateLimited.
+type Error struct {
+	// RetryAfter is how long until the key is allowed again, in whole
+	// seconds and at least one.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string { return derr.ErrRateLimited.Error() }
+
+func (e *Error) Unwrap() error { return derr.ErrRateLimited }
+
+// Seconds returns RetryAfter in seconds, for a Retry-After header.
+func (e *Error) Seconds() int { return int(e.RetryAfter / time.Second) }
+
+// Limiter applies one Rule to the keys of one kind of attempt.
+//
+// A nil *Limiter allows everything. Store errors are logged and the attempt
+// is allowed, so an unavailable store does not lock everyone out.
+type Limiter struct {
+	store Store
+	name  string
+	rule  Rule
+	now   func() time.Time
+}
+
+// New returns a limiter counting in st. name prefixes its counter keys and
+// must be unique among the limiters sharing st.
+func New(st Store, name string, rule Rule) *Limiter {
+	return &Limiter{store: st, name: name, rule: rule, now: time.Now}
+}
+
+// Allow records an attempt for key, or returns an *Error without recording
+// it when key is over the limit.
+func (l *Limiter) Allow(ctx context.Context, key string) error {
+	if err := l.Check(ctx, key); err != nil {
+		return err
+	}
+	l.Hit(ctx, key)
+	return nil
+}
+
+// Check returns an *Error when key is over the limit, without recording an
+// attempt. Use it with Hit to count only failures.
+func (l *Limiter) Check(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+	now := l.now()
+	idx, elapsed := l.window(now)
+	curr, err := l.store.Get(ctx, l.key(key, idx), now)
+	if err != nil {
+		log.Printf("ratelimit %s: %v", l.name, err)
+		return nil
+	}
+	prev, err := l.store.Get(ctx, l.key(key, idx-1), now)
+	if err != nil {
+		log.Printf("ratelimit %s: %v", l.name, err)
+		return nil
+	}
+	wait := l.wait(curr, prev, elapsed)
+	if wait < 0 {
+		return nil
+	}
+	// Round up past wait so a client waiting RetryAfter is let through.
+	return &Error{RetryAfter: (wait/time.Second + 1) * time.Second}
+}
+
+// Hit records an attempt for key.
+func (l *Limiter) Hit(ctx context.Context, key string) {
+	if l == nil {
+		return
+	}
+	idx, _ := l.window(l.now())
+	// A counter is read while it is the current or the previous window.
+	expires := time.Unix(0, (idx+2)*int64(l.rule.Window))
+	if _, err := l.store.Incr(ctx, l.key(key, idx), expires); err != nil {
+		log.Printf("ratelimit %s: %v", l.name, err)
+	}
+}
+
+// Reset forgets the attempts recorded for key, e.g. after a successful login.
+func (l *Limiter) Reset(ctx context.Context, key string) {
+	if l == nil {
+		return
+	}
+	idx, _ := l.window(l.now())
+	for _, i := range []int64{idx, idx - 1} {
+		if err := l.store.Delete(ctx, l.key(key, i)); err != nil {
+			log.Printf("ratelimit %s: %v", l.name, err)
+		}
+	}
+}
+
+// window returns the index of the fixed window holding now and how far into
+// it now is.
+func (l *Limiter) window(now time.Time) (int64, time.Duration) {
+	w := int64(l.rule.Window)
+	ns := now.UnixNano()
+	return ns / w, time.Duration(ns % w)
+}
+
+func (l *Limiter) key(key string, idx int64) string {
+	return l.name + ":" + key + ":" + strconv.FormatInt(idx, 10)
+}
+
+// wait returns how long until the sliding count
+//
+//	prev*(1-elapsed/window) + curr
+//
+// drops below the limit, or -1 if it is below already.
+func (l *Limiter) wait(curr, prev int64, elapsed time.Duration) time.Duration {
+	limit, window := float64(l.rule.Limit), float64(l.rule.Window)
+	c, p, e := float64(curr), float64(prev), float64(elapsed)
+	if p*(1-e/window)+c < limit {
+		return -1
+	}
+	if c >= limit {
+		// Only the next window helps, once enough of this one has slid out.
+		return time.Duration(window - e + window*(1-limit/c))
+	}
+	return max(0, time.Duration(window*(1-(limit-c)/p)-e))
+}
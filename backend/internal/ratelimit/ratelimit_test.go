@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+)
+
+// clock is a settable time source shared by a limiter and its store.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestLimiter(rule Rule) (*Limiter, *MemoryStore, *clock) {
+	c := &clock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	st := NewMemoryStore()
+	st.now = c.now
+	l := New(st, "test", rule)
+	l.now = c.now
+	return l, st, c
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	var rl *Error
+	if !errors.As(err, &rl) || !errors.Is(err, derr.ErrRateLimited) {
+		t.Fatalf("err = %v, want a rate limit error", err)
+	}
+	return rl.RetryAfter
+}
+
+func TestAllowUpToLimit(t *testing.T) {
+	ctx := context.Background()
+	l, _, c := newTestLimiter(Rule{Limit: 3, Window: time.Minute})
+	for i := 0; i < 3; i++ {
+		if err := l.Allow(ctx, "a"); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	// 12:00:00 is a window start: the fourth attempt waits for the next
+	// window, and refused attempts do not count.
+	if got := retryAfter(t, l.Allow(ctx, "a")); got != 61*time.Second {
+		t.Fatalf("RetryAfter = %v, want 61s", got)
+	}
+	retryAfter(t, l.Allow(ctx, "a"))
+	if err := l.Allow(ctx, "b"); err != nil {
+		t.Fatalf("other key: %v", err)
+	}
+	c.t = c.t.Add(61 * time.Second)
+	if err := l.Allow(ctx, "a"); err != nil {
+		t.Fatalf("after RetryAfter: %v", err)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	l, _, c := newTestLimiter(Rule{Limit: 4, Window: time.Minute})
+	c.t = c.t.Add(30 * time.Second)
+	for i := 0; i < 4; i++ {
+		if err := l.Allow(ctx, "a"); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	// 5s into the next window the previous window weighs 4*55/60, so one
+	// more attempt fits.
+	c.t = c.t.Add(35 * time.Second)
+	if err := l.Allow(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	// With that one counted, the previous window must weigh under 3: 15s
+	// into the window, 10s from now, rounded up past it.
+	if got := retryAfter(t, l.Allow(ctx, "a")); got != 11*time.Second {
+		t.Fatalf("RetryAfter = %v, want 11s", got)
+	}
+	c.t = c.t.Add(11 * time.Second)
+	if err := l.Allow(ctx, "a"); err != nil {
+		t.Fatalf("after RetryAfter: %v", err)
+	}
+}
+
+func TestCheckHitReset(t *testing.T) {
+	ctx := context.Background()
+	l, _, _ := newTestLimiter(Rule{Limit: 2, Window: time.Hour})
+	for i := 0; i < 2; i++ {
+		if err := l.Check(ctx, "user"); err != nil {
+			t.Fatalf("Check %d: %v", i+1, err)
+		}
+		l.Hit(ctx, "user")
+	}
+	retryAfter(t, l.Check(ctx, "user"))
+	l.Reset(ctx, "user")
+	if err := l.Check(ctx, "user"); err != nil {
+		t.Fatalf("after Reset: %v", err)
+	}
+}
+
+func TestNilLimiterAllows(t *testing.T) {
+	var l *Limiter
+	if err := l.Allow(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	l.Hit(context.Background(), "a")
+	l.Reset(context.Background(), "a")
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	ctx := context.Background()
+	l, st, c := newTestLimiter(Rule{Limit: 1, Window: time.Minute})
+	for _, key := range []string{"a", "b", "c"} {
+		if err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Counters are read for two windows, then swept.
+	c.t = c.t.Add(2 * time.Minute)
+	if err := l.Allow(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(st.counters); n != 1 {
+		t.Fatalf("counters = %d, want 1 after the sweep", n)
+	}
+}
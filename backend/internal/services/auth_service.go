@@ -2,31 +2,390 @@ package services
 
 import (
     "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/url"
     "regexp"
+    "strings"
     "time"
 
     apiauth "github.com/janvillarosa/gracie-app/backend/internal/auth"
     "github.com/janvillarosa/gracie-app/backend/internal/crypto"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/mail"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/oidc"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
     "github.com/janvillarosa/gracie-app/backend/internal/store"
     "github.com/janvillarosa/gracie-app/backend/pkg/ids"
-    "golang.org/x/crypto/bcrypt"
 )
 
 var emailRe = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
 
 type AuthService struct {
-    users store.UserRepository
-    key   []byte
-    ttl   time.Duration
+    users      store.UserRepository
+    sessions   store.SessionRepository
+    // ring encrypts passwords and TOTP secrets; its root key seeds keys,
+    // tokens and emails.
+    ring       *crypto.Keyring
+    keys       *apiauth.APIKeys
+    hasher     apiauth.PasswordHasher
+    tokens     *apiauth.SessionTokens
+    cache      *apiauth.Cache
+    ttl        time.Duration
+    accessTTL  time.Duration
+    refreshTTL time.Duration
+    userTokens store.UserTokenRepository
+    mailer     mail.Mailer
+    resetURL   string
+    resetTTL   time.Duration
+    emails     *apiauth.EmailTokens
+    verifyURL  string
+    verifyTTL  time.Duration
+    // verifiedLogin blocks Login until the email address is verified.
+    verifiedLogin bool
+    // lockout counts failed passwords per username.
+    lockout *ratelimit.Limiter
+    // mailLimit counts the emails asked for per user.
+    mailLimit *ratelimit.Limiter
+    // oidc holds the single sign-on providers in configured order.
+    oidc         []*oidc.Provider
+    oidcRedirect string
 }
 
-func NewAuthService(users store.UserRepository, encKeyPath string, ttlHours int) (*AuthService, error) {
-    key, err := crypto.LoadOrCreateKey(encKeyPath)
+// JobPurgeSessions removes expired sessions. It is scheduled periodically and
+// takes no payload.
+const JobPurgeSessions = "purge_sessions"
+
+// JobPurgeUserTokens removes expired user tokens such as unused password
+// reset links. It is scheduled periodically and takes no payload.
+const JobPurgeUserTokens = "purge_user_tokens"
+
+// sessionTouchInterval bounds how often a session's LastUsedAt is written.
+const sessionTouchInterval = time.Minute
+
+// maxSessionName is the longest device name kept, in runes.
+const maxSessionName = 100
+
+// Default token lifetimes; see UseTokenLifetimes.
+const (
+    defaultAccessTTL  = 15 * time.Minute
+    defaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// Two-factor login settings.
+const (
+    // totpIssuer labels the account in authenticator apps.
+    totpIssuer = "Gracie"
+    // challengeTTL bounds the time between the password and the second factor.
+    challengeTTL = 5 * time.Minute
+    recoveryCodeCount = 10
+)
+
+// recoveryCodesExpire is the ExpiresAt of recovery codes, which last until
+// they are used or replaced.
+var recoveryCodesExpire = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// oidcFlowTTL bounds the time a user may spend at the identity provider.
+const oidcFlowTTL = 10 * time.Minute
+
+// legacySessionName names the session an API key issued before sessions is moved into.
+const legacySessionName = "Legacy API key"
+
+func NewAuthService(users store.UserRepository, sessions store.SessionRepository, encKeyPath string, ttlHours int) (*AuthService, error) {
+    ring, err := crypto.LoadOrCreateKeyring(encKeyPath)
     if err != nil { return nil, err }
+    keys := apiauth.ServerKeys{ByID: ring.Keys(), Primary: ring.Primary()}
     ttl := time.Duration(ttlHours) * time.Hour
-    return &AuthService{users: users, key: key, ttl: ttl}, nil
+    return &AuthService{
+        users: users, sessions: sessions, ring: ring,
+        keys: apiauth.NewAPIKeys(keys), tokens: apiauth.NewSessionTokens(keys), emails: apiauth.NewEmailTokens(keys),
+        hasher: apiauth.NewArgon2id(apiauth.DefaultArgon2Params),
+        ttl: ttl, accessTTL: defaultAccessTTL, refreshTTL: defaultRefreshTTL,
+    }, nil
+}
+
+// UseTokenLifetimes sets how long access tokens last and how long a token
+// session may go without refreshing before it expires. Zero keeps a default.
+func (s *AuthService) UseTokenLifetimes(access, refresh time.Duration) {
+    if access > 0 { s.accessTTL = access }
+    if refresh > 0 { s.refreshTTL = refresh }
+}
+
+// UsePasswordReset enables RequestPasswordReset and ResetPassword. Reset
+// links are resetURL with the token in the "token" query parameter and stay
+// valid for ttl.
+func (s *AuthService) UsePasswordReset(tokens store.UserTokenRepository, mailer mail.Mailer, resetURL string, ttl time.Duration) {
+    s.userTokens, s.mailer, s.resetURL, s.resetTTL = tokens, mailer, resetURL, ttl
+}
+
+// UseEmailVerification mails a verification link to every address a user
+// registers or switches to. Links are verifyURL with the token in the
+// "token" query parameter and stay valid for ttl. With requireForLogin,
+// Login refuses unverified addresses with derr.ErrEmailUnverified.
+func (s *AuthService) UseEmailVerification(mailer mail.Mailer, verifyURL string, ttl time.Duration, requireForLogin bool) {
+    s.mailer, s.verifyURL, s.verifyTTL, s.verifiedLogin = mailer, verifyURL, ttl, requireForLogin
+}
+
+// UseLoginLockout makes Login count failed passwords per username with l and
+// refuse a username over its limit with a *ratelimit.Error, even with the
+// right password. A successful login clears the count.
+func (s *AuthService) UseLoginLockout(l *ratelimit.Limiter) { s.lockout = l }
+
+// UseMailLimiter makes RequestPasswordReset and RequestVerification count
+// the emails they send per user with l and send none while the user is over
+// the limit. Each kind of email is counted on its own.
+func (s *AuthService) UseMailLimiter(l *ratelimit.Limiter) { s.mailLimit = l }
+
+// UseOIDC enables single sign-on with providers. They redirect back to
+// redirectURL, the frontend page that passes the code on to FinishOIDC; it
+// must be registered with each provider.
+func (s *AuthService) UseOIDC(redirectURL string, providers ...*oidc.Provider) {
+    s.oidcRedirect, s.oidc = redirectURL, providers
+}
+
+// UsePasswordHasher sets how new passwords are hashed; Argon2id with
+// apiauth.DefaultArgon2Params by default. Hashes from other hashers keep
+// working and are replaced at the user's next login.
+func (s *AuthService) UsePasswordHasher(h apiauth.PasswordHasher) { s.hasher = h }
+
+// UseAuthCache injects the cache of verified API keys used by Authenticate.
+// Without it every request queries the key index and checks the hash.
+func (s *AuthService) UseAuthCache(c *apiauth.Cache) { s.cache = c }
+
+// Authenticate returns the user and session owning an unexpired access token
+// or API key, or derr.ErrUnauthorized. A key issued before sessions is moved
+// into a session the first time it verifies.
+func (s *AuthService) Authenticate(ctx context.Context, apiKey string) (*models.User, *models.Session, error) {
+    now := time.Now().UTC()
+    if strings.HasPrefix(apiKey, apiauth.AccessTokenPrefix) { return s.authenticateAccess(ctx, apiKey, now) }
+    // Refresh tokens are only redeemed at Refresh.
+    if strings.HasPrefix(apiKey, apiauth.RefreshTokenPrefix) { return nil, nil, derr.ErrUnauthorized }
+    lookup := apiauth.DeriveLookup(apiKey)
+    if id, hash, ok := s.cache.Get(lookup); ok {
+        ses, err := s.sessions.GetByID(ctx, id)
+        if err == nil && ses.KeyHash == hash { return s.sessionUser(ctx, ses, now) }
+        s.cache.Forget(lookup)
+    }
+    ses, err := s.sessions.GetByKeyLookup(ctx, lookup)
+    if errors.Is(err, derr.ErrNotFound) { ses, err = s.adoptLegacyKey(ctx, apiKey, lookup, now) }
+    if err != nil { return nil, nil, derr.ErrUnauthorized }
+    if ok, _ := s.keys.Verify(ses.KeyHash, apiKey); !ok { return nil, nil, derr.ErrUnauthorized }
+    u, ses, err := s.sessionUser(ctx, ses, now)
+    if err != nil { return nil, nil, err }
+    s.cache.Put(lookup, ses.SessionID, ses.KeyHash)
+    return u, ses, nil
+}
+
+// authenticateAccess checks an access token's signature and expiry; the
+// session must still exist, so revoking it ends its access tokens at once.
+func (s *AuthService) authenticateAccess(ctx context.Context, token string, now time.Time) (*models.User, *models.Session, error) {
+    id, ok := s.tokens.ParseAccess(token, now)
+    if !ok { return nil, nil, derr.ErrUnauthorized }
+    ses, err := s.sessions.GetByID(ctx, id)
+    if err != nil { return nil, nil, derr.ErrUnauthorized }
+    return s.sessionUser(ctx, ses, now)
+}
+
+// sessionUser checks that ses has not expired, loads its user and records
+// the use.
+func (s *AuthService) sessionUser(ctx context.Context, ses *models.Session, now time.Time) (*models.User, *models.Session, error) {
+    if ses.ExpiresAt != nil && now.After(*ses.ExpiresAt) { return nil, nil, derr.ErrUnauthorized }
+    u, err := s.users.GetByID(ctx, ses.UserID)
+    if err != nil { return nil, nil, derr.ErrUnauthorized }
+    if now.Sub(ses.LastUsedAt) >= sessionTouchInterval {
+        // Best effort: a failed write only leaves LastUsedAt behind.
+        if err := s.sessions.Touch(ctx, ses.SessionID, now); err == nil { ses.LastUsedAt = now }
+    }
+    return u, ses, nil
+}
+
+// adoptLegacyKey moves the API key stored on a user before sessions existed
+// into a session, rehashed with HMAC if it was a bcrypt hash. The session ID
+// is derived from the user, so concurrent first uses create one session.
+func (s *AuthService) adoptLegacyKey(ctx context.Context, apiKey, lookup string, now time.Time) (*models.Session, error) {
+    u, err := s.users.GetByAPIKeyLookup(ctx, lookup)
+    if err != nil || u == nil { return nil, derr.ErrUnauthorized }
+    if ok, _ := s.keys.Verify(u.APIKeyHash, apiKey); !ok { return nil, derr.ErrUnauthorized }
+    ses := &models.Session{
+        SessionID:  "ses_legacy_" + u.UserID,
+        UserID:     u.UserID,
+        Name:       legacySessionName,
+        KeyHash:    s.keys.Hash(apiKey),
+        KeyLookup:  lookup,
+        CreatedAt:  now,
+        LastUsedAt: now,
+        ExpiresAt:  u.APIKeyExpiresAt,
+    }
+    err = s.sessions.Create(ctx, ses)
+    if errors.Is(err, derr.ErrConflict) {
+        ses, err = s.sessions.GetByID(ctx, ses.SessionID)
+    }
+    if err != nil { return nil, err }
+    // Already cleared by a concurrent adoption if it fails with ErrNotFound.
+    if err := s.users.ClearAPIKey(ctx, u.UserID, lookup); err != nil && !errors.Is(err, derr.ErrNotFound) { return nil, err }
+    return ses, nil
+}
+
+// IssueSession creates a session for userID on the device called name and
+// returns its API key, which is not stored and cannot be shown again.
+func (s *AuthService) IssueSession(ctx context.Context, userID, name string) (string, *models.Session, error) {
+    plain, hash := s.keys.Generate()
+    now := time.Now().UTC()
+    ses := &models.Session{
+        SessionID:  ids.NewID("ses"),
+        UserID:     userID,
+        Name:       sessionName(name),
+        KeyHash:    hash,
+        KeyLookup:  apiauth.DeriveLookup(plain),
+        CreatedAt:  now,
+        LastUsedAt: now,
+    }
+    if s.ttl > 0 {
+        exp := now.Add(s.ttl)
+        ses.ExpiresAt = &exp
+    }
+    if err := s.sessions.Create(ctx, ses); err != nil { return "", nil, err }
+    return plain, ses, nil
+}
+
+// TokenPair is what a token session hands its client: a short-lived access
+// token for requests and a single-use refresh token to get the next pair.
+type TokenPair struct {
+    AccessToken  string
+    RefreshToken string
+    // ExpiresIn is the access token's lifetime.
+    ExpiresIn time.Duration
+}
+
+func (s *AuthService) tokenPair(ses *models.Session, now time.Time) *TokenPair {
+    return &TokenPair{
+        AccessToken:  s.tokens.Access(ses.SessionID, now.Add(s.accessTTL)),
+        RefreshToken: s.tokens.Refresh(ses.SessionID, ses.RefreshGen),
+        ExpiresIn:    s.accessTTL,
+    }
+}
+
+// IssueTokens creates a token session for userID on the device called name.
+// It expires unless refreshed within the refresh lifetime.
+func (s *AuthService) IssueTokens(ctx context.Context, userID, name string) (*TokenPair, *models.Session, error) {
+    now := time.Now().UTC()
+    exp := now.Add(s.refreshTTL)
+    ses := &models.Session{
+        SessionID:  ids.NewID("ses"),
+        UserID:     userID,
+        Name:       sessionName(name),
+        RefreshGen: 1,
+        CreatedAt:  now,
+        LastUsedAt: now,
+        ExpiresAt:  &exp,
+    }
+    if err := s.sessions.Create(ctx, ses); err != nil { return nil, nil, err }
+    return s.tokenPair(ses, now), ses, nil
+}
+
+// Refresh redeems a refresh token for a new token pair and extends the
+// session. Each refresh token works once: presenting one that was already
+// redeemed means it leaked, so the whole session is revoked and both the
+// thief and the owner must sign in again.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, *models.Session, error) {
+    id, gen, ok := s.tokens.ParseRefresh(refreshToken)
+    if !ok { return nil, nil, derr.ErrUnauthorized }
+    ses, err := s.sessions.GetByID(ctx, id)
+    if err != nil { return nil, nil, derr.ErrUnauthorized }
+    now := time.Now().UTC()
+    if ses.ExpiresAt != nil && now.After(*ses.ExpiresAt) { return nil, nil, derr.ErrUnauthorized }
+    if gen != ses.RefreshGen {
+        s.revokeReplayed(ctx, ses)
+        return nil, nil, derr.ErrUnauthorized
+    }
+    exp := now.Add(s.refreshTTL)
+    err = s.sessions.Rotate(ctx, id, gen, now, &exp)
+    if errors.Is(err, derr.ErrConflict) {
+        // Another request redeemed the same token first.
+        s.revokeReplayed(ctx, ses)
+        return nil, nil, derr.ErrUnauthorized
+    }
+    if errors.Is(err, derr.ErrNotFound) { return nil, nil, derr.ErrUnauthorized }
+    if err != nil { return nil, nil, err }
+    ses.RefreshGen, ses.LastUsedAt, ses.ExpiresAt = gen+1, now, &exp
+    return s.tokenPair(ses, now), ses, nil
+}
+
+func (s *AuthService) revokeReplayed(ctx context.Context, ses *models.Session) {
+    log.Printf("auth: refresh token reused for session %s; revoking it", ses.SessionID)
+    if err := s.sessions.Delete(ctx, ses.SessionID); err != nil && !errors.Is(err, derr.ErrNotFound) {
+        log.Printf("auth: revoke session %s: %v", ses.SessionID, err)
+    }
+}
+
+func sessionName(name string) string {
+    r := []rune(strings.TrimSpace(name))
+    if len(r) > maxSessionName { r = r[:maxSessionName] }
+    return string(r)
+}
+
+// ListSessions returns the user's unexpired sessions, most recently used first.
+func (s *AuthService) ListSessions(ctx context.Context, userID string) ([]models.Session, error) {
+    all, err := s.sessions.ListByUser(ctx, userID)
+    if err != nil { return nil, err }
+    now := time.Now().UTC()
+    out := make([]models.Session, 0, len(all))
+    for _, ses := range all {
+        if ses.ExpiresAt != nil && now.After(*ses.ExpiresAt) { continue }
+        out = append(out, ses)
+    }
+    return out, nil
+}
+
+// RevokeSession signs a device out. It returns derr.ErrNotFound unless the
+// session belongs to userID.
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+    ses, err := s.sessions.GetByID(ctx, sessionID)
+    if err != nil { return err }
+    if ses.UserID != userID { return derr.ErrNotFound }
+    if err := s.sessions.Delete(ctx, sessionID); err != nil { return err }
+    s.cache.Forget(ses.KeyLookup)
+    return nil
+}
+
+// RevokeOtherSessions signs out every session of userID except keepID and
+// returns how many were revoked.
+func (s *AuthService) RevokeOtherSessions(ctx context.Context, userID, keepID string) (int, error) {
+    all, err := s.sessions.ListByUser(ctx, userID)
+    if err != nil { return 0, err }
+    n := 0
+    for _, ses := range all {
+        if ses.SessionID == keepID { continue }
+        err := s.sessions.Delete(ctx, ses.SessionID)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return n, err }
+        s.cache.Forget(ses.KeyLookup)
+        n++
+    }
+    return n, nil
+}
+
+// RevokeAllSessions signs out every session of userID, including a key
+// issued before sessions that has not been used since.
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID string) error {
+    if _, err := s.sessions.DeleteByUser(ctx, userID); err != nil { return err }
+    u, err := s.users.GetByID(ctx, userID)
+    if errors.Is(err, derr.ErrNotFound) { return nil }
+    if err != nil { return err }
+    if u.APIKeyLookup == "" { return nil }
+    if err := s.users.ClearAPIKey(ctx, userID, u.APIKeyLookup); err != nil && !errors.Is(err, derr.ErrNotFound) { return err }
+    return nil
+}
+
+// PurgeExpiredSessions handles JobPurgeSessions.
+func (s *AuthService) PurgeExpiredSessions(ctx context.Context, _ models.Job) error {
+    n, err := s.sessions.DeleteExpired(ctx, time.Now().UTC())
+    if err != nil { return fmt.Errorf("purge sessions: %w", err) }
+    if n > 0 { log.Printf("purge_sessions: removed %d expired sessions", n) }
+    return nil
 }
 
 func (s *AuthService) Register(ctx context.Context, username, password, name string) error {
@@ -39,10 +398,8 @@ func (s *AuthService) Register(ctx context.Context, username, password, name str
         return derr.ErrConflict
     }
     userID := ids.NewID("usr")
-    // Hash password with bcrypt, then encrypt the hash at-rest
-    ph, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-    if err != nil { return err }
-    enc, err := crypto.Encrypt(s.key, ph)
+    // Hash the password, then encrypt the hash at-rest
+    enc, err := s.hashPassword(password)
     if err != nil { return err }
     user := &models.User{
         UserID:      userID,
@@ -52,71 +409,581 @@ func (s *AuthService) Register(ctx context.Context, username, password, name str
         CreatedAt:   now,
         UpdatedAt:   now,
     }
-    return s.users.Put(ctx, user)
+    if err := s.users.Put(ctx, user); err != nil { return err }
+    // The account works without it; the user can ask for another link.
+    if err := s.SendVerification(ctx, user); err != nil { log.Printf("auth: verification mail for %s: %v", userID, err) }
+    return nil
 }
 
 type LoginResult struct {
-    User   *models.User
-    APIKey string
+    User    *models.User
+    Session *models.Session
+    Tokens  *TokenPair
+    // Challenge is set instead of the other fields when the user has
+    // two-factor login on: LoginTOTP redeems it with a code.
+    Challenge string
 }
 
-func (s *AuthService) Login(ctx context.Context, username, password string) (*LoginResult, error) {
+// Login checks the password and signs in a new token session for the device
+// called device. Other sessions stay signed in. When verification is required
+// for login, an unverified address fails with derr.ErrEmailUnverified; a
+// username locked out by failed passwords fails with *ratelimit.Error. A user
+// with two-factor login gets a Challenge and no session yet.
+func (s *AuthService) Login(ctx context.Context, username, password, device string) (*LoginResult, error) {
+    // Unknown usernames are counted too, so a lockout does not tell them apart.
+    lockKey := strings.ToLower(strings.TrimSpace(username))
+    if err := s.lockout.Check(ctx, lockKey); err != nil { return nil, err }
+    u, err := s.checkPassword(ctx, username, password)
+    if err != nil {
+        s.lockout.Hit(ctx, lockKey)
+        return nil, err
+    }
+    s.lockout.Reset(ctx, lockKey)
+    if s.verifiedLogin && !EmailVerified(u) { return nil, derr.ErrEmailUnverified }
+    if TOTPEnabled(u) { return s.challenge(u), nil }
+    tokens, ses, err := s.IssueTokens(ctx, u.UserID, device)
+    if err != nil { return nil, err }
+    return &LoginResult{User: u, Session: ses, Tokens: tokens}, nil
+}
+
+// checkPassword returns the user called username if password is theirs, or
+// derr.ErrUnauthorized.
+func (s *AuthService) checkPassword(ctx context.Context, username, password string) (*models.User, error) {
     u, err := s.users.GetByUsername(ctx, username)
     if err != nil { return nil, derr.ErrUnauthorized }
-    if u.PasswordEnc == "" {
-        return nil, derr.ErrUnauthorized
+    ph, ok := s.verifyPassword(u, password)
+    if !ok { return nil, derr.ErrUnauthorized }
+    if s.hasher.NeedsRehash(ph) { s.rehashPassword(ctx, u, password) }
+    return u, nil
+}
+
+// verifyPassword returns u's decrypted password hash and whether password
+// matches it. A user without a password matches nothing.
+func (s *AuthService) verifyPassword(u *models.User, password string) (string, bool) {
+    if u.PasswordEnc == "" { return "", false }
+    ph, err := s.ring.Decrypt(u.PasswordEnc)
+    if err != nil { return "", false }
+    return string(ph), apiauth.VerifyPassword(string(ph), password)
+}
+
+// hashPassword returns the encrypted hash of password for PasswordEnc.
+func (s *AuthService) hashPassword(password string) (string, error) {
+    ph, err := s.hasher.Hash(password)
+    if err != nil { return "", err }
+    return s.ring.Encrypt([]byte(ph))
+}
+
+// rehashPassword replaces u's password hash, made by an older algorithm or
+// with weaker parameters, with one from the current hasher. It only logs
+// failures: the login goes ahead and the next one tries again. Sessions are
+// kept, since the password is the same.
+func (s *AuthService) rehashPassword(ctx context.Context, u *models.User, password string) {
+    enc, err := s.hashPassword(password)
+    if err == nil {
+        // Only while the hash is the one checked, so a concurrent change wins.
+        err = s.users.ReencryptSecrets(ctx, u.UserID, u.PasswordEnc, enc, "", "")
+    }
+    if err != nil && !errors.Is(err, derr.ErrNotFound) {
+        log.Printf("auth: rehash password of %s: %v", u.UserID, err)
+        return
     }
-    // Decrypt stored bcrypt hash
-    ph, err := crypto.Decrypt(s.key, u.PasswordEnc)
+    if err == nil { u.PasswordEnc = enc }
+}
+
+// ChangePassword verifies the current password when present, sets the new password,
+// signs out every session and returns the tokens of a new session for the
+// device called device. Enforces minimum length.
+func (s *AuthService) ChangePassword(ctx context.Context, userID, current, next, device string) (*TokenPair, error) {
+    if len(next) < 8 { return nil, derr.ErrBadRequest }
+    u, err := s.users.GetByID(ctx, userID)
     if err != nil { return nil, derr.ErrUnauthorized }
-    if bcrypt.CompareHashAndPassword(ph, []byte(password)) != nil {
-        return nil, derr.ErrUnauthorized
+    if u.PasswordEnc != "" {
+        if current == "" { return nil, derr.ErrBadRequest }
+        if _, ok := s.verifyPassword(u, current); !ok { return nil, derr.ErrUnauthorized }
     }
-    // Rotate API key and return plain to client
-    plain, hash, err := apiauth.GenerateAPIKey()
-    if err != nil { return nil, err }
-    lookup := apiauth.DeriveLookup(plain)
+    if err := s.replacePassword(ctx, userID, next); err != nil { return nil, err }
+    tokens, _, err := s.IssueTokens(ctx, userID, device)
+    return tokens, err
+}
+
+// replacePassword stores next as userID's password and signs out every session.
+func (s *AuthService) replacePassword(ctx context.Context, userID, next string) error {
+    enc, err := s.hashPassword(next)
+    if err != nil { return err }
     now := time.Now().UTC()
-    var exp *time.Time
-    if s.ttl > 0 {
-        e := now.Add(s.ttl)
-        exp = &e
+    if err := s.users.UpdatePasswordEnc(ctx, userID, enc, now); err != nil { return err }
+    return s.RevokeAllSessions(ctx, userID)
+}
+
+// RequestPasswordReset mails a single-use reset link to username, replacing
+// any link sent before. An unknown username is not an error, so the response
+// does not reveal which accounts exist. A user over the mail limit gets no
+// link and a *ratelimit.Error, which callers must not pass on for the same
+// reason.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, username string) error {
+    if s.userTokens == nil || s.mailer == nil { return derr.ErrNotFound }
+    u, err := s.users.GetByUsername(ctx, strings.TrimSpace(username))
+    if errors.Is(err, derr.ErrNotFound) { return nil }
+    if err != nil { return err }
+    if err := s.mailLimit.Allow(ctx, "reset:"+u.UserID); err != nil { return err }
+    if _, err := s.userTokens.DeleteByUser(ctx, u.UserID, models.TokenPasswordReset); err != nil { return err }
+    plain, hash := apiauth.NewUserToken()
+    now := time.Now().UTC()
+    t := &models.UserToken{TokenHash: hash, Purpose: models.TokenPasswordReset, UserID: u.UserID, CreatedAt: now, ExpiresAt: now.Add(s.resetTTL)}
+    if err := s.userTokens.Create(ctx, t); err != nil { return err }
+    link := s.resetURL + "?token=" + url.QueryEscape(plain)
+    body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your Gracie account. To choose a new password, open this link within %s:\n\n%s\n\nIf it wasn't you, ignore this email; your password has not changed.\n", u.Name, lifetimeText(s.resetTTL), link)
+    return s.mailer.Send(ctx, mail.Message{To: u.Username, Subject: "Reset your Gracie password", Body: body})
+}
+
+// ResetPassword redeems a reset token: it sets the new password, signs out
+// every session like ChangePassword and signs in a token session for the
+// device called device, or returns a Challenge like Login when the user has
+// two-factor login on. A used, expired or unknown token is derr.ErrUnauthorized.
+func (s *AuthService) ResetPassword(ctx context.Context, token, next, device string) (*LoginResult, error) {
+    if len(next) < 8 || token == "" { return nil, derr.ErrBadRequest }
+    if s.userTokens == nil { return nil, derr.ErrUnauthorized }
+    t, err := s.userTokens.Consume(ctx, apiauth.HashUserToken(token), models.TokenPasswordReset)
+    if errors.Is(err, derr.ErrNotFound) { return nil, derr.ErrUnauthorized }
+    if err != nil { return nil, err }
+    if time.Now().UTC().After(t.ExpiresAt) { return nil, derr.ErrUnauthorized }
+    u, err := s.users.GetByID(ctx, t.UserID)
+    if errors.Is(err, derr.ErrNotFound) { return nil, derr.ErrUnauthorized }
+    if err != nil { return nil, err }
+    if err := s.replacePassword(ctx, u.UserID, next); err != nil { return nil, err }
+    // The link reached the current address: EmailChanged voids older ones.
+    if !EmailVerified(u) {
+        now := time.Now().UTC()
+        if err := s.users.MarkEmailVerified(ctx, u.UserID, u.Username, now); err == nil { u.EmailVerifiedAt = &now }
     }
-    if err := s.users.SetAPIKey(ctx, u.UserID, hash, lookup, exp, now); err != nil {
-        return nil, err
+    // Links requested after this one was sent are void too.
+    if _, err := s.userTokens.DeleteByUser(ctx, u.UserID, models.TokenPasswordReset); err != nil {
+        log.Printf("auth: clear reset tokens of %s: %v", u.UserID, err)
     }
-    return &LoginResult{User: u, APIKey: plain}, nil
+    // The mailbox is one factor; the authenticator is still asked for.
+    if TOTPEnabled(u) { return s.challenge(u), nil }
+    tokens, ses, err := s.IssueTokens(ctx, u.UserID, device)
+    if err != nil { return nil, err }
+    return &LoginResult{User: u, Session: ses, Tokens: tokens}, nil
 }
 
-// ChangePassword verifies the current password when present, sets the new password,
-// rotates the API key, and returns the new api key. Enforces minimum length.
-func (s *AuthService) ChangePassword(ctx context.Context, userID, current, next string) (string, error) {
-    if len(next) < 8 { return "", derr.ErrBadRequest }
+// EmailVerified reports whether u has proven its address, or has none: users
+// created without a username have nothing to verify.
+func EmailVerified(u *models.User) bool { return u.Username == "" || u.EmailVerifiedAt != nil }
+
+// SendVerification mails u a link that verifies its current username. It
+// does nothing for verified users or without a mailer.
+func (s *AuthService) SendVerification(ctx context.Context, u *models.User) error {
+    if s.mailer == nil || s.verifyURL == "" || EmailVerified(u) { return nil }
+    token := s.emails.Sign(u.UserID, u.Username, time.Now().UTC().Add(s.verifyTTL))
+    link := s.verifyURL + "?token=" + url.QueryEscape(token)
+    body := fmt.Sprintf("Hi %s,\n\nPlease confirm that this is your email address for Gracie by opening this link within %s:\n\n%s\n\nIf you did not sign up, ignore this email.\n", u.Name, lifetimeText(s.verifyTTL), link)
+    return s.mailer.Send(ctx, mail.Message{To: u.Username, Subject: "Verify your Gracie email address", Body: body})
+}
+
+// RequestVerification mails a new verification link to username. Like
+// RequestPasswordReset it does not reveal whether the account exists, and
+// fails with a *ratelimit.Error when the user is over the mail limit.
+func (s *AuthService) RequestVerification(ctx context.Context, username string) error {
+    u, err := s.users.GetByUsername(ctx, strings.TrimSpace(username))
+    if errors.Is(err, derr.ErrNotFound) || (err == nil && EmailVerified(u)) { return nil }
+    if err != nil { return err }
+    if err := s.mailLimit.Allow(ctx, "verify:"+u.UserID); err != nil { return err }
+    return s.SendVerification(ctx, u)
+}
+
+// VerifyEmail redeems a verification link and returns the verified user. A
+// link for an address the user no longer has, or an expired one, is
+// derr.ErrUnauthorized. Verifying twice is not an error.
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+    id, ok := s.emails.UserID(token)
+    if !ok { return nil, derr.ErrUnauthorized }
+    u, err := s.users.GetByID(ctx, id)
+    if errors.Is(err, derr.ErrNotFound) { return nil, derr.ErrUnauthorized }
+    if err != nil { return nil, err }
+    now := time.Now().UTC()
+    if !s.emails.Valid(token, u.Username, now) { return nil, derr.ErrUnauthorized }
+    if u.EmailVerifiedAt != nil { return u, nil }
+    err = s.users.MarkEmailVerified(ctx, u.UserID, u.Username, now)
+    // The username changed since it was loaded.
+    if errors.Is(err, derr.ErrNotFound) { return nil, derr.ErrUnauthorized }
+    if err != nil { return nil, err }
+    u.EmailVerifiedAt = &now
+    return u, nil
+}
+
+// EmailChanged is called after u's username changed. Reset links sent to the
+// old address are voided and the new address gets a verification link.
+func (s *AuthService) EmailChanged(ctx context.Context, u *models.User) error {
+    if s.userTokens != nil {
+        if _, err := s.userTokens.DeleteByUser(ctx, u.UserID, models.TokenPasswordReset); err != nil { return err }
+    }
+    return s.SendVerification(ctx, u)
+}
+
+// PurgeExpiredUserTokens handles JobPurgeUserTokens.
+func (s *AuthService) PurgeExpiredUserTokens(ctx context.Context, _ models.Job) error {
+    if s.userTokens == nil { return nil }
+    n, err := s.userTokens.DeleteExpired(ctx, time.Now().UTC())
+    if err != nil { return fmt.Errorf("purge user tokens: %w", err) }
+    if n > 0 { log.Printf("purge_user_tokens: removed %d expired tokens", n) }
+    return nil
+}
+
+// TOTPEnabled reports whether u signs in with a TOTP code after the password.
+func TOTPEnabled(u *models.User) bool { return u.TOTPEnabledAt != nil && u.TOTPSecretEnc != "" }
+
+// TOTPSetup is a new secret for an authenticator app, as text to type in and
+// as an otpauth:// URI to show as a QR code.
+type TOTPSetup struct {
+    Secret string
+    URI    string
+}
+
+// StartTOTP generates a TOTP secret for userID, replacing one that awaits
+// confirmation. Two-factor login stays off until ConfirmTOTP; it fails with
+// derr.ErrConflict when it is on already.
+func (s *AuthService) StartTOTP(ctx context.Context, userID string) (*TOTPSetup, error) {
+    // Recovery codes are kept with the user tokens.
+    if s.userTokens == nil { return nil, derr.ErrNotFound }
     u, err := s.users.GetByID(ctx, userID)
-    if err != nil { return "", derr.ErrUnauthorized }
-    if u.PasswordEnc != "" {
-        if current == "" { return "", derr.ErrBadRequest }
-        ph, err := crypto.Decrypt(s.key, u.PasswordEnc)
-        if err != nil { return "", derr.ErrUnauthorized }
-        if bcrypt.CompareHashAndPassword(ph, []byte(current)) != nil { return "", derr.ErrUnauthorized }
+    if err != nil { return nil, err }
+    if TOTPEnabled(u) { return nil, derr.ErrConflict }
+    secret := apiauth.NewTOTPSecret()
+    enc, err := s.ring.Encrypt([]byte(secret))
+    if err != nil { return nil, err }
+    if err := s.users.SetTOTPSecret(ctx, userID, enc, time.Now().UTC()); err != nil { return nil, err }
+    account := u.Username
+    if account == "" { account = u.Name }
+    return &TOTPSetup{Secret: secret, URI: apiauth.TOTPURI(totpIssuer, account, secret)}, nil
+}
+
+// ConfirmTOTP turns two-factor login on once code shows that the
+// authenticator holds the secret from StartTOTP, and returns the user's
+// recovery codes. They are not stored and cannot be shown again.
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+    u, err := s.users.GetByID(ctx, userID)
+    if err != nil { return nil, err }
+    if TOTPEnabled(u) { return nil, derr.ErrConflict }
+    if u.TOTPSecretEnc == "" || s.userTokens == nil { return nil, derr.ErrBadRequest }
+    secret, err := s.ring.Decrypt(u.TOTPSecretEnc)
+    if err != nil { return nil, err }
+    step, ok := apiauth.MatchTOTP(string(secret), code, time.Now().UTC())
+    if !ok { return nil, derr.ErrUnauthorized }
+    if err := s.useTOTPStep(ctx, userID, step); err != nil { return nil, err }
+    err = s.users.EnableTOTP(ctx, userID, u.TOTPSecretEnc, time.Now().UTC())
+    // StartTOTP ran again since u was loaded.
+    if errors.Is(err, derr.ErrNotFound) { return nil, derr.ErrConflict }
+    if err != nil { return nil, err }
+    return s.newRecoveryCodes(ctx, userID)
+}
+
+// LoginTOTP finishes a Login that returned a Challenge. code is the current
+// code of the user's authenticator or one of their recovery codes, which is
+// then used up. Wrong codes are counted like wrong passwords.
+func (s *AuthService) LoginTOTP(ctx context.Context, challenge, code, device string) (*LoginResult, error) {
+    id, ok := s.tokens.ParseChallenge(challenge, time.Now().UTC())
+    if !ok { return nil, derr.ErrUnauthorized }
+    u, err := s.users.GetByID(ctx, id)
+    if err != nil { return nil, derr.ErrUnauthorized }
+    if err := s.checkSecondFactor(ctx, u, code); err != nil { return nil, err }
+    tokens, ses, err := s.IssueTokens(ctx, u.UserID, device)
+    if err != nil { return nil, err }
+    return &LoginResult{User: u, Session: ses, Tokens: tokens}, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of userID after
+// checking code like LoginTOTP.
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+    u, err := s.users.GetByID(ctx, userID)
+    if err != nil { return nil, err }
+    if err := s.checkSecondFactor(ctx, u, code); err != nil { return nil, err }
+    return s.newRecoveryCodes(ctx, userID)
+}
+
+// DisableTOTP turns two-factor login off after checking code like LoginTOTP
+// and drops the recovery codes. A secret awaiting confirmation is dropped
+// without a code.
+func (s *AuthService) DisableTOTP(ctx context.Context, userID, code string) error {
+    u, err := s.users.GetByID(ctx, userID)
+    if err != nil { return err }
+    if TOTPEnabled(u) {
+        if err := s.checkSecondFactor(ctx, u, code); err != nil { return err }
     }
-    // Hash and encrypt new password
-    phNew, err := bcrypt.GenerateFromPassword([]byte(next), bcrypt.DefaultCost)
-    if err != nil { return "", err }
-    enc, err := crypto.Encrypt(s.key, phNew)
-    if err != nil { return "", err }
+    if err := s.users.SetTOTPSecret(ctx, userID, "", time.Now().UTC()); err != nil { return err }
+    return s.DeleteRecoveryCodes(ctx, userID)
+}
+
+// DeleteRecoveryCodes removes the recovery codes of userID, e.g. when the
+// account is deleted: unlike other user tokens they never expire.
+func (s *AuthService) DeleteRecoveryCodes(ctx context.Context, userID string) error {
+    if s.userTokens == nil { return nil }
+    _, err := s.userTokens.DeleteByUser(ctx, userID, models.TokenRecoveryCode)
+    return err
+}
+
+// challenge returns the LoginResult of a password accepted for u, pending
+// the second factor.
+func (s *AuthService) challenge(u *models.User) *LoginResult {
+    return &LoginResult{Challenge: s.tokens.Challenge(u.UserID, time.Now().UTC().Add(challengeTTL))}
+}
+
+// checkSecondFactor accepts a TOTP code of u that was not used before, or one
+// of u's recovery codes, which is used up. Anything else is
+// derr.ErrUnauthorized and counts toward the login lockout, so codes cannot
+// be guessed; a user locked out gets a *ratelimit.Error.
+func (s *AuthService) checkSecondFactor(ctx context.Context, u *models.User, code string) error {
+    if !TOTPEnabled(u) || s.userTokens == nil { return derr.ErrUnauthorized }
+    lockKey := "totp:" + u.UserID
+    if err := s.lockout.Check(ctx, lockKey); err != nil { return err }
+    secret, err := s.ring.Decrypt(u.TOTPSecretEnc)
+    if err != nil { return err }
+    if step, ok := apiauth.MatchTOTP(string(secret), code, time.Now().UTC()); ok {
+        err = s.useTOTPStep(ctx, u.UserID, step)
+    } else {
+        err = s.useRecoveryCode(ctx, u.UserID, code)
+    }
+    if errors.Is(err, derr.ErrUnauthorized) { s.lockout.Hit(ctx, lockKey) }
+    if err != nil { return err }
+    s.lockout.Reset(ctx, lockKey)
+    return nil
+}
+
+// useTOTPStep records that userID used the code of step, failing with
+// derr.ErrUnauthorized if it was used before. The record outlives the
+// steps MatchTOTP accepts around step.
+func (s *AuthService) useTOTPStep(ctx context.Context, userID string, step int64) error {
     now := time.Now().UTC()
-    if err := s.users.UpdatePasswordEnc(ctx, userID, enc, now); err != nil { return "", err }
+    t := &models.UserToken{
+        TokenHash: s.keys.Hash(fmt.Sprintf("totp:%s:%d", userID, step)),
+        Purpose:   models.TokenTOTPStep,
+        UserID:    userID,
+        CreatedAt: now,
+        ExpiresAt: now.Add(time.Duration(2*apiauth.TOTPSkew+1) * apiauth.TOTPPeriod),
+    }
+    err := s.userTokens.Create(ctx, t)
+    if errors.Is(err, derr.ErrConflict) { return derr.ErrUnauthorized }
+    return err
+}
 
-    // Rotate API key
-    plain, hash, err := apiauth.GenerateAPIKey()
-    if err != nil { return "", err }
-    lookup := apiauth.DeriveLookup(plain)
-    var exp *time.Time
-    if s.ttl > 0 {
-        e := now.Add(s.ttl)
-        exp = &e
+// newRecoveryCodes replaces the recovery codes of userID and returns them.
+func (s *AuthService) newRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+    if err := s.DeleteRecoveryCodes(ctx, userID); err != nil { return nil, err }
+    now := time.Now().UTC()
+    codes := make([]string, recoveryCodeCount)
+    for i := range codes {
+        codes[i] = apiauth.NewRecoveryCode()
+        t := &models.UserToken{TokenHash: s.recoveryHash(userID, codes[i]), Purpose: models.TokenRecoveryCode, UserID: userID, CreatedAt: now, ExpiresAt: recoveryCodesExpire}
+        if err := s.userTokens.Create(ctx, t); err != nil { return nil, err }
+    }
+    return codes, nil
+}
+
+// useRecoveryCode consumes a recovery code of userID, made under any key of
+// the keyring, or fails with derr.ErrUnauthorized.
+func (s *AuthService) useRecoveryCode(ctx context.Context, userID, code string) error {
+    for _, hash := range s.keys.Hashes(recoveryCodeInput(userID, code)) {
+        _, err := s.userTokens.Consume(ctx, hash, models.TokenRecoveryCode)
+        if !errors.Is(err, derr.ErrNotFound) { return err }
+    }
+    return derr.ErrUnauthorized
+}
+
+// recoveryHash is the stored form of a recovery code. Codes are short, so
+// the hash is keyed like API keys; it names the user so that a guess only
+// ever redeems that user's codes.
+func (s *AuthService) recoveryHash(userID, code string) string {
+    return s.keys.Hash(recoveryCodeInput(userID, code))
+}
+
+func recoveryCodeInput(userID, code string) string {
+    return "recovery:" + userID + ":" + apiauth.NormalizeRecoveryCode(code)
+}
+
+// OIDCProviders returns the single sign-on providers users can pick from.
+func (s *AuthService) OIDCProviders() []*oidc.Provider { return s.oidc }
+
+// oidcFlow is what FinishOIDC needs from StartOIDC. The client keeps it,
+// encrypted, so no login state is stored server-side.
+type oidcFlow struct {
+    Provider string    `json:"p"`
+    State    string    `json:"s"`
+    Nonce    string    `json:"n"`
+    Verifier string    `json:"v"`
+    Expires  time.Time `json:"e"`
+}
+
+// StartOIDC begins a single sign-on with provider. The user is sent to
+// authURL; flow must be handed back to FinishOIDC along with the code and
+// state the provider redirects back with. An unknown provider is
+// derr.ErrNotFound.
+func (s *AuthService) StartOIDC(ctx context.Context, provider string) (authURL, flow string, err error) {
+    p := s.oidcProvider(provider)
+    if p == nil { return "", "", derr.ErrNotFound }
+    authURL, f, err := p.Begin(ctx, s.oidcRedirect)
+    if err != nil { return "", "", err }
+    b, err := json.Marshal(oidcFlow{Provider: p.ID(), State: f.State, Nonce: f.Nonce, Verifier: f.Verifier, Expires: time.Now().UTC().Add(oidcFlowTTL)})
+    if err != nil { return "", "", err }
+    flow, err = s.ring.Encrypt(b)
+    if err != nil { return "", "", err }
+    return authURL, flow, nil
+}
+
+// FinishOIDC redeems the code of a single sign-on started by StartOIDC and
+// signs the user in like Login, including the second factor. The provider
+// must vouch for the email address: it signs in the user with that username,
+// or a new passwordless user. A flow that does not match, has expired or
+// whose code the provider refuses is derr.ErrUnauthorized; an unverified
+// address is derr.ErrEmailUnverified.
+func (s *AuthService) FinishOIDC(ctx context.Context, provider, flow, code, state, device string) (*LoginResult, error) {
+    p := s.oidcProvider(provider)
+    if p == nil { return nil, derr.ErrNotFound }
+    raw, err := s.ring.Decrypt(flow)
+    if err != nil { return nil, derr.ErrUnauthorized }
+    var f oidcFlow
+    if err := json.Unmarshal(raw, &f); err != nil { return nil, derr.ErrUnauthorized }
+    if f.Provider != p.ID() || f.State == "" || f.State != state || time.Now().UTC().After(f.Expires) {
+        return nil, derr.ErrUnauthorized
+    }
+    claims, err := p.Exchange(ctx, code, s.oidcRedirect, &oidc.Flow{State: f.State, Nonce: f.Nonce, Verifier: f.Verifier})
+    if err != nil {
+        log.Printf("auth: oidc %s: %v", p.ID(), err)
+        return nil, derr.ErrUnauthorized
+    }
+    email := strings.TrimSpace(claims.Email)
+    if !claims.EmailVerified || !emailRe.MatchString(email) { return nil, derr.ErrEmailUnverified }
+    u, err := s.oidcUser(ctx, email, claims.Name)
+    if err != nil { return nil, err }
+    if TOTPEnabled(u) { return s.challenge(u), nil }
+    tokens, ses, err := s.IssueTokens(ctx, u.UserID, device)
+    if err != nil { return nil, err }
+    return &LoginResult{User: u, Session: ses, Tokens: tokens}, nil
+}
+
+// oidcUser returns the user called email, linking it to the provider that
+// vouched for the address, or creates one. An account whose address was
+// never verified may have been registered by someone else to take over the
+// real owner's sign-ups, so its password and sessions are dropped.
+func (s *AuthService) oidcUser(ctx context.Context, email, name string) (*models.User, error) {
+    now := time.Now().UTC()
+    u, err := s.users.GetByUsername(ctx, email)
+    if err == nil {
+        if EmailVerified(u) { return u, nil }
+        if err := s.users.UpdatePasswordEnc(ctx, u.UserID, "", now); err != nil { return nil, err }
+        if err := s.RevokeAllSessions(ctx, u.UserID); err != nil { return nil, err }
+        if err := s.users.MarkEmailVerified(ctx, u.UserID, u.Username, now); err != nil { return nil, err }
+        u.PasswordEnc, u.EmailVerifiedAt = "", &now
+        return u, nil
+    }
+    if !errors.Is(err, derr.ErrNotFound) { return nil, err }
+    if name = strings.TrimSpace(name); name == "" { name = email[:strings.IndexByte(email, '@')] }
+    u = &models.User{
+        UserID:          ids.NewID("usr"),
+        Name:            name,
+        Username:        email,
+        EmailVerifiedAt: &now,
+        CreatedAt:       now,
+        UpdatedAt:       now,
+    }
+    if err := s.users.Put(ctx, u); err != nil { return nil, err }
+    return u, nil
+}
+
+func (s *AuthService) oidcProvider(id string) *oidc.Provider {
+    for _, p := range s.oidc {
+        if p.ID() == id { return p }
+    }
+    return nil
+}
+
+// ReencryptResult reports a ReencryptSecrets run.
+type ReencryptResult struct {
+    // Users is how many users were scanned and Reencrypted how many had a
+    // secret moved to the primary key.
+    Users       int
+    Reencrypted int
+    // Changed counts users whose secrets changed while the run was under way;
+    // they are left as the concurrent write left them.
+    Changed int
+    // Remaining counts the secrets not under the primary key afterwards, by
+    // key ID; Remaining[id] == 0 means key id can be retired.
+    Remaining map[string]int
+}
+
+// ReencryptSecrets moves every password and TOTP secret in scan to the
+// primary key of the keyring. With dryRun it only counts what it would move.
+// Users are written with a compare-and-swap, so a password changed during
+// the run is not overwritten.
+func (s *AuthService) ReencryptSecrets(ctx context.Context, scan store.UserScanner, dryRun bool) (*ReencryptResult, error) {
+    primary := s.ring.Primary()
+    res := &ReencryptResult{Remaining: map[string]int{}}
+    var stale []models.User
+    // Collect first: some stores cannot be written while a scan is open.
+    err := scan.ScanAll(ctx, func(u models.User) error {
+        res.Users++
+        if s.needsReencrypt(u.PasswordEnc, primary) || s.needsReencrypt(u.TOTPSecretEnc, primary) { stale = append(stale, u) }
+        return nil
+    })
+    if err != nil { return nil, err }
+    for _, u := range stale {
+        if dryRun {
+            res.Reencrypted++
+            continue
+        }
+        oldPassword, newPassword, err := s.reencrypt(u.PasswordEnc, primary)
+        if err != nil { return res, fmt.Errorf("user %s password: %w", u.UserID, err) }
+        oldTOTP, newTOTP, err := s.reencrypt(u.TOTPSecretEnc, primary)
+        if err != nil { return res, fmt.Errorf("user %s totp secret: %w", u.UserID, err) }
+        err = s.users.ReencryptSecrets(ctx, u.UserID, oldPassword, newPassword, oldTOTP, newTOTP)
+        switch {
+        case err == nil:
+            res.Reencrypted++
+        case errors.Is(err, derr.ErrNotFound):
+            res.Changed++
+        default:
+            return res, err
+        }
+    }
+    if dryRun {
+        for _, u := range stale { s.countRemaining(u, primary, res.Remaining) }
+        return res, nil
+    }
+    // Count again: concurrent writes may have used a server's old primary.
+    err = scan.ScanAll(ctx, func(u models.User) error {
+        s.countRemaining(u, primary, res.Remaining)
+        return nil
+    })
+    return res, err
+}
+
+// countRemaining adds u's secrets not under the primary key to counts.
+func (s *AuthService) countRemaining(u models.User, primary string, counts map[string]int) {
+    for _, enc := range []string{u.PasswordEnc, u.TOTPSecretEnc} {
+        if s.needsReencrypt(enc, primary) { counts[s.ring.KeyID(enc)]++ }
+    }
+}
+
+func (s *AuthService) needsReencrypt(enc, primary string) bool {
+    return enc != "" && s.ring.KeyID(enc) != primary
+}
+
+// reencrypt returns enc and its re-encryption under the primary key, or two
+// empty strings when enc needs none.
+func (s *AuthService) reencrypt(enc, primary string) (string, string, error) {
+    if !s.needsReencrypt(enc, primary) { return "", "", nil }
+    pt, err := s.ring.Decrypt(enc)
+    if err != nil { return "", "", err }
+    next, err := s.ring.Encrypt(pt)
+    if err != nil { return "", "", err }
+    return enc, next, nil
+}
+
+// lifetimeText renders a link lifetime for an email, e.g. "1 hour" or "30 minutes".
+func lifetimeText(d time.Duration) string {
+    if d >= time.Hour && d%time.Hour == 0 {
+        if h := int(d / time.Hour); h != 1 { return fmt.Sprintf("%d hours", h) }
+        return "1 hour"
     }
-    if err := s.users.SetAPIKey(ctx, userID, hash, lookup, exp, now); err != nil { return "", err }
-    return plain, nil
+    if m := int(d / time.Minute); m != 1 { return fmt.Sprintf("%d minutes", m) }
+    return "1 minute"
 }
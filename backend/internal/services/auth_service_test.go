@@ -2,17 +2,30 @@ package services
 
 import (
     "context"
+    "errors"
     "path/filepath"
+    "strings"
     "testing"
+    "time"
 
+    "golang.org/x/crypto/bcrypt"
+
+    apiauth "github.com/janvillarosa/gracie-app/backend/internal/auth"
+    "github.com/janvillarosa/gracie-app/backend/internal/crypto"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/mail"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/oidc"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
     "github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
+    "github.com/janvillarosa/gracie-app/backend/internal/testutil/oidcmock"
 )
 
 func TestAuthRegisterLoginChangePassword(t *testing.T) {
     _, users, _, _, _ := memstore.Compose()
     keyPath := filepath.Join(t.TempDir(), "enc.key")
-    auth, err := NewAuthService(users, keyPath, 1)
+    auth, err := NewAuthService(users, memstore.NewSessionRepo(memstore.NewStore()), keyPath, 1)
     if err != nil { t.Fatalf("auth svc: %v", err) }
     ctx := context.Background()
 
@@ -31,20 +44,545 @@ func TestAuthRegisterLoginChangePassword(t *testing.T) {
     }
 
     // Login
-    lr, err := auth.Login(ctx, "a@b.com", "password123")
-    if err != nil || lr == nil || lr.APIKey == "" { t.Fatalf("login failed: %v %v", lr, err) }
+    lr, err := auth.Login(ctx, "a@b.com", "password123", "")
+    if err != nil || lr == nil || lr.Tokens.AccessToken == "" || lr.Tokens.RefreshToken == "" { t.Fatalf("login failed: %v %v", lr, err) }
 
     // Wrong password
-    if _, err := auth.Login(ctx, "a@b.com", "x"); err != derr.ErrUnauthorized {
+    if _, err := auth.Login(ctx, "a@b.com", "x", ""); err != derr.ErrUnauthorized {
         t.Fatalf("want unauthorized, got %v", err)
     }
 
     // Change password requires current
-    if _, err := auth.ChangePassword(ctx, lr.User.UserID, "", "newpassword"); err != derr.ErrBadRequest {
+    if _, err := auth.ChangePassword(ctx, lr.User.UserID, "", "newpassword", ""); err != derr.ErrBadRequest {
         t.Fatalf("want bad request when missing current, got %v", err)
     }
     // Provide current and rotate
-    newKey, err := auth.ChangePassword(ctx, lr.User.UserID, "password123", "newpassword")
-    if err != nil || newKey == "" { t.Fatalf("change password: %v %q", err, newKey) }
+    tokens, err := auth.ChangePassword(ctx, lr.User.UserID, "password123", "newpassword", "")
+    if err != nil || tokens.AccessToken == "" { t.Fatalf("change password: %v %+v", err, tokens) }
+}
+
+
+func TestAuthenticateSessions(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    sessions := memstore.NewSessionRepo(memstore.NewStore())
+    auth, err := NewAuthService(users, sessions, filepath.Join(t.TempDir(), "enc.key"), 1)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    cache := apiauth.NewCache(10, time.Minute)
+    auth.UseAuthCache(cache)
+    ctx := context.Background()
+
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    laptop, err := auth.Login(ctx, "a@b.com", "password123", "Laptop")
+    if err != nil { t.Fatalf("login: %v", err) }
+
+    if _, _, err := auth.Authenticate(ctx, "nope"); err != derr.ErrUnauthorized { t.Fatalf("want unauthorized, got %v", err) }
+    u, ses, err := auth.Authenticate(ctx, laptop.Tokens.AccessToken)
+    if err != nil || u.UserID != laptop.User.UserID || ses.SessionID != laptop.Session.SessionID || ses.Name != "Laptop" { t.Fatalf("authenticate: %v %v %v", u, ses, err) }
+
+    // Signing in on another device keeps the first signed in.
+    phone, err := auth.Login(ctx, "a@b.com", "password123", "Phone")
+    if err != nil { t.Fatalf("login: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, laptop.Tokens.AccessToken); err != nil { t.Fatalf("laptop after phone login: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, phone.Tokens.AccessToken); err != nil { t.Fatalf("phone: %v", err) }
+    list, err := auth.ListSessions(ctx, u.UserID)
+    if err != nil || len(list) != 2 { t.Fatalf("list sessions: %v %v", list, err) }
+
+    // Access tokens die with their session.
+    if err := sessions.Delete(ctx, laptop.Session.SessionID); err != nil { t.Fatalf("delete: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, laptop.Tokens.AccessToken); err != derr.ErrUnauthorized { t.Fatalf("revoked token: want unauthorized, got %v", err) }
+
+    // Only the owner can revoke a session.
+    if err := auth.RevokeSession(ctx, "usr_other", phone.Session.SessionID); err != derr.ErrNotFound { t.Fatalf("foreign revoke: want not found, got %v", err) }
+    if err := auth.RevokeSession(ctx, u.UserID, phone.Session.SessionID); err != nil { t.Fatalf("revoke: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, phone.Tokens.AccessToken); err != derr.ErrUnauthorized { t.Fatalf("logged out token: want unauthorized, got %v", err) }
+
+    // API key sessions are cached once verified.
+    plain, ses, err := auth.IssueSession(ctx, u.UserID, "Tablet")
+    if err != nil { t.Fatalf("issue: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, plain); err != nil { t.Fatalf("api key: %v", err) }
+    if cache.Len() != 1 { t.Fatalf("expected cached key, got %d", cache.Len()) }
+    if _, got, err := auth.Authenticate(ctx, plain); err != nil || got.Name != "Tablet" { t.Fatalf("cached authenticate: %v %v", got, err) }
+
+    // A session revoked elsewhere is not served from a stale cache entry.
+    if err := sessions.Delete(ctx, ses.SessionID); err != nil { t.Fatalf("delete: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, plain); err != derr.ErrUnauthorized { t.Fatalf("revoked key: want unauthorized, got %v", err) }
+
+    // Expired sessions are refused and not listed.
+    plain, ses, err = auth.IssueSession(ctx, u.UserID, "Tablet")
+    if err != nil { t.Fatalf("issue: %v", err) }
+    past := time.Now().UTC().Add(-time.Minute)
+    ses.SessionID, ses.KeyLookup, ses.ExpiresAt = "ses_expired", "lk_expired", &past
+    if err := sessions.Create(ctx, ses); err != nil { t.Fatalf("create: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, plain); err != nil { t.Fatalf("tablet: %v", err) }
+    list, _ = auth.ListSessions(ctx, u.UserID)
+    if len(list) != 1 || list[0].Name != "Tablet" { t.Fatalf("list: %+v", list) }
+    if err := auth.PurgeExpiredSessions(ctx, models.Job{}); err != nil { t.Fatalf("purge: %v", err) }
+    if _, err := sessions.GetByID(ctx, "ses_expired"); err != derr.ErrNotFound { t.Fatalf("expired session not purged: %v", err) }
+
+    // Changing the password signs out every other device.
+    other, _ := auth.Login(ctx, "a@b.com", "password123", "Other")
+    key, err := auth.ChangePassword(ctx, u.UserID, "password123", "newpassword", "Tablet")
+    if err != nil { t.Fatalf("change password: %v", err) }
+    for _, k := range []string{plain, other.Tokens.AccessToken} {
+        if _, _, err := auth.Authenticate(ctx, k); err != derr.ErrUnauthorized { t.Fatalf("key after password change: want unauthorized, got %v", err) }
+    }
+    if _, ses, err := auth.Authenticate(ctx, key.AccessToken); err != nil || ses.Name != "Tablet" { t.Fatalf("new token: %v %v", ses, err) }
+}
+
+func TestRefreshTokens(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    lr, err := auth.Login(ctx, "a@b.com", "password123", "Laptop")
+    if err != nil { t.Fatalf("login: %v", err) }
+    if lr.Tokens.ExpiresIn != defaultAccessTTL || lr.Session.ExpiresAt == nil { t.Fatalf("unexpected login %+v", lr) }
+
+    // Refresh tokens are not bearer credentials.
+    if _, _, err := auth.Authenticate(ctx, lr.Tokens.RefreshToken); err != derr.ErrUnauthorized { t.Fatalf("refresh as bearer: want unauthorized, got %v", err) }
+    if _, _, err := auth.Refresh(ctx, lr.Tokens.AccessToken); err != derr.ErrUnauthorized { t.Fatalf("access as refresh: want unauthorized, got %v", err) }
+
+    next, ses, err := auth.Refresh(ctx, lr.Tokens.RefreshToken)
+    if err != nil || ses.SessionID != lr.Session.SessionID || next.RefreshToken == lr.Tokens.RefreshToken { t.Fatalf("refresh: %v %+v", err, next) }
+    if _, _, err := auth.Authenticate(ctx, next.AccessToken); err != nil { t.Fatalf("refreshed access token: %v", err) }
+    third, _, err := auth.Refresh(ctx, next.RefreshToken)
+    if err != nil { t.Fatalf("second refresh: %v", err) }
+
+    // Replaying a redeemed refresh token revokes the whole session.
+    if _, _, err := auth.Refresh(ctx, next.RefreshToken); err != derr.ErrUnauthorized { t.Fatalf("replay: want unauthorized, got %v", err) }
+    if _, _, err := auth.Refresh(ctx, third.RefreshToken); err != derr.ErrUnauthorized { t.Fatalf("refresh after replay: want unauthorized, got %v", err) }
+    if _, _, err := auth.Authenticate(ctx, third.AccessToken); err != derr.ErrUnauthorized { t.Fatalf("access after replay: want unauthorized, got %v", err) }
 }
 
+func TestAuthenticateAdoptsLegacyKey(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    u, _ := users.GetByUsername(ctx, "a@b.com")
+
+    plain := "legacy-key"
+    b, _ := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.MinCost)
+    if err := users.SetAPIKey(ctx, u.UserID, string(b), apiauth.DeriveLookup(plain), nil, time.Now().UTC()); err != nil { t.Fatalf("set key: %v", err) }
+
+    _, ses, err := auth.Authenticate(ctx, plain)
+    if err != nil { t.Fatalf("legacy key: %v", err) }
+    if !strings.HasPrefix(ses.KeyHash, "hmac-sha256$") || ses.Name != legacySessionName { t.Fatalf("unexpected session %+v", ses) }
+    got, _ := users.GetByID(ctx, u.UserID)
+    if got.APIKeyHash != "" || got.APIKeyLookup != "" { t.Fatalf("legacy key left on user: %+v", got) }
+    if _, again, err := auth.Authenticate(ctx, plain); err != nil || again.SessionID != ses.SessionID { t.Fatalf("adopted key: %v %v", again, err) }
+}
+
+// outbox is a mail.Mailer that keeps what it is sent.
+type outbox struct{ sent []mail.Message }
+
+func (o *outbox) Send(_ context.Context, m mail.Message) error {
+    o.sent = append(o.sent, m)
+    return nil
+}
+
+// resetToken returns the token of the reset link in m.
+func resetToken(t *testing.T, m mail.Message) string {
+    t.Helper()
+    i := strings.Index(m.Body, "?token=")
+    if i < 0 { t.Fatalf("no reset link in %q", m.Body) }
+    return strings.Fields(m.Body[i+len("?token="):])[0]
+}
+
+func TestPasswordReset(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    tokens := memstore.NewUserTokenRepo(memstore.NewStore())
+    box := &outbox{}
+    auth.UsePasswordReset(tokens, box, "https://gracie.example/reset-password", time.Hour)
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    lr, err := auth.Login(ctx, "a@b.com", "password123", "Laptop")
+    if err != nil { t.Fatalf("login: %v", err) }
+
+    // Unknown accounts get no mail and no error.
+    if err := auth.RequestPasswordReset(ctx, "nobody@b.com"); err != nil || len(box.sent) != 0 { t.Fatalf("unknown user: %v %v", err, box.sent) }
+
+    // A second request voids the first link.
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("request: %v", err) }
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("request again: %v", err) }
+    if len(box.sent) != 2 || box.sent[1].To != "a@b.com" || !strings.Contains(box.sent[1].Body, "1 hour") { t.Fatalf("unexpected mail %+v", box.sent) }
+    first, second := resetToken(t, box.sent[0]), resetToken(t, box.sent[1])
+    if _, err := auth.ResetPassword(ctx, first, "resetpass1", ""); err != derr.ErrUnauthorized { t.Fatalf("replaced link: want unauthorized, got %v", err) }
+
+    if _, err := auth.ResetPassword(ctx, second, "short", ""); err != derr.ErrBadRequest { t.Fatalf("short password: want bad request, got %v", err) }
+    res, err := auth.ResetPassword(ctx, second, "resetpass1", "Phone")
+    if err != nil || res.User.UserID != lr.User.UserID || res.Session.Name != "Phone" { t.Fatalf("reset: %v %+v", err, res) }
+
+    // The link works once, old sessions are signed out and the new password is live.
+    if _, err := auth.ResetPassword(ctx, second, "resetpass2", ""); err != derr.ErrUnauthorized { t.Fatalf("reused link: want unauthorized, got %v", err) }
+    if _, _, err := auth.Authenticate(ctx, lr.Tokens.AccessToken); err != derr.ErrUnauthorized { t.Fatalf("old session: want unauthorized, got %v", err) }
+    if _, _, err := auth.Authenticate(ctx, res.Tokens.AccessToken); err != nil { t.Fatalf("new session: %v", err) }
+    if _, err := auth.Login(ctx, "a@b.com", "password123", ""); err != derr.ErrUnauthorized { t.Fatalf("old password: want unauthorized, got %v", err) }
+    if _, err := auth.Login(ctx, "a@b.com", "resetpass1", ""); err != nil { t.Fatalf("new password: %v", err) }
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    tokens := memstore.NewUserTokenRepo(memstore.NewStore())
+    box := &outbox{}
+    auth.UsePasswordReset(tokens, box, "https://gracie.example/reset-password", -time.Minute)
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("request: %v", err) }
+    if _, err := auth.ResetPassword(ctx, resetToken(t, box.sent[0]), "resetpass1", ""); err != derr.ErrUnauthorized { t.Fatalf("expired link: want unauthorized, got %v", err) }
+
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("request: %v", err) }
+    if err := auth.PurgeExpiredUserTokens(ctx, models.Job{}); err != nil { t.Fatalf("purge: %v", err) }
+    if _, err := tokens.Consume(ctx, apiauth.HashUserToken(resetToken(t, box.sent[1])), models.TokenPasswordReset); err != derr.ErrNotFound { t.Fatalf("purged token: want not found, got %v", err) }
+}
+
+func TestPasswordResetMailLimit(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    box := &outbox{}
+    auth.UsePasswordReset(memstore.NewUserTokenRepo(memstore.NewStore()), box, "https://gracie.example/reset-password", time.Hour)
+    auth.UseMailLimiter(ratelimit.New(ratelimit.NewMemoryStore(), "mail_user", ratelimit.Rule{Limit: 2, Window: time.Hour}))
+    ctx := context.Background()
+    for _, name := range []string{"a@b.com", "c@d.com"} {
+        if err := auth.Register(ctx, name, "password123", "Alice"); err != nil { t.Fatalf("register %s: %v", name, err) }
+    }
+    for i := 0; i < 2; i++ {
+        if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("request %d: %v", i+1, err) }
+    }
+    // Over the limit nothing is sent and the last link stays valid.
+    if err := auth.RequestPasswordReset(ctx, " a@b.com"); !errors.Is(err, derr.ErrRateLimited) || len(box.sent) != 2 { t.Fatalf("over limit: %v, %d mails", err, len(box.sent)) }
+    // Other users and unknown names are not held up.
+    if err := auth.RequestPasswordReset(ctx, "c@d.com"); err != nil || len(box.sent) != 3 { t.Fatalf("other user: %v", err) }
+    if err := auth.RequestPasswordReset(ctx, "nobody@b.com"); err != nil { t.Fatalf("unknown user: %v", err) }
+    if _, err := auth.ResetPassword(ctx, mailedToken(t, box, "a@b.com"), "resetpass1", ""); err != nil { t.Fatalf("reset with last link: %v", err) }
+}
+
+func TestVerificationResendLimit(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    box := &outbox{}
+    auth.UsePasswordReset(memstore.NewUserTokenRepo(memstore.NewStore()), box, "https://gracie.example/reset-password", time.Hour)
+    auth.UseEmailVerification(box, "https://gracie.example/verify-email", time.Hour, false)
+    auth.UseMailLimiter(ratelimit.New(ratelimit.NewMemoryStore(), "mail_user", ratelimit.Rule{Limit: 2, Window: time.Hour}))
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    for i := 0; i < 2; i++ {
+        if err := auth.RequestVerification(ctx, "a@b.com"); err != nil { t.Fatalf("resend %d: %v", i+1, err) }
+    }
+    sent := len(box.sent)
+    if err := auth.RequestVerification(ctx, "a@b.com"); !errors.Is(err, derr.ErrRateLimited) || len(box.sent) != sent { t.Fatalf("over limit: %v, %d mails", err, len(box.sent)-sent) }
+    // The last link still verifies, and verified users get no mail.
+    if _, err := auth.VerifyEmail(ctx, mailedToken(t, box, "a@b.com")); err != nil { t.Fatalf("verify: %v", err) }
+    if err := auth.RequestVerification(ctx, "a@b.com"); err != nil || len(box.sent) != sent { t.Fatalf("verified resend: %v, %d mails", err, len(box.sent)-sent) }
+    // Reset links are counted apart from verification links.
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil || len(box.sent) != sent+1 { t.Fatalf("reset after resends: %v", err) }
+}
+
+// mailedToken returns the token of the link in the last message sent to to.
+func mailedToken(t *testing.T, box *outbox, to string) string {
+    t.Helper()
+    for i := len(box.sent) - 1; i >= 0; i-- {
+        if box.sent[i].To == to { return resetToken(t, box.sent[i]) }
+    }
+    t.Fatalf("no mail to %s in %+v", to, box.sent)
+    return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+    tx, users, rooms, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    box := &outbox{}
+    auth.UsePasswordReset(memstore.NewUserTokenRepo(memstore.NewStore()), box, "https://gracie.example/reset-password", time.Hour)
+    auth.UseEmailVerification(box, "https://gracie.example/verify-email", time.Hour, true)
+    userSvc := NewUserService(users, rooms, tx, auth)
+    ctx := context.Background()
+
+    // Registering mails a link; login waits for it.
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    if _, err := auth.Login(ctx, "a@b.com", "password123", ""); err != derr.ErrEmailUnverified { t.Fatalf("unverified login: want ErrEmailUnverified, got %v", err) }
+    if _, err := auth.Login(ctx, "a@b.com", "wrong-password", ""); err != derr.ErrUnauthorized { t.Fatalf("wrong password: want unauthorized, got %v", err) }
+    first := mailedToken(t, box, "a@b.com")
+    if _, err := auth.VerifyEmail(ctx, first+"x"); err != derr.ErrUnauthorized { t.Fatalf("tampered: want unauthorized, got %v", err) }
+    u, err := auth.VerifyEmail(ctx, first)
+    if err != nil || u.EmailVerifiedAt == nil { t.Fatalf("verify: %v %+v", err, u) }
+    if _, err := auth.VerifyEmail(ctx, first); err != nil { t.Fatalf("verify again: %v", err) }
+    lr, err := auth.Login(ctx, "a@b.com", "password123", "")
+    if err != nil { t.Fatalf("verified login: %v", err) }
+
+    // A new address must be verified again, and links to the old one are void.
+    sent := len(box.sent)
+    if err := userSvc.UpdateProfile(ctx, lr.User.UserID, nil, ptr("a@b.com")); err != nil || len(box.sent) != sent { t.Fatalf("unchanged username: %v, %d mails", err, len(box.sent)-sent) }
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("reset request: %v", err) }
+    staleReset := mailedToken(t, box, "a@b.com")
+    if err := userSvc.UpdateProfile(ctx, lr.User.UserID, nil, ptr("new@b.com")); err != nil { t.Fatalf("update username: %v", err) }
+    if got, _ := users.GetByID(ctx, lr.User.UserID); EmailVerified(got) { t.Fatalf("new address kept verification: %+v", got) }
+    if _, err := auth.VerifyEmail(ctx, first); err != derr.ErrUnauthorized { t.Fatalf("old address link: want unauthorized, got %v", err) }
+    if _, err := auth.ResetPassword(ctx, staleReset, "resetpass1", ""); err != derr.ErrUnauthorized { t.Fatalf("reset link to old address: want unauthorized, got %v", err) }
+    if _, err := auth.Login(ctx, "new@b.com", "password123", ""); err != derr.ErrEmailUnverified { t.Fatalf("login after change: want ErrEmailUnverified, got %v", err) }
+
+    // Resending works by username; unknown ones are silently ignored.
+    sent = len(box.sent)
+    if err := auth.RequestVerification(ctx, "nobody@b.com"); err != nil || len(box.sent) != sent { t.Fatalf("unknown resend: %v", err) }
+    if err := auth.RequestVerification(ctx, "new@b.com"); err != nil || len(box.sent) != sent+1 { t.Fatalf("resend: %v", err) }
+    if _, err := auth.VerifyEmail(ctx, mailedToken(t, box, "new@b.com")); err != nil { t.Fatalf("verify new address: %v", err) }
+    if _, err := auth.Login(ctx, "new@b.com", "password123", ""); err != nil { t.Fatalf("login after re-verifying: %v", err) }
+}
+
+func TestResetPasswordVerifiesEmail(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    box := &outbox{}
+    auth.UsePasswordReset(memstore.NewUserTokenRepo(memstore.NewStore()), box, "https://gracie.example/reset-password", time.Hour)
+    auth.UseEmailVerification(box, "https://gracie.example/verify-email", time.Hour, true)
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("reset request: %v", err) }
+    res, err := auth.ResetPassword(ctx, mailedToken(t, box, "a@b.com"), "resetpass1", "")
+    if err != nil || res.User.EmailVerifiedAt == nil { t.Fatalf("reset: %v %+v", err, res) }
+    if _, err := auth.Login(ctx, "a@b.com", "resetpass1", ""); err != nil { t.Fatalf("login: %v", err) }
+}
+
+func TestLoginLockout(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    auth.UseLoginLockout(ratelimit.New(ratelimit.NewMemoryStore(), "login_user", ratelimit.Rule{Limit: 3, Window: time.Hour}))
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+
+    // A success clears earlier failures.
+    for i := 0; i < 2; i++ {
+        if _, err := auth.Login(ctx, "a@b.com", "wrong", ""); err != derr.ErrUnauthorized { t.Fatalf("failure %d: %v", i+1, err) }
+    }
+    if _, err := auth.Login(ctx, "a@b.com", "password123", ""); err != nil { t.Fatalf("login: %v", err) }
+    for i := 0; i < 3; i++ {
+        if _, err := auth.Login(ctx, "A@b.com ", "wrong", ""); err != derr.ErrUnauthorized { t.Fatalf("failure %d: %v", i+1, err) }
+    }
+    // Locked, even with the right password.
+    _, err := auth.Login(ctx, "a@b.com", "password123", "")
+    var rl *ratelimit.Error
+    if !errors.As(err, &rl) || rl.RetryAfter <= 0 { t.Fatalf("locked login: want a rate limit error, got %v", err) }
+
+    // Unknown usernames lock the same way.
+    for i := 0; i < 3; i++ {
+        if _, err := auth.Login(ctx, "nobody@b.com", "wrong", ""); err != derr.ErrUnauthorized { t.Fatalf("unknown %d: %v", i+1, err) }
+    }
+    if _, err := auth.Login(ctx, "nobody@b.com", "wrong", ""); !errors.Is(err, derr.ErrRateLimited) { t.Fatalf("unknown locked: %v", err) }
+}
+
+func TestTOTPLogin(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    box := &outbox{}
+    auth.UsePasswordReset(memstore.NewUserTokenRepo(memstore.NewStore()), box, "https://gracie.example/reset-password", time.Hour)
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    lr, err := auth.Login(ctx, "a@b.com", "password123", "")
+    if err != nil { t.Fatalf("login: %v", err) }
+    uid := lr.User.UserID
+    code := func(secret string) string {
+        c, err := apiauth.TOTPCode(secret, apiauth.TOTPStep(time.Now()))
+        if err != nil { t.Fatalf("code: %v", err) }
+        return c
+    }
+
+    // Enrollment only takes effect once a code is confirmed.
+    setup, err := auth.StartTOTP(ctx, uid)
+    if err != nil || !strings.Contains(setup.URI, "secret="+setup.Secret) { t.Fatalf("start: %v %+v", err, setup) }
+    if lr, err := auth.Login(ctx, "a@b.com", "password123", ""); err != nil || lr.Challenge != "" { t.Fatalf("pending secret asked for a code: %v %+v", err, lr) }
+    if _, err := auth.ConfirmTOTP(ctx, uid, "000000"); err != derr.ErrUnauthorized { t.Fatalf("wrong code: want unauthorized, got %v", err) }
+    recovery, err := auth.ConfirmTOTP(ctx, uid, code(setup.Secret))
+    if err != nil || len(recovery) != recoveryCodeCount { t.Fatalf("confirm: %v %v", err, recovery) }
+    if _, err := auth.StartTOTP(ctx, uid); err != derr.ErrConflict { t.Fatalf("start when enabled: want conflict, got %v", err) }
+    u, _ := users.GetByID(ctx, uid)
+    if enc, err := auth.ring.Decrypt(u.TOTPSecretEnc); err != nil || string(enc) != setup.Secret { t.Fatalf("secret not stored encrypted: %v", err) }
+
+    // The password alone yields a challenge, not a session.
+    lr, err = auth.Login(ctx, "a@b.com", "password123", "")
+    if err != nil || lr.Challenge == "" || lr.Tokens != nil || lr.User != nil { t.Fatalf("login: %v %+v", err, lr) }
+    if _, err := auth.LoginTOTP(ctx, "gmt_forged.1.x", code(setup.Secret), ""); err != derr.ErrUnauthorized { t.Fatalf("forged challenge: want unauthorized, got %v", err) }
+    // The code used to confirm cannot be replayed.
+    if _, err := auth.LoginTOTP(ctx, lr.Challenge, code(setup.Secret), ""); err != derr.ErrUnauthorized { t.Fatalf("replayed code: want unauthorized, got %v", err) }
+
+    // A recovery code works once, typed any way.
+    res, err := auth.LoginTOTP(ctx, lr.Challenge, strings.ToUpper(recovery[0]), "Phone")
+    if err != nil || res.Tokens == nil || res.Session.Name != "Phone" { t.Fatalf("recovery login: %v %+v", err, res) }
+    if _, err := auth.LoginTOTP(ctx, lr.Challenge, recovery[0], ""); err != derr.ErrUnauthorized { t.Fatalf("reused recovery code: want unauthorized, got %v", err) }
+
+    // A password reset does not skip the second factor.
+    if err := auth.RequestPasswordReset(ctx, "a@b.com"); err != nil { t.Fatalf("request reset: %v", err) }
+    res, err = auth.ResetPassword(ctx, resetToken(t, box.sent[len(box.sent)-1]), "resetpass1", "")
+    if err != nil || res.Challenge == "" || res.Tokens != nil { t.Fatalf("reset: %v %+v", err, res) }
+
+    // Regenerating voids the old codes; disabling needs a code too.
+    fresh, err := auth.RegenerateRecoveryCodes(ctx, uid, recovery[1])
+    if err != nil || len(fresh) != recoveryCodeCount { t.Fatalf("regenerate: %v", err) }
+    if err := auth.DisableTOTP(ctx, uid, recovery[2]); err != derr.ErrUnauthorized { t.Fatalf("old recovery code: want unauthorized, got %v", err) }
+    if err := auth.DisableTOTP(ctx, uid, fresh[0]); err != nil { t.Fatalf("disable: %v", err) }
+    if lr, err := auth.Login(ctx, "a@b.com", "resetpass1", ""); err != nil || lr.Tokens == nil { t.Fatalf("login after disable: %v %+v", err, lr) }
+}
+
+func TestTOTPLockout(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    auth.UsePasswordReset(memstore.NewUserTokenRepo(memstore.NewStore()), &outbox{}, "https://gracie.example/reset-password", time.Hour)
+    auth.UseLoginLockout(ratelimit.New(ratelimit.NewMemoryStore(), "login_user", ratelimit.Rule{Limit: 3, Window: time.Hour}))
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    lr, _ := auth.Login(ctx, "a@b.com", "password123", "")
+    setup, _ := auth.StartTOTP(ctx, lr.User.UserID)
+    c, _ := apiauth.TOTPCode(setup.Secret, apiauth.TOTPStep(time.Now()))
+    recovery, err := auth.ConfirmTOTP(ctx, lr.User.UserID, c)
+    if err != nil { t.Fatalf("confirm: %v", err) }
+    lr, _ = auth.Login(ctx, "a@b.com", "password123", "")
+    for i := 0; i < 3; i++ {
+        if _, err := auth.LoginTOTP(ctx, lr.Challenge, "bad-code", ""); err != derr.ErrUnauthorized { t.Fatalf("attempt %d: %v", i+1, err) }
+    }
+    if _, err := auth.LoginTOTP(ctx, lr.Challenge, recovery[0], ""); !errors.Is(err, derr.ErrRateLimited) { t.Fatalf("want rate limited, got %v", err) }
+}
+
+func TestOIDCLogin(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    mock := oidcmock.New(t, "gracie", "s3cret", oidcmock.User{Subject: "sub-1", Email: "ann@example.com", EmailVerified: true, Name: "Ann"})
+    auth.UseOIDC("https://gracie.example/oidc/callback", oidc.New(oidc.Config{ID: "mock", Name: "Mock", Issuer: mock.Issuer(), ClientID: "gracie", ClientSecret: "s3cret"}, nil))
+    ctx := context.Background()
+    login := func(device string) (*LoginResult, error) {
+        authURL, flow, err := auth.StartOIDC(ctx, "mock")
+        if err != nil { t.Fatalf("start: %v", err) }
+        code, state, err := mock.Authorize(authURL)
+        if err != nil { t.Fatalf("authorize: %v", err) }
+        return auth.FinishOIDC(ctx, "mock", flow, code, state, device)
+    }
+
+    if _, _, err := auth.StartOIDC(ctx, "nope"); err != derr.ErrNotFound { t.Fatalf("unknown provider: want not found, got %v", err) }
+
+    // The first login creates a verified, passwordless user.
+    res, err := login("Laptop")
+    if err != nil || res.Tokens == nil || res.Session.Name != "Laptop" { t.Fatalf("first login: %v %+v", err, res) }
+    if res.User.Username != "ann@example.com" || res.User.Name != "Ann" || !EmailVerified(res.User) || res.User.PasswordEnc != "" { t.Fatalf("created user: %+v", res.User) }
+    again, err := login("")
+    if err != nil || again.User.UserID != res.User.UserID { t.Fatalf("second login: %v %+v", err, again) }
+
+    // The state must match the flow it came with.
+    authURL, flow, _ := auth.StartOIDC(ctx, "mock")
+    code, _, _ := mock.Authorize(authURL)
+    if _, err := auth.FinishOIDC(ctx, "mock", flow, code, "forged", ""); err != derr.ErrUnauthorized { t.Fatalf("wrong state: want unauthorized, got %v", err) }
+    if _, err := auth.FinishOIDC(ctx, "mock", "forged", code, "forged", ""); err != derr.ErrUnauthorized { t.Fatalf("forged flow: want unauthorized, got %v", err) }
+
+    // An address the provider has not verified is refused.
+    mock.SetUser(oidcmock.User{Subject: "sub-2", Email: "bob@example.com", Name: "Bob"})
+    if _, err := login(""); err != derr.ErrEmailUnverified { t.Fatalf("unverified: want email unverified, got %v", err) }
+
+    // An unverified local account with the same address is taken over: its
+    // password no longer works and its sessions end.
+    if err := auth.Register(ctx, "cat@example.com", "password123", "Squatter"); err != nil { t.Fatalf("register: %v", err) }
+    squat, err := auth.Login(ctx, "cat@example.com", "password123", "")
+    if err != nil { t.Fatalf("login: %v", err) }
+    mock.SetUser(oidcmock.User{Subject: "sub-3", Email: "cat@example.com", EmailVerified: true, Name: "Cat"})
+    res, err = login("")
+    if err != nil || res.User.UserID != squat.User.UserID { t.Fatalf("link: %v %+v", err, res) }
+    if _, err := auth.Login(ctx, "cat@example.com", "password123", ""); err != derr.ErrUnauthorized { t.Fatalf("old password: want unauthorized, got %v", err) }
+    if _, _, err := auth.Authenticate(ctx, squat.Tokens.AccessToken); err == nil { t.Fatal("old session still valid") }
+}
+
+func TestReencryptSecrets(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    path := filepath.Join(t.TempDir(), "enc.key")
+    if _, err := crypto.LoadOrCreateKey(path); err != nil { t.Fatalf("key: %v", err) }
+    sessions := memstore.NewSessionRepo(memstore.NewStore())
+    auth, err := NewAuthService(users, sessions, path, 1)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    tokens := memstore.NewUserTokenRepo(memstore.NewStore())
+    auth.UsePasswordReset(tokens, &outbox{}, "https://gracie.example/reset-password", time.Hour)
+    ctx := context.Background()
+    if err := auth.Register(ctx, "a@b.com", "password123", "Alice"); err != nil { t.Fatalf("register: %v", err) }
+    lr, _ := auth.Login(ctx, "a@b.com", "password123", "")
+    setup, err := auth.StartTOTP(ctx, lr.User.UserID)
+    if err != nil { t.Fatalf("start totp: %v", err) }
+    totp, _ := apiauth.TOTPCode(setup.Secret, apiauth.TOTPStep(time.Now()))
+    recovery, err := auth.ConfirmTOTP(ctx, lr.User.UserID, totp)
+    if err != nil { t.Fatalf("confirm totp: %v", err) }
+    if err := auth.Register(ctx, "b@b.com", "password123", "Bob"); err != nil { t.Fatalf("register: %v", err) }
+
+    // Rotate: the new key is primary, the raw key file becomes the root.
+    ring, err := crypto.LoadOrCreateKeyring(path)
+    if err != nil { t.Fatalf("ring: %v", err) }
+    id, _ := ring.Add(true)
+    if err := ring.Save(path); err != nil { t.Fatalf("save: %v", err) }
+    auth, err = NewAuthService(users, sessions, path, 1)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    auth.UsePasswordReset(tokens, &outbox{}, "https://gracie.example/reset-password", time.Hour)
+    // Keys and tokens issued under the old file still work.
+    if _, _, err := auth.Authenticate(ctx, lr.Tokens.AccessToken); err != nil { t.Fatalf("token after rotation: %v", err) }
+    pending, err := auth.Login(ctx, "a@b.com", "password123", "")
+    if err != nil || pending.Challenge == "" { t.Fatalf("login: %v %+v", err, pending) }
+    if _, err := auth.LoginTOTP(ctx, pending.Challenge, recovery[0], ""); err != nil { t.Fatalf("recovery code after rotation: %v", err) }
+
+    dry, err := auth.ReencryptSecrets(ctx, users.(store.UserScanner), true)
+    if err != nil || dry.Users != 2 || dry.Reencrypted != 2 || dry.Remaining["k0"] != 3 { t.Fatalf("dry run: %v %+v", err, dry) }
+    u, _ := users.GetByID(ctx, lr.User.UserID)
+    if ring.KeyID(u.PasswordEnc) != "k0" { t.Fatal("dry run wrote") }
+
+    res, err := auth.ReencryptSecrets(ctx, users.(store.UserScanner), false)
+    if err != nil || res.Reencrypted != 2 || res.Changed != 0 || len(res.Remaining) != 0 { t.Fatalf("reencrypt: %v %+v", err, res) }
+    u, _ = users.GetByID(ctx, lr.User.UserID)
+    if ring.KeyID(u.PasswordEnc) != id || ring.KeyID(u.TOTPSecretEnc) != id { t.Fatalf("not moved to %s: %q %q", id, u.PasswordEnc, u.TOTPSecretEnc) }
+    if enc, err := auth.ring.Decrypt(u.TOTPSecretEnc); err != nil || string(enc) != setup.Secret { t.Fatalf("totp secret: %v", err) }
+    if _, err := auth.Login(ctx, "b@b.com", "password123", ""); err != nil { t.Fatalf("login after reencrypt: %v", err) }
+    if again, err := auth.ReencryptSecrets(ctx, users.(store.UserScanner), false); err != nil || again.Reencrypted != 0 { t.Fatalf("second run: %v %+v", err, again) }
+
+    // The root key can go too: sessions from after the rotation outlive it,
+    // older ones end with it.
+    fresh, err := auth.Login(ctx, "b@b.com", "password123", "")
+    if err != nil { t.Fatalf("login: %v", err) }
+    if err := ring.Retire("k0"); err != nil { t.Fatalf("retire root: %v", err) }
+    if err := ring.Save(path); err != nil { t.Fatalf("save: %v", err) }
+    auth, err = NewAuthService(users, sessions, path, 1)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, fresh.Tokens.AccessToken); err != nil { t.Fatalf("token after retiring root: %v", err) }
+    if _, _, err := auth.Authenticate(ctx, lr.Tokens.AccessToken); err != derr.ErrUnauthorized { t.Fatalf("root token after retiring root: %v", err) }
+    if _, err := auth.Login(ctx, "b@b.com", "password123", ""); err != nil { t.Fatalf("login after retiring root: %v", err) }
+}
+
+func TestPasswordRehash(t *testing.T) {
+    _, users, _, _, _ := memstore.Compose()
+    auth := newTestAuth(t, users)
+    ctx := context.Background()
+    hashOf := func(username string) string {
+        u, _ := users.GetByUsername(ctx, username)
+        ph, err := auth.ring.Decrypt(u.PasswordEnc)
+        if err != nil { t.Fatalf("decrypt %s: %v", username, err) }
+        return string(ph)
+    }
+
+    // A user from before Argon2id: a bcrypt hash, encrypted without a key ID.
+    legacy, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+    enc, _ := crypto.Encrypt(auth.ring.Root(), legacy)
+    now := time.Now().UTC()
+    if err := users.Put(ctx, &models.User{UserID: "usr_legacy", Name: "Lee", Username: "lee@b.com", PasswordEnc: enc, CreatedAt: now, UpdatedAt: now}); err != nil { t.Fatalf("put: %v", err) }
+    if err := auth.Register(ctx, "new@b.com", "password123", "Nia"); err != nil { t.Fatalf("register: %v", err) }
+    if h := hashOf("new@b.com"); !strings.HasPrefix(h, "$argon2id$") { t.Fatalf("new user hash: %q", h) }
+
+    // A wrong password changes nothing; the right one upgrades the hash and
+    // keeps the user's sessions.
+    lr, err := auth.Login(ctx, "lee@b.com", "password123", "")
+    if err != nil { t.Fatalf("legacy login: %v", err) }
+    if _, err := auth.Login(ctx, "lee@b.com", "wrong-pass", ""); err != derr.ErrUnauthorized { t.Fatalf("wrong password: %v", err) }
+    if h := hashOf("lee@b.com"); !strings.HasPrefix(h, "$argon2id$") { t.Fatalf("legacy hash not upgraded: %q", h) }
+    if _, _, err := auth.Authenticate(ctx, lr.Tokens.AccessToken); err != nil { t.Fatalf("session after rehash: %v", err) }
+    if _, err := auth.Login(ctx, "lee@b.com", "password123", ""); err != nil { t.Fatalf("login after rehash: %v", err) }
+    if _, err := auth.ChangePassword(ctx, "usr_legacy", "password123", "password456", ""); err != nil { t.Fatalf("change password: %v", err) }
+
+    // Raising the parameters upgrades Argon2id hashes too.
+    before := hashOf("new@b.com")
+    auth.UsePasswordHasher(apiauth.NewArgon2id(apiauth.Argon2Params{Memory: apiauth.DefaultArgon2Params.Memory, Time: apiauth.DefaultArgon2Params.Time + 1, Parallelism: 1}))
+    if _, err := auth.Login(ctx, "new@b.com", "password123", ""); err != nil { t.Fatalf("login: %v", err) }
+    if after := hashOf("new@b.com"); after == before || !strings.Contains(after, ",t=3,") { t.Fatalf("argon2id hash not upgraded: %q", after) }
+
+    // Switching to bcrypt keeps Argon2id users able to sign in.
+    auth.UsePasswordHasher(apiauth.NewBcrypt(bcrypt.MinCost))
+    if _, err := auth.Login(ctx, "new@b.com", "password123", ""); err != nil { t.Fatalf("login under bcrypt: %v", err) }
+    if h := hashOf("new@b.com"); !strings.HasPrefix(h, "$2") { t.Fatalf("hash not moved to bcrypt: %q", h) }
+}
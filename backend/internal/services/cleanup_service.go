@@ -0,0 +1,118 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/jobs"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// JobCleanupRoom removes what a deleted room leaves behind. Payload: room_id.
+const JobCleanupRoom = "cleanup_room"
+
+// JobPurgeTrash permanently removes trashed lists and items older than the
+// trash retention. It is scheduled periodically and takes no payload.
+const JobPurgeTrash = "purge_trash"
+
+// JobPurgeCounters removes expired rate limit counters from the data store.
+// It is scheduled periodically and takes no payload.
+const JobPurgeCounters = "purge_counters"
+
+// enqueueRoomCleanup schedules JobCleanupRoom. Call it inside the transaction
+// that deletes the room so the cleanup cannot be lost. A nil queue is a no-op.
+func enqueueRoomCleanup(ctx context.Context, q store.JobRepository, roomID string, now time.Time) error {
+    if q == nil { return nil }
+    return q.Enqueue(ctx, jobs.New(JobCleanupRoom, map[string]string{"room_id": roomID}, now))
+}
+
+// CleanupService runs the cascade jobs enqueued by RoomService and UserService
+// and the scheduled purges.
+type CleanupService struct {
+    lists          store.ListRepository
+    items          store.ListItemRepository
+    counters       store.CounterRepository
+    invites        store.InviteRepository
+    trashRetention time.Duration
+    now            func() time.Time
+}
+
+func NewCleanupService(lists store.ListRepository, items store.ListItemRepository) *CleanupService {
+    return &CleanupService{lists: lists, items: items, trashRetention: DefaultTrashRetention, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// UseTrashRetention sets how long trashed lists and items are kept before
+// PurgeTrash removes them.
+func (s *CleanupService) UseTrashRetention(d time.Duration) {
+    if d > 0 { s.trashRetention = d }
+}
+
+// UseCounters injects the shared counters PurgeCounters cleans up.
+func (s *CleanupService) UseCounters(counters store.CounterRepository) { s.counters = counters }
+
+// UseInvites makes CleanupRoom remove the invites of deleted rooms too.
+func (s *CleanupService) UseInvites(invites store.InviteRepository) { s.invites = invites }
+
+// CleanupRoom handles JobCleanupRoom: it deletes every item of the room, then
+// every list, soft-deleted ones included, then its invites. Category choices live on the items
+// and go with them; the category index is shared across rooms and is kept.
+// Both steps are idempotent, so a retry finishes a partial run.
+func (s *CleanupService) CleanupRoom(ctx context.Context, job models.Job) error {
+    roomID := job.Payload["room_id"]
+    if roomID == "" { return jobs.Permanent(fmt.Errorf("cleanup_room: missing room_id")) }
+    items, err := s.items.DeleteByRoom(ctx, roomID)
+    if err != nil { return fmt.Errorf("delete items: %w", err) }
+    lists, err := s.lists.DeleteByRoom(ctx, roomID)
+    if err != nil { return fmt.Errorf("delete lists: %w", err) }
+    invites := 0
+    if s.invites != nil {
+        invites, err = s.invites.DeleteByRoom(ctx, roomID)
+        if err != nil { return fmt.Errorf("delete invites: %w", err) }
+    }
+    log.Printf("cleanup_room %s: removed %d lists, %d items, %d invites", roomID, lists, items, invites)
+    return nil
+}
+
+// PurgeTrash handles JobPurgeTrash: it removes items trashed before the
+// retention cutoff, then each expired list together with all of its items.
+// Items are deleted before their list, so a retry finishes a partial run.
+func (s *CleanupService) PurgeTrash(ctx context.Context, _ models.Job) error {
+    cutoff := s.now().Add(-s.trashRetention)
+    items, err := s.items.PurgeDeletedBefore(ctx, cutoff)
+    if err != nil { return fmt.Errorf("purge items: %w", err) }
+    expired, err := s.lists.ListDeletedBefore(ctx, cutoff)
+    if err != nil { return fmt.Errorf("find expired lists: %w", err) }
+    lists := 0
+    for _, l := range expired {
+        // Skip lists restored since they were listed.
+        cur, err := s.lists.GetByID(ctx, l.ListID)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return fmt.Errorf("get list %s: %w", l.ListID, err) }
+        if !cur.IsDeleted || !cur.UpdatedAt.Before(cutoff) { continue }
+        n, err := s.items.DeleteByList(ctx, l.ListID)
+        if err != nil { return fmt.Errorf("delete items of list %s: %w", l.ListID, err) }
+        items += n
+        err = s.lists.Delete(ctx, l.ListID)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return fmt.Errorf("delete list %s: %w", l.ListID, err) }
+        lists++
+    }
+    if lists > 0 || items > 0 {
+        log.Printf("purge_trash: removed %d lists, %d items deleted before %s", lists, items, cutoff.Format(time.RFC3339))
+    }
+    return nil
+}
+
+// PurgeCounters handles JobPurgeCounters.
+func (s *CleanupService) PurgeCounters(ctx context.Context, _ models.Job) error {
+    if s.counters == nil { return nil }
+    n, err := s.counters.DeleteExpired(ctx, s.now())
+    if err != nil { return fmt.Errorf("purge counters: %w", err) }
+    if n > 0 { log.Printf("purge_counters: removed %d expired counters", n) }
+    return nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+    "context"
+    "testing"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/jobs"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+    "github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
+)
+
+func TestRoomDeletionCascadesThroughJobs(t *testing.T) {
+    ctx := context.Background()
+    st := memstore.NewStore()
+    users, rooms, lists, items := memstore.NewUserRepo(st), memstore.NewRoomRepo(st), memstore.NewListRepo(st), memstore.NewListItemRepo(st)
+    queue, invites := memstore.NewJobRepo(st), memstore.NewInviteRepo(st)
+    us := NewUserService(users, rooms, memstore.Tx{}, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, memstore.Tx{})
+    us.UseJobQueue(queue)
+    rs.UseJobQueue(queue)
+    rs.UseInvites(invites)
+    ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+    pool := jobs.NewPool(queue, jobs.Options{})
+    cleanup := NewCleanupService(lists, items)
+    cleanup.UseInvites(invites)
+    pool.Handle(JobCleanupRoom, cleanup.CleanupRoom)
+
+    seed := func(name string) (*models.User, *models.List) {
+        cu, err := us.CreateUserWithSoloRoom(ctx, name, "")
+        if err != nil { t.Fatalf("create user: %v", err) }
+        l, err := ls.CreateList(ctx, cu.User, *cu.User.RoomID, "Groceries", "", "")
+        if err != nil { t.Fatalf("create list: %v", err) }
+        for _, d := range []string{"milk", "eggs"} {
+            if _, err := ls.CreateItem(ctx, cu.User, *cu.User.RoomID, l.ListID, d, "", "", ""); err != nil { t.Fatalf("create item: %v", err) }
+        }
+        return cu.User, l
+    }
+    alice, aliceList := seed("Alice")
+    bob, bobList := seed("Bob")
+    if _, err := rs.CreateInvite(ctx, alice, InviteOptions{}); err != nil { t.Fatalf("create invite: %v", err) }
+
+    deleted, err := rs.VoteDeletion(ctx, alice)
+    if err != nil || !deleted { t.Fatalf("vote deletion: %v %v", deleted, err) }
+    // Nothing is removed until the job runs.
+    if _, err := lists.GetByID(ctx, aliceList.ListID); err != nil { t.Fatalf("list removed before job ran: %v", err) }
+    ran, err := pool.RunOnce(ctx)
+    if err != nil || !ran { t.Fatalf("run cleanup: %v %v", ran, err) }
+    if _, err := lists.GetByID(ctx, aliceList.ListID); err != derr.ErrNotFound { t.Fatalf("list not cleaned up: %v", err) }
+    if left, _, _ := items.ListByList(ctx, aliceList.ListID, store.Page{}); len(left) != 0 { t.Fatalf("items not cleaned up: %d left", len(left)) }
+    if left, _ := invites.ListByRoom(ctx, *alice.RoomID); len(left) != 0 { t.Fatalf("invites not cleaned up: %d left", len(left)) }
+
+    // Other rooms are untouched; deleting an account with a solo room cascades too.
+    if left, _, _ := items.ListByList(ctx, bobList.ListID, store.Page{}); len(left) != 2 { t.Fatalf("bob's items touched: %d left", len(left)) }
+    if err := us.DeleteAccount(ctx, bob.UserID); err != nil { t.Fatalf("delete account: %v", err) }
+    if ran, err := pool.RunOnce(ctx); err != nil || !ran { t.Fatalf("run cleanup: %v %v", ran, err) }
+    if _, err := lists.GetByID(ctx, bobList.ListID); err != derr.ErrNotFound { t.Fatalf("bob's list not cleaned up: %v", err) }
+    if ran, _ := pool.RunOnce(ctx); ran { t.Fatalf("unexpected extra job") }
+}
+
+func TestCleanupRoomRejectsMissingRoomID(t *testing.T) {
+    _, _, _, lists, items := memstore.Compose()
+    err := NewCleanupService(lists, items).CleanupRoom(context.Background(), models.Job{Kind: JobCleanupRoom})
+    if err == nil { t.Fatalf("expected error for missing room_id") }
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/pkg/ids"
+)
+
+// Invite limits. An invite without an expiry gets DefaultInviteTTL.
+const (
+    DefaultInviteTTL = 7 * 24 * time.Hour
+    MaxInviteTTL     = 90 * 24 * time.Hour
+    MaxInviteNameLen = 64
+)
+
+// InviteOptions describes a new invite. MaxUses 0 means unlimited; TTL 0
+// means DefaultInviteTTL. Link adds a long link token next to the code.
+type InviteOptions struct {
+    Name    string
+    MaxUses int
+    TTL     time.Duration
+    Link    bool
+}
+
+// CreateInvite adds an invite to the user's active room, which takes
+// PermShareRoom.
+func (s *RoomService) CreateInvite(ctx context.Context, user *models.User, opts InviteOptions) (*models.Invite, error) {
+    if s.invites == nil { return nil, derr.ErrNotFound }
+    name := strings.TrimSpace(opts.Name)
+    if utf8.RuneCountInString(name) > MaxInviteNameLen || opts.MaxUses < 0 || opts.TTL < 0 || opts.TTL > MaxInviteTTL { return nil, derr.ErrBadRequest }
+    if opts.TTL == 0 { opts.TTL = DefaultInviteTTL }
+    rm, err := s.activeRoom(ctx, user)
+    if err != nil { return nil, err }
+    if err := requirePermission(rm, user.UserID, PermShareRoom); err != nil { return nil, err }
+    now := time.Now().UTC()
+    inv := &models.Invite{
+        InviteID:  ids.NewID("inv"),
+        RoomID:    rm.RoomID,
+        Name:      name,
+        CreatedBy: user.UserID,
+        MaxUses:   opts.MaxUses,
+        CreatedAt: now,
+        ExpiresAt: now.Add(opts.TTL),
+    }
+    // Codes are short, so retry the rare collision with a fresh one.
+    for attempt := 1; ; attempt++ {
+        inv.Code = ids.NewShareToken5()
+        if opts.Link { inv.LinkToken = ids.NewToken() }
+        err := s.invites.Put(ctx, inv)
+        if err == nil { return inv, nil }
+        if !errors.Is(err, derr.ErrConflict) || attempt == 3 { return nil, err }
+    }
+}
+
+// ListInvites returns the invites of the user's active room that can still
+// be used, oldest first. It takes PermShareRoom.
+func (s *RoomService) ListInvites(ctx context.Context, user *models.User) ([]models.Invite, error) {
+    if s.invites == nil { return []models.Invite{}, nil }
+    rm, err := s.activeRoom(ctx, user)
+    if err != nil { return nil, err }
+    if err := requirePermission(rm, user.UserID, PermShareRoom); err != nil { return nil, err }
+    all, err := s.invites.ListByRoom(ctx, rm.RoomID)
+    if err != nil { return nil, err }
+    now := time.Now().UTC()
+    out := []models.Invite{}
+    for _, inv := range all {
+        if inv.Usable(now) { out = append(out, inv) }
+    }
+    return out, nil
+}
+
+// RevokeInvite stops an invite of the user's active room from working. It
+// takes PermShareRoom; invites of other rooms are derr.ErrNotFound.
+func (s *RoomService) RevokeInvite(ctx context.Context, user *models.User, inviteID string) error {
+    if s.invites == nil { return derr.ErrNotFound }
+    rm, err := s.activeRoom(ctx, user)
+    if err != nil { return err }
+    if err := requirePermission(rm, user.UserID, PermShareRoom); err != nil { return err }
+    inv, err := s.invites.GetByID(ctx, inviteID)
+    if err != nil { return err }
+    if inv.RoomID != rm.RoomID { return derr.ErrNotFound }
+    return s.invites.Revoke(ctx, inviteID, time.Now().UTC())
+}
+
+// inviteFor checks a join token for rm. It returns the invite the token
+// names, or nil when the token is the room's legacy share token. A token that
+// is neither, or names an invite that can no longer be used, is
+// derr.ErrForbidden.
+func (s *RoomService) inviteFor(ctx context.Context, rm *models.Room, token string, now time.Time) (*models.Invite, error) {
+    if token == "" { return nil, derr.ErrForbidden }
+    if s.invites != nil {
+        inv, err := s.invites.GetByToken(ctx, token)
+        if err != nil && !errors.Is(err, derr.ErrNotFound) { return nil, err }
+        if err == nil && inv.RoomID == rm.RoomID {
+            if !inv.Usable(now) { return nil, derr.ErrForbidden }
+            return inv, nil
+        }
+    }
+    if rm.ShareToken == nil || *rm.ShareToken == "" || token != *rm.ShareToken { return nil, derr.ErrForbidden }
+    return nil, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
@@ -12,46 +13,70 @@ import (
 	"github.com/janvillarosa/gracie-app/backend/pkg/ids"
 )
 
+// DefaultTrashRetention is how long deleted lists and items stay in a room's
+// trash before the purge job removes them.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// MaxPageSize caps the page size of ListLists and ListItems.
+const MaxPageSize = 200
+
 type ListService struct {
-	users       store.UserRepository
-	rooms       store.RoomRepository
-	lists       store.ListRepository
-	items       store.ListItemRepository
-	categorizer categorization.Categorizer
+	users          store.UserRepository
+	rooms          store.RoomRepository
+	lists          store.ListRepository
+	items          store.ListItemRepository
+	categorizer    categorization.Categorizer
+	trashRetention time.Duration
 }
 
 func NewListService(users store.UserRepository, rooms store.RoomRepository, lists store.ListRepository, items store.ListItemRepository, categorizer categorization.Categorizer) *ListService {
-	return &ListService{users: users, rooms: rooms, lists: lists, items: items, categorizer: categorizer}
+	return &ListService{users: users, rooms: rooms, lists: lists, items: items, categorizer: categorizer, trashRetention: DefaultTrashRetention}
+}
+
+// UseTrashRetention sets how long trashed lists and items are kept; it only
+// affects the purge times reported by GetTrash. Match the CleanupService setting.
+func (s *ListService) UseTrashRetention(d time.Duration) {
+	if d > 0 {
+		s.trashRetention = d
+	}
 }
 
 func (s *ListService) ensureRoomMembership(ctx context.Context, user *models.User, roomID string) error {
-	if user.RoomID == nil || *user.RoomID == "" {
-		return derr.ErrForbidden
+	_, err := s.memberRoom(ctx, user, roomID)
+	return err
+}
+
+// ensureRoomPermission is ensureRoomMembership for changes: the user's role
+// in the room must also allow p.
+func (s *ListService) ensureRoomPermission(ctx context.Context, user *models.User, roomID string, p Permission) error {
+	rm, err := s.memberRoom(ctx, user, roomID)
+	if err != nil {
+		return err
 	}
-	if *user.RoomID != roomID {
-		return derr.ErrForbidden
+	return requirePermission(rm, user.UserID, p)
+}
+
+func (s *ListService) memberRoom(ctx context.Context, user *models.User, roomID string) (*models.Room, error) {
+	if roomID == "" {
+		return nil, derr.ErrForbidden
 	}
 	rm, err := s.rooms.GetByID(ctx, roomID)
-	if err != nil {
-		return err
+	if errors.Is(err, derr.ErrNotFound) {
+		return nil, derr.ErrForbidden
 	}
-	// verify membership
-	isMember := false
-	for _, mid := range rm.MemberIDs {
-		if mid == user.UserID {
-			isMember = true
-			break
-		}
+	if err != nil {
+		return nil, err
 	}
-	if !isMember {
-		return derr.ErrForbidden
+	// Any room the user is a member of, not only the active one.
+	if !isMember(rm, user.UserID) {
+		return nil, derr.ErrForbidden
 	}
-	return nil
+	return rm, nil
 }
 
 // Lists
 func (s *ListService) CreateList(ctx context.Context, user *models.User, roomID, name, description string, icon string) (*models.List, error) {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditLists); err != nil {
 		return nil, err
 	}
 	if name == "" {
@@ -64,6 +89,7 @@ func (s *ListService) CreateList(ctx context.Context, user *models.User, roomID,
 		Name:          name,
 		Description:   description,
 		DeletionVotes: map[string]string{},
+		Version:       1,
 		CreatedAt:     now,
 		UpdatedAt:     now,
 	}
@@ -79,15 +105,48 @@ func (s *ListService) CreateList(ctx context.Context, user *models.User, roomID,
 	return l, nil
 }
 
-func (s *ListService) ListLists(ctx context.Context, user *models.User, roomID string) ([]models.List, error) {
+// ListLists returns a page of the room's lists, oldest first, and the cursor
+// of the next page ("" after the last). A zero page.Limit returns them all.
+func (s *ListService) ListLists(ctx context.Context, user *models.User, roomID string, page store.Page) ([]models.List, string, error) {
 	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+		return nil, "", err
+	}
+	return s.lists.ListByRoom(ctx, roomID, clampPage(page))
+}
+
+// GetList returns one of the room's lists.
+func (s *ListService) GetList(ctx context.Context, user *models.User, roomID, listID string) (*models.List, error) {
+	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+		return nil, err
+	}
+	l, err := s.lists.GetByID(ctx, listID)
+	if err != nil {
 		return nil, err
 	}
-	return s.lists.ListByRoom(ctx, roomID)
+	if l.RoomID != roomID || l.IsDeleted {
+		return nil, derr.ErrForbidden
+	}
+	return l, nil
+}
+
+func clampPage(page store.Page) store.Page {
+	if page.Limit > MaxPageSize {
+		page.Limit = MaxPageSize
+	}
+	return page
+}
+
+// nextVersion is the version expected by the write following one made at v,
+// so multi-field updates stay conditional on nobody else writing in between.
+func nextVersion(v int64) int64 {
+	if v == store.AnyVersion {
+		return v
+	}
+	return v + 1
 }
 
 func (s *ListService) VoteListDeletion(ctx context.Context, user *models.User, roomID, listID string) (bool, error) {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermDeleteLists); err != nil {
 		return false, err
 	}
 	l, err := s.lists.GetByID(ctx, listID)
@@ -101,16 +160,16 @@ func (s *ListService) VoteListDeletion(ctx context.Context, user *models.User, r
 	if err := s.lists.AddDeletionVote(ctx, listID, user.UserID, now); err != nil {
 		return false, err
 	}
-	// finalize when all room members have voted
+	// finalize when all room members who may vote have
 	rm, err := s.rooms.GetByID(ctx, roomID)
 	if err != nil {
 		return false, err
 	}
-	return s.lists.FinalizeDeleteIfVotedByAll(ctx, listID, rm.MemberIDs, now)
+	return s.lists.FinalizeDeleteIfVotedByAll(ctx, listID, membersWith(rm, PermDeleteLists), now)
 }
 
 func (s *ListService) CancelListDeletionVote(ctx context.Context, user *models.User, roomID, listID string) error {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermDeleteLists); err != nil {
 		return err
 	}
 	l, err := s.lists.GetByID(ctx, listID)
@@ -123,9 +182,11 @@ func (s *ListService) CancelListDeletionVote(ctx context.Context, user *models.U
 	return s.lists.RemoveDeletionVote(ctx, listID, user.UserID)
 }
 
-// UpdateList updates the list's name and/or description.
-func (s *ListService) UpdateList(ctx context.Context, user *models.User, roomID, listID string, name *string, description *string, icon *string, notes *string) (*models.List, error) {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+// UpdateList updates the list's name, description, icon and/or notes in a
+// single write. Unless ifVersion is store.AnyVersion the list must still be at
+// that version, or the update fails with derr.ErrPreconditionFailed.
+func (s *ListService) UpdateList(ctx context.Context, user *models.User, roomID, listID string, name *string, description *string, icon *string, notes *string, ifVersion int64) (*models.List, error) {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditLists); err != nil {
 		return nil, err
 	}
 	l, err := s.lists.GetByID(ctx, listID)
@@ -135,52 +196,29 @@ func (s *ListService) UpdateList(ctx context.Context, user *models.User, roomID,
 	if l.RoomID != roomID || l.IsDeleted {
 		return nil, derr.ErrForbidden
 	}
+	if ifVersion != store.AnyVersion && l.Version != ifVersion {
+		return nil, derr.ErrPreconditionFailed
+	}
 	if name == nil && description == nil && icon == nil && notes == nil {
 		return l, nil
 	}
-	now := time.Now().UTC()
-	if name != nil {
-		if *name == "" {
-			return nil, derr.ErrBadRequest
-		}
-		if err := s.lists.UpdateName(ctx, listID, *name, now); err != nil {
-			return nil, err
-		}
-	}
-	if description != nil {
-		if err := s.lists.UpdateDescription(ctx, listID, *description, now); err != nil {
-			return nil, err
-		}
+	if name != nil && *name == "" {
+		return nil, derr.ErrBadRequest
 	}
-	if icon != nil {
-		if *icon == "" {
-			if err := s.lists.UpdateIcon(ctx, listID, "", now); err != nil {
-				return nil, err
-			}
-		} else {
-			if !models.IsValidListIcon(*icon) {
-				return nil, derr.ErrBadRequest
-			}
-			if err := s.lists.UpdateIcon(ctx, listID, *icon, now); err != nil {
-				return nil, err
-			}
-		}
+	if icon != nil && *icon != "" && !models.IsValidListIcon(*icon) {
+		return nil, derr.ErrBadRequest
 	}
-	if notes != nil {
-		// Cap notes length to prevent abuse (64KB)
-		if len(*notes) > 65535 {
-			return nil, derr.ErrBadRequest
-		}
-		if err := s.lists.UpdateNotes(ctx, listID, *notes, now); err != nil {
-			return nil, err
-		}
+	// Cap notes length to prevent abuse (64KB)
+	if notes != nil && len(*notes) > 65535 {
+		return nil, derr.ErrBadRequest
 	}
-	return s.lists.GetByID(ctx, listID)
+	patch := store.ListPatch{Name: name, Description: description, Icon: icon, Notes: notes}
+	return s.lists.Patch(ctx, listID, patch, ifVersion, time.Now().UTC())
 }
 
 // Items
 func (s *ListService) CreateItem(ctx context.Context, user *models.User, roomID, listID, description string, quantity string, unit string, category string) (*models.ListItem, error) {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditItems); err != nil {
 		return nil, err
 	}
 	l, err := s.lists.GetByID(ctx, listID)
@@ -192,7 +230,7 @@ func (s *ListService) CreateItem(ctx context.Context, user *models.User, roomID,
 	}
 	now := time.Now().UTC()
 	// Determine append order: use max existing order + 1000, or fallback to timestamp if empty
-	items, err := s.items.ListByList(ctx, listID)
+	items, _, err := s.items.ListByList(ctx, listID, store.Page{})
 	if err != nil {
 		return nil, err
 	}
@@ -218,6 +256,7 @@ func (s *ListService) CreateItem(ctx context.Context, user *models.User, roomID,
 		Unit:        unit,
 		Category:    category,
 		Completed:   false,
+		Version:     1,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
@@ -236,53 +275,52 @@ func (s *ListService) CreateItem(ctx context.Context, user *models.User, roomID,
 	return it, nil
 }
 
-// normalizeItemForRead splits a legacy item whose quantity/unit is still baked
-// into the description. Only rewrites the returned copy — never touches the DB.
-// Skipped when Quantity is already populated (no double-strip).
-func normalizeItemForRead(it models.ListItem) models.ListItem {
-	if it.Quantity != "" {
-		return it
-	}
-	desc, qty, unit := parse.ParseInput(it.Description)
-	if qty == "" {
-		return it
-	}
-	it.Description = desc
-	it.Quantity = qty
-	it.Unit = unit
-	return it
-}
-
-func (s *ListService) ListItems(ctx context.Context, user *models.User, roomID, listID string, includeCompleted bool) ([]models.ListItem, error) {
+// ListItems returns a page of the list's items in display order and the
+// cursor of the next page ("" after the last). Archived items, and completed
+// ones unless includeCompleted, are skipped without counting towards
+// page.Limit; a zero limit returns them all.
+func (s *ListService) ListItems(ctx context.Context, user *models.User, roomID, listID string, includeCompleted bool, page store.Page) ([]models.ListItem, string, error) {
 	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	l, err := s.lists.GetByID(ctx, listID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if l.RoomID != roomID || l.IsDeleted {
-		return nil, derr.ErrForbidden
-	}
-	items, err := s.items.ListByList(ctx, listID)
-	if err != nil {
-		return nil, err
-	}
-
-	out := make([]models.ListItem, 0, len(items))
-	for _, it := range items {
-		if it.IsArchived {
-			continue
+		return nil, "", derr.ErrForbidden
+	}
+	page = clampPage(page)
+	out := []models.ListItem{}
+	for {
+		// Read only as many items as the page still needs; filtered ones
+		// are made up for by the next read.
+		read := page
+		if page.Limit > 0 {
+			read.Limit = page.Limit - len(out)
 		}
-		if !includeCompleted && it.Completed {
-			continue
+		items, next, err := s.items.ListByList(ctx, listID, read)
+		if err != nil {
+			return nil, "", err
 		}
-		out = append(out, normalizeItemForRead(it))
+		for _, it := range items {
+			if it.IsArchived {
+				continue
+			}
+			if !includeCompleted && it.Completed {
+				continue
+			}
+			out = append(out, it)
+		}
+		if next == "" || (page.Limit > 0 && len(out) == page.Limit) {
+			return out, next, nil
+		}
+		page.Cursor = next
 	}
-	return out, nil
 }
 
-func (s *ListService) UpdateItem(ctx context.Context, user *models.User, roomID, listID, itemID string, description *string, completed *bool, quantity *string, unit *string, category *string, starred *bool) (*models.ListItem, error) {
+// GetItem returns one of the list's items.
+func (s *ListService) GetItem(ctx context.Context, user *models.User, roomID, listID, itemID string) (*models.ListItem, error) {
 	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
 		return nil, err
 	}
@@ -290,46 +328,40 @@ func (s *ListService) UpdateItem(ctx context.Context, user *models.User, roomID,
 	if err != nil {
 		return nil, err
 	}
-	if it.RoomID != roomID || it.ListID != listID {
+	if it.RoomID != roomID || it.ListID != listID || it.IsDeleted {
 		return nil, derr.ErrForbidden
 	}
-	now := time.Now().UTC()
-	if description != nil {
-		if err := s.items.UpdateDescription(ctx, itemID, *description, now); err != nil {
-			return nil, err
-		}
-	}
-	if completed != nil {
-		if err := s.items.UpdateCompletion(ctx, itemID, *completed, now); err != nil {
-			return nil, err
-		}
-	}
-	if quantity != nil {
-		if err := s.items.UpdateQuantity(ctx, itemID, *quantity, now); err != nil {
-			return nil, err
-		}
+	return it, nil
+}
+
+// UpdateItem updates the given fields of an item in a single write. Unless
+// ifVersion is store.AnyVersion the item must still be at that version, or the
+// update fails with derr.ErrPreconditionFailed.
+func (s *ListService) UpdateItem(ctx context.Context, user *models.User, roomID, listID, itemID string, description *string, completed *bool, quantity *string, unit *string, category *string, starred *bool, ifVersion int64) (*models.ListItem, error) {
+	// Checking an item off is all guests may do.
+	perm := PermEditItems
+	if description == nil && quantity == nil && unit == nil && category == nil && starred == nil {
+		perm = PermCheckItems
+	}
+	if err := s.ensureRoomPermission(ctx, user, roomID, perm); err != nil {
+		return nil, err
 	}
-	if unit != nil {
-		if err := s.items.UpdateUnit(ctx, itemID, *unit, now); err != nil {
-			return nil, err
-		}
+	it, err := s.items.GetByID(ctx, itemID)
+	if err != nil {
+		return nil, err
 	}
-	if category != nil {
-		if err := s.items.UpdateCategory(ctx, itemID, *category, now); err != nil {
-			return nil, err
-		}
+	if it.RoomID != roomID || it.ListID != listID {
+		return nil, derr.ErrForbidden
 	}
-	if starred != nil {
-		if err := s.items.UpdateStarred(ctx, itemID, *starred, now); err != nil {
-			return nil, err
-		}
+	if ifVersion != store.AnyVersion && it.Version != ifVersion {
+		return nil, derr.ErrPreconditionFailed
 	}
-	// return latest
-	return s.items.GetByID(ctx, itemID)
+	patch := store.ItemPatch{Description: description, Completed: completed, Quantity: quantity, Unit: unit, Category: category, Starred: starred}
+	return s.items.Patch(ctx, itemID, patch, ifVersion, time.Now().UTC())
 }
 
 func (s *ListService) ArchiveCompletedItems(ctx context.Context, user *models.User, roomID, listID string) error {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditLists); err != nil {
 		return err
 	}
 	l, err := s.lists.GetByID(ctx, listID)
@@ -353,7 +385,7 @@ func (s *ListService) GetPantry(ctx context.Context, user *models.User, roomID s
 	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
 		return nil, err
 	}
-	items, err := s.items.ListArchivedByRoom(ctx, roomID)
+	items, _, err := s.items.ListArchivedByRoom(ctx, roomID, store.Page{})
 	if err != nil {
 		return nil, err
 	}
@@ -380,7 +412,7 @@ func (s *ListService) GetPantry(ctx context.Context, user *models.User, roomID s
 
 
 func (s *ListService) DeleteItem(ctx context.Context, user *models.User, roomID, listID, itemID string) error {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditItems); err != nil {
 		return err
 	}
 	it, err := s.items.GetByID(ctx, itemID)
@@ -390,13 +422,124 @@ func (s *ListService) DeleteItem(ctx context.Context, user *models.User, roomID,
 	if it.RoomID != roomID || it.ListID != listID {
 		return derr.ErrForbidden
 	}
-	return s.items.Delete(ctx, itemID)
+	return s.items.SoftDelete(ctx, itemID, time.Now().UTC())
+}
+
+// TrashedList is a deleted list with the time it will be purged.
+type TrashedList struct {
+	models.List
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashedItem is a deleted item with the time it will be purged.
+type TrashedItem struct {
+	models.ListItem
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// Trash is a room's trash, most recently deleted first.
+type Trash struct {
+	Lists []TrashedList `json:"lists"`
+	Items []TrashedItem `json:"items"`
+}
+
+// GetTrash returns the room's deleted lists and items. Items of a deleted list
+// are not listed on their own; restoring the list brings them back.
+func (s *ListService) GetTrash(ctx context.Context, user *models.User, roomID string) (*Trash, error) {
+	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+		return nil, err
+	}
+	lists, err := s.lists.ListTrashedByRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.items.ListTrashedByRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	out := &Trash{Lists: make([]TrashedList, 0, len(lists)), Items: make([]TrashedItem, 0, len(items))}
+	trashedLists := make(map[string]bool, len(lists))
+	for _, l := range lists {
+		trashedLists[l.ListID] = true
+		out.Lists = append(out.Lists, TrashedList{List: l, PurgeAt: l.UpdatedAt.Add(s.trashRetention)})
+	}
+	for _, it := range items {
+		if trashedLists[it.ListID] {
+			continue
+		}
+		out.Items = append(out.Items, TrashedItem{ListItem: normalizeItemForRead(it), PurgeAt: it.UpdatedAt.Add(s.trashRetention)})
+	}
+	return out, nil
+}
+
+// RestoreList takes a deleted list out of the trash. Its deletion votes are
+// cleared, so deleting it again needs a fresh vote from every member.
+func (s *ListService) RestoreList(ctx context.Context, user *models.User, roomID, listID string) (*models.List, error) {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditLists); err != nil {
+		return nil, err
+	}
+	l, err := s.lists.GetByID(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+	if l.RoomID != roomID {
+		return nil, derr.ErrForbidden
+	}
+	if err := s.lists.Restore(ctx, listID, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return s.lists.GetByID(ctx, listID)
+}
+
+// RestoreItem takes a deleted item out of the trash. It fails with
+// derr.ErrConflict while the item's list is itself in the trash.
+func (s *ListService) RestoreItem(ctx context.Context, user *models.User, roomID, listID, itemID string) (*models.ListItem, error) {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditItems); err != nil {
+		return nil, err
+	}
+	it, err := s.items.GetByID(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if it.RoomID != roomID || it.ListID != listID {
+		return nil, derr.ErrForbidden
+	}
+	l, err := s.lists.GetByID(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+	if l.IsDeleted {
+		return nil, derr.ErrConflict
+	}
+	now := time.Now().UTC()
+	if err := s.items.Restore(ctx, itemID, now); err != nil {
+		return nil, err
+	}
+	// The quantity backfill skips trashed items; split them as they come back.
+	if split := normalizeItemForRead(*it); split.Quantity != it.Quantity {
+		p := store.ItemPatch{Description: &split.Description, Quantity: &split.Quantity, Unit: &split.Unit}
+		return s.items.Patch(ctx, itemID, p, store.AnyVersion, now)
+	}
+	return s.items.GetByID(ctx, itemID)
+}
+
+// normalizeItemForRead splits a legacy item whose quantity/unit is still baked
+// into the description. Only rewrites the returned copy — never touches the DB.
+// The backfill_item_quantity migration leaves only trashed items like that.
+func normalizeItemForRead(it models.ListItem) models.ListItem {
+	desc, qty, unit, ok := parse.SplitLegacyQuantity(it.Description, it.Quantity)
+	if !ok {
+		return it
+	}
+	it.Description, it.Quantity, it.Unit = desc, qty, unit
+	return it
 }
 
 // UpdateItemPosition repositions an item between prev and next neighbors.
 // If there is insufficient gap, it compacts orders then inserts at midpoint.
-func (s *ListService) UpdateItemPosition(ctx context.Context, user *models.User, roomID, listID, itemID string, prevID *string, nextID *string) (*models.ListItem, error) {
-	if err := s.ensureRoomMembership(ctx, user, roomID); err != nil {
+// ifVersion applies to the moved item as in UpdateItem.
+func (s *ListService) UpdateItemPosition(ctx context.Context, user *models.User, roomID, listID, itemID string, prevID *string, nextID *string, ifVersion int64) (*models.ListItem, error) {
+	if err := s.ensureRoomPermission(ctx, user, roomID, PermEditItems); err != nil {
 		return nil, err
 	}
 	it, err := s.items.GetByID(ctx, itemID)
@@ -406,7 +549,10 @@ func (s *ListService) UpdateItemPosition(ctx context.Context, user *models.User,
 	if it.RoomID != roomID || it.ListID != listID {
 		return nil, derr.ErrForbidden
 	}
-	items, err := s.items.ListByList(ctx, listID)
+	if ifVersion != store.AnyVersion && it.Version != ifVersion {
+		return nil, derr.ErrPreconditionFailed
+	}
+	items, _, err := s.items.ListByList(ctx, listID, store.Page{})
 	if err != nil {
 		return nil, err
 	}
@@ -448,9 +594,16 @@ func (s *ListService) UpdateItemPosition(ctx context.Context, user *models.User,
 			step := 1000.0
 			cur := step
 			for _, x := range items {
-				if err := s.items.UpdateOrder(ctx, x.ItemID, cur, now); err != nil {
+				v := store.AnyVersion
+				if x.ItemID == itemID {
+					v = ifVersion
+				}
+				if err := s.items.UpdateOrder(ctx, x.ItemID, cur, v, now); err != nil {
 					return nil, err
 				}
+				if x.ItemID == itemID {
+					ifVersion = nextVersion(ifVersion)
+				}
 				if prevID != nil && x.ItemID == *prevID {
 					p := cur
 					prevOrder = &p
@@ -470,7 +623,7 @@ func (s *ListService) UpdateItemPosition(ctx context.Context, user *models.User,
 	default:
 		newOrder = chooseAfter()
 	}
-	if err := s.items.UpdateOrder(ctx, itemID, newOrder, now); err != nil {
+	if err := s.items.UpdateOrder(ctx, itemID, newOrder, ifVersion, now); err != nil {
 		return nil, err
 	}
 	return s.items.GetByID(ctx, itemID)
@@ -3,22 +3,23 @@ package services
 import (
 	"context"
 	"testing"
+	"time"
 
 	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+	"github.com/janvillarosa/gracie-app/backend/internal/migrate"
 	"github.com/janvillarosa/gracie-app/backend/internal/models"
 	"github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
 	"github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
 )
 
 func TestListValidationsAndFilters(t *testing.T) {
 	tx, users, rooms, lists, items := memstore.Compose()
-	us := NewUserService(users, rooms, tx)
-	rs := NewRoomService(users, rooms, tx)
+	us := NewUserService(users, rooms, tx, newTestAuth(t, users))
 	ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
-	rs.UseListRepos(lists, items)
 	ctx := context.Background()
 
-	cu, _ := us.CreateUserWithSoloRoom(ctx, "A")
+	cu, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
 	roomID := *cu.User.RoomID
 
 	// Create invalid name
@@ -36,7 +37,7 @@ func TestListValidationsAndFilters(t *testing.T) {
 	}
 
 	// Update list invalid icon
-	_, err = ls.UpdateList(ctx, cu.User, roomID, l.ListID, nil, nil, strPtr("BAD"), nil)
+	_, err = ls.UpdateList(ctx, cu.User, roomID, l.ListID, nil, nil, strPtr("BAD"), nil, store.AnyVersion)
 	if err != derr.ErrBadRequest {
 		t.Fatalf("want bad request on invalid update icon")
 	}
@@ -45,15 +46,190 @@ func TestListValidationsAndFilters(t *testing.T) {
 	it1, _ := ls.CreateItem(ctx, cu.User, roomID, l.ListID, "Milk", "", "", "")
 	_, _ = ls.CreateItem(ctx, cu.User, roomID, l.ListID, "Bread", "", "", "")
 	// Complete one
-	_, _ = ls.UpdateItem(ctx, cu.User, roomID, l.ListID, it1.ItemID, nil, boolPtr(true), nil, nil, nil, nil)
+	_, _ = ls.UpdateItem(ctx, cu.User, roomID, l.ListID, it1.ItemID, nil, boolPtr(true), nil, nil, nil, nil, store.AnyVersion)
 
 	// List include_completed=false should return only one
-	itemsOnlyIncomplete, _ := ls.ListItems(ctx, cu.User, roomID, l.ListID, false)
+	itemsOnlyIncomplete, _, _ := ls.ListItems(ctx, cu.User, roomID, l.ListID, false, store.Page{})
 	if len(itemsOnlyIncomplete) != 1 {
 		t.Fatalf("want 1 incomplete item, got %d", len(itemsOnlyIncomplete))
 	}
 }
 
+func TestListItemsPagesSkipFilteredItems(t *testing.T) {
+	tx, users, rooms, lists, items := memstore.Compose()
+	us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+	ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+	ctx := context.Background()
+
+	cu, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+	u, roomID := cu.User, *cu.User.RoomID
+	l, _ := ls.CreateList(ctx, u, roomID, "Groceries", "", "")
+	var want []string
+	for i, desc := range []string{"Milk", "Bread", "Eggs", "Rice", "Tea"} {
+		it, _ := ls.CreateItem(ctx, u, roomID, l.ListID, desc, "", "", "")
+		if i%2 == 0 {
+			_, _ = ls.UpdateItem(ctx, u, roomID, l.ListID, it.ItemID, nil, boolPtr(true), nil, nil, nil, nil, store.AnyVersion)
+			continue
+		}
+		want = append(want, it.ItemID)
+	}
+
+	// Completed items are skipped without shortening the page.
+	var got []string
+	page := store.Page{Limit: 1}
+	for {
+		its, next, err := ls.ListItems(ctx, u, roomID, l.ListID, false, page)
+		if err != nil {
+			t.Fatalf("list items: %v", err)
+		}
+		if len(its) != 1 && next != "" {
+			t.Fatalf("short page before the end: %d items", len(its))
+		}
+		for _, it := range its {
+			got = append(got, it.ItemID)
+		}
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("paged items: got %v, want %v", got, want)
+	}
+	if _, _, err := ls.ListItems(ctx, u, roomID, l.ListID, false, store.Page{Cursor: "bogus"}); err != derr.ErrBadRequest {
+		t.Fatalf("bad cursor: got %v, want bad request", err)
+	}
+}
+
+func TestVersionedUpdates(t *testing.T) {
+	tx, users, rooms, lists, items := memstore.Compose()
+	us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+	rs := NewRoomService(users, rooms, tx)
+	ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+	ctx := context.Background()
+
+	cu, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+	u, roomID := cu.User, *cu.User.RoomID
+	l, _ := ls.CreateList(ctx, u, roomID, "Groceries", "", "")
+	if l.Version != 1 {
+		t.Fatalf("new list version: %d", l.Version)
+	}
+
+	// An update is a single write, however many fields it touches.
+	l, err := ls.UpdateList(ctx, u, roomID, l.ListID, strPtr("Food"), strPtr("Weekly"), nil, nil, 1)
+	if err != nil || l.Version != 2 || l.Description != "Weekly" {
+		t.Fatalf("update list: %v, version %d", err, l.Version)
+	}
+	if _, err := ls.UpdateList(ctx, u, roomID, l.ListID, strPtr("Stale"), nil, nil, nil, 1); err != derr.ErrPreconditionFailed {
+		t.Fatalf("stale list update: got %v", err)
+	}
+	if got, _ := ls.GetList(ctx, u, roomID, l.ListID); got.Name != "Food" {
+		t.Fatalf("stale update applied: %q", got.Name)
+	}
+
+	it, _ := ls.CreateItem(ctx, u, roomID, l.ListID, "Milk", "", "", "")
+	it, err = ls.UpdateItem(ctx, u, roomID, l.ListID, it.ItemID, nil, boolPtr(true), strPtr("2"), nil, nil, nil, it.Version)
+	if err != nil || it.Version != 2 || !it.Completed || it.Quantity != "2" {
+		t.Fatalf("update item: %v, version %d", err, it.Version)
+	}
+	if _, err := ls.UpdateItemPosition(ctx, u, roomID, l.ListID, it.ItemID, nil, nil, 1); err != derr.ErrPreconditionFailed {
+		t.Fatalf("stale reorder: got %v", err)
+	}
+	if _, err := ls.UpdateItem(ctx, u, roomID, l.ListID, it.ItemID, strPtr("Oat milk"), nil, nil, nil, nil, nil, store.AnyVersion); err != nil {
+		t.Fatalf("unconditional update: %v", err)
+	}
+
+	if rm, err := rs.UpdateRoomSettings(ctx, u, strPtr("Flat"), strPtr("Ours"), 1); err != nil || rm.Version != 2 {
+		t.Fatalf("update settings: %v", err)
+	}
+	if _, err := rs.UpdateRoomSettings(ctx, u, strPtr("Loft"), nil, 1); err != derr.ErrPreconditionFailed {
+		t.Fatalf("stale settings: got %v", err)
+	}
+	if rm, _ := rs.GetMyRoom(ctx, u); rm.Version != 2 || rm.DisplayName != "Flat" || rm.Description != "Ours" {
+		t.Fatalf("room after settings: %+v", rm)
+	}
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	tx, users, rooms, lists, items := memstore.Compose()
+	us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+	ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+	ctx := context.Background()
+
+	cu, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+	u, roomID := cu.User, *cu.User.RoomID
+	l, _ := ls.CreateList(ctx, u, roomID, "Groceries", "", "")
+	milk, _ := ls.CreateItem(ctx, u, roomID, l.ListID, "Milk", "", "", "")
+	bread, _ := ls.CreateItem(ctx, u, roomID, l.ListID, "Bread", "", "", "")
+
+	// Deleting an item moves it to the trash.
+	if err := ls.DeleteItem(ctx, u, roomID, l.ListID, milk.ItemID); err != nil {
+		t.Fatalf("delete item: %v", err)
+	}
+	if left, _, _ := ls.ListItems(ctx, u, roomID, l.ListID, true, store.Page{}); len(left) != 1 {
+		t.Fatalf("want 1 item after delete, got %d", len(left))
+	}
+	if err := ls.DeleteItem(ctx, u, roomID, l.ListID, milk.ItemID); err != derr.ErrNotFound {
+		t.Fatalf("delete trashed item: got %v, want not found", err)
+	}
+	trash, err := ls.GetTrash(ctx, u, roomID)
+	if err != nil || len(trash.Items) != 1 || trash.Items[0].ItemID != milk.ItemID {
+		t.Fatalf("trash after item delete: %+v %v", trash, err)
+	}
+	if want := trash.Items[0].UpdatedAt.Add(DefaultTrashRetention); !trash.Items[0].PurgeAt.Equal(want) {
+		t.Fatalf("purge_at: got %v, want %v", trash.Items[0].PurgeAt, want)
+	}
+	if _, err := ls.RestoreItem(ctx, u, roomID, l.ListID, milk.ItemID); err != nil {
+		t.Fatalf("restore item: %v", err)
+	}
+	if left, _, _ := ls.ListItems(ctx, u, roomID, l.ListID, true, store.Page{}); len(left) != 2 {
+		t.Fatalf("want 2 items after restore, got %d", len(left))
+	}
+
+	// A deleted list hides its items; they come back with the list, not on their own.
+	_ = ls.DeleteItem(ctx, u, roomID, l.ListID, bread.ItemID)
+	if deleted, err := ls.VoteListDeletion(ctx, u, roomID, l.ListID); err != nil || !deleted {
+		t.Fatalf("vote list deletion: %v %v", deleted, err)
+	}
+	trash, _ = ls.GetTrash(ctx, u, roomID)
+	if len(trash.Lists) != 1 || len(trash.Items) != 0 {
+		t.Fatalf("trash after list delete: %d lists, %d items", len(trash.Lists), len(trash.Items))
+	}
+	if _, err := ls.RestoreItem(ctx, u, roomID, l.ListID, bread.ItemID); err != derr.ErrConflict {
+		t.Fatalf("restore item of trashed list: got %v, want conflict", err)
+	}
+	restored, err := ls.RestoreList(ctx, u, roomID, l.ListID)
+	if err != nil || restored.IsDeleted || len(restored.DeletionVotes) != 0 {
+		t.Fatalf("restore list: %+v %v", restored, err)
+	}
+	if _, err := ls.RestoreList(ctx, u, roomID, l.ListID); err != derr.ErrNotFound {
+		t.Fatalf("restore live list: got %v, want not found", err)
+	}
+
+	// The purge removes only what outlived the retention.
+	cs := NewCleanupService(lists, items)
+	cs.UseTrashRetention(time.Hour)
+	if err := cs.PurgeTrash(ctx, models.Job{Kind: JobPurgeTrash}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if _, err := items.GetByID(ctx, bread.ItemID); err != nil {
+		t.Fatalf("purge removed an item inside the retention: %v", err)
+	}
+	_, _ = ls.VoteListDeletion(ctx, u, roomID, l.ListID)
+	cs.now = func() time.Time { return time.Now().UTC().Add(2 * time.Hour) }
+	if err := cs.PurgeTrash(ctx, models.Job{Kind: JobPurgeTrash}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if _, err := lists.GetByID(ctx, l.ListID); err != derr.ErrNotFound {
+		t.Fatalf("expired list not purged: %v", err)
+	}
+	for _, id := range []string{milk.ItemID, bread.ItemID} {
+		if _, err := items.GetByID(ctx, id); err != derr.ErrNotFound {
+			t.Fatalf("item %s of purged list left behind: %v", id, err)
+		}
+	}
+}
+
 func strPtr(s string) *string { return &s }
 func boolPtr(b bool) *bool    { return &b }
 
@@ -79,3 +255,43 @@ func TestNormalizeLegacyItemForRead(t *testing.T) {
 		t.Fatalf("plain normalize changed item: (%q,%q,%q)", got3.Description, got3.Quantity, got3.Unit)
 	}
 }
+
+func TestRestoreSplitsLegacyTrashedItem(t *testing.T) {
+	tx, users, rooms, lists, items := memstore.Compose()
+	us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+	ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+	ctx := context.Background()
+
+	cu, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+	u, roomID := cu.User, *cu.User.RoomID
+	l, _ := ls.CreateList(ctx, u, roomID, "Groceries", "", "")
+	// An item from before quantities, trashed before the migration ran.
+	now := time.Now().UTC()
+	legacy := &models.ListItem{ItemID: "it_legacy", ListID: l.ListID, RoomID: roomID, Description: "2 lbs chicken breast", CreatedAt: now, UpdatedAt: now}
+	if err := items.Put(ctx, legacy); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := ls.DeleteItem(ctx, u, roomID, l.ListID, legacy.ItemID); err != nil {
+		t.Fatalf("trash: %v", err)
+	}
+
+	env := migrate.Env{Tx: tx, Items: items, ItemScanner: items.(store.ListItemScanner), Users: users, UserScanner: users.(store.UserScanner)}
+	if _, err := migrate.NewRunner(memstore.NewMigrationRepo(memstore.NewStore()), env, migrate.All).Up(ctx, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if it, _ := items.GetByID(ctx, legacy.ItemID); it.Quantity != "" {
+		t.Fatalf("backfill wrote a trashed item: %+v", it)
+	}
+
+	trash, err := ls.GetTrash(ctx, u, roomID)
+	if err != nil || len(trash.Items) != 1 || trash.Items[0].Description != "chicken breast" || trash.Items[0].Quantity != "2" {
+		t.Fatalf("trash: %v %+v", err, trash)
+	}
+	it, err := ls.RestoreItem(ctx, u, roomID, l.ListID, legacy.ItemID)
+	if err != nil || it.IsDeleted || it.Description != "chicken breast" || it.Quantity != "2" || it.Unit != "lbs" {
+		t.Fatalf("restore: %v %+v", err, it)
+	}
+	if stored, _ := items.GetByID(ctx, legacy.ItemID); stored.Description != "chicken breast" || stored.Quantity != "2" {
+		t.Fatalf("split not stored: %+v", stored)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+    "slices"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// Permission is something a room role may allow. Reading a room and its lists
+// only takes membership.
+type Permission string
+
+const (
+    // PermShareRoom rotates the share code others join with.
+    PermShareRoom Permission = "share_room"
+    // PermEditRoom changes the room's display name and description.
+    PermEditRoom Permission = "edit_room"
+    // PermDeleteRoom votes to delete the room. It is deleted once every
+    // member with this permission has voted.
+    PermDeleteRoom Permission = "delete_room"
+    // PermManageRoles changes other members' roles; see RoomService.SetMemberRole.
+    PermManageRoles Permission = "manage_roles"
+    // PermEditLists creates, edits, clears and restores lists.
+    PermEditLists Permission = "edit_lists"
+    // PermDeleteLists votes to delete a list. It is deleted once every member
+    // with this permission has voted.
+    PermDeleteLists Permission = "delete_lists"
+    // PermEditItems adds, edits, moves, deletes and restores items.
+    PermEditItems Permission = "edit_items"
+    // PermCheckItems marks items completed or not.
+    PermCheckItems Permission = "check_items"
+)
+
+// rolePermissions is the permission matrix.
+var rolePermissions = map[models.Role][]Permission{
+    models.RoleOwner:  {PermShareRoom, PermEditRoom, PermDeleteRoom, PermManageRoles, PermEditLists, PermDeleteLists, PermEditItems, PermCheckItems},
+    models.RoleAdmin:  {PermShareRoom, PermEditRoom, PermDeleteRoom, PermManageRoles, PermEditLists, PermDeleteLists, PermEditItems, PermCheckItems},
+    models.RoleMember: {PermDeleteRoom, PermEditLists, PermDeleteLists, PermEditItems, PermCheckItems},
+    models.RoleGuest:  {PermCheckItems},
+}
+
+// Can reports whether role allows p.
+func Can(role models.Role, p Permission) bool { return slices.Contains(rolePermissions[role], p) }
+
+// PermissionsOf returns what role allows.
+func PermissionsOf(role models.Role) []Permission { return slices.Clone(rolePermissions[role]) }
+
+// requirePermission fails with derr.ErrForbidden unless userID is a member of
+// the room whose role allows p.
+func requirePermission(rm *models.Room, userID string, p Permission) error {
+    if !Can(rm.RoleOf(userID), p) { return derr.ErrForbidden }
+    return nil
+}
+
+// membersWith returns the members whose role allows p, in join order.
+func membersWith(rm *models.Room, p Permission) []string {
+    var out []string
+    for _, mid := range rm.MemberIDs {
+        if Can(rm.RoleOf(mid), p) { out = append(out, mid) }
+    }
+    return out
+}
@@ -2,10 +2,14 @@ package services
 
 import (
     "context"
+    "errors"
+    "log"
+    "slices"
     "time"
 
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
     "github.com/janvillarosa/gracie-app/backend/internal/store"
     "github.com/janvillarosa/gracie-app/backend/pkg/ids"
 )
@@ -13,131 +17,394 @@ import (
 type RoomService struct {
     users store.UserRepository
     rooms store.RoomRepository
+    jobs  store.JobRepository
+    tx    store.TxRunner
+    // lists and items are nil until UseLists; leaving a room then settles no
+    // list votes and joining one cannot merge lists.
     lists store.ListRepository
     items store.ListItemRepository
-    tx    store.TxRunner
+    // invites is nil until UseInvites; rooms then only have a share token.
+    invites store.InviteRepository
+    // verifiedJoin blocks joining rooms until the email address is verified.
+    verifiedJoin bool
+    // joinLimit counts failed joins (wrong codes) per user.
+    joinLimit *ratelimit.Limiter
 }
 
 func NewRoomService(users store.UserRepository, rooms store.RoomRepository, tx store.TxRunner) *RoomService {
     return &RoomService{users: users, rooms: rooms, tx: tx}
 }
 
-// UseListRepos injects optional list repositories used for cleanup when a room is deleted.
-func (s *RoomService) UseListRepos(lists store.ListRepository, items store.ListItemRepository) { s.lists, s.items = lists, items }
+// UseJobQueue injects the job queue that cleans up after deleted rooms. Without
+// it a deleted room's lists and items are left in place.
+func (s *RoomService) UseJobQueue(jobs store.JobRepository) { s.jobs = jobs }
+
+// UseInvites turns on room invites: RotateShareToken issues one and the join
+// methods accept their codes and link tokens. Share tokens issued before keep
+// working.
+func (s *RoomService) UseInvites(invites store.InviteRepository) { s.invites = invites }
+
+// UseLists injects the lists and items JoinRoom merges from a solo room and
+// whose deletion votes LeaveRoom settles once the leaver no longer counts.
+func (s *RoomService) UseLists(lists store.ListRepository, items store.ListItemRepository) {
+    s.lists, s.items = lists, items
+}
+
+// RequireVerifiedEmail makes JoinRoom refuse users whose email address is
+// unverified with derr.ErrEmailUnverified.
+func (s *RoomService) RequireVerifiedEmail(required bool) { s.verifiedJoin = required }
 
+// UseJoinLimiter makes JoinRoom and JoinRoomByToken count wrong share codes
+// per user with l and refuse a user over its limit with a *ratelimit.Error,
+// so codes cannot be guessed.
+func (s *RoomService) UseJoinLimiter(l *ratelimit.Limiter) { s.joinLimit = l }
+
+// GetMyRoom returns the user's active room.
 func (s *RoomService) GetMyRoom(ctx context.Context, user *models.User) (*models.Room, error) {
-    if user.RoomID == nil || *user.RoomID == "" { return nil, derr.ErrNotFound }
-    return s.rooms.GetByID(ctx, *user.RoomID)
+    return s.activeRoom(ctx, user)
+}
+
+// ListRooms returns every room the user is a member of, in the order they
+// joined.
+func (s *RoomService) ListRooms(ctx context.Context, user *models.User) ([]models.Room, error) {
+    out := []models.Room{}
+    for _, id := range roomIDsOf(user) {
+        rm, err := s.rooms.GetByID(ctx, id)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return nil, err }
+        if isMember(rm, user.UserID) { out = append(out, *rm) }
+    }
+    return out, nil
 }
 
+// SwitchRoom makes roomID, one of the user's rooms, their active room.
+func (s *RoomService) SwitchRoom(ctx context.Context, user *models.User, roomID string) (*models.Room, error) {
+    rm, err := s.memberRoom(ctx, user, roomID)
+    if err != nil { return nil, err }
+    if err := s.users.SetRoomID(ctx, user.UserID, &rm.RoomID, time.Now().UTC()); err != nil { return nil, err }
+    return rm, nil
+}
+
+// CreateSoloRoom creates a room with the user as its only member and makes it
+// their active room. Their other rooms are kept.
 func (s *RoomService) CreateSoloRoom(ctx context.Context, user *models.User) (*models.Room, error) {
-    if user.RoomID != nil && *user.RoomID != "" { return nil, derr.ErrConflict }
     now := time.Now().UTC()
-    room := &models.Room{
-        RoomID:        ids.NewID("room"),
-        MemberIDs:     []string{user.UserID},
-        DeletionVotes: map[string]string{},
-        DisplayName:   "My Room",
-        Description:   "",
-        CreatedAt:     now,
-        UpdatedAt:     now,
-    }
+    room := newSoloRoom(user.UserID, now)
     if err := s.tx.WithTransaction(ctx, func(txctx context.Context) error {
         if err := s.rooms.Put(txctx, room); err != nil { return err }
+        if err := s.users.AddRoom(txctx, user.UserID, room.RoomID, now); err != nil { return err }
         return s.users.SetRoomID(txctx, user.UserID, &room.RoomID, now)
     }); err != nil { return nil, err }
     return room, nil
 }
 
+// RotateShareToken issues a new share code for the user's active room. It
+// takes PermShareRoom. With invites the code belongs to a new single-use
+// invite, so codes handed out before keep working until used or expired.
 func (s *RoomService) RotateShareToken(ctx context.Context, user *models.User) (string, error) {
-    if user.RoomID == nil || *user.RoomID == "" { return "", derr.ErrNotFound }
+    if s.invites != nil {
+        inv, err := s.CreateInvite(ctx, user, InviteOptions{MaxUses: 1})
+        if err != nil { return "", err }
+        return inv.Code, nil
+    }
+    rm, err := s.activeRoom(ctx, user)
+    if err != nil { return "", err }
+    if err := requirePermission(rm, user.UserID, PermShareRoom); err != nil { return "", err }
     token := ids.NewShareToken5()
-    if err := s.rooms.SetShareToken(ctx, *user.RoomID, user.UserID, token, time.Now().UTC()); err != nil { return "", err }
+    if err := s.rooms.SetShareToken(ctx, rm.RoomID, user.UserID, token, time.Now().UTC()); err != nil { return "", err }
     return token, nil
 }
 
-// JoinRoom joins the authenticated user to the target room using a token.
-func (s *RoomService) JoinRoom(ctx context.Context, joiner *models.User, roomID, token string) (*models.Room, error) {
+// JoinRoom joins the authenticated user to the target room using an invite
+// code, link token or share token and makes it their active room. The rooms
+// they were in already are kept, unless opts merge or discard their solo
+// room. A share token is removed once used; an invite counts the join.
+func (s *RoomService) JoinRoom(ctx context.Context, joiner *models.User, roomID, token string, opts JoinOptions) (*models.Room, error) {
+    if s.verifiedJoin && !EmailVerified(joiner) { return nil, derr.ErrEmailUnverified }
+    if err := s.joinLimit.Check(ctx, joiner.UserID); err != nil { return nil, err }
     now := time.Now().UTC()
     rm, err := s.rooms.GetByID(ctx, roomID)
     if err != nil { return nil, err }
-    if rm.ShareToken == nil || *rm.ShareToken == "" || token == "" || token != *rm.ShareToken { return nil, derr.ErrForbidden }
+    inv, err := s.inviteFor(ctx, rm, token, now)
+    if errors.Is(err, derr.ErrForbidden) { s.joinLimit.Hit(ctx, joiner.UserID) }
+    if err != nil { return nil, err }
     // Disallow joining the same room twice
-    for _, mid := range rm.MemberIDs { if mid == joiner.UserID { return nil, derr.ErrConflict } }
+    if isMember(rm, joiner.UserID) { return nil, derr.ErrConflict }
+    solo, err := s.soloRoomPlan(ctx, joiner, rm, opts)
+    if err != nil { return nil, err }
 
-    var deleteSolo *models.Room
-    if joiner.RoomID != nil && *joiner.RoomID != "" {
-        jr, err := s.rooms.GetByID(ctx, *joiner.RoomID)
-        if err != nil { return nil, err }
-        // If the joiner currently has a solo room (only them), delete it post-join.
-        if len(jr.MemberIDs) == 1 && jr.MemberIDs[0] == joiner.UserID {
-            deleteSolo = jr
-        }
-    }
     if err := s.tx.WithTransaction(ctx, func(txctx context.Context) error {
+        if inv != nil {
+            if err := s.invites.Use(txctx, inv.InviteID); err != nil { return err }
+        }
         if err := s.rooms.AddMember(txctx, rm.RoomID, joiner.UserID, now); err != nil { return err }
-        if err := s.rooms.RemoveShareToken(txctx, rm.RoomID, now); err != nil { return err }
+        if inv == nil {
+            if err := s.rooms.RemoveShareToken(txctx, rm.RoomID, now); err != nil { return err }
+        }
+        if err := s.users.AddRoom(txctx, joiner.UserID, rm.RoomID, now); err != nil && !errors.Is(err, derr.ErrConflict) { return err }
         if err := s.users.SetRoomID(txctx, joiner.UserID, &rm.RoomID, now); err != nil { return err }
-        if deleteSolo != nil { return s.rooms.Delete(txctx, deleteSolo.RoomID) }
-        return nil
+        if solo == nil { return nil }
+        return s.applySoloRoomChange(txctx, solo, now)
     }); err != nil { return nil, err }
+    if solo == nil { return s.rooms.GetByID(ctx, rm.RoomID) }
+    // As in LeaveRoom, the deleted solo room leaves the user's rooms outside
+    // the transaction.
+    if err := s.users.RemoveRoom(ctx, joiner.UserID, solo.roomID, now); err != nil && !errors.Is(err, derr.ErrNotFound) {
+        log.Printf("rooms: dropping room %s from user %s: %v", solo.roomID, joiner.UserID, err)
+    }
+    // Merged lists move outside the transaction; if that fails part way the
+    // queued JobMergeRoom finishes it.
+    if solo.merge != nil {
+        if err := s.mergeLists(ctx, rm.RoomID, solo.moves, now); err != nil {
+            log.Printf("rooms: merging room %s into %s: %v", solo.roomID, rm.RoomID, err)
+        }
+    }
     return s.rooms.GetByID(ctx, rm.RoomID)
 }
 
-func (s *RoomService) JoinRoomByToken(ctx context.Context, joiner *models.User, token string) (*models.Room, error) {
+// JoinRoomByToken joins the room an invite code, link token or share token
+// belongs to, like JoinRoom.
+func (s *RoomService) JoinRoomByToken(ctx context.Context, joiner *models.User, token string, opts JoinOptions) (*models.Room, error) {
     if token == "" { return nil, derr.ErrBadRequest }
+    if err := s.joinLimit.Check(ctx, joiner.UserID); err != nil { return nil, err }
+    if s.invites != nil {
+        inv, err := s.invites.GetByToken(ctx, token)
+        if err == nil { return s.JoinRoom(ctx, joiner, inv.RoomID, token, opts) }
+        if !errors.Is(err, derr.ErrNotFound) { return nil, err }
+    }
     rm, err := s.rooms.GetByShareToken(ctx, token)
+    if errors.Is(err, derr.ErrNotFound) { s.joinLimit.Hit(ctx, joiner.UserID) }
     if err != nil { return nil, err }
-    return s.JoinRoom(ctx, joiner, rm.RoomID, token)
+    return s.JoinRoom(ctx, joiner, rm.RoomID, token, opts)
+}
+
+// LeaveRoom takes the user out of their active room and makes a new solo
+// room, which it returns, their active room. Their deletion votes go with
+// them, so room and list deletions only they had not voted for go ahead. An
+// owner hands the room to newOwnerID, or when that is "" to the first admin,
+// else the first member, else whoever joined earliest; only the owner may
+// name one (derr.ErrForbidden). The last member cannot leave a room, they
+// delete it instead (derr.ErrConflict).
+func (s *RoomService) LeaveRoom(ctx context.Context, user *models.User, newOwnerID string) (*models.Room, error) {
+    rm, err := s.activeRoom(ctx, user)
+    if err != nil { return nil, err }
+    if len(rm.MemberIDs) <= 1 { return nil, derr.ErrConflict }
+    if newOwnerID != "" {
+        if rm.RoleOf(user.UserID) != models.RoleOwner { return nil, derr.ErrForbidden }
+        if newOwnerID == user.UserID || !isMember(rm, newOwnerID) { return nil, derr.ErrNotFound }
+    }
+    now := time.Now().UTC()
+    solo := newSoloRoom(user.UserID, now)
+    if err := s.tx.WithTransaction(ctx, func(txctx context.Context) error {
+        if err := removeMember(txctx, s.rooms, rm, user.UserID, newOwnerID, now); err != nil { return err }
+        if err := s.rooms.Put(txctx, solo); err != nil { return err }
+        if err := s.users.AddRoom(txctx, user.UserID, solo.RoomID, now); err != nil { return err }
+        return s.users.SetRoomID(txctx, user.UserID, &solo.RoomID, now)
+    }); err != nil { return nil, err }
+    // MemberIDs decide membership, so the old room may drop out of the user's
+    // rooms separately; DynamoDB cannot add to and remove from one list in a
+    // single transaction.
+    if err := s.users.RemoveRoom(ctx, user.UserID, rm.RoomID, now); err != nil && !errors.Is(err, derr.ErrNotFound) {
+        log.Printf("rooms: dropping room %s from user %s: %v", rm.RoomID, user.UserID, err)
+    }
+    // The user has left; votes that now pass are settled on a best-effort
+    // basis and the next vote settles any left over.
+    if err := s.settleDeletionVotes(ctx, rm.RoomID, user.UserID, now); err != nil {
+        log.Printf("rooms: settling deletion votes of room %s: %v", rm.RoomID, err)
+    }
+    return solo, nil
+}
+
+// settleDeletionVotes drops leaverID's votes on the room's lists and deletes
+// the lists, then the room, that every remaining member who may vote has
+// voted to delete.
+func (s *RoomService) settleDeletionVotes(ctx context.Context, roomID, leaverID string, now time.Time) error {
+    rm, err := s.rooms.GetByID(ctx, roomID)
+    if err != nil { return err }
+    if s.lists != nil {
+        lists, _, err := s.lists.ListByRoom(ctx, roomID, store.Page{})
+        if err != nil { return err }
+        voters := membersWith(rm, PermDeleteLists)
+        for _, l := range lists {
+            if len(l.DeletionVotes) == 0 { continue }
+            if l.DeletionVotes[leaverID] != "" {
+                if err := s.lists.RemoveDeletionVote(ctx, l.ListID, leaverID); err != nil { return err }
+            }
+            if _, err := s.lists.FinalizeDeleteIfVotedByAll(ctx, l.ListID, voters, now); err != nil { return err }
+        }
+    }
+    if len(rm.DeletionVotes) == 0 { return nil }
+    _, err = s.deleteIfVotedByAll(ctx, rm, now)
+    return err
 }
 
+// VoteDeletion votes to delete the user's active room, which takes
+// PermDeleteRoom. The room is deleted once every member with that permission
+// has voted; it reports whether it was.
 func (s *RoomService) VoteDeletion(ctx context.Context, voter *models.User) (bool, error) {
-    if voter.RoomID == nil || *voter.RoomID == "" { return false, derr.ErrNotFound }
+    rm, err := s.activeRoom(ctx, voter)
+    if err != nil { return false, err }
+    if err := requirePermission(rm, voter.UserID, PermDeleteRoom); err != nil { return false, err }
     now := time.Now().UTC()
-    if err := s.rooms.VoteDeletion(ctx, *voter.RoomID, voter.UserID, now); err != nil { return false, err }
-    rm, err := s.rooms.GetByID(ctx, *voter.RoomID)
+    if err := s.rooms.VoteDeletion(ctx, rm.RoomID, voter.UserID, now); err != nil { return false, err }
+    rm, err = s.rooms.GetByID(ctx, rm.RoomID)
     if err != nil { return false, err }
-    // Delete when ALL current members have voted (works for solo rooms too)
+    return s.deleteIfVotedByAll(ctx, rm, now)
+}
+
+// deleteIfVotedByAll deletes rm once every member with PermDeleteRoom has
+// voted to, and reports whether it did.
+func (s *RoomService) deleteIfVotedByAll(ctx context.Context, rm *models.Room, now time.Time) (bool, error) {
+    // Delete when all current members who may vote have (works for solo rooms too)
     allVoted := true
-    for _, mid := range rm.MemberIDs {
+    for _, mid := range membersWith(rm, PermDeleteRoom) {
         if rm.DeletionVotes[mid] == "" {
             allVoted = false
             break
         }
     }
     if !allVoted { return false, nil }
+    members := make([]*models.User, 0, len(rm.MemberIDs))
+    for _, mid := range rm.MemberIDs {
+        m, err := s.users.GetByID(ctx, mid)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return false, err }
+        members = append(members, m)
+    }
     if err := s.tx.WithTransaction(ctx, func(txctx context.Context) error {
         if err := s.rooms.Delete(txctx, rm.RoomID); err != nil { return err }
-        for _, mid := range rm.MemberIDs {
-            if err := s.users.SetRoomID(txctx, mid, nil, now); err != nil { return err }
+        for _, m := range members {
+            if err := detachFromRoom(txctx, s.users, m, rm.RoomID, now); err != nil { return err }
         }
-        return nil
+        return enqueueRoomCleanup(txctx, s.jobs, rm.RoomID, now)
     }); err != nil { return false, err }
-    go s.cleanupRoomResources(context.Background(), rm.RoomID)
     return true, nil
 }
 
 func (s *RoomService) CancelDeletionVote(ctx context.Context, user *models.User) error {
-    if user.RoomID == nil || *user.RoomID == "" { return derr.ErrNotFound }
-    return s.rooms.RemoveDeletionVote(ctx, *user.RoomID, user.UserID)
+    rm, err := s.activeRoom(ctx, user)
+    if err != nil { return err }
+    if err := requirePermission(rm, user.UserID, PermDeleteRoom); err != nil { return err }
+    return s.rooms.RemoveDeletionVote(ctx, rm.RoomID, user.UserID)
 }
 
-// UpdateRoomSettings updates display name and/or description.
-func (s *RoomService) UpdateRoomSettings(ctx context.Context, user *models.User, displayName *string, description *string) error {
-    if user.RoomID == nil || *user.RoomID == "" { return derr.ErrNotFound }
-    now := time.Now().UTC()
-    if displayName != nil {
-        if err := s.rooms.UpdateDisplayName(ctx, *user.RoomID, user.UserID, *displayName, now); err != nil { return err }
+// UpdateRoomSettings updates display name and/or description in a single
+// write and returns the updated room. Unless ifVersion is store.AnyVersion the
+// room must still be at that version, or the update fails with
+// derr.ErrPreconditionFailed.
+func (s *RoomService) UpdateRoomSettings(ctx context.Context, user *models.User, displayName *string, description *string, ifVersion int64) (*models.Room, error) {
+    rm, err := s.activeRoom(ctx, user)
+    if err != nil { return nil, err }
+    if err := requirePermission(rm, user.UserID, PermEditRoom); err != nil { return nil, err }
+    patch := store.RoomPatch{DisplayName: displayName, Description: description}
+    return s.rooms.Patch(ctx, rm.RoomID, user.UserID, patch, ifVersion, time.Now().UTC())
+}
+
+// SetMemberRole gives memberID the role in the actor's active room and
+// returns the updated room. It takes PermManageRoles, and further:
+//   - nobody changes their own role;
+//   - admins only move others between RoleMember and RoleGuest;
+//   - only the owner makes someone else owner, becoming an admin themselves.
+//
+// It fails with derr.ErrBadRequest for an unknown role or the actor's own ID,
+// derr.ErrNotFound if memberID is not a member and derr.ErrForbidden if the
+// actor may not make the change.
+func (s *RoomService) SetMemberRole(ctx context.Context, actor *models.User, memberID string, role models.Role) (*models.Room, error) {
+    if !role.Valid() || memberID == actor.UserID { return nil, derr.ErrBadRequest }
+    rm, err := s.activeRoom(ctx, actor)
+    if err != nil { return nil, err }
+    if err := requirePermission(rm, actor.UserID, PermManageRoles); err != nil { return nil, err }
+    current := rm.RoleOf(memberID)
+    if current == "" { return nil, derr.ErrNotFound }
+    roles := map[string]models.Role{memberID: role}
+    switch rm.RoleOf(actor.UserID) {
+    case models.RoleOwner:
+        if role == models.RoleOwner { roles[actor.UserID] = models.RoleAdmin }
+    default:
+        lesser := func(r models.Role) bool { return r == models.RoleMember || r == models.RoleGuest }
+        if !lesser(current) || !lesser(role) { return nil, derr.ErrForbidden }
     }
-    if description != nil {
-        if err := s.rooms.UpdateDescription(ctx, *user.RoomID, user.UserID, *description, now); err != nil { return err }
+    if current == role { return rm, nil }
+    if err := s.rooms.SetRoles(ctx, rm.RoomID, roles, time.Now().UTC()); err != nil { return nil, err }
+    return s.rooms.GetByID(ctx, rm.RoomID)
+}
+
+// newSoloRoom returns a room with userID as its owner and only member.
+func newSoloRoom(userID string, now time.Time) *models.Room {
+    return &models.Room{
+        RoomID:        ids.NewID("room"),
+        MemberIDs:     []string{userID},
+        Roles:         map[string]models.Role{userID: models.RoleOwner},
+        DeletionVotes: map[string]string{},
+        DisplayName:   "My Room",
+        Description:   "",
+        Version:       1,
+        CreatedAt:     now,
+        UpdatedAt:     now,
+    }
+}
+
+// removeMember takes leaverID out of rm with their deletion vote. An owner
+// hands the room to newOwnerID, or when that is "" to successorOf's pick.
+func removeMember(ctx context.Context, rooms store.RoomRepository, rm *models.Room, leaverID, newOwnerID string, now time.Time) error {
+    if err := rooms.RemoveMember(ctx, rm.RoomID, leaverID, now); err != nil { return err }
+    if rm.RoleOf(leaverID) == models.RoleOwner {
+        if newOwnerID == "" { newOwnerID = successorOf(rm, leaverID) }
+        if err := rooms.SetRoles(ctx, rm.RoomID, map[string]models.Role{newOwnerID: models.RoleOwner}, now); err != nil { return err }
+    }
+    return rooms.RemoveDeletionVote(ctx, rm.RoomID, leaverID)
+}
+
+// successorOf picks who owns rm once leaverID has left: the first admin, else
+// the first member, else the earliest remaining member.
+func successorOf(rm *models.Room, leaverID string) string {
+    for _, role := range []models.Role{models.RoleAdmin, models.RoleMember} {
+        for _, mid := range rm.MemberIDs {
+            if mid != leaverID && rm.RoleOf(mid) == role { return mid }
+        }
+    }
+    for _, mid := range rm.MemberIDs {
+        if mid != leaverID { return mid }
     }
-    return nil
+    return ""
 }
 
-func (s *RoomService) cleanupRoomResources(ctx context.Context, roomID string) {
-    if s.lists == nil { return }
-    lists, err := s.lists.ListByRoom(ctx, roomID)
-    if err != nil { return }
-    for _, l := range lists { _ = s.lists.Delete(ctx, l.ListID) }
+// activeRoom returns the user's active room, derr.ErrNotFound if they have
+// none and derr.ErrForbidden if they are no longer a member of it.
+func (s *RoomService) activeRoom(ctx context.Context, user *models.User) (*models.Room, error) {
+    if user.RoomID == nil || *user.RoomID == "" { return nil, derr.ErrNotFound }
+    return s.memberRoom(ctx, user, *user.RoomID)
+}
+
+// memberRoom returns roomID if the user is one of its members and
+// derr.ErrForbidden otherwise.
+func (s *RoomService) memberRoom(ctx context.Context, user *models.User, roomID string) (*models.Room, error) {
+    rm, err := s.rooms.GetByID(ctx, roomID)
+    if err != nil { return nil, err }
+    if !isMember(rm, user.UserID) { return nil, derr.ErrForbidden }
+    return rm, nil
+}
+
+// isMember reports whether userID is one of the room's members. MemberIDs,
+// not the user's RoomIDs, decide who may act in a room.
+func isMember(rm *models.Room, userID string) bool { return slices.Contains(rm.MemberIDs, userID) }
+
+// roomIDsOf returns the rooms the user belongs to. Users stored before
+// RoomIDs existed only have their active room.
+func roomIDsOf(u *models.User) []string {
+    if u.RoomID == nil || *u.RoomID == "" || slices.Contains(u.RoomIDs, *u.RoomID) { return u.RoomIDs }
+    return append(append([]string{}, u.RoomIDs...), *u.RoomID)
+}
+
+// detachFromRoom takes roomID out of u's rooms after u has left it or it was
+// deleted. If it was u's active room, the room u joined most recently of
+// those left becomes active, or none.
+func detachFromRoom(ctx context.Context, users store.UserRepository, u *models.User, roomID string, now time.Time) error {
+    if err := users.RemoveRoom(ctx, u.UserID, roomID, now); err != nil && !errors.Is(err, derr.ErrNotFound) { return err }
+    if u.RoomID == nil || *u.RoomID != roomID { return nil }
+    var next *string
+    for _, id := range roomIDsOf(u) {
+        if id != roomID { next = &id }
+    }
+    return users.SetRoomID(ctx, u.UserID, next, now)
 }
@@ -2,28 +2,476 @@ package services
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "slices"
     "testing"
+    "time"
 
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/ratelimit"
+    "github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
     "github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
 )
 
 func TestRoomJoinByTokenAndCancelVote(t *testing.T) {
     tx, users, rooms, _, _ := memstore.Compose()
-    us := NewUserService(users, rooms, tx)
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
     rs := NewRoomService(users, rooms, tx)
 
     ctx := context.Background()
-    a, _ := us.CreateUserWithSoloRoom(ctx, "A")
-    b, _ := us.CreateUserWithSoloRoom(ctx, "B")
+    a, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+    b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
 
     // Set share token
     tok, err := rs.RotateShareToken(ctx, a.User)
     if err != nil || tok == "" { t.Fatalf("rotate: %v %q", err, tok) }
 
     // Join by token (no room_id exposed)
-    if _, err := rs.JoinRoomByToken(ctx, b.User, tok); err != nil { t.Fatalf("join by token: %v", err) }
+    if _, err := rs.JoinRoomByToken(ctx, b.User, tok, JoinOptions{}); err != nil { t.Fatalf("join by token: %v", err) }
 
     // Cancel deletion vote (no votes yet -> no error)
     if err := rs.CancelDeletionVote(ctx, a.User); err != nil { t.Fatalf("cancel vote: %v", err) }
 }
 
+
+func TestRoomJoinRequiresVerifiedEmail(t *testing.T) {
+    tx, users, rooms, _, _ := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    rs.RequireVerifiedEmail(true)
+
+    ctx := context.Background()
+    a, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+    b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
+    tok, err := rs.RotateShareToken(ctx, a.User)
+    if err != nil { t.Fatalf("rotate: %v", err) }
+
+    if err := users.UpdateUsername(ctx, b.User.UserID, "b@example.com", time.Now().UTC()); err != nil { t.Fatalf("username: %v", err) }
+    joiner, _ := users.GetByID(ctx, b.User.UserID)
+    if _, err := rs.JoinRoomByToken(ctx, joiner, tok, JoinOptions{}); err != derr.ErrEmailUnverified { t.Fatalf("unverified join: want ErrEmailUnverified, got %v", err) }
+    if err := users.MarkEmailVerified(ctx, joiner.UserID, "b@example.com", time.Now().UTC()); err != nil { t.Fatalf("verify: %v", err) }
+    joiner, _ = users.GetByID(ctx, b.User.UserID)
+    if _, err := rs.JoinRoomByToken(ctx, joiner, tok, JoinOptions{}); err != nil { t.Fatalf("verified join: %v", err) }
+}
+
+func TestRoomJoinLimitsWrongCodes(t *testing.T) {
+    tx, users, rooms, _, _ := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    rs.UseJoinLimiter(ratelimit.New(ratelimit.NewMemoryStore(), "join_user", ratelimit.Rule{Limit: 2, Window: time.Hour}))
+
+    ctx := context.Background()
+    a, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+    b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
+    c, _ := us.CreateUserWithSoloRoom(ctx, "C", "")
+    tok, err := rs.RotateShareToken(ctx, a.User)
+    if err != nil { t.Fatalf("rotate: %v", err) }
+
+    if _, err := rs.JoinRoomByToken(ctx, b.User, "ZZZZZ", JoinOptions{}); err != derr.ErrNotFound { t.Fatalf("unknown code: %v", err) }
+    if _, err := rs.JoinRoom(ctx, b.User, *a.User.RoomID, "ZZZZZ", JoinOptions{}); err != derr.ErrForbidden { t.Fatalf("wrong code: %v", err) }
+    // Over the limit, even the right code is refused.
+    if _, err := rs.JoinRoomByToken(ctx, b.User, tok, JoinOptions{}); !errors.Is(err, derr.ErrRateLimited) { t.Fatalf("limited join: %v", err) }
+    // Other users are not affected.
+    if _, err := rs.JoinRoomByToken(ctx, c.User, tok, JoinOptions{}); err != nil { t.Fatalf("other user: %v", err) }
+}
+
+func TestMultipleRooms(t *testing.T) {
+    tx, users, rooms, lists, items := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+
+    ctx := context.Background()
+    a, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+    b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
+    c, _ := us.CreateUserWithSoloRoom(ctx, "C", "")
+    home, family := *a.User.RoomID, *b.User.RoomID
+
+    // A joins B's room and keeps their own; the joined room becomes active.
+    tok, _ := rs.RotateShareToken(ctx, b.User)
+    if _, err := rs.JoinRoomByToken(ctx, a.User, tok, JoinOptions{}); err != nil { t.Fatalf("join: %v", err) }
+    me, _ := users.GetByID(ctx, a.User.UserID)
+    mine, err := rs.ListRooms(ctx, me)
+    if err != nil || len(mine) != 2 || mine[0].RoomID != home || mine[1].RoomID != family { t.Fatalf("rooms after join: %+v %v", mine, err) }
+    if rm, err := rs.GetMyRoom(ctx, me); err != nil || rm.RoomID != family { t.Fatalf("active after join: %v %v", rm, err) }
+
+    // Lists of every room are reachable, whichever is active.
+    if _, err := ls.CreateList(ctx, me, home, "Groceries", "", ""); err != nil { t.Fatalf("list in inactive room: %v", err) }
+    if _, err := ls.CreateList(ctx, me, family, "Chores", "", ""); err != nil { t.Fatalf("list in active room: %v", err) }
+    if _, err := ls.CreateList(ctx, me, *c.User.RoomID, "Nope", "", ""); err != derr.ErrForbidden { t.Fatalf("list in other room: %v", err) }
+
+    if _, err := rs.SwitchRoom(ctx, me, *c.User.RoomID); err != derr.ErrForbidden { t.Fatalf("switch to other room: %v", err) }
+    if _, err := rs.SwitchRoom(ctx, me, home); err != nil { t.Fatalf("switch: %v", err) }
+    me, _ = users.GetByID(ctx, a.User.UserID)
+    if rm, err := rs.GetMyRoom(ctx, me); err != nil || rm.RoomID != home { t.Fatalf("active after switch: %v %v", rm, err) }
+
+    // Deleting the active room makes the other one active.
+    if deleted, err := rs.VoteDeletion(ctx, me); err != nil || !deleted { t.Fatalf("delete home: %v %v", deleted, err) }
+    me, _ = users.GetByID(ctx, a.User.UserID)
+    if me.RoomID == nil || *me.RoomID != family || len(me.RoomIDs) != 1 { t.Fatalf("after deleting home: %+v", me) }
+
+    // Deleting the account leaves the shared room to its other members.
+    if err := us.DeleteAccount(ctx, a.User.UserID); err != nil { t.Fatalf("delete account: %v", err) }
+    rm, err := rooms.GetByID(ctx, family)
+    if err != nil || len(rm.MemberIDs) != 1 || rm.MemberIDs[0] != b.User.UserID { t.Fatalf("family after delete: %+v %v", rm, err) }
+}
+
+func TestRoomRoles(t *testing.T) {
+    tx, users, rooms, lists, items := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+
+    ctx := context.Background()
+    owner, _ := us.CreateUserWithSoloRoom(ctx, "Owner", "")
+    roomID := *owner.User.RoomID
+    join := func(name string) *models.User {
+        u, _ := us.CreateUserWithSoloRoom(ctx, name, "")
+        tok, err := rs.RotateShareToken(ctx, owner.User)
+        if err != nil { t.Fatalf("rotate: %v", err) }
+        if _, err := rs.JoinRoomByToken(ctx, u.User, tok, JoinOptions{}); err != nil { t.Fatalf("join %s: %v", name, err) }
+        me, _ := users.GetByID(ctx, u.User.UserID)
+        return me
+    }
+    admin, member, guest := join("Admin"), join("Member"), join("Guest")
+
+    rm, _ := rooms.GetByID(ctx, roomID)
+    if rm.RoleOf(owner.User.UserID) != models.RoleOwner || rm.RoleOf(member.UserID) != models.RoleMember { t.Fatalf("initial roles: %v", rm.Roles) }
+
+    // Members may not manage roles; admins only move members and guests.
+    if _, err := rs.SetMemberRole(ctx, member, guest.UserID, models.RoleGuest); err != derr.ErrForbidden { t.Fatalf("member sets role: %v", err) }
+    if _, err := rs.SetMemberRole(ctx, owner.User, admin.UserID, models.RoleAdmin); err != nil { t.Fatalf("owner makes admin: %v", err) }
+    if _, err := rs.SetMemberRole(ctx, admin, guest.UserID, models.RoleGuest); err != nil { t.Fatalf("admin makes guest: %v", err) }
+    if _, err := rs.SetMemberRole(ctx, admin, member.UserID, models.RoleAdmin); err != derr.ErrForbidden { t.Fatalf("admin makes admin: %v", err) }
+    if _, err := rs.SetMemberRole(ctx, admin, owner.User.UserID, models.RoleMember); err != derr.ErrForbidden { t.Fatalf("admin demotes owner: %v", err) }
+    if _, err := rs.SetMemberRole(ctx, admin, admin.UserID, models.RoleMember); err != derr.ErrBadRequest { t.Fatalf("own role: %v", err) }
+    if _, err := rs.SetMemberRole(ctx, owner.User, member.UserID, "king"); err != derr.ErrBadRequest { t.Fatalf("unknown role: %v", err) }
+
+    // Guests read and check items off; nothing else.
+    l, err := ls.CreateList(ctx, member, roomID, "Groceries", "", "")
+    if err != nil { t.Fatalf("member creates list: %v", err) }
+    it, err := ls.CreateItem(ctx, member, roomID, l.ListID, "Milk", "", "", "")
+    if err != nil { t.Fatalf("member creates item: %v", err) }
+    if _, _, err := ls.ListItems(ctx, guest, roomID, l.ListID, true, store.Page{}); err != nil { t.Fatalf("guest reads: %v", err) }
+    done := true
+    if _, err := ls.UpdateItem(ctx, guest, roomID, l.ListID, it.ItemID, nil, &done, nil, nil, nil, nil, store.AnyVersion); err != nil { t.Fatalf("guest checks: %v", err) }
+    name := "Oat milk"
+    if _, err := ls.UpdateItem(ctx, guest, roomID, l.ListID, it.ItemID, &name, nil, nil, nil, nil, nil, store.AnyVersion); err != derr.ErrForbidden { t.Fatalf("guest edits: %v", err) }
+    if _, err := ls.CreateList(ctx, guest, roomID, "Mine", "", ""); err != derr.ErrForbidden { t.Fatalf("guest creates list: %v", err) }
+    if _, err := ls.VoteListDeletion(ctx, guest, roomID, l.ListID); err != derr.ErrForbidden { t.Fatalf("guest deletes list: %v", err) }
+    if _, err := rs.RotateShareToken(ctx, member); err != derr.ErrForbidden { t.Fatalf("member shares: %v", err) }
+    dn := "Ours"
+    if _, err := rs.UpdateRoomSettings(ctx, member, &dn, nil, store.AnyVersion); err != derr.ErrForbidden { t.Fatalf("member renames: %v", err) }
+    if _, err := rs.UpdateRoomSettings(ctx, admin, &dn, nil, store.AnyVersion); err != nil { t.Fatalf("admin renames: %v", err) }
+
+    // A list is deleted once everyone who may vote on it has; guests don't.
+    for _, u := range []*models.User{owner.User, admin} {
+        if deleted, err := ls.VoteListDeletion(ctx, u, roomID, l.ListID); err != nil || deleted { t.Fatalf("early list delete: %v %v", deleted, err) }
+    }
+    if deleted, err := ls.VoteListDeletion(ctx, member, roomID, l.ListID); err != nil || !deleted { t.Fatalf("list delete: %v %v", deleted, err) }
+
+    // Handing over ownership makes the old owner an admin.
+    if _, err := rs.SetMemberRole(ctx, admin, member.UserID, models.RoleOwner); err != derr.ErrForbidden { t.Fatalf("admin transfers: %v", err) }
+    rm, err = rs.SetMemberRole(ctx, owner.User, member.UserID, models.RoleOwner)
+    if err != nil || rm.RoleOf(member.UserID) != models.RoleOwner || rm.RoleOf(owner.User.UserID) != models.RoleAdmin { t.Fatalf("transfer: %+v %v", rm, err) }
+
+    // The room goes once every member but guests has voted.
+    if _, err := rs.VoteDeletion(ctx, guest); err != derr.ErrForbidden { t.Fatalf("guest votes: %v", err) }
+    for _, u := range []*models.User{owner.User, admin} {
+        if deleted, err := rs.VoteDeletion(ctx, u); err != nil || deleted { t.Fatalf("early delete: %v %v", deleted, err) }
+    }
+    if deleted, err := rs.VoteDeletion(ctx, member); err != nil || !deleted { t.Fatalf("delete: %v %v", deleted, err) }
+}
+
+func TestRoomDeletionNeedsMembers(t *testing.T) {
+    tx, users, rooms, _, _ := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+
+    ctx := context.Background()
+    owner, _ := us.CreateUserWithSoloRoom(ctx, "Owner", "")
+    b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
+    tok, err := rs.RotateShareToken(ctx, owner.User)
+    if err != nil { t.Fatalf("rotate: %v", err) }
+    if _, err := rs.JoinRoomByToken(ctx, b.User, tok, JoinOptions{}); err != nil { t.Fatalf("join: %v", err) }
+
+    // The owner alone cannot delete a room plain members are in.
+    if deleted, err := rs.VoteDeletion(ctx, owner.User); err != nil || deleted { t.Fatalf("owner alone deleted: %v %v", deleted, err) }
+    if _, err := rooms.GetByID(ctx, *owner.User.RoomID); err != nil { t.Fatalf("room gone: %v", err) }
+    member, _ := users.GetByID(ctx, b.User.UserID)
+    if deleted, err := rs.VoteDeletion(ctx, member); err != nil || !deleted { t.Fatalf("member vote: %v %v", deleted, err) }
+}
+
+func TestRoomInvites(t *testing.T) {
+    tx, users, rooms, _, _ := memstore.Compose()
+    invites := memstore.NewInviteRepo(memstore.NewStore())
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    rs.UseInvites(invites)
+
+    ctx := context.Background()
+    owner, _ := us.CreateUserWithSoloRoom(ctx, "Owner", "")
+    roomID := *owner.User.RoomID
+    newUser := func(name string) *models.User {
+        u, _ := us.CreateUserWithSoloRoom(ctx, name, "")
+        return u.User
+    }
+
+    // One invite lets two people in, by code or by link.
+    inv, err := rs.CreateInvite(ctx, owner.User, InviteOptions{Name: "Roommates", MaxUses: 2, Link: true})
+    if err != nil || len(inv.Code) != 5 || len(inv.LinkToken) < 32 || inv.ExpiresAt.Sub(inv.CreatedAt) != DefaultInviteTTL { t.Fatalf("create: %+v %v", inv, err) }
+    a := newUser("A")
+    if _, err := rs.JoinRoomByToken(ctx, a, inv.Code, JoinOptions{}); err != nil { t.Fatalf("join by code: %v", err) }
+    if _, err := rs.JoinRoom(ctx, newUser("B"), roomID, inv.LinkToken, JoinOptions{}); err != nil { t.Fatalf("join by link: %v", err) }
+    if _, err := rs.JoinRoomByToken(ctx, newUser("C"), inv.Code, JoinOptions{}); err != derr.ErrForbidden { t.Fatalf("used up: %v", err) }
+    got, _ := invites.GetByID(ctx, inv.InviteID)
+    if got.Uses != 2 { t.Fatalf("uses: %d", got.Uses) }
+
+    // Old codes keep working after a new one is issued.
+    code1, err := rs.RotateShareToken(ctx, owner.User)
+    if err != nil { t.Fatalf("rotate: %v", err) }
+    code2, _ := rs.RotateShareToken(ctx, owner.User)
+    if _, err := rs.JoinRoomByToken(ctx, newUser("D"), code1, JoinOptions{}); err != nil { t.Fatalf("join first code: %v", err) }
+    if _, err := rs.JoinRoomByToken(ctx, newUser("E"), code2, JoinOptions{}); err != nil { t.Fatalf("join second code: %v", err) }
+
+    // Only usable invites are listed; revoked ones stop working.
+    open, _ := rs.CreateInvite(ctx, owner.User, InviteOptions{})
+    list, err := rs.ListInvites(ctx, owner.User)
+    if err != nil || len(list) != 1 || list[0].InviteID != open.InviteID || list[0].MaxUses != 0 { t.Fatalf("list: %+v %v", list, err) }
+    if err := rs.RevokeInvite(ctx, owner.User, open.InviteID); err != nil { t.Fatalf("revoke: %v", err) }
+    if _, err := rs.JoinRoomByToken(ctx, newUser("F"), open.Code, JoinOptions{}); err != derr.ErrForbidden { t.Fatalf("revoked: %v", err) }
+    if list, _ := rs.ListInvites(ctx, owner.User); len(list) != 0 { t.Fatalf("list after revoke: %+v", list) }
+
+    // Expired invites are refused.
+    old := &models.Invite{InviteID: "inv_old", RoomID: roomID, Code: "OLD00", CreatedBy: owner.User.UserID, CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}
+    if err := invites.Put(ctx, old); err != nil { t.Fatal(err) }
+    if _, err := rs.JoinRoomByToken(ctx, newUser("G"), "OLD00", JoinOptions{}); err != derr.ErrForbidden { t.Fatalf("expired: %v", err) }
+
+    // Codes only join their own room, and only sharers manage invites.
+    other := newUser("H")
+    otherInv, _ := rs.CreateInvite(ctx, other, InviteOptions{})
+    if _, err := rs.JoinRoom(ctx, newUser("I"), roomID, otherInv.Code, JoinOptions{}); err != derr.ErrForbidden { t.Fatalf("other room's code: %v", err) }
+    if err := rs.RevokeInvite(ctx, owner.User, otherInv.InviteID); err != derr.ErrNotFound { t.Fatalf("revoke other room's invite: %v", err) }
+    member, _ := users.GetByID(ctx, a.UserID)
+    if _, err := rs.CreateInvite(ctx, member, InviteOptions{}); err != derr.ErrForbidden { t.Fatalf("member invites: %v", err) }
+    if _, err := rs.ListInvites(ctx, member); err != derr.ErrForbidden { t.Fatalf("member lists invites: %v", err) }
+    if _, err := rs.CreateInvite(ctx, owner.User, InviteOptions{TTL: MaxInviteTTL + time.Hour}); err != derr.ErrBadRequest { t.Fatalf("long ttl: %v", err) }
+    if _, err := rs.CreateInvite(ctx, owner.User, InviteOptions{MaxUses: -1}); err != derr.ErrBadRequest { t.Fatalf("negative uses: %v", err) }
+}
+
+func TestLeaveRoom(t *testing.T) {
+    tx, users, rooms, lists, items := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    rs.UseLists(lists, items)
+    ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+
+    ctx := context.Background()
+    owner, _ := us.CreateUserWithSoloRoom(ctx, "Owner", "")
+    roomID := *owner.User.RoomID
+    join := func(name string) *models.User {
+        u, _ := us.CreateUserWithSoloRoom(ctx, name, "")
+        tok, err := rs.RotateShareToken(ctx, owner.User)
+        if err != nil { t.Fatalf("rotate: %v", err) }
+        if _, err := rs.JoinRoomByToken(ctx, u.User, tok, JoinOptions{}); err != nil { t.Fatalf("join %s: %v", name, err) }
+        me, _ := users.GetByID(ctx, u.User.UserID)
+        return me
+    }
+    admin, member, other := join("Admin"), join("Member"), join("Other")
+    adminHome := admin.RoomIDs[0]
+    if _, err := rs.SetMemberRole(ctx, owner.User, admin.UserID, models.RoleAdmin); err != nil { t.Fatalf("set role: %v", err) }
+
+    // Everyone but the member has voted to delete the list.
+    l, err := ls.CreateList(ctx, owner.User, roomID, "Groceries", "", "")
+    if err != nil { t.Fatalf("create list: %v", err) }
+    for _, u := range []*models.User{owner.User, admin, other} {
+        if _, err := ls.VoteListDeletion(ctx, u, roomID, l.ListID); err != nil { t.Fatalf("list vote: %v", err) }
+    }
+
+    // Only the owner names a new owner.
+    if _, err := rs.LeaveRoom(ctx, member, owner.User.UserID); err != derr.ErrForbidden { t.Fatalf("member naming owner: %v", err) }
+    solo, err := rs.LeaveRoom(ctx, member, "")
+    if err != nil { t.Fatalf("leave: %v", err) }
+    if len(solo.MemberIDs) != 1 || solo.RoleOf(member.UserID) != models.RoleOwner { t.Fatalf("solo room: %+v", solo) }
+    me, _ := users.GetByID(ctx, member.UserID)
+    if me.RoomID == nil || *me.RoomID != solo.RoomID || slices.Contains(me.RoomIDs, roomID) { t.Fatalf("member after leaving: %+v", me) }
+    rm, _ := rooms.GetByID(ctx, roomID)
+    if isMember(rm, member.UserID) { t.Fatalf("member still in room: %v", rm.MemberIDs) }
+    // The member's vote was the one missing.
+    if got, _ := lists.GetByID(ctx, l.ListID); !got.IsDeleted { t.Fatalf("list not deleted after the last holdout left") }
+    // The last member deletes a room instead of leaving it.
+    if _, err := rs.LeaveRoom(ctx, me, ""); err != derr.ErrConflict { t.Fatalf("leaving solo room: %v", err) }
+
+    // The owner hands the room to someone they name; the admin's deletion
+    // vote does not carry it yet, as the new owner votes too.
+    if _, err := rs.VoteDeletion(ctx, admin); err != nil { t.Fatalf("admin vote: %v", err) }
+    if _, err := rs.LeaveRoom(ctx, owner.User, member.UserID); err != derr.ErrNotFound { t.Fatalf("naming a non-member: %v", err) }
+    if _, err := rs.LeaveRoom(ctx, owner.User, other.UserID); err != nil { t.Fatalf("owner leave: %v", err) }
+    rm, err = rooms.GetByID(ctx, roomID)
+    if err != nil || rm.RoleOf(other.UserID) != models.RoleOwner || rm.RoleOf(admin.UserID) != models.RoleAdmin { t.Fatalf("roles after owner left: %+v %v", rm, err) }
+
+    // Without a name the admin takes over, and their vote now deletes the room.
+    if _, err := rs.LeaveRoom(ctx, other, ""); err != nil { t.Fatalf("new owner leave: %v", err) }
+    if _, err := rooms.GetByID(ctx, roomID); err != derr.ErrNotFound { t.Fatalf("room after last holdout left: %v", err) }
+    me, _ = users.GetByID(ctx, admin.UserID)
+    if me.RoomID == nil || *me.RoomID != adminHome { t.Fatalf("admin after room deletion: %+v", me) }
+}
+
+func TestSuccessorOf(t *testing.T) {
+    rm := &models.Room{MemberIDs: []string{"o", "g", "m", "a"}, Roles: map[string]models.Role{"o": models.RoleOwner, "g": models.RoleGuest, "a": models.RoleAdmin}}
+    if got := successorOf(rm, "o"); got != "a" { t.Fatalf("with an admin: %q", got) }
+    rm.Roles["a"] = models.RoleGuest
+    if got := successorOf(rm, "o"); got != "m" { t.Fatalf("with a member: %q", got) }
+    rm.Roles["m"] = models.RoleGuest
+    if got := successorOf(rm, "o"); got != "g" { t.Fatalf("guests only: %q", got) }
+}
+
+func TestJoinMergesSoloRoom(t *testing.T) {
+    tx, users, rooms, lists, items := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    rs.UseLists(lists, items)
+    ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+
+    ctx := context.Background()
+    a, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+    b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
+    house, solo := *a.User.RoomID, *b.User.RoomID
+    addItems := func(u *models.User, roomID, listID string, descs ...string) []string {
+        var out []string
+        for _, d := range descs {
+            it, err := ls.CreateItem(ctx, u, roomID, listID, d, "", "", "")
+            if err != nil { t.Fatalf("create item: %v", err) }
+            out = append(out, it.ItemID)
+        }
+        return out
+    }
+
+    // B's groceries predate A's, so merged they must be moved after A's.
+    mine, _ := ls.CreateList(ctx, b.User, solo, " groceries", "", "")
+    chores, _ := ls.CreateList(ctx, b.User, solo, "Chores", "", "")
+    moved := addItems(b.User, solo, mine.ListID, "milk", "eggs")
+    choreIDs := addItems(b.User, solo, chores.ListID, "dishes", "laundry")
+    if err := ls.DeleteItem(ctx, b.User, solo, chores.ListID, choreIDs[1]); err != nil { t.Fatalf("trash item: %v", err) }
+    groceries, _ := ls.CreateList(ctx, a.User, house, "Groceries", "", "")
+    kept := addItems(a.User, house, groceries.ListID, "bread")
+
+    tok, _ := rs.RotateShareToken(ctx, a.User)
+    if _, err := rs.JoinRoomByToken(ctx, b.User, tok, JoinOptions{SoloRoom: "keep"}); err != derr.ErrBadRequest { t.Fatalf("unknown action: %v", err) }
+    if _, err := rs.JoinRoomByToken(ctx, b.User, tok, JoinOptions{DedupeLists: true}); err != derr.ErrBadRequest { t.Fatalf("dedupe without merge: %v", err) }
+    if _, err := rs.JoinRoomByToken(ctx, b.User, tok, JoinOptions{SoloRoom: MergeSoloRoom, DedupeLists: true}); err != nil { t.Fatalf("join: %v", err) }
+
+    me, _ := users.GetByID(ctx, b.User.UserID)
+    if me.RoomID == nil || *me.RoomID != house || len(me.RoomIDs) != 1 { t.Fatalf("B after merge: %+v", me) }
+    if _, err := rooms.GetByID(ctx, solo); err != derr.ErrNotFound { t.Fatalf("solo room after merge: %v", err) }
+    got, _, _ := ls.ListLists(ctx, me, house, store.Page{})
+    if len(got) != 2 || got[0].ListID != chores.ListID || got[1].ListID != groceries.ListID { t.Fatalf("lists after merge: %+v", got) }
+    if _, err := lists.GetByID(ctx, mine.ListID); err != derr.ErrNotFound { t.Fatalf("deduplicated list: %v", err) }
+    its, _, _ := items.ListByList(ctx, groceries.ListID, store.Page{})
+    if len(its) != 3 || its[0].ItemID != kept[0] || its[1].ItemID != moved[0] || its[2].ItemID != moved[1] { t.Fatalf("merged items: %+v", its) }
+    for _, id := range append(moved, choreIDs...) {
+        if it, _ := items.GetByID(ctx, id); it.RoomID != house { t.Fatalf("item %s in room %s", id, it.RoomID) }
+    }
+
+    // Merging or discarding needs a solo room: B's active room is shared now.
+    c, _ := us.CreateUserWithSoloRoom(ctx, "C", "")
+    ctok, _ := rs.RotateShareToken(ctx, c.User)
+    if _, err := rs.JoinRoomByToken(ctx, me, ctok, JoinOptions{SoloRoom: DiscardSoloRoom}); err != derr.ErrBadRequest { t.Fatalf("discard shared room: %v", err) }
+
+    // Discarding drops the solo room and queues its cleanup.
+    jobs := memstore.NewJobRepo(memstore.NewStore())
+    rs.UseJobQueue(jobs)
+    d, _ := us.CreateUserWithSoloRoom(ctx, "D", "")
+    tok, _ = rs.RotateShareToken(ctx, a.User)
+    if _, err := rs.JoinRoomByToken(ctx, d.User, tok, JoinOptions{SoloRoom: DiscardSoloRoom}); err != nil { t.Fatalf("join discarding: %v", err) }
+    if _, err := rooms.GetByID(ctx, *d.User.RoomID); err != derr.ErrNotFound { t.Fatalf("solo room after discard: %v", err) }
+    now := time.Now().UTC()
+    if job, err := jobs.ClaimNext(ctx, now.Add(time.Minute), now.Add(time.Hour)); err != nil || job.Kind != JobCleanupRoom || job.Payload["room_id"] != *d.User.RoomID { t.Fatalf("cleanup job: %+v %v", job, err) }
+}
+
+// markTx marks the context of its transactions so txItems can tell.
+type markTx struct{ store.TxRunner }
+
+type inTx struct{}
+
+func (m markTx) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+    return m.TxRunner.WithTransaction(ctx, func(txctx context.Context) error { return fn(context.WithValue(txctx, inTx{}, true)) })
+}
+
+// txItems refuses to move items inside a transaction, where DynamoDB would
+// count each against its write limit, and fails the next move when fail is set.
+type txItems struct {
+    store.ListItemRepository
+    fail bool
+}
+
+func (r *txItems) MoveByList(ctx context.Context, from, to, roomID string, offset float64, at time.Time) (int, error) {
+    if ctx.Value(inTx{}) != nil { return 0, errors.New("items moved inside the transaction") }
+    if r.fail {
+        r.fail = false
+        return 0, errors.New("store unavailable")
+    }
+    return r.ListItemRepository.MoveByList(ctx, from, to, roomID, offset, at)
+}
+
+func TestJoinMergesManyItems(t *testing.T) {
+    tx, users, rooms, lists, memItems := memstore.Compose()
+    tx = markTx{tx}
+    items := &txItems{ListItemRepository: memItems}
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    rs.UseLists(lists, items)
+    queue := memstore.NewJobRepo(memstore.NewStore())
+    rs.UseJobQueue(queue)
+    ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+
+    ctx := context.Background()
+    a, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+    house := *a.User.RoomID
+    join := func(dedupe bool) (solo string, ids []string) {
+        b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
+        solo = *b.User.RoomID
+        l, _ := ls.CreateList(ctx, b.User, solo, "Groceries", "", "")
+        for i := range 120 {
+            it, err := ls.CreateItem(ctx, b.User, solo, l.ListID, fmt.Sprintf("item %d", i), "", "", "")
+            if err != nil { t.Fatalf("create item: %v", err) }
+            ids = append(ids, it.ItemID)
+        }
+        tok, _ := rs.RotateShareToken(ctx, a.User)
+        if _, err := rs.JoinRoomByToken(ctx, b.User, tok, JoinOptions{SoloRoom: MergeSoloRoom, DedupeLists: dedupe}); err != nil { t.Fatalf("join: %v", err) }
+        return solo, ids
+    }
+    inHouse := func(ids []string) bool {
+        for _, id := range ids {
+            if it, _ := memItems.GetByID(ctx, id); it.RoomID != house { return false }
+        }
+        return true
+    }
+    claim := func() *models.Job {
+        now := time.Now().UTC()
+        job, err := queue.ClaimNext(ctx, now.Add(time.Minute), now.Add(time.Hour))
+        if err != nil { t.Fatalf("claim: %v", err) }
+        return job
+    }
+
+    // More items than a DynamoDB transaction takes move after joining.
+    solo, ids := join(false)
+    if !inHouse(ids) { t.Fatalf("items not merged") }
+    job := claim()
+    if job.Kind != JobMergeRoom { t.Fatalf("merge job: %+v", job) }
+    if err := rs.MergeRoom(ctx, *job); err != nil { t.Fatalf("rerun merge: %v", err) }
+    if !inHouse(ids) { t.Fatalf("items after rerun") }
+    if job := claim(); job.Kind != JobCleanupRoom || job.Payload["room_id"] != solo { t.Fatalf("cleanup job: %+v", job) }
+
+    // A merge failing after the join is finished by the queued job.
+    items.fail = true
+    _, ids = join(true)
+    if inHouse(ids) { t.Fatalf("items merged despite failure") }
+    if err := rs.MergeRoom(ctx, *claim()); err != nil { t.Fatalf("resume merge: %v", err) }
+    if !inHouse(ids) { t.Fatalf("items after resume") }
+    got, _, _ := lists.ListByRoom(ctx, house, store.Page{})
+    if len(got) != 1 { t.Fatalf("lists after deduplicating merge: %+v", got) }
+}
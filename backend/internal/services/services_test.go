@@ -2,24 +2,33 @@ package services
 
 import (
     "context"
+    "path/filepath"
     "testing"
     "time"
 
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
     "github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
 )
 
+// newTestAuth returns the AuthService that signs in users created by tests.
+func newTestAuth(t *testing.T, users store.UserRepository) *AuthService {
+    t.Helper()
+    auth, err := NewAuthService(users, memstore.NewSessionRepo(memstore.NewStore()), filepath.Join(t.TempDir(), "enc.key"), 1)
+    if err != nil { t.Fatalf("auth svc: %v", err) }
+    return auth
+}
+
 func setupSvc(t *testing.T) (*UserService, *RoomService, func()) {
-    tx, users, rooms, lists, items := memstore.Compose()
+    tx, users, rooms, _, _ := memstore.Compose()
     rs := NewRoomService(users, rooms, tx)
-    rs.UseListRepos(lists, items)
-    return NewUserService(users, rooms, tx), rs, func() {}
+    return NewUserService(users, rooms, tx, newTestAuth(t, users)), rs, func() {}
 }
 
 func TestUserSignupAndSoloRoom(t *testing.T) {
     us, _, cleanup := setupSvc(t)
     defer cleanup()
-    cu, err := us.CreateUserWithSoloRoom(context.Background(), "Alice")
+    cu, err := us.CreateUserWithSoloRoom(context.Background(), "Alice", "")
     if err != nil { t.Fatalf("create: %v", err) }
     if cu.APIKey == "" || cu.User.RoomID == nil || *cu.User.RoomID == "" { t.Fatalf("expected key and room id") }
 }
@@ -29,19 +38,19 @@ func TestShareJoinAndDeleteFlow(t *testing.T) {
     defer cleanup()
     ctx := context.Background()
 
-    a, _ := us.CreateUserWithSoloRoom(ctx, "A")
-    b, _ := us.CreateUserWithSoloRoom(ctx, "B")
+    a, _ := us.CreateUserWithSoloRoom(ctx, "A", "")
+    b, _ := us.CreateUserWithSoloRoom(ctx, "B", "")
 
     tok, err := rs.RotateShareToken(ctx, a.User)
     if err != nil { t.Fatalf("rotate: %v", err) }
 
     // Join B into A's room
-    room, err := rs.JoinRoom(ctx, b.User, *a.User.RoomID, tok)
+    room, err := rs.JoinRoom(ctx, b.User, *a.User.RoomID, tok, JoinOptions{})
     if err != nil { t.Fatalf("join: %v", err) }
     if len(room.MemberIDs) != 2 { t.Fatalf("expected 2 members, got %d", len(room.MemberIDs)) }
 
     // Second join attempt should fail (room full)
-    if _, err := rs.JoinRoom(ctx, a.User, room.RoomID, tok); err == nil {
+    if _, err := rs.JoinRoom(ctx, a.User, room.RoomID, tok, JoinOptions{}); err == nil {
         t.Fatalf("expected conflict on joining full room")
     }
 
@@ -53,10 +62,12 @@ func TestShareJoinAndDeleteFlow(t *testing.T) {
     deleted, err = rs.VoteDeletion(ctx, bRef)
     if err != nil || !deleted { t.Fatalf("second vote should delete: %v %v", deleted, err) }
 
-    // After deletion, both users have no room
-    // Fetching their room should be not found
-    if _, err := rs.GetMyRoom(ctx, a.User); err == nil || err != derr.ErrNotFound { t.Fatalf("expected not found for A") }
-    if _, err := rs.GetMyRoom(ctx, b.User); err == nil || err != derr.ErrNotFound { t.Fatalf("expected not found for B") }
+    // After deletion A has no room left; B is back in the room they had
+    // before joining
+    aRef, _ := us.GetMe(ctx, a.User.UserID)
+    if _, err := rs.GetMyRoom(ctx, aRef); err == nil || err != derr.ErrNotFound { t.Fatalf("expected not found for A") }
+    bRef, _ = us.GetMe(ctx, b.User.UserID)
+    if rm, err := rs.GetMyRoom(ctx, bRef); err != nil || rm.RoomID != *b.User.RoomID { t.Fatalf("expected B's own room, got %v %v", rm, err) }
 
     _ = time.Now() // silence unused import if any
 }
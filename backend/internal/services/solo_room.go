@@ -0,0 +1,201 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/jobs"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// SoloRoomAction says what JoinRoom does with the joiner's solo room, the
+// active room they are the only member of.
+type SoloRoomAction string
+
+const (
+    // KeepSoloRoom leaves the solo room as one of the joiner's rooms.
+    KeepSoloRoom SoloRoomAction = ""
+    // MergeSoloRoom moves the solo room's lists and their items into the
+    // joined room and deletes the solo room; lists in its trash are deleted
+    // with it.
+    MergeSoloRoom SoloRoomAction = "merge"
+    // DiscardSoloRoom deletes the solo room with its lists and items.
+    DiscardSoloRoom SoloRoomAction = "discard"
+)
+
+// JoinOptions tune JoinRoom. DedupeLists makes MergeSoloRoom put the items of
+// a list into the joined room's list of the same name, ignoring case, instead
+// of moving the list alongside it; it is an error with any other action.
+type JoinOptions struct {
+    SoloRoom    SoloRoomAction
+    DedupeLists bool
+}
+
+// JobMergeRoom moves the lists and items of a solo room JoinRoom merged into
+// the joined room, then queues the solo room's cleanup. Payload: from_room,
+// to_room, and per list "list:<list_id>", the list its items go into (itself
+// unless deduplicated), and "offset:<list_id>", what is added to their Order.
+const JobMergeRoom = "merge_room"
+
+// listMove is one list of the solo room on its way to the joined room: into
+// the list into, or as itself when into is its own ID.
+type listMove struct {
+    listID string
+    into   string
+    offset float64
+}
+
+// soloRoomChange is what JoinRoom does to the joiner's solo room: it deletes
+// room and, when merging, makes moves afterwards, queued as merge.
+type soloRoomChange struct {
+    roomID string
+    moves  []listMove
+    merge  *models.Job
+}
+
+// soloRoomPlan works out what opts do to the joiner's solo room before rm is
+// joined, or returns nil to keep it. It fails with derr.ErrBadRequest for an
+// unknown action, for DedupeLists without merging, when the joiner has no solo
+// room to act on, or when merging without UseLists.
+func (s *RoomService) soloRoomPlan(ctx context.Context, joiner *models.User, rm *models.Room, opts JoinOptions) (*soloRoomChange, error) {
+    if opts.DedupeLists && opts.SoloRoom != MergeSoloRoom { return nil, derr.ErrBadRequest }
+    switch opts.SoloRoom {
+    case KeepSoloRoom:
+        return nil, nil
+    case MergeSoloRoom, DiscardSoloRoom:
+    default:
+        return nil, derr.ErrBadRequest
+    }
+    if joiner.RoomID == nil || *joiner.RoomID == "" { return nil, derr.ErrBadRequest }
+    solo, err := s.rooms.GetByID(ctx, *joiner.RoomID)
+    if err != nil { return nil, err }
+    if len(solo.MemberIDs) != 1 || solo.MemberIDs[0] != joiner.UserID { return nil, derr.ErrBadRequest }
+    change := &soloRoomChange{roomID: solo.RoomID}
+    if opts.SoloRoom == MergeSoloRoom {
+        if s.lists == nil || s.items == nil { return nil, derr.ErrBadRequest }
+        change.moves, err = s.listMoves(ctx, solo.RoomID, rm.RoomID, opts.DedupeLists)
+        if err != nil { return nil, err }
+        change.merge = jobs.New(JobMergeRoom, mergePayload(solo.RoomID, rm.RoomID, change.moves), time.Now().UTC())
+    }
+    return change, nil
+}
+
+// applySoloRoomChange deletes the solo room inside the join transaction. A
+// discarded room is cleaned up; a merged one is left to JobMergeRoom, so the
+// number of items moved does not count against the transaction.
+func (s *RoomService) applySoloRoomChange(txctx context.Context, c *soloRoomChange, now time.Time) error {
+    if err := s.rooms.Delete(txctx, c.roomID); err != nil { return err }
+    if c.merge == nil { return enqueueRoomCleanup(txctx, s.jobs, c.roomID, now) }
+    if s.jobs == nil { return nil }
+    return s.jobs.Enqueue(txctx, c.merge)
+}
+
+// MergeRoom handles JobMergeRoom. JoinRoom runs it right after joining and
+// the queue again later, so it finishes a run that failed part way: a list's
+// items move before the list, and moved items are not matched again.
+func (s *RoomService) MergeRoom(ctx context.Context, job models.Job) error {
+    from, to, moves, err := parseMergePayload(job.Payload)
+    if err != nil { return jobs.Permanent(err) }
+    if err := s.mergeLists(ctx, to, moves, time.Now().UTC()); err != nil { return err }
+    // Whatever was not moved, trashed lists and invites among it, is cleaned
+    // up with the room.
+    return enqueueRoomCleanup(ctx, s.jobs, from, time.Now().UTC())
+}
+
+// mergeLists carries out moves into the room to, one list at a time.
+func (s *RoomService) mergeLists(ctx context.Context, to string, moves []listMove, now time.Time) error {
+    if s.lists == nil || s.items == nil { return fmt.Errorf("merge_room: lists are not configured") }
+    for _, mv := range moves {
+        if _, err := s.items.MoveByList(ctx, mv.listID, mv.into, to, mv.offset, now); err != nil {
+            return fmt.Errorf("move items of list %s: %w", mv.listID, err)
+        }
+        if mv.into == mv.listID {
+            err := s.lists.MoveToRoom(ctx, mv.listID, to, now)
+            if err != nil && !errors.Is(err, derr.ErrNotFound) { return fmt.Errorf("move list %s: %w", mv.listID, err) }
+            continue
+        }
+        err := s.lists.Delete(ctx, mv.listID)
+        if err != nil && !errors.Is(err, derr.ErrNotFound) { return fmt.Errorf("delete list %s: %w", mv.listID, err) }
+    }
+    return nil
+}
+
+func mergePayload(from, to string, moves []listMove) map[string]string {
+    p := map[string]string{"from_room": from, "to_room": to}
+    for _, mv := range moves {
+        p["list:"+mv.listID] = mv.into
+        if mv.offset != 0 { p["offset:"+mv.listID] = strconv.FormatFloat(mv.offset, 'f', -1, 64) }
+    }
+    return p
+}
+
+func parseMergePayload(p map[string]string) (from, to string, moves []listMove, err error) {
+    from, to = p["from_room"], p["to_room"]
+    if from == "" || to == "" { return "", "", nil, fmt.Errorf("merge_room: missing from_room or to_room") }
+    for k, into := range p {
+        listID, ok := strings.CutPrefix(k, "list:")
+        if !ok { continue }
+        mv := listMove{listID: listID, into: into}
+        if off := p["offset:"+listID]; off != "" {
+            if mv.offset, err = strconv.ParseFloat(off, 64); err != nil { return "", "", nil, fmt.Errorf("merge_room: offset of list %s: %w", listID, err) }
+        }
+        moves = append(moves, mv)
+    }
+    // Map order is random; keep runs alike.
+    slices.SortFunc(moves, func(a, b listMove) int { return strings.Compare(a.listID, b.listID) })
+    return from, to, moves, nil
+}
+
+// listMoves plans moving the live lists of the room from into the room to.
+// With dedupe a list whose name matches one of to's goes into it, its items
+// shifted after that list's own so they keep their order at the end.
+func (s *RoomService) listMoves(ctx context.Context, from, to string, dedupe bool) ([]listMove, error) {
+    src, _, err := s.lists.ListByRoom(ctx, from, store.Page{})
+    if err != nil { return nil, err }
+    byName := map[string]string{}
+    if dedupe {
+        dst, _, err := s.lists.ListByRoom(ctx, to, store.Page{})
+        if err != nil { return nil, err }
+        for _, l := range dst {
+            key := listNameKey(l.Name)
+            if _, ok := byName[key]; !ok { byName[key] = l.ListID }
+        }
+    }
+    moves := make([]listMove, 0, len(src))
+    for _, l := range src {
+        into, ok := byName[listNameKey(l.Name)]
+        if !ok {
+            moves = append(moves, listMove{listID: l.ListID, into: l.ListID})
+            continue
+        }
+        offset, err := s.appendOffset(ctx, l.ListID, into)
+        if err != nil { return nil, err }
+        moves = append(moves, listMove{listID: l.ListID, into: into, offset: offset})
+    }
+    return moves, nil
+}
+
+// appendOffset returns what to add to the Order of the items of list from so
+// they follow the items of list into.
+func (s *RoomService) appendOffset(ctx context.Context, from, into string) (float64, error) {
+    moved, _, err := s.items.ListByList(ctx, from, store.Page{})
+    if err != nil { return 0, err }
+    kept, _, err := s.items.ListByList(ctx, into, store.Page{})
+    if err != nil { return 0, err }
+    if len(moved) == 0 || len(kept) == 0 { return 0, nil }
+    first, last := moved[0].Order, kept[0].Order
+    for _, it := range moved { first = min(first, it.Order) }
+    for _, it := range kept { last = max(last, it.Order) }
+    if first > last { return 0, nil }
+    return last - first + 1000, nil
+}
+
+// listNameKey is what list names are compared by when deduplicating.
+func listNameKey(name string) string { return strings.ToLower(strings.TrimSpace(name)) }
@@ -2,10 +2,11 @@ package services
 
 import (
     "context"
+    "errors"
+    "log"
     "regexp"
     "time"
 
-    authpkg "github.com/janvillarosa/gracie-app/backend/internal/auth"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
     "github.com/janvillarosa/gracie-app/backend/internal/store"
@@ -15,13 +16,17 @@ import (
 type UserService struct {
     users store.UserRepository
     rooms store.RoomRepository
-    lists store.ListRepository
-    items store.ListItemRepository
+    jobs  store.JobRepository
     tx    store.TxRunner
+    auth  *AuthService
+    // roomSvc settles the deletion votes of shared rooms a deleted account
+    // leaves; nil until UseRoomService.
+    roomSvc *RoomService
 }
 
-func NewUserService(users store.UserRepository, rooms store.RoomRepository, tx store.TxRunner) *UserService {
-    return &UserService{users: users, rooms: rooms, tx: tx}
+// NewUserService signs new users in and deleted users out through auth.
+func NewUserService(users store.UserRepository, rooms store.RoomRepository, tx store.TxRunner, auth *AuthService) *UserService {
+    return &UserService{users: users, rooms: rooms, tx: tx, auth: auth}
 }
 
 type CreatedUser struct {
@@ -29,31 +34,28 @@ type CreatedUser struct {
 	APIKey string
 }
 
-func (s *UserService) CreateUserWithSoloRoom(ctx context.Context, name string) (*CreatedUser, error) {
+// CreateUserWithSoloRoom creates a user in a room of their own and signs in a
+// session for the device called device.
+func (s *UserService) CreateUserWithSoloRoom(ctx context.Context, name, device string) (*CreatedUser, error) {
     now := time.Now().UTC()
 
     userID := ids.NewID("usr")
     roomID := ids.NewID("room")
 
-    apiKey, apiHash, err := authpkg.GenerateAPIKey()
-    if err != nil {
-        return nil, err
-    }
-    lookup := authpkg.DeriveLookup(apiKey)
-
     user := &models.User{
-        UserID:       userID,
-        Name:         name,
-        APIKeyHash:   apiHash,
-        APIKeyLookup: lookup,
-        RoomID:       &roomID,
-        CreatedAt:    now,
-        UpdatedAt:    now,
+        UserID:    userID,
+        Name:      name,
+        RoomID:    &roomID,
+        RoomIDs:   []string{roomID},
+        CreatedAt: now,
+        UpdatedAt: now,
     }
     room := &models.Room{
         RoomID:        roomID,
         MemberIDs:     []string{userID},
+        Roles:         map[string]models.Role{userID: models.RoleOwner},
         DeletionVotes: map[string]string{},
+        Version:       1,
         CreatedAt:     now,
         UpdatedAt:     now,
     }
@@ -65,6 +67,8 @@ func (s *UserService) CreateUserWithSoloRoom(ctx context.Context, name string) (
     }); err != nil {
         return nil, err
     }
+    apiKey, _, err := s.auth.IssueSession(ctx, userID, device)
+    if err != nil { return nil, err }
     return &CreatedUser{User: user, APIKey: apiKey}, nil
 }
 
@@ -75,12 +79,18 @@ func (s *UserService) UpdateName(ctx context.Context, userID string, name string
     return s.users.UpdateName(ctx, userID, name, time.Now().UTC())
 }
 
-// UseListRepos injects optional list repositories used for cleanup when a room is deleted.
-func (s *UserService) UseListRepos(lists store.ListRepository, items store.ListItemRepository) { s.lists, s.items = lists, items }
+// UseJobQueue injects the job queue that cleans up after deleted rooms. Without
+// it a deleted room's lists and items are left in place.
+func (s *UserService) UseJobQueue(jobs store.JobRepository) { s.jobs = jobs }
+
+// UseRoomService makes DeleteAccount settle the deletion votes of the shared
+// rooms the user leaves, as LeaveRoom does.
+func (s *UserService) UseRoomService(rs *RoomService) { s.roomSvc = rs }
 
 var emailRe2 = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
 
 // UpdateProfile updates name and/or username (email). Pre-checks username uniqueness.
+// A new username starts unverified and is sent a verification link.
 func (s *UserService) UpdateProfile(ctx context.Context, userID string, name *string, username *string) error {
     now := time.Now().UTC()
     if name == nil && username == nil { return derr.ErrBadRequest }
@@ -88,10 +98,15 @@ func (s *UserService) UpdateProfile(ctx context.Context, userID string, name *st
     if username != nil {
         if *username == "" || !emailRe2.MatchString(*username) { return derr.ErrBadRequest }
         // Ensure not taken by another user
-        if u, err := s.users.GetByUsername(ctx, *username); err == nil && u != nil && u.UserID != userID {
+        existing, err := s.users.GetByUsername(ctx, *username)
+        if err == nil && existing != nil && existing.UserID != userID {
             return derr.ErrConflict
         }
-        if err := s.users.UpdateUsername(ctx, userID, *username, now); err != nil { return err }
+        // Unchanged: keep its verification.
+        if err != nil || existing == nil {
+            if err := s.users.UpdateUsername(ctx, userID, *username, now); err != nil { return err }
+            s.emailChanged(ctx, userID)
+        }
     }
     if name != nil {
         if err := s.users.UpdateName(ctx, userID, *name, now); err != nil { return err }
@@ -99,49 +114,52 @@ func (s *UserService) UpdateProfile(ctx context.Context, userID string, name *st
     return nil
 }
 
-// DeleteAccount removes the user and detaches them from any room. If their room would
-// become empty, delete the room and (optionally) cleanup lists.
+// emailChanged voids links sent to the user's old address and verifies the
+// new one. The change itself has succeeded, so failures are only logged.
+func (s *UserService) emailChanged(ctx context.Context, userID string) {
+    if s.auth == nil { return }
+    u, err := s.users.GetByID(ctx, userID)
+    if err == nil { err = s.auth.EmailChanged(ctx, u) }
+    if err != nil { log.Printf("users: after username change of %s: %v", userID, err) }
+}
+
+// DeleteAccount signs the user out everywhere, removes them and detaches them from their rooms.
+// Rooms that would become empty are deleted and their lists and items cleaned up.
+// Shared rooms are left as with LeaveRoom: an owner's room goes to successorOf's
+// pick and votes the user held up are settled.
 func (s *UserService) DeleteAccount(ctx context.Context, userID string) error {
     u, err := s.users.GetByID(ctx, userID)
     if err != nil { return err }
+    if err := s.auth.RevokeAllSessions(ctx, userID); err != nil { return err }
+    if err := s.auth.DeleteRecoveryCodes(ctx, userID); err != nil { return err }
     now := time.Now().UTC()
-    var deletedRoomID string
-    if u.RoomID != nil && *u.RoomID != "" {
-        rm, err := s.rooms.GetByID(ctx, *u.RoomID)
+    var rooms []*models.Room
+    for _, id := range roomIDsOf(u) {
+        rm, err := s.rooms.GetByID(ctx, id)
+        if errors.Is(err, derr.ErrNotFound) { continue }
         if err != nil { return err }
-        if len(rm.MemberIDs) <= 1 {
-            // Solo room: delete room then delete user
-            if err := s.tx.WithTransaction(ctx, func(txctx context.Context) error {
+        if isMember(rm, u.UserID) { rooms = append(rooms, rm) }
+    }
+    var shared []string
+    if err := s.tx.WithTransaction(ctx, func(txctx context.Context) error {
+        shared = shared[:0]
+        for _, rm := range rooms {
+            if len(rm.MemberIDs) <= 1 {
+                // Solo room: delete it with the user
                 if err := s.rooms.Delete(txctx, rm.RoomID); err != nil { return err }
-                // Clear room_id then delete user
-                if err := s.users.SetRoomID(txctx, u.UserID, nil, now); err != nil { return err }
-                if err := s.users.Delete(txctx, u.UserID); err != nil { return err }
-                return nil
-            }); err != nil { return err }
-            deletedRoomID = rm.RoomID
-        } else {
-            // Shared room: remove membership and delete user
-            if err := s.tx.WithTransaction(ctx, func(txctx context.Context) error {
-                if err := s.rooms.RemoveMember(txctx, rm.RoomID, u.UserID, now); err != nil { return err }
-                _ = s.rooms.RemoveDeletionVote(txctx, rm.RoomID, u.UserID)
-                if err := s.users.SetRoomID(txctx, u.UserID, nil, now); err != nil { return err }
-                if err := s.users.Delete(txctx, u.UserID); err != nil { return err }
-                return nil
-            }); err != nil { return err }
+                if err := enqueueRoomCleanup(txctx, s.jobs, rm.RoomID, now); err != nil { return err }
+                continue
+            }
+            if err := removeMember(txctx, s.rooms, rm, u.UserID, "", now); err != nil { return err }
+            shared = append(shared, rm.RoomID)
+        }
+        return s.users.Delete(txctx, u.UserID)
+    }); err != nil { return err }
+    if s.roomSvc == nil { return nil }
+    for _, id := range shared {
+        if err := s.roomSvc.settleDeletionVotes(ctx, id, u.UserID, now); err != nil {
+            log.Printf("users: settling deletion votes of room %s: %v", id, err)
         }
-    } else {
-        // No room: delete user only
-        if err := s.users.Delete(ctx, u.UserID); err != nil { return err }
-    }
-    if deletedRoomID != "" {
-        go s.cleanupRoomResources(context.Background(), deletedRoomID)
     }
     return nil
 }
-
-func (s *UserService) cleanupRoomResources(ctx context.Context, roomID string) {
-    if s.lists == nil { return }
-    lists, err := s.lists.ListByRoom(ctx, roomID)
-    if err != nil { return }
-    for _, l := range lists { _ = s.lists.Delete(ctx, l.ListID) }
-}
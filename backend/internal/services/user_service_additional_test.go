@@ -6,12 +6,13 @@ import (
 
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
     "github.com/janvillarosa/gracie-app/backend/internal/testutil/memstore"
 )
 
 func TestUpdateProfileValidationAndConflicts(t *testing.T) {
     tx, usersRepo, roomsRepo, _, _ := memstore.Compose()
-    svc := NewUserService(usersRepo, roomsRepo, tx)
+    svc := NewUserService(usersRepo, roomsRepo, tx, newTestAuth(t, usersRepo))
 
     // Seed an existing user with a username
     existing := &models.User{UserID: "usr_exist", Name: "Ex", Username: "ex@example.com"}
@@ -46,7 +47,7 @@ func TestUpdateProfileValidationAndConflicts(t *testing.T) {
 func TestDeleteAccountScenarios(t *testing.T) {
     ctx := context.Background()
     tx, usersRepo, roomsRepo, _, _ := memstore.Compose()
-    svc := NewUserService(usersRepo, roomsRepo, tx)
+    svc := NewUserService(usersRepo, roomsRepo, tx, newTestAuth(t, usersRepo))
 
     // No room: delete user only
     u1 := &models.User{UserID: "usr1", Name: "Solo"}
@@ -55,15 +56,15 @@ func TestDeleteAccountScenarios(t *testing.T) {
     if _, err := usersRepo.GetByID(ctx, u1.UserID); err == nil { t.Fatalf("expected u1 deleted") }
 
     // Solo room: delete room then user
-    cu, err := svc.CreateUserWithSoloRoom(ctx, "Alice")
+    cu, err := svc.CreateUserWithSoloRoom(ctx, "Alice", "")
     if err != nil { t.Fatalf("create solo: %v", err) }
     if err := svc.DeleteAccount(ctx, cu.User.UserID); err != nil { t.Fatalf("delete solo: %v", err) }
     if _, err := usersRepo.GetByID(ctx, cu.User.UserID); err == nil { t.Fatalf("expected user deleted") }
     if _, err := roomsRepo.GetByID(ctx, *cu.User.RoomID); err == nil { t.Fatalf("expected room deleted") }
 
     // Shared room: remove member and delete user, keep room for others
-    cuA, _ := svc.CreateUserWithSoloRoom(ctx, "A")
-    cuB, _ := svc.CreateUserWithSoloRoom(ctx, "B")
+    cuA, _ := svc.CreateUserWithSoloRoom(ctx, "A", "")
+    cuB, _ := svc.CreateUserWithSoloRoom(ctx, "B", "")
     // Add B to A's room
     if err := roomsRepo.AddMember(ctx, *cuA.User.RoomID, cuB.User.UserID, cuB.User.UpdatedAt); err != nil { t.Fatalf("add member: %v", err) }
     if err := usersRepo.SetRoomID(ctx, cuB.User.UserID, cuA.User.RoomID, cuB.User.UpdatedAt); err != nil { t.Fatalf("set room: %v", err) }
@@ -77,4 +78,42 @@ func TestDeleteAccountScenarios(t *testing.T) {
     if len(rm.MemberIDs) != 1 || rm.MemberIDs[0] != cuB.User.UserID { t.Fatalf("expected only B remaining, got %v", rm.MemberIDs) }
 }
 
+func TestDeleteAccountHandsOverOwnership(t *testing.T) {
+    ctx := context.Background()
+    tx, users, rooms, lists, items := memstore.Compose()
+    us := NewUserService(users, rooms, tx, newTestAuth(t, users))
+    rs := NewRoomService(users, rooms, tx)
+    rs.UseLists(lists, items)
+    us.UseRoomService(rs)
+    ls := NewListService(users, rooms, lists, items, categorization.NewKeywordCategorizer(categorization.GroceryAnchors))
+
+    owner, _ := us.CreateUserWithSoloRoom(ctx, "Owner", "")
+    roomID := *owner.User.RoomID
+    join := func(name string) *models.User {
+        u, _ := us.CreateUserWithSoloRoom(ctx, name, "")
+        tok, err := rs.RotateShareToken(ctx, owner.User)
+        if err != nil { t.Fatalf("rotate: %v", err) }
+        if _, err := rs.JoinRoomByToken(ctx, u.User, tok, JoinOptions{}); err != nil { t.Fatalf("join %s: %v", name, err) }
+        me, _ := users.GetByID(ctx, u.User.UserID)
+        return me
+    }
+    // The guest joined before the member.
+    guest := join("Guest")
+    if _, err := rs.SetMemberRole(ctx, owner.User, guest.UserID, models.RoleGuest); err != nil { t.Fatalf("make guest: %v", err) }
+    member := join("Member")
+
+    // A list only the owner has not voted to delete yet.
+    l, err := ls.CreateList(ctx, member, roomID, "Groceries", "", "")
+    if err != nil { t.Fatalf("create list: %v", err) }
+    if deleted, err := ls.VoteListDeletion(ctx, member, roomID, l.ListID); err != nil || deleted { t.Fatalf("vote list: %v %v", deleted, err) }
+
+    if err := us.DeleteAccount(ctx, owner.User.UserID); err != nil { t.Fatalf("delete account: %v", err) }
+    rm, err := rooms.GetByID(ctx, roomID)
+    if err != nil { t.Fatalf("room: %v", err) }
+    if rm.RoleOf(member.UserID) != models.RoleOwner || rm.RoleOf(guest.UserID) != models.RoleGuest || rm.Roles[member.UserID] != models.RoleOwner {
+        t.Fatalf("roles after owner deleted: %v", rm.Roles)
+    }
+    if got, err := lists.GetByID(ctx, l.ListID); err != nil || !got.IsDeleted { t.Fatalf("list vote not settled: %+v %v", got, err) }
+}
+
 func ptr[T any](v T) *T { return &v }
@@ -14,6 +14,12 @@ type Tables struct {
     Rooms string
     Lists string
     ListItems string
+    Migrations string
+    Jobs string
+    Sessions string
+    UserTokens string
+    Counters string
+    Invites string
 }
 
 type Client struct {
@@ -0,0 +1,17 @@
+package dynamo
+
+import (
+    "testing"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/store/storetest"
+    "github.com/janvillarosa/gracie-app/backend/internal/testutil"
+)
+
+func TestConformance(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) storetest.Repos {
+        db, usersTable, roomsTable, listsTable, itemsTable, cleanup := testutil.SetupDynamoWithListsOrSkip(t)
+        t.Cleanup(cleanup)
+        client := &Client{DB: db, Tables: Tables{Users: usersTable, Rooms: roomsTable, Lists: listsTable, ListItems: itemsTable}}
+        return storetest.Repos{Tx: NewTx(client), Users: NewUserRepo(client), Rooms: NewRoomRepo(client), Lists: NewListRepo(client), Items: NewListItemRepo(client)}
+    })
+}
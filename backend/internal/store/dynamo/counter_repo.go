@@ -0,0 +1,78 @@
+package dynamo
+
+import (
+    "context"
+    "strconv"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CounterRepo keeps expiring counters in the Counters table (hash key
+// "counter_key"). expires_at holds epoch seconds so that the table's TTL
+// (see cmd/setup-ddb) removes expired counters.
+type CounterRepo struct{ c *Client }
+
+func NewCounterRepo(c *Client) *CounterRepo { return &CounterRepo{c: c} }
+
+type counterItem struct {
+    Key       string `dynamodbav:"counter_key"`
+    Count     int64  `dynamodbav:"count"`
+    ExpiresAt int64  `dynamodbav:"expires_at"`
+}
+
+func counterKey(key string) map[string]types.AttributeValue {
+    return map[string]types.AttributeValue{"counter_key": &types.AttributeValueMemberS{Value: key}}
+}
+
+// Incr adds one atomically; "count" is a reserved word, hence #c.
+func (r *CounterRepo) Incr(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+    out, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                &r.c.Tables.Counters,
+        Key:                      counterKey(key),
+        UpdateExpression:         strPtr("ADD #c :one SET expires_at = if_not_exists(expires_at, :e)"),
+        ExpressionAttributeNames: map[string]string{"#c": "count"},
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":one": &types.AttributeValueMemberN{Value: "1"},
+            ":e":   &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+        },
+        ReturnValues: types.ReturnValueUpdatedNew,
+    })
+    if err != nil { return 0, err }
+    var it counterItem
+    if err := attributevalue.UnmarshalMap(out.Attributes, &it); err != nil { return 0, err }
+    return it.Count, nil
+}
+
+// Get checks expiry itself: TTL deletion lags behind by up to days.
+func (r *CounterRepo) Get(ctx context.Context, key string, now time.Time) (int64, error) {
+    out, err := r.c.DB.GetItem(ctx, &dynamodb.GetItemInput{TableName: &r.c.Tables.Counters, Key: counterKey(key), ConsistentRead: aws.Bool(true)})
+    if err != nil || out.Item == nil { return 0, err }
+    var it counterItem
+    if err := attributevalue.UnmarshalMap(out.Item, &it); err != nil { return 0, err }
+    if it.ExpiresAt <= now.Unix() { return 0, nil }
+    return it.Count, nil
+}
+
+func (r *CounterRepo) Delete(ctx context.Context, key string) error {
+    _, err := r.c.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: &r.c.Tables.Counters, Key: counterKey(key)})
+    return err
+}
+
+// DeleteExpired scans the table. The TTL removes expired counters anyway; this
+// only speeds it up.
+func (r *CounterRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    var expired []string
+    err := scanTable(ctx, r.c, r.c.Tables.Counters, func(it counterItem) error {
+        if it.ExpiresAt < cutoff.Unix() { expired = append(expired, it.Key) }
+        return nil
+    })
+    if err != nil { return 0, err }
+    for i, key := range expired {
+        if err := r.Delete(ctx, key); err != nil { return i, err }
+    }
+    return len(expired), nil
+}
@@ -0,0 +1,151 @@
+package dynamo
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// GSIs of the Invites table (see cmd/setup-ddb).
+const (
+    inviteCodeIndex = "code_index"
+    inviteLinkIndex = "link_token_index"
+    inviteRoomIndex = "room_id_index"
+)
+
+// InviteRepo stores room invites in the Invites table (hash key "invite_id").
+type InviteRepo struct{ c *Client }
+
+func NewInviteRepo(c *Client) *InviteRepo { return &InviteRepo{c: c} }
+
+func inviteKey(id string) map[string]types.AttributeValue {
+    return map[string]types.AttributeValue{"invite_id": &types.AttributeValueMemberS{Value: id}}
+}
+
+// Put checks the code and link token against the GSIs before writing. GSIs
+// cannot enforce uniqueness, so two invites racing for the same random code
+// could both be written; GetByToken then returns either.
+func (r *InviteRepo) Put(ctx context.Context, inv *models.Invite) error {
+    for _, tok := range []string{inv.Code, inv.LinkToken} {
+        if tok == "" { continue }
+        _, err := r.GetByToken(ctx, tok)
+        if err == nil { return derr.ErrConflict }
+        if !errors.Is(err, derr.ErrNotFound) { return err }
+    }
+    item, err := attributevalue.MarshalMap(inv)
+    if err != nil { return err }
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
+        TableName:           &r.c.Tables.Invites,
+        Item:                item,
+        ConditionExpression: strPtr("attribute_not_exists(invite_id)"),
+    })
+    return conflictIfConditionFailed(err)
+}
+
+func (r *InviteRepo) GetByID(ctx context.Context, inviteID string) (*models.Invite, error) {
+    out, err := r.c.DB.GetItem(ctx, &dynamodb.GetItemInput{TableName: &r.c.Tables.Invites, Key: inviteKey(inviteID)})
+    if err != nil { return nil, err }
+    if len(out.Item) == 0 { return nil, derr.ErrNotFound }
+    var inv models.Invite
+    if err := attributevalue.UnmarshalMap(out.Item, &inv); err != nil { return nil, err }
+    return &inv, nil
+}
+
+// GetByToken reads the code GSI, then the link token GSI. Both are
+// eventually consistent, so the counts in the result may lag behind.
+func (r *InviteRepo) GetByToken(ctx context.Context, token string) (*models.Invite, error) {
+    if token == "" { return nil, derr.ErrNotFound }
+    for _, ix := range []struct{ index, attr string }{{inviteCodeIndex, "code"}, {inviteLinkIndex, "link_token"}} {
+        invs, err := r.queryIndex(ctx, ix.index, ix.attr, token)
+        if err != nil { return nil, err }
+        if len(invs) > 0 { return &invs[0], nil }
+    }
+    return nil, derr.ErrNotFound
+}
+
+func (r *InviteRepo) ListByRoom(ctx context.Context, roomID string) ([]models.Invite, error) {
+    invs, err := r.queryIndex(ctx, inviteRoomIndex, "room_id", roomID)
+    if err != nil { return nil, err }
+    sort.Slice(invs, func(i, j int) bool {
+        if !invs[i].CreatedAt.Equal(invs[j].CreatedAt) { return invs[i].CreatedAt.Before(invs[j].CreatedAt) }
+        return invs[i].InviteID < invs[j].InviteID
+    })
+    return invs, nil
+}
+
+// ScanAll scans the whole table page by page, calling fn for each invite.
+func (r *InviteRepo) ScanAll(ctx context.Context, fn func(inv models.Invite) error) error {
+    return scanTable(ctx, r.c, r.c.Tables.Invites, fn)
+}
+
+func (r *InviteRepo) queryIndex(ctx context.Context, index, attr, value string) ([]models.Invite, error) {
+    var (
+        out   []models.Invite
+        start map[string]types.AttributeValue
+    )
+    for {
+        page, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
+            TableName:                 &r.c.Tables.Invites,
+            IndexName:                 strPtr(index),
+            KeyConditionExpression:    strPtr("#k = :v"),
+            ExpressionAttributeNames:  map[string]string{"#k": attr},
+            ExpressionAttributeValues: map[string]types.AttributeValue{":v": &types.AttributeValueMemberS{Value: value}},
+            ExclusiveStartKey:         start,
+        })
+        if err != nil { return nil, err }
+        var invs []models.Invite
+        if err := attributevalue.UnmarshalListOfMaps(page.Items, &invs); err != nil { return nil, err }
+        out = append(out, invs...)
+        if len(page.LastEvaluatedKey) == 0 { return out, nil }
+        start = page.LastEvaluatedKey
+    }
+}
+
+func (r *InviteRepo) Use(ctx context.Context, inviteID string) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:           &r.c.Tables.Invites,
+        Key:                 inviteKey(inviteID),
+        ConditionExpression: strPtr("attribute_exists(invite_id) AND attribute_not_exists(revoked_at) AND (max_uses = :zero OR uses < max_uses)"),
+        UpdateExpression:    strPtr("SET uses = uses + :one"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":zero": &types.AttributeValueMemberN{Value: "0"},
+            ":one":  &types.AttributeValueMemberN{Value: "1"},
+        },
+    })
+    return conflictIfConditionFailed(err)
+}
+
+func (r *InviteRepo) Revoke(ctx context.Context, inviteID string, at time.Time) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                 &r.c.Tables.Invites,
+        Key:                       inviteKey(inviteID),
+        ConditionExpression:       strPtr("attribute_exists(invite_id)"),
+        UpdateExpression:          strPtr("SET revoked_at = if_not_exists(revoked_at, :t)"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{":t": timeAV(at)},
+    })
+    return notFoundIfConditionFailed(err)
+}
+
+func (r *InviteRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    invs, err := r.queryIndex(ctx, inviteRoomIndex, "room_id", roomID)
+    if err != nil { return 0, err }
+    n := 0
+    for _, inv := range invs {
+        err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
+            TableName:           &r.c.Tables.Invites,
+            Key:                 inviteKey(inv.InviteID),
+            ConditionExpression: strPtr("attribute_exists(invite_id)"),
+        })
+        if errors.Is(notFoundIfConditionFailed(err), derr.ErrNotFound) { continue }
+        if err != nil { return n, err }
+        n++
+    }
+    return n, nil
+}
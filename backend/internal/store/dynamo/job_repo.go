@@ -0,0 +1,208 @@
+package dynamo
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// jobStatusIndex is a GSI on status; due jobs are picked from it in Go.
+const jobStatusIndex = "status_index"
+
+// JobRepo stores background jobs in the Jobs table (hash key "job_id").
+type JobRepo struct{ c *Client }
+
+func NewJobRepo(c *Client) *JobRepo { return &JobRepo{c: c} }
+
+func jobKey(id string) map[string]types.AttributeValue {
+    return map[string]types.AttributeValue{"job_id": &types.AttributeValueMemberS{Value: id}}
+}
+
+func timeAV(t time.Time) types.AttributeValue {
+    return &types.AttributeValueMemberS{Value: t.UTC().Format(time.RFC3339Nano)}
+}
+
+func (r *JobRepo) Enqueue(ctx context.Context, j *models.Job) error {
+    item, err := attributevalue.MarshalMap(j)
+    if err != nil { return err }
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
+        TableName:           &r.c.Tables.Jobs,
+        Item:                item,
+        ConditionExpression: strPtr("attribute_not_exists(job_id)"),
+    })
+    return conflictIfConditionFailed(err)
+}
+
+func (r *JobRepo) GetByID(ctx context.Context, id string) (*models.Job, error) {
+    out, err := r.c.DB.GetItem(ctx, &dynamodb.GetItemInput{TableName: &r.c.Tables.Jobs, Key: jobKey(id)})
+    if err != nil { return nil, err }
+    if len(out.Item) == 0 { return nil, derr.ErrNotFound }
+    var j models.Job
+    if err := attributevalue.UnmarshalMap(out.Item, &j); err != nil { return nil, err }
+    return &j, nil
+}
+
+func (r *JobRepo) byStatus(ctx context.Context, status string) ([]models.Job, error) {
+    var (
+        jobs  []models.Job
+        start map[string]types.AttributeValue
+    )
+    for {
+        out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
+            TableName:                 &r.c.Tables.Jobs,
+            IndexName:                 strPtr(jobStatusIndex),
+            KeyConditionExpression:    strPtr("#s = :s"),
+            ExpressionAttributeNames:  map[string]string{"#s": "status"},
+            ExpressionAttributeValues: map[string]types.AttributeValue{":s": &types.AttributeValueMemberS{Value: status}},
+            ExclusiveStartKey:         start,
+        })
+        if err != nil { return nil, err }
+        var page []models.Job
+        if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil { return nil, err }
+        jobs = append(jobs, page...)
+        if len(out.LastEvaluatedKey) == 0 { return jobs, nil }
+        start = out.LastEvaluatedKey
+    }
+}
+
+// ClaimNext tries the due jobs earliest first, each with an update guarded by
+// the attempt count it was read with.
+func (r *JobRepo) ClaimNext(ctx context.Context, now, leaseUntil time.Time) (*models.Job, error) {
+    var due []models.Job
+    for _, status := range []string{models.JobPending, models.JobRunning} {
+        jobs, err := r.byStatus(ctx, status)
+        if err != nil { return nil, err }
+        for _, j := range jobs {
+            if !j.RunAt.After(now) { due = append(due, j) }
+        }
+    }
+    sort.Slice(due, func(i, j int) bool {
+        if !due[i].RunAt.Equal(due[j].RunAt) { return due[i].RunAt.Before(due[j].RunAt) }
+        return due[i].JobID < due[j].JobID
+    })
+    for _, j := range due {
+        out, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+            TableName:                &r.c.Tables.Jobs,
+            Key:                      jobKey(j.JobID),
+            UpdateExpression:         strPtr("SET #s = :running, run_at = :lease, updated_at = :now ADD attempts :one"),
+            ConditionExpression:      strPtr("attempts = :a AND #s <> :dead"),
+            ExpressionAttributeNames: map[string]string{"#s": "status"},
+            ExpressionAttributeValues: map[string]types.AttributeValue{
+                ":running": &types.AttributeValueMemberS{Value: models.JobRunning},
+                ":dead":    &types.AttributeValueMemberS{Value: models.JobDead},
+                ":lease":   timeAV(leaseUntil),
+                ":now":     timeAV(now),
+                ":one":     &types.AttributeValueMemberN{Value: "1"},
+                ":a":       &types.AttributeValueMemberN{Value: strconv.Itoa(j.Attempts)},
+            },
+            ReturnValues: types.ReturnValueAllNew,
+        })
+        var cce *types.ConditionalCheckFailedException
+        if errors.As(err, &cce) { continue }
+        if err != nil { return nil, err }
+        var claimed models.Job
+        if err := attributevalue.UnmarshalMap(out.Attributes, &claimed); err != nil { return nil, err }
+        return &claimed, nil
+    }
+    return nil, derr.ErrNotFound
+}
+
+func leaseCondition(attempt int, values map[string]types.AttributeValue) map[string]types.AttributeValue {
+    values[":running"] = &types.AttributeValueMemberS{Value: models.JobRunning}
+    values[":attempt"] = &types.AttributeValueMemberN{Value: strconv.Itoa(attempt)}
+    return values
+}
+
+const leaseExpr = "#s = :running AND attempts = :attempt"
+
+// lostLease maps a failed lease condition to derr.ErrNotFound or derr.ErrConflict.
+func (r *JobRepo) lostLease(ctx context.Context, jobID string, err error) error {
+    var cce *types.ConditionalCheckFailedException
+    if !errors.As(err, &cce) { return err }
+    if _, err := r.GetByID(ctx, jobID); err != nil { return err }
+    return derr.ErrConflict
+}
+
+func (r *JobRepo) Complete(ctx context.Context, jobID string, attempt int) error {
+    _, err := r.c.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+        TableName:                 &r.c.Tables.Jobs,
+        Key:                       jobKey(jobID),
+        ConditionExpression:       strPtr(leaseExpr),
+        ExpressionAttributeNames:  map[string]string{"#s": "status"},
+        ExpressionAttributeValues: leaseCondition(attempt, map[string]types.AttributeValue{}),
+    })
+    if err != nil { return r.lostLease(ctx, jobID, err) }
+    return nil
+}
+
+func (r *JobRepo) Retry(ctx context.Context, jobID string, attempt int, runAt time.Time, lastErr string, updatedAt time.Time) error {
+    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                &r.c.Tables.Jobs,
+        Key:                      jobKey(jobID),
+        UpdateExpression:         strPtr("SET #s = :pending, run_at = :run, last_error = :e, updated_at = :ua"),
+        ConditionExpression:      strPtr(leaseExpr),
+        ExpressionAttributeNames: map[string]string{"#s": "status"},
+        ExpressionAttributeValues: leaseCondition(attempt, map[string]types.AttributeValue{
+            ":pending": &types.AttributeValueMemberS{Value: models.JobPending},
+            ":run":     timeAV(runAt),
+            ":e":       &types.AttributeValueMemberS{Value: lastErr},
+            ":ua":      timeAV(updatedAt),
+        }),
+    })
+    if err != nil { return r.lostLease(ctx, jobID, err) }
+    return nil
+}
+
+func (r *JobRepo) Bury(ctx context.Context, jobID string, attempt int, lastErr string, updatedAt time.Time) error {
+    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                &r.c.Tables.Jobs,
+        Key:                      jobKey(jobID),
+        UpdateExpression:         strPtr("SET #s = :dead, last_error = :e, updated_at = :ua"),
+        ConditionExpression:      strPtr(leaseExpr),
+        ExpressionAttributeNames: map[string]string{"#s": "status"},
+        ExpressionAttributeValues: leaseCondition(attempt, map[string]types.AttributeValue{
+            ":dead": &types.AttributeValueMemberS{Value: models.JobDead},
+            ":e":    &types.AttributeValueMemberS{Value: lastErr},
+            ":ua":   timeAV(updatedAt),
+        }),
+    })
+    if err != nil { return r.lostLease(ctx, jobID, err) }
+    return nil
+}
+
+func (r *JobRepo) ListDead(ctx context.Context) ([]models.Job, error) {
+    jobs, err := r.byStatus(ctx, models.JobDead)
+    if err != nil { return nil, err }
+    if jobs == nil { jobs = []models.Job{} }
+    sort.Slice(jobs, func(i, j int) bool {
+        if !jobs[i].UpdatedAt.Equal(jobs[j].UpdatedAt) { return jobs[i].UpdatedAt.Before(jobs[j].UpdatedAt) }
+        return jobs[i].JobID < jobs[j].JobID
+    })
+    return jobs, nil
+}
+
+func (r *JobRepo) Requeue(ctx context.Context, jobID string, runAt time.Time, updatedAt time.Time) error {
+    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                &r.c.Tables.Jobs,
+        Key:                      jobKey(jobID),
+        UpdateExpression:         strPtr("SET #s = :pending, attempts = :zero, run_at = :run, updated_at = :ua"),
+        ConditionExpression:      strPtr("#s = :dead"),
+        ExpressionAttributeNames: map[string]string{"#s": "status"},
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":pending": &types.AttributeValueMemberS{Value: models.JobPending},
+            ":dead":    &types.AttributeValueMemberS{Value: models.JobDead},
+            ":zero":    &types.AttributeValueMemberN{Value: "0"},
+            ":run":     timeAV(runAt),
+            ":ua":      timeAV(updatedAt),
+        },
+    })
+    return notFoundIfConditionFailed(err)
+}
@@ -3,6 +3,7 @@ package dynamo
 import (
     "context"
     "errors"
+    "sort"
     "strconv"
     "time"
 
@@ -11,9 +12,15 @@ import (
     "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
 )
 
 const itemListIndex = "list_id_index"
+const itemRoomIndex = "room_id_index"
+
+// liveItemCond matches an existing item that is not in the trash; is_deleted
+// is omitted while false.
+const liveItemCond = "attribute_exists(item_id) AND attribute_not_exists(is_deleted)"
 
 type ListItemRepo struct{ c *Client }
 
@@ -22,7 +29,7 @@ func NewListItemRepo(c *Client) *ListItemRepo { return &ListItemRepo{c: c} }
 func (r *ListItemRepo) Put(ctx context.Context, it *models.ListItem) error {
     item, err := attributevalue.MarshalMap(it)
     if err != nil { return err }
-    _, err = r.c.DB.PutItem(ctx, &dynamodb.PutItemInput{
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
         TableName:           &r.c.Tables.ListItems,
         Item:                item,
         ConditionExpression: strPtr("attribute_not_exists(item_id)"),
@@ -42,23 +49,16 @@ func (r *ListItemRepo) GetByID(ctx context.Context, id string) (*models.ListItem
     return &it, nil
 }
 
-func (r *ListItemRepo) ListByList(ctx context.Context, listID string) ([]models.ListItem, error) {
-    out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
-        TableName:              &r.c.Tables.ListItems,
-        IndexName:              strPtr(itemListIndex),
-        KeyConditionExpression: strPtr("list_id = :lid"),
-        ExpressionAttributeValues: map[string]types.AttributeValue{
-            ":lid": &types.AttributeValueMemberS{Value: listID},
-        },
-    })
-    if err != nil { return nil, err }
-    var items []models.ListItem
-    if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil { return nil, err }
-    return items, nil
+// ListByList reads every item of the list (following LastEvaluatedKey) and
+// pages through them in memory; the index has no sort key to seek on.
+func (r *ListItemRepo) ListByList(ctx context.Context, listID string, page store.Page) ([]models.ListItem, string, error) {
+    items, err := r.queryIndex(ctx, itemListIndex, "list_id", listID)
+    if err != nil { return nil, "", err }
+    return store.Paginate(withoutTrashed(items), page, store.ItemKey, store.CompareAscending)
 }
 
-func (r *ListItemRepo) UpdateCompletion(ctx context.Context, itemID string, completed bool, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (r *ListItemRepo) UpdateCompletion(ctx context.Context, itemID string, completed bool, ifVersion int64, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.ListItems,
         Key:              map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: itemID}},
         UpdateExpression: strPtr("SET completed = :c, updated_at = :ua"),
@@ -66,13 +66,13 @@ func (r *ListItemRepo) UpdateCompletion(ctx context.Context, itemID string, comp
             ":c":  &types.AttributeValueMemberBOOL{Value: completed},
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
-        ConditionExpression: strPtr("attribute_exists(item_id)"),
-    })
-    return err
+        ConditionExpression: strPtr(liveItemCond),
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
 }
 
-func (r *ListItemRepo) UpdateDescription(ctx context.Context, itemID, description string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (r *ListItemRepo) UpdateDescription(ctx context.Context, itemID, description string, ifVersion int64, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.ListItems,
         Key:              map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: itemID}},
         UpdateExpression: strPtr("SET description = :d, updated_at = :ua"),
@@ -80,13 +80,13 @@ func (r *ListItemRepo) UpdateDescription(ctx context.Context, itemID, descriptio
             ":d":  &types.AttributeValueMemberS{Value: description},
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
-        ConditionExpression: strPtr("attribute_exists(item_id)"),
-    })
-    return err
+        ConditionExpression: strPtr(liveItemCond),
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
 }
 
-func (r *ListItemRepo) UpdateOrder(ctx context.Context, itemID string, order float64, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (r *ListItemRepo) UpdateOrder(ctx context.Context, itemID string, order float64, ifVersion int64, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.ListItems,
         Key:              map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: itemID}},
         UpdateExpression: strPtr("SET #ord = :o, updated_at = :ua"),
@@ -97,14 +97,195 @@ func (r *ListItemRepo) UpdateOrder(ctx context.Context, itemID string, order flo
             ":o":  &types.AttributeValueMemberN{Value: strconv.FormatFloat(order, 'f', -1, 64)},
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
-        ConditionExpression: strPtr("attribute_exists(item_id)"),
-    })
-    return err
+        ConditionExpression: strPtr(liveItemCond),
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
+}
+
+func (r *ListItemRepo) UpdateQuantity(ctx context.Context, itemID string, quantity string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "quantity", &types.AttributeValueMemberS{Value: quantity}, ifVersion, updatedAt)
 }
 
+func (r *ListItemRepo) UpdateUnit(ctx context.Context, itemID string, unit string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "unit", &types.AttributeValueMemberS{Value: unit}, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateCategory(ctx context.Context, itemID string, category string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "category", &types.AttributeValueMemberS{Value: category}, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateStarred(ctx context.Context, itemID string, starred bool, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "is_starred", &types.AttributeValueMemberBOOL{Value: starred}, ifVersion, updatedAt)
+}
+
+// setField sets a single attribute and bumps updated_at on an item that is not
+// in the trash.
+func (r *ListItemRepo) setField(ctx context.Context, itemID, attr string, v types.AttributeValue, ifVersion int64, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.ListItems,
+        Key:              map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: itemID}},
+        UpdateExpression: strPtr("SET #f = :v, updated_at = :ua"),
+        ExpressionAttributeNames: map[string]string{"#f": attr},
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":v":  v,
+            ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+        },
+        ConditionExpression: strPtr(liveItemCond),
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
+}
+
+func (r *ListItemRepo) Patch(ctx context.Context, itemID string, p store.ItemPatch, ifVersion int64, updatedAt time.Time) (*models.ListItem, error) {
+    e := newPatchExpr(updatedAt)
+    e.putString("description", p.Description)
+    e.putBool("completed", p.Completed)
+    e.putString("quantity", p.Quantity)
+    e.putString("unit", p.Unit)
+    e.putString("category", p.Category)
+    e.putBool("is_starred", p.Starred)
+    key := map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: itemID}}
+    item, err := r.c.updateItemNew(ctx, versioned(e.input(&r.c.Tables.ListItems, key, liveItemCond, nil), ifVersion))
+    if err != nil { return nil, versionFailed(err, notTrashed) }
+    var it models.ListItem
+    if err := attributevalue.UnmarshalMap(item, &it); err != nil { return nil, err }
+    return &it, nil
+}
+
+// ArchiveCompletedByList marks every completed, not yet archived item of a list as archived.
+// DynamoDB has no multi-item update, so the matching items are updated one by one.
+func (r *ListItemRepo) ArchiveCompletedByList(ctx context.Context, listID string, updatedAt time.Time) error {
+    items, err := r.queryIndex(ctx, itemListIndex, "list_id", listID)
+    if err != nil { return err }
+    for _, it := range items {
+        if !it.Completed || it.IsArchived || it.IsDeleted { continue }
+        err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+            TableName:        &r.c.Tables.ListItems,
+            Key:              map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: it.ItemID}},
+            UpdateExpression: strPtr("SET is_archived = :t, updated_at = :ua"),
+            ExpressionAttributeValues: map[string]types.AttributeValue{
+                ":t":  &types.AttributeValueMemberBOOL{Value: true},
+                ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+            },
+            ConditionExpression: strPtr(liveItemCond),
+        }, store.AnyVersion))
+        if err != nil {
+            var cce *types.ConditionalCheckFailedException
+            if errors.As(err, &cce) { continue } // deleted concurrently
+            return err
+        }
+    }
+    return nil
+}
+
+// ListArchivedByRoom returns a page of the room's archived items, most
+// recently updated first. Like ListByList it pages in memory.
+func (r *ListItemRepo) ListArchivedByRoom(ctx context.Context, roomID string, page store.Page) ([]models.ListItem, string, error) {
+    items, err := r.queryIndex(ctx, itemRoomIndex, "room_id", roomID)
+    if err != nil { return nil, "", err }
+    out := make([]models.ListItem, 0, len(items))
+    for _, it := range items {
+        if it.IsArchived && !it.IsDeleted { out = append(out, it) }
+    }
+    return store.Paginate(out, page, store.ArchivedKey, store.CompareNewestFirst)
+}
+
+func (r *ListItemRepo) SoftDelete(ctx context.Context, itemID string, ts time.Time) error {
+    return r.setField(ctx, itemID, "is_deleted", &types.AttributeValueMemberBOOL{Value: true}, store.AnyVersion, ts)
+}
+
+func (r *ListItemRepo) Restore(ctx context.Context, itemID string, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.ListItems,
+        Key:              map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: itemID}},
+        UpdateExpression: strPtr("REMOVE is_deleted SET updated_at = :ua"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+        },
+        ConditionExpression: strPtr("attribute_exists(is_deleted)"),
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
+}
+
+// ListTrashedByRoom returns trashed items of a room, most recently trashed first.
+func (r *ListItemRepo) ListTrashedByRoom(ctx context.Context, roomID string) ([]models.ListItem, error) {
+    items, err := r.queryIndex(ctx, itemRoomIndex, "room_id", roomID)
+    if err != nil { return nil, err }
+    out := make([]models.ListItem, 0, len(items))
+    for _, it := range items {
+        if it.IsDeleted { out = append(out, it) }
+    }
+    sort.SliceStable(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+    return out, nil
+}
+
+// PurgeDeletedBefore scans for trashed items and deletes the expired ones one
+// by one. The delete is conditional so an item restored meanwhile is kept.
+func (r *ListItemRepo) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+    var (
+        start map[string]types.AttributeValue
+        n     int
+    )
+    for {
+        out, err := r.c.DB.Scan(ctx, &dynamodb.ScanInput{
+            TableName:         &r.c.Tables.ListItems,
+            FilterExpression:  strPtr("attribute_exists(is_deleted)"),
+            ExclusiveStartKey: start,
+        })
+        if err != nil { return n, err }
+        var page []models.ListItem
+        if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil { return n, err }
+        for _, it := range page {
+            if !it.UpdatedAt.Before(cutoff) { continue }
+            err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
+                TableName:           &r.c.Tables.ListItems,
+                Key:                 map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: it.ItemID}},
+                ConditionExpression: strPtr("attribute_exists(is_deleted)"),
+            })
+            if errors.Is(notFoundIfConditionFailed(err), derr.ErrNotFound) { continue }
+            if err != nil { return n, err }
+            n++
+        }
+        if len(out.LastEvaluatedKey) == 0 { return n, nil }
+        start = out.LastEvaluatedKey
+    }
+}
+
+func withoutTrashed(items []models.ListItem) []models.ListItem {
+    out := items[:0]
+    for _, it := range items {
+        if !it.IsDeleted { out = append(out, it) }
+    }
+    return out
+}
+
+// queryIndex reads all items matching attr = value on a GSI, following pagination.
+func (r *ListItemRepo) queryIndex(ctx context.Context, index, attr, value string) ([]models.ListItem, error) {
+    var (
+        items []models.ListItem
+        start map[string]types.AttributeValue
+    )
+    for {
+        out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
+            TableName:              &r.c.Tables.ListItems,
+            IndexName:              strPtr(index),
+            KeyConditionExpression: strPtr("#k = :v"),
+            ExpressionAttributeNames: map[string]string{"#k": attr},
+            ExpressionAttributeValues: map[string]types.AttributeValue{
+                ":v": &types.AttributeValueMemberS{Value: value},
+            },
+            ExclusiveStartKey: start,
+        })
+        if err != nil { return nil, err }
+        var page []models.ListItem
+        if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil { return nil, err }
+        items = append(items, page...)
+        if len(out.LastEvaluatedKey) == 0 { return items, nil }
+        start = out.LastEvaluatedKey
+    }
+}
 
 func (r *ListItemRepo) Delete(ctx context.Context, itemID string) error {
-    _, err := r.c.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+    err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
         TableName: &r.c.Tables.ListItems,
         Key:       map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: itemID}},
         ConditionExpression: strPtr("attribute_exists(item_id)"),
@@ -116,3 +297,85 @@ func (r *ListItemRepo) Delete(ctx context.Context, itemID string) error {
     }
     return nil
 }
+
+// ScanAll scans the whole table page by page, calling fn for each item.
+func (r *ListItemRepo) ScanAll(ctx context.Context, fn func(it models.ListItem) error) error {
+    return scanTable(ctx, r.c, r.c.Tables.ListItems, fn)
+}
+
+// scanTable scans table page by page, calling fn for each record.
+func scanTable[T any](ctx context.Context, c *Client, table string, fn func(T) error) error {
+    var start map[string]types.AttributeValue
+    for {
+        out, err := c.DB.Scan(ctx, &dynamodb.ScanInput{TableName: &table, ExclusiveStartKey: start})
+        if err != nil { return err }
+        var page []T
+        if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil { return err }
+        for _, v := range page {
+            if err := fn(v); err != nil { return err }
+        }
+        if len(out.LastEvaluatedKey) == 0 { return nil }
+        start = out.LastEvaluatedKey
+    }
+}
+
+// DeleteByList removes the list's items, trashed ones included, one by one via
+// the list_id index.
+func (r *ListItemRepo) DeleteByList(ctx context.Context, listID string) (int, error) {
+    items, err := r.queryIndex(ctx, itemListIndex, "list_id", listID)
+    if err != nil { return 0, err }
+    return r.deleteAll(ctx, items)
+}
+
+// MoveByList updates the list's items one by one via the list_id index. In a
+// transaction each item is one of its writes.
+func (r *ListItemRepo) MoveByList(ctx context.Context, fromListID, toListID, roomID string, orderOffset float64, updatedAt time.Time) (int, error) {
+    items, err := r.queryIndex(ctx, itemListIndex, "list_id", fromListID)
+    if err != nil { return 0, err }
+    n := 0
+    for _, it := range items {
+        err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+            TableName:        &r.c.Tables.ListItems,
+            Key:              map[string]types.AttributeValue{"item_id": &types.AttributeValueMemberS{Value: it.ItemID}},
+            UpdateExpression: strPtr("SET list_id = :l, room_id = :r, #ord = if_not_exists(#ord, :zero) + :off, updated_at = :ua"),
+            ExpressionAttributeNames: map[string]string{
+                "#ord": "order",
+            },
+            ExpressionAttributeValues: map[string]types.AttributeValue{
+                ":l":    &types.AttributeValueMemberS{Value: toListID},
+                ":r":    &types.AttributeValueMemberS{Value: roomID},
+                ":zero": &types.AttributeValueMemberN{Value: "0"},
+                ":off":  &types.AttributeValueMemberN{Value: strconv.FormatFloat(orderOffset, 'f', -1, 64)},
+                ":ua":   &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+                ":from": &types.AttributeValueMemberS{Value: fromListID},
+            },
+            ConditionExpression: strPtr("list_id = :from"),
+        }, store.AnyVersion))
+        if err != nil {
+            var cce *types.ConditionalCheckFailedException
+            if errors.As(err, &cce) { continue } // deleted or moved concurrently
+            return n, err
+        }
+        n++
+    }
+    return n, nil
+}
+
+// DeleteByRoom removes the room's items one by one via the room_id index.
+func (r *ListItemRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    items, err := r.queryIndex(ctx, itemRoomIndex, "room_id", roomID)
+    if err != nil { return 0, err }
+    return r.deleteAll(ctx, items)
+}
+
+// deleteAll deletes items, skipping ones already gone, and returns how many it removed.
+func (r *ListItemRepo) deleteAll(ctx context.Context, items []models.ListItem) (int, error) {
+    n := 0
+    for _, it := range items {
+        err := r.Delete(ctx, it.ItemID)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return n, err }
+        n++
+    }
+    return n, nil
+}
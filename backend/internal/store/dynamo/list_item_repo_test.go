@@ -0,0 +1,48 @@
+package dynamo
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+    "github.com/janvillarosa/gracie-app/backend/internal/testutil"
+)
+
+func TestListItemRepoFieldsAndArchive(t *testing.T) {
+    db, usersTable, roomsTable, listsTable, itemsTable, cleanup := testutil.SetupDynamoWithListsOrSkip(t)
+    defer cleanup()
+    ctx := context.Background()
+    client := &Client{DB: db, Tables: Tables{Users: usersTable, Rooms: roomsTable, Lists: listsTable, ListItems: itemsTable}}
+    items := NewListItemRepo(client)
+    base := time.Now().UTC().Add(-time.Hour)
+
+    for i, id := range []string{"it_c", "it_a", "it_b"} {
+        it := &models.ListItem{ItemID: id, ListID: "list_1", RoomID: "room_1", Order: float64(3 - i), Description: id, CreatedAt: base, UpdatedAt: base}
+        if err := items.Put(ctx, it); err != nil { t.Fatalf("put %s: %v", id, err) }
+    }
+    got, _, err := items.ListByList(ctx, "list_1", store.Page{})
+    if err != nil { t.Fatalf("list: %v", err) }
+    if len(got) != 3 || got[0].ItemID != "it_b" || got[2].ItemID != "it_c" { t.Fatalf("unexpected order: %+v", got) }
+
+    now := time.Now().UTC()
+    if err := items.UpdateQuantity(ctx, "it_a", "2", store.AnyVersion, now); err != nil { t.Fatalf("qty: %v", err) }
+    if err := items.UpdateUnit(ctx, "it_a", "kg", store.AnyVersion, now); err != nil { t.Fatalf("unit: %v", err) }
+    if err := items.UpdateCategory(ctx, "it_a", "Produce", store.AnyVersion, now); err != nil { t.Fatalf("category: %v", err) }
+    if err := items.UpdateStarred(ctx, "it_a", true, store.AnyVersion, now); err != nil { t.Fatalf("starred: %v", err) }
+    it, err := items.GetByID(ctx, "it_a")
+    if err != nil { t.Fatalf("get: %v", err) }
+    if it.Quantity != "2" || it.Unit != "kg" || it.Category != "Produce" || !it.IsStarred { t.Fatalf("fields not applied: %+v", it) }
+    if err := items.UpdateQuantity(ctx, "missing", "1", store.AnyVersion, now); !errors.Is(err, derr.ErrNotFound) { t.Fatalf("missing item: %v", err) }
+
+    if err := items.UpdateCompletion(ctx, "it_a", true, store.AnyVersion, now); err != nil { t.Fatalf("complete: %v", err) }
+    if err := items.UpdateCompletion(ctx, "it_b", true, store.AnyVersion, now.Add(time.Second)); err != nil { t.Fatalf("complete: %v", err) }
+    if err := items.ArchiveCompletedByList(ctx, "list_1", now.Add(2*time.Second)); err != nil { t.Fatalf("archive: %v", err) }
+    if err := items.UpdateStarred(ctx, "it_b", false, store.AnyVersion, now.Add(3*time.Second)); err != nil { t.Fatalf("touch: %v", err) }
+    archived, _, err := items.ListArchivedByRoom(ctx, "room_1", store.Page{})
+    if err != nil { t.Fatalf("archived: %v", err) }
+    if len(archived) != 2 || archived[0].ItemID != "it_b" || archived[1].ItemID != "it_a" { t.Fatalf("unexpected archived: %+v", archived) }
+}
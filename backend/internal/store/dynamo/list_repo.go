@@ -4,6 +4,7 @@ import (
     "context"
     "errors"
     "fmt"
+    "sort"
     "time"
 
     "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -11,6 +12,7 @@ import (
     "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
 )
 
 const listRoomIndex = "room_id_index"
@@ -22,7 +24,8 @@ func NewListRepo(c *Client) *ListRepo { return &ListRepo{c: c} }
 func (r *ListRepo) Put(ctx context.Context, l *models.List) error {
     item, err := attributevalue.MarshalMap(l)
     if err != nil { return err }
-    _, err = r.c.DB.PutItem(ctx, &dynamodb.PutItemInput{
+    ensureVotesMap(item)
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
         TableName:           &r.c.Tables.Lists,
         Item:                item,
         ConditionExpression: strPtr("attribute_not_exists(list_id)"),
@@ -42,18 +45,14 @@ func (r *ListRepo) GetByID(ctx context.Context, id string) (*models.List, error)
     return &l, nil
 }
 
-func (r *ListRepo) ListByRoom(ctx context.Context, roomID string) ([]models.List, error) {
-    out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
-        TableName:              &r.c.Tables.Lists,
-        IndexName:              strPtr(listRoomIndex),
-        KeyConditionExpression: strPtr("room_id = :rid"),
-        ExpressionAttributeValues: map[string]types.AttributeValue{
-            ":rid": &types.AttributeValueMemberS{Value: roomID},
-        },
-    })
-    if err != nil { return nil, err }
-    var lists []models.List
-    if err := attributevalue.UnmarshalListOfMaps(out.Items, &lists); err != nil { return nil, err }
+// ScanAll scans the whole table page by page, calling fn for each list.
+func (r *ListRepo) ScanAll(ctx context.Context, fn func(l models.List) error) error {
+    return scanTable(ctx, r.c, r.c.Tables.Lists, fn)
+}
+
+func (r *ListRepo) ListByRoom(ctx context.Context, roomID string, page store.Page) ([]models.List, string, error) {
+    lists, err := r.ListByRoomRaw(ctx, roomID)
+    if err != nil { return nil, "", err }
     // Filter out soft-deleted
     filtered := make([]models.List, 0, len(lists))
     for _, l := range lists {
@@ -61,25 +60,36 @@ func (r *ListRepo) ListByRoom(ctx context.Context, roomID string) ([]models.List
             filtered = append(filtered, l)
         }
     }
-    return filtered, nil
+    return store.Paginate(filtered, page, store.ListKey, store.CompareAscending)
 }
 
-// ListByRoomRaw returns all lists for a room, including soft-deleted ones.
+// ListByRoomRaw returns all lists for a room, including soft-deleted ones, oldest first.
 func (r *ListRepo) ListByRoomRaw(ctx context.Context, roomID string) ([]models.List, error) {
-    out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
-        TableName:              &r.c.Tables.Lists,
-        IndexName:              strPtr(listRoomIndex),
-        KeyConditionExpression: strPtr("room_id = :rid"),
-        ExpressionAttributeValues: map[string]types.AttributeValue{":rid": &types.AttributeValueMemberS{Value: roomID}},
-    })
-    if err != nil { return nil, err }
-    var lists []models.List
-    if err := attributevalue.UnmarshalListOfMaps(out.Items, &lists); err != nil { return nil, err }
+    var (
+        lists []models.List
+        start map[string]types.AttributeValue
+    )
+    for {
+        out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
+            TableName:              &r.c.Tables.Lists,
+            IndexName:              strPtr(listRoomIndex),
+            KeyConditionExpression: strPtr("room_id = :rid"),
+            ExpressionAttributeValues: map[string]types.AttributeValue{":rid": &types.AttributeValueMemberS{Value: roomID}},
+            ExclusiveStartKey: start,
+        })
+        if err != nil { return nil, err }
+        var page []models.List
+        if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil { return nil, err }
+        lists = append(lists, page...)
+        if len(out.LastEvaluatedKey) == 0 { break }
+        start = out.LastEvaluatedKey
+    }
+    sort.SliceStable(lists, func(i, j int) bool { return lists[i].CreatedAt.Before(lists[j].CreatedAt) })
     return lists, nil
 }
 
 func (r *ListRepo) AddDeletionVote(ctx context.Context, listID, userID string, ts time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Lists,
         Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         UpdateExpression: strPtr("SET deletion_votes.#u = :ts, updated_at = :ua"),
@@ -89,12 +99,12 @@ func (r *ListRepo) AddDeletionVote(ctx context.Context, listID, userID string, t
             ":ua": &types.AttributeValueMemberS{Value: ts.UTC().Format(time.RFC3339)},
         },
         ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-    })
-    return err
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
 }
 
 func (r *ListRepo) RemoveDeletionVote(ctx context.Context, listID, userID string) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Lists,
         Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         UpdateExpression: strPtr("REMOVE deletion_votes.#u SET updated_at = :ua"),
@@ -103,12 +113,12 @@ func (r *ListRepo) RemoveDeletionVote(ctx context.Context, listID, userID string
             ":ua": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
         },
         ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-    })
-    return err
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
 }
 
-func (r *ListRepo) UpdateName(ctx context.Context, listID string, name string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (r *ListRepo) UpdateName(ctx context.Context, listID string, name string, ifVersion int64, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Lists,
         Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         UpdateExpression: strPtr("SET #n = :nv, updated_at = :ua"),
@@ -118,13 +128,13 @@ func (r *ListRepo) UpdateName(ctx context.Context, listID string, name string, u
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
         ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-    })
-    return err
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
 }
 
-func (r *ListRepo) UpdateDescription(ctx context.Context, listID string, description string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateDescription(ctx context.Context, listID string, description string, ifVersion int64, updatedAt time.Time) error {
     if description == "" {
-        _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
             TableName:        &r.c.Tables.Lists,
             Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
             UpdateExpression: strPtr("REMOVE description SET updated_at = :ua"),
@@ -132,10 +142,10 @@ func (r *ListRepo) UpdateDescription(ctx context.Context, listID string, descrip
                 ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
             },
             ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-        })
-        return err
+        }, ifVersion))
+        return versionFailed(err, notTrashed)
     }
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Lists,
         Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         UpdateExpression: strPtr("SET description = :dv, updated_at = :ua"),
@@ -144,13 +154,13 @@ func (r *ListRepo) UpdateDescription(ctx context.Context, listID string, descrip
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
         ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-    })
-    return err
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
 }
 
-func (r *ListRepo) UpdateNotes(ctx context.Context, listID string, notes string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateNotes(ctx context.Context, listID string, notes string, ifVersion int64, updatedAt time.Time) error {
     if notes == "" {
-        _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
             TableName:        &r.c.Tables.Lists,
             Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
             UpdateExpression: strPtr("REMOVE notes SET updated_at = :ua"),
@@ -158,10 +168,10 @@ func (r *ListRepo) UpdateNotes(ctx context.Context, listID string, notes string,
                 ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
             },
             ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-        })
-        return err
+        }, ifVersion))
+        return versionFailed(err, notTrashed)
     }
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Lists,
         Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         UpdateExpression: strPtr("SET notes = :nv, updated_at = :ua"),
@@ -170,13 +180,13 @@ func (r *ListRepo) UpdateNotes(ctx context.Context, listID string, notes string,
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
         ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-    })
-    return err
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
 }
 
-func (r *ListRepo) UpdateIcon(ctx context.Context, listID string, icon string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateIcon(ctx context.Context, listID string, icon string, ifVersion int64, updatedAt time.Time) error {
     if icon == "" {
-        _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
             TableName:        &r.c.Tables.Lists,
             Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
             UpdateExpression: strPtr("REMOVE icon SET updated_at = :ua"),
@@ -184,10 +194,10 @@ func (r *ListRepo) UpdateIcon(ctx context.Context, listID string, icon string, u
                 ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
             },
             ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-        })
-        return err
+        }, ifVersion))
+        return versionFailed(err, notTrashed)
     }
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Lists,
         Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         UpdateExpression: strPtr("SET icon = :iv, updated_at = :ua"),
@@ -196,21 +206,35 @@ func (r *ListRepo) UpdateIcon(ctx context.Context, listID string, icon string, u
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
         ConditionExpression: strPtr("attribute_exists(list_id) AND attribute_not_exists(is_deleted)"),
-    })
-    return err
+    }, ifVersion))
+    return versionFailed(err, notTrashed)
+}
+
+func (r *ListRepo) Patch(ctx context.Context, listID string, p store.ListPatch, ifVersion int64, updatedAt time.Time) (*models.List, error) {
+    e := newPatchExpr(updatedAt)
+    e.putString("name", p.Name)
+    e.putOptional("description", p.Description)
+    e.putOptional("notes", p.Notes)
+    e.putOptional("icon", p.Icon)
+    key := map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}}
+    item, err := r.c.updateItemNew(ctx, versioned(e.input(&r.c.Tables.Lists, key, "attribute_exists(list_id) AND attribute_not_exists(is_deleted)", nil), ifVersion))
+    if err != nil { return nil, versionFailed(err, notTrashed) }
+    var l models.List
+    if err := attributevalue.UnmarshalMap(item, &l); err != nil { return nil, err }
+    return &l, nil
 }
 
 // FinalizeDeleteIfVotedByAll sets is_deleted=true when votes exist for all memberIDs passed.
 func (r *ListRepo) FinalizeDeleteIfVotedByAll(ctx context.Context, listID string, memberIDs []string, ts time.Time) (bool, error) {
     // Build dynamic ConditionExpression requiring all deletion_votes for memberIDs
     names := map[string]string{}
-    cond := "attribute_not_exists(is_deleted)"
+    cond := "attribute_exists(list_id) AND attribute_not_exists(is_deleted)"
     for i, uid := range memberIDs {
         key := fmt.Sprintf("#u%d", i)
         names[key] = uid
         cond = fmt.Sprintf("%s AND attribute_exists(deletion_votes.%s)", cond, key)
     }
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Lists,
         Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         UpdateExpression: strPtr("SET is_deleted = :true, updated_at = :ua"),
@@ -221,7 +245,7 @@ func (r *ListRepo) FinalizeDeleteIfVotedByAll(ctx context.Context, listID string
         ExpressionAttributeNames: names,
         ConditionExpression:      &cond,
         ReturnValues:             types.ReturnValueNone,
-    })
+    }, store.AnyVersion))
     if err != nil {
         var cce *types.ConditionalCheckFailedException
         if errors.As(err, &cce) { return false, nil }
@@ -230,11 +254,93 @@ func (r *ListRepo) FinalizeDeleteIfVotedByAll(ctx context.Context, listID string
     return true, nil
 }
 
+// Restore clears is_deleted and the deletion votes of a soft-deleted list.
+func (r *ListRepo) Restore(ctx context.Context, listID string, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Lists,
+        Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
+        UpdateExpression: strPtr("REMOVE is_deleted SET deletion_votes = :empty, updated_at = :ua"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+            ":ua":    &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+        },
+        ConditionExpression: strPtr("attribute_exists(is_deleted)"),
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
+}
+
+func (r *ListRepo) MoveToRoom(ctx context.Context, listID string, roomID string, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Lists,
+        Key:              map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
+        UpdateExpression: strPtr("SET room_id = :r, deletion_votes = :empty, updated_at = :ua"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":r":     &types.AttributeValueMemberS{Value: roomID},
+            ":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+            ":ua":    &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+        },
+        ConditionExpression: strPtr("attribute_exists(list_id)"),
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
+}
+
+// ListTrashedByRoom returns the room's soft-deleted lists, most recently deleted first.
+func (r *ListRepo) ListTrashedByRoom(ctx context.Context, roomID string) ([]models.List, error) {
+    lists, err := r.ListByRoomRaw(ctx, roomID)
+    if err != nil { return nil, err }
+    out := make([]models.List, 0, len(lists))
+    for _, l := range lists {
+        if l.IsDeleted { out = append(out, l) }
+    }
+    sort.SliceStable(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+    return out, nil
+}
+
+// ListDeletedBefore scans for soft-deleted lists. updated_at is compared in Go
+// because stored timestamps mix RFC3339 precisions and do not sort as strings.
+func (r *ListRepo) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.List, error) {
+    var (
+        out   []models.List
+        start map[string]types.AttributeValue
+    )
+    for {
+        page, err := r.c.DB.Scan(ctx, &dynamodb.ScanInput{
+            TableName:         &r.c.Tables.Lists,
+            FilterExpression:  strPtr("attribute_exists(is_deleted)"),
+            ExclusiveStartKey: start,
+        })
+        if err != nil { return nil, err }
+        var lists []models.List
+        if err := attributevalue.UnmarshalListOfMaps(page.Items, &lists); err != nil { return nil, err }
+        for _, l := range lists {
+            if l.IsDeleted && l.UpdatedAt.Before(cutoff) { out = append(out, l) }
+        }
+        if len(page.LastEvaluatedKey) == 0 { break }
+        start = page.LastEvaluatedKey
+    }
+    sort.SliceStable(out, func(i, j int) bool { return out[i].UpdatedAt.Before(out[j].UpdatedAt) })
+    return out, nil
+}
+
 func (r *ListRepo) Delete(ctx context.Context, listID string) error {
-    _, err := r.c.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+    err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
         TableName: &r.c.Tables.Lists,
         Key:       map[string]types.AttributeValue{"list_id": &types.AttributeValueMemberS{Value: listID}},
         ConditionExpression: strPtr("attribute_exists(list_id)"),
     })
-    return err
+    return notFoundIfConditionFailed(err)
+}
+
+// DeleteByRoom removes the room's lists, soft-deleted ones included, one by one.
+func (r *ListRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    lists, err := r.ListByRoomRaw(ctx, roomID)
+    if err != nil { return 0, err }
+    n := 0
+    for _, l := range lists {
+        err := r.Delete(ctx, l.ListID)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return n, err }
+        n++
+    }
+    return n, nil
 }
@@ -0,0 +1,46 @@
+package dynamo
+
+import (
+    "context"
+    "sort"
+
+    "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// MigrationRepo records applied data migrations in the Migrations table
+// (hash key "version", a number).
+type MigrationRepo struct{ c *Client }
+
+func NewMigrationRepo(c *Client) *MigrationRepo { return &MigrationRepo{c: c} }
+
+func (r *MigrationRepo) ListApplied(ctx context.Context) ([]models.MigrationRecord, error) {
+    var (
+        out   []models.MigrationRecord
+        start map[string]types.AttributeValue
+    )
+    for {
+        res, err := r.c.DB.Scan(ctx, &dynamodb.ScanInput{TableName: &r.c.Tables.Migrations, ExclusiveStartKey: start})
+        if err != nil { return nil, err }
+        var page []models.MigrationRecord
+        if err := attributevalue.UnmarshalListOfMaps(res.Items, &page); err != nil { return nil, err }
+        out = append(out, page...)
+        if len(res.LastEvaluatedKey) == 0 { break }
+        start = res.LastEvaluatedKey
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+    return out, nil
+}
+
+func (r *MigrationRepo) Record(ctx context.Context, rec models.MigrationRecord) error {
+    item, err := attributevalue.MarshalMap(rec)
+    if err != nil { return err }
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
+        TableName:           &r.c.Tables.Migrations,
+        Item:                item,
+        ConditionExpression: strPtr("attribute_not_exists(version)"),
+    })
+    return conflictIfConditionFailed(err)
+}
@@ -0,0 +1,72 @@
+package dynamo
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// patchExpr builds the update expression of a Patch: updated_at plus every
+// patched attribute, each under its own placeholder so reserved words such as
+// "name" need no special casing.
+type patchExpr struct {
+    set    []string
+    remove []string
+    names  map[string]string
+    values map[string]types.AttributeValue
+}
+
+func newPatchExpr(updatedAt time.Time) *patchExpr {
+    return &patchExpr{
+        set:    []string{"updated_at = :ua"},
+        names:  map[string]string{},
+        values: map[string]types.AttributeValue{":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)}},
+    }
+}
+
+func (e *patchExpr) put(attr string, v types.AttributeValue) {
+    n := len(e.names)
+    e.names[fmt.Sprintf("#p%d", n)] = attr
+    e.values[fmt.Sprintf(":p%d", n)] = v
+    e.set = append(e.set, fmt.Sprintf("#p%d = :p%d", n, n))
+}
+
+func (e *patchExpr) putString(attr string, v *string) {
+    if v != nil { e.put(attr, &types.AttributeValueMemberS{Value: *v}) }
+}
+
+func (e *patchExpr) putBool(attr string, v *bool) {
+    if v != nil { e.put(attr, &types.AttributeValueMemberBOOL{Value: *v}) }
+}
+
+// putOptional stores v, or removes the attribute when v is empty.
+func (e *patchExpr) putOptional(attr string, v *string) {
+    switch {
+    case v == nil:
+    case *v == "":
+        n := len(e.names)
+        e.names[fmt.Sprintf("#p%d", n)] = attr
+        e.remove = append(e.remove, fmt.Sprintf("#p%d", n))
+    default:
+        e.putString(attr, v)
+    }
+}
+
+// input returns the UpdateItemInput applying the patch to key under cond.
+func (e *patchExpr) input(table *string, key map[string]types.AttributeValue, cond string, values map[string]types.AttributeValue) *dynamodb.UpdateItemInput {
+    expr := "SET " + strings.Join(e.set, ", ")
+    if len(e.remove) > 0 { expr += " REMOVE " + strings.Join(e.remove, ", ") }
+    for k, v := range values { e.values[k] = v }
+    in := &dynamodb.UpdateItemInput{
+        TableName:                 table,
+        Key:                       key,
+        UpdateExpression:          &expr,
+        ConditionExpression:       &cond,
+        ExpressionAttributeValues: e.values,
+    }
+    if len(e.names) > 0 { in.ExpressionAttributeNames = e.names }
+    return in
+}
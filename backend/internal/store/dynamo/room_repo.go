@@ -2,6 +2,10 @@ package dynamo
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
     "time"
 
     "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -9,6 +13,7 @@ import (
     "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
 )
 
 type RoomRepo struct {
@@ -22,7 +27,9 @@ func (r *RoomRepo) Put(ctx context.Context, room *models.Room) error {
     if err != nil {
         return err
     }
-    _, err = r.c.DB.PutItem(ctx, &dynamodb.PutItemInput{
+    ensureVotesMap(item)
+    ensureRolesMap(item)
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
         TableName:           &r.c.Tables.Rooms,
         Item:                item,
         ConditionExpression: strPtr("attribute_not_exists(room_id)"),
@@ -50,6 +57,11 @@ func (r *RoomRepo) GetByID(ctx context.Context, id string) (*models.Room, error)
     return &rm, nil
 }
 
+// ScanAll scans the whole table page by page, calling fn for each room.
+func (r *RoomRepo) ScanAll(ctx context.Context, fn func(rm models.Room) error) error {
+    return scanTable(ctx, r.c, r.c.Tables.Rooms, fn)
+}
+
 func (r *RoomRepo) GetByShareToken(ctx context.Context, token string) (*models.Room, error) {
     idx := "share_token_index"
     out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
@@ -75,7 +87,7 @@ func (r *RoomRepo) GetByShareToken(ctx context.Context, token string) (*models.R
 }
 
 func (r *RoomRepo) SetShareToken(ctx context.Context, roomID string, userID string, token string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Rooms,
         Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
         UpdateExpression: strPtr("SET share_token = :tok, updated_at = :ua"),
@@ -85,24 +97,25 @@ func (r *RoomRepo) SetShareToken(ctx context.Context, roomID string, userID stri
             ":ua":  &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
             ":uid": &types.AttributeValueMemberS{Value: userID},
         },
-    })
-    return err
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
 }
 
 func (r *RoomRepo) RemoveShareToken(ctx context.Context, roomID string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Rooms,
         Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
         UpdateExpression: strPtr("REMOVE share_token SET updated_at = :ua"),
+        ConditionExpression: strPtr("attribute_exists(room_id)"),
         ExpressionAttributeValues: map[string]types.AttributeValue{
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
-    })
-    return err
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
 }
 
 func (r *RoomRepo) VoteDeletion(ctx context.Context, roomID string, userID string, ts time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Rooms,
         Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
         UpdateExpression: strPtr("SET deletion_votes.#u = :ts, updated_at = :ua"),
@@ -115,13 +128,13 @@ func (r *RoomRepo) VoteDeletion(ctx context.Context, roomID string, userID strin
             ":uid": &types.AttributeValueMemberS{Value: userID},
         },
         ConditionExpression: strPtr("attribute_exists(room_id) AND contains(member_ids, :uid)"),
-    })
-    return err
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
 }
 
-func (r *RoomRepo) UpdateDescription(ctx context.Context, roomID string, userID string, description string, updatedAt time.Time) error {
+func (r *RoomRepo) UpdateDescription(ctx context.Context, roomID string, userID string, description string, ifVersion int64, updatedAt time.Time) error {
     if description == "" {
-        _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+        err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
             TableName:        &r.c.Tables.Rooms,
             Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
             UpdateExpression: strPtr("REMOVE description SET updated_at = :ua"),
@@ -130,10 +143,10 @@ func (r *RoomRepo) UpdateDescription(ctx context.Context, roomID string, userID
                 ":uid": &types.AttributeValueMemberS{Value: userID},
             },
             ConditionExpression: strPtr("contains(member_ids, :uid)"),
-        })
-        return err
+        }, ifVersion))
+        return versionFailed(err, hasMember(userID))
     }
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Rooms,
         Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
         UpdateExpression: strPtr("SET description = :d, updated_at = :ua"),
@@ -143,12 +156,12 @@ func (r *RoomRepo) UpdateDescription(ctx context.Context, roomID string, userID
             ":uid": &types.AttributeValueMemberS{Value: userID},
         },
         ConditionExpression: strPtr("contains(member_ids, :uid)"),
-    })
-    return err
+    }, ifVersion))
+    return versionFailed(err, hasMember(userID))
 }
 
-func (r *RoomRepo) UpdateDisplayName(ctx context.Context, roomID string, userID string, displayName string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (r *RoomRepo) UpdateDisplayName(ctx context.Context, roomID string, userID string, displayName string, ifVersion int64, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Rooms,
         Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
         UpdateExpression: strPtr("SET display_name = :n, updated_at = :ua"),
@@ -158,6 +171,175 @@ func (r *RoomRepo) UpdateDisplayName(ctx context.Context, roomID string, userID
             ":uid": &types.AttributeValueMemberS{Value: userID},
         },
         ConditionExpression: strPtr("contains(member_ids, :uid)"),
+    }, ifVersion))
+    return versionFailed(err, hasMember(userID))
+}
+
+func (r *RoomRepo) Patch(ctx context.Context, roomID string, userID string, p store.RoomPatch, ifVersion int64, updatedAt time.Time) (*models.Room, error) {
+    e := newPatchExpr(updatedAt)
+    e.putString("display_name", p.DisplayName)
+    e.putOptional("description", p.Description)
+    key := map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}}
+    in := e.input(&r.c.Tables.Rooms, key, "contains(member_ids, :uid)", map[string]types.AttributeValue{":uid": &types.AttributeValueMemberS{Value: userID}})
+    item, err := r.c.updateItemNew(ctx, versioned(in, ifVersion))
+    if err != nil { return nil, versionFailed(err, hasMember(userID)) }
+    var rm models.Room
+    if err := attributevalue.UnmarshalMap(item, &rm); err != nil { return nil, err }
+    return &rm, nil
+}
+
+func (r *RoomRepo) RemoveDeletionVote(ctx context.Context, roomID string, userID string) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Rooms,
+        Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
+        UpdateExpression: strPtr("REMOVE deletion_votes.#u"),
+        ExpressionAttributeNames: map[string]string{
+            "#u": userID,
+        },
+        ConditionExpression: strPtr("attribute_exists(room_id)"),
+    }, store.AnyVersion))
+    return notFoundIfConditionFailed(err)
+}
+
+func (r *RoomRepo) Delete(ctx context.Context, roomID string) error {
+    err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
+        TableName:           &r.c.Tables.Rooms,
+        Key:                 map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
+        ConditionExpression: strPtr("attribute_exists(room_id)"),
     })
+    return notFoundIfConditionFailed(err)
+}
+
+// AddMember appends userID to member_ids. Adding an existing member is a conflict.
+func (r *RoomRepo) AddMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Rooms,
+        Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
+        UpdateExpression: strPtr("SET member_ids = list_append(member_ids, :m), updated_at = :ua"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":m":   &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: userID}}},
+            ":ua":  &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+            ":uid": &types.AttributeValueMemberS{Value: userID},
+        },
+        ConditionExpression: strPtr("attribute_exists(room_id) AND NOT contains(member_ids, :uid)"),
+    }, store.AnyVersion))
+    var cce *types.ConditionalCheckFailedException
+    if err != nil && errors.As(err, &cce) {
+        // Either the room is missing or userID is already a member.
+        if _, err := r.GetByID(ctx, roomID); err != nil {
+            return err
+        }
+        return derr.ErrConflict
+    }
+    return err
+}
+
+// RemoveMember removes userID from member_ids. Lists cannot be updated by value, so the
+// member's index is read first and the removal is guarded on it still holding userID.
+func (r *RoomRepo) RemoveMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error {
+    rm, err := r.GetByID(ctx, roomID)
+    if err != nil {
+        return err
+    }
+    idx := -1
+    for i, mid := range rm.MemberIDs {
+        if mid == userID {
+            idx = i
+            break
+        }
+    }
+    if idx < 0 {
+        return derr.ErrNotFound
+    }
+    remove := fmt.Sprintf("REMOVE member_ids[%d]", idx)
+    var names map[string]string
+    // Rooms stored before roles have no roles map to remove from.
+    if rm.Roles != nil {
+        remove += ", roles.#u"
+        names = map[string]string{"#u": userID}
+    }
+    err = r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Rooms,
+        Key:              map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
+        UpdateExpression: strPtr(remove + " SET updated_at = :ua"),
+        ExpressionAttributeNames: names,
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":ua":  &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+            ":uid": &types.AttributeValueMemberS{Value: userID},
+        },
+        ConditionExpression: strPtr(fmt.Sprintf("member_ids[%d] = :uid", idx)),
+    }, store.AnyVersion))
+    var cce *types.ConditionalCheckFailedException
+    if err != nil && errors.As(err, &cce) {
+        return derr.ErrConflict
+    }
     return err
 }
+
+// SetRoles writes roles.<userID> for each member in roles. Rooms stored
+// before roles have no roles map to write into; theirs is set whole, guarded
+// on it still being absent.
+func (r *RoomRepo) SetRoles(ctx context.Context, roomID string, roles map[string]models.Role, updatedAt time.Time) error {
+    rm, err := r.GetByID(ctx, roomID)
+    if err != nil {
+        return err
+    }
+    names := map[string]string{}
+    values := map[string]types.AttributeValue{
+        ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+    }
+    var sets, conds []string
+    whole := map[string]types.AttributeValue{}
+    i := 0
+    for uid, role := range roles {
+        if !slices.Contains(rm.MemberIDs, uid) {
+            return derr.ErrNotFound
+        }
+        names[fmt.Sprintf("#u%d", i)] = uid
+        values[fmt.Sprintf(":u%d", i)] = &types.AttributeValueMemberS{Value: uid}
+        values[fmt.Sprintf(":r%d", i)] = &types.AttributeValueMemberS{Value: string(role)}
+        whole[uid] = values[fmt.Sprintf(":r%d", i)]
+        sets = append(sets, fmt.Sprintf("roles.#u%d = :r%d", i, i))
+        conds = append(conds, fmt.Sprintf("contains(member_ids, :u%d)", i))
+        i++
+    }
+    if rm.Roles == nil {
+        for j := 0; j < i; j++ { delete(values, fmt.Sprintf(":r%d", j)) }
+        values[":roles"] = &types.AttributeValueMemberM{Value: whole}
+        sets, names = []string{"roles = :roles"}, nil
+        conds = append(conds, "attribute_not_exists(roles)")
+    }
+    err = r.c.updateItem(ctx, versioned(&dynamodb.UpdateItemInput{
+        TableName:                 &r.c.Tables.Rooms,
+        Key:                       map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: roomID}},
+        UpdateExpression:          strPtr("SET " + strings.Join(sets, ", ") + ", updated_at = :ua"),
+        ExpressionAttributeNames:  names,
+        ExpressionAttributeValues: values,
+        ConditionExpression:       strPtr(strings.Join(conds, " AND ")),
+    }, store.AnyVersion))
+    var cce *types.ConditionalCheckFailedException
+    if err != nil && errors.As(err, &cce) {
+        // A member left, or the room was deleted, since it was read.
+        return derr.ErrConflict
+    }
+    return err
+}
+
+// ensureRolesMap stores an empty roles map when the model has none, for the
+// same reason as ensureVotesMap.
+func ensureRolesMap(item map[string]types.AttributeValue) {
+    if _, ok := item["roles"].(*types.AttributeValueMemberM); !ok {
+        item["roles"] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}
+    }
+}
+
+// ensureVotesMap stores an empty deletion_votes map when the model has none, so
+// later "SET deletion_votes.#u" updates have a parent document to write into.
+func ensureVotesMap(item map[string]types.AttributeValue) {
+    if v, ok := item["deletion_votes"]; ok {
+        if _, isMap := v.(*types.AttributeValueMemberM); isMap {
+            return
+        }
+    }
+    item["deletion_votes"] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}
+}
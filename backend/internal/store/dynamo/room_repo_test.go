@@ -2,9 +2,11 @@ package dynamo
 
 import (
     "context"
+    "errors"
     "testing"
     "time"
 
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
     "github.com/janvillarosa/gracie-app/backend/internal/testutil"
 )
@@ -26,3 +28,64 @@ func TestRoomRepoBasics(t *testing.T) {
     if err := rooms.RemoveShareToken(context.Background(), rm.RoomID, time.Now().UTC()); err != nil { t.Fatalf("remove tok: %v", err) }
     if err := rooms.VoteDeletion(context.Background(), rm.RoomID, "usrA", time.Now().UTC()); err != nil { t.Fatalf("vote: %v", err) }
 }
+
+func TestRoomRepoMembership(t *testing.T) {
+    db, usersTable, roomsTable, cleanup := testutil.SetupDynamoOrSkip(t)
+    defer cleanup()
+    ctx := context.Background()
+    client := &Client{DB: db, Tables: Tables{Users: usersTable, Rooms: roomsTable}}
+    rooms := NewRoomRepo(client)
+    now := time.Now().UTC()
+    // No DeletionVotes on the model: Put must still allow nested vote updates.
+    rm := &models.Room{RoomID: "room_ut_2", MemberIDs: []string{"usrA"}, CreatedAt: now, UpdatedAt: now}
+    if err := rooms.Put(ctx, rm); err != nil { t.Fatalf("put room: %v", err) }
+
+    if err := rooms.AddMember(ctx, rm.RoomID, "usrB", now); err != nil { t.Fatalf("add member: %v", err) }
+    if err := rooms.AddMember(ctx, rm.RoomID, "usrB", now); !errors.Is(err, derr.ErrConflict) { t.Fatalf("add dup member: %v", err) }
+    if err := rooms.VoteDeletion(ctx, rm.RoomID, "usrB", now); err != nil { t.Fatalf("vote: %v", err) }
+    if err := rooms.RemoveDeletionVote(ctx, rm.RoomID, "usrB"); err != nil { t.Fatalf("remove vote: %v", err) }
+    if err := rooms.RemoveMember(ctx, rm.RoomID, "usrA", now); err != nil { t.Fatalf("remove member: %v", err) }
+    got, err := rooms.GetByID(ctx, rm.RoomID)
+    if err != nil { t.Fatalf("get: %v", err) }
+    if len(got.MemberIDs) != 1 || got.MemberIDs[0] != "usrB" || len(got.DeletionVotes) != 0 { t.Fatalf("unexpected room: %+v", got) }
+    if err := rooms.RemoveMember(ctx, rm.RoomID, "usrA", now); !errors.Is(err, derr.ErrNotFound) { t.Fatalf("remove missing member: %v", err) }
+
+    if err := rooms.Delete(ctx, rm.RoomID); err != nil { t.Fatalf("delete: %v", err) }
+    if _, err := rooms.GetByID(ctx, rm.RoomID); !errors.Is(err, derr.ErrNotFound) { t.Fatalf("get deleted: %v", err) }
+}
+
+func TestTxMergesUpdatesOnSameRoom(t *testing.T) {
+    db, usersTable, roomsTable, cleanup := testutil.SetupDynamoOrSkip(t)
+    defer cleanup()
+    ctx := context.Background()
+    client := &Client{DB: db, Tables: Tables{Users: usersTable, Rooms: roomsTable}}
+    rooms := NewRoomRepo(client)
+    users := NewUserRepo(client)
+    tx := NewTx(client)
+    now := time.Now().UTC()
+    tok := "TOKEN"
+    rm := &models.Room{RoomID: "room_ut_3", MemberIDs: []string{"usrA"}, ShareToken: &tok, CreatedAt: now, UpdatedAt: now}
+    if err := rooms.Put(ctx, rm); err != nil { t.Fatalf("put room: %v", err) }
+    if err := users.Put(ctx, &models.User{UserID: "usrB", Name: "B", APIKeyLookup: "lk_b", CreatedAt: now, UpdatedAt: now}); err != nil { t.Fatalf("put user: %v", err) }
+
+    // Same shape as RoomService.JoinRoom: two updates of one room plus a user update.
+    err := tx.WithTransaction(ctx, func(txctx context.Context) error {
+        if err := rooms.AddMember(txctx, rm.RoomID, "usrB", now); err != nil { return err }
+        if err := rooms.RemoveShareToken(txctx, rm.RoomID, now); err != nil { return err }
+        return users.SetRoomID(txctx, "usrB", &rm.RoomID, now)
+    })
+    if err != nil { t.Fatalf("tx: %v", err) }
+    got, _ := rooms.GetByID(ctx, rm.RoomID)
+    if len(got.MemberIDs) != 2 || got.ShareToken != nil { t.Fatalf("unexpected room: %+v", got) }
+    u, _ := users.GetByID(ctx, "usrB")
+    if u.RoomID == nil || *u.RoomID != rm.RoomID { t.Fatalf("room not set on user: %+v", u) }
+
+    // A failed condition rolls back every write in the transaction.
+    err = tx.WithTransaction(ctx, func(txctx context.Context) error {
+        if err := users.SetRoomID(txctx, "usrB", nil, now); err != nil { return err }
+        return rooms.AddMember(txctx, rm.RoomID, "usrB", now)
+    })
+    if !errors.Is(err, derr.ErrConflict) { t.Fatalf("expected conflict, got %v", err) }
+    u, _ = users.GetByID(ctx, "usrB")
+    if u.RoomID == nil { t.Fatalf("user update was not rolled back") }
+}
@@ -0,0 +1,166 @@
+package dynamo
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// GSIs of the Sessions table (see cmd/setup-ddb).
+const (
+    sessionLookupIndex = "key_lookup_index"
+    sessionUserIndex   = "user_id_index"
+)
+
+// SessionRepo stores sessions in the Sessions table (hash key "session_id").
+type SessionRepo struct{ c *Client }
+
+func NewSessionRepo(c *Client) *SessionRepo { return &SessionRepo{c: c} }
+
+func sessionKey(id string) map[string]types.AttributeValue {
+    return map[string]types.AttributeValue{"session_id": &types.AttributeValueMemberS{Value: id}}
+}
+
+func (r *SessionRepo) Create(ctx context.Context, s *models.Session) error {
+    item, err := attributevalue.MarshalMap(s)
+    if err != nil { return err }
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
+        TableName:           &r.c.Tables.Sessions,
+        Item:                item,
+        ConditionExpression: strPtr("attribute_not_exists(session_id)"),
+    })
+    return conflictIfConditionFailed(err)
+}
+
+func (r *SessionRepo) GetByID(ctx context.Context, id string) (*models.Session, error) {
+    out, err := r.c.DB.GetItem(ctx, &dynamodb.GetItemInput{TableName: &r.c.Tables.Sessions, Key: sessionKey(id)})
+    if err != nil { return nil, err }
+    if len(out.Item) == 0 { return nil, derr.ErrNotFound }
+    var s models.Session
+    if err := attributevalue.UnmarshalMap(out.Item, &s); err != nil { return nil, err }
+    return &s, nil
+}
+
+// GetByKeyLookup reads the lookup GSI, which is eventually consistent: a
+// session created a moment ago may not be found yet.
+func (r *SessionRepo) GetByKeyLookup(ctx context.Context, lookup string) (*models.Session, error) {
+    ss, err := r.queryIndex(ctx, sessionLookupIndex, "key_lookup", lookup)
+    if err != nil { return nil, err }
+    if len(ss) == 0 { return nil, derr.ErrNotFound }
+    return &ss[0], nil
+}
+
+func (r *SessionRepo) ListByUser(ctx context.Context, userID string) ([]models.Session, error) {
+    ss, err := r.queryIndex(ctx, sessionUserIndex, "user_id", userID)
+    if err != nil { return nil, err }
+    sort.Slice(ss, func(i, j int) bool {
+        if !ss[i].LastUsedAt.Equal(ss[j].LastUsedAt) { return ss[i].LastUsedAt.After(ss[j].LastUsedAt) }
+        return ss[i].SessionID < ss[j].SessionID
+    })
+    return ss, nil
+}
+
+func (r *SessionRepo) queryIndex(ctx context.Context, index, attr, value string) ([]models.Session, error) {
+    var (
+        out   []models.Session
+        start map[string]types.AttributeValue
+    )
+    for {
+        page, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
+            TableName:                 &r.c.Tables.Sessions,
+            IndexName:                 strPtr(index),
+            KeyConditionExpression:    strPtr("#k = :v"),
+            ExpressionAttributeNames:  map[string]string{"#k": attr},
+            ExpressionAttributeValues: map[string]types.AttributeValue{":v": &types.AttributeValueMemberS{Value: value}},
+            ExclusiveStartKey:         start,
+        })
+        if err != nil { return nil, err }
+        var ss []models.Session
+        if err := attributevalue.UnmarshalListOfMaps(page.Items, &ss); err != nil { return nil, err }
+        out = append(out, ss...)
+        if len(page.LastEvaluatedKey) == 0 { return out, nil }
+        start = page.LastEvaluatedKey
+    }
+}
+
+func (r *SessionRepo) Touch(ctx context.Context, sessionID string, lastUsedAt time.Time) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                 &r.c.Tables.Sessions,
+        Key:                       sessionKey(sessionID),
+        ConditionExpression:       strPtr("attribute_exists(session_id)"),
+        UpdateExpression:          strPtr("SET last_used_at = :t"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{":t": timeAV(lastUsedAt)},
+    })
+    return notFoundIfConditionFailed(err)
+}
+
+func (r *SessionRepo) Rotate(ctx context.Context, sessionID string, gen int64, lastUsedAt time.Time, expiresAt *time.Time) error {
+    update := "SET refresh_gen = refresh_gen + :one, last_used_at = :t REMOVE expires_at"
+    eav := map[string]types.AttributeValue{
+        ":one": &types.AttributeValueMemberN{Value: "1"},
+        ":gen": &types.AttributeValueMemberN{Value: strconv.FormatInt(gen, 10)},
+        ":t":   timeAV(lastUsedAt),
+    }
+    if expiresAt != nil {
+        update = "SET refresh_gen = refresh_gen + :one, last_used_at = :t, expires_at = :exp"
+        eav[":exp"] = timeAV(*expiresAt)
+    }
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                 &r.c.Tables.Sessions,
+        Key:                       sessionKey(sessionID),
+        ConditionExpression:       strPtr("refresh_gen = :gen"),
+        UpdateExpression:          strPtr(update),
+        ExpressionAttributeValues: eav,
+    })
+    var cce *types.ConditionalCheckFailedException
+    if !errors.As(err, &cce) { return err }
+    if _, err := r.GetByID(ctx, sessionID); err != nil { return err }
+    return derr.ErrConflict
+}
+
+func (r *SessionRepo) Delete(ctx context.Context, sessionID string) error {
+    err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
+        TableName:           &r.c.Tables.Sessions,
+        Key:                 sessionKey(sessionID),
+        ConditionExpression: strPtr("attribute_exists(session_id)"),
+    })
+    return notFoundIfConditionFailed(err)
+}
+
+func (r *SessionRepo) DeleteByUser(ctx context.Context, userID string) (int, error) {
+    ss, err := r.queryIndex(ctx, sessionUserIndex, "user_id", userID)
+    if err != nil { return 0, err }
+    return r.deleteAll(ctx, ss)
+}
+
+// DeleteExpired scans the table; expiry is compared in Go because stored
+// timestamps do not sort as strings.
+func (r *SessionRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    var expired []models.Session
+    err := scanTable(ctx, r.c, r.c.Tables.Sessions, func(s models.Session) error {
+        if s.ExpiresAt != nil && s.ExpiresAt.Before(cutoff) { expired = append(expired, s) }
+        return nil
+    })
+    if err != nil { return 0, err }
+    return r.deleteAll(ctx, expired)
+}
+
+// deleteAll deletes sessions, skipping ones already gone, and returns how many it removed.
+func (r *SessionRepo) deleteAll(ctx context.Context, ss []models.Session) (int, error) {
+    n := 0
+    for _, s := range ss {
+        err := r.Delete(ctx, s.SessionID)
+        if errors.Is(err, derr.ErrNotFound) { continue }
+        if err != nil { return n, err }
+        n++
+    }
+    return n, nil
+}
@@ -0,0 +1,366 @@
+package dynamo
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// maxTxItems is the TransactWriteItems limit on operations per request.
+const maxTxItems = 100
+
+// Tx implements store.TxRunner on top of TransactWriteItems.
+//
+// Writes issued through the repositories with the context passed to fn are
+// buffered instead of executed, then committed together when fn returns nil.
+// Reads inside fn are not part of the transaction and observe the state from
+// before any buffered write.
+type Tx struct{ c *Client }
+
+func NewTx(c *Client) *Tx { return &Tx{c: c} }
+
+type txKey struct{}
+
+// txBatch collects the writes of one WithTransaction call.
+type txBatch struct {
+    ops []*txOp
+}
+
+type txOp struct {
+    table  string
+    key    string
+    put    *types.Put
+    update *types.Update
+    delete *types.Delete
+}
+
+func (t *Tx) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+    if _, ok := ctx.Value(txKey{}).(*txBatch); ok {
+        // Nested call: join the outer transaction.
+        return fn(ctx)
+    }
+    b := &txBatch{}
+    if err := fn(context.WithValue(ctx, txKey{}, b)); err != nil {
+        return err
+    }
+    if len(b.ops) == 0 {
+        return nil
+    }
+    if len(b.ops) > maxTxItems {
+        return fmt.Errorf("dynamo tx: %d writes exceeds the limit of %d", len(b.ops), maxTxItems)
+    }
+    items := make([]types.TransactWriteItem, 0, len(b.ops))
+    for _, op := range b.ops {
+        items = append(items, types.TransactWriteItem{Put: op.put, Update: op.update, Delete: op.delete})
+    }
+    _, err := t.c.DB.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+    if err != nil {
+        var tce *types.TransactionCanceledException
+        if errors.As(err, &tce) {
+            for _, reason := range tce.CancellationReasons {
+                if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+                    return derr.ErrConflict
+                }
+            }
+        }
+        return err
+    }
+    return nil
+}
+
+// Ensure Tx implements store.TxRunner
+var _ store.TxRunner = (*Tx)(nil)
+
+func batchFrom(ctx context.Context) *txBatch {
+    b, _ := ctx.Value(txKey{}).(*txBatch)
+    return b
+}
+
+// putItem executes the put, or buffers it when ctx carries a transaction.
+func (c *Client) putItem(ctx context.Context, in *dynamodb.PutItemInput) error {
+    if b := batchFrom(ctx); b != nil {
+        return b.add(&txOp{table: *in.TableName, key: keyString(c.keyOf(*in.TableName, in.Item)), put: &types.Put{
+            TableName:                 in.TableName,
+            Item:                      in.Item,
+            ConditionExpression:       in.ConditionExpression,
+            ExpressionAttributeNames:  in.ExpressionAttributeNames,
+            ExpressionAttributeValues: in.ExpressionAttributeValues,
+        }})
+    }
+    _, err := c.DB.PutItem(ctx, in)
+    return err
+}
+
+// updateItem executes the update, or buffers it when ctx carries a transaction.
+func (c *Client) updateItem(ctx context.Context, in *dynamodb.UpdateItemInput) error {
+    if b := batchFrom(ctx); b != nil {
+        return b.add(&txOp{table: *in.TableName, key: keyString(in.Key), update: &types.Update{
+            TableName:                 in.TableName,
+            Key:                       in.Key,
+            UpdateExpression:          in.UpdateExpression,
+            ConditionExpression:       in.ConditionExpression,
+            ExpressionAttributeNames:  in.ExpressionAttributeNames,
+            ExpressionAttributeValues: in.ExpressionAttributeValues,
+        }})
+    }
+    _, err := c.DB.UpdateItem(ctx, in)
+    return err
+}
+
+// updateItemNew executes the update and returns the item as written. The
+// caller needs that result at once, so it cannot be buffered in a transaction.
+func (c *Client) updateItemNew(ctx context.Context, in *dynamodb.UpdateItemInput) (map[string]types.AttributeValue, error) {
+    if batchFrom(ctx) != nil {
+        return nil, errors.New("dynamo tx: an update returning the written item cannot join a transaction")
+    }
+    in.ReturnValues = types.ReturnValueAllNew
+    out, err := c.DB.UpdateItem(ctx, in)
+    if err != nil {
+        return nil, err
+    }
+    return out.Attributes, nil
+}
+
+// deleteItem executes the delete, or buffers it when ctx carries a transaction.
+func (c *Client) deleteItem(ctx context.Context, in *dynamodb.DeleteItemInput) error {
+    if b := batchFrom(ctx); b != nil {
+        return b.add(&txOp{table: *in.TableName, key: keyString(in.Key), delete: &types.Delete{
+            TableName:                 in.TableName,
+            Key:                       in.Key,
+            ConditionExpression:       in.ConditionExpression,
+            ExpressionAttributeNames:  in.ExpressionAttributeNames,
+            ExpressionAttributeValues: in.ExpressionAttributeValues,
+        }})
+    }
+    _, err := c.DB.DeleteItem(ctx, in)
+    return err
+}
+
+// add appends op to the batch. TransactWriteItems rejects two operations on
+// the same item, so a second update of an item already in the batch is folded
+// into the first one and a delete replaces pending updates; any other
+// combination is refused.
+func (b *txBatch) add(op *txOp) error {
+    for _, prev := range b.ops {
+        if prev.table != op.table || prev.key != op.key {
+            continue
+        }
+        if prev.update != nil && op.delete != nil {
+            prev.update, prev.delete = nil, op.delete
+            return nil
+        }
+        if prev.update != nil && op.update != nil {
+            merged, err := mergeUpdates(prev.update, op.update, len(b.ops))
+            if err != nil {
+                return err
+            }
+            prev.update = merged
+            return nil
+        }
+        return fmt.Errorf("dynamo tx: multiple writes to %s/%s", op.table, op.key)
+    }
+    b.ops = append(b.ops, op)
+    return nil
+}
+
+// keyOf extracts the primary key attributes of a full item written to table.
+func (c *Client) keyOf(table string, item map[string]types.AttributeValue) map[string]types.AttributeValue {
+    var attr string
+    switch table {
+    case c.Tables.Users:
+        attr = "user_id"
+    case c.Tables.Rooms:
+        attr = "room_id"
+    case c.Tables.Lists:
+        attr = "list_id"
+    case c.Tables.ListItems:
+        attr = "item_id"
+    case c.Tables.Migrations:
+        attr = "version"
+    case c.Tables.Jobs:
+        attr = "job_id"
+    case c.Tables.Sessions:
+        attr = "session_id"
+    case c.Tables.UserTokens:
+        attr = "token_hash"
+    case c.Tables.Counters:
+        attr = "counter_key"
+    default:
+        return item
+    }
+    return map[string]types.AttributeValue{attr: item[attr]}
+}
+
+// keyString identifies an item by its primary key for duplicate detection.
+func keyString(key map[string]types.AttributeValue) string {
+    parts := make([]string, 0, len(key))
+    for k, v := range key {
+        switch av := v.(type) {
+        case *types.AttributeValueMemberS:
+            parts = append(parts, k+"="+av.Value)
+        case *types.AttributeValueMemberN:
+            parts = append(parts, k+"="+av.Value)
+        }
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ",")
+}
+
+var placeholderRe = regexp.MustCompile(`[#:][A-Za-z0-9_]+`)
+
+// mergeUpdates combines two update operations on the same item. Placeholders
+// of the second update are suffixed to avoid collisions, actions on the same
+// attribute path keep the later one, and conditions are ANDed.
+func mergeUpdates(a, b *types.Update, seq int) (*types.Update, error) {
+    suffix := fmt.Sprintf("_m%d", seq)
+    rename := func(s string) string {
+        return placeholderRe.ReplaceAllStringFunc(s, func(p string) string {
+            if _, ok := b.ExpressionAttributeNames[p]; ok {
+                return p + suffix
+            }
+            if _, ok := b.ExpressionAttributeValues[p]; ok {
+                return p + suffix
+            }
+            return p
+        })
+    }
+    names := map[string]string{}
+    values := map[string]types.AttributeValue{}
+    for k, v := range a.ExpressionAttributeNames { names[k] = v }
+    for k, v := range a.ExpressionAttributeValues { values[k] = v }
+    for k, v := range b.ExpressionAttributeNames { names[k+suffix] = v }
+    for k, v := range b.ExpressionAttributeValues { values[k+suffix] = v }
+
+    ca, err := parseUpdateClauses(deref(a.UpdateExpression))
+    if err != nil { return nil, err }
+    cb, err := parseUpdateClauses(rename(deref(b.UpdateExpression)))
+    if err != nil { return nil, err }
+
+    // Resolve "#n" style names so both sides compare real attribute paths.
+    resolve := func(path string) string {
+        return placeholderRe.ReplaceAllStringFunc(path, func(p string) string {
+            if n, ok := names[p]; ok && strings.HasPrefix(p, "#") {
+                return n
+            }
+            return p
+        })
+    }
+    later := map[string]bool{}
+    for _, acts := range cb {
+        for _, act := range acts { later[resolve(actionPath(act))] = true }
+    }
+    var parts []string
+    for _, kw := range updateKeywords {
+        var acts []string
+        for _, act := range ca[kw] {
+            if !later[resolve(actionPath(act))] { acts = append(acts, act) }
+        }
+        acts = append(acts, cb[kw]...)
+        if len(acts) > 0 {
+            parts = append(parts, kw+" "+strings.Join(acts, ", "))
+        }
+    }
+    expr := strings.Join(parts, " ")
+
+    var cond string
+    switch {
+    case a.ConditionExpression != nil && b.ConditionExpression != nil:
+        cond = "(" + *a.ConditionExpression + ") AND (" + rename(*b.ConditionExpression) + ")"
+    case a.ConditionExpression != nil:
+        cond = *a.ConditionExpression
+    case b.ConditionExpression != nil:
+        cond = rename(*b.ConditionExpression)
+    }
+
+    // DynamoDB rejects unused placeholders, so drop the ones whose action was superseded.
+    used := map[string]bool{}
+    for _, p := range placeholderRe.FindAllString(expr+" "+cond, -1) { used[p] = true }
+    for k := range names { if !used[k] { delete(names, k) } }
+    for k := range values { if !used[k] { delete(values, k) } }
+
+    out := &types.Update{TableName: a.TableName, Key: a.Key, UpdateExpression: &expr}
+    if cond != "" { out.ConditionExpression = &cond }
+    if len(names) > 0 { out.ExpressionAttributeNames = names }
+    if len(values) > 0 { out.ExpressionAttributeValues = values }
+    return out, nil
+}
+
+var updateKeywords = []string{"SET", "REMOVE", "ADD", "DELETE"}
+
+// parseUpdateClauses splits an update expression into its actions per clause.
+func parseUpdateClauses(expr string) (map[string][]string, error) {
+    out := map[string][]string{}
+    fields := strings.Fields(expr)
+    cur := ""
+    var buf []string
+    flush := func() {
+        if cur != "" {
+            out[cur] = append(out[cur], splitActions(strings.Join(buf, " "))...)
+        }
+        buf = nil
+    }
+    for _, f := range fields {
+        isKw := false
+        for _, kw := range updateKeywords {
+            if f == kw { isKw = true }
+        }
+        if isKw {
+            flush()
+            cur = f
+            continue
+        }
+        if cur == "" {
+            return nil, fmt.Errorf("dynamo tx: cannot parse update expression %q", expr)
+        }
+        buf = append(buf, f)
+    }
+    flush()
+    return out, nil
+}
+
+// splitActions splits a clause body on top-level commas.
+func splitActions(body string) []string {
+    var acts []string
+    depth, start := 0, 0
+    for i, r := range body {
+        switch r {
+        case '(':
+            depth++
+        case ')':
+            depth--
+        case ',':
+            if depth == 0 {
+                acts = append(acts, strings.TrimSpace(body[start:i]))
+                start = i + 1
+            }
+        }
+    }
+    if s := strings.TrimSpace(body[start:]); s != "" {
+        acts = append(acts, s)
+    }
+    return acts
+}
+
+// actionPath returns the attribute path an update action writes to.
+func actionPath(act string) string {
+    if i := strings.Index(act, "="); i >= 0 {
+        return strings.TrimSpace(act[:i])
+    }
+    if f := strings.Fields(act); len(f) > 0 {
+        return f[0]
+    }
+    return act
+}
+
+func deref(s *string) string {
+    if s == nil { return "" }
+    return *s
+}
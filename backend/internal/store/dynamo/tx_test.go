@@ -0,0 +1,48 @@
+package dynamo
+
+import (
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestMergeUpdates(t *testing.T) {
+    table := "Rooms"
+    key := map[string]types.AttributeValue{"room_id": &types.AttributeValueMemberS{Value: "r1"}}
+    b := &txBatch{}
+    add := &types.Update{
+        TableName:           &table,
+        Key:                 key,
+        UpdateExpression:    strPtr("SET member_ids = list_append(member_ids, :m), updated_at = :ua"),
+        ConditionExpression: strPtr("NOT contains(member_ids, :uid)"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":m":   &types.AttributeValueMemberL{},
+            ":ua":  &types.AttributeValueMemberS{Value: "t1"},
+            ":uid": &types.AttributeValueMemberS{Value: "u1"},
+        },
+    }
+    removeTok := &types.Update{
+        TableName:                &table,
+        Key:                      key,
+        UpdateExpression:         strPtr("REMOVE share_token, deletion_votes.#u SET updated_at = :ua"),
+        ExpressionAttributeNames: map[string]string{"#u": "u1"},
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":ua": &types.AttributeValueMemberS{Value: "t2"},
+        },
+    }
+    if err := b.add(&txOp{table: table, key: keyString(key), update: add}); err != nil { t.Fatal(err) }
+    if err := b.add(&txOp{table: table, key: keyString(key), update: removeTok}); err != nil { t.Fatal(err) }
+    if len(b.ops) != 1 { t.Fatalf("expected one merged op, got %d", len(b.ops)) }
+    got := b.ops[0].update
+    want := "SET member_ids = list_append(member_ids, :m), updated_at = :ua_m1 REMOVE share_token, deletion_votes.#u_m1"
+    if *got.UpdateExpression != want { t.Fatalf("expr:\n got %q\nwant %q", *got.UpdateExpression, want) }
+    if *got.ConditionExpression != "NOT contains(member_ids, :uid)" { t.Fatalf("cond: %q", *got.ConditionExpression) }
+    if _, ok := got.ExpressionAttributeValues[":ua"]; ok { t.Fatalf("superseded placeholder kept") }
+    if got.ExpressionAttributeNames["#u_m1"] != "u1" { t.Fatalf("names: %v", got.ExpressionAttributeNames) }
+
+    // A delete replaces pending updates; a second put is refused.
+    del := &types.Delete{TableName: &table, Key: key}
+    if err := b.add(&txOp{table: table, key: keyString(key), delete: del}); err != nil { t.Fatal(err) }
+    if b.ops[0].delete == nil || b.ops[0].update != nil { t.Fatalf("delete did not replace update") }
+    if err := b.add(&txOp{table: table, key: keyString(key), put: &types.Put{TableName: &table}}); err == nil { t.Fatalf("expected error for put after delete") }
+}
@@ -3,6 +3,8 @@ package dynamo
 import (
     "context"
     "errors"
+    "fmt"
+    "strings"
     "time"
 
     "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -26,7 +28,7 @@ func (r *UserRepo) Put(ctx context.Context, u *models.User) error {
     if err != nil {
         return err
     }
-    _, err = r.c.DB.PutItem(ctx, &dynamodb.PutItemInput{
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
         TableName:           &r.c.Tables.Users,
         Item:                item,
         ConditionExpression: strPtr("attribute_not_exists(user_id)"),
@@ -54,6 +56,11 @@ func (r *UserRepo) GetByID(ctx context.Context, id string) (*models.User, error)
     return &u, nil
 }
 
+// ScanAll scans the whole table page by page, calling fn for each user.
+func (r *UserRepo) ScanAll(ctx context.Context, fn func(u models.User) error) error {
+    return scanTable(ctx, r.c, r.c.Tables.Users, fn)
+}
+
 func (r *UserRepo) GetByAPIKeyLookup(ctx context.Context, lookup string) (*models.User, error) {
     out, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
         TableName:              &r.c.Tables.Users,
@@ -111,33 +118,51 @@ func (r *UserRepo) SetAPIKey(ctx context.Context, userID string, hash, lookup st
         set = "SET api_key_hash = :h, api_key_lookup = :l, api_key_expires_at = :exp, updated_at = :ua"
         eav[":exp"] = &types.AttributeValueMemberS{Value: expiresAt.UTC().Format(time.RFC3339)}
     }
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
         TableName:                 &r.c.Tables.Users,
         Key:                       map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        ConditionExpression: strPtr("attribute_exists(user_id)"),
         UpdateExpression:          &set,
         ExpressionAttributeValues: eav,
         ReturnValues:              types.ReturnValueNone,
     })
-    return err
+    return notFoundIfConditionFailed(err)
 }
 
-func (r *UserRepo) SetPasswordEnc(ctx context.Context, userID string, enc string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+// ClearAPIKey removes the attributes rather than blanking them: an empty
+// string may not be written to the api_key_lookup GSI key.
+func (r *UserRepo) ClearAPIKey(ctx context.Context, userID, lookup string) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:           &r.c.Tables.Users,
+        Key:                 map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        ConditionExpression: strPtr("api_key_lookup = :l"),
+        UpdateExpression:    strPtr("REMOVE api_key_hash, api_key_lookup, api_key_expires_at"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":l": &types.AttributeValueMemberS{Value: lookup},
+        },
+    })
+    return notFoundIfConditionFailed(err)
+}
+
+func (r *UserRepo) UpdatePasswordEnc(ctx context.Context, userID string, enc string, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Users,
         Key:              map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        ConditionExpression: strPtr("attribute_exists(user_id)"),
         UpdateExpression: strPtr("SET password_enc = :p, updated_at = :ua"),
         ExpressionAttributeValues: map[string]types.AttributeValue{
             ":p":  &types.AttributeValueMemberS{Value: enc},
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
     })
-    return err
+    return notFoundIfConditionFailed(err)
 }
 
 func (r *UserRepo) UpdateName(ctx context.Context, userID string, name string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Users,
         Key:              map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        ConditionExpression: strPtr("attribute_exists(user_id)"),
         UpdateExpression: strPtr("SET #n = :name, updated_at = :ua"),
         ExpressionAttributeNames: map[string]string{
             "#n": "name",
@@ -148,31 +173,186 @@ func (r *UserRepo) UpdateName(ctx context.Context, userID string, name string, u
         },
         ReturnValues: types.ReturnValueNone,
     })
-    return err
+    return notFoundIfConditionFailed(err)
 }
 
-func (r *UserRepo) SetRoomID(ctx context.Context, userID string, roomID string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (r *UserRepo) UpdateUsername(ctx context.Context, userID string, username string, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Users,
         Key:              map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
-        UpdateExpression: strPtr("SET room_id = :rid, updated_at = :ua"),
+        UpdateExpression: strPtr("SET username = :u, updated_at = :ua REMOVE email_verified_at"),
         ExpressionAttributeValues: map[string]types.AttributeValue{
-            ":rid": &types.AttributeValueMemberS{Value: roomID},
-            ":ua":  &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+            ":u":  &types.AttributeValueMemberS{Value: username},
+            ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
+        ConditionExpression: strPtr("attribute_exists(user_id)"),
     })
-    return err
+    return notFoundIfConditionFailed(err)
 }
 
-func (r *UserRepo) ClearRoomID(ctx context.Context, userID string, updatedAt time.Time) error {
-    _, err := r.c.DB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+func (r *UserRepo) MarkEmailVerified(ctx context.Context, userID, username string, at time.Time) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
         TableName:        &r.c.Tables.Users,
         Key:              map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
-        UpdateExpression: strPtr("REMOVE room_id SET updated_at = :ua"),
+        UpdateExpression: strPtr("SET email_verified_at = :v"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":v": &types.AttributeValueMemberS{Value: at.UTC().Format(time.RFC3339)},
+            ":u": &types.AttributeValueMemberS{Value: username},
+        },
+        ConditionExpression: strPtr("attribute_exists(user_id) AND username = :u"),
+    })
+    return notFoundIfConditionFailed(err)
+}
+
+// SetTOTPSecret stores a pending secret, or removes two-factor login when
+// secretEnc is empty.
+func (r *UserRepo) SetTOTPSecret(ctx context.Context, userID, secretEnc string, updatedAt time.Time) error {
+    in := &dynamodb.UpdateItemInput{
+        TableName:           &r.c.Tables.Users,
+        Key:                 map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        ConditionExpression: strPtr("attribute_exists(user_id)"),
+        UpdateExpression:    strPtr("SET totp_secret_enc = :s, updated_at = :ua REMOVE totp_enabled_at"),
         ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":s":  &types.AttributeValueMemberS{Value: secretEnc},
             ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
         },
+    }
+    if secretEnc == "" {
+        in.UpdateExpression = strPtr("SET updated_at = :ua REMOVE totp_secret_enc, totp_enabled_at")
+        delete(in.ExpressionAttributeValues, ":s")
+    }
+    return notFoundIfConditionFailed(r.c.updateItem(ctx, in))
+}
+
+func (r *UserRepo) EnableTOTP(ctx context.Context, userID, secretEnc string, at time.Time) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Users,
+        Key:              map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        UpdateExpression: strPtr("SET totp_enabled_at = :t"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":t": &types.AttributeValueMemberS{Value: at.UTC().Format(time.RFC3339)},
+            ":s": &types.AttributeValueMemberS{Value: secretEnc},
+        },
+        ConditionExpression: strPtr("attribute_exists(user_id) AND totp_secret_enc = :s"),
     })
+    return notFoundIfConditionFailed(err)
+}
+
+func (r *UserRepo) ReencryptSecrets(ctx context.Context, userID, oldPassword, newPassword, oldTOTP, newTOTP string) error {
+    cond, set := []string{"attribute_exists(user_id)"}, []string{}
+    values := map[string]types.AttributeValue{}
+    if oldPassword != "" {
+        cond, set = append(cond, "password_enc = :op"), append(set, "password_enc = :np")
+        values[":op"], values[":np"] = &types.AttributeValueMemberS{Value: oldPassword}, &types.AttributeValueMemberS{Value: newPassword}
+    }
+    if oldTOTP != "" {
+        cond, set = append(cond, "totp_secret_enc = :ot"), append(set, "totp_secret_enc = :nt")
+        values[":ot"], values[":nt"] = &types.AttributeValueMemberS{Value: oldTOTP}, &types.AttributeValueMemberS{Value: newTOTP}
+    }
+    if len(set) == 0 {
+        _, err := r.GetByID(ctx, userID)
+        return err
+    }
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:                 &r.c.Tables.Users,
+        Key:                       map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        UpdateExpression:          strPtr("SET " + strings.Join(set, ", ")),
+        ConditionExpression:       strPtr(strings.Join(cond, " AND ")),
+        ExpressionAttributeValues: values,
+    })
+    return notFoundIfConditionFailed(err)
+}
+
+// SetRoomID sets the user's room, or removes it when roomID is nil.
+func (r *UserRepo) SetRoomID(ctx context.Context, userID string, roomID *string, updatedAt time.Time) error {
+    in := &dynamodb.UpdateItemInput{
+        TableName: &r.c.Tables.Users,
+        Key:       map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":ua": &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+        },
+        ConditionExpression: strPtr("attribute_exists(user_id)"),
+    }
+    if roomID == nil {
+        in.UpdateExpression = strPtr("REMOVE room_id SET updated_at = :ua")
+    } else {
+        in.UpdateExpression = strPtr("SET room_id = :rid, updated_at = :ua")
+        in.ExpressionAttributeValues[":rid"] = &types.AttributeValueMemberS{Value: *roomID}
+    }
+    return notFoundIfConditionFailed(r.c.updateItem(ctx, in))
+}
+
+// AddRoom appends roomID to room_ids, creating the list on first use.
+func (r *UserRepo) AddRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error {
+    err := r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Users,
+        Key:              map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        UpdateExpression: strPtr("SET room_ids = list_append(if_not_exists(room_ids, :empty), :r), updated_at = :ua"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":r":     &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: roomID}}},
+            ":empty": &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+            ":ua":    &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+            ":rid":   &types.AttributeValueMemberS{Value: roomID},
+        },
+        ConditionExpression: strPtr("attribute_exists(user_id) AND NOT contains(room_ids, :rid)"),
+    })
+    var cce *types.ConditionalCheckFailedException
+    if err != nil && errors.As(err, &cce) {
+        // Either the user is missing or roomID is already there.
+        if _, err := r.GetByID(ctx, userID); err != nil { return err }
+        return derr.ErrConflict
+    }
+    return err
+}
+
+// RemoveRoom removes roomID from room_ids. Like RoomRepo.RemoveMember it reads
+// the index first and guards the removal on it still holding roomID.
+func (r *UserRepo) RemoveRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error {
+    u, err := r.GetByID(ctx, userID)
+    if err != nil { return err }
+    idx := -1
+    for i, id := range u.RoomIDs {
+        if id == roomID {
+            idx = i
+            break
+        }
+    }
+    if idx < 0 { return derr.ErrNotFound }
+    err = r.c.updateItem(ctx, &dynamodb.UpdateItemInput{
+        TableName:        &r.c.Tables.Users,
+        Key:              map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        UpdateExpression: strPtr(fmt.Sprintf("REMOVE room_ids[%d] SET updated_at = :ua", idx)),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":ua":  &types.AttributeValueMemberS{Value: updatedAt.UTC().Format(time.RFC3339)},
+            ":rid": &types.AttributeValueMemberS{Value: roomID},
+        },
+        ConditionExpression: strPtr(fmt.Sprintf("room_ids[%d] = :rid", idx)),
+    })
+    var cce *types.ConditionalCheckFailedException
+    if err != nil && errors.As(err, &cce) { return derr.ErrConflict }
+    return err
+}
+
+func (r *UserRepo) Delete(ctx context.Context, userID string) error {
+    err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
+        TableName:           &r.c.Tables.Users,
+        Key:                 map[string]types.AttributeValue{"user_id": &types.AttributeValueMemberS{Value: userID}},
+        ConditionExpression: strPtr("attribute_exists(user_id)"),
+    })
+    return notFoundIfConditionFailed(err)
+}
+
+// notFoundIfConditionFailed maps a failed attribute_exists condition to derr.ErrNotFound.
+func notFoundIfConditionFailed(err error) error {
+    var cce *types.ConditionalCheckFailedException
+    if err != nil && errors.As(err, &cce) {
+        return derr.ErrNotFound
+    }
+    return err
+}
+
+// conflictIfConditionFailed maps a failed attribute_not_exists put to derr.ErrConflict.
+func conflictIfConditionFailed(err error) error {
     var cce *types.ConditionalCheckFailedException
     if err != nil && errors.As(err, &cce) {
         return derr.ErrConflict
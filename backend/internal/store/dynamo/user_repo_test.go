@@ -2,10 +2,12 @@ package dynamo
 
 import (
     "context"
+    "errors"
     "testing"
     "time"
 
     authpkg "github.com/janvillarosa/gracie-app/backend/internal/auth"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
     "github.com/janvillarosa/gracie-app/backend/internal/testutil"
 )
@@ -37,6 +39,11 @@ func TestUserRepoCRUD(t *testing.T) {
     if err != nil || got2 == nil || got2.UserID != u.UserID { t.Fatalf("lookup: %v %v", err, got2) }
 
     if err := repo.UpdateName(context.Background(), u.UserID, "Alicia", time.Now().UTC()); err != nil { t.Fatalf("update name: %v", err) }
-    if err := repo.SetRoomID(context.Background(), u.UserID, "roomX", time.Now().UTC()); err != nil { t.Fatalf("set room: %v", err) }
-    if err := repo.ClearRoomID(context.Background(), u.UserID, time.Now().UTC()); err != nil { t.Fatalf("clear room: %v", err) }
+    roomX := "roomX"
+    if err := repo.SetRoomID(context.Background(), u.UserID, &roomX, time.Now().UTC()); err != nil { t.Fatalf("set room: %v", err) }
+    if err := repo.SetRoomID(context.Background(), u.UserID, nil, time.Now().UTC()); err != nil { t.Fatalf("clear room: %v", err) }
+    if err := repo.UpdateUsername(context.Background(), u.UserID, "alice@example.com", time.Now().UTC()); err != nil { t.Fatalf("update username: %v", err) }
+    if got, err := repo.GetByUsername(context.Background(), "alice@example.com"); err != nil || got.UserID != u.UserID || got.RoomID != nil { t.Fatalf("by username: %v %+v", err, got) }
+    if err := repo.Delete(context.Background(), u.UserID); err != nil { t.Fatalf("delete: %v", err) }
+    if err := repo.Delete(context.Background(), u.UserID); !errors.Is(err, derr.ErrNotFound) { t.Fatalf("delete again: %v", err) }
 }
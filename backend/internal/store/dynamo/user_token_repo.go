@@ -0,0 +1,114 @@
+package dynamo
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// userTokenUserIndex is the user_id GSI of the UserTokens table (see cmd/setup-ddb).
+const userTokenUserIndex = "user_id_index"
+
+// UserTokenRepo stores single-use user tokens in the UserTokens table (hash
+// key "token_hash").
+type UserTokenRepo struct{ c *Client }
+
+func NewUserTokenRepo(c *Client) *UserTokenRepo { return &UserTokenRepo{c: c} }
+
+func userTokenKey(hash string) map[string]types.AttributeValue {
+    return map[string]types.AttributeValue{"token_hash": &types.AttributeValueMemberS{Value: hash}}
+}
+
+func (r *UserTokenRepo) Create(ctx context.Context, t *models.UserToken) error {
+    item, err := attributevalue.MarshalMap(t)
+    if err != nil { return err }
+    err = r.c.putItem(ctx, &dynamodb.PutItemInput{
+        TableName:           &r.c.Tables.UserTokens,
+        Item:                item,
+        ConditionExpression: strPtr("attribute_not_exists(token_hash)"),
+    })
+    return conflictIfConditionFailed(err)
+}
+
+// ScanAll scans the whole table page by page, calling fn for each token.
+func (r *UserTokenRepo) ScanAll(ctx context.Context, fn func(t models.UserToken) error) error {
+    return scanTable(ctx, r.c, r.c.Tables.UserTokens, fn)
+}
+
+// Consume deletes the item conditionally and returns its old image, so only
+// one caller redeems a token.
+func (r *UserTokenRepo) Consume(ctx context.Context, hash, purpose string) (*models.UserToken, error) {
+    out, err := r.c.DB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+        TableName:                 &r.c.Tables.UserTokens,
+        Key:                       userTokenKey(hash),
+        ConditionExpression:       strPtr("purpose = :p"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{":p": &types.AttributeValueMemberS{Value: purpose}},
+        ReturnValues:              types.ReturnValueAllOld,
+    })
+    if err := notFoundIfConditionFailed(err); err != nil { return nil, err }
+    var t models.UserToken
+    if err := attributevalue.UnmarshalMap(out.Attributes, &t); err != nil { return nil, err }
+    return &t, nil
+}
+
+func (r *UserTokenRepo) DeleteByUser(ctx context.Context, userID, purpose string) (int, error) {
+    var (
+        n     int
+        start map[string]types.AttributeValue
+    )
+    for {
+        page, err := r.c.DB.Query(ctx, &dynamodb.QueryInput{
+            TableName:              &r.c.Tables.UserTokens,
+            IndexName:              strPtr(userTokenUserIndex),
+            KeyConditionExpression: strPtr("user_id = :u"),
+            FilterExpression:       strPtr("purpose = :p"),
+            ExpressionAttributeValues: map[string]types.AttributeValue{
+                ":u": &types.AttributeValueMemberS{Value: userID},
+                ":p": &types.AttributeValueMemberS{Value: purpose},
+            },
+            ExclusiveStartKey: start,
+        })
+        if err != nil { return n, err }
+        var ts []models.UserToken
+        if err := attributevalue.UnmarshalListOfMaps(page.Items, &ts); err != nil { return n, err }
+        m, err := r.deleteAll(ctx, ts)
+        n += m
+        if err != nil { return n, err }
+        if len(page.LastEvaluatedKey) == 0 { return n, nil }
+        start = page.LastEvaluatedKey
+    }
+}
+
+// DeleteExpired scans the table; expiry is compared in Go because stored
+// timestamps do not sort as strings.
+func (r *UserTokenRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    var expired []models.UserToken
+    err := scanTable(ctx, r.c, r.c.Tables.UserTokens, func(t models.UserToken) error {
+        if t.ExpiresAt.Before(cutoff) { expired = append(expired, t) }
+        return nil
+    })
+    if err != nil { return 0, err }
+    return r.deleteAll(ctx, expired)
+}
+
+// deleteAll deletes tokens, skipping ones already gone, and returns how many it removed.
+func (r *UserTokenRepo) deleteAll(ctx context.Context, ts []models.UserToken) (int, error) {
+    n := 0
+    for _, t := range ts {
+        err := r.c.deleteItem(ctx, &dynamodb.DeleteItemInput{
+            TableName:           &r.c.Tables.UserTokens,
+            Key:                 userTokenKey(t.TokenHash),
+            ConditionExpression: strPtr("attribute_exists(token_hash)"),
+        })
+        if errors.Is(notFoundIfConditionFailed(err), derr.ErrNotFound) { continue }
+        if err != nil { return n, err }
+        n++
+    }
+    return n, nil
+}
@@ -0,0 +1,74 @@
+package dynamo
+
+import (
+    "errors"
+    "strconv"
+
+    "github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// versioned makes in bump the version attribute and, unless ifVersion is
+// store.AnyVersion, apply only while it equals ifVersion. Records written before
+// versions existed have no attribute and count as version 0.
+func versioned(in *dynamodb.UpdateItemInput, ifVersion int64) *dynamodb.UpdateItemInput {
+    expr := *in.UpdateExpression + " ADD version :one"
+    in.UpdateExpression = &expr
+    if in.ExpressionAttributeValues == nil {
+        in.ExpressionAttributeValues = map[string]types.AttributeValue{}
+    }
+    in.ExpressionAttributeValues[":one"] = &types.AttributeValueMemberN{Value: "1"}
+    if ifVersion == store.AnyVersion {
+        return in
+    }
+    cond := "version = :ver"
+    if ifVersion == 0 {
+        cond = "(attribute_not_exists(version) OR version = :ver)"
+    }
+    if in.ConditionExpression != nil {
+        cond = "(" + *in.ConditionExpression + ") AND " + cond
+    }
+    in.ConditionExpression = &cond
+    in.ExpressionAttributeValues[":ver"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(ifVersion, 10)}
+    in.ReturnValuesOnConditionCheckFailure = types.ReturnValuesOnConditionCheckFailureAllOld
+    return in
+}
+
+// versionFailed maps a failed versioned update to derr.ErrPreconditionFailed when
+// the record it saw still passes live, so only the version check failed, and to
+// derr.ErrNotFound otherwise.
+func versionFailed(err error, live func(old map[string]types.AttributeValue) bool) error {
+    var cce *types.ConditionalCheckFailedException
+    if err == nil || !errors.As(err, &cce) {
+        return err
+    }
+    if len(cce.Item) > 0 && live(cce.Item) {
+        return derr.ErrPreconditionFailed
+    }
+    return derr.ErrNotFound
+}
+
+// notTrashed is the live check of lists and items: the record has no is_deleted.
+func notTrashed(old map[string]types.AttributeValue) bool {
+    _, deleted := old["is_deleted"]
+    return !deleted
+}
+
+// hasMember is the live check of room updates made on behalf of userID.
+func hasMember(userID string) func(map[string]types.AttributeValue) bool {
+    return func(old map[string]types.AttributeValue) bool {
+        var ids []string
+        if err := attributevalue.Unmarshal(old["member_ids"], &ids); err != nil {
+            return false
+        }
+        for _, id := range ids {
+            if id == userID {
+                return true
+            }
+        }
+        return false
+    }
+}
@@ -49,6 +49,16 @@ func (r *CategoryIndexRepo) Lookup(ctx context.Context, key string) (string, boo
 	return doc.Category, true, nil
 }
 
+// ScanAll streams every entry through fn.
+func (r *CategoryIndexRepo) ScanAll(ctx context.Context, fn func(key, category string) error) error {
+	return scanAll(ctx, r.col(), func(doc struct {
+		Key      string `bson:"key"`
+		Category string `bson:"category"`
+	}) error {
+		return fn(doc.Key, doc.Category)
+	})
+}
+
 // Upsert updates or inserts a category for a key, setting updated_at.
 func (r *CategoryIndexRepo) Upsert(ctx context.Context, key, category string) error {
 	_, err := r.col().UpdateOne(
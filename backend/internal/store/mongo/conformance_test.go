@@ -0,0 +1,20 @@
+package mongo
+
+import (
+    "context"
+    "testing"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) storetest.Repos {
+        c := connectOrSkip(t)
+        ctx := context.Background()
+        users, rooms, lists, items, migrations, jobs, sessions, userTokens, counters, invites := NewUserRepo(c), NewRoomRepo(c), NewListRepo(c), NewListItemRepo(c), NewMigrationRepo(c), NewJobRepo(c), NewSessionRepo(c), NewUserTokenRepo(c), NewCounterRepo(c), NewInviteRepo(c)
+        for _, ensure := range []func(context.Context) error{users.EnsureIndexes, rooms.EnsureIndexes, lists.EnsureIndexes, items.EnsureIndexes, migrations.EnsureIndexes, jobs.EnsureIndexes, sessions.EnsureIndexes, userTokens.EnsureIndexes, counters.EnsureIndexes, invites.EnsureIndexes} {
+            if err := ensure(ctx); err != nil { t.Fatalf("indexes: %v", err) }
+        }
+        return storetest.Repos{Tx: NewTx(c), Users: users, Rooms: rooms, Lists: lists, Items: items, Migrations: migrations, Jobs: jobs, Sessions: sessions, UserTokens: userTokens, Counters: counters, Invites: invites}
+    })
+}
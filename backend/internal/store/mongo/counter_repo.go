@@ -0,0 +1,67 @@
+package mongo
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    mgo "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CounterRepo keeps expiring counters in the counters collection, keyed by
+// _id. A TTL index removes them once expired.
+type CounterRepo struct{ db *mgo.Database }
+
+func NewCounterRepo(c *Client) *CounterRepo { return &CounterRepo{db: c.DB} }
+
+func (r *CounterRepo) col() *mgo.Collection { return r.db.Collection("counters") }
+
+type counterDoc struct {
+    Key       string    `bson:"_id"`
+    Count     int64     `bson:"count"`
+    ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func (r *CounterRepo) EnsureIndexes(ctx context.Context) error {
+    _, err := r.col().Indexes().CreateOne(ctx, mgo.IndexModel{
+        Keys:    bson.D{{Key: "expires_at", Value: 1}},
+        Options: options.Index().SetExpireAfterSeconds(0),
+    })
+    return err
+}
+
+func (r *CounterRepo) Incr(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+    update := bson.D{
+        {Key: "$inc", Value: bson.D{{Key: "count", Value: int64(1)}}},
+        {Key: "$setOnInsert", Value: bson.D{{Key: "expires_at", Value: expiresAt.UTC()}}},
+    }
+    opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+    var d counterDoc
+    err := r.col().FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: key}}, update, opts).Decode(&d)
+    // Two concurrent upserts may both insert; the loser retries as an update.
+    if mgo.IsDuplicateKeyError(err) {
+        err = r.col().FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: key}}, update, opts).Decode(&d)
+    }
+    return d.Count, err
+}
+
+func (r *CounterRepo) Get(ctx context.Context, key string, now time.Time) (int64, error) {
+    var d counterDoc
+    filter := bson.D{{Key: "_id", Value: key}, {Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now.UTC()}}}}
+    err := r.col().FindOne(ctx, filter).Decode(&d)
+    if errors.Is(err, mgo.ErrNoDocuments) { return 0, nil }
+    return d.Count, err
+}
+
+func (r *CounterRepo) Delete(ctx context.Context, key string) error {
+    _, err := r.col().DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+    return err
+}
+
+func (r *CounterRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    res, err := r.col().DeleteMany(ctx, bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lt", Value: cutoff.UTC()}}}})
+    if err != nil { return 0, err }
+    return int(res.DeletedCount), nil
+}
@@ -0,0 +1,20 @@
+package mongo
+
+import (
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    mgo "go.mongodb.org/mongo-driver/mongo"
+)
+
+// notFoundIfUnmatched maps an update whose filter matched no document to derr.ErrNotFound.
+func notFoundIfUnmatched(res *mgo.UpdateResult, err error) error {
+    if err != nil { return err }
+    if res.MatchedCount == 0 { return derr.ErrNotFound }
+    return nil
+}
+
+// notFoundIfNoneDeleted maps a delete that removed no document to derr.ErrNotFound.
+func notFoundIfNoneDeleted(res *mgo.DeleteResult, err error) error {
+    if err != nil { return err }
+    if res.DeletedCount == 0 { return derr.ErrNotFound }
+    return nil
+}
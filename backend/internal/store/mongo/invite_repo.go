@@ -0,0 +1,94 @@
+package mongo
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "go.mongodb.org/mongo-driver/bson"
+    mgo "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InviteRepo stores room invites in the invites collection.
+type InviteRepo struct{ db *mgo.Database }
+
+func NewInviteRepo(c *Client) *InviteRepo { return &InviteRepo{db: c.DB} }
+
+func (r *InviteRepo) col() *mgo.Collection { return r.db.Collection("invites") }
+
+func (r *InviteRepo) EnsureIndexes(ctx context.Context) error {
+    _, err := r.col().Indexes().CreateMany(ctx, []mgo.IndexModel{
+        {Keys: bson.D{{Key: "invite_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+        {Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+        {Keys: bson.D{{Key: "link_token", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+        {Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "created_at", Value: 1}}},
+    })
+    return err
+}
+
+func (r *InviteRepo) Put(ctx context.Context, inv *models.Invite) error {
+    _, err := r.col().InsertOne(ctx, inv)
+    if mgo.IsDuplicateKeyError(err) { return derr.ErrConflict }
+    return err
+}
+
+func (r *InviteRepo) findOne(ctx context.Context, filter bson.D) (*models.Invite, error) {
+    var inv models.Invite
+    err := r.col().FindOne(ctx, filter).Decode(&inv)
+    if errors.Is(err, mgo.ErrNoDocuments) { return nil, derr.ErrNotFound }
+    if err != nil { return nil, err }
+    return &inv, nil
+}
+
+func (r *InviteRepo) GetByID(ctx context.Context, inviteID string) (*models.Invite, error) {
+    return r.findOne(ctx, bson.D{{Key: "invite_id", Value: inviteID}})
+}
+
+func (r *InviteRepo) GetByToken(ctx context.Context, token string) (*models.Invite, error) {
+    return r.findOne(ctx, bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "code", Value: token}}, bson.D{{Key: "link_token", Value: token}}}}})
+}
+
+func (r *InviteRepo) ListByRoom(ctx context.Context, roomID string) ([]models.Invite, error) {
+    opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "invite_id", Value: 1}})
+    cur, err := r.col().Find(ctx, bson.D{{Key: "room_id", Value: roomID}}, opts)
+    if err != nil { return nil, err }
+    var out []models.Invite
+    if err := cur.All(ctx, &out); err != nil { return nil, err }
+    return out, nil
+}
+
+// ScanAll streams every invite through fn.
+func (r *InviteRepo) ScanAll(ctx context.Context, fn func(inv models.Invite) error) error {
+    return scanAll(ctx, r.col(), fn)
+}
+
+func (r *InviteRepo) Use(ctx context.Context, inviteID string) error {
+    filter := bson.D{
+        {Key: "invite_id", Value: inviteID},
+        {Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}},
+        {Key: "$or", Value: bson.A{
+            bson.D{{Key: "max_uses", Value: 0}},
+            bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$uses", "$max_uses"}}}}},
+        }},
+    }
+    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$inc", Value: bson.D{{Key: "uses", Value: 1}}}})
+    if err != nil { return err }
+    if res.MatchedCount == 0 { return derr.ErrConflict }
+    return nil
+}
+
+func (r *InviteRepo) Revoke(ctx context.Context, inviteID string, at time.Time) error {
+    filter := bson.D{{Key: "invite_id", Value: inviteID}, {Key: "revoked_at", Value: bson.D{{Key: "$exists", Value: false}}}}
+    if _, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: at.UTC()}}}}); err != nil { return err }
+    _, err := r.GetByID(ctx, inviteID)
+    return err
+}
+
+func (r *InviteRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    res, err := r.col().DeleteMany(ctx, bson.D{{Key: "room_id", Value: roomID}})
+    if err != nil { return 0, err }
+    return int(res.DeletedCount), nil
+}
@@ -0,0 +1,124 @@
+package mongo
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "go.mongodb.org/mongo-driver/bson"
+    mgo "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobRepo stores background jobs in the jobs collection.
+type JobRepo struct{ db *mgo.Database }
+
+func NewJobRepo(c *Client) *JobRepo { return &JobRepo{db: c.DB} }
+
+func (r *JobRepo) col() *mgo.Collection { return r.db.Collection("jobs") }
+
+func (r *JobRepo) EnsureIndexes(ctx context.Context) error {
+    _, err := r.col().Indexes().CreateMany(ctx, []mgo.IndexModel{
+        {Keys: bson.D{{Key: "job_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+        {Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+    })
+    return err
+}
+
+func (r *JobRepo) Enqueue(ctx context.Context, j *models.Job) error {
+    _, err := r.col().InsertOne(ctx, j)
+    if mgo.IsDuplicateKeyError(err) { return derr.ErrConflict }
+    return err
+}
+
+func (r *JobRepo) GetByID(ctx context.Context, id string) (*models.Job, error) {
+    var j models.Job
+    err := r.col().FindOne(ctx, bson.D{{Key: "job_id", Value: id}}).Decode(&j)
+    if errors.Is(err, mgo.ErrNoDocuments) { return nil, derr.ErrNotFound }
+    if err != nil { return nil, err }
+    return &j, nil
+}
+
+// ClaimNext leases the earliest due job in a single findOneAndUpdate.
+func (r *JobRepo) ClaimNext(ctx context.Context, now, leaseUntil time.Time) (*models.Job, error) {
+    filter := bson.D{
+        {Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{models.JobPending, models.JobRunning}}}},
+        {Key: "run_at", Value: bson.D{{Key: "$lte", Value: now}}},
+    }
+    update := bson.D{
+        {Key: "$set", Value: bson.D{{Key: "status", Value: models.JobRunning}, {Key: "run_at", Value: leaseUntil}, {Key: "updated_at", Value: now}}},
+        {Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+    }
+    opts := options.FindOneAndUpdate().
+        SetSort(bson.D{{Key: "run_at", Value: 1}, {Key: "job_id", Value: 1}}).
+        SetReturnDocument(options.After)
+    var j models.Job
+    err := r.col().FindOneAndUpdate(ctx, filter, update, opts).Decode(&j)
+    if errors.Is(err, mgo.ErrNoDocuments) { return nil, derr.ErrNotFound }
+    if err != nil { return nil, err }
+    return &j, nil
+}
+
+func leaseFilter(jobID string, attempt int) bson.D {
+    return bson.D{{Key: "job_id", Value: jobID}, {Key: "status", Value: models.JobRunning}, {Key: "attempts", Value: attempt}}
+}
+
+// lostLease tells a missing job from one that is no longer held under the attempt.
+func (r *JobRepo) lostLease(ctx context.Context, jobID string) error {
+    if _, err := r.GetByID(ctx, jobID); err != nil { return err }
+    return derr.ErrConflict
+}
+
+func (r *JobRepo) Complete(ctx context.Context, jobID string, attempt int) error {
+    res, err := r.col().DeleteOne(ctx, leaseFilter(jobID, attempt))
+    if err != nil { return err }
+    if res.DeletedCount == 0 { return r.lostLease(ctx, jobID) }
+    return nil
+}
+
+func (r *JobRepo) setLeased(ctx context.Context, jobID string, attempt int, set bson.D) error {
+    res, err := r.col().UpdateOne(ctx, leaseFilter(jobID, attempt), bson.D{{Key: "$set", Value: set}})
+    if err != nil { return err }
+    if res.MatchedCount == 0 { return r.lostLease(ctx, jobID) }
+    return nil
+}
+
+func (r *JobRepo) Retry(ctx context.Context, jobID string, attempt int, runAt time.Time, lastErr string, updatedAt time.Time) error {
+    return r.setLeased(ctx, jobID, attempt, bson.D{
+        {Key: "status", Value: models.JobPending},
+        {Key: "run_at", Value: runAt},
+        {Key: "last_error", Value: lastErr},
+        {Key: "updated_at", Value: updatedAt},
+    })
+}
+
+func (r *JobRepo) Bury(ctx context.Context, jobID string, attempt int, lastErr string, updatedAt time.Time) error {
+    return r.setLeased(ctx, jobID, attempt, bson.D{
+        {Key: "status", Value: models.JobDead},
+        {Key: "last_error", Value: lastErr},
+        {Key: "updated_at", Value: updatedAt},
+    })
+}
+
+func (r *JobRepo) ListDead(ctx context.Context) ([]models.Job, error) {
+    cur, err := r.col().Find(ctx, bson.D{{Key: "status", Value: models.JobDead}},
+        options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}, {Key: "job_id", Value: 1}}))
+    if err != nil { return nil, err }
+    out := []models.Job{}
+    if err := cur.All(ctx, &out); err != nil { return nil, err }
+    return out, nil
+}
+
+func (r *JobRepo) Requeue(ctx context.Context, jobID string, runAt time.Time, updatedAt time.Time) error {
+    res, err := r.col().UpdateOne(ctx,
+        bson.D{{Key: "job_id", Value: jobID}, {Key: "status", Value: models.JobDead}},
+        bson.D{{Key: "$set", Value: bson.D{
+            {Key: "status", Value: models.JobPending},
+            {Key: "attempts", Value: 0},
+            {Key: "run_at", Value: runAt},
+            {Key: "updated_at", Value: updatedAt},
+        }}})
+    return notFoundIfUnmatched(res, err)
+}
@@ -6,6 +6,7 @@ import (
 
 	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
 	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
 	"go.mongodb.org/mongo-driver/bson"
 	mgo "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -21,10 +22,20 @@ func (r *ListItemRepo) EnsureIndexes(ctx context.Context) error {
 		{Keys: bson.D{{Key: "item_id", Value: 1}}, Options: options.Index().SetUnique(true)},
 		{Keys: bson.D{{Key: "list_id", Value: 1}}},
 		{Keys: bson.D{{Key: "list_id", Value: 1}, {Key: "order", Value: 1}}},
+		{Keys: bson.D{{Key: "room_id", Value: 1}}},
+		{Keys: bson.D{{Key: "is_deleted", Value: 1}, {Key: "updated_at", Value: 1}}},
 	})
 	return err
 }
 
+// notTrashed matches items that are not in the trash.
+var notTrashed = bson.E{Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}
+
+// liveItem filters for the item unless it is in the trash.
+func liveItem(itemID string) bson.D {
+	return bson.D{{Key: "item_id", Value: itemID}, notTrashed}
+}
+
 func (r *ListItemRepo) Put(ctx context.Context, it *models.ListItem) error {
 	_, err := r.col().InsertOne(ctx, it)
 	return err
@@ -42,52 +53,87 @@ func (r *ListItemRepo) GetByID(ctx context.Context, id string) (*models.ListItem
 	return &it, nil
 }
 
-func (r *ListItemRepo) ListByList(ctx context.Context, listID string) ([]models.ListItem, error) {
-	// Sort by order (ascending), then created_at as a stable fallback.
-	cur, err := r.col().Find(ctx, bson.D{{Key: "list_id", Value: listID}}, options.Find().SetSort(bson.D{{Key: "order", Value: 1}, {Key: "created_at", Value: 1}}))
+func (r *ListItemRepo) ListByList(ctx context.Context, listID string, page store.Page) ([]models.ListItem, string, error) {
+	return r.page(ctx, bson.D{{Key: "list_id", Value: listID}, notTrashed}, page, store.ItemKey, func(c store.Cursor) []sortKey {
+		return []sortKey{{"order", 1, c.Order}, {"created_at", 1, c.Time.UTC()}, {"item_id", 1, c.ID}}
+	})
+}
+
+// page runs a paginated item query. keys gives the sort for a cursor's values
+// and must match the order of key.
+func (r *ListItemRepo) page(ctx context.Context, filter bson.D, page store.Page, key func(models.ListItem) store.Cursor, keys func(store.Cursor) []sortKey) ([]models.ListItem, string, error) {
+	var c store.Cursor
+	if page.Cursor != "" {
+		var err error
+		if c, err = store.DecodeCursor(page.Cursor); err != nil {
+			return nil, "", err
+		}
+		filter = append(filter, after(keys(c)...))
+	}
+	cur, err := r.col().Find(ctx, filter, pageFind(page, keys(c)...))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	var out []models.ListItem
 	if err := cur.All(ctx, &out); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return out, nil
+	return store.Trim(out, page.Limit, key)
 }
 
-func (r *ListItemRepo) UpdateCompletion(ctx context.Context, itemID string, completed bool, updatedAt time.Time) error {
-	_, err := r.col().UpdateOne(ctx, bson.D{{Key: "item_id", Value: itemID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "completed", Value: completed}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-	return err
+func (r *ListItemRepo) UpdateCompletion(ctx context.Context, itemID string, completed bool, ifVersion int64, updatedAt time.Time) error {
+	return updateIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "completed", Value: completed}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
 }
 
-func (r *ListItemRepo) UpdateDescription(ctx context.Context, itemID string, description string, updatedAt time.Time) error {
-	_, err := r.col().UpdateOne(ctx, bson.D{{Key: "item_id", Value: itemID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "description", Value: description}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-	return err
+func (r *ListItemRepo) UpdateDescription(ctx context.Context, itemID string, description string, ifVersion int64, updatedAt time.Time) error {
+	return updateIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "description", Value: description}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
 }
 
-func (r *ListItemRepo) UpdateOrder(ctx context.Context, itemID string, order float64, updatedAt time.Time) error {
-	_, err := r.col().UpdateOne(ctx, bson.D{{Key: "item_id", Value: itemID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "order", Value: order}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-	return err
+func (r *ListItemRepo) UpdateOrder(ctx context.Context, itemID string, order float64, ifVersion int64, updatedAt time.Time) error {
+	return updateIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "order", Value: order}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
 }
 
-func (r *ListItemRepo) UpdateQuantity(ctx context.Context, itemID string, quantity string, updatedAt time.Time) error {
-	_, err := r.col().UpdateOne(ctx, bson.D{{Key: "item_id", Value: itemID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "quantity", Value: quantity}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-	return err
+func (r *ListItemRepo) UpdateQuantity(ctx context.Context, itemID string, quantity string, ifVersion int64, updatedAt time.Time) error {
+	return updateIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "quantity", Value: quantity}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
 }
 
-func (r *ListItemRepo) UpdateUnit(ctx context.Context, itemID string, unit string, updatedAt time.Time) error {
-	_, err := r.col().UpdateOne(ctx, bson.D{{Key: "item_id", Value: itemID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "unit", Value: unit}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-	return err
+func (r *ListItemRepo) UpdateUnit(ctx context.Context, itemID string, unit string, ifVersion int64, updatedAt time.Time) error {
+	return updateIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "unit", Value: unit}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
 }
 
-func (r *ListItemRepo) UpdateCategory(ctx context.Context, itemID string, category string, updatedAt time.Time) error {
-	_, err := r.col().UpdateOne(ctx, bson.D{{Key: "item_id", Value: itemID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "category", Value: category}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-	return err
+func (r *ListItemRepo) UpdateCategory(ctx context.Context, itemID string, category string, ifVersion int64, updatedAt time.Time) error {
+	return updateIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "category", Value: category}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
 }
 
-func (r *ListItemRepo) UpdateStarred(ctx context.Context, itemID string, starred bool, updatedAt time.Time) error {
-	_, err := r.col().UpdateOne(ctx, bson.D{{Key: "item_id", Value: itemID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "is_starred", Value: starred}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-	return err
+func (r *ListItemRepo) UpdateStarred(ctx context.Context, itemID string, starred bool, ifVersion int64, updatedAt time.Time) error {
+	return updateIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "is_starred", Value: starred}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
+}
+
+func (r *ListItemRepo) Patch(ctx context.Context, itemID string, p store.ItemPatch, ifVersion int64, updatedAt time.Time) (*models.ListItem, error) {
+	set := bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}
+	if p.Description != nil {
+		set = append(set, bson.E{Key: "description", Value: *p.Description})
+	}
+	if p.Completed != nil {
+		set = append(set, bson.E{Key: "completed", Value: *p.Completed})
+	}
+	if p.Quantity != nil {
+		set = append(set, bson.E{Key: "quantity", Value: *p.Quantity})
+	}
+	if p.Unit != nil {
+		set = append(set, bson.E{Key: "unit", Value: *p.Unit})
+	}
+	if p.Category != nil {
+		set = append(set, bson.E{Key: "category", Value: *p.Category})
+	}
+	if p.Starred != nil {
+		set = append(set, bson.E{Key: "is_starred", Value: *p.Starred})
+	}
+	var it models.ListItem
+	if err := patchIfVersion(ctx, r.col(), ifVersion, liveItem(itemID), bson.D{{Key: "$set", Value: set}}, &it); err != nil {
+		return nil, err
+	}
+	return &it, nil
 }
 
 func (r *ListItemRepo) ArchiveCompletedByList(ctx context.Context, listID string, updatedAt time.Time) error {
@@ -95,22 +141,47 @@ func (r *ListItemRepo) ArchiveCompletedByList(ctx context.Context, listID string
 		{Key: "list_id", Value: listID},
 		{Key: "completed", Value: true},
 		{Key: "is_archived", Value: bson.D{{Key: "$ne", Value: true}}},
+		notTrashed,
 	}
 	update := bson.D{{Key: "$set", Value: bson.D{
 		{Key: "is_archived", Value: true},
 		{Key: "updated_at", Value: updatedAt.UTC()},
-	}}}
+	}}, bump}
 	_, err := r.col().UpdateMany(ctx, filter, update)
 	return err
 }
 
-func (r *ListItemRepo) ListArchivedByRoom(ctx context.Context, roomID string) ([]models.ListItem, error) {
+func (r *ListItemRepo) ListArchivedByRoom(ctx context.Context, roomID string, page store.Page) ([]models.ListItem, string, error) {
 	filter := bson.D{
 		{Key: "room_id", Value: roomID},
 		{Key: "is_archived", Value: true},
+		notTrashed,
+	}
+	// Most recently updated first.
+	return r.page(ctx, filter, page, store.ArchivedKey, func(c store.Cursor) []sortKey {
+		return []sortKey{{"updated_at", -1, c.Time.UTC()}, {"item_id", 1, c.ID}}
+	})
+}
+
+func (r *ListItemRepo) SoftDelete(ctx context.Context, itemID string, ts time.Time) error {
+	res, err := r.col().UpdateOne(ctx, liveItem(itemID), bson.D{{Key: "$set", Value: bson.D{{Key: "is_deleted", Value: true}, {Key: "updated_at", Value: ts.UTC()}}}, bump})
+	return notFoundIfUnmatched(res, err)
+}
+
+func (r *ListItemRepo) Restore(ctx context.Context, itemID string, updatedAt time.Time) error {
+	res, err := r.col().UpdateOne(ctx,
+		bson.D{{Key: "item_id", Value: itemID}, {Key: "is_deleted", Value: true}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "is_deleted", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}, bump},
+	)
+	return notFoundIfUnmatched(res, err)
+}
+
+func (r *ListItemRepo) ListTrashedByRoom(ctx context.Context, roomID string) ([]models.ListItem, error) {
+	filter := bson.D{
+		{Key: "room_id", Value: roomID},
+		{Key: "is_deleted", Value: true},
 	}
-	// Sort by updated_at descending to get most recent items first
-	cur, err := r.col().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}))
+	cur, err := r.col().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}, {Key: "item_id", Value: 1}}))
 	if err != nil {
 		return nil, err
 	}
@@ -121,7 +192,75 @@ func (r *ListItemRepo) ListArchivedByRoom(ctx context.Context, roomID string) ([
 	return out, nil
 }
 
+func (r *ListItemRepo) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	res, err := r.col().DeleteMany(ctx, bson.D{
+		{Key: "is_deleted", Value: true},
+		{Key: "updated_at", Value: bson.D{{Key: "$lt", Value: cutoff.UTC()}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
 func (r *ListItemRepo) Delete(ctx context.Context, itemID string) error {
-	_, err := r.col().DeleteOne(ctx, bson.D{{Key: "item_id", Value: itemID}})
-	return err
+	res, err := r.col().DeleteOne(ctx, bson.D{{Key: "item_id", Value: itemID}})
+	return notFoundIfNoneDeleted(res, err)
+}
+
+func (r *ListItemRepo) DeleteByList(ctx context.Context, listID string) (int, error) {
+	res, err := r.col().DeleteMany(ctx, bson.D{{Key: "list_id", Value: listID}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+func (r *ListItemRepo) MoveByList(ctx context.Context, fromListID, toListID, roomID string, orderOffset float64, updatedAt time.Time) (int, error) {
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "list_id", Value: toListID},
+			{Key: "room_id", Value: roomID},
+			{Key: "updated_at", Value: updatedAt.UTC()},
+		}},
+		// bump's $inc, with the order offset added.
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}, {Key: "order", Value: orderOffset}}},
+	}
+	res, err := r.col().UpdateMany(ctx, bson.D{{Key: "list_id", Value: fromListID}}, update)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.MatchedCount), nil
+}
+
+func (r *ListItemRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+	res, err := r.col().DeleteMany(ctx, bson.D{{Key: "room_id", Value: roomID}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+// ScanAll streams every item through fn.
+func (r *ListItemRepo) ScanAll(ctx context.Context, fn func(it models.ListItem) error) error {
+	return scanAll(ctx, r.col(), fn)
+}
+
+// scanAll streams every document of col through fn.
+func scanAll[T any](ctx context.Context, col *mgo.Collection, fn func(T) error) error {
+	cur, err := col.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var v T
+		if err := cur.Decode(&v); err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
 }
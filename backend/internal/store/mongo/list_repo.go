@@ -6,6 +6,7 @@ import (
 
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
     "go.mongodb.org/mongo-driver/bson"
     mgo "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
@@ -20,6 +21,7 @@ func (r *ListRepo) EnsureIndexes(ctx context.Context) error {
     _, err := r.col().Indexes().CreateMany(ctx, []mgo.IndexModel{
         {Keys: bson.D{{Key: "list_id", Value: 1}}, Options: options.Index().SetUnique(true)},
         {Keys: bson.D{{Key: "room_id", Value: 1}}},
+        {Keys: bson.D{{Key: "is_deleted", Value: 1}, {Key: "updated_at", Value: 1}}},
     })
     return err
 }
@@ -39,76 +41,94 @@ func (r *ListRepo) GetByID(ctx context.Context, id string) (*models.List, error)
     return &l, nil
 }
 
-func (r *ListRepo) ListByRoom(ctx context.Context, roomID string) ([]models.List, error) {
-    // Exclude soft-deleted lists
-    cur, err := r.col().Find(ctx, bson.D{{Key: "room_id", Value: roomID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}})
-    if err != nil { return nil, err }
-    var out []models.List
-    if err := cur.All(ctx, &out); err != nil { return nil, err }
-    return out, nil
+// ScanAll streams every list through fn.
+func (r *ListRepo) ScanAll(ctx context.Context, fn func(l models.List) error) error {
+    return scanAll(ctx, r.col(), fn)
+}
+
+func (r *ListRepo) ListByRoom(ctx context.Context, roomID string, page store.Page) ([]models.List, string, error) {
+    // Exclude soft-deleted lists; oldest first.
+    filter := bson.D{{Key: "room_id", Value: roomID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}}
+    var c store.Cursor
+    if page.Cursor != "" {
+        var err error
+        if c, err = store.DecodeCursor(page.Cursor); err != nil { return nil, "", err }
+    }
+    keys := []sortKey{{"created_at", 1, c.Time.UTC()}, {"list_id", 1, c.ID}}
+    if page.Cursor != "" { filter = append(filter, after(keys...)) }
+    out, err := r.find(ctx, filter, pageFind(page, keys...))
+    if err != nil { return nil, "", err }
+    return store.Trim(out, page.Limit, store.ListKey)
 }
 
-func (r *ListRepo) UpdateName(ctx context.Context, listID string, name string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx,
+func (r *ListRepo) UpdateName(ctx context.Context, listID string, name string, ifVersion int64, updatedAt time.Time) error {
+    return updateIfVersion(ctx, r.col(), ifVersion,
         bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
         bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}, {Key: "updated_at", Value: updatedAt.UTC()}}}},
     )
-    return err
 }
 
-func (r *ListRepo) UpdateDescription(ctx context.Context, listID string, description string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateDescription(ctx context.Context, listID string, description string, ifVersion int64, updatedAt time.Time) error {
     if description == "" {
-        _, err := r.col().UpdateOne(ctx,
+        return updateIfVersion(ctx, r.col(), ifVersion,
             bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
             bson.D{{Key: "$unset", Value: bson.D{{Key: "description", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}},
         )
-        return err
     }
-    _, err := r.col().UpdateOne(ctx,
+    return updateIfVersion(ctx, r.col(), ifVersion,
         bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
         bson.D{{Key: "$set", Value: bson.D{{Key: "description", Value: description}, {Key: "updated_at", Value: updatedAt.UTC()}}}},
     )
-    return err
 }
 
-func (r *ListRepo) UpdateNotes(ctx context.Context, listID string, notes string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateNotes(ctx context.Context, listID string, notes string, ifVersion int64, updatedAt time.Time) error {
     if notes == "" {
-        _, err := r.col().UpdateOne(ctx,
+        return updateIfVersion(ctx, r.col(), ifVersion,
             bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
             bson.D{{Key: "$unset", Value: bson.D{{Key: "notes", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}},
         )
-        return err
     }
-    _, err := r.col().UpdateOne(ctx,
+    return updateIfVersion(ctx, r.col(), ifVersion,
         bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
         bson.D{{Key: "$set", Value: bson.D{{Key: "notes", Value: notes}, {Key: "updated_at", Value: updatedAt.UTC()}}}},
     )
-    return err
 }
 
-func (r *ListRepo) UpdateIcon(ctx context.Context, listID string, icon string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateIcon(ctx context.Context, listID string, icon string, ifVersion int64, updatedAt time.Time) error {
     if icon == "" {
-        _, err := r.col().UpdateOne(ctx,
+        return updateIfVersion(ctx, r.col(), ifVersion,
             bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
             bson.D{{Key: "$unset", Value: bson.D{{Key: "icon", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}},
         )
-        return err
     }
-    _, err := r.col().UpdateOne(ctx,
+    return updateIfVersion(ctx, r.col(), ifVersion,
         bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
         bson.D{{Key: "$set", Value: bson.D{{Key: "icon", Value: icon}, {Key: "updated_at", Value: updatedAt.UTC()}}}},
     )
-    return err
+}
+
+func (r *ListRepo) Patch(ctx context.Context, listID string, p store.ListPatch, ifVersion int64, updatedAt time.Time) (*models.List, error) {
+    set, unset := bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}, bson.D{}
+    if p.Name != nil { set = append(set, bson.E{Key: "name", Value: *p.Name}) }
+    setOrUnset(&set, &unset, "description", p.Description)
+    setOrUnset(&set, &unset, "notes", p.Notes)
+    setOrUnset(&set, &unset, "icon", p.Icon)
+    var l models.List
+    err := patchIfVersion(ctx, r.col(), ifVersion,
+        bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
+        patchUpdate(set, unset), &l)
+    if err != nil { return nil, err }
+    return &l, nil
 }
 
 func (r *ListRepo) AddDeletionVote(ctx context.Context, listID string, userID string, ts time.Time) error {
-    _, err := r.col().UpdateOne(ctx, bson.D{{Key: "list_id", Value: listID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "deletion_votes." + userID, Value: ts.UTC().Format(time.RFC3339)}, {Key: "updated_at", Value: ts.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}}}, bson.D{{Key: "$set", Value: bson.D{{Key: "deletion_votes." + userID, Value: ts.UTC().Format(time.RFC3339)}, {Key: "updated_at", Value: ts.UTC()}}}, bump})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *ListRepo) RemoveDeletionVote(ctx context.Context, listID string, userID string) error {
-    _, err := r.col().UpdateOne(ctx, bson.D{{Key: "list_id", Value: listID}}, bson.D{{Key: "$unset", Value: bson.D{{Key: "deletion_votes." + userID, Value: ""}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, bson.D{{Key: "list_id", Value: listID}}, bson.D{{Key: "$unset", Value: bson.D{{Key: "deletion_votes." + userID, Value: ""}}}, bump})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *ListRepo) FinalizeDeleteIfVotedByAll(ctx context.Context, listID string, memberIDs []string, ts time.Time) (bool, error) {
@@ -118,12 +138,56 @@ func (r *ListRepo) FinalizeDeleteIfVotedByAll(ctx context.Context, listID string
         filter = append(filter, bson.E{Key: "deletion_votes." + uid, Value: bson.D{{Key: "$exists", Value: true}}})
     }
     filter = append(filter, bson.E{Key: "is_deleted", Value: bson.D{{Key: "$ne", Value: true}}})
-    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "is_deleted", Value: true}, {Key: "updated_at", Value: ts.UTC()}}}})
+    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "is_deleted", Value: true}, {Key: "updated_at", Value: ts.UTC()}}}, bump})
     if err != nil { return false, err }
     return res.ModifiedCount > 0, nil
 }
 
+func (r *ListRepo) Restore(ctx context.Context, listID string, updatedAt time.Time) error {
+    res, err := r.col().UpdateOne(ctx,
+        bson.D{{Key: "list_id", Value: listID}, {Key: "is_deleted", Value: true}},
+        bson.D{{Key: "$unset", Value: bson.D{{Key: "is_deleted", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "deletion_votes", Value: bson.D{}}, {Key: "updated_at", Value: updatedAt.UTC()}}}, bump},
+    )
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *ListRepo) MoveToRoom(ctx context.Context, listID string, roomID string, updatedAt time.Time) error {
+    res, err := r.col().UpdateOne(ctx,
+        bson.D{{Key: "list_id", Value: listID}},
+        bson.D{{Key: "$set", Value: bson.D{{Key: "room_id", Value: roomID}, {Key: "deletion_votes", Value: bson.D{}}, {Key: "updated_at", Value: updatedAt.UTC()}}}, bump},
+    )
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *ListRepo) ListTrashedByRoom(ctx context.Context, roomID string) ([]models.List, error) {
+    return r.find(ctx,
+        bson.D{{Key: "room_id", Value: roomID}, {Key: "is_deleted", Value: true}},
+        options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}, {Key: "list_id", Value: 1}}),
+    )
+}
+
+func (r *ListRepo) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.List, error) {
+    return r.find(ctx,
+        bson.D{{Key: "is_deleted", Value: true}, {Key: "updated_at", Value: bson.D{{Key: "$lt", Value: cutoff.UTC()}}}},
+        options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}}),
+    )
+}
+
+func (r *ListRepo) find(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]models.List, error) {
+    cur, err := r.col().Find(ctx, filter, opts)
+    if err != nil { return nil, err }
+    var out []models.List
+    if err := cur.All(ctx, &out); err != nil { return nil, err }
+    return out, nil
+}
+
 func (r *ListRepo) Delete(ctx context.Context, listID string) error {
-    _, err := r.col().DeleteOne(ctx, bson.D{{Key: "list_id", Value: listID}})
-    return err
+    res, err := r.col().DeleteOne(ctx, bson.D{{Key: "list_id", Value: listID}})
+    return notFoundIfNoneDeleted(res, err)
+}
+
+func (r *ListRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    res, err := r.col().DeleteMany(ctx, bson.D{{Key: "room_id", Value: roomID}})
+    if err != nil { return 0, err }
+    return int(res.DeletedCount), nil
 }
@@ -0,0 +1,39 @@
+package mongo
+
+import (
+    "context"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "go.mongodb.org/mongo-driver/bson"
+    mgo "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationRepo records applied data migrations in the migrations collection.
+type MigrationRepo struct{ db *mgo.Database }
+
+func NewMigrationRepo(c *Client) *MigrationRepo { return &MigrationRepo{db: c.DB} }
+
+func (r *MigrationRepo) col() *mgo.Collection { return r.db.Collection("migrations") }
+
+func (r *MigrationRepo) EnsureIndexes(ctx context.Context) error {
+    _, err := r.col().Indexes().CreateMany(ctx, []mgo.IndexModel{
+        {Keys: bson.D{{Key: "version", Value: 1}}, Options: options.Index().SetUnique(true)},
+    })
+    return err
+}
+
+func (r *MigrationRepo) ListApplied(ctx context.Context) ([]models.MigrationRecord, error) {
+    cur, err := r.col().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+    if err != nil { return nil, err }
+    var out []models.MigrationRecord
+    if err := cur.All(ctx, &out); err != nil { return nil, err }
+    return out, nil
+}
+
+func (r *MigrationRepo) Record(ctx context.Context, rec models.MigrationRecord) error {
+    _, err := r.col().InsertOne(ctx, rec)
+    if mgo.IsDuplicateKeyError(err) { return derr.ErrConflict }
+    return err
+}
@@ -9,6 +9,7 @@ import (
     "time"
 
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
 )
 
 func randHex(n int) string {
@@ -82,8 +83,8 @@ func TestMongoRoomRepoCRUD(t *testing.T) {
     if err := rr.VoteDeletion(context.Background(), rm.RoomID, "u2", time.Now().UTC()); err != nil { t.Fatalf("vote: %v", err) }
     if err := rr.RemoveDeletionVote(context.Background(), rm.RoomID, "u2"); err != nil { t.Fatalf("rm vote: %v", err) }
     // updates
-    if err := rr.UpdateDisplayName(context.Background(), rm.RoomID, "u2", "House", time.Now().UTC()); err != nil { t.Fatalf("upd dn: %v", err) }
-    if err := rr.UpdateDescription(context.Background(), rm.RoomID, "u2", "Desc", time.Now().UTC()); err != nil { t.Fatalf("upd desc: %v", err) }
+    if err := rr.UpdateDisplayName(context.Background(), rm.RoomID, "u2", "House", store.AnyVersion, time.Now().UTC()); err != nil { t.Fatalf("upd dn: %v", err) }
+    if err := rr.UpdateDescription(context.Background(), rm.RoomID, "u2", "Desc", store.AnyVersion, time.Now().UTC()); err != nil { t.Fatalf("upd desc: %v", err) }
     if err := rr.Delete(context.Background(), rm.RoomID); err != nil { t.Fatalf("delete: %v", err) }
 }
 
@@ -98,18 +99,18 @@ func TestMongoListRepos(t *testing.T) {
     l := &models.List{ListID: "list_"+randHex(4), RoomID: roomID, Name: "Groceries", CreatedAt: now, UpdatedAt: now}
     if err := lr.Put(context.Background(), l); err != nil { t.Fatalf("put list: %v", err) }
     if _, err := lr.GetByID(context.Background(), l.ListID); err != nil { t.Fatalf("get list: %v", err) }
-    if err := lr.UpdateName(context.Background(), l.ListID, "G1", time.Now().UTC()); err != nil { t.Fatalf("upd name: %v", err) }
-    if err := lr.UpdateDescription(context.Background(), l.ListID, "Weekly", time.Now().UTC()); err != nil { t.Fatalf("upd desc: %v", err) }
-    if err := lr.UpdateIcon(context.Background(), l.ListID, "HOUSE", time.Now().UTC()); err != nil { t.Fatalf("upd icon: %v", err) }
-    if _, err := lr.ListByRoom(context.Background(), roomID); err != nil { t.Fatalf("list by room: %v", err) }
+    if err := lr.UpdateName(context.Background(), l.ListID, "G1", store.AnyVersion, time.Now().UTC()); err != nil { t.Fatalf("upd name: %v", err) }
+    if err := lr.UpdateDescription(context.Background(), l.ListID, "Weekly", store.AnyVersion, time.Now().UTC()); err != nil { t.Fatalf("upd desc: %v", err) }
+    if err := lr.UpdateIcon(context.Background(), l.ListID, "HOUSE", store.AnyVersion, time.Now().UTC()); err != nil { t.Fatalf("upd icon: %v", err) }
+    if _, _, err := lr.ListByRoom(context.Background(), roomID, store.Page{}); err != nil { t.Fatalf("list by room: %v", err) }
 
     // Items
     it := &models.ListItem{ItemID: "item_"+randHex(4), ListID: l.ListID, RoomID: roomID, Description: "Milk", Completed: false, CreatedAt: now, UpdatedAt: now}
     if err := ir.Put(context.Background(), it); err != nil { t.Fatalf("put item: %v", err) }
     if _, err := ir.GetByID(context.Background(), it.ItemID); err != nil { t.Fatalf("get item: %v", err) }
-    if _, err := ir.ListByList(context.Background(), l.ListID); err != nil { t.Fatalf("list items: %v", err) }
-    if err := ir.UpdateCompletion(context.Background(), it.ItemID, true, time.Now().UTC()); err != nil { t.Fatalf("upd completion: %v", err) }
-    if err := ir.UpdateDescription(context.Background(), it.ItemID, "Bread", time.Now().UTC()); err != nil { t.Fatalf("upd desc: %v", err) }
+    if _, _, err := ir.ListByList(context.Background(), l.ListID, store.Page{}); err != nil { t.Fatalf("list items: %v", err) }
+    if err := ir.UpdateCompletion(context.Background(), it.ItemID, true, store.AnyVersion, time.Now().UTC()); err != nil { t.Fatalf("upd completion: %v", err) }
+    if err := ir.UpdateDescription(context.Background(), it.ItemID, "Bread", store.AnyVersion, time.Now().UTC()); err != nil { t.Fatalf("upd desc: %v", err) }
     if err := ir.Delete(context.Background(), it.ItemID); err != nil { t.Fatalf("del item: %v", err) }
 
     // Deletion votes
@@ -0,0 +1,56 @@
+package mongo
+
+import (
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sortKey is one field of a paginated query's sort, with the cursor's value
+// for it.
+type sortKey struct {
+	field string
+	dir   int
+	value any
+}
+
+// pageFind sorts by keys and, for a limited page, fetches one document past
+// the page so store.Trim can tell whether another page follows.
+func pageFind(page store.Page, keys ...sortKey) *options.FindOptions {
+	sort := bson.D{}
+	for _, k := range keys {
+		sort = append(sort, bson.E{Key: k.field, Value: k.dir})
+	}
+	opts := options.Find().SetSort(sort)
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit) + 1)
+	}
+	return opts
+}
+
+// after matches documents that sort strictly after the cursor values of keys.
+func after(keys ...sortKey) bson.E {
+	or := bson.A{}
+	for i, k := range keys {
+		clause := bson.D{}
+		for _, eq := range keys[:i] {
+			clause = append(clause, bson.E{Key: eq.field, Value: equal(eq.value)})
+		}
+		op := "$gt"
+		if k.dir < 0 {
+			op = "$lt"
+		}
+		clause = append(clause, bson.E{Key: k.field, Value: bson.D{{Key: op, Value: k.value}}})
+		or = append(or, clause)
+	}
+	return bson.E{Key: "$or", Value: or}
+}
+
+// equal matches v. Zero orders are omitted from item documents, so a zero
+// float also matches a missing field.
+func equal(v any) any {
+	if f, ok := v.(float64); ok && f == 0 {
+		return bson.D{{Key: "$in", Value: bson.A{0.0, nil}}}
+	}
+	return v
+}
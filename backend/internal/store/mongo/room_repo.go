@@ -6,6 +6,7 @@ import (
 
     derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
     "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
     "go.mongodb.org/mongo-driver/bson"
     mgo "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
@@ -40,6 +41,11 @@ func (r *RoomRepo) GetByID(ctx context.Context, id string) (*models.Room, error)
     return &rm, nil
 }
 
+// ScanAll streams every room through fn.
+func (r *RoomRepo) ScanAll(ctx context.Context, fn func(rm models.Room) error) error {
+    return scanAll(ctx, r.col(), fn)
+}
+
 func (r *RoomRepo) GetByShareToken(ctx context.Context, token string) (*models.Room, error) {
     var rm models.Room
     err := r.col().FindOne(ctx, bson.D{{Key: "share_token", Value: token}}).Decode(&rm)
@@ -51,66 +57,101 @@ func (r *RoomRepo) GetByShareToken(ctx context.Context, token string) (*models.R
 }
 
 func (r *RoomRepo) SetShareToken(ctx context.Context, roomID string, userID string, token string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx,
+    res, err := r.col().UpdateOne(ctx,
         bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: bson.D{{Key: "$in", Value: bson.A{userID}}}}},
-        bson.D{{Key: "$set", Value: bson.D{{Key: "share_token", Value: token}, {Key: "updated_at", Value: updatedAt.UTC()}}}},
+        bson.D{{Key: "$set", Value: bson.D{{Key: "share_token", Value: token}, {Key: "updated_at", Value: updatedAt.UTC()}}}, bump},
     )
-    return err
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *RoomRepo) RemoveShareToken(ctx context.Context, roomID string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx,
+    res, err := r.col().UpdateOne(ctx,
         bson.D{{Key: "room_id", Value: roomID}},
-        bson.D{{Key: "$unset", Value: bson.D{{Key: "share_token", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}},
+        bson.D{{Key: "$unset", Value: bson.D{{Key: "share_token", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}, bump},
     )
-    return err
+    return notFoundIfUnmatched(res, err)
 }
 
-func (r *RoomRepo) UpdateDescription(ctx context.Context, roomID string, userID string, description string, updatedAt time.Time) error {
+func (r *RoomRepo) UpdateDescription(ctx context.Context, roomID string, userID string, description string, ifVersion int64, updatedAt time.Time) error {
     if description == "" {
-        _, err := r.col().UpdateOne(ctx,
+        return updateIfVersion(ctx, r.col(), ifVersion,
             bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: bson.D{{Key: "$in", Value: bson.A{userID}}}}},
             bson.D{{Key: "$unset", Value: bson.D{{Key: "description", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}},
         )
-        return err
     }
-    _, err := r.col().UpdateOne(ctx,
+    return updateIfVersion(ctx, r.col(), ifVersion,
         bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: bson.D{{Key: "$in", Value: bson.A{userID}}}}},
         bson.D{{Key: "$set", Value: bson.D{{Key: "description", Value: description}, {Key: "updated_at", Value: updatedAt.UTC()}}}},
     )
-    return err
 }
 
-func (r *RoomRepo) UpdateDisplayName(ctx context.Context, roomID string, userID string, displayName string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx,
+func (r *RoomRepo) UpdateDisplayName(ctx context.Context, roomID string, userID string, displayName string, ifVersion int64, updatedAt time.Time) error {
+    return updateIfVersion(ctx, r.col(), ifVersion,
         bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: bson.D{{Key: "$in", Value: bson.A{userID}}}}},
         bson.D{{Key: "$set", Value: bson.D{{Key: "display_name", Value: displayName}, {Key: "updated_at", Value: updatedAt.UTC()}}}},
     )
-    return err
+}
+
+func (r *RoomRepo) Patch(ctx context.Context, roomID string, userID string, p store.RoomPatch, ifVersion int64, updatedAt time.Time) (*models.Room, error) {
+    set, unset := bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}, bson.D{}
+    if p.DisplayName != nil { set = append(set, bson.E{Key: "display_name", Value: *p.DisplayName}) }
+    setOrUnset(&set, &unset, "description", p.Description)
+    var rm models.Room
+    err := patchIfVersion(ctx, r.col(), ifVersion,
+        bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: bson.D{{Key: "$in", Value: bson.A{userID}}}}},
+        patchUpdate(set, unset), &rm)
+    if err != nil { return nil, err }
+    return &rm, nil
 }
 
 func (r *RoomRepo) VoteDeletion(ctx context.Context, roomID string, userID string, ts time.Time) error {
-    _, err := r.col().UpdateOne(ctx, bson.D{{Key: "room_id", Value: roomID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "deletion_votes." + userID, Value: ts.UTC().Format(time.RFC3339)}, {Key: "updated_at", Value: ts.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, bson.D{{Key: "room_id", Value: roomID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "deletion_votes." + userID, Value: ts.UTC().Format(time.RFC3339)}, {Key: "updated_at", Value: ts.UTC()}}}, bump})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *RoomRepo) RemoveDeletionVote(ctx context.Context, roomID string, userID string) error {
-    _, err := r.col().UpdateOne(ctx, bson.D{{Key: "room_id", Value: roomID}}, bson.D{{Key: "$unset", Value: bson.D{{Key: "deletion_votes." + userID, Value: ""}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, bson.D{{Key: "room_id", Value: roomID}}, bson.D{{Key: "$unset", Value: bson.D{{Key: "deletion_votes." + userID, Value: ""}}}, bump})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *RoomRepo) Delete(ctx context.Context, roomID string) error {
-    _, err := r.col().DeleteOne(ctx, bson.D{{Key: "room_id", Value: roomID}})
-    return err
+    res, err := r.col().DeleteOne(ctx, bson.D{{Key: "room_id", Value: roomID}})
+    return notFoundIfNoneDeleted(res, err)
 }
 
 func (r *RoomRepo) AddMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error {
     // add userID if not present and ensure max two members by checking in service
-    _, err := r.col().UpdateOne(ctx, bson.D{{Key: "room_id", Value: roomID}}, bson.D{{Key: "$addToSet", Value: bson.D{{Key: "member_ids", Value: userID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx,
+        bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: bson.D{{Key: "$ne", Value: userID}}}},
+        bson.D{{Key: "$push", Value: bson.D{{Key: "member_ids", Value: userID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}, bump},
+    )
+    if err != nil { return err }
+    if res.MatchedCount == 0 {
+        // Either the room is missing or userID is already a member.
+        if _, err := r.GetByID(ctx, roomID); err != nil { return err }
+        return derr.ErrConflict
+    }
+    return nil
 }
 
 func (r *RoomRepo) RemoveMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx, bson.D{{Key: "room_id", Value: roomID}}, bson.D{{Key: "$pull", Value: bson.D{{Key: "member_ids", Value: userID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx,
+        bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: userID}},
+        bson.D{{Key: "$pull", Value: bson.D{{Key: "member_ids", Value: userID}}}, {Key: "$unset", Value: bson.D{{Key: "roles." + userID, Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}, bump},
+    )
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *RoomRepo) SetRoles(ctx context.Context, roomID string, roles map[string]models.Role, updatedAt time.Time) error {
+    uids := bson.A{}
+    set := bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}
+    for uid, role := range roles {
+        uids = append(uids, uid)
+        set = append(set, bson.E{Key: "roles." + uid, Value: role})
+    }
+    res, err := r.col().UpdateOne(ctx,
+        bson.D{{Key: "room_id", Value: roomID}, {Key: "member_ids", Value: bson.D{{Key: "$all", Value: uids}}}},
+        bson.D{{Key: "$set", Value: set}, bump},
+    )
+    return notFoundIfUnmatched(res, err)
 }
@@ -0,0 +1,118 @@
+package mongo
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "go.mongodb.org/mongo-driver/bson"
+    mgo "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionRepo stores sessions in the sessions collection.
+type SessionRepo struct{ db *mgo.Database }
+
+func NewSessionRepo(c *Client) *SessionRepo { return &SessionRepo{db: c.DB} }
+
+func (r *SessionRepo) col() *mgo.Collection { return r.db.Collection("sessions") }
+
+func (r *SessionRepo) EnsureIndexes(ctx context.Context) error {
+    idx := r.col().Indexes()
+    // Token sessions have no key_lookup; drop the first, non-partial unique
+    // index so they do not collide on a missing field.
+    cur, err := idx.List(ctx)
+    if err == nil {
+        for cur.Next(ctx) {
+            var m bson.M
+            _ = cur.Decode(&m)
+            if name, _ := m["name"].(string); name == "key_lookup_1" {
+                _, _ = idx.DropOne(ctx, name)
+            }
+        }
+        _ = cur.Close(ctx)
+    }
+    _, err = idx.CreateMany(ctx, []mgo.IndexModel{
+        {Keys: bson.D{{Key: "session_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+        {
+            Keys: bson.D{{Key: "key_lookup", Value: 1}},
+            Options: options.Index().SetUnique(true).SetName("key_lookup_unique").
+                SetPartialFilterExpression(bson.D{{Key: "key_lookup", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+        },
+        {Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_used_at", Value: -1}}},
+        {Keys: bson.D{{Key: "expires_at", Value: 1}}},
+    })
+    return err
+}
+
+func filterBySessionID(id string) bson.D { return bson.D{{Key: "session_id", Value: id}} }
+
+func (r *SessionRepo) Create(ctx context.Context, s *models.Session) error {
+    _, err := r.col().InsertOne(ctx, s)
+    if mgo.IsDuplicateKeyError(err) { return derr.ErrConflict }
+    return err
+}
+
+func (r *SessionRepo) get(ctx context.Context, filter bson.D) (*models.Session, error) {
+    var s models.Session
+    err := r.col().FindOne(ctx, filter).Decode(&s)
+    if errors.Is(err, mgo.ErrNoDocuments) { return nil, derr.ErrNotFound }
+    if err != nil { return nil, err }
+    return &s, nil
+}
+
+func (r *SessionRepo) GetByID(ctx context.Context, id string) (*models.Session, error) {
+    return r.get(ctx, filterBySessionID(id))
+}
+
+func (r *SessionRepo) GetByKeyLookup(ctx context.Context, lookup string) (*models.Session, error) {
+    return r.get(ctx, bson.D{{Key: "key_lookup", Value: lookup}})
+}
+
+func (r *SessionRepo) ListByUser(ctx context.Context, userID string) ([]models.Session, error) {
+    opts := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}, {Key: "session_id", Value: 1}})
+    cur, err := r.col().Find(ctx, bson.D{{Key: "user_id", Value: userID}}, opts)
+    if err != nil { return nil, err }
+    var out []models.Session
+    if err := cur.All(ctx, &out); err != nil { return nil, err }
+    return out, nil
+}
+
+func (r *SessionRepo) Touch(ctx context.Context, sessionID string, lastUsedAt time.Time) error {
+    res, err := r.col().UpdateOne(ctx, filterBySessionID(sessionID), bson.D{{Key: "$set", Value: bson.D{{Key: "last_used_at", Value: lastUsedAt.UTC()}}}})
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *SessionRepo) Rotate(ctx context.Context, sessionID string, gen int64, lastUsedAt time.Time, expiresAt *time.Time) error {
+    set := bson.D{{Key: "last_used_at", Value: lastUsedAt.UTC()}}
+    update := bson.D{{Key: "$inc", Value: bson.D{{Key: "refresh_gen", Value: 1}}}}
+    if expiresAt != nil {
+        set = append(set, bson.E{Key: "expires_at", Value: expiresAt.UTC()})
+    } else {
+        update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "expires_at", Value: ""}}})
+    }
+    update = append(update, bson.E{Key: "$set", Value: set})
+    res, err := r.col().UpdateOne(ctx, bson.D{{Key: "session_id", Value: sessionID}, {Key: "refresh_gen", Value: gen}}, update)
+    if err := notFoundIfUnmatched(res, err); !errors.Is(err, derr.ErrNotFound) { return err }
+    if _, err := r.GetByID(ctx, sessionID); err != nil { return err }
+    return derr.ErrConflict
+}
+
+func (r *SessionRepo) Delete(ctx context.Context, sessionID string) error {
+    res, err := r.col().DeleteOne(ctx, filterBySessionID(sessionID))
+    return notFoundIfNoneDeleted(res, err)
+}
+
+func (r *SessionRepo) DeleteByUser(ctx context.Context, userID string) (int, error) {
+    res, err := r.col().DeleteMany(ctx, bson.D{{Key: "user_id", Value: userID}})
+    if err != nil { return 0, err }
+    return int(res.DeletedCount), nil
+}
+
+func (r *SessionRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    res, err := r.col().DeleteMany(ctx, bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lt", Value: cutoff.UTC()}}}})
+    if err != nil { return 0, err }
+    return int(res.DeletedCount), nil
+}
@@ -82,6 +82,11 @@ func (r *UserRepo) GetByID(ctx context.Context, id string) (*models.User, error)
     return &u, nil
 }
 
+// ScanAll streams every user through fn.
+func (r *UserRepo) ScanAll(ctx context.Context, fn func(u models.User) error) error {
+    return scanAll(ctx, r.col(), fn)
+}
+
 func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.User, error) {
     var u models.User
     err := r.col().FindOne(ctx, bson.D{{Key: "username", Value: username}}).Decode(&u)
@@ -108,35 +113,108 @@ func (r *UserRepo) SetAPIKey(ctx context.Context, userID string, hash, lookup st
         setDoc = append(setDoc, bson.E{Key: "api_key_expires_at", Value: expiresAt.UTC()})
     }
     update := bson.D{{Key: "$set", Value: setDoc}}
-    _, err := r.col().UpdateOne(ctx, filterByUserID(userID), update)
-    return err
+    res, err := r.col().UpdateOne(ctx, filterByUserID(userID), update)
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *UserRepo) ClearAPIKey(ctx context.Context, userID, lookup string) error {
+    filter := append(filterByUserID(userID), bson.E{Key: "api_key_lookup", Value: lookup})
+    unset := bson.D{{Key: "api_key_hash", Value: ""}, {Key: "api_key_lookup", Value: ""}, {Key: "api_key_expires_at", Value: ""}}
+    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$unset", Value: unset}})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *UserRepo) UpdateName(ctx context.Context, userID string, name string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *UserRepo) SetRoomID(ctx context.Context, userID string, roomID *string, updatedAt time.Time) error {
     if roomID == nil || *roomID == "" {
-        _, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$unset", Value: bson.D{{Key: "room_id", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}})
-        return err
+        res, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$unset", Value: bson.D{{Key: "room_id", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}})
+        return notFoundIfUnmatched(res, err)
     }
-    _, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$set", Value: bson.D{{Key: "room_id", Value: *roomID}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$set", Value: bson.D{{Key: "room_id", Value: *roomID}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *UserRepo) AddRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error {
+    filter := bson.D{{Key: "$and", Value: bson.A{filterByUserID(userID), bson.D{{Key: "room_ids", Value: bson.D{{Key: "$ne", Value: roomID}}}}}}}
+    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$push", Value: bson.D{{Key: "room_ids", Value: roomID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}})
+    if err != nil { return err }
+    if res.MatchedCount == 0 {
+        // Either the user is missing or roomID is already there.
+        if _, err := r.GetByID(ctx, userID); err != nil { return err }
+        return derr.ErrConflict
+    }
+    return nil
+}
+
+func (r *UserRepo) RemoveRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error {
+    filter := bson.D{{Key: "$and", Value: bson.A{filterByUserID(userID), bson.D{{Key: "room_ids", Value: roomID}}}}}
+    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$pull", Value: bson.D{{Key: "room_ids", Value: roomID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}}})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *UserRepo) UpdateUsername(ctx context.Context, userID string, username string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$set", Value: bson.D{{Key: "username", Value: username}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{
+        {Key: "$set", Value: bson.D{{Key: "username", Value: username}, {Key: "updated_at", Value: updatedAt.UTC()}}},
+        {Key: "$unset", Value: bson.D{{Key: "email_verified_at", Value: ""}}},
+    })
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *UserRepo) MarkEmailVerified(ctx context.Context, userID, username string, at time.Time) error {
+    filter := bson.D{{Key: "$and", Value: bson.A{filterByUserID(userID), bson.D{{Key: "username", Value: username}}}}}
+    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "email_verified_at", Value: at.UTC()}}}})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *UserRepo) UpdatePasswordEnc(ctx context.Context, userID string, enc string, updatedAt time.Time) error {
-    _, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$set", Value: bson.D{{Key: "password_enc", Value: enc}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
-    return err
+    res, err := r.col().UpdateOne(ctx, filterByUserID(userID), bson.D{{Key: "$set", Value: bson.D{{Key: "password_enc", Value: enc}, {Key: "updated_at", Value: updatedAt.UTC()}}}})
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *UserRepo) SetTOTPSecret(ctx context.Context, userID, secretEnc string, updatedAt time.Time) error {
+    update := bson.D{
+        {Key: "$set", Value: bson.D{{Key: "totp_secret_enc", Value: secretEnc}, {Key: "updated_at", Value: updatedAt.UTC()}}},
+        {Key: "$unset", Value: bson.D{{Key: "totp_enabled_at", Value: ""}}},
+    }
+    if secretEnc == "" {
+        update = bson.D{
+            {Key: "$set", Value: bson.D{{Key: "updated_at", Value: updatedAt.UTC()}}},
+            {Key: "$unset", Value: bson.D{{Key: "totp_secret_enc", Value: ""}, {Key: "totp_enabled_at", Value: ""}}},
+        }
+    }
+    res, err := r.col().UpdateOne(ctx, filterByUserID(userID), update)
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *UserRepo) EnableTOTP(ctx context.Context, userID, secretEnc string, at time.Time) error {
+    filter := bson.D{{Key: "$and", Value: bson.A{filterByUserID(userID), bson.D{{Key: "totp_secret_enc", Value: secretEnc}}}}}
+    res, err := r.col().UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "totp_enabled_at", Value: at.UTC()}}}})
+    return notFoundIfUnmatched(res, err)
+}
+
+func (r *UserRepo) ReencryptSecrets(ctx context.Context, userID, oldPassword, newPassword, oldTOTP, newTOTP string) error {
+    conds, set := bson.A{filterByUserID(userID)}, bson.D{}
+    if oldPassword != "" {
+        conds = append(conds, bson.D{{Key: "password_enc", Value: oldPassword}})
+        set = append(set, bson.E{Key: "password_enc", Value: newPassword})
+    }
+    if oldTOTP != "" {
+        conds = append(conds, bson.D{{Key: "totp_secret_enc", Value: oldTOTP}})
+        set = append(set, bson.E{Key: "totp_secret_enc", Value: newTOTP})
+    }
+    if len(set) == 0 {
+        _, err := r.GetByID(ctx, userID)
+        return err
+    }
+    res, err := r.col().UpdateOne(ctx, bson.D{{Key: "$and", Value: conds}}, bson.D{{Key: "$set", Value: set}})
+    return notFoundIfUnmatched(res, err)
 }
 
 func (r *UserRepo) Delete(ctx context.Context, userID string) error {
-    _, err := r.col().DeleteOne(ctx, filterByUserID(userID))
-    return err
+    res, err := r.col().DeleteOne(ctx, filterByUserID(userID))
+    return notFoundIfNoneDeleted(res, err)
 }
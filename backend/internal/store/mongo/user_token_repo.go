@@ -0,0 +1,60 @@
+package mongo
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "go.mongodb.org/mongo-driver/bson"
+    mgo "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserTokenRepo stores single-use user tokens in the user_tokens collection.
+type UserTokenRepo struct{ db *mgo.Database }
+
+func NewUserTokenRepo(c *Client) *UserTokenRepo { return &UserTokenRepo{db: c.DB} }
+
+func (r *UserTokenRepo) col() *mgo.Collection { return r.db.Collection("user_tokens") }
+
+func (r *UserTokenRepo) EnsureIndexes(ctx context.Context) error {
+    _, err := r.col().Indexes().CreateMany(ctx, []mgo.IndexModel{
+        {Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+        {Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+        {Keys: bson.D{{Key: "expires_at", Value: 1}}},
+    })
+    return err
+}
+
+func (r *UserTokenRepo) Create(ctx context.Context, t *models.UserToken) error {
+    _, err := r.col().InsertOne(ctx, t)
+    if mgo.IsDuplicateKeyError(err) { return derr.ErrConflict }
+    return err
+}
+
+// ScanAll streams every token through fn.
+func (r *UserTokenRepo) ScanAll(ctx context.Context, fn func(t models.UserToken) error) error {
+    return scanAll(ctx, r.col(), fn)
+}
+
+func (r *UserTokenRepo) Consume(ctx context.Context, hash, purpose string) (*models.UserToken, error) {
+    var t models.UserToken
+    err := r.col().FindOneAndDelete(ctx, bson.D{{Key: "token_hash", Value: hash}, {Key: "purpose", Value: purpose}}).Decode(&t)
+    if errors.Is(err, mgo.ErrNoDocuments) { return nil, derr.ErrNotFound }
+    if err != nil { return nil, err }
+    return &t, nil
+}
+
+func (r *UserTokenRepo) DeleteByUser(ctx context.Context, userID, purpose string) (int, error) {
+    res, err := r.col().DeleteMany(ctx, bson.D{{Key: "user_id", Value: userID}, {Key: "purpose", Value: purpose}})
+    if err != nil { return 0, err }
+    return int(res.DeletedCount), nil
+}
+
+func (r *UserTokenRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    res, err := r.col().DeleteMany(ctx, bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lt", Value: cutoff.UTC()}}}})
+    if err != nil { return 0, err }
+    return int(res.DeletedCount), nil
+}
@@ -0,0 +1,86 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
+	"go.mongodb.org/mongo-driver/bson"
+	mgo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bump is appended to every room, list and item update document.
+var bump = bson.E{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}
+
+// updateIfVersion updates the document matching filter and bumps its version,
+// requiring the version to equal ifVersion unless it is store.AnyVersion.
+// When a checked update matches nothing, filter alone tells a stale version
+// (derr.ErrPreconditionFailed) from a missing document.
+func updateIfVersion(ctx context.Context, col *mgo.Collection, ifVersion int64, filter, update bson.D) error {
+	res, err := col.UpdateOne(ctx, withVersion(filter, ifVersion), append(update, bump))
+	if err := notFoundIfUnmatched(res, err); err != derr.ErrNotFound || ifVersion == store.AnyVersion {
+		return err
+	}
+	return staleOrMissing(ctx, col, filter)
+}
+
+// patchIfVersion is updateIfVersion for a single atomic patch: it decodes the
+// updated document into out.
+func patchIfVersion(ctx context.Context, col *mgo.Collection, ifVersion int64, filter, update bson.D, out any) error {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := col.FindOneAndUpdate(ctx, withVersion(filter, ifVersion), append(update, bump), opts).Decode(out)
+	if !errors.Is(err, mgo.ErrNoDocuments) {
+		return err
+	}
+	if ifVersion == store.AnyVersion {
+		return derr.ErrNotFound
+	}
+	return staleOrMissing(ctx, col, filter)
+}
+
+// setOrUnset adds field to set, or to unset when v is empty, unless v is nil.
+func setOrUnset(set, unset *bson.D, field string, v *string) {
+	switch {
+	case v == nil:
+	case *v == "":
+		*unset = append(*unset, bson.E{Key: field, Value: ""})
+	default:
+		*set = append(*set, bson.E{Key: field, Value: *v})
+	}
+}
+
+// patchUpdate builds the update document of a patch from its $set and $unset fields.
+func patchUpdate(set, unset bson.D) bson.D {
+	update := bson.D{{Key: "$set", Value: set}}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update
+}
+
+// withVersion adds the version check of ifVersion to filter.
+func withVersion(filter bson.D, ifVersion int64) bson.D {
+	if ifVersion == store.AnyVersion {
+		return filter
+	}
+	// Documents written before versioning have no version field.
+	var version any = ifVersion
+	if ifVersion == 0 {
+		version = bson.D{{Key: "$in", Value: bson.A{0, nil}}}
+	}
+	return append(append(bson.D{}, filter...), bson.E{Key: "version", Value: version})
+}
+
+// staleOrMissing explains a checked update that matched nothing.
+func staleOrMissing(ctx context.Context, col *mgo.Collection, filter bson.D) error {
+	n, err := col.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return derr.ErrNotFound
+	}
+	return derr.ErrPreconditionFailed
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// Page selects one page of a paginated query. A zero Limit returns every
+// result after Cursor; an empty Cursor starts at the beginning.
+//
+// Paginated queries return the cursor of the next page alongside the
+// results, or "" when there is nothing after them. Cursors are opaque to
+// callers and only valid for the query that produced them.
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+// Cursor is the sort key of the last result of a page. Each query fills the
+// fields its ordering uses:
+//
+//   - ListItemRepository.ListByList: Order, Time (created_at), ID (item_id), ascending
+//   - ListItemRepository.ListArchivedByRoom: Time (updated_at) descending, ID (item_id) ascending
+//   - ListRepository.ListByRoom: Time (created_at), ID (list_id), ascending
+type Cursor struct {
+	Order float64   `json:"o,omitempty"`
+	Time  time.Time `json:"t"`
+	ID    string    `json:"id"`
+}
+
+func EncodeCursor(c Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a cursor from EncodeCursor. A malformed cursor is
+// derr.ErrBadRequest.
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.ID == "" {
+		return Cursor{}, derr.ErrBadRequest
+	}
+	return c, nil
+}
+
+// ItemKey is an item's position in ListByList.
+func ItemKey(it models.ListItem) Cursor {
+	return Cursor{Order: it.Order, Time: it.CreatedAt, ID: it.ItemID}
+}
+
+// ArchivedKey is an item's position in ListArchivedByRoom.
+func ArchivedKey(it models.ListItem) Cursor { return Cursor{Time: it.UpdatedAt, ID: it.ItemID} }
+
+// ListKey is a list's position in ListByRoom.
+func ListKey(l models.List) Cursor { return Cursor{Time: l.CreatedAt, ID: l.ListID} }
+
+// CompareAscending orders keys by Order, Time and ID.
+func CompareAscending(a, b Cursor) int {
+	if c := cmp.Compare(a.Order, b.Order); c != 0 {
+		return c
+	}
+	if c := a.Time.Compare(b.Time); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// CompareNewestFirst orders keys by Time descending, then ID.
+func CompareNewestFirst(a, b Cursor) int {
+	if c := b.Time.Compare(a.Time); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// Paginate sorts all by key and compare and returns the requested page with
+// the next cursor. Backends that cannot seek natively read every candidate
+// and page through them with it.
+func Paginate[T any](all []T, page Page, key func(T) Cursor, compare func(a, b Cursor) int) ([]T, string, error) {
+	slices.SortStableFunc(all, func(a, b T) int { return compare(key(a), key(b)) })
+	if page.Cursor != "" {
+		after, err := DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		all = all[sort.Search(len(all), func(i int) bool { return compare(key(all[i]), after) > 0 }):]
+	}
+	return Trim(all, page.Limit, key)
+}
+
+// Trim cuts a result fetched with limit+1 rows down to limit and returns the
+// cursor of the next page when the extra row shows there is one.
+func Trim[T any](rows []T, limit int, key func(T) Cursor) ([]T, string, error) {
+	if limit <= 0 || len(rows) <= limit {
+		return rows, "", nil
+	}
+	rows = rows[:limit]
+	return rows, EncodeCursor(key(rows[limit-1])), nil
+}
@@ -18,54 +18,322 @@ type UserRepository interface {
 	GetByUsername(ctx context.Context, username string) (*models.User, error)
 	GetByAPIKeyLookup(ctx context.Context, lookup string) (*models.User, error)
 	SetAPIKey(ctx context.Context, userID string, hash, lookup string, expiresAt *time.Time, updatedAt time.Time) error
+	// ClearAPIKey removes the user's API key while its lookup is still lookup,
+	// leaving updated_at alone. It returns derr.ErrNotFound once the key has
+	// been replaced or removed, or the user deleted.
+	ClearAPIKey(ctx context.Context, userID, lookup string) error
 	UpdateName(ctx context.Context, userID string, name string, updatedAt time.Time) error
+	// SetRoomID sets the user's active room, or clears it when roomID is nil.
 	SetRoomID(ctx context.Context, userID string, roomID *string, updatedAt time.Time) error
+	// AddRoom appends roomID to the user's RoomIDs. It fails with
+	// derr.ErrConflict if it is already there.
+	AddRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error
+	// RemoveRoom takes roomID out of the user's RoomIDs, leaving RoomID alone.
+	// It returns derr.ErrNotFound unless roomID is there.
+	RemoveRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error
+	// UpdateUsername sets the username and clears EmailVerifiedAt: the new
+	// address has not been verified.
 	UpdateUsername(ctx context.Context, userID string, username string, updatedAt time.Time) error
+	// MarkEmailVerified sets EmailVerifiedAt while the username is still
+	// username. It returns derr.ErrNotFound once the username has changed or
+	// the user is deleted.
+	MarkEmailVerified(ctx context.Context, userID, username string, at time.Time) error
 	UpdatePasswordEnc(ctx context.Context, userID string, enc string, updatedAt time.Time) error
+	// SetTOTPSecret stores a TOTP secret awaiting confirmation and clears
+	// TOTPEnabledAt; an empty secretEnc turns two-factor login off.
+	SetTOTPSecret(ctx context.Context, userID, secretEnc string, updatedAt time.Time) error
+	// EnableTOTP sets TOTPEnabledAt while the secret is still secretEnc. It
+	// returns derr.ErrNotFound once the secret has changed or the user is
+	// deleted.
+	EnableTOTP(ctx context.Context, userID, secretEnc string, at time.Time) error
+	// ReencryptSecrets replaces PasswordEnc with newPassword and
+	// TOTPSecretEnc with newTOTP while they are still oldPassword and
+	// oldTOTP, leaving updated_at alone; an empty old value leaves that field
+	// alone. It returns derr.ErrNotFound once either has changed or the user
+	// is deleted.
+	ReencryptSecrets(ctx context.Context, userID, oldPassword, newPassword, oldTOTP, newTOTP string) error
 	Delete(ctx context.Context, userID string) error
 }
 
+// SessionRepository stores sessions, the signed-in devices of users.
+type SessionRepository interface {
+	// Create fails with derr.ErrConflict if the session ID or a non-empty
+	// key lookup is taken.
+	Create(ctx context.Context, s *models.Session) error
+	GetByID(ctx context.Context, id string) (*models.Session, error)
+	GetByKeyLookup(ctx context.Context, lookup string) (*models.Session, error)
+	// ListByUser returns the user's sessions, most recently used first.
+	ListByUser(ctx context.Context, userID string) ([]models.Session, error)
+	Touch(ctx context.Context, sessionID string, lastUsedAt time.Time) error
+	// Rotate advances RefreshGen from gen to gen+1 and records the use and
+	// the new expiry. It fails with derr.ErrConflict if the session is no
+	// longer at gen, so each refresh token is redeemed at most once.
+	Rotate(ctx context.Context, sessionID string, gen int64, lastUsedAt time.Time, expiresAt *time.Time) error
+	Delete(ctx context.Context, sessionID string) error
+	// DeleteByUser removes every session of a user and returns how many were removed.
+	DeleteByUser(ctx context.Context, userID string) (int, error)
+	// DeleteExpired removes sessions that expired before cutoff and returns
+	// how many were removed.
+	DeleteExpired(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// UserTokenRepository stores single-use user tokens by hash.
+type UserTokenRepository interface {
+	// Create fails with derr.ErrConflict if the hash is taken.
+	Create(ctx context.Context, t *models.UserToken) error
+	// Consume deletes and returns the token with hash made for purpose, so
+	// that it redeems once; derr.ErrNotFound if there is none. Expiry is up
+	// to the caller.
+	Consume(ctx context.Context, hash, purpose string) (*models.UserToken, error)
+	// DeleteByUser removes the user's tokens for purpose and returns how many
+	// were removed.
+	DeleteByUser(ctx context.Context, userID, purpose string) (int, error)
+	// DeleteExpired removes tokens that expired before cutoff and returns how
+	// many were removed.
+	DeleteExpired(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// CounterRepository keeps expiring counters shared by every server, such as
+// rate limit windows (it implements ratelimit.Store).
+type CounterRepository interface {
+	// Incr adds one to the counter key and returns the new count. A new
+	// counter starts at one and expires at expiresAt; later increments keep
+	// that expiry, so keys should not be reused once they expire.
+	Incr(ctx context.Context, key string, expiresAt time.Time) (int64, error)
+	// Get returns the count of key, or 0 if it is missing or expired at now.
+	Get(ctx context.Context, key string, now time.Time) (int64, error)
+	// Delete removes the counter key; a missing counter is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeleteExpired removes counters that expired before cutoff and returns
+	// how many were removed.
+	DeleteExpired(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// AnyVersion is the ifVersion of an unconditional update.
+//
+// Every write to a room, list or item increments its Version. Update methods
+// taking ifVersion apply only while the stored Version equals it and fail
+// with derr.ErrPreconditionFailed otherwise; a missing record is still
+// derr.ErrNotFound.
+const AnyVersion int64 = -1
+
+// RoomPatch is a partial update of a room's settings; nil fields are left as
+// they are. An empty Description clears it.
+type RoomPatch struct {
+	DisplayName *string
+	Description *string
+}
+
+// ListPatch is a partial update of a list; nil fields are left as they are.
+// An empty Description, Notes or Icon clears it.
+type ListPatch struct {
+	Name        *string
+	Description *string
+	Notes       *string
+	Icon        *string
+}
+
+// ItemPatch is a partial update of a list item; nil fields are left as they are.
+type ItemPatch struct {
+	Description *string
+	Completed   *bool
+	Quantity    *string
+	Unit        *string
+	Category    *string
+	Starred     *bool
+}
+
 type RoomRepository interface {
 	Put(ctx context.Context, r *models.Room) error
 	GetByID(ctx context.Context, id string) (*models.Room, error)
 	GetByShareToken(ctx context.Context, token string) (*models.Room, error)
 	SetShareToken(ctx context.Context, roomID string, userID string, token string, updatedAt time.Time) error
 	RemoveShareToken(ctx context.Context, roomID string, updatedAt time.Time) error
-	UpdateDescription(ctx context.Context, roomID string, userID string, description string, updatedAt time.Time) error
-	UpdateDisplayName(ctx context.Context, roomID string, userID string, displayName string, updatedAt time.Time) error
+	UpdateDescription(ctx context.Context, roomID string, userID string, description string, ifVersion int64, updatedAt time.Time) error
+	UpdateDisplayName(ctx context.Context, roomID string, userID string, displayName string, ifVersion int64, updatedAt time.Time) error
+	// Patch applies p to a room userID is a member of as a single write and
+	// returns the updated room. It fails like the Update methods.
+	Patch(ctx context.Context, roomID string, userID string, p RoomPatch, ifVersion int64, updatedAt time.Time) (*models.Room, error)
 	VoteDeletion(ctx context.Context, roomID string, userID string, ts time.Time) error
 	RemoveDeletionVote(ctx context.Context, roomID string, userID string) error
 	Delete(ctx context.Context, roomID string) error
 	AddMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error
+	// RemoveMember takes userID out of MemberIDs and drops their role.
 	RemoveMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error
+	// SetRoles records the roles of the members in roles as one write. It
+	// fails with derr.ErrNotFound unless each of them is a member of the room.
+	SetRoles(ctx context.Context, roomID string, roles map[string]models.Role, updatedAt time.Time) error
+}
+
+// InviteRepository stores room invites.
+type InviteRepository interface {
+	// Put creates the invite. It fails with derr.ErrConflict if its ID, code
+	// or link token is taken.
+	Put(ctx context.Context, inv *models.Invite) error
+	GetByID(ctx context.Context, inviteID string) (*models.Invite, error)
+	// GetByToken returns the invite whose code or link token is token.
+	GetByToken(ctx context.Context, token string) (*models.Invite, error)
+	// ListByRoom returns the room's invites, revoked and expired ones
+	// included, oldest first.
+	ListByRoom(ctx context.Context, roomID string) ([]models.Invite, error)
+	// Use counts one more join. It fails with derr.ErrConflict if the invite
+	// is revoked, used up or gone; expiry is up to the caller.
+	Use(ctx context.Context, inviteID string) error
+	// Revoke sets RevokedAt unless it is set already; derr.ErrNotFound if
+	// there is no such invite.
+	Revoke(ctx context.Context, inviteID string, at time.Time) error
+	// DeleteByRoom removes the room's invites and returns how many were
+	// removed.
+	DeleteByRoom(ctx context.Context, roomID string) (int, error)
 }
 
 type ListRepository interface {
 	Put(ctx context.Context, l *models.List) error
 	GetByID(ctx context.Context, id string) (*models.List, error)
-	ListByRoom(ctx context.Context, roomID string) ([]models.List, error)
-	UpdateName(ctx context.Context, listID string, name string, updatedAt time.Time) error
-	UpdateDescription(ctx context.Context, listID string, description string, updatedAt time.Time) error
-	UpdateNotes(ctx context.Context, listID string, notes string, updatedAt time.Time) error
-	UpdateIcon(ctx context.Context, listID string, icon string, updatedAt time.Time) error
+	// ListByRoom returns a page of the room's lists, oldest first (see Page).
+	ListByRoom(ctx context.Context, roomID string, page Page) ([]models.List, string, error)
+	UpdateName(ctx context.Context, listID string, name string, ifVersion int64, updatedAt time.Time) error
+	UpdateDescription(ctx context.Context, listID string, description string, ifVersion int64, updatedAt time.Time) error
+	UpdateNotes(ctx context.Context, listID string, notes string, ifVersion int64, updatedAt time.Time) error
+	UpdateIcon(ctx context.Context, listID string, icon string, ifVersion int64, updatedAt time.Time) error
+	// Patch applies p to a list that is not soft-deleted as a single write and
+	// returns the updated list. It fails like the Update methods.
+	Patch(ctx context.Context, listID string, p ListPatch, ifVersion int64, updatedAt time.Time) (*models.List, error)
 	AddDeletionVote(ctx context.Context, listID string, userID string, ts time.Time) error
 	RemoveDeletionVote(ctx context.Context, listID string, userID string) error
 	FinalizeDeleteIfVotedByAll(ctx context.Context, listID string, memberIDs []string, ts time.Time) (bool, error)
+	// Restore takes a soft-deleted list out of the trash and clears its
+	// deletion votes. It returns derr.ErrNotFound unless the list is in the trash.
+	Restore(ctx context.Context, listID string, updatedAt time.Time) error
+	// MoveToRoom moves a list, trashed or not, to roomID and clears its
+	// deletion votes. It returns derr.ErrNotFound if there is no such list.
+	MoveToRoom(ctx context.Context, listID string, roomID string, updatedAt time.Time) error
+	// ListTrashedByRoom returns the room's soft-deleted lists, most recently
+	// deleted first.
+	ListTrashedByRoom(ctx context.Context, roomID string) ([]models.List, error)
+	// ListDeletedBefore returns soft-deleted lists of any room deleted before
+	// cutoff, least recently deleted first.
+	ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.List, error)
 	Delete(ctx context.Context, listID string) error
+	// DeleteByRoom removes every list of a room, soft-deleted ones included,
+	// and returns how many were removed.
+	DeleteByRoom(ctx context.Context, roomID string) (int, error)
 }
 
+// ListItemRepository stores list items. Trashed items (IsDeleted) stay
+// readable through GetByID but are left out of ListByList, ListArchivedByRoom
+// and ArchiveCompletedByList, and the Update* methods return derr.ErrNotFound
+// for them. Their UpdatedAt records when they were trashed.
 type ListItemRepository interface {
 	Put(ctx context.Context, it *models.ListItem) error
 	GetByID(ctx context.Context, id string) (*models.ListItem, error)
-	ListByList(ctx context.Context, listID string) ([]models.ListItem, error)
-	UpdateCompletion(ctx context.Context, itemID string, completed bool, updatedAt time.Time) error
-	UpdateDescription(ctx context.Context, itemID string, description string, updatedAt time.Time) error
-	UpdateQuantity(ctx context.Context, itemID string, quantity string, updatedAt time.Time) error
-	UpdateUnit(ctx context.Context, itemID string, unit string, updatedAt time.Time) error
-	UpdateCategory(ctx context.Context, itemID string, category string, updatedAt time.Time) error
-	UpdateStarred(ctx context.Context, itemID string, starred bool, updatedAt time.Time) error
+	// ListByList returns a page of the list's items by Order, oldest first
+	// among equal orders (see Page).
+	ListByList(ctx context.Context, listID string, page Page) ([]models.ListItem, string, error)
+	UpdateCompletion(ctx context.Context, itemID string, completed bool, ifVersion int64, updatedAt time.Time) error
+	UpdateDescription(ctx context.Context, itemID string, description string, ifVersion int64, updatedAt time.Time) error
+	UpdateQuantity(ctx context.Context, itemID string, quantity string, ifVersion int64, updatedAt time.Time) error
+	UpdateUnit(ctx context.Context, itemID string, unit string, ifVersion int64, updatedAt time.Time) error
+	UpdateCategory(ctx context.Context, itemID string, category string, ifVersion int64, updatedAt time.Time) error
+	UpdateStarred(ctx context.Context, itemID string, starred bool, ifVersion int64, updatedAt time.Time) error
+	// Patch applies p to an item that is not in the trash as a single write and
+	// returns the updated item. It fails like the Update methods.
+	Patch(ctx context.Context, itemID string, p ItemPatch, ifVersion int64, updatedAt time.Time) (*models.ListItem, error)
 	ArchiveCompletedByList(ctx context.Context, listID string, updatedAt time.Time) error
-	ListArchivedByRoom(ctx context.Context, roomID string) ([]models.ListItem, error)
-	UpdateOrder(ctx context.Context, itemID string, order float64, updatedAt time.Time) error
+	// ListArchivedByRoom returns a page of the room's archived items, most
+	// recently updated first (see Page).
+	ListArchivedByRoom(ctx context.Context, roomID string, page Page) ([]models.ListItem, string, error)
+	UpdateOrder(ctx context.Context, itemID string, order float64, ifVersion int64, updatedAt time.Time) error
+	// SoftDelete moves an item to the trash. It returns derr.ErrNotFound if the
+	// item is missing or already trashed.
+	SoftDelete(ctx context.Context, itemID string, ts time.Time) error
+	// Restore takes an item out of the trash. It returns derr.ErrNotFound
+	// unless the item is trashed.
+	Restore(ctx context.Context, itemID string, updatedAt time.Time) error
+	// ListTrashedByRoom returns the room's trashed items, most recently
+	// trashed first.
+	ListTrashedByRoom(ctx context.Context, roomID string) ([]models.ListItem, error)
+	// PurgeDeletedBefore permanently removes items trashed before cutoff and
+	// returns how many were removed.
+	PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int, error)
 	Delete(ctx context.Context, itemID string) error
+	// DeleteByList removes every item of a list, trashed ones included, and
+	// returns how many were removed.
+	DeleteByList(ctx context.Context, listID string) (int, error)
+	// MoveByList moves every item of fromListID, trashed ones included, to
+	// toListID in roomID, adding orderOffset to each Order, and returns how
+	// many were moved.
+	MoveByList(ctx context.Context, fromListID, toListID, roomID string, orderOffset float64, updatedAt time.Time) (int, error)
+	// DeleteByRoom removes every item of a room and returns how many were removed.
+	DeleteByRoom(ctx context.Context, roomID string) (int, error)
+}
+
+// ListItemScanner visits every list item in the store, in no particular order.
+// fn may write through the repositories; implementations must not hold a
+// connection or lock while calling it.
+type ListItemScanner interface {
+	ScanAll(ctx context.Context, fn func(it models.ListItem) error) error
+}
+
+// UserScanner, RoomScanner and ListScanner are the ListItemScanner of users,
+// rooms and lists, trashed lists included. They back store-wide exports.
+type UserScanner interface {
+	ScanAll(ctx context.Context, fn func(u models.User) error) error
+}
+
+type RoomScanner interface {
+	ScanAll(ctx context.Context, fn func(rm models.Room) error) error
+}
+
+type ListScanner interface {
+	ScanAll(ctx context.Context, fn func(l models.List) error) error
+}
+
+// UserTokenScanner visits every user token, expired ones included.
+type UserTokenScanner interface {
+	ScanAll(ctx context.Context, fn func(t models.UserToken) error) error
+}
+
+// InviteScanner visits every invite, revoked and expired ones included.
+type InviteScanner interface {
+	ScanAll(ctx context.Context, fn func(inv models.Invite) error) error
+}
+
+// CategoryIndexScanner visits every entry of the category index cache, in no
+// particular order.
+type CategoryIndexScanner interface {
+	ScanAll(ctx context.Context, fn func(key, category string) error) error
+}
+
+// MigrationRepository records the data migrations applied to a store.
+type MigrationRepository interface {
+	ListApplied(ctx context.Context) ([]models.MigrationRecord, error)
+	// Record fails with derr.ErrConflict if the version is already recorded.
+	Record(ctx context.Context, rec models.MigrationRecord) error
+}
+
+// JobRepository persists background jobs (see internal/jobs).
+//
+// Claiming leases a job: its status becomes running, RunAt the lease expiry
+// and Attempts is incremented, so a job whose worker died is claimed again
+// once the lease runs out. Attempts doubles as the lease token: Complete,
+// Retry and Bury fail with derr.ErrConflict unless the job is still running
+// under the given attempt.
+type JobRepository interface {
+	Enqueue(ctx context.Context, j *models.Job) error
+	GetByID(ctx context.Context, id string) (*models.Job, error)
+	// ClaimNext leases the due job with the earliest RunAt: pending, or running
+	// with an expired lease. It returns derr.ErrNotFound when nothing is due.
+	ClaimNext(ctx context.Context, now, leaseUntil time.Time) (*models.Job, error)
+	// Complete deletes a finished job.
+	Complete(ctx context.Context, jobID string, attempt int) error
+	// Retry puts a job back to pending, due at runAt.
+	Retry(ctx context.Context, jobID string, attempt int, runAt time.Time, lastErr string, updatedAt time.Time) error
+	// Bury moves a job to the dead-letter state; it is not claimed again.
+	Bury(ctx context.Context, jobID string, attempt int, lastErr string, updatedAt time.Time) error
+	// ListDead returns dead jobs, least recently updated first.
+	ListDead(ctx context.Context) ([]models.Job, error)
+	// Requeue makes a dead job pending again with its attempts reset. It
+	// returns derr.ErrNotFound unless the job exists and is dead.
+	Requeue(ctx context.Context, jobID string, runAt time.Time, updatedAt time.Time) error
 }
@@ -0,0 +1,67 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// CategoryIndexEntry is used for bulk seeding.
+type CategoryIndexEntry struct {
+    Key      string
+    Category string
+}
+
+// CategoryIndexRepo manages the category_index table. It satisfies
+// categorization.CategoryIndex.
+type CategoryIndexRepo struct{ c *Client }
+
+func NewCategoryIndexRepo(c *Client) *CategoryIndexRepo { return &CategoryIndexRepo{c: c} }
+
+const upsertCategory = "INSERT INTO category_index (key, category, updated_at) VALUES (?, ?, ?) ON CONFLICT (key) DO UPDATE SET category = excluded.category, updated_at = excluded.updated_at"
+
+// Lookup returns (category, true, nil) on hit; ("", false, nil) on not found; ("", false, err) on error.
+func (r *CategoryIndexRepo) Lookup(ctx context.Context, key string) (string, bool, error) {
+    var category string
+    err := r.c.queryRow(ctx, "SELECT category FROM category_index WHERE key = ?", key).Scan(&category)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) { return "", false, nil }
+        return "", false, err
+    }
+    return category, true, nil
+}
+
+// ScanAll reads entries in key pages, calling fn between queries.
+func (r *CategoryIndexRepo) ScanAll(ctx context.Context, fn func(key, category string) error) error {
+    return scanPages(func(after string) ([]CategoryIndexEntry, error) {
+        rows, err := r.c.query(ctx, "SELECT key, category FROM category_index WHERE key > ? ORDER BY key LIMIT ?", after, scanPageSize)
+        if err != nil { return nil, err }
+        defer rows.Close()
+        var out []CategoryIndexEntry
+        for rows.Next() {
+            var e CategoryIndexEntry
+            if err := rows.Scan(&e.Key, &e.Category); err != nil { return nil, err }
+            out = append(out, e)
+        }
+        return out, rows.Err()
+    }, func(e CategoryIndexEntry) string { return e.Key }, func(e CategoryIndexEntry) error { return fn(e.Key, e.Category) })
+}
+
+// Upsert updates or inserts a category for a key, setting updated_at.
+func (r *CategoryIndexRepo) Upsert(ctx context.Context, key, category string) error {
+    _, err := r.c.exec(ctx, upsertCategory, key, category, time.Now().UTC())
+    return err
+}
+
+// Seed bulk upserts entries in one transaction. No-op for empty slice.
+func (r *CategoryIndexRepo) Seed(ctx context.Context, entries []CategoryIndexEntry) error {
+    if len(entries) == 0 { return nil }
+    now := time.Now().UTC()
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        for _, e := range entries {
+            if _, err := r.c.exec(ctx, upsertCategory, e.Key, e.Category, now); err != nil { return err }
+        }
+        return nil
+    })
+}
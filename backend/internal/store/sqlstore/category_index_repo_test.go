@@ -0,0 +1,30 @@
+package sqlstore
+
+import (
+    "context"
+    "testing"
+)
+
+func TestCategoryIndexRepo(t *testing.T) {
+    r := NewCategoryIndexRepo(openSQLite(t))
+    ctx := context.Background()
+
+    if cat, found, err := r.Lookup(ctx, "chicken breast"); err != nil || found || cat != "" {
+        t.Fatalf("miss: got %q %v %v", cat, found, err)
+    }
+    if err := r.Upsert(ctx, "chicken breast", "Meat & Seafood"); err != nil { t.Fatalf("upsert: %v", err) }
+    if err := r.Upsert(ctx, "chicken breast", "Deli"); err != nil { t.Fatalf("re-upsert: %v", err) }
+    if cat, found, err := r.Lookup(ctx, "chicken breast"); err != nil || !found || cat != "Deli" {
+        t.Fatalf("last writer wins: got %q %v %v", cat, found, err)
+    }
+
+    if err := r.Seed(ctx, nil); err != nil { t.Fatalf("seed empty: %v", err) }
+    if err := r.Seed(ctx, []CategoryIndexEntry{{Key: "milk", Category: "Dairy"}, {Key: "chicken breast", Category: "Meat & Seafood"}}); err != nil {
+        t.Fatalf("seed: %v", err)
+    }
+    for key, want := range map[string]string{"milk": "Dairy", "chicken breast": "Meat & Seafood"} {
+        if cat, found, err := r.Lookup(ctx, key); err != nil || !found || cat != want {
+            t.Fatalf("seeded %s: got %q %v %v", key, cat, found, err)
+        }
+    }
+}
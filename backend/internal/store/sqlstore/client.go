@@ -0,0 +1,166 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+    _ "github.com/jackc/pgx/v5/stdlib"
+    _ "modernc.org/sqlite"
+)
+
+// Supported dialects. Queries are written with "?" placeholders and rebound
+// for Postgres.
+const (
+    DialectSQLite   = "sqlite"
+    DialectPostgres = "postgres"
+)
+
+type Client struct {
+    DB      *sql.DB
+    dialect string
+}
+
+// Open connects to a SQLite file (dsn is a path, created if missing) or a
+// Postgres server (dsn is a connection URL). Call Migrate before use.
+func Open(ctx context.Context, dialect, dsn string) (*Client, error) {
+    var driver string
+    switch dialect {
+    case DialectSQLite:
+        driver, dsn = "sqlite", sqliteDSN(dsn)
+    case DialectPostgres:
+        driver = "pgx"
+    default:
+        return nil, fmt.Errorf("sqlstore: unknown dialect %q", dialect)
+    }
+    db, err := sql.Open(driver, dsn)
+    if err != nil { return nil, err }
+    if dialect == DialectSQLite {
+        // SQLite allows a single writer; one connection also serialises
+        // transactions instead of failing them with SQLITE_BUSY.
+        db.SetMaxOpenConns(1)
+    }
+    pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+    if err := db.PingContext(pingCtx); err != nil {
+        _ = db.Close()
+        return nil, err
+    }
+    return &Client{DB: db, dialect: dialect}, nil
+}
+
+// sqliteDSN adds the pragmas the store relies on to a file path.
+func sqliteDSN(path string) string {
+    sep := "?"
+    if strings.Contains(path, "?") { sep = "&" }
+    return path + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+}
+
+func (c *Client) Close() error { return c.DB.Close() }
+
+func (c *Client) Dialect() string { return c.dialect }
+
+// querier is the part of *sql.DB and *sql.Tx used by the repositories.
+type querier interface {
+    ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+    QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn returns the transaction carried by ctx, or the pool.
+func (c *Client) conn(ctx context.Context) querier {
+    if tx := txFrom(ctx); tx != nil { return tx }
+    return c.DB
+}
+
+func (c *Client) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+    res, err := c.conn(ctx).ExecContext(ctx, c.rebind(query), args...)
+    return res, mapErr(err)
+}
+
+// execOne runs a statement that must affect at least one row; none is derr.ErrNotFound.
+func (c *Client) execOne(ctx context.Context, query string, args ...any) error {
+    res, err := c.exec(ctx, query, args...)
+    return notFoundIfNoRows(res, err)
+}
+
+// update runs "UPDATE table SET set, version = version + 1 WHERE where" on
+// exactly one row, adding a version check unless ifVersion is
+// store.AnyVersion. When a checked update matches nothing, where alone tells
+// a stale version (derr.ErrPreconditionFailed) from a missing row.
+func (c *Client) update(ctx context.Context, table, set string, setArgs []any, where string, whereArgs []any, ifVersion int64) error {
+    query := "UPDATE " + table + " SET " + set + ", version = version + 1 WHERE " + where
+    args := append(append([]any{}, setArgs...), whereArgs...)
+    if ifVersion == store.AnyVersion { return c.execOne(ctx, query, args...) }
+    err := c.execOne(ctx, query+" AND version = ?", append(args, ifVersion)...)
+    if !errors.Is(err, derr.ErrNotFound) { return err }
+    var one int
+    if err := c.queryRow(ctx, "SELECT 1 FROM "+table+" WHERE "+where, whereArgs...).Scan(&one); err != nil { return notFoundIfNoRow(err) }
+    return derr.ErrPreconditionFailed
+}
+
+// assignments collects the "column = ?" pairs of a patch's SET clause.
+type assignments struct {
+    cols []string
+    args []any
+}
+
+func (a *assignments) set(column string, value any) {
+    a.cols = append(a.cols, column+" = ?")
+    a.args = append(a.args, value)
+}
+
+func (a *assignments) String() string { return strings.Join(a.cols, ", ") }
+
+func (c *Client) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+    return c.conn(ctx).QueryContext(ctx, c.rebind(query), args...)
+}
+
+func (c *Client) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+    return c.conn(ctx).QueryRowContext(ctx, c.rebind(query), args...)
+}
+
+// rebind rewrites "?" placeholders to "$n" for Postgres.
+func (c *Client) rebind(query string) string {
+    if c.dialect != DialectPostgres || !strings.Contains(query, "?") { return query }
+    var b strings.Builder
+    n := 0
+    for _, r := range query {
+        if r == '?' {
+            n++
+            b.WriteByte('$')
+            b.WriteString(strconv.Itoa(n))
+            continue
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
+
+// placeholders returns "?, ?, ..." for n arguments.
+func placeholders(n int) string {
+    return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// limit appends a LIMIT one row past a limited page, so store.Trim can tell
+// whether another page follows.
+func limit(query string, args []any, page store.Page) (string, []any) {
+    if page.Limit <= 0 { return query, args }
+    return query + " LIMIT ?", append(args, page.Limit+1)
+}
+
+// nullString stores "" as NULL for optional columns that carry a unique index.
+func nullString(s string) sql.NullString {
+    return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+    if t == nil { return sql.NullTime{} }
+    return sql.NullTime{Time: t.UTC(), Valid: true}
+}
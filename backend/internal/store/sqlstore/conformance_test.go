@@ -0,0 +1,61 @@
+package sqlstore
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/store/storetest"
+)
+
+// openSQLite returns a migrated store in a fresh database file.
+func openSQLite(t *testing.T) *Client {
+    t.Helper()
+    c, err := Open(context.Background(), DialectSQLite, filepath.Join(t.TempDir(), "gracie.db"))
+    if err != nil { t.Fatalf("open sqlite: %v", err) }
+    t.Cleanup(func() { _ = c.Close() })
+    if err := c.Migrate(context.Background()); err != nil { t.Fatalf("migrate: %v", err) }
+    return c
+}
+
+// openPostgresOrSkip connects to POSTGRES_TEST_URL and isolates the test in
+// its own schema, dropped on cleanup.
+func openPostgresOrSkip(t *testing.T) *Client {
+    t.Helper()
+    url := os.Getenv("POSTGRES_TEST_URL")
+    if url == "" { t.Skip("POSTGRES_TEST_URL not set") }
+    ctx := context.Background()
+    admin, err := Open(ctx, DialectPostgres, url)
+    if err != nil { t.Skipf("postgres unavailable: %v", err) }
+    b := make([]byte, 6)
+    _, _ = rand.Read(b)
+    schema := "gracie_test_" + hex.EncodeToString(b)
+    if _, err := admin.DB.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil { t.Fatalf("create schema: %v", err) }
+    sep := "?"
+    if strings.Contains(url, "?") { sep = "&" }
+    c, err := Open(ctx, DialectPostgres, url+sep+"search_path="+schema)
+    if err != nil { t.Fatalf("open postgres: %v", err) }
+    t.Cleanup(func() {
+        _ = c.Close()
+        _, _ = admin.DB.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+        _ = admin.Close()
+    })
+    if err := c.Migrate(ctx); err != nil { t.Fatalf("migrate: %v", err) }
+    return c
+}
+
+func repos(c *Client) storetest.Repos {
+    return storetest.Repos{Tx: NewTx(c), Users: NewUserRepo(c), Rooms: NewRoomRepo(c), Lists: NewListRepo(c), Items: NewListItemRepo(c), Migrations: NewMigrationRepo(c), Jobs: NewJobRepo(c), Sessions: NewSessionRepo(c), UserTokens: NewUserTokenRepo(c), Counters: NewCounterRepo(c), Invites: NewInviteRepo(c)}
+}
+
+func TestConformanceSQLite(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) storetest.Repos { return repos(openSQLite(t)) })
+}
+
+func TestConformancePostgres(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) storetest.Repos { return repos(openPostgresOrSkip(t)) })
+}
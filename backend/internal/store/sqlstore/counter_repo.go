@@ -0,0 +1,41 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// CounterRepo keeps expiring counters in the counters table.
+type CounterRepo struct{ c *Client }
+
+func NewCounterRepo(c *Client) *CounterRepo { return &CounterRepo{c: c} }
+
+// Incr upserts the counter in one statement, so concurrent increments from
+// several servers all count.
+func (r *CounterRepo) Incr(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+    var n int64
+    err := r.c.queryRow(ctx, "INSERT INTO counters (counter_key, count, expires_at) VALUES (?, 1, ?) ON CONFLICT (counter_key) DO UPDATE SET count = counters.count + 1 RETURNING count",
+        key, expiresAt.UTC()).Scan(&n)
+    return n, err
+}
+
+func (r *CounterRepo) Get(ctx context.Context, key string, now time.Time) (int64, error) {
+    var n int64
+    err := r.c.queryRow(ctx, "SELECT count FROM counters WHERE counter_key = ? AND expires_at > ?", key, now.UTC()).Scan(&n)
+    if errors.Is(err, sql.ErrNoRows) { return 0, nil }
+    return n, err
+}
+
+func (r *CounterRepo) Delete(ctx context.Context, key string) error {
+    _, err := r.c.exec(ctx, "DELETE FROM counters WHERE counter_key = ?", key)
+    return err
+}
+
+func (r *CounterRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM counters WHERE expires_at < ?", cutoff.UTC())
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
@@ -0,0 +1,43 @@
+package sqlstore
+
+import (
+    "database/sql"
+    "errors"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "modernc.org/sqlite"
+    sqlite3 "modernc.org/sqlite/lib"
+)
+
+// mapErr turns unique and primary key violations into derr.ErrConflict.
+func mapErr(err error) error {
+    if err == nil { return nil }
+    var se *sqlite.Error
+    if errors.As(err, &se) {
+        switch se.Code() {
+        case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+            return derr.ErrConflict
+        }
+    }
+    var pe *pgconn.PgError
+    if errors.As(err, &pe) && pe.Code == "23505" {
+        return derr.ErrConflict
+    }
+    return err
+}
+
+// notFoundIfNoRows maps a statement that affected no row to derr.ErrNotFound.
+func notFoundIfNoRows(res sql.Result, err error) error {
+    if err != nil { return err }
+    n, err := res.RowsAffected()
+    if err != nil { return err }
+    if n == 0 { return derr.ErrNotFound }
+    return nil
+}
+
+// notFoundIfNoRow maps sql.ErrNoRows from a single-row read to derr.ErrNotFound.
+func notFoundIfNoRow(err error) error {
+    if errors.Is(err, sql.ErrNoRows) { return derr.ErrNotFound }
+    return err
+}
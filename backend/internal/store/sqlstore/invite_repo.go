@@ -0,0 +1,98 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// InviteRepo stores room invites in the invites table.
+type InviteRepo struct{ c *Client }
+
+func NewInviteRepo(c *Client) *InviteRepo { return &InviteRepo{c: c} }
+
+const inviteColumns = "invite_id, room_id, name, code, link_token, created_by, max_uses, uses, created_at, expires_at, revoked_at"
+
+func scanInvite(row rowScanner) (*models.Invite, error) {
+    var inv models.Invite
+    var link sql.NullString
+    var revokedAt sql.NullTime
+    if err := row.Scan(&inv.InviteID, &inv.RoomID, &inv.Name, &inv.Code, &link, &inv.CreatedBy, &inv.MaxUses, &inv.Uses, &inv.CreatedAt, &inv.ExpiresAt, &revokedAt); err != nil {
+        return nil, err
+    }
+    inv.LinkToken = link.String
+    if revokedAt.Valid {
+        t := revokedAt.Time.UTC()
+        inv.RevokedAt = &t
+    }
+    inv.CreatedAt, inv.ExpiresAt = inv.CreatedAt.UTC(), inv.ExpiresAt.UTC()
+    return &inv, nil
+}
+
+func (r *InviteRepo) Put(ctx context.Context, inv *models.Invite) error {
+    _, err := r.c.exec(ctx, "INSERT INTO invites ("+inviteColumns+") VALUES ("+placeholders(11)+")",
+        inv.InviteID, inv.RoomID, inv.Name, inv.Code, nullString(inv.LinkToken), inv.CreatedBy, inv.MaxUses, inv.Uses, inv.CreatedAt.UTC(), inv.ExpiresAt.UTC(), nullTime(inv.RevokedAt))
+    return err
+}
+
+func (r *InviteRepo) GetByID(ctx context.Context, inviteID string) (*models.Invite, error) {
+    inv, err := scanInvite(r.c.queryRow(ctx, "SELECT "+inviteColumns+" FROM invites WHERE invite_id = ?", inviteID))
+    return inv, notFoundIfNoRow(err)
+}
+
+func (r *InviteRepo) GetByToken(ctx context.Context, token string) (*models.Invite, error) {
+    inv, err := scanInvite(r.c.queryRow(ctx, "SELECT "+inviteColumns+" FROM invites WHERE code = ? OR link_token = ?", token, token))
+    return inv, notFoundIfNoRow(err)
+}
+
+func (r *InviteRepo) ListByRoom(ctx context.Context, roomID string) ([]models.Invite, error) {
+    rows, err := r.c.query(ctx, "SELECT "+inviteColumns+" FROM invites WHERE room_id = ? ORDER BY created_at, invite_id", roomID)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []models.Invite
+    for rows.Next() {
+        inv, err := scanInvite(rows)
+        if err != nil { return nil, err }
+        out = append(out, *inv)
+    }
+    return out, rows.Err()
+}
+
+// ScanAll reads invites in invite_id pages, calling fn between queries.
+func (r *InviteRepo) ScanAll(ctx context.Context, fn func(inv models.Invite) error) error {
+    return scanPages(func(after string) ([]models.Invite, error) {
+        rows, err := r.c.query(ctx, "SELECT "+inviteColumns+" FROM invites WHERE invite_id > ? ORDER BY invite_id LIMIT ?", after, scanPageSize)
+        if err != nil { return nil, err }
+        defer rows.Close()
+        var out []models.Invite
+        for rows.Next() {
+            inv, err := scanInvite(rows)
+            if err != nil { return nil, err }
+            out = append(out, *inv)
+        }
+        return out, rows.Err()
+    }, func(inv models.Invite) string { return inv.InviteID }, fn)
+}
+
+func (r *InviteRepo) Use(ctx context.Context, inviteID string) error {
+    err := r.c.execOne(ctx, "UPDATE invites SET uses = uses + 1 WHERE invite_id = ? AND revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses)", inviteID)
+    if errors.Is(err, derr.ErrNotFound) { return derr.ErrConflict }
+    return err
+}
+
+func (r *InviteRepo) Revoke(ctx context.Context, inviteID string, at time.Time) error {
+    if _, err := r.c.exec(ctx, "UPDATE invites SET revoked_at = ? WHERE invite_id = ? AND revoked_at IS NULL", at.UTC(), inviteID); err != nil { return err }
+    _, err := r.GetByID(ctx, inviteID)
+    return err
+}
+
+func (r *InviteRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM invites WHERE room_id = ?", roomID)
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
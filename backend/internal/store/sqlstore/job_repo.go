@@ -0,0 +1,107 @@
+package sqlstore
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// JobRepo stores background jobs in the jobs table.
+type JobRepo struct{ c *Client }
+
+func NewJobRepo(c *Client) *JobRepo { return &JobRepo{c: c} }
+
+const jobColumns = "job_id, kind, payload, status, attempts, max_attempts, run_at, last_error, created_at, updated_at"
+
+func scanJob(row rowScanner) (*models.Job, error) {
+    var (
+        j       models.Job
+        payload string
+    )
+    if err := row.Scan(&j.JobID, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt, &j.LastError, &j.CreatedAt, &j.UpdatedAt); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(payload), &j.Payload); err != nil { return nil, err }
+    if len(j.Payload) == 0 { j.Payload = nil }
+    j.RunAt, j.CreatedAt, j.UpdatedAt = j.RunAt.UTC(), j.CreatedAt.UTC(), j.UpdatedAt.UTC()
+    return &j, nil
+}
+
+func (r *JobRepo) Enqueue(ctx context.Context, j *models.Job) error {
+    payload, err := json.Marshal(j.Payload)
+    if err != nil { return err }
+    if j.Payload == nil { payload = []byte("{}") }
+    _, err = r.c.exec(ctx, "INSERT INTO jobs ("+jobColumns+") VALUES ("+placeholders(10)+")",
+        j.JobID, j.Kind, string(payload), j.Status, j.Attempts, j.MaxAttempts, j.RunAt.UTC(), j.LastError, j.CreatedAt.UTC(), j.UpdatedAt.UTC())
+    return err
+}
+
+func (r *JobRepo) GetByID(ctx context.Context, id string) (*models.Job, error) {
+    j, err := scanJob(r.c.queryRow(ctx, "SELECT "+jobColumns+" FROM jobs WHERE job_id = ?", id))
+    if err != nil { return nil, notFoundIfNoRow(err) }
+    return j, nil
+}
+
+// ClaimNext picks the earliest due job and takes it with an update guarded by
+// its attempt count; if another worker got there first it looks again.
+func (r *JobRepo) ClaimNext(ctx context.Context, now, leaseUntil time.Time) (*models.Job, error) {
+    for {
+        var (
+            id       string
+            attempts int
+        )
+        err := r.c.queryRow(ctx, "SELECT job_id, attempts FROM jobs WHERE status IN (?, ?) AND run_at <= ? ORDER BY run_at, job_id LIMIT 1",
+            models.JobPending, models.JobRunning, now.UTC()).Scan(&id, &attempts)
+        if err != nil { return nil, notFoundIfNoRow(err) }
+        res, err := r.c.exec(ctx, "UPDATE jobs SET status = ?, run_at = ?, attempts = attempts + 1, updated_at = ? WHERE job_id = ? AND attempts = ? AND status IN (?, ?)",
+            models.JobRunning, leaseUntil.UTC(), now.UTC(), id, attempts, models.JobPending, models.JobRunning)
+        if err != nil { return nil, err }
+        n, err := res.RowsAffected()
+        if err != nil { return nil, err }
+        if n == 1 { return r.GetByID(ctx, id) }
+    }
+}
+
+// leaseOp runs an update guarded by the running status and attempt count.
+func (r *JobRepo) leaseOp(ctx context.Context, jobID string, attempt int, query string, args ...any) error {
+    res, err := r.c.exec(ctx, query+" WHERE job_id = ? AND status = ? AND attempts = ?", append(args, jobID, models.JobRunning, attempt)...)
+    if err := notFoundIfNoRows(res, err); !errors.Is(err, derr.ErrNotFound) { return err }
+    if _, err := r.GetByID(ctx, jobID); err != nil { return err }
+    return derr.ErrConflict
+}
+
+func (r *JobRepo) Complete(ctx context.Context, jobID string, attempt int) error {
+    return r.leaseOp(ctx, jobID, attempt, "DELETE FROM jobs")
+}
+
+func (r *JobRepo) Retry(ctx context.Context, jobID string, attempt int, runAt time.Time, lastErr string, updatedAt time.Time) error {
+    return r.leaseOp(ctx, jobID, attempt, "UPDATE jobs SET status = ?, run_at = ?, last_error = ?, updated_at = ?",
+        models.JobPending, runAt.UTC(), lastErr, updatedAt.UTC())
+}
+
+func (r *JobRepo) Bury(ctx context.Context, jobID string, attempt int, lastErr string, updatedAt time.Time) error {
+    return r.leaseOp(ctx, jobID, attempt, "UPDATE jobs SET status = ?, last_error = ?, updated_at = ?",
+        models.JobDead, lastErr, updatedAt.UTC())
+}
+
+func (r *JobRepo) ListDead(ctx context.Context) ([]models.Job, error) {
+    rows, err := r.c.query(ctx, "SELECT "+jobColumns+" FROM jobs WHERE status = ? ORDER BY updated_at, job_id", models.JobDead)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    out := []models.Job{}
+    for rows.Next() {
+        j, err := scanJob(rows)
+        if err != nil { return nil, err }
+        out = append(out, *j)
+    }
+    return out, rows.Err()
+}
+
+func (r *JobRepo) Requeue(ctx context.Context, jobID string, runAt time.Time, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE jobs SET status = ?, attempts = 0, run_at = ?, updated_at = ? WHERE job_id = ? AND status = ?",
+        models.JobPending, runAt.UTC(), updatedAt.UTC(), jobID, models.JobDead)
+}
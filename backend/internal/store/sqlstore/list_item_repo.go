@@ -0,0 +1,212 @@
+package sqlstore
+
+import (
+    "context"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+type ListItemRepo struct{ c *Client }
+
+func NewListItemRepo(c *Client) *ListItemRepo { return &ListItemRepo{c: c} }
+
+// The Order field is stored as sort_order; ORDER is a reserved word.
+const itemColumns = "item_id, list_id, room_id, sort_order, description, quantity, unit, category, is_starred, is_archived, is_deleted, completed, version, created_at, updated_at"
+
+func scanItem(row rowScanner) (*models.ListItem, error) {
+    var it models.ListItem
+    if err := row.Scan(&it.ItemID, &it.ListID, &it.RoomID, &it.Order, &it.Description, &it.Quantity, &it.Unit, &it.Category,
+        &it.IsStarred, &it.IsArchived, &it.IsDeleted, &it.Completed, &it.Version, &it.CreatedAt, &it.UpdatedAt); err != nil {
+        return nil, err
+    }
+    it.CreatedAt, it.UpdatedAt = it.CreatedAt.UTC(), it.UpdatedAt.UTC()
+    return &it, nil
+}
+
+func (r *ListItemRepo) list(ctx context.Context, query string, args ...any) ([]models.ListItem, error) {
+    rows, err := r.c.query(ctx, query, args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []models.ListItem
+    for rows.Next() {
+        it, err := scanItem(rows)
+        if err != nil { return nil, err }
+        out = append(out, *it)
+    }
+    return out, rows.Err()
+}
+
+func (r *ListItemRepo) Put(ctx context.Context, it *models.ListItem) error {
+    _, err := r.c.exec(ctx, "INSERT INTO list_items ("+itemColumns+") VALUES ("+placeholders(15)+")",
+        it.ItemID, it.ListID, it.RoomID, it.Order, it.Description, it.Quantity, it.Unit, it.Category,
+        it.IsStarred, it.IsArchived, it.IsDeleted, it.Completed, it.Version, it.CreatedAt.UTC(), it.UpdatedAt.UTC())
+    return err
+}
+
+func (r *ListItemRepo) GetByID(ctx context.Context, id string) (*models.ListItem, error) {
+    it, err := scanItem(r.c.queryRow(ctx, "SELECT "+itemColumns+" FROM list_items WHERE item_id = ?", id))
+    if err != nil { return nil, notFoundIfNoRow(err) }
+    return it, nil
+}
+
+func (r *ListItemRepo) ListByList(ctx context.Context, listID string, page store.Page) ([]models.ListItem, string, error) {
+    q, args := "SELECT "+itemColumns+" FROM list_items WHERE list_id = ? AND is_deleted = FALSE", []any{listID}
+    if page.Cursor != "" {
+        c, err := store.DecodeCursor(page.Cursor)
+        if err != nil { return nil, "", err }
+        q += " AND (sort_order > ? OR (sort_order = ? AND (created_at > ? OR (created_at = ? AND item_id > ?))))"
+        args = append(args, c.Order, c.Order, c.Time.UTC(), c.Time.UTC(), c.ID)
+    }
+    q, args = limit(q+" ORDER BY sort_order, created_at, item_id", args, page)
+    out, err := r.list(ctx, q, args...)
+    if err != nil { return nil, "", err }
+    return store.Trim(out, page.Limit, store.ItemKey)
+}
+
+// setField updates one column of an item that is not in the trash.
+func (r *ListItemRepo) setField(ctx context.Context, itemID, column string, value any, ifVersion int64, updatedAt time.Time) error {
+    return r.c.update(ctx, "list_items", column+" = ?, updated_at = ?", []any{value, updatedAt.UTC()}, "item_id = ? AND is_deleted = FALSE", []any{itemID}, ifVersion)
+}
+
+func (r *ListItemRepo) UpdateCompletion(ctx context.Context, itemID string, completed bool, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "completed", completed, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateDescription(ctx context.Context, itemID string, description string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "description", description, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateOrder(ctx context.Context, itemID string, order float64, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "sort_order", order, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateQuantity(ctx context.Context, itemID string, quantity string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "quantity", quantity, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateUnit(ctx context.Context, itemID string, unit string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "unit", unit, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateCategory(ctx context.Context, itemID string, category string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "category", category, ifVersion, updatedAt)
+}
+
+func (r *ListItemRepo) UpdateStarred(ctx context.Context, itemID string, starred bool, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, itemID, "is_starred", starred, ifVersion, updatedAt)
+}
+
+// Patch updates the patched columns in one statement and reads the item back
+// in the same transaction.
+func (r *ListItemRepo) Patch(ctx context.Context, itemID string, p store.ItemPatch, ifVersion int64, updatedAt time.Time) (*models.ListItem, error) {
+    var set assignments
+    if p.Description != nil { set.set("description", *p.Description) }
+    if p.Completed != nil { set.set("completed", *p.Completed) }
+    if p.Quantity != nil { set.set("quantity", *p.Quantity) }
+    if p.Unit != nil { set.set("unit", *p.Unit) }
+    if p.Category != nil { set.set("category", *p.Category) }
+    if p.Starred != nil { set.set("is_starred", *p.Starred) }
+    set.set("updated_at", updatedAt.UTC())
+    var out *models.ListItem
+    err := r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.update(ctx, "list_items", set.String(), set.args, "item_id = ? AND is_deleted = FALSE", []any{itemID}, ifVersion); err != nil { return err }
+        var err error
+        out, err = r.GetByID(ctx, itemID)
+        return err
+    })
+    return out, err
+}
+
+func (r *ListItemRepo) ArchiveCompletedByList(ctx context.Context, listID string, updatedAt time.Time) error {
+    _, err := r.c.exec(ctx, "UPDATE list_items SET is_archived = TRUE, updated_at = ?, version = version + 1 WHERE list_id = ? AND completed = TRUE AND is_archived = FALSE AND is_deleted = FALSE",
+        updatedAt.UTC(), listID)
+    return err
+}
+
+func (r *ListItemRepo) ListArchivedByRoom(ctx context.Context, roomID string, page store.Page) ([]models.ListItem, string, error) {
+    q, args := "SELECT "+itemColumns+" FROM list_items WHERE room_id = ? AND is_archived = TRUE AND is_deleted = FALSE", []any{roomID}
+    if page.Cursor != "" {
+        c, err := store.DecodeCursor(page.Cursor)
+        if err != nil { return nil, "", err }
+        q += " AND (updated_at < ? OR (updated_at = ? AND item_id > ?))"
+        args = append(args, c.Time.UTC(), c.Time.UTC(), c.ID)
+    }
+    q, args = limit(q+" ORDER BY updated_at DESC, item_id", args, page)
+    out, err := r.list(ctx, q, args...)
+    if err != nil { return nil, "", err }
+    return store.Trim(out, page.Limit, store.ArchivedKey)
+}
+
+// scanPageSize bounds how many items ScanAll reads per query.
+const scanPageSize = 500
+
+// ScanAll reads items in item_id pages and calls fn between queries, so fn may
+// write through the same (single) SQLite connection.
+func (r *ListItemRepo) ScanAll(ctx context.Context, fn func(it models.ListItem) error) error {
+    return scanPages(func(after string) ([]models.ListItem, error) {
+        return r.list(ctx, "SELECT "+itemColumns+" FROM list_items WHERE item_id > ? ORDER BY item_id LIMIT ?", after, scanPageSize)
+    }, func(it models.ListItem) string { return it.ItemID }, fn)
+}
+
+// scanPages calls fn for each record of the pages returned by next, asking
+// for each page after the key of the previous one's last record until a page
+// comes back short.
+func scanPages[T any](next func(after string) ([]T, error), key func(T) string, fn func(T) error) error {
+    after := ""
+    for {
+        page, err := next(after)
+        if err != nil { return err }
+        for _, v := range page {
+            if err := fn(v); err != nil { return err }
+        }
+        if len(page) < scanPageSize { return nil }
+        after = key(page[len(page)-1])
+    }
+}
+
+func (r *ListItemRepo) SoftDelete(ctx context.Context, itemID string, ts time.Time) error {
+    return r.setField(ctx, itemID, "is_deleted", true, store.AnyVersion, ts)
+}
+
+func (r *ListItemRepo) Restore(ctx context.Context, itemID string, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE list_items SET is_deleted = FALSE, updated_at = ?, version = version + 1 WHERE item_id = ? AND is_deleted = TRUE", updatedAt.UTC(), itemID)
+}
+
+func (r *ListItemRepo) ListTrashedByRoom(ctx context.Context, roomID string) ([]models.ListItem, error) {
+    return r.list(ctx, "SELECT "+itemColumns+" FROM list_items WHERE room_id = ? AND is_deleted = TRUE ORDER BY updated_at DESC, item_id", roomID)
+}
+
+func (r *ListItemRepo) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM list_items WHERE is_deleted = TRUE AND updated_at < ?", cutoff.UTC())
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+func (r *ListItemRepo) Delete(ctx context.Context, itemID string) error {
+    return r.c.execOne(ctx, "DELETE FROM list_items WHERE item_id = ?", itemID)
+}
+
+func (r *ListItemRepo) DeleteByList(ctx context.Context, listID string) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM list_items WHERE list_id = ?", listID)
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+func (r *ListItemRepo) MoveByList(ctx context.Context, fromListID, toListID, roomID string, orderOffset float64, updatedAt time.Time) (int, error) {
+    res, err := r.c.exec(ctx, "UPDATE list_items SET list_id = ?, room_id = ?, sort_order = sort_order + ?, updated_at = ?, version = version + 1 WHERE list_id = ?",
+        toListID, roomID, orderOffset, updatedAt.UTC(), fromListID)
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+func (r *ListItemRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM list_items WHERE room_id = ?", roomID)
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
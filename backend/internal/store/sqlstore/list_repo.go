@@ -0,0 +1,216 @@
+package sqlstore
+
+import (
+    "context"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+type ListRepo struct{ c *Client }
+
+func NewListRepo(c *Client) *ListRepo { return &ListRepo{c: c} }
+
+const listColumns = "list_id, room_id, name, description, notes, icon, is_deleted, version, created_at, updated_at"
+
+func scanList(row rowScanner) (*models.List, error) {
+    var l models.List
+    if err := row.Scan(&l.ListID, &l.RoomID, &l.Name, &l.Description, &l.Notes, &l.Icon, &l.IsDeleted, &l.Version, &l.CreatedAt, &l.UpdatedAt); err != nil {
+        return nil, err
+    }
+    l.CreatedAt, l.UpdatedAt = l.CreatedAt.UTC(), l.UpdatedAt.UTC()
+    return &l, nil
+}
+
+func (r *ListRepo) Put(ctx context.Context, l *models.List) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if _, err := r.c.exec(ctx, "INSERT INTO lists ("+listColumns+") VALUES ("+placeholders(10)+")",
+            l.ListID, l.RoomID, l.Name, l.Description, l.Notes, l.Icon, l.IsDeleted, l.Version, l.CreatedAt.UTC(), l.UpdatedAt.UTC()); err != nil {
+            return err
+        }
+        for uid, ts := range l.DeletionVotes {
+            if _, err := r.c.exec(ctx, "INSERT INTO list_deletion_votes (list_id, user_id, voted_at) VALUES (?, ?, ?)", l.ListID, uid, ts); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (r *ListRepo) GetByID(ctx context.Context, id string) (*models.List, error) {
+    l, err := scanList(r.c.queryRow(ctx, "SELECT "+listColumns+" FROM lists WHERE list_id = ?", id))
+    if err != nil { return nil, notFoundIfNoRow(err) }
+    l.DeletionVotes, err = readVotes(ctx, r.c, "SELECT user_id, voted_at FROM list_deletion_votes WHERE list_id = ?", l.ListID)
+    if err != nil { return nil, err }
+    return l, nil
+}
+
+// ScanAll reads lists in list_id pages, trashed ones included, calling fn
+// between queries.
+func (r *ListRepo) ScanAll(ctx context.Context, fn func(l models.List) error) error {
+    return scanPages(func(after string) ([]models.List, error) {
+        return r.list(ctx, "SELECT "+listColumns+" FROM lists WHERE list_id > ? ORDER BY list_id LIMIT ?", after, scanPageSize)
+    }, func(l models.List) string { return l.ListID }, fn)
+}
+
+func (r *ListRepo) ListByRoom(ctx context.Context, roomID string, page store.Page) ([]models.List, string, error) {
+    // Exclude soft-deleted lists; oldest first.
+    q, args := "SELECT "+listColumns+" FROM lists WHERE room_id = ? AND is_deleted = FALSE", []any{roomID}
+    if page.Cursor != "" {
+        c, err := store.DecodeCursor(page.Cursor)
+        if err != nil { return nil, "", err }
+        q += " AND (created_at > ? OR (created_at = ? AND list_id > ?))"
+        args = append(args, c.Time.UTC(), c.Time.UTC(), c.ID)
+    }
+    q, args = limit(q+" ORDER BY created_at, list_id", args, page)
+    out, err := r.list(ctx, q, args...)
+    if err != nil { return nil, "", err }
+    return store.Trim(out, page.Limit, store.ListKey)
+}
+
+func (r *ListRepo) MoveToRoom(ctx context.Context, listID string, roomID string, updatedAt time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE lists SET room_id = ?, updated_at = ?, version = version + 1 WHERE list_id = ?", roomID, updatedAt.UTC(), listID); err != nil { return err }
+        _, err := r.c.exec(ctx, "DELETE FROM list_deletion_votes WHERE list_id = ?", listID)
+        return err
+    })
+}
+
+func (r *ListRepo) ListTrashedByRoom(ctx context.Context, roomID string) ([]models.List, error) {
+    return r.list(ctx, "SELECT "+listColumns+" FROM lists WHERE room_id = ? AND is_deleted = TRUE ORDER BY updated_at DESC, list_id", roomID)
+}
+
+func (r *ListRepo) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.List, error) {
+    return r.list(ctx, "SELECT "+listColumns+" FROM lists WHERE is_deleted = TRUE AND updated_at < ? ORDER BY updated_at, list_id", cutoff.UTC())
+}
+
+// list runs a lists query and attaches each list's deletion votes.
+func (r *ListRepo) list(ctx context.Context, query string, args ...any) ([]models.List, error) {
+    rows, err := r.c.query(ctx, query, args...)
+    if err != nil { return nil, err }
+    var out []models.List
+    for rows.Next() {
+        l, err := scanList(rows)
+        if err != nil { rows.Close(); return nil, err }
+        out = append(out, *l)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil { return nil, err }
+    for i := range out {
+        out[i].DeletionVotes, err = readVotes(ctx, r.c, "SELECT user_id, voted_at FROM list_deletion_votes WHERE list_id = ?", out[i].ListID)
+        if err != nil { return nil, err }
+    }
+    return out, nil
+}
+
+// setField updates one column of a live (not soft-deleted) list.
+func (r *ListRepo) setField(ctx context.Context, listID, column string, value any, ifVersion int64, updatedAt time.Time) error {
+    return r.c.update(ctx, "lists", column+" = ?, updated_at = ?", []any{value, updatedAt.UTC()}, "list_id = ? AND is_deleted = FALSE", []any{listID}, ifVersion)
+}
+
+func (r *ListRepo) UpdateName(ctx context.Context, listID string, name string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, listID, "name", name, ifVersion, updatedAt)
+}
+
+func (r *ListRepo) UpdateDescription(ctx context.Context, listID string, description string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, listID, "description", description, ifVersion, updatedAt)
+}
+
+func (r *ListRepo) UpdateNotes(ctx context.Context, listID string, notes string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, listID, "notes", notes, ifVersion, updatedAt)
+}
+
+func (r *ListRepo) UpdateIcon(ctx context.Context, listID string, icon string, ifVersion int64, updatedAt time.Time) error {
+    return r.setField(ctx, listID, "icon", icon, ifVersion, updatedAt)
+}
+
+// Patch updates the patched columns in one statement and reads the list back
+// in the same transaction.
+func (r *ListRepo) Patch(ctx context.Context, listID string, p store.ListPatch, ifVersion int64, updatedAt time.Time) (*models.List, error) {
+    var set assignments
+    if p.Name != nil { set.set("name", *p.Name) }
+    if p.Description != nil { set.set("description", *p.Description) }
+    if p.Notes != nil { set.set("notes", *p.Notes) }
+    if p.Icon != nil { set.set("icon", *p.Icon) }
+    set.set("updated_at", updatedAt.UTC())
+    var out *models.List
+    err := r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.update(ctx, "lists", set.String(), set.args, "list_id = ? AND is_deleted = FALSE", []any{listID}, ifVersion); err != nil { return err }
+        var err error
+        out, err = r.GetByID(ctx, listID)
+        return err
+    })
+    return out, err
+}
+
+func (r *ListRepo) AddDeletionVote(ctx context.Context, listID string, userID string, ts time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE lists SET updated_at = ?, version = version + 1 WHERE list_id = ? AND is_deleted = FALSE", ts.UTC(), listID); err != nil {
+            return err
+        }
+        _, err := r.c.exec(ctx, "INSERT INTO list_deletion_votes (list_id, user_id, voted_at) VALUES (?, ?, ?) ON CONFLICT (list_id, user_id) DO UPDATE SET voted_at = excluded.voted_at",
+            listID, userID, ts.UTC().Format(time.RFC3339))
+        return err
+    })
+}
+
+func (r *ListRepo) RemoveDeletionVote(ctx context.Context, listID string, userID string) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE lists SET version = version + 1 WHERE list_id = ?", listID); err != nil {
+            return err
+        }
+        _, err := r.c.exec(ctx, "DELETE FROM list_deletion_votes WHERE list_id = ? AND user_id = ?", listID, userID)
+        return err
+    })
+}
+
+func (r *ListRepo) FinalizeDeleteIfVotedByAll(ctx context.Context, listID string, memberIDs []string, ts time.Time) (bool, error) {
+    // Soft-delete only when every member has a vote on record.
+    query := "UPDATE lists SET is_deleted = TRUE, updated_at = ?, version = version + 1 WHERE list_id = ? AND is_deleted = FALSE"
+    args := []any{ts.UTC(), listID}
+    members := uniqueStrings(memberIDs)
+    if len(members) > 0 {
+        query += " AND (SELECT COUNT(*) FROM list_deletion_votes v WHERE v.list_id = lists.list_id AND v.user_id IN (" + placeholders(len(members)) + ")) = ?"
+        for _, uid := range members { args = append(args, uid) }
+        args = append(args, len(members))
+    }
+    res, err := r.c.exec(ctx, query, args...)
+    if err != nil { return false, err }
+    n, err := res.RowsAffected()
+    if err != nil { return false, err }
+    return n > 0, nil
+}
+
+func (r *ListRepo) Restore(ctx context.Context, listID string, updatedAt time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE lists SET is_deleted = FALSE, updated_at = ?, version = version + 1 WHERE list_id = ? AND is_deleted = TRUE", updatedAt.UTC(), listID); err != nil {
+            return err
+        }
+        _, err := r.c.exec(ctx, "DELETE FROM list_deletion_votes WHERE list_id = ?", listID)
+        return err
+    })
+}
+
+func (r *ListRepo) Delete(ctx context.Context, listID string) error {
+    return r.c.execOne(ctx, "DELETE FROM lists WHERE list_id = ?", listID)
+}
+
+// DeleteByRoom removes all lists of a room; list_deletion_votes rows cascade.
+func (r *ListRepo) DeleteByRoom(ctx context.Context, roomID string) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM lists WHERE room_id = ?", roomID)
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+func uniqueStrings(in []string) []string {
+    seen := make(map[string]bool, len(in))
+    out := make([]string, 0, len(in))
+    for _, s := range in {
+        if seen[s] { continue }
+        seen[s] = true
+        out = append(out, s)
+    }
+    return out
+}
@@ -0,0 +1,75 @@
+package sqlstore
+
+import (
+    "context"
+    "embed"
+    "fmt"
+    "io/fs"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schema migrations live in migrations/<dialect>/NNNN_name.sql and are applied
+// in version order. Applied versions are recorded in schema_migrations.
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+type migration struct {
+    version int
+    name    string
+    sql     string
+}
+
+func (c *Client) migrations() ([]migration, error) {
+    dir := "migrations/" + c.dialect
+    entries, err := fs.ReadDir(migrationsFS, dir)
+    if err != nil { return nil, err }
+    var out []migration
+    for _, e := range entries {
+        if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") { continue }
+        prefix, _, _ := strings.Cut(e.Name(), "_")
+        v, err := strconv.Atoi(prefix)
+        if err != nil { return nil, fmt.Errorf("sqlstore: migration %s: bad version prefix", e.Name()) }
+        body, err := fs.ReadFile(migrationsFS, dir+"/"+e.Name())
+        if err != nil { return nil, err }
+        out = append(out, migration{version: v, name: e.Name(), sql: string(body)})
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+    return out, nil
+}
+
+// Migrate applies the embedded schema migrations that have not run yet, each
+// in its own transaction.
+func (c *Client) Migrate(ctx context.Context) error {
+    tsType := "TIMESTAMP"
+    if c.dialect == DialectPostgres { tsType = "TIMESTAMPTZ" }
+    if _, err := c.DB.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at "+tsType+" NOT NULL)"); err != nil {
+        return fmt.Errorf("sqlstore: create schema_migrations: %w", err)
+    }
+    applied := map[int]bool{}
+    rows, err := c.DB.QueryContext(ctx, "SELECT version FROM schema_migrations")
+    if err != nil { return err }
+    for rows.Next() {
+        var v int
+        if err := rows.Scan(&v); err != nil { rows.Close(); return err }
+        applied[v] = true
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil { return err }
+
+    ms, err := c.migrations()
+    if err != nil { return err }
+    for _, m := range ms {
+        if applied[m.version] { continue }
+        err := c.inTx(ctx, func(ctx context.Context) error {
+            if _, err := c.conn(ctx).ExecContext(ctx, m.sql); err != nil { return err }
+            _, err := c.exec(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)", m.version, time.Now().UTC())
+            return err
+        })
+        if err != nil { return fmt.Errorf("sqlstore: migration %s: %w", m.name, err) }
+    }
+    return nil
+}
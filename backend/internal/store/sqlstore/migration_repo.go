@@ -0,0 +1,31 @@
+package sqlstore
+
+import (
+    "context"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// MigrationRepo records applied data migrations in data_migrations.
+type MigrationRepo struct{ c *Client }
+
+func NewMigrationRepo(c *Client) *MigrationRepo { return &MigrationRepo{c: c} }
+
+func (r *MigrationRepo) ListApplied(ctx context.Context) ([]models.MigrationRecord, error) {
+    rows, err := r.c.query(ctx, "SELECT version, name, applied_at FROM data_migrations ORDER BY version")
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []models.MigrationRecord
+    for rows.Next() {
+        var rec models.MigrationRecord
+        if err := rows.Scan(&rec.Version, &rec.Name, &rec.AppliedAt); err != nil { return nil, err }
+        rec.AppliedAt = rec.AppliedAt.UTC()
+        out = append(out, rec)
+    }
+    return out, rows.Err()
+}
+
+func (r *MigrationRepo) Record(ctx context.Context, rec models.MigrationRecord) error {
+    _, err := r.c.exec(ctx, "INSERT INTO data_migrations (version, name, applied_at) VALUES (?, ?, ?)", rec.Version, rec.Name, rec.AppliedAt.UTC())
+    return err
+}
@@ -0,0 +1,81 @@
+CREATE TABLE users (
+    user_id            TEXT PRIMARY KEY,
+    name               TEXT NOT NULL DEFAULT '',
+    username           TEXT UNIQUE,
+    password_enc       TEXT NOT NULL DEFAULT '',
+    api_key_hash       TEXT NOT NULL DEFAULT '',
+    api_key_lookup     TEXT UNIQUE,
+    api_key_expires_at TIMESTAMPTZ,
+    room_id            TEXT,
+    created_at         TIMESTAMPTZ NOT NULL,
+    updated_at         TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE rooms (
+    room_id      TEXT PRIMARY KEY,
+    display_name TEXT NOT NULL DEFAULT '',
+    description  TEXT NOT NULL DEFAULT '',
+    share_token  TEXT UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL
+);
+
+-- position keeps members in join order.
+CREATE TABLE room_members (
+    room_id  TEXT NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+    user_id  TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (room_id, user_id)
+);
+CREATE INDEX room_members_user_id ON room_members (user_id);
+
+CREATE TABLE room_deletion_votes (
+    room_id  TEXT NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+    user_id  TEXT NOT NULL,
+    voted_at TEXT NOT NULL,
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE TABLE lists (
+    list_id     TEXT PRIMARY KEY,
+    room_id     TEXT NOT NULL,
+    name        TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    notes       TEXT NOT NULL DEFAULT '',
+    icon        TEXT NOT NULL DEFAULT '',
+    is_deleted  BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX lists_room_id ON lists (room_id, created_at);
+
+CREATE TABLE list_deletion_votes (
+    list_id  TEXT NOT NULL REFERENCES lists (list_id) ON DELETE CASCADE,
+    user_id  TEXT NOT NULL,
+    voted_at TEXT NOT NULL,
+    PRIMARY KEY (list_id, user_id)
+);
+
+CREATE TABLE list_items (
+    item_id     TEXT PRIMARY KEY,
+    list_id     TEXT NOT NULL,
+    room_id     TEXT NOT NULL,
+    sort_order  DOUBLE PRECISION NOT NULL DEFAULT 0,
+    description TEXT NOT NULL DEFAULT '',
+    quantity    TEXT NOT NULL DEFAULT '',
+    unit        TEXT NOT NULL DEFAULT '',
+    category    TEXT NOT NULL DEFAULT '',
+    is_starred  BOOLEAN NOT NULL DEFAULT FALSE,
+    is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+    completed   BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX list_items_list_id ON list_items (list_id, sort_order, created_at);
+CREATE INDEX list_items_room_id ON list_items (room_id, is_archived, updated_at);
+
+CREATE TABLE category_index (
+    key        TEXT PRIMARY KEY,
+    category   TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,4 @@
+-- Token sessions: no API key (key_lookup is NULL) and a refresh generation.
+ALTER TABLE sessions ALTER COLUMN key_lookup DROP NOT NULL;
+ALTER TABLE sessions ALTER COLUMN key_hash SET DEFAULT '';
+ALTER TABLE sessions ADD COLUMN refresh_gen BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Email verification: set once the user follows a link mailed to their username.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
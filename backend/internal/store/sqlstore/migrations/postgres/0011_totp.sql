@@ -0,0 +1,3 @@
+-- Two-factor login: the encrypted TOTP secret, enabled once confirmed.
+ALTER TABLE users ADD COLUMN totp_secret_enc TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMPTZ;
@@ -0,0 +1,11 @@
+-- Every room a user belongs to, in join order; users.room_id is the active
+-- one. Existing members start with the room they are in.
+CREATE TABLE user_rooms (
+    user_id  TEXT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    room_id  TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (user_id, room_id)
+);
+
+INSERT INTO user_rooms (user_id, room_id, position)
+SELECT m.user_id, m.room_id, 1 FROM room_members m JOIN users u ON u.user_id = m.user_id;
@@ -0,0 +1,2 @@
+-- Each member's role in the room; '' counts as member.
+ALTER TABLE room_members ADD COLUMN role TEXT NOT NULL DEFAULT '';
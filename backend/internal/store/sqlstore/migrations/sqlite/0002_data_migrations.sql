@@ -0,0 +1,6 @@
+-- Applied data migrations (internal/migrate); schema_migrations tracks this DDL.
+CREATE TABLE data_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+);
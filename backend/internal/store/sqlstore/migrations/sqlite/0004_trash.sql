@@ -0,0 +1,4 @@
+-- Trash: deleted items are kept, flagged, until restored or purged.
+ALTER TABLE list_items ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX list_items_deleted ON list_items (is_deleted, updated_at);
+CREATE INDEX lists_deleted ON lists (is_deleted, updated_at);
@@ -0,0 +1,4 @@
+-- Optimistic concurrency: every write increments version.
+ALTER TABLE rooms ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE lists ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE list_items ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,19 @@
+-- Token sessions: no API key (key_lookup is NULL) and a refresh generation.
+-- SQLite cannot drop NOT NULL in place, so the table is rebuilt.
+CREATE TABLE sessions_new (
+    session_id   TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    name         TEXT NOT NULL DEFAULT '',
+    key_hash     TEXT NOT NULL DEFAULT '',
+    key_lookup   TEXT UNIQUE,
+    refresh_gen  BIGINT NOT NULL DEFAULT 0,
+    created_at   TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NOT NULL,
+    expires_at   TIMESTAMP
+);
+INSERT INTO sessions_new (session_id, user_id, name, key_hash, key_lookup, created_at, last_used_at, expires_at)
+    SELECT session_id, user_id, name, key_hash, key_lookup, created_at, last_used_at, expires_at FROM sessions;
+DROP TABLE sessions;
+ALTER TABLE sessions_new RENAME TO sessions;
+CREATE INDEX sessions_user_id ON sessions (user_id);
+CREATE INDEX sessions_expires_at ON sessions (expires_at);
@@ -0,0 +1,10 @@
+-- Single-use tokens mailed to users (password reset). Only hashes are stored.
+CREATE TABLE user_tokens (
+    token_hash TEXT PRIMARY KEY,
+    purpose    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX user_tokens_user_id ON user_tokens (user_id, purpose);
+CREATE INDEX user_tokens_expires_at ON user_tokens (expires_at);
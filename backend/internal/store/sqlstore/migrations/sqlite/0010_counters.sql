@@ -0,0 +1,7 @@
+-- Expiring counters shared by every server (rate limit windows).
+CREATE TABLE counters (
+    counter_key TEXT PRIMARY KEY,
+    count       INTEGER NOT NULL,
+    expires_at  TIMESTAMP NOT NULL
+);
+CREATE INDEX counters_expires_at ON counters (expires_at);
@@ -0,0 +1,16 @@
+-- Room invites: several per room, each with a short code and an optional
+-- long link token.
+CREATE TABLE invites (
+    invite_id  TEXT PRIMARY KEY,
+    room_id    TEXT NOT NULL,
+    name       TEXT NOT NULL DEFAULT '',
+    code       TEXT NOT NULL UNIQUE,
+    link_token TEXT UNIQUE,
+    created_by TEXT NOT NULL,
+    max_uses   INTEGER NOT NULL,
+    uses       INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX invites_room_id ON invites (room_id, created_at);
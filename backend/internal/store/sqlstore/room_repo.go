@@ -0,0 +1,205 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// RoomRepo keeps members, with their roles, and deletion votes in
+// room_members and room_deletion_votes; both cascade when the room is deleted.
+type RoomRepo struct{ c *Client }
+
+func NewRoomRepo(c *Client) *RoomRepo { return &RoomRepo{c: c} }
+
+// isMember restricts a rooms UPDATE to rows where userID is a member.
+const isMember = "EXISTS (SELECT 1 FROM room_members m WHERE m.room_id = rooms.room_id AND m.user_id = ?)"
+
+func (r *RoomRepo) Put(ctx context.Context, rm *models.Room) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        var token sql.NullString
+        if rm.ShareToken != nil { token = nullString(*rm.ShareToken) }
+        if _, err := r.c.exec(ctx, "INSERT INTO rooms (room_id, display_name, description, share_token, version, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+            rm.RoomID, rm.DisplayName, rm.Description, token, rm.Version, rm.CreatedAt.UTC(), rm.UpdatedAt.UTC()); err != nil {
+            return err
+        }
+        for i, uid := range rm.MemberIDs {
+            if _, err := r.c.exec(ctx, "INSERT INTO room_members (room_id, user_id, position, role) VALUES (?, ?, ?, ?)", rm.RoomID, uid, i+1, string(rm.Roles[uid])); err != nil {
+                return err
+            }
+        }
+        for uid, ts := range rm.DeletionVotes {
+            if _, err := r.c.exec(ctx, "INSERT INTO room_deletion_votes (room_id, user_id, voted_at) VALUES (?, ?, ?)", rm.RoomID, uid, ts); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (r *RoomRepo) GetByID(ctx context.Context, id string) (*models.Room, error) {
+    return r.get(ctx, "room_id", id)
+}
+
+// ScanAll reads room_id pages and loads each room of a page, calling fn
+// between queries.
+func (r *RoomRepo) ScanAll(ctx context.Context, fn func(rm models.Room) error) error {
+    return scanPages(func(after string) ([]models.Room, error) {
+        rows, err := r.c.query(ctx, "SELECT room_id FROM rooms WHERE room_id > ? ORDER BY room_id LIMIT ?", after, scanPageSize)
+        if err != nil { return nil, err }
+        var ids []string
+        for rows.Next() {
+            var id string
+            if err := rows.Scan(&id); err != nil { rows.Close(); return nil, err }
+            ids = append(ids, id)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil { return nil, err }
+        out := make([]models.Room, 0, len(ids))
+        for _, id := range ids {
+            rm, err := r.GetByID(ctx, id)
+            if err != nil { return nil, err }
+            out = append(out, *rm)
+        }
+        return out, nil
+    }, func(rm models.Room) string { return rm.RoomID }, fn)
+}
+
+func (r *RoomRepo) GetByShareToken(ctx context.Context, token string) (*models.Room, error) {
+    return r.get(ctx, "share_token", token)
+}
+
+func (r *RoomRepo) get(ctx context.Context, where string, arg any) (*models.Room, error) {
+    var rm models.Room
+    var token sql.NullString
+    err := r.c.queryRow(ctx, "SELECT room_id, display_name, description, share_token, version, created_at, updated_at FROM rooms WHERE "+where+" = ?", arg).
+        Scan(&rm.RoomID, &rm.DisplayName, &rm.Description, &token, &rm.Version, &rm.CreatedAt, &rm.UpdatedAt)
+    if err != nil { return nil, notFoundIfNoRow(err) }
+    if token.Valid { rm.ShareToken = &token.String }
+    rm.CreatedAt, rm.UpdatedAt = rm.CreatedAt.UTC(), rm.UpdatedAt.UTC()
+
+    members, err := r.c.query(ctx, "SELECT user_id, role FROM room_members WHERE room_id = ? ORDER BY position", rm.RoomID)
+    if err != nil { return nil, err }
+    defer members.Close()
+    for members.Next() {
+        var uid, role string
+        if err := members.Scan(&uid, &role); err != nil { return nil, err }
+        rm.MemberIDs = append(rm.MemberIDs, uid)
+        if role != "" {
+            if rm.Roles == nil { rm.Roles = map[string]models.Role{} }
+            rm.Roles[uid] = models.Role(role)
+        }
+    }
+    if err := members.Err(); err != nil { return nil, err }
+
+    rm.DeletionVotes, err = readVotes(ctx, r.c, "SELECT user_id, voted_at FROM room_deletion_votes WHERE room_id = ?", rm.RoomID)
+    if err != nil { return nil, err }
+    return &rm, nil
+}
+
+// readVotes loads a deletion vote map; nil when there are no votes.
+func readVotes(ctx context.Context, c *Client, query string, id string) (map[string]string, error) {
+    rows, err := c.query(ctx, query, id)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var votes map[string]string
+    for rows.Next() {
+        var uid, ts string
+        if err := rows.Scan(&uid, &ts); err != nil { return nil, err }
+        if votes == nil { votes = map[string]string{} }
+        votes[uid] = ts
+    }
+    return votes, rows.Err()
+}
+
+func (r *RoomRepo) SetShareToken(ctx context.Context, roomID string, userID string, token string, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE rooms SET share_token = ?, updated_at = ?, version = version + 1 WHERE room_id = ? AND "+isMember,
+        token, updatedAt.UTC(), roomID, userID)
+}
+
+func (r *RoomRepo) RemoveShareToken(ctx context.Context, roomID string, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE rooms SET share_token = NULL, updated_at = ?, version = version + 1 WHERE room_id = ?", updatedAt.UTC(), roomID)
+}
+
+func (r *RoomRepo) UpdateDescription(ctx context.Context, roomID string, userID string, description string, ifVersion int64, updatedAt time.Time) error {
+    return r.c.update(ctx, "rooms", "description = ?, updated_at = ?", []any{description, updatedAt.UTC()}, "room_id = ? AND "+isMember, []any{roomID, userID}, ifVersion)
+}
+
+func (r *RoomRepo) UpdateDisplayName(ctx context.Context, roomID string, userID string, displayName string, ifVersion int64, updatedAt time.Time) error {
+    return r.c.update(ctx, "rooms", "display_name = ?, updated_at = ?", []any{displayName, updatedAt.UTC()}, "room_id = ? AND "+isMember, []any{roomID, userID}, ifVersion)
+}
+
+// Patch updates the patched settings in one statement and reads the room back
+// in the same transaction.
+func (r *RoomRepo) Patch(ctx context.Context, roomID string, userID string, p store.RoomPatch, ifVersion int64, updatedAt time.Time) (*models.Room, error) {
+    var set assignments
+    if p.DisplayName != nil { set.set("display_name", *p.DisplayName) }
+    if p.Description != nil { set.set("description", *p.Description) }
+    set.set("updated_at", updatedAt.UTC())
+    var out *models.Room
+    err := r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.update(ctx, "rooms", set.String(), set.args, "room_id = ? AND "+isMember, []any{roomID, userID}, ifVersion); err != nil { return err }
+        var err error
+        out, err = r.GetByID(ctx, roomID)
+        return err
+    })
+    return out, err
+}
+
+func (r *RoomRepo) VoteDeletion(ctx context.Context, roomID string, userID string, ts time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE rooms SET updated_at = ?, version = version + 1 WHERE room_id = ?", ts.UTC(), roomID); err != nil {
+            return err
+        }
+        _, err := r.c.exec(ctx, "INSERT INTO room_deletion_votes (room_id, user_id, voted_at) VALUES (?, ?, ?) ON CONFLICT (room_id, user_id) DO UPDATE SET voted_at = excluded.voted_at",
+            roomID, userID, ts.UTC().Format(time.RFC3339))
+        return err
+    })
+}
+
+func (r *RoomRepo) RemoveDeletionVote(ctx context.Context, roomID string, userID string) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE rooms SET version = version + 1 WHERE room_id = ?", roomID); err != nil { return err }
+        _, err := r.c.exec(ctx, "DELETE FROM room_deletion_votes WHERE room_id = ? AND user_id = ?", roomID, userID)
+        return err
+    })
+}
+
+func (r *RoomRepo) Delete(ctx context.Context, roomID string) error {
+    return r.c.execOne(ctx, "DELETE FROM rooms WHERE room_id = ?", roomID)
+}
+
+func (r *RoomRepo) AddMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE rooms SET updated_at = ?, version = version + 1 WHERE room_id = ?", updatedAt.UTC(), roomID); err != nil {
+            return err
+        }
+        // A duplicate (room_id, user_id) key maps to derr.ErrConflict.
+        _, err := r.c.exec(ctx, "INSERT INTO room_members (room_id, user_id, position) SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM room_members WHERE room_id = ?",
+            roomID, userID, roomID)
+        return err
+    })
+}
+
+func (r *RoomRepo) RemoveMember(ctx context.Context, roomID string, userID string, updatedAt time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "DELETE FROM room_members WHERE room_id = ? AND user_id = ?", roomID, userID); err != nil {
+            return err
+        }
+        return r.c.execOne(ctx, "UPDATE rooms SET updated_at = ?, version = version + 1 WHERE room_id = ?", updatedAt.UTC(), roomID)
+    })
+}
+
+func (r *RoomRepo) SetRoles(ctx context.Context, roomID string, roles map[string]models.Role, updatedAt time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        for uid, role := range roles {
+            if err := r.c.execOne(ctx, "UPDATE room_members SET role = ? WHERE room_id = ? AND user_id = ?", string(role), roomID, uid); err != nil {
+                return err
+            }
+        }
+        return r.c.execOne(ctx, "UPDATE rooms SET updated_at = ?, version = version + 1 WHERE room_id = ?", updatedAt.UTC(), roomID)
+    })
+}
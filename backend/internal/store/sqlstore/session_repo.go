@@ -0,0 +1,96 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// SessionRepo stores sessions in the sessions table.
+type SessionRepo struct{ c *Client }
+
+func NewSessionRepo(c *Client) *SessionRepo { return &SessionRepo{c: c} }
+
+const sessionColumns = "session_id, user_id, name, key_hash, key_lookup, refresh_gen, created_at, last_used_at, expires_at"
+
+func scanSession(row rowScanner) (*models.Session, error) {
+    var s models.Session
+    var lookup sql.NullString
+    var expiresAt sql.NullTime
+    if err := row.Scan(&s.SessionID, &s.UserID, &s.Name, &s.KeyHash, &lookup, &s.RefreshGen, &s.CreatedAt, &s.LastUsedAt, &expiresAt); err != nil {
+        return nil, err
+    }
+    s.KeyLookup = lookup.String
+    if expiresAt.Valid {
+        t := expiresAt.Time.UTC()
+        s.ExpiresAt = &t
+    }
+    s.CreatedAt, s.LastUsedAt = s.CreatedAt.UTC(), s.LastUsedAt.UTC()
+    return &s, nil
+}
+
+func (r *SessionRepo) Create(ctx context.Context, s *models.Session) error {
+    _, err := r.c.exec(ctx, "INSERT INTO sessions ("+sessionColumns+") VALUES ("+placeholders(9)+")",
+        s.SessionID, s.UserID, s.Name, s.KeyHash, nullString(s.KeyLookup), s.RefreshGen, s.CreatedAt.UTC(), s.LastUsedAt.UTC(), nullTime(s.ExpiresAt))
+    return err
+}
+
+func (r *SessionRepo) get(ctx context.Context, where string, arg any) (*models.Session, error) {
+    s, err := scanSession(r.c.queryRow(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE "+where+" = ?", arg))
+    return s, notFoundIfNoRow(err)
+}
+
+func (r *SessionRepo) GetByID(ctx context.Context, id string) (*models.Session, error) {
+    return r.get(ctx, "session_id", id)
+}
+
+func (r *SessionRepo) GetByKeyLookup(ctx context.Context, lookup string) (*models.Session, error) {
+    return r.get(ctx, "key_lookup", lookup)
+}
+
+func (r *SessionRepo) ListByUser(ctx context.Context, userID string) ([]models.Session, error) {
+    rows, err := r.c.query(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? ORDER BY last_used_at DESC, session_id", userID)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []models.Session
+    for rows.Next() {
+        s, err := scanSession(rows)
+        if err != nil { return nil, err }
+        out = append(out, *s)
+    }
+    return out, rows.Err()
+}
+
+func (r *SessionRepo) Touch(ctx context.Context, sessionID string, lastUsedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE sessions SET last_used_at = ? WHERE session_id = ?", lastUsedAt.UTC(), sessionID)
+}
+
+func (r *SessionRepo) Rotate(ctx context.Context, sessionID string, gen int64, lastUsedAt time.Time, expiresAt *time.Time) error {
+    res, err := r.c.exec(ctx, "UPDATE sessions SET refresh_gen = refresh_gen + 1, last_used_at = ?, expires_at = ? WHERE session_id = ? AND refresh_gen = ?",
+        lastUsedAt.UTC(), nullTime(expiresAt), sessionID, gen)
+    if err := notFoundIfNoRows(res, err); !errors.Is(err, derr.ErrNotFound) { return err }
+    if _, err := r.GetByID(ctx, sessionID); err != nil { return err }
+    return derr.ErrConflict
+}
+
+func (r *SessionRepo) Delete(ctx context.Context, sessionID string) error {
+    return r.c.execOne(ctx, "DELETE FROM sessions WHERE session_id = ?", sessionID)
+}
+
+func (r *SessionRepo) DeleteByUser(ctx context.Context, userID string) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM sessions WHERE user_id = ?", userID)
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+func (r *SessionRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM sessions WHERE expires_at < ?", cutoff.UTC())
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
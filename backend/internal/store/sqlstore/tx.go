@@ -0,0 +1,49 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// Tx implements store.TxRunner with a database transaction. Repositories
+// called with the context passed to fn read and write through it.
+type Tx struct{ c *Client }
+
+func NewTx(c *Client) *Tx { return &Tx{c: c} }
+
+type txKey struct{}
+
+func txFrom(ctx context.Context) *sql.Tx {
+    tx, _ := ctx.Value(txKey{}).(*sql.Tx)
+    return tx
+}
+
+func (t *Tx) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+    if txFrom(ctx) != nil {
+        // Nested call: join the outer transaction.
+        return fn(ctx)
+    }
+    tx, err := t.c.DB.BeginTx(ctx, nil)
+    if err != nil { return err }
+    committed := false
+    defer func() {
+        if !committed { _ = tx.Rollback() }
+    }()
+    if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+        return err
+    }
+    if err := tx.Commit(); err != nil { return mapErr(err) }
+    committed = true
+    return nil
+}
+
+// inTx runs fn in the caller's transaction, or in a new one for repository
+// methods that issue several statements.
+func (c *Client) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return NewTx(c).WithTransaction(ctx, fn)
+}
+
+// Ensure Tx implements store.TxRunner
+var _ store.TxRunner = (*Tx)(nil)
@@ -0,0 +1,70 @@
+package sqlstore
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+func TestTxRollsBackOnError(t *testing.T) {
+    c := openSQLite(t)
+    ctx := context.Background()
+    users, rooms := NewUserRepo(c), NewRoomRepo(c)
+    now := time.Now().UTC()
+    boom := errors.New("boom")
+
+    err := NewTx(c).WithTransaction(ctx, func(ctx context.Context) error {
+        if err := users.Put(ctx, &models.User{UserID: "usr_tx", Name: "A", CreatedAt: now, UpdatedAt: now}); err != nil { return err }
+        if err := rooms.Put(ctx, &models.Room{RoomID: "room_tx", MemberIDs: []string{"usr_tx"}, CreatedAt: now, UpdatedAt: now}); err != nil { return err }
+        return boom
+    })
+    if !errors.Is(err, boom) { t.Fatalf("tx: got %v, want boom", err) }
+    if _, err := users.GetByID(ctx, "usr_tx"); !errors.Is(err, derr.ErrNotFound) { t.Fatalf("user survived rollback: %v", err) }
+    if _, err := rooms.GetByID(ctx, "room_tx"); !errors.Is(err, derr.ErrNotFound) { t.Fatalf("room survived rollback: %v", err) }
+}
+
+func TestTxCommitsAndNests(t *testing.T) {
+    c := openSQLite(t)
+    ctx := context.Background()
+    users := NewUserRepo(c)
+    tx := NewTx(c)
+    now := time.Now().UTC()
+
+    err := tx.WithTransaction(ctx, func(ctx context.Context) error {
+        if err := users.Put(ctx, &models.User{UserID: "usr_a", Name: "A", CreatedAt: now, UpdatedAt: now}); err != nil { return err }
+        return tx.WithTransaction(ctx, func(ctx context.Context) error {
+            // The inner call joins the outer transaction and sees its writes.
+            return users.UpdateName(ctx, "usr_a", "B", now)
+        })
+    })
+    if err != nil { t.Fatalf("tx: %v", err) }
+    u, err := users.GetByID(ctx, "usr_a")
+    if err != nil || u.Name != "B" { t.Fatalf("after commit: %+v %v", u, err) }
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+    c := openSQLite(t)
+    if err := c.Migrate(context.Background()); err != nil { t.Fatalf("second migrate: %v", err) }
+}
+
+func TestDuplicateKeyIsConflict(t *testing.T) {
+    c := openSQLite(t)
+    ctx := context.Background()
+    users := NewUserRepo(c)
+    now := time.Now().UTC()
+    if err := users.Put(ctx, &models.User{UserID: "usr_1", Username: "a@example.com", CreatedAt: now, UpdatedAt: now}); err != nil { t.Fatalf("put: %v", err) }
+    err := users.Put(ctx, &models.User{UserID: "usr_2", Username: "a@example.com", CreatedAt: now, UpdatedAt: now})
+    if !errors.Is(err, derr.ErrConflict) { t.Fatalf("duplicate username: got %v, want ErrConflict", err) }
+}
+
+func TestRebind(t *testing.T) {
+    c := &Client{dialect: DialectPostgres}
+    got := c.rebind("UPDATE t SET a = ? WHERE b = ? AND c IN (?, ?)")
+    if got != "UPDATE t SET a = $1 WHERE b = $2 AND c IN ($3, $4)" { t.Fatalf("rebind: %s", got) }
+    c.dialect = DialectSQLite
+    if got := c.rebind("SELECT ?"); got != "SELECT ?" { t.Fatalf("sqlite rebind: %s", got) }
+}
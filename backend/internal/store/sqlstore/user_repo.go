@@ -0,0 +1,211 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "strings"
+    "time"
+
+    derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// UserRepo keeps the rooms a user belongs to in user_rooms, which cascades
+// when the user is deleted.
+type UserRepo struct{ c *Client }
+
+func NewUserRepo(c *Client) *UserRepo { return &UserRepo{c: c} }
+
+const userColumns = "user_id, name, username, password_enc, api_key_hash, api_key_lookup, api_key_expires_at, email_verified_at, totp_secret_enc, totp_enabled_at, room_id, created_at, updated_at"
+
+type rowScanner interface{ Scan(dest ...any) error }
+
+func scanUser(row rowScanner) (*models.User, error) {
+    var u models.User
+    var username, lookup, roomID sql.NullString
+    var expiresAt, verifiedAt, totpAt sql.NullTime
+    if err := row.Scan(&u.UserID, &u.Name, &username, &u.PasswordEnc, &u.APIKeyHash, &lookup, &expiresAt, &verifiedAt, &u.TOTPSecretEnc, &totpAt, &roomID, &u.CreatedAt, &u.UpdatedAt); err != nil {
+        return nil, err
+    }
+    u.Username = username.String
+    u.APIKeyLookup = lookup.String
+    if expiresAt.Valid {
+        t := expiresAt.Time.UTC()
+        u.APIKeyExpiresAt = &t
+    }
+    if verifiedAt.Valid {
+        t := verifiedAt.Time.UTC()
+        u.EmailVerifiedAt = &t
+    }
+    if totpAt.Valid {
+        t := totpAt.Time.UTC()
+        u.TOTPEnabledAt = &t
+    }
+    if roomID.Valid {
+        u.RoomID = &roomID.String
+    }
+    u.CreatedAt, u.UpdatedAt = u.CreatedAt.UTC(), u.UpdatedAt.UTC()
+    return &u, nil
+}
+
+// loadRooms fills in u.RoomIDs; nil when the user is in no room.
+func (r *UserRepo) loadRooms(ctx context.Context, u *models.User) error {
+    rows, err := r.c.query(ctx, "SELECT room_id FROM user_rooms WHERE user_id = ? ORDER BY position", u.UserID)
+    if err != nil { return err }
+    defer rows.Close()
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil { return err }
+        u.RoomIDs = append(u.RoomIDs, id)
+    }
+    return rows.Err()
+}
+
+func (r *UserRepo) Put(ctx context.Context, u *models.User) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        var roomID sql.NullString
+        if u.RoomID != nil { roomID = nullString(*u.RoomID) }
+        if _, err := r.c.exec(ctx, "INSERT INTO users ("+userColumns+") VALUES ("+placeholders(13)+")",
+            u.UserID, u.Name, nullString(u.Username), u.PasswordEnc, u.APIKeyHash, nullString(u.APIKeyLookup),
+            nullTime(u.APIKeyExpiresAt), nullTime(u.EmailVerifiedAt), u.TOTPSecretEnc, nullTime(u.TOTPEnabledAt), roomID, u.CreatedAt.UTC(), u.UpdatedAt.UTC()); err != nil {
+            return err
+        }
+        for i, id := range u.RoomIDs {
+            if _, err := r.c.exec(ctx, "INSERT INTO user_rooms (user_id, room_id, position) VALUES (?, ?, ?)", u.UserID, id, i+1); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (r *UserRepo) get(ctx context.Context, where string, arg any) (*models.User, error) {
+    u, err := scanUser(r.c.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE "+where+" = ?", arg))
+    if err != nil { return nil, err }
+    if err := r.loadRooms(ctx, u); err != nil { return nil, err }
+    return u, nil
+}
+
+func (r *UserRepo) GetByID(ctx context.Context, id string) (*models.User, error) {
+    u, err := r.get(ctx, "user_id", id)
+    return u, notFoundIfNoRow(err)
+}
+
+// ScanAll reads users in user_id pages, calling fn between queries.
+func (r *UserRepo) ScanAll(ctx context.Context, fn func(u models.User) error) error {
+    return scanPages(func(after string) ([]models.User, error) {
+        rows, err := r.c.query(ctx, "SELECT "+userColumns+" FROM users WHERE user_id > ? ORDER BY user_id LIMIT ?", after, scanPageSize)
+        if err != nil { return nil, err }
+        var out []models.User
+        for rows.Next() {
+            u, err := scanUser(rows)
+            if err != nil { rows.Close(); return nil, err }
+            out = append(out, *u)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil { return nil, err }
+        // Rooms are loaded once the page is read, as for RoomRepo.ScanAll.
+        for i := range out {
+            if err := r.loadRooms(ctx, &out[i]); err != nil { return nil, err }
+        }
+        return out, nil
+    }, func(u models.User) string { return u.UserID }, fn)
+}
+
+func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+    u, err := r.get(ctx, "username", username)
+    return u, notFoundIfNoRow(err)
+}
+
+func (r *UserRepo) GetByAPIKeyLookup(ctx context.Context, lookup string) (*models.User, error) {
+    u, err := r.get(ctx, "api_key_lookup", lookup)
+    if err == sql.ErrNoRows { return nil, derr.ErrUnauthorized }
+    return u, err
+}
+
+func (r *UserRepo) SetAPIKey(ctx context.Context, userID string, hash, lookup string, expiresAt *time.Time, updatedAt time.Time) error {
+    if expiresAt == nil {
+        return r.c.execOne(ctx, "UPDATE users SET api_key_hash = ?, api_key_lookup = ?, updated_at = ? WHERE user_id = ?",
+            hash, nullString(lookup), updatedAt.UTC(), userID)
+    }
+    return r.c.execOne(ctx, "UPDATE users SET api_key_hash = ?, api_key_lookup = ?, api_key_expires_at = ?, updated_at = ? WHERE user_id = ?",
+        hash, nullString(lookup), expiresAt.UTC(), updatedAt.UTC(), userID)
+}
+
+func (r *UserRepo) ClearAPIKey(ctx context.Context, userID, lookup string) error {
+    return r.c.execOne(ctx, "UPDATE users SET api_key_hash = '', api_key_lookup = NULL, api_key_expires_at = NULL WHERE user_id = ? AND api_key_lookup = ?", userID, lookup)
+}
+
+func (r *UserRepo) UpdateName(ctx context.Context, userID string, name string, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE users SET name = ?, updated_at = ? WHERE user_id = ?", name, updatedAt.UTC(), userID)
+}
+
+func (r *UserRepo) SetRoomID(ctx context.Context, userID string, roomID *string, updatedAt time.Time) error {
+    var v sql.NullString
+    if roomID != nil { v = nullString(*roomID) }
+    return r.c.execOne(ctx, "UPDATE users SET room_id = ?, updated_at = ? WHERE user_id = ?", v, updatedAt.UTC(), userID)
+}
+
+func (r *UserRepo) AddRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "UPDATE users SET updated_at = ? WHERE user_id = ?", updatedAt.UTC(), userID); err != nil {
+            return err
+        }
+        // A duplicate (user_id, room_id) key maps to derr.ErrConflict.
+        _, err := r.c.exec(ctx, "INSERT INTO user_rooms (user_id, room_id, position) SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM user_rooms WHERE user_id = ?",
+            userID, roomID, userID)
+        return err
+    })
+}
+
+func (r *UserRepo) RemoveRoom(ctx context.Context, userID string, roomID string, updatedAt time.Time) error {
+    return r.c.inTx(ctx, func(ctx context.Context) error {
+        if err := r.c.execOne(ctx, "DELETE FROM user_rooms WHERE user_id = ? AND room_id = ?", userID, roomID); err != nil {
+            return err
+        }
+        return r.c.execOne(ctx, "UPDATE users SET updated_at = ? WHERE user_id = ?", updatedAt.UTC(), userID)
+    })
+}
+
+func (r *UserRepo) UpdateUsername(ctx context.Context, userID string, username string, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE users SET username = ?, email_verified_at = NULL, updated_at = ? WHERE user_id = ?", nullString(username), updatedAt.UTC(), userID)
+}
+
+func (r *UserRepo) MarkEmailVerified(ctx context.Context, userID, username string, at time.Time) error {
+    return r.c.execOne(ctx, "UPDATE users SET email_verified_at = ? WHERE user_id = ? AND username = ?", at.UTC(), userID, username)
+}
+
+func (r *UserRepo) UpdatePasswordEnc(ctx context.Context, userID string, enc string, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE users SET password_enc = ?, updated_at = ? WHERE user_id = ?", enc, updatedAt.UTC(), userID)
+}
+
+func (r *UserRepo) SetTOTPSecret(ctx context.Context, userID, secretEnc string, updatedAt time.Time) error {
+    return r.c.execOne(ctx, "UPDATE users SET totp_secret_enc = ?, totp_enabled_at = NULL, updated_at = ? WHERE user_id = ?", secretEnc, updatedAt.UTC(), userID)
+}
+
+func (r *UserRepo) EnableTOTP(ctx context.Context, userID, secretEnc string, at time.Time) error {
+    return r.c.execOne(ctx, "UPDATE users SET totp_enabled_at = ? WHERE user_id = ? AND totp_secret_enc = ?", at.UTC(), userID, secretEnc)
+}
+
+func (r *UserRepo) ReencryptSecrets(ctx context.Context, userID, oldPassword, newPassword, oldTOTP, newTOTP string) error {
+    var set, where []string
+    var setArgs, whereArgs []any
+    if oldPassword != "" {
+        set, setArgs = append(set, "password_enc = ?"), append(setArgs, newPassword)
+        where, whereArgs = append(where, "password_enc = ?"), append(whereArgs, oldPassword)
+    }
+    if oldTOTP != "" {
+        set, setArgs = append(set, "totp_secret_enc = ?"), append(setArgs, newTOTP)
+        where, whereArgs = append(where, "totp_secret_enc = ?"), append(whereArgs, oldTOTP)
+    }
+    if len(set) == 0 {
+        _, err := r.GetByID(ctx, userID)
+        return err
+    }
+    query := "UPDATE users SET " + strings.Join(set, ", ") + " WHERE user_id = ? AND " + strings.Join(where, " AND ")
+    return r.c.execOne(ctx, query, append(append(setArgs, userID), whereArgs...)...)
+}
+
+func (r *UserRepo) Delete(ctx context.Context, userID string) error {
+    return r.c.execOne(ctx, "DELETE FROM users WHERE user_id = ?", userID)
+}
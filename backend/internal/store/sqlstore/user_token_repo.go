@@ -0,0 +1,62 @@
+package sqlstore
+
+import (
+    "context"
+    "time"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/models"
+)
+
+// UserTokenRepo stores single-use user tokens in the user_tokens table.
+type UserTokenRepo struct{ c *Client }
+
+func NewUserTokenRepo(c *Client) *UserTokenRepo { return &UserTokenRepo{c: c} }
+
+func (r *UserTokenRepo) Create(ctx context.Context, t *models.UserToken) error {
+    _, err := r.c.exec(ctx, "INSERT INTO user_tokens (token_hash, purpose, user_id, created_at, expires_at) VALUES ("+placeholders(5)+")",
+        t.TokenHash, t.Purpose, t.UserID, t.CreatedAt.UTC(), t.ExpiresAt.UTC())
+    return err
+}
+
+// ScanAll reads tokens in token_hash pages, calling fn between queries.
+func (r *UserTokenRepo) ScanAll(ctx context.Context, fn func(t models.UserToken) error) error {
+    return scanPages(func(after string) ([]models.UserToken, error) {
+        rows, err := r.c.query(ctx, "SELECT token_hash, purpose, user_id, created_at, expires_at FROM user_tokens WHERE token_hash > ? ORDER BY token_hash LIMIT ?", after, scanPageSize)
+        if err != nil { return nil, err }
+        defer rows.Close()
+        var out []models.UserToken
+        for rows.Next() {
+            var t models.UserToken
+            if err := rows.Scan(&t.TokenHash, &t.Purpose, &t.UserID, &t.CreatedAt, &t.ExpiresAt); err != nil { return nil, err }
+            t.CreatedAt, t.ExpiresAt = t.CreatedAt.UTC(), t.ExpiresAt.UTC()
+            out = append(out, t)
+        }
+        return out, rows.Err()
+    }, func(t models.UserToken) string { return t.TokenHash }, fn)
+}
+
+// Consume reads the token, then deletes it; only the caller whose delete
+// removes the row gets it back.
+func (r *UserTokenRepo) Consume(ctx context.Context, hash, purpose string) (*models.UserToken, error) {
+    var t models.UserToken
+    err := r.c.queryRow(ctx, "SELECT token_hash, purpose, user_id, created_at, expires_at FROM user_tokens WHERE token_hash = ? AND purpose = ?", hash, purpose).
+        Scan(&t.TokenHash, &t.Purpose, &t.UserID, &t.CreatedAt, &t.ExpiresAt)
+    if err := notFoundIfNoRow(err); err != nil { return nil, err }
+    if err := r.c.execOne(ctx, "DELETE FROM user_tokens WHERE token_hash = ? AND purpose = ?", hash, purpose); err != nil { return nil, err }
+    t.CreatedAt, t.ExpiresAt = t.CreatedAt.UTC(), t.ExpiresAt.UTC()
+    return &t, nil
+}
+
+func (r *UserTokenRepo) DeleteByUser(ctx context.Context, userID, purpose string) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM user_tokens WHERE user_id = ? AND purpose = ?", userID, purpose)
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+func (r *UserTokenRepo) DeleteExpired(ctx context.Context, cutoff time.Time) (int, error) {
+    res, err := r.c.exec(ctx, "DELETE FROM user_tokens WHERE expires_at < ?", cutoff.UTC())
+    if err != nil { return 0, err }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
@@ -0,0 +1,246 @@
+// Package stores opens the data store selected by DATA_STORE and returns its
+// repositories. It is shared by the server and the maintenance commands.
+package stores
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+
+    "github.com/janvillarosa/gracie-app/backend/internal/config"
+    "github.com/janvillarosa/gracie-app/backend/internal/parse"
+    "github.com/janvillarosa/gracie-app/backend/internal/services/categorization"
+    "github.com/janvillarosa/gracie-app/backend/internal/store"
+    dynamostore "github.com/janvillarosa/gracie-app/backend/internal/store/dynamo"
+    mongostore "github.com/janvillarosa/gracie-app/backend/internal/store/mongo"
+    "github.com/janvillarosa/gracie-app/backend/internal/store/sqlstore"
+)
+
+// Set bundles the repositories of one backend.
+type Set struct {
+    Users            store.UserRepository
+    Rooms            store.RoomRepository
+    Lists            store.ListRepository
+    Items            store.ListItemRepository
+    ItemScanner      store.ListItemScanner
+    UserScanner      store.UserScanner
+    RoomScanner      store.RoomScanner
+    ListScanner      store.ListScanner
+    Migrations       store.MigrationRepository
+    Jobs             store.JobRepository
+    Sessions         store.SessionRepository
+    UserTokens       store.UserTokenRepository
+    // UserTokenScanner and InviteScanner are UserTokens and Invites; they
+    // back store-wide exports.
+    UserTokenScanner store.UserTokenScanner
+    InviteScanner    store.InviteScanner
+    Counters         store.CounterRepository
+    Invites          store.InviteRepository
+    Tx               store.TxRunner
+    // CategoryIndex is nil when the backend has no category cache or it is disabled.
+    CategoryIndex    categorization.CategoryIndex
+    // CategoryScanner is set together with CategoryIndex.
+    CategoryScanner  store.CategoryIndexScanner
+    Close            func()
+}
+
+// Open connects to the backend named by cfg.DataStore. Index creation and SQL
+// schema migrations run here; failures are returned rather than ignored.
+func Open(ctx context.Context, cfg *config.Config) (*Set, error) {
+    switch cfg.DataStore {
+    case "mongo":
+        return openMongo(ctx, cfg)
+    case "dynamo":
+        return openDynamo(ctx, cfg)
+    case "sqlite":
+        if err := os.MkdirAll(filepath.Dir(cfg.SQLitePath), 0o755); err != nil {
+            return nil, fmt.Errorf("sqlite dir: %w", err)
+        }
+        return openSQL(ctx, cfg, sqlstore.DialectSQLite, cfg.SQLitePath)
+    case "postgres":
+        return openSQL(ctx, cfg, sqlstore.DialectPostgres, cfg.PostgresURL)
+    default:
+        return nil, fmt.Errorf("unknown DATA_STORE %q", cfg.DataStore)
+    }
+}
+
+// anchorSeed returns the grocery anchors as normalized category index entries.
+func anchorSeed() []struct{ Key, Category string } {
+    seed := make([]struct{ Key, Category string }, 0, len(categorization.GroceryAnchors))
+    for _, a := range categorization.GroceryAnchors {
+        seed = append(seed, struct{ Key, Category string }{Key: parse.NormalizeKey(a.Term), Category: a.Category})
+    }
+    return seed
+}
+
+func openMongo(ctx context.Context, cfg *config.Config) (*Set, error) {
+    mcli, err := mongostore.New(ctx, cfg.MongoURI, cfg.MongoDB)
+    if err != nil { return nil, fmt.Errorf("mongo connect: %w", err) }
+    usersRepo := mongostore.NewUserRepo(mcli)
+    roomsRepo := mongostore.NewRoomRepo(mcli)
+    listsRepo := mongostore.NewListRepo(mcli)
+    itemsRepo := mongostore.NewListItemRepo(mcli)
+    migrationsRepo := mongostore.NewMigrationRepo(mcli)
+    jobsRepo := mongostore.NewJobRepo(mcli)
+    sessionsRepo := mongostore.NewSessionRepo(mcli)
+    userTokensRepo := mongostore.NewUserTokenRepo(mcli)
+    countersRepo := mongostore.NewCounterRepo(mcli)
+    invitesRepo := mongostore.NewInviteRepo(mcli)
+    for _, ix := range []struct {
+        name   string
+        ensure func(context.Context) error
+    }{
+        {"users", usersRepo.EnsureIndexes},
+        {"rooms", roomsRepo.EnsureIndexes},
+        {"lists", listsRepo.EnsureIndexes},
+        {"list_items", itemsRepo.EnsureIndexes},
+        {"migrations", migrationsRepo.EnsureIndexes},
+        {"jobs", jobsRepo.EnsureIndexes},
+        {"sessions", sessionsRepo.EnsureIndexes},
+        {"user_tokens", userTokensRepo.EnsureIndexes},
+        {"counters", countersRepo.EnsureIndexes},
+        {"invites", invitesRepo.EnsureIndexes},
+    } {
+        if err := ix.ensure(ctx); err != nil {
+            _ = mcli.Close(context.Background())
+            return nil, fmt.Errorf("mongo %s indexes: %w", ix.name, err)
+        }
+    }
+    st := &Set{
+        Users:            usersRepo,
+        Rooms:            roomsRepo,
+        Lists:            listsRepo,
+        Items:            itemsRepo,
+        ItemScanner:      itemsRepo,
+        UserScanner:      usersRepo,
+        RoomScanner:      roomsRepo,
+        ListScanner:      listsRepo,
+        Migrations:       migrationsRepo,
+        Jobs:             jobsRepo,
+        Sessions:         sessionsRepo,
+        UserTokens:       userTokensRepo,
+        UserTokenScanner: userTokensRepo,
+        Counters:         countersRepo,
+        Invites:          invitesRepo,
+        InviteScanner:    invitesRepo,
+        Tx:               mongostore.NewTx(mcli),
+        Close:            func() { _ = mcli.Close(context.Background()) },
+    }
+
+    if cfg.CategoryIndexEnabled {
+        categoryIndex := mongostore.NewCategoryIndexRepo(mcli)
+        if err := categoryIndex.EnsureIndexes(ctx); err != nil {
+            log.Printf("category_index: ensure indexes failed: %v (continuing without cache)", err)
+            return st, nil
+        }
+        var seed []mongostore.CategoryIndexEntry
+        for _, e := range anchorSeed() {
+            seed = append(seed, mongostore.CategoryIndexEntry{Key: e.Key, Category: e.Category})
+        }
+        if err := categoryIndex.Seed(ctx, seed); err != nil {
+            log.Printf("category_index: anchor seed failed: %v (continuing)", err)
+        } else {
+            log.Printf("category_index: seeded %d anchors", len(seed))
+        }
+        st.CategoryIndex, st.CategoryScanner = categoryIndex, categoryIndex
+    }
+    return st, nil
+}
+
+// openDynamo expects the tables to exist already (see cmd/setup-ddb).
+func openDynamo(ctx context.Context, cfg *config.Config) (*Set, error) {
+    dcli, err := dynamostore.New(ctx, cfg.AWSRegion, cfg.DDBEndpoint, dynamostore.Tables{
+        Users:      cfg.UsersTable,
+        Rooms:      cfg.RoomsTable,
+        Lists:      cfg.ListsTable,
+        ListItems:  cfg.ListItemsTable,
+        Migrations: cfg.MigrationsTable,
+        Jobs:       cfg.JobsTable,
+        Sessions:   cfg.SessionsTable,
+        UserTokens: cfg.UserTokensTable,
+        Counters:   cfg.CountersTable,
+        Invites:    cfg.InvitesTable,
+    })
+    if err != nil { return nil, fmt.Errorf("dynamo client: %w", err) }
+    if cfg.CategoryIndexEnabled {
+        log.Printf("category_index: not available for dynamo (continuing without cache)")
+    }
+    usersRepo := dynamostore.NewUserRepo(dcli)
+    roomsRepo := dynamostore.NewRoomRepo(dcli)
+    listsRepo := dynamostore.NewListRepo(dcli)
+    itemsRepo := dynamostore.NewListItemRepo(dcli)
+    userTokensRepo := dynamostore.NewUserTokenRepo(dcli)
+    invitesRepo := dynamostore.NewInviteRepo(dcli)
+    return &Set{
+        Users:            usersRepo,
+        Rooms:            roomsRepo,
+        Lists:            listsRepo,
+        Items:            itemsRepo,
+        ItemScanner:      itemsRepo,
+        UserScanner:      usersRepo,
+        RoomScanner:      roomsRepo,
+        ListScanner:      listsRepo,
+        Migrations:       dynamostore.NewMigrationRepo(dcli),
+        Jobs:             dynamostore.NewJobRepo(dcli),
+        Sessions:         dynamostore.NewSessionRepo(dcli),
+        UserTokens:       userTokensRepo,
+        UserTokenScanner: userTokensRepo,
+        Counters:         dynamostore.NewCounterRepo(dcli),
+        Invites:          invitesRepo,
+        InviteScanner:    invitesRepo,
+        Tx:               dynamostore.NewTx(dcli),
+        Close:            func() {},
+    }, nil
+}
+
+// openSQL connects to SQLite or Postgres and applies pending schema migrations.
+func openSQL(ctx context.Context, cfg *config.Config, dialect, dsn string) (*Set, error) {
+    cli, err := sqlstore.Open(ctx, dialect, dsn)
+    if err != nil { return nil, fmt.Errorf("%s open: %w", dialect, err) }
+    if err := cli.Migrate(ctx); err != nil {
+        _ = cli.Close()
+        return nil, fmt.Errorf("%s migrate: %w", dialect, err)
+    }
+    usersRepo := sqlstore.NewUserRepo(cli)
+    roomsRepo := sqlstore.NewRoomRepo(cli)
+    listsRepo := sqlstore.NewListRepo(cli)
+    itemsRepo := sqlstore.NewListItemRepo(cli)
+    userTokensRepo := sqlstore.NewUserTokenRepo(cli)
+    invitesRepo := sqlstore.NewInviteRepo(cli)
+    st := &Set{
+        Users:            usersRepo,
+        Rooms:            roomsRepo,
+        Lists:            listsRepo,
+        Items:            itemsRepo,
+        ItemScanner:      itemsRepo,
+        UserScanner:      usersRepo,
+        RoomScanner:      roomsRepo,
+        ListScanner:      listsRepo,
+        Migrations:       sqlstore.NewMigrationRepo(cli),
+        Jobs:             sqlstore.NewJobRepo(cli),
+        Sessions:         sqlstore.NewSessionRepo(cli),
+        UserTokens:       userTokensRepo,
+        UserTokenScanner: userTokensRepo,
+        Counters:         sqlstore.NewCounterRepo(cli),
+        Invites:          invitesRepo,
+        InviteScanner:    invitesRepo,
+        Tx:               sqlstore.NewTx(cli),
+        Close:            func() { _ = cli.Close() },
+    }
+    if cfg.CategoryIndexEnabled {
+        categoryIndex := sqlstore.NewCategoryIndexRepo(cli)
+        var seed []sqlstore.CategoryIndexEntry
+        for _, e := range anchorSeed() {
+            seed = append(seed, sqlstore.CategoryIndexEntry{Key: e.Key, Category: e.Category})
+        }
+        if err := categoryIndex.Seed(ctx, seed); err != nil {
+            log.Printf("category_index: anchor seed failed: %v (continuing)", err)
+        } else {
+            log.Printf("category_index: seeded %d anchors", len(seed))
+        }
+        st.CategoryIndex, st.CategoryScanner = categoryIndex, categoryIndex
+    }
+    return st, nil
+}
@@ -0,0 +1,1429 @@
+// Package storetest is a conformance suite for store.*Repository
+// implementations. Each backend runs it from its own tests with a Factory that
+// returns fresh, empty repositories:
+//
+//	storetest.Run(t, func(t *testing.T) storetest.Repos { ... })
+//
+// The suite pins down the behaviour services rely on: ordering of list reads,
+// derr.ErrNotFound for missing records, soft-delete visibility of lists,
+// archive semantics of items, trash and restore, cursor pagination, version
+// checks on updates, store-wide scans, the data migration log, job leasing,
+// sessions, user tokens, counters and invites.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	derr "github.com/janvillarosa/gracie-app/backend/internal/errors"
+	"github.com/janvillarosa/gracie-app/backend/internal/models"
+	"github.com/janvillarosa/gracie-app/backend/internal/store"
+)
+
+// Repos is the set of repositories under test.
+type Repos struct {
+	Tx    store.TxRunner
+	Users store.UserRepository
+	Rooms store.RoomRepository
+	Lists store.ListRepository
+	Items store.ListItemRepository
+	// Migrations, Jobs, Sessions, UserTokens, Counters and Invites are
+	// optional; their groups are skipped when nil.
+	Migrations store.MigrationRepository
+	Jobs       store.JobRepository
+	Sessions   store.SessionRepository
+	UserTokens store.UserTokenRepository
+	Counters   store.CounterRepository
+	Invites    store.InviteRepository
+}
+
+// Factory returns empty repositories backed by an isolated store. It should
+// skip the test when the backend is unavailable and register cleanup with t.
+type Factory func(t *testing.T) Repos
+
+// Run executes the whole suite against the backend built by newRepos.
+func Run(t *testing.T, newRepos Factory) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, newRepos(t)) })
+	t.Run("Rooms", func(t *testing.T) { testRooms(t, newRepos(t)) })
+	t.Run("Lists", func(t *testing.T) { testLists(t, newRepos(t)) })
+	t.Run("Items", func(t *testing.T) { testItems(t, newRepos(t)) })
+	t.Run("Trash", func(t *testing.T) { testTrash(t, newRepos(t)) })
+	t.Run("Move", func(t *testing.T) { testMove(t, newRepos(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newRepos(t)) })
+	t.Run("Versions", func(t *testing.T) { testVersions(t, newRepos(t)) })
+	t.Run("Patch", func(t *testing.T) { testPatch(t, newRepos(t)) })
+	t.Run("Scan", func(t *testing.T) { testScan(t, newRepos(t)) })
+	t.Run("Migrations", func(t *testing.T) {
+		r := newRepos(t)
+		if r.Migrations == nil {
+			t.Skip("no migration repository")
+		}
+		testMigrations(t, r.Migrations)
+	})
+	t.Run("Jobs", func(t *testing.T) {
+		r := newRepos(t)
+		if r.Jobs == nil {
+			t.Skip("no job repository")
+		}
+		testJobs(t, r.Jobs)
+	})
+	t.Run("Sessions", func(t *testing.T) {
+		r := newRepos(t)
+		if r.Sessions == nil {
+			t.Skip("no session repository")
+		}
+		testSessions(t, r.Sessions)
+	})
+	t.Run("UserTokens", func(t *testing.T) {
+		r := newRepos(t)
+		if r.UserTokens == nil {
+			t.Skip("no user token repository")
+		}
+		testUserTokens(t, r.UserTokens)
+	})
+	t.Run("Counters", func(t *testing.T) {
+		r := newRepos(t)
+		if r.Counters == nil {
+			t.Skip("no counter repository")
+		}
+		testCounters(t, r.Counters)
+	})
+	t.Run("Invites", func(t *testing.T) {
+		r := newRepos(t)
+		if r.Invites == nil {
+			t.Skip("no invite repository")
+		}
+		testInvites(t, r.Tx, r.Invites)
+	})
+}
+
+// base is a fixed, second-aligned instant. Some backends persist update
+// timestamps with second precision, so the suite only compares whole seconds.
+var base = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+func wantErr(t *testing.T, what string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: got error %v, want %v", what, err, want)
+	}
+}
+
+func must(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+func testUsers(t *testing.T, r Repos) {
+	ctx := context.Background()
+	users := r.Users
+
+	_, err := users.GetByID(ctx, "usr_missing")
+	wantErr(t, "GetByID missing", err, derr.ErrNotFound)
+	_, err = users.GetByUsername(ctx, "missing@example.com")
+	wantErr(t, "GetByUsername missing", err, derr.ErrNotFound)
+	_, err = users.GetByAPIKeyLookup(ctx, "lookup_missing")
+	wantErr(t, "GetByAPIKeyLookup missing", err, derr.ErrUnauthorized)
+
+	u := &models.User{UserID: "usr_st_1", Name: "Alice", Username: "alice@example.com", APIKeyHash: "h1", APIKeyLookup: "lk_st_1", CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put", users.Put(ctx, u))
+	if err := users.Put(ctx, u); err == nil {
+		t.Fatalf("Put duplicate: expected error")
+	}
+
+	got, err := users.GetByUsername(ctx, "alice@example.com")
+	must(t, "GetByUsername", err)
+	if got.UserID != u.UserID || got.Name != "Alice" {
+		t.Fatalf("GetByUsername: unexpected user %+v", got)
+	}
+
+	exp := at(60)
+	must(t, "SetAPIKey", users.SetAPIKey(ctx, u.UserID, "h2", "lk_st_2", &exp, at(1)))
+	got, err = users.GetByAPIKeyLookup(ctx, "lk_st_2")
+	must(t, "GetByAPIKeyLookup", err)
+	if got.APIKeyHash != "h2" || got.APIKeyExpiresAt == nil || !got.APIKeyExpiresAt.Equal(exp) {
+		t.Fatalf("SetAPIKey: unexpected user %+v", got)
+	}
+	wantErr(t, "ClearAPIKey stale lookup", users.ClearAPIKey(ctx, u.UserID, "lk_st_1"), derr.ErrNotFound)
+	must(t, "ClearAPIKey", users.ClearAPIKey(ctx, u.UserID, "lk_st_2"))
+	if _, err := users.GetByAPIKeyLookup(ctx, "lk_st_2"); err == nil {
+		t.Fatalf("GetByAPIKeyLookup: cleared key still found")
+	}
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.APIKeyHash != "" || got.APIKeyLookup != "" || got.APIKeyExpiresAt != nil {
+		t.Fatalf("ClearAPIKey: key left on user %+v", got)
+	}
+	wantErr(t, "ClearAPIKey cleared", users.ClearAPIKey(ctx, u.UserID, "lk_st_2"), derr.ErrNotFound)
+	must(t, "SetAPIKey", users.SetAPIKey(ctx, u.UserID, "h2", "lk_st_2", &exp, at(1)))
+
+	wantErr(t, "MarkEmailVerified other username", users.MarkEmailVerified(ctx, u.UserID, "other@example.com", at(1)), derr.ErrNotFound)
+	must(t, "MarkEmailVerified", users.MarkEmailVerified(ctx, u.UserID, "alice@example.com", at(1)))
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.EmailVerifiedAt == nil || !got.EmailVerifiedAt.Equal(at(1)) {
+		t.Fatalf("MarkEmailVerified: unexpected user %+v", got)
+	}
+
+	must(t, "SetTOTPSecret", users.SetTOTPSecret(ctx, u.UserID, "secret-1", at(1)))
+	wantErr(t, "EnableTOTP other secret", users.EnableTOTP(ctx, u.UserID, "secret-0", at(1)), derr.ErrNotFound)
+	must(t, "EnableTOTP", users.EnableTOTP(ctx, u.UserID, "secret-1", at(1)))
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.TOTPSecretEnc != "secret-1" || got.TOTPEnabledAt == nil || !got.TOTPEnabledAt.Equal(at(1)) {
+		t.Fatalf("EnableTOTP: unexpected user %+v", got)
+	}
+	before, err := users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	wantErr(t, "ReencryptSecrets stale", users.ReencryptSecrets(ctx, u.UserID, "", "", "secret-0", "k1:secret"), derr.ErrNotFound)
+	must(t, "ReencryptSecrets", users.ReencryptSecrets(ctx, u.UserID, "", "", "secret-1", "k1:secret-1"))
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.TOTPSecretEnc != "k1:secret-1" || got.PasswordEnc != before.PasswordEnc || !got.UpdatedAt.Equal(before.UpdatedAt) || got.TOTPEnabledAt == nil {
+		t.Fatalf("ReencryptSecrets: unexpected user %+v", got)
+	}
+	wantErr(t, "ReencryptSecrets missing user", users.ReencryptSecrets(ctx, "usr_missing", "", "", "k1:secret-1", "x"), derr.ErrNotFound)
+	must(t, "SetTOTPSecret clear", users.SetTOTPSecret(ctx, u.UserID, "", at(1)))
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.TOTPSecretEnc != "" || got.TOTPEnabledAt != nil {
+		t.Fatalf("SetTOTPSecret clear: unexpected user %+v", got)
+	}
+
+	must(t, "UpdateName", users.UpdateName(ctx, u.UserID, "Alicia", at(2)))
+	must(t, "UpdateUsername", users.UpdateUsername(ctx, u.UserID, "alicia@example.com", at(3)))
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.EmailVerifiedAt != nil {
+		t.Fatalf("UpdateUsername: verification kept for the new address: %+v", got)
+	}
+	must(t, "UpdatePasswordEnc", users.UpdatePasswordEnc(ctx, u.UserID, "enc", at(4)))
+	wantErr(t, "ReencryptSecrets stale password", users.ReencryptSecrets(ctx, u.UserID, "other", "k1:enc", "", ""), derr.ErrNotFound)
+	must(t, "ReencryptSecrets password", users.ReencryptSecrets(ctx, u.UserID, "enc", "k1:enc", "", ""))
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.PasswordEnc != "k1:enc" || !got.UpdatedAt.Equal(at(4)) {
+		t.Fatalf("ReencryptSecrets password: unexpected user %+v", got)
+	}
+	room := "room_st_1"
+	must(t, "SetRoomID", users.SetRoomID(ctx, u.UserID, &room, at(5)))
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if got.Name != "Alicia" || got.Username != "alicia@example.com" || got.PasswordEnc != "k1:enc" || got.RoomID == nil || *got.RoomID != room {
+		t.Fatalf("updates not applied: %+v", got)
+	}
+	if !got.UpdatedAt.Equal(at(5)) {
+		t.Fatalf("UpdatedAt: got %v, want %v", got.UpdatedAt, at(5))
+	}
+	must(t, "SetRoomID nil", users.SetRoomID(ctx, u.UserID, nil, at(6)))
+	got, _ = users.GetByID(ctx, u.UserID)
+	if got.RoomID != nil {
+		t.Fatalf("SetRoomID nil: room still set: %v", *got.RoomID)
+	}
+	must(t, "AddRoom", users.AddRoom(ctx, u.UserID, "room_st_1", at(6)))
+	must(t, "AddRoom second", users.AddRoom(ctx, u.UserID, "room_st_2", at(6)))
+	wantErr(t, "AddRoom again", users.AddRoom(ctx, u.UserID, "room_st_1", at(6)), derr.ErrConflict)
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if !slices.Equal(got.RoomIDs, []string{"room_st_1", "room_st_2"}) {
+		t.Fatalf("AddRoom: got room IDs %v", got.RoomIDs)
+	}
+	must(t, "RemoveRoom", users.RemoveRoom(ctx, u.UserID, "room_st_1", at(6)))
+	wantErr(t, "RemoveRoom again", users.RemoveRoom(ctx, u.UserID, "room_st_1", at(6)), derr.ErrNotFound)
+	got, err = users.GetByID(ctx, u.UserID)
+	must(t, "GetByID", err)
+	if !slices.Equal(got.RoomIDs, []string{"room_st_2"}) {
+		t.Fatalf("RemoveRoom: got room IDs %v", got.RoomIDs)
+	}
+
+	wantErr(t, "UpdateName missing", users.UpdateName(ctx, "usr_missing", "x", at(7)), derr.ErrNotFound)
+	wantErr(t, "UpdateUsername missing", users.UpdateUsername(ctx, "usr_missing", "x@example.com", at(7)), derr.ErrNotFound)
+	wantErr(t, "UpdatePasswordEnc missing", users.UpdatePasswordEnc(ctx, "usr_missing", "x", at(7)), derr.ErrNotFound)
+	wantErr(t, "SetAPIKey missing", users.SetAPIKey(ctx, "usr_missing", "h", "lk_x", nil, at(7)), derr.ErrNotFound)
+	wantErr(t, "SetRoomID missing", users.SetRoomID(ctx, "usr_missing", &room, at(7)), derr.ErrNotFound)
+	wantErr(t, "AddRoom missing", users.AddRoom(ctx, "usr_missing", "room_st_1", at(7)), derr.ErrNotFound)
+	wantErr(t, "RemoveRoom missing", users.RemoveRoom(ctx, "usr_missing", "room_st_1", at(7)), derr.ErrNotFound)
+	wantErr(t, "MarkEmailVerified missing", users.MarkEmailVerified(ctx, "usr_missing", "x@example.com", at(7)), derr.ErrNotFound)
+	wantErr(t, "SetTOTPSecret missing", users.SetTOTPSecret(ctx, "usr_missing", "s", at(7)), derr.ErrNotFound)
+
+	must(t, "Delete", users.Delete(ctx, u.UserID))
+	_, err = users.GetByID(ctx, u.UserID)
+	wantErr(t, "GetByID deleted", err, derr.ErrNotFound)
+	wantErr(t, "Delete missing", users.Delete(ctx, u.UserID), derr.ErrNotFound)
+}
+
+func testRooms(t *testing.T, r Repos) {
+	ctx := context.Background()
+	rooms := r.Rooms
+
+	_, err := rooms.GetByID(ctx, "room_missing")
+	wantErr(t, "GetByID missing", err, derr.ErrNotFound)
+	_, err = rooms.GetByShareToken(ctx, "NOPE1")
+	wantErr(t, "GetByShareToken missing", err, derr.ErrNotFound)
+
+	// DeletionVotes deliberately nil: backends must still accept votes.
+	rm := &models.Room{RoomID: "room_st_1", MemberIDs: []string{"usr_b"}, DisplayName: "Home", CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put", rooms.Put(ctx, rm))
+	if err := rooms.Put(ctx, rm); err == nil {
+		t.Fatalf("Put duplicate: expected error")
+	}
+
+	// Members keep insertion order.
+	must(t, "AddMember", rooms.AddMember(ctx, rm.RoomID, "usr_a", at(1)))
+	wantErr(t, "AddMember duplicate", rooms.AddMember(ctx, rm.RoomID, "usr_a", at(1)), derr.ErrConflict)
+	wantErr(t, "AddMember missing room", rooms.AddMember(ctx, "room_missing", "usr_a", at(1)), derr.ErrNotFound)
+	got, err := rooms.GetByID(ctx, rm.RoomID)
+	must(t, "GetByID", err)
+	if len(got.MemberIDs) != 2 || got.MemberIDs[0] != "usr_b" || got.MemberIDs[1] != "usr_a" {
+		t.Fatalf("AddMember: unexpected members %v", got.MemberIDs)
+	}
+
+	must(t, "SetShareToken", rooms.SetShareToken(ctx, rm.RoomID, "usr_a", "ABCDE", at(2)))
+	got, err = rooms.GetByShareToken(ctx, "ABCDE")
+	must(t, "GetByShareToken", err)
+	if got.RoomID != rm.RoomID {
+		t.Fatalf("GetByShareToken: got room %s", got.RoomID)
+	}
+	must(t, "RemoveShareToken", rooms.RemoveShareToken(ctx, rm.RoomID, at(3)))
+	_, err = rooms.GetByShareToken(ctx, "ABCDE")
+	wantErr(t, "GetByShareToken removed", err, derr.ErrNotFound)
+
+	must(t, "UpdateDisplayName", rooms.UpdateDisplayName(ctx, rm.RoomID, "usr_a", "Flat", store.AnyVersion, at(4)))
+	must(t, "UpdateDescription", rooms.UpdateDescription(ctx, rm.RoomID, "usr_a", "Our place", store.AnyVersion, at(5)))
+	got, _ = rooms.GetByID(ctx, rm.RoomID)
+	if got.DisplayName != "Flat" || got.Description != "Our place" || got.ShareToken != nil {
+		t.Fatalf("settings not applied: %+v", got)
+	}
+	must(t, "UpdateDescription clear", rooms.UpdateDescription(ctx, rm.RoomID, "usr_a", "", store.AnyVersion, at(6)))
+	got, _ = rooms.GetByID(ctx, rm.RoomID)
+	if got.Description != "" {
+		t.Fatalf("UpdateDescription clear: got %q", got.Description)
+	}
+
+	must(t, "VoteDeletion", rooms.VoteDeletion(ctx, rm.RoomID, "usr_a", at(7)))
+	must(t, "VoteDeletion", rooms.VoteDeletion(ctx, rm.RoomID, "usr_b", at(7)))
+	must(t, "RemoveDeletionVote", rooms.RemoveDeletionVote(ctx, rm.RoomID, "usr_b"))
+	must(t, "RemoveDeletionVote absent", rooms.RemoveDeletionVote(ctx, rm.RoomID, "usr_b"))
+	got, _ = rooms.GetByID(ctx, rm.RoomID)
+	if len(got.DeletionVotes) != 1 || got.DeletionVotes["usr_a"] == "" {
+		t.Fatalf("deletion votes: %v", got.DeletionVotes)
+	}
+
+	// Roles deliberately nil at Put, like rooms stored before roles.
+	must(t, "SetRoles", rooms.SetRoles(ctx, rm.RoomID, map[string]models.Role{"usr_a": models.RoleAdmin, "usr_b": models.RoleGuest}, at(8)))
+	must(t, "SetRoles again", rooms.SetRoles(ctx, rm.RoomID, map[string]models.Role{"usr_b": models.RoleMember}, at(8)))
+	wantErr(t, "SetRoles non-member", rooms.SetRoles(ctx, rm.RoomID, map[string]models.Role{"usr_z": models.RoleAdmin}, at(8)), derr.ErrNotFound)
+	wantErr(t, "SetRoles missing room", rooms.SetRoles(ctx, "room_missing", map[string]models.Role{"usr_a": models.RoleAdmin}, at(8)), derr.ErrNotFound)
+	got, _ = rooms.GetByID(ctx, rm.RoomID)
+	if len(got.Roles) != 2 || got.Roles["usr_a"] != models.RoleAdmin || got.Roles["usr_b"] != models.RoleMember {
+		t.Fatalf("SetRoles: unexpected roles %v", got.Roles)
+	}
+
+	must(t, "RemoveMember", rooms.RemoveMember(ctx, rm.RoomID, "usr_b", at(8)))
+	wantErr(t, "RemoveMember non-member", rooms.RemoveMember(ctx, rm.RoomID, "usr_b", at(8)), derr.ErrNotFound)
+	got, _ = rooms.GetByID(ctx, rm.RoomID)
+	if len(got.MemberIDs) != 1 || got.MemberIDs[0] != "usr_a" {
+		t.Fatalf("RemoveMember: unexpected members %v", got.MemberIDs)
+	}
+	if len(got.Roles) != 1 || got.Roles["usr_a"] != models.RoleAdmin {
+		t.Fatalf("RemoveMember: role left behind %v", got.Roles)
+	}
+
+	wantErr(t, "SetShareToken missing", rooms.SetShareToken(ctx, "room_missing", "usr_a", "ZZZZZ", at(9)), derr.ErrNotFound)
+	wantErr(t, "UpdateDisplayName missing", rooms.UpdateDisplayName(ctx, "room_missing", "usr_a", "x", store.AnyVersion, at(9)), derr.ErrNotFound)
+	wantErr(t, "VoteDeletion missing", rooms.VoteDeletion(ctx, "room_missing", "usr_a", at(9)), derr.ErrNotFound)
+	wantErr(t, "RemoveDeletionVote missing", rooms.RemoveDeletionVote(ctx, "room_missing", "usr_a"), derr.ErrNotFound)
+
+	must(t, "Delete", rooms.Delete(ctx, rm.RoomID))
+	_, err = rooms.GetByID(ctx, rm.RoomID)
+	wantErr(t, "GetByID deleted", err, derr.ErrNotFound)
+	wantErr(t, "Delete missing", rooms.Delete(ctx, rm.RoomID), derr.ErrNotFound)
+
+	// Roles given at Put survive the round trip and take later changes.
+	owned := &models.Room{RoomID: "room_st_2", MemberIDs: []string{"usr_c", "usr_d"}, Roles: map[string]models.Role{"usr_c": models.RoleOwner},
+		CreatedAt: at(10), UpdatedAt: at(10)}
+	must(t, "Put with roles", rooms.Put(ctx, owned))
+	must(t, "SetRoles transfer", rooms.SetRoles(ctx, owned.RoomID, map[string]models.Role{"usr_c": models.RoleAdmin, "usr_d": models.RoleOwner}, at(11)))
+	got, err = rooms.GetByID(ctx, owned.RoomID)
+	must(t, "GetByID", err)
+	if got.Roles["usr_c"] != models.RoleAdmin || got.Roles["usr_d"] != models.RoleOwner {
+		t.Fatalf("SetRoles transfer: unexpected roles %v", got.Roles)
+	}
+}
+
+func testLists(t *testing.T, r Repos) {
+	ctx := context.Background()
+	lists := r.Lists
+
+	_, err := lists.GetByID(ctx, "list_missing")
+	wantErr(t, "GetByID missing", err, derr.ErrNotFound)
+	got, _, err := lists.ListByRoom(ctx, "room_empty", store.Page{})
+	must(t, "ListByRoom empty", err)
+	if len(got) != 0 {
+		t.Fatalf("ListByRoom empty: got %d lists", len(got))
+	}
+
+	// Inserted out of creation order; ListByRoom returns oldest first.
+	for _, l := range []*models.List{
+		{ListID: "list_st_b", RoomID: "room_st", Name: "B", CreatedAt: at(2), UpdatedAt: at(2)},
+		{ListID: "list_st_a", RoomID: "room_st", Name: "A", CreatedAt: at(1), UpdatedAt: at(1)},
+		{ListID: "list_st_c", RoomID: "room_st", Name: "C", CreatedAt: at(3), UpdatedAt: at(3)},
+		{ListID: "list_st_x", RoomID: "room_other", Name: "X", CreatedAt: at(0), UpdatedAt: at(0)},
+	} {
+		must(t, "Put "+l.ListID, lists.Put(ctx, l))
+	}
+	if err := lists.Put(ctx, &models.List{ListID: "list_st_a", RoomID: "room_st", Name: "dup", CreatedAt: at(0), UpdatedAt: at(0)}); err == nil {
+		t.Fatalf("Put duplicate: expected error")
+	}
+	assertListIDs(t, lists, "room_st", "list_st_a", "list_st_b", "list_st_c")
+
+	must(t, "UpdateName", lists.UpdateName(ctx, "list_st_a", "Groceries", store.AnyVersion, at(4)))
+	must(t, "UpdateDescription", lists.UpdateDescription(ctx, "list_st_a", "weekly", store.AnyVersion, at(4)))
+	must(t, "UpdateNotes", lists.UpdateNotes(ctx, "list_st_a", "no nuts", store.AnyVersion, at(4)))
+	must(t, "UpdateIcon", lists.UpdateIcon(ctx, "list_st_a", "CART", store.AnyVersion, at(4)))
+	l, err := lists.GetByID(ctx, "list_st_a")
+	must(t, "GetByID", err)
+	if l.Name != "Groceries" || l.Description != "weekly" || l.Notes != "no nuts" || l.Icon != "CART" || !l.UpdatedAt.Equal(at(4)) {
+		t.Fatalf("updates not applied: %+v", l)
+	}
+	must(t, "UpdateDescription clear", lists.UpdateDescription(ctx, "list_st_a", "", store.AnyVersion, at(5)))
+	l, _ = lists.GetByID(ctx, "list_st_a")
+	if l.Description != "" {
+		t.Fatalf("UpdateDescription clear: got %q", l.Description)
+	}
+	wantErr(t, "UpdateName missing", lists.UpdateName(ctx, "list_missing", "x", store.AnyVersion, at(5)), derr.ErrNotFound)
+	wantErr(t, "UpdateIcon missing", lists.UpdateIcon(ctx, "list_missing", "CART", store.AnyVersion, at(5)), derr.ErrNotFound)
+
+	// Soft delete: finalize only once every member voted.
+	members := []string{"usr_a", "usr_b"}
+	must(t, "AddDeletionVote", lists.AddDeletionVote(ctx, "list_st_b", "usr_a", at(6)))
+	ok, err := lists.FinalizeDeleteIfVotedByAll(ctx, "list_st_b", members, at(6))
+	must(t, "Finalize partial", err)
+	if ok {
+		t.Fatalf("Finalize partial: deleted with one of two votes")
+	}
+	must(t, "AddDeletionVote", lists.AddDeletionVote(ctx, "list_st_b", "usr_b", at(7)))
+	must(t, "RemoveDeletionVote", lists.RemoveDeletionVote(ctx, "list_st_b", "usr_b"))
+	l, _ = lists.GetByID(ctx, "list_st_b")
+	if len(l.DeletionVotes) != 1 || l.DeletionVotes["usr_a"] == "" {
+		t.Fatalf("deletion votes: %v", l.DeletionVotes)
+	}
+	must(t, "AddDeletionVote", lists.AddDeletionVote(ctx, "list_st_b", "usr_b", at(8)))
+	ok, err = lists.FinalizeDeleteIfVotedByAll(ctx, "list_st_b", members, at(8))
+	must(t, "Finalize", err)
+	if !ok {
+		t.Fatalf("Finalize: not deleted after all votes")
+	}
+	ok, err = lists.FinalizeDeleteIfVotedByAll(ctx, "list_st_b", members, at(9))
+	must(t, "Finalize again", err)
+	if ok {
+		t.Fatalf("Finalize again: reported a second deletion")
+	}
+	ok, err = lists.FinalizeDeleteIfVotedByAll(ctx, "list_missing", members, at(9))
+	if err != nil || ok {
+		t.Fatalf("Finalize missing: got %v, %v; want false, nil", ok, err)
+	}
+
+	// Soft-deleted lists stay readable by ID but leave ListByRoom and reject updates.
+	l, err = lists.GetByID(ctx, "list_st_b")
+	must(t, "GetByID soft-deleted", err)
+	if !l.IsDeleted {
+		t.Fatalf("GetByID soft-deleted: IsDeleted = false")
+	}
+	assertListIDs(t, lists, "room_st", "list_st_a", "list_st_c")
+	wantErr(t, "UpdateName soft-deleted", lists.UpdateName(ctx, "list_st_b", "x", store.AnyVersion, at(10)), derr.ErrNotFound)
+	wantErr(t, "AddDeletionVote soft-deleted", lists.AddDeletionVote(ctx, "list_st_b", "usr_c", at(10)), derr.ErrNotFound)
+
+	// Delete is a hard delete.
+	must(t, "Delete", lists.Delete(ctx, "list_st_b"))
+	_, err = lists.GetByID(ctx, "list_st_b")
+	wantErr(t, "GetByID deleted", err, derr.ErrNotFound)
+	wantErr(t, "Delete missing", lists.Delete(ctx, "list_st_b"), derr.ErrNotFound)
+
+	// DeleteByRoom removes live and soft-deleted lists of one room only.
+	must(t, "Put soft-deleted", lists.Put(ctx, &models.List{ListID: "list_st_d", RoomID: "room_st", Name: "D", IsDeleted: true, CreatedAt: at(11), UpdatedAt: at(11)}))
+	n, err := lists.DeleteByRoom(ctx, "room_st")
+	must(t, "DeleteByRoom", err)
+	if n != 3 {
+		t.Fatalf("DeleteByRoom: removed %d lists, want 3", n)
+	}
+	_, err = lists.GetByID(ctx, "list_st_d")
+	wantErr(t, "GetByID after DeleteByRoom", err, derr.ErrNotFound)
+	assertListIDs(t, lists, "room_other", "list_st_x")
+	n, err = lists.DeleteByRoom(ctx, "room_st")
+	if err != nil || n != 0 {
+		t.Fatalf("DeleteByRoom again: got %d, %v; want 0, nil", n, err)
+	}
+}
+
+func assertListIDs(t *testing.T, lists store.ListRepository, roomID string, want ...string) {
+	t.Helper()
+	got, _, err := lists.ListByRoom(context.Background(), roomID, store.Page{})
+	must(t, "ListByRoom", err)
+	if len(got) != len(want) {
+		t.Fatalf("ListByRoom(%s): got %d lists, want %v", roomID, len(got), want)
+	}
+	for i := range want {
+		if got[i].ListID != want[i] {
+			t.Fatalf("ListByRoom(%s)[%d]: got %s, want %s", roomID, i, got[i].ListID, want[i])
+		}
+	}
+}
+
+func testItems(t *testing.T, r Repos) {
+	ctx := context.Background()
+	items := r.Items
+
+	_, err := items.GetByID(ctx, "item_missing")
+	wantErr(t, "GetByID missing", err, derr.ErrNotFound)
+
+	// Order ascending, ties broken by creation time.
+	for _, it := range []*models.ListItem{
+		{ItemID: "it_st_3", ListID: "list_st", RoomID: "room_st", Order: 2, Description: "three", CreatedAt: at(1), UpdatedAt: at(1)},
+		{ItemID: "it_st_2", ListID: "list_st", RoomID: "room_st", Order: 1, Description: "two", CreatedAt: at(3), UpdatedAt: at(3)},
+		{ItemID: "it_st_1", ListID: "list_st", RoomID: "room_st", Order: 1, Description: "one", CreatedAt: at(2), UpdatedAt: at(2)},
+		{ItemID: "it_st_4", ListID: "list_st", RoomID: "room_st", Order: 3, Description: "four", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ItemID: "it_st_x", ListID: "list_other", RoomID: "room_st", Order: 1, Description: "other", CreatedAt: at(0), UpdatedAt: at(0)},
+	} {
+		must(t, "Put "+it.ItemID, items.Put(ctx, it))
+	}
+	if err := items.Put(ctx, &models.ListItem{ItemID: "it_st_1", ListID: "list_st", RoomID: "room_st", CreatedAt: at(0), UpdatedAt: at(0)}); err == nil {
+		t.Fatalf("Put duplicate: expected error")
+	}
+	assertItemIDs(t, items, "list_st", "it_st_1", "it_st_2", "it_st_3", "it_st_4")
+
+	must(t, "UpdateOrder", items.UpdateOrder(ctx, "it_st_4", 0.5, store.AnyVersion, at(4)))
+	assertItemIDs(t, items, "list_st", "it_st_4", "it_st_1", "it_st_2", "it_st_3")
+
+	must(t, "UpdateDescription", items.UpdateDescription(ctx, "it_st_1", "milk", store.AnyVersion, at(5)))
+	must(t, "UpdateQuantity", items.UpdateQuantity(ctx, "it_st_1", "2", store.AnyVersion, at(5)))
+	must(t, "UpdateUnit", items.UpdateUnit(ctx, "it_st_1", "l", store.AnyVersion, at(5)))
+	must(t, "UpdateCategory", items.UpdateCategory(ctx, "it_st_1", "Dairy", store.AnyVersion, at(5)))
+	must(t, "UpdateStarred", items.UpdateStarred(ctx, "it_st_1", true, store.AnyVersion, at(5)))
+	it, err := items.GetByID(ctx, "it_st_1")
+	must(t, "GetByID", err)
+	if it.Description != "milk" || it.Quantity != "2" || it.Unit != "l" || it.Category != "Dairy" || !it.IsStarred || !it.UpdatedAt.Equal(at(5)) {
+		t.Fatalf("updates not applied: %+v", it)
+	}
+	for name, err := range map[string]error{
+		"UpdateCompletion":  items.UpdateCompletion(ctx, "item_missing", true, store.AnyVersion, at(5)),
+		"UpdateDescription": items.UpdateDescription(ctx, "item_missing", "x", store.AnyVersion, at(5)),
+		"UpdateQuantity":    items.UpdateQuantity(ctx, "item_missing", "1", store.AnyVersion, at(5)),
+		"UpdateUnit":        items.UpdateUnit(ctx, "item_missing", "kg", store.AnyVersion, at(5)),
+		"UpdateCategory":    items.UpdateCategory(ctx, "item_missing", "x", store.AnyVersion, at(5)),
+		"UpdateStarred":     items.UpdateStarred(ctx, "item_missing", true, store.AnyVersion, at(5)),
+		"UpdateOrder":       items.UpdateOrder(ctx, "item_missing", 1, store.AnyVersion, at(5)),
+	} {
+		wantErr(t, name+" missing", err, derr.ErrNotFound)
+	}
+
+	// Archive moves completed, not yet archived items of one list only.
+	must(t, "UpdateCompletion", items.UpdateCompletion(ctx, "it_st_1", true, store.AnyVersion, at(6)))
+	must(t, "UpdateCompletion", items.UpdateCompletion(ctx, "it_st_3", true, store.AnyVersion, at(6)))
+	must(t, "UpdateCompletion", items.UpdateCompletion(ctx, "it_st_x", true, store.AnyVersion, at(6)))
+	must(t, "ArchiveCompletedByList", items.ArchiveCompletedByList(ctx, "list_st", at(7)))
+	for id, want := range map[string]bool{"it_st_1": true, "it_st_3": true, "it_st_2": false, "it_st_4": false, "it_st_x": false} {
+		it, err := items.GetByID(ctx, id)
+		must(t, "GetByID "+id, err)
+		if it.IsArchived != want {
+			t.Fatalf("%s: IsArchived = %v, want %v", id, it.IsArchived, want)
+		}
+		if want && !it.UpdatedAt.Equal(at(7)) {
+			t.Fatalf("%s: UpdatedAt = %v, want %v", id, it.UpdatedAt, at(7))
+		}
+	}
+	// Already archived items are left alone on a second pass.
+	must(t, "ArchiveCompletedByList again", items.ArchiveCompletedByList(ctx, "list_st", at(8)))
+	it, _ = items.GetByID(ctx, "it_st_1")
+	if !it.UpdatedAt.Equal(at(7)) {
+		t.Fatalf("re-archive touched item: UpdatedAt = %v", it.UpdatedAt)
+	}
+
+	// ListByList still returns archived items; ListArchivedByRoom returns only
+	// archived ones, most recently updated first.
+	assertItemIDs(t, items, "list_st", "it_st_4", "it_st_1", "it_st_2", "it_st_3")
+	must(t, "UpdateStarred", items.UpdateStarred(ctx, "it_st_3", true, store.AnyVersion, at(9)))
+	archived, _, err := items.ListArchivedByRoom(ctx, "room_st", store.Page{})
+	must(t, "ListArchivedByRoom", err)
+	if len(archived) != 2 || archived[0].ItemID != "it_st_3" || archived[1].ItemID != "it_st_1" {
+		t.Fatalf("ListArchivedByRoom: unexpected %v", itemIDs(archived))
+	}
+	archived, _, err = items.ListArchivedByRoom(ctx, "room_empty", store.Page{})
+	must(t, "ListArchivedByRoom empty", err)
+	if len(archived) != 0 {
+		t.Fatalf("ListArchivedByRoom empty: got %v", itemIDs(archived))
+	}
+
+	if scanner, ok := items.(store.ListItemScanner); ok {
+		seen := map[string]bool{}
+		must(t, "ScanAll", scanner.ScanAll(ctx, func(it models.ListItem) error {
+			seen[it.ItemID] = true
+			return nil
+		}))
+		if len(seen) != 5 || !seen["it_st_x"] {
+			t.Fatalf("ScanAll: got %v, want all 5 items", seen)
+		}
+		stop := errors.New("stop")
+		wantErr(t, "ScanAll callback error", scanner.ScanAll(ctx, func(models.ListItem) error { return stop }), stop)
+	}
+
+	must(t, "Delete", items.Delete(ctx, "it_st_2"))
+	_, err = items.GetByID(ctx, "it_st_2")
+	wantErr(t, "GetByID deleted", err, derr.ErrNotFound)
+	wantErr(t, "Delete missing", items.Delete(ctx, "it_st_2"), derr.ErrNotFound)
+	assertItemIDs(t, items, "list_st", "it_st_4", "it_st_1", "it_st_3")
+
+	// DeleteByRoom removes every item of the room, archived ones included.
+	must(t, "Put other room", items.Put(ctx, &models.ListItem{ItemID: "it_st_y", ListID: "list_y", RoomID: "room_y", CreatedAt: at(0), UpdatedAt: at(0)}))
+	n, err := items.DeleteByRoom(ctx, "room_st")
+	must(t, "DeleteByRoom", err)
+	if n != 4 {
+		t.Fatalf("DeleteByRoom: removed %d items, want 4", n)
+	}
+	assertItemIDs(t, items, "list_st")
+	assertItemIDs(t, items, "list_y", "it_st_y")
+	n, err = items.DeleteByRoom(ctx, "room_st")
+	if err != nil || n != 0 {
+		t.Fatalf("DeleteByRoom again: got %d, %v; want 0, nil", n, err)
+	}
+}
+
+func assertItemIDs(t *testing.T, items store.ListItemRepository, listID string, want ...string) {
+	t.Helper()
+	got, _, err := items.ListByList(context.Background(), listID, store.Page{})
+	must(t, "ListByList", err)
+	ids := itemIDs(got)
+	if len(ids) != len(want) {
+		t.Fatalf("ListByList(%s): got %v, want %v", listID, ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ListByList(%s): got %v, want %v", listID, ids, want)
+		}
+	}
+}
+
+func itemIDs(items []models.ListItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ItemID)
+	}
+	return ids
+}
+
+func testTrash(t *testing.T, r Repos) {
+	ctx := context.Background()
+	lists, items := r.Lists, r.Items
+
+	for _, l := range []*models.List{
+		{ListID: "list_tr_a", RoomID: "room_tr", Name: "A", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ListID: "list_tr_b", RoomID: "room_tr", Name: "B", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ListID: "list_tr_x", RoomID: "room_tr_other", Name: "X", CreatedAt: at(0), UpdatedAt: at(0)},
+	} {
+		must(t, "Put "+l.ListID, lists.Put(ctx, l))
+	}
+	for _, it := range []*models.ListItem{
+		{ItemID: "it_tr_1", ListID: "list_tr_a", RoomID: "room_tr", Order: 1, Description: "one", Completed: true, CreatedAt: at(0), UpdatedAt: at(0)},
+		{ItemID: "it_tr_2", ListID: "list_tr_a", RoomID: "room_tr", Order: 2, Description: "two", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ItemID: "it_tr_3", ListID: "list_tr_a", RoomID: "room_tr", Order: 3, Description: "three", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ItemID: "it_tr_4", ListID: "list_tr_b", RoomID: "room_tr", Order: 1, Description: "four", CreatedAt: at(0), UpdatedAt: at(0)},
+	} {
+		must(t, "Put "+it.ItemID, items.Put(ctx, it))
+	}
+
+	// Trashed items stay readable by ID but leave list reads and reject updates.
+	must(t, "SoftDelete", items.SoftDelete(ctx, "it_tr_1", at(1)))
+	must(t, "SoftDelete", items.SoftDelete(ctx, "it_tr_2", at(2)))
+	wantErr(t, "SoftDelete twice", items.SoftDelete(ctx, "it_tr_1", at(3)), derr.ErrNotFound)
+	wantErr(t, "SoftDelete missing", items.SoftDelete(ctx, "item_missing", at(3)), derr.ErrNotFound)
+	it, err := items.GetByID(ctx, "it_tr_1")
+	must(t, "GetByID trashed", err)
+	if !it.IsDeleted || !it.UpdatedAt.Equal(at(1)) {
+		t.Fatalf("GetByID trashed: IsDeleted = %v, UpdatedAt = %v", it.IsDeleted, it.UpdatedAt)
+	}
+	assertItemIDs(t, items, "list_tr_a", "it_tr_3")
+	wantErr(t, "UpdateDescription trashed", items.UpdateDescription(ctx, "it_tr_1", "x", store.AnyVersion, at(3)), derr.ErrNotFound)
+	wantErr(t, "UpdateOrder trashed", items.UpdateOrder(ctx, "it_tr_1", 9, store.AnyVersion, at(3)), derr.ErrNotFound)
+	must(t, "ArchiveCompletedByList", items.ArchiveCompletedByList(ctx, "list_tr_a", at(3)))
+	it, _ = items.GetByID(ctx, "it_tr_1")
+	if it.IsArchived {
+		t.Fatalf("ArchiveCompletedByList archived a trashed item")
+	}
+	trashed, err := items.ListTrashedByRoom(ctx, "room_tr")
+	must(t, "ListTrashedByRoom items", err)
+	if ids := itemIDs(trashed); len(ids) != 2 || ids[0] != "it_tr_2" || ids[1] != "it_tr_1" {
+		t.Fatalf("ListTrashedByRoom items: got %v, want [it_tr_2 it_tr_1]", ids)
+	}
+
+	must(t, "Restore item", items.Restore(ctx, "it_tr_2", at(4)))
+	wantErr(t, "Restore live item", items.Restore(ctx, "it_tr_2", at(4)), derr.ErrNotFound)
+	wantErr(t, "Restore missing item", items.Restore(ctx, "item_missing", at(4)), derr.ErrNotFound)
+	assertItemIDs(t, items, "list_tr_a", "it_tr_2", "it_tr_3")
+	must(t, "UpdateDescription restored", items.UpdateDescription(ctx, "it_tr_2", "deux", store.AnyVersion, at(5)))
+
+	// Lists: trash, restore with votes cleared, and the purge query.
+	for _, id := range []string{"list_tr_a", "list_tr_b", "list_tr_x"} {
+		must(t, "AddDeletionVote", lists.AddDeletionVote(ctx, id, "usr_a", at(6)))
+	}
+	for id, ts := range map[string]int{"list_tr_a": 7, "list_tr_b": 8, "list_tr_x": 20} {
+		ok, err := lists.FinalizeDeleteIfVotedByAll(ctx, id, []string{"usr_a"}, at(ts))
+		if err != nil || !ok {
+			t.Fatalf("Finalize %s: got %v, %v", id, ok, err)
+		}
+	}
+	trashedLists, err := lists.ListTrashedByRoom(ctx, "room_tr")
+	must(t, "ListTrashedByRoom lists", err)
+	if len(trashedLists) != 2 || trashedLists[0].ListID != "list_tr_b" || trashedLists[1].ListID != "list_tr_a" {
+		t.Fatalf("ListTrashedByRoom lists: unexpected %+v", trashedLists)
+	}
+	expired, err := lists.ListDeletedBefore(ctx, at(10))
+	must(t, "ListDeletedBefore", err)
+	if len(expired) != 2 || expired[0].ListID != "list_tr_a" || expired[1].ListID != "list_tr_b" {
+		t.Fatalf("ListDeletedBefore: unexpected %+v", expired)
+	}
+
+	must(t, "Restore list", lists.Restore(ctx, "list_tr_a", at(9)))
+	wantErr(t, "Restore live list", lists.Restore(ctx, "list_tr_a", at(9)), derr.ErrNotFound)
+	wantErr(t, "Restore missing list", lists.Restore(ctx, "list_missing", at(9)), derr.ErrNotFound)
+	l, err := lists.GetByID(ctx, "list_tr_a")
+	must(t, "GetByID restored", err)
+	if l.IsDeleted || len(l.DeletionVotes) != 0 || !l.UpdatedAt.Equal(at(9)) {
+		t.Fatalf("Restore list: %+v", l)
+	}
+	assertListIDs(t, lists, "room_tr", "list_tr_a")
+	must(t, "UpdateName restored", lists.UpdateName(ctx, "list_tr_a", "A2", store.AnyVersion, at(10)))
+
+	// Purge: expired trashed items go; DeleteByList takes a list's items, trashed or not.
+	must(t, "SoftDelete", items.SoftDelete(ctx, "it_tr_3", at(30)))
+	n, err := items.PurgeDeletedBefore(ctx, at(10))
+	must(t, "PurgeDeletedBefore", err)
+	if n != 1 {
+		t.Fatalf("PurgeDeletedBefore: removed %d items, want 1", n)
+	}
+	_, err = items.GetByID(ctx, "it_tr_1")
+	wantErr(t, "GetByID purged", err, derr.ErrNotFound)
+	if _, err := items.GetByID(ctx, "it_tr_3"); err != nil {
+		t.Fatalf("PurgeDeletedBefore removed an item trashed after the cutoff: %v", err)
+	}
+	n, err = items.DeleteByList(ctx, "list_tr_a")
+	must(t, "DeleteByList", err)
+	if n != 2 {
+		t.Fatalf("DeleteByList: removed %d items, want 2", n)
+	}
+	assertItemIDs(t, items, "list_tr_b", "it_tr_4")
+}
+
+func testMove(t *testing.T, r Repos) {
+	ctx := context.Background()
+	lists, items := r.Lists, r.Items
+
+	for _, l := range []*models.List{
+		{ListID: "list_mv_a", RoomID: "room_mv_solo", Name: "A", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ListID: "list_mv_b", RoomID: "room_mv_solo", Name: "B", CreatedAt: at(1), UpdatedAt: at(1)},
+		{ListID: "list_mv_c", RoomID: "room_mv_home", Name: "B", CreatedAt: at(0), UpdatedAt: at(0)},
+	} {
+		must(t, "Put "+l.ListID, lists.Put(ctx, l))
+	}
+	for _, it := range []*models.ListItem{
+		{ItemID: "it_mv_1", ListID: "list_mv_a", RoomID: "room_mv_solo", Order: 1, Description: "one", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ItemID: "it_mv_2", ListID: "list_mv_a", RoomID: "room_mv_solo", Order: 2, Description: "two", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ItemID: "it_mv_3", ListID: "list_mv_b", RoomID: "room_mv_solo", Order: 1, Description: "three", CreatedAt: at(0), UpdatedAt: at(0)},
+		{ItemID: "it_mv_4", ListID: "list_mv_c", RoomID: "room_mv_home", Order: 5, Description: "four", CreatedAt: at(0), UpdatedAt: at(0)},
+	} {
+		must(t, "Put "+it.ItemID, items.Put(ctx, it))
+	}
+	must(t, "SoftDelete", items.SoftDelete(ctx, "it_mv_2", at(1)))
+	must(t, "AddDeletionVote", lists.AddDeletionVote(ctx, "list_mv_a", "usr_a", at(1)))
+
+	// A whole list moves to another room, its votes cleared and its items,
+	// trashed ones included, following it.
+	must(t, "MoveToRoom", lists.MoveToRoom(ctx, "list_mv_a", "room_mv_home", at(2)))
+	wantErr(t, "MoveToRoom missing", lists.MoveToRoom(ctx, "list_missing", "room_mv_home", at(2)), derr.ErrNotFound)
+	l, err := lists.GetByID(ctx, "list_mv_a")
+	must(t, "GetByID moved", err)
+	if l.RoomID != "room_mv_home" || len(l.DeletionVotes) != 0 || !l.UpdatedAt.Equal(at(2)) {
+		t.Fatalf("MoveToRoom: %+v", l)
+	}
+	n, err := items.MoveByList(ctx, "list_mv_a", "list_mv_a", "room_mv_home", 0, at(2))
+	must(t, "MoveByList same list", err)
+	if n != 2 {
+		t.Fatalf("MoveByList same list: moved %d items, want 2", n)
+	}
+	it, err := items.GetByID(ctx, "it_mv_2")
+	must(t, "GetByID moved trashed", err)
+	if it.RoomID != "room_mv_home" || !it.IsDeleted || it.Order != 2 {
+		t.Fatalf("MoveByList trashed item: %+v", it)
+	}
+	assertListIDs(t, lists, "room_mv_home", "list_mv_a", "list_mv_c")
+	assertListIDs(t, lists, "room_mv_solo", "list_mv_b")
+
+	// Merging into another list shifts the moved items after its own, all
+	// in one transaction.
+	must(t, "WithTransaction", r.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := items.MoveByList(ctx, "list_mv_b", "list_mv_c", "room_mv_home", 10, at(3)); err != nil {
+			return err
+		}
+		return lists.Delete(ctx, "list_mv_b")
+	}))
+	assertItemIDs(t, items, "list_mv_c", "it_mv_4", "it_mv_3")
+	it, _ = items.GetByID(ctx, "it_mv_3")
+	if it.RoomID != "room_mv_home" || it.Order != 11 || !it.UpdatedAt.Equal(at(3)) {
+		t.Fatalf("MoveByList merged item: %+v", it)
+	}
+	assertListIDs(t, lists, "room_mv_solo")
+	n, err = items.MoveByList(ctx, "list_mv_b", "list_mv_c", "room_mv_home", 0, at(4))
+	if err != nil || n != 0 {
+		t.Fatalf("MoveByList empty list: got %d, %v", n, err)
+	}
+}
+
+func testPagination(t *testing.T, r Repos) {
+	ctx := context.Background()
+	lists, items := r.Lists, r.Items
+
+	// Ties on the leading sort keys are broken by ID, so every page boundary
+	// below falls between equal keys at least once.
+	for _, it := range []*models.ListItem{
+		{ItemID: "it_pg_e", Order: 2, CreatedAt: at(1), UpdatedAt: at(5)},
+		{ItemID: "it_pg_b", Order: 1, CreatedAt: at(2), UpdatedAt: at(5)},
+		{ItemID: "it_pg_d", Order: 1, CreatedAt: at(2), UpdatedAt: at(6)},
+		{ItemID: "it_pg_a", Order: 1, CreatedAt: at(1), UpdatedAt: at(5)},
+		{ItemID: "it_pg_c", Order: 1, CreatedAt: at(2), UpdatedAt: at(4)},
+	} {
+		it.ListID, it.RoomID, it.IsArchived = "list_pg", "room_pg", true
+		must(t, "Put "+it.ItemID, items.Put(ctx, it))
+	}
+	for _, l := range []*models.List{
+		{ListID: "list_pg_c", CreatedAt: at(2)},
+		{ListID: "list_pg_a", CreatedAt: at(1)},
+		{ListID: "list_pg_b", CreatedAt: at(2)},
+	} {
+		l.RoomID, l.Name, l.UpdatedAt = "room_pg", l.ListID, l.CreatedAt
+		must(t, "Put "+l.ListID, lists.Put(ctx, l))
+	}
+
+	byList := func(p store.Page) ([]string, string, error) {
+		got, next, err := items.ListByList(ctx, "list_pg", p)
+		return itemIDs(got), next, err
+	}
+	archived := func(p store.Page) ([]string, string, error) {
+		got, next, err := items.ListArchivedByRoom(ctx, "room_pg", p)
+		return itemIDs(got), next, err
+	}
+	byRoom := func(p store.Page) ([]string, string, error) {
+		got, next, err := lists.ListByRoom(ctx, "room_pg", p)
+		ids := make([]string, 0, len(got))
+		for _, l := range got {
+			ids = append(ids, l.ListID)
+		}
+		return ids, next, err
+	}
+	for _, tc := range []struct {
+		name  string
+		query func(store.Page) ([]string, string, error)
+		want  []string
+	}{
+		{"ListByList", byList, []string{"it_pg_a", "it_pg_b", "it_pg_c", "it_pg_d", "it_pg_e"}},
+		{"ListArchivedByRoom", archived, []string{"it_pg_d", "it_pg_a", "it_pg_b", "it_pg_e", "it_pg_c"}},
+		{"ListByRoom", byRoom, []string{"list_pg_a", "list_pg_b", "list_pg_c"}},
+	} {
+		for _, limit := range []int{0, 1, 2, len(tc.want), len(tc.want) + 1} {
+			var got []string
+			page := store.Page{Limit: limit}
+			for calls := 0; ; calls++ {
+				if calls > len(tc.want) {
+					t.Fatalf("%s limit %d: cursor does not advance, got %v", tc.name, limit, got)
+				}
+				ids, next, err := tc.query(page)
+				must(t, tc.name, err)
+				if limit > 0 && len(ids) > limit {
+					t.Fatalf("%s limit %d: page of %d", tc.name, limit, len(ids))
+				}
+				got = append(got, ids...)
+				if next == "" {
+					break
+				}
+				page.Cursor = next
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("%s limit %d: got %v, want %v", tc.name, limit, got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("%s limit %d: got %v, want %v", tc.name, limit, got, tc.want)
+				}
+			}
+		}
+		_, _, err := tc.query(store.Page{Limit: 1, Cursor: "not a cursor"})
+		wantErr(t, tc.name+" bad cursor", err, derr.ErrBadRequest)
+	}
+}
+
+func testVersions(t *testing.T, r Repos) {
+	ctx := context.Background()
+
+	rm := &models.Room{RoomID: "room_v", MemberIDs: []string{"usr_a"}, DisplayName: "Home", Version: 1, CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put room", r.Rooms.Put(ctx, rm))
+	must(t, "UpdateDisplayName", r.Rooms.UpdateDisplayName(ctx, rm.RoomID, "usr_a", "Flat", 1, at(1)))
+	wantErr(t, "UpdateDisplayName stale", r.Rooms.UpdateDisplayName(ctx, rm.RoomID, "usr_a", "Loft", 1, at(2)), derr.ErrPreconditionFailed)
+	wantErr(t, "UpdateDescription missing", r.Rooms.UpdateDescription(ctx, "room_missing", "usr_a", "x", 0, at(2)), derr.ErrNotFound)
+	must(t, "VoteDeletion", r.Rooms.VoteDeletion(ctx, rm.RoomID, "usr_a", at(3)))
+	must(t, "UpdateDescription", r.Rooms.UpdateDescription(ctx, rm.RoomID, "usr_a", "Ours", 3, at(4)))
+	gotRoom, err := r.Rooms.GetByID(ctx, rm.RoomID)
+	must(t, "GetByID room", err)
+	if gotRoom.Version != 4 || gotRoom.DisplayName != "Flat" || gotRoom.Description != "Ours" {
+		t.Fatalf("room after updates: %+v", gotRoom)
+	}
+
+	l := &models.List{ListID: "list_v", RoomID: rm.RoomID, Name: "Groceries", Version: 1, CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put list", r.Lists.Put(ctx, l))
+	must(t, "UpdateName", r.Lists.UpdateName(ctx, l.ListID, "Food", 1, at(1)))
+	wantErr(t, "UpdateNotes stale", r.Lists.UpdateNotes(ctx, l.ListID, "x", 1, at(2)), derr.ErrPreconditionFailed)
+	must(t, "AddDeletionVote", r.Lists.AddDeletionVote(ctx, l.ListID, "usr_a", at(2)))
+	must(t, "UpdateIcon", r.Lists.UpdateIcon(ctx, l.ListID, "cart", 3, at(3)))
+	gotList, err := r.Lists.GetByID(ctx, l.ListID)
+	must(t, "GetByID list", err)
+	if gotList.Version != 4 || gotList.Name != "Food" || gotList.Notes != "" || gotList.Icon != "cart" {
+		t.Fatalf("list after updates: %+v", gotList)
+	}
+
+	// Records written before versions existed are at version 0.
+	it := &models.ListItem{ItemID: "it_v", ListID: l.ListID, RoomID: rm.RoomID, Order: 1, Description: "milk", CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put item", r.Items.Put(ctx, it))
+	must(t, "UpdateCompletion", r.Items.UpdateCompletion(ctx, it.ItemID, true, 0, at(1)))
+	wantErr(t, "UpdateOrder stale", r.Items.UpdateOrder(ctx, it.ItemID, 5, 0, at(2)), derr.ErrPreconditionFailed)
+	must(t, "UpdateQuantity any", r.Items.UpdateQuantity(ctx, it.ItemID, "2", store.AnyVersion, at(2)))
+	must(t, "SoftDelete", r.Items.SoftDelete(ctx, it.ItemID, at(3)))
+	wantErr(t, "UpdateStarred trashed", r.Items.UpdateStarred(ctx, it.ItemID, true, 3, at(4)), derr.ErrNotFound)
+	must(t, "Restore", r.Items.Restore(ctx, it.ItemID, at(4)))
+	wantErr(t, "UpdateCategory missing", r.Items.UpdateCategory(ctx, "item_missing", "dairy", 4, at(5)), derr.ErrNotFound)
+	must(t, "UpdateCategory", r.Items.UpdateCategory(ctx, it.ItemID, "dairy", 4, at(5)))
+	gotItem, err := r.Items.GetByID(ctx, it.ItemID)
+	must(t, "GetByID item", err)
+	if gotItem.Version != 5 || !gotItem.Completed || gotItem.Order != 1 || gotItem.Quantity != "2" || gotItem.Category != "dairy" {
+		t.Fatalf("item after updates: %+v", gotItem)
+	}
+}
+
+func testPatch(t *testing.T, r Repos) {
+	ctx := context.Background()
+	str := func(s string) *string { return &s }
+	yes := true
+
+	rm := &models.Room{RoomID: "room_p", MemberIDs: []string{"usr_a"}, DisplayName: "Home", Description: "Ours", Version: 1, CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put room", r.Rooms.Put(ctx, rm))
+	gotRoom, err := r.Rooms.Patch(ctx, rm.RoomID, "usr_a", store.RoomPatch{DisplayName: str("Flat"), Description: str("")}, 1, at(1))
+	must(t, "Patch room", err)
+	if gotRoom.Version != 2 || gotRoom.DisplayName != "Flat" || gotRoom.Description != "" || !gotRoom.UpdatedAt.Equal(at(1)) {
+		t.Fatalf("patched room: %+v", gotRoom)
+	}
+	_, err = r.Rooms.Patch(ctx, rm.RoomID, "usr_a", store.RoomPatch{DisplayName: str("Loft")}, 1, at(2))
+	wantErr(t, "Patch room stale", err, derr.ErrPreconditionFailed)
+	_, err = r.Rooms.Patch(ctx, "room_missing", "usr_a", store.RoomPatch{DisplayName: str("Loft")}, 2, at(2))
+	wantErr(t, "Patch room missing", err, derr.ErrNotFound)
+
+	l := &models.List{ListID: "list_p", RoomID: rm.RoomID, Name: "Groceries", Notes: "eggs", Icon: "cart", Version: 1, CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put list", r.Lists.Put(ctx, l))
+	gotList, err := r.Lists.Patch(ctx, l.ListID, store.ListPatch{Name: str("Food"), Description: str("Weekly"), Notes: str(""), Icon: str("")}, 1, at(1))
+	must(t, "Patch list", err)
+	if gotList.Version != 2 || gotList.Name != "Food" || gotList.Description != "Weekly" || gotList.Notes != "" || gotList.Icon != "" {
+		t.Fatalf("patched list: %+v", gotList)
+	}
+	_, err = r.Lists.Patch(ctx, l.ListID, store.ListPatch{Name: str("Stale")}, 1, at(2))
+	wantErr(t, "Patch list stale", err, derr.ErrPreconditionFailed)
+	must(t, "AddDeletionVote", r.Lists.AddDeletionVote(ctx, l.ListID, "usr_a", at(3)))
+	if _, err := r.Lists.FinalizeDeleteIfVotedByAll(ctx, l.ListID, []string{"usr_a"}, at(3)); err != nil {
+		t.Fatalf("FinalizeDeleteIfVotedByAll: %v", err)
+	}
+	_, err = r.Lists.Patch(ctx, l.ListID, store.ListPatch{Name: str("Trashed")}, store.AnyVersion, at(4))
+	wantErr(t, "Patch list trashed", err, derr.ErrNotFound)
+
+	// A patch of a record written before versions existed expects version 0.
+	it := &models.ListItem{ItemID: "it_p", ListID: "list_q", RoomID: rm.RoomID, Order: 1, Description: "milk", CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put item", r.Items.Put(ctx, it))
+	patch := store.ItemPatch{Description: str("oat milk"), Completed: &yes, Quantity: str("2"), Unit: str("l"), Category: str("dairy"), Starred: &yes}
+	gotItem, err := r.Items.Patch(ctx, it.ItemID, patch, 0, at(1))
+	must(t, "Patch item", err)
+	if gotItem.Version != 1 || gotItem.Description != "oat milk" || !gotItem.Completed || gotItem.Quantity != "2" ||
+		gotItem.Unit != "l" || gotItem.Category != "dairy" || !gotItem.IsStarred || gotItem.Order != 1 {
+		t.Fatalf("patched item: %+v", gotItem)
+	}
+	stored, err := r.Items.GetByID(ctx, it.ItemID)
+	must(t, "GetByID item", err)
+	if stored.Version != 1 || stored.Description != "oat milk" || !stored.IsStarred {
+		t.Fatalf("stored item: %+v", stored)
+	}
+	_, err = r.Items.Patch(ctx, it.ItemID, store.ItemPatch{Quantity: str("3")}, 0, at(2))
+	wantErr(t, "Patch item stale", err, derr.ErrPreconditionFailed)
+	_, err = r.Items.Patch(ctx, "item_missing", store.ItemPatch{Quantity: str("3")}, 1, at(2))
+	wantErr(t, "Patch item missing", err, derr.ErrNotFound)
+	gotItem, err = r.Items.Patch(ctx, it.ItemID, store.ItemPatch{Quantity: str("3")}, store.AnyVersion, at(3))
+	if err != nil || gotItem.Version != 2 || gotItem.Quantity != "3" || gotItem.Unit != "l" {
+		t.Fatalf("unconditional item patch: %v, %+v", err, gotItem)
+	}
+}
+
+// testScan checks that ScanAll visits every record, trashed ones included.
+// Repositories without it are skipped.
+func testScan(t *testing.T, r Repos) {
+	ctx := context.Background()
+	users, ok1 := r.Users.(store.UserScanner)
+	rooms, ok2 := r.Rooms.(store.RoomScanner)
+	lists, ok3 := r.Lists.(store.ListScanner)
+	items, ok4 := r.Items.(store.ListItemScanner)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		t.Skip("repositories do not implement ScanAll")
+	}
+
+	for _, id := range []string{"usr_s1", "usr_s2"} {
+		must(t, "Put user", r.Users.Put(ctx, &models.User{UserID: id, Name: id, PasswordEnc: "enc", RoomIDs: []string{"room_s", "room_s0"}, CreatedAt: at(0), UpdatedAt: at(0)}))
+	}
+	rm := &models.Room{RoomID: "room_s", MemberIDs: []string{"usr_s1", "usr_s2"}, DeletionVotes: map[string]string{"usr_s1": at(1).Format(time.RFC3339)}, Version: 1, CreatedAt: at(0), UpdatedAt: at(0)}
+	must(t, "Put room", r.Rooms.Put(ctx, rm))
+	for i, id := range []string{"list_s1", "list_s2"} {
+		must(t, "Put list", r.Lists.Put(ctx, &models.List{ListID: id, RoomID: rm.RoomID, Name: id, IsDeleted: i == 1, Version: 1, CreatedAt: at(i), UpdatedAt: at(i)}))
+	}
+	for i, id := range []string{"it_s1", "it_s2", "it_s3"} {
+		must(t, "Put item", r.Items.Put(ctx, &models.ListItem{ItemID: id, ListID: "list_s1", RoomID: rm.RoomID, Order: float64(i), Description: id, IsDeleted: i == 2, Version: 1, CreatedAt: at(i), UpdatedAt: at(i)}))
+	}
+
+	var userIDs, listIDs, gotItems []string
+	must(t, "ScanAll users", users.ScanAll(ctx, func(u models.User) error {
+		if u.PasswordEnc != "enc" || !slices.Equal(u.RoomIDs, []string{"room_s", "room_s0"}) {
+			t.Errorf("scanned user %s: %+v", u.UserID, u)
+		}
+		userIDs = append(userIDs, u.UserID)
+		return nil
+	}))
+	var gotRooms []models.Room
+	must(t, "ScanAll rooms", rooms.ScanAll(ctx, func(rm models.Room) error {
+		gotRooms = append(gotRooms, rm)
+		return nil
+	}))
+	must(t, "ScanAll lists", lists.ScanAll(ctx, func(l models.List) error {
+		listIDs = append(listIDs, l.ListID)
+		return nil
+	}))
+	must(t, "ScanAll items", items.ScanAll(ctx, func(it models.ListItem) error {
+		gotItems = append(gotItems, it.ItemID)
+		return nil
+	}))
+	slices.Sort(userIDs)
+	slices.Sort(listIDs)
+	slices.Sort(gotItems)
+	if !slices.Equal(userIDs, []string{"usr_s1", "usr_s2"}) || !slices.Equal(listIDs, []string{"list_s1", "list_s2"}) ||
+		!slices.Equal(gotItems, []string{"it_s1", "it_s2", "it_s3"}) {
+		t.Fatalf("scanned users %v, lists %v, items %v", userIDs, listIDs, gotItems)
+	}
+	if len(gotRooms) != 1 || !slices.Equal(gotRooms[0].MemberIDs, rm.MemberIDs) || len(gotRooms[0].DeletionVotes) != 1 {
+		t.Fatalf("scanned rooms: %+v", gotRooms)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err := items.ScanAll(ctx, func(models.ListItem) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Fatalf("ScanAll stop: %v after %d items", err, n)
+	}
+}
+
+func testMigrations(t *testing.T, migrations store.MigrationRepository) {
+	ctx := context.Background()
+
+	recs, err := migrations.ListApplied(ctx)
+	must(t, "ListApplied empty", err)
+	if len(recs) != 0 {
+		t.Fatalf("ListApplied empty: got %v", recs)
+	}
+	must(t, "Record 2", migrations.Record(ctx, models.MigrationRecord{Version: 2, Name: "second", AppliedAt: at(1)}))
+	must(t, "Record 1", migrations.Record(ctx, models.MigrationRecord{Version: 1, Name: "first", AppliedAt: at(2)}))
+	wantErr(t, "Record duplicate", migrations.Record(ctx, models.MigrationRecord{Version: 1, Name: "again", AppliedAt: at(3)}), derr.ErrConflict)
+
+	// Ascending by version, whatever the insertion order.
+	recs, err = migrations.ListApplied(ctx)
+	must(t, "ListApplied", err)
+	if len(recs) != 2 || recs[0].Version != 1 || recs[1].Version != 2 {
+		t.Fatalf("ListApplied: unexpected %+v", recs)
+	}
+	if recs[0].Name != "first" || !recs[0].AppliedAt.Equal(at(2)) {
+		t.Fatalf("ListApplied: record not round-tripped: %+v", recs[0])
+	}
+}
+
+func testJobs(t *testing.T, jobs store.JobRepository) {
+	ctx := context.Background()
+
+	_, err := jobs.ClaimNext(ctx, at(0), at(1))
+	wantErr(t, "ClaimNext empty", err, derr.ErrNotFound)
+	_, err = jobs.GetByID(ctx, "job_missing")
+	wantErr(t, "GetByID missing", err, derr.ErrNotFound)
+
+	for _, j := range []*models.Job{
+		{JobID: "job_st_late", Kind: "k", Status: models.JobPending, MaxAttempts: 3, RunAt: at(5), CreatedAt: at(0), UpdatedAt: at(0)},
+		{JobID: "job_st_early", Kind: "k", Payload: map[string]string{"room_id": "room_st"}, Status: models.JobPending, MaxAttempts: 3, RunAt: at(1), CreatedAt: at(0), UpdatedAt: at(0)},
+	} {
+		must(t, "Enqueue "+j.JobID, jobs.Enqueue(ctx, j))
+	}
+	wantErr(t, "Enqueue duplicate", jobs.Enqueue(ctx, &models.Job{JobID: "job_st_late", Kind: "k", Status: models.JobPending, RunAt: at(0), CreatedAt: at(0), UpdatedAt: at(0)}), derr.ErrConflict)
+
+	// Nothing is due before the earliest RunAt.
+	_, err = jobs.ClaimNext(ctx, at(0), at(1))
+	wantErr(t, "ClaimNext early", err, derr.ErrNotFound)
+
+	j, err := jobs.ClaimNext(ctx, at(2), at(12))
+	must(t, "ClaimNext", err)
+	if j.JobID != "job_st_early" || j.Status != models.JobRunning || j.Attempts != 1 || !j.RunAt.Equal(at(12)) || j.Payload["room_id"] != "room_st" {
+		t.Fatalf("ClaimNext: unexpected %+v", j)
+	}
+	// A leased job is not handed out again until the lease expires.
+	_, err = jobs.ClaimNext(ctx, at(3), at(13))
+	wantErr(t, "ClaimNext leased", err, derr.ErrNotFound)
+
+	must(t, "Retry", jobs.Retry(ctx, "job_st_early", 1, at(6), "boom", at(3)))
+	wantErr(t, "Retry stale", jobs.Retry(ctx, "job_st_early", 1, at(6), "boom", at(3)), derr.ErrConflict)
+	got, err := jobs.GetByID(ctx, "job_st_early")
+	must(t, "GetByID", err)
+	if got.Status != models.JobPending || got.LastError != "boom" || !got.RunAt.Equal(at(6)) || got.Attempts != 1 {
+		t.Fatalf("Retry: unexpected %+v", got)
+	}
+
+	// Earliest due first: late (RunAt 5) before early (RunAt 6).
+	j, err = jobs.ClaimNext(ctx, at(7), at(8))
+	must(t, "ClaimNext late", err)
+	if j.JobID != "job_st_late" {
+		t.Fatalf("ClaimNext: got %s, want job_st_late", j.JobID)
+	}
+	// An expired lease is claimed again, which invalidates the first claim.
+	j, err = jobs.ClaimNext(ctx, at(7), at(18))
+	must(t, "ClaimNext early again", err)
+	if j.JobID != "job_st_early" || j.Attempts != 2 {
+		t.Fatalf("ClaimNext: unexpected %+v", j)
+	}
+	j, err = jobs.ClaimNext(ctx, at(9), at(19))
+	must(t, "ClaimNext expired lease", err)
+	if j.JobID != "job_st_late" || j.Attempts != 2 {
+		t.Fatalf("ClaimNext expired lease: unexpected %+v", j)
+	}
+	wantErr(t, "Complete stale", jobs.Complete(ctx, "job_st_late", 1), derr.ErrConflict)
+	must(t, "Complete", jobs.Complete(ctx, "job_st_late", 2))
+	_, err = jobs.GetByID(ctx, "job_st_late")
+	wantErr(t, "GetByID completed", err, derr.ErrNotFound)
+	wantErr(t, "Complete missing", jobs.Complete(ctx, "job_st_late", 2), derr.ErrNotFound)
+
+	// Dead jobs are never claimed and can be requeued.
+	must(t, "Bury", jobs.Bury(ctx, "job_st_early", 2, "gave up", at(10)))
+	_, err = jobs.ClaimNext(ctx, at(30), at(31))
+	wantErr(t, "ClaimNext dead", err, derr.ErrNotFound)
+	dead, err := jobs.ListDead(ctx)
+	must(t, "ListDead", err)
+	if len(dead) != 1 || dead[0].JobID != "job_st_early" || dead[0].LastError != "gave up" || dead[0].Status != models.JobDead {
+		t.Fatalf("ListDead: unexpected %+v", dead)
+	}
+	wantErr(t, "Requeue missing", jobs.Requeue(ctx, "job_missing", at(11), at(11)), derr.ErrNotFound)
+	must(t, "Requeue", jobs.Requeue(ctx, "job_st_early", at(11), at(11)))
+	wantErr(t, "Requeue not dead", jobs.Requeue(ctx, "job_st_early", at(11), at(11)), derr.ErrNotFound)
+	j, err = jobs.ClaimNext(ctx, at(12), at(13))
+	must(t, "ClaimNext requeued", err)
+	if j.JobID != "job_st_early" || j.Attempts != 1 {
+		t.Fatalf("ClaimNext requeued: unexpected %+v", j)
+	}
+	dead, err = jobs.ListDead(ctx)
+	must(t, "ListDead empty", err)
+	if len(dead) != 0 {
+		t.Fatalf("ListDead empty: got %d", len(dead))
+	}
+}
+
+func testSessions(t *testing.T, sessions store.SessionRepository) {
+	ctx := context.Background()
+
+	_, err := sessions.GetByID(ctx, "ses_missing")
+	wantErr(t, "GetByID missing", err, derr.ErrNotFound)
+	_, err = sessions.GetByKeyLookup(ctx, "lk_missing")
+	wantErr(t, "GetByKeyLookup missing", err, derr.ErrNotFound)
+
+	exp := at(60)
+	for _, s := range []*models.Session{
+		{SessionID: "ses_st_laptop", UserID: "usr_st_1", Name: "Laptop", KeyHash: "h1", KeyLookup: "lk_ses_1", CreatedAt: at(0), LastUsedAt: at(1), ExpiresAt: &exp},
+		{SessionID: "ses_st_phone", UserID: "usr_st_1", Name: "Phone", KeyHash: "h2", KeyLookup: "lk_ses_2", CreatedAt: at(0), LastUsedAt: at(2)},
+		{SessionID: "ses_st_other", UserID: "usr_st_2", KeyHash: "h3", KeyLookup: "lk_ses_3", CreatedAt: at(0), LastUsedAt: at(0)},
+	} {
+		must(t, "Create "+s.SessionID, sessions.Create(ctx, s))
+	}
+	wantErr(t, "Create duplicate", sessions.Create(ctx, &models.Session{SessionID: "ses_st_phone", UserID: "usr_st_1", KeyHash: "h", KeyLookup: "lk_ses_4", CreatedAt: at(0), LastUsedAt: at(0)}), derr.ErrConflict)
+
+	got, err := sessions.GetByKeyLookup(ctx, "lk_ses_1")
+	must(t, "GetByKeyLookup", err)
+	if got.SessionID != "ses_st_laptop" || got.UserID != "usr_st_1" || got.Name != "Laptop" || got.KeyHash != "h1" || got.ExpiresAt == nil || !got.ExpiresAt.Equal(exp) || !got.LastUsedAt.Equal(at(1)) {
+		t.Fatalf("GetByKeyLookup: unexpected %+v", got)
+	}
+
+	// Most recently used first.
+	must(t, "Touch", sessions.Touch(ctx, "ses_st_laptop", at(3)))
+	wantErr(t, "Touch missing", sessions.Touch(ctx, "ses_missing", at(3)), derr.ErrNotFound)
+	list, err := sessions.ListByUser(ctx, "usr_st_1")
+	must(t, "ListByUser", err)
+	if len(list) != 2 || list[0].SessionID != "ses_st_laptop" || list[1].SessionID != "ses_st_phone" || !list[0].LastUsedAt.Equal(at(3)) {
+		t.Fatalf("ListByUser: unexpected %+v", list)
+	}
+
+	n, err := sessions.DeleteExpired(ctx, at(61))
+	must(t, "DeleteExpired", err)
+	if n != 1 {
+		t.Fatalf("DeleteExpired: removed %d, want 1", n)
+	}
+	_, err = sessions.GetByID(ctx, "ses_st_laptop")
+	wantErr(t, "GetByID expired", err, derr.ErrNotFound)
+
+	// Token sessions have no key lookup and rotate their refresh generation.
+	for _, id := range []string{"ses_st_tok_1", "ses_st_tok_2"} {
+		must(t, "Create "+id, sessions.Create(ctx, &models.Session{SessionID: id, UserID: "usr_st_2", RefreshGen: 1, CreatedAt: at(0), LastUsedAt: at(0), ExpiresAt: &exp}))
+	}
+	_, err = sessions.GetByKeyLookup(ctx, "")
+	wantErr(t, "GetByKeyLookup empty", err, derr.ErrNotFound)
+	later := at(120)
+	must(t, "Rotate", sessions.Rotate(ctx, "ses_st_tok_1", 1, at(5), &later))
+	wantErr(t, "Rotate stale", sessions.Rotate(ctx, "ses_st_tok_1", 1, at(6), &later), derr.ErrConflict)
+	wantErr(t, "Rotate missing", sessions.Rotate(ctx, "ses_missing", 1, at(6), &later), derr.ErrNotFound)
+	got, err = sessions.GetByID(ctx, "ses_st_tok_1")
+	must(t, "GetByID rotated", err)
+	if got.RefreshGen != 2 || got.KeyLookup != "" || !got.LastUsedAt.Equal(at(5)) || got.ExpiresAt == nil || !got.ExpiresAt.Equal(later) {
+		t.Fatalf("Rotate: unexpected %+v", got)
+	}
+
+	must(t, "Delete", sessions.Delete(ctx, "ses_st_phone"))
+	wantErr(t, "Delete missing", sessions.Delete(ctx, "ses_st_phone"), derr.ErrNotFound)
+
+	n, err = sessions.DeleteByUser(ctx, "usr_st_2")
+	must(t, "DeleteByUser", err)
+	if n != 3 {
+		t.Fatalf("DeleteByUser: removed %d, want 1", n)
+	}
+	list, err = sessions.ListByUser(ctx, "usr_st_2")
+	must(t, "ListByUser empty", err)
+	if len(list) != 0 {
+		t.Fatalf("ListByUser empty: got %+v", list)
+	}
+}
+
+func testUserTokens(t *testing.T, tokens store.UserTokenRepository) {
+	ctx := context.Background()
+	const reset = models.TokenPasswordReset
+
+	_, err := tokens.Consume(ctx, "hash_missing", reset)
+	wantErr(t, "Consume missing", err, derr.ErrNotFound)
+
+	for _, tk := range []*models.UserToken{
+		{TokenHash: "hash_1", Purpose: reset, UserID: "usr_tk_1", CreatedAt: at(0), ExpiresAt: at(60)},
+		{TokenHash: "hash_2", Purpose: reset, UserID: "usr_tk_1", CreatedAt: at(1), ExpiresAt: at(30)},
+		{TokenHash: "hash_3", Purpose: "other", UserID: "usr_tk_1", CreatedAt: at(1), ExpiresAt: at(90)},
+		{TokenHash: "hash_4", Purpose: reset, UserID: "usr_tk_2", CreatedAt: at(1), ExpiresAt: at(90)},
+	} {
+		must(t, "Create "+tk.TokenHash, tokens.Create(ctx, tk))
+	}
+	wantErr(t, "Create duplicate", tokens.Create(ctx, &models.UserToken{TokenHash: "hash_1", Purpose: reset, UserID: "usr_tk_2", CreatedAt: at(0), ExpiresAt: at(60)}), derr.ErrConflict)
+
+	if scanner, ok := tokens.(store.UserTokenScanner); ok {
+		var hashes []string
+		must(t, "ScanAll", scanner.ScanAll(ctx, func(tk models.UserToken) error {
+			if tk.TokenHash == "hash_2" && (tk.UserID != "usr_tk_1" || !tk.CreatedAt.Equal(at(1)) || !tk.ExpiresAt.Equal(at(30))) {
+				t.Errorf("scanned token: %+v", tk)
+			}
+			hashes = append(hashes, tk.TokenHash)
+			return nil
+		}))
+		slices.Sort(hashes)
+		if !slices.Equal(hashes, []string{"hash_1", "hash_2", "hash_3", "hash_4"}) {
+			t.Fatalf("ScanAll: got %v", hashes)
+		}
+	}
+
+	// A token redeems once, and only for its purpose.
+	_, err = tokens.Consume(ctx, "hash_3", reset)
+	wantErr(t, "Consume wrong purpose", err, derr.ErrNotFound)
+	got, err := tokens.Consume(ctx, "hash_1", reset)
+	must(t, "Consume", err)
+	if got.UserID != "usr_tk_1" || got.Purpose != reset || !got.CreatedAt.Equal(at(0)) || !got.ExpiresAt.Equal(at(60)) {
+		t.Fatalf("Consume: unexpected %+v", got)
+	}
+	_, err = tokens.Consume(ctx, "hash_1", reset)
+	wantErr(t, "Consume twice", err, derr.ErrNotFound)
+
+	n, err := tokens.DeleteExpired(ctx, at(31))
+	must(t, "DeleteExpired", err)
+	if n != 1 {
+		t.Fatalf("DeleteExpired: removed %d, want 1", n)
+	}
+	_, err = tokens.Consume(ctx, "hash_2", reset)
+	wantErr(t, "Consume expired", err, derr.ErrNotFound)
+
+	n, err = tokens.DeleteByUser(ctx, "usr_tk_1", reset)
+	must(t, "DeleteByUser", err)
+	if n != 0 {
+		t.Fatalf("DeleteByUser: removed %d, want 0", n)
+	}
+	n, err = tokens.DeleteByUser(ctx, "usr_tk_2", reset)
+	must(t, "DeleteByUser", err)
+	if n != 1 {
+		t.Fatalf("DeleteByUser: removed %d, want 1", n)
+	}
+	_, err = tokens.Consume(ctx, "hash_3", "other")
+	must(t, "Consume other purpose", err)
+}
+
+func testInvites(t *testing.T, tx store.TxRunner, invites store.InviteRepository) {
+	ctx := context.Background()
+
+	_, err := invites.GetByID(ctx, "inv_missing")
+	wantErr(t, "GetByID missing", err, derr.ErrNotFound)
+	_, err = invites.GetByToken(ctx, "NOPE1")
+	wantErr(t, "GetByToken missing", err, derr.ErrNotFound)
+
+	for _, inv := range []*models.Invite{
+		{InviteID: "inv_2", RoomID: "room_inv_1", Code: "CODE2", CreatedBy: "usr_inv", MaxUses: 2, CreatedAt: at(2), ExpiresAt: at(60)},
+		{InviteID: "inv_1", RoomID: "room_inv_1", Name: "For Bea", Code: "CODE1", LinkToken: "link_token_1", CreatedBy: "usr_inv", CreatedAt: at(1), ExpiresAt: at(60)},
+		{InviteID: "inv_3", RoomID: "room_inv_2", Code: "CODE3", CreatedBy: "usr_inv", MaxUses: 1, CreatedAt: at(3), ExpiresAt: at(60)},
+	} {
+		must(t, "Put "+inv.InviteID, invites.Put(ctx, inv))
+	}
+	wantErr(t, "Put duplicate code", invites.Put(ctx, &models.Invite{InviteID: "inv_4", RoomID: "room_inv_1", Code: "CODE1", CreatedBy: "usr_inv", CreatedAt: at(4), ExpiresAt: at(60)}), derr.ErrConflict)
+	wantErr(t, "Put duplicate link token", invites.Put(ctx, &models.Invite{InviteID: "inv_5", RoomID: "room_inv_1", Code: "CODE5", LinkToken: "link_token_1", CreatedBy: "usr_inv", CreatedAt: at(4), ExpiresAt: at(60)}), derr.ErrConflict)
+
+	got, err := invites.GetByToken(ctx, "link_token_1")
+	must(t, "GetByToken link", err)
+	if got.InviteID != "inv_1" || got.Name != "For Bea" || got.Code != "CODE1" || got.RoomID != "room_inv_1" || !got.ExpiresAt.Equal(at(60)) || got.RevokedAt != nil {
+		t.Fatalf("GetByToken link: unexpected %+v", got)
+	}
+	got, err = invites.GetByToken(ctx, "CODE2")
+	must(t, "GetByToken code", err)
+	if got.InviteID != "inv_2" || got.LinkToken != "" || got.MaxUses != 2 {
+		t.Fatalf("GetByToken code: unexpected %+v", got)
+	}
+
+	list, err := invites.ListByRoom(ctx, "room_inv_1")
+	must(t, "ListByRoom", err)
+	if len(list) != 2 || list[0].InviteID != "inv_1" || list[1].InviteID != "inv_2" {
+		t.Fatalf("ListByRoom: got %+v, want inv_1, inv_2", list)
+	}
+
+	// Uses stop at MaxUses; 0 allows any number.
+	must(t, "Use", invites.Use(ctx, "inv_2"))
+	must(t, "Use", invites.Use(ctx, "inv_2"))
+	wantErr(t, "Use exhausted", invites.Use(ctx, "inv_2"), derr.ErrConflict)
+	for i := 0; i < 3; i++ {
+		must(t, "Use unlimited", invites.Use(ctx, "inv_1"))
+	}
+	got, err = invites.GetByID(ctx, "inv_2")
+	must(t, "GetByID", err)
+	if got.Uses != 2 {
+		t.Fatalf("Use: got %d uses, want 2", got.Uses)
+	}
+	wantErr(t, "Use missing", invites.Use(ctx, "inv_missing"), derr.ErrConflict)
+
+	// Joins use invites inside a transaction.
+	use := func(id string) error {
+		return tx.WithTransaction(ctx, func(txctx context.Context) error { return invites.Use(txctx, id) })
+	}
+	must(t, "Use in tx", use("inv_3"))
+	wantErr(t, "Use in tx exhausted", use("inv_3"), derr.ErrConflict)
+
+	must(t, "Revoke", invites.Revoke(ctx, "inv_1", at(10)))
+	must(t, "Revoke again", invites.Revoke(ctx, "inv_1", at(20)))
+	wantErr(t, "Revoke missing", invites.Revoke(ctx, "inv_missing", at(10)), derr.ErrNotFound)
+	wantErr(t, "Use revoked", invites.Use(ctx, "inv_1"), derr.ErrConflict)
+	got, err = invites.GetByID(ctx, "inv_1")
+	must(t, "GetByID revoked", err)
+	if got.Uses != 3 || got.RevokedAt == nil || !got.RevokedAt.Equal(at(10)) {
+		t.Fatalf("Revoke: unexpected %+v", got)
+	}
+
+	if scanner, ok := invites.(store.InviteScanner); ok {
+		var ids []string
+		must(t, "ScanAll", scanner.ScanAll(ctx, func(inv models.Invite) error {
+			if inv.InviteID == got.InviteID && (inv.Uses != got.Uses || inv.RevokedAt == nil || !inv.RevokedAt.Equal(*got.RevokedAt)) {
+				t.Errorf("scanned invite: %+v", inv)
+			}
+			ids = append(ids, inv.InviteID)
+			return nil
+		}))
+		slices.Sort(ids)
+		if !slices.Equal(ids, []string{"inv_1", "inv_2", "inv_3"}) {
+			t.Fatalf("ScanAll: got %v", ids)
+		}
+	}
+
+	n, err := invites.DeleteByRoom(ctx, "room_inv_1")
+	must(t, "DeleteByRoom", err)
+	if n != 2 {
+		t.Fatalf("DeleteByRoom: removed %d, want 2", n)
+	}
+	list, err = invites.ListByRoom(ctx, "room_inv_1")
+	must(t, "ListByRoom after delete", err)
+	if len(list) != 0 {
+		t.Fatalf("ListByRoom after delete: got %+v", list)
+	}
+	_, err = invites.GetByID(ctx, "inv_3")
+	must(t, "GetByID other room", err)
+}
+
+func testCounters(t *testing.T, counters store.CounterRepository) {
+	ctx := context.Background()
+	// Expiries lie in the future so that a backend expiring counters by
+	// itself, like a Mongo TTL index, leaves them alone during the test.
+	later := func(minutes int) time.Time { return at(minutes).AddDate(100, 0, 0) }
+
+	n, err := counters.Get(ctx, "login:ana:1", later(0))
+	must(t, "Get missing", err)
+	if n != 0 {
+		t.Fatalf("Get missing: got %d, want 0", n)
+	}
+	for want := int64(1); want <= 3; want++ {
+		// Only the first increment sets the expiry.
+		n, err := counters.Incr(ctx, "login:ana:1", later(int(want)*10))
+		must(t, "Incr", err)
+		if n != want {
+			t.Fatalf("Incr: got %d, want %d", n, want)
+		}
+	}
+	_, err = counters.Incr(ctx, "login:bob:1", later(60))
+	must(t, "Incr other", err)
+
+	n, err = counters.Get(ctx, "login:ana:1", later(5))
+	must(t, "Get", err)
+	if n != 3 {
+		t.Fatalf("Get: got %d, want 3", n)
+	}
+	n, err = counters.Get(ctx, "login:ana:1", later(10))
+	must(t, "Get expired", err)
+	if n != 0 {
+		t.Fatalf("Get expired: got %d, want 0", n)
+	}
+
+	removed, err := counters.DeleteExpired(ctx, later(11))
+	must(t, "DeleteExpired", err)
+	if removed != 1 {
+		t.Fatalf("DeleteExpired: removed %d, want 1", removed)
+	}
+	n, err = counters.Incr(ctx, "login:ana:1", later(70))
+	must(t, "Incr after purge", err)
+	if n != 1 {
+		t.Fatalf("Incr after purge: got %d, want 1", n)
+	}
+
+	must(t, "Delete", counters.Delete(ctx, "login:bob:1"))
+	must(t, "Delete missing", counters.Delete(ctx, "login:bob:1"))
+	n, err = counters.Get(ctx, "login:bob:1", later(0))
+	must(t, "Get deleted", err)
+	if n != 0 {
+		t.Fatalf("Get deleted: got %d, want 0", n)
+	}
+}
@@ -249,30 +249,33 @@ func ensureListsTable(ctx context.Context, db *dynamodb.Client, table string) er
 }
 
 func ensureListItemsTable(ctx context.Context, db *dynamodb.Client, table string) error {
-    listIndex := "list_id_index"
-    if _, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table}); err == nil {
-        out, _ := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table})
-        has := false
-        if out != nil && out.Table.GlobalSecondaryIndexes != nil {
+    indexes := []struct{ name, attr string }{{"list_id_index", "list_id"}, {"room_id_index", "room_id"}}
+    if out, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &table}); err == nil {
+        for _, ix := range indexes {
+            has := false
             for _, g := range out.Table.GlobalSecondaryIndexes {
-                if g.IndexName != nil && *g.IndexName == listIndex { has = true }
+                if g.IndexName != nil && *g.IndexName == ix.name { has = true }
+            }
+            if !has {
+                _, _ = db.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+                    TableName:            &table,
+                    AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr(ix.attr), AttributeType: types.ScalarAttributeTypeS}},
+                    GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{Create: &types.CreateGlobalSecondaryIndexAction{IndexName: strPtr(ix.name), KeySchema: []types.KeySchemaElement{{AttributeName: strPtr(ix.attr), KeyType: types.KeyTypeHash}}, Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll}}}},
+                })
             }
-        }
-        if !has {
-            _, _ = db.UpdateTable(ctx, &dynamodb.UpdateTableInput{
-                TableName:            &table,
-                AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr("list_id"), AttributeType: types.ScalarAttributeTypeS}},
-                GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{Create: &types.CreateGlobalSecondaryIndexAction{IndexName: strPtr(listIndex), KeySchema: []types.KeySchemaElement{{AttributeName: strPtr("list_id"), KeyType: types.KeyTypeHash}}, Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll}}}},
-            })
         }
         return nil
     } else if !isNotFound(err) { return err }
+    gsis := make([]types.GlobalSecondaryIndex, 0, len(indexes))
+    for _, ix := range indexes {
+        gsis = append(gsis, types.GlobalSecondaryIndex{IndexName: strPtr(ix.name), KeySchema: []types.KeySchemaElement{{AttributeName: strPtr(ix.attr), KeyType: types.KeyTypeHash}}, Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll}})
+    }
     _, err := db.CreateTable(ctx, &dynamodb.CreateTableInput{
         TableName: &table,
-        AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr("item_id"), AttributeType: types.ScalarAttributeTypeS}, {AttributeName: strPtr("list_id"), AttributeType: types.ScalarAttributeTypeS}},
+        AttributeDefinitions: []types.AttributeDefinition{{AttributeName: strPtr("item_id"), AttributeType: types.ScalarAttributeTypeS}, {AttributeName: strPtr("list_id"), AttributeType: types.ScalarAttributeTypeS}, {AttributeName: strPtr("room_id"), AttributeType: types.ScalarAttributeTypeS}},
         KeySchema:  []types.KeySchemaElement{{AttributeName: strPtr("item_id"), KeyType: types.KeyTypeHash}},
         BillingMode: types.BillingModePayPerRequest,
-        GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{IndexName: strPtr(listIndex), KeySchema: []types.KeySchemaElement{{AttributeName: strPtr("list_id"), KeyType: types.KeyTypeHash}}, Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll}}},
+        GlobalSecondaryIndexes: gsis,
     })
     if err != nil { return err }
     waiter := dynamodb.NewTableExistsWaiter(db)
@@ -3,6 +3,8 @@ package memstore
 import (
 	"context"
 	"errors"
+	"maps"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -22,6 +24,17 @@ type Store struct {
 	byShareToken map[string]string // token -> roomID
 	lists        map[string]*models.List
 	items        map[string]*models.ListItem
+	migrations   map[int]models.MigrationRecord
+	jobs         map[string]*models.Job
+	sessions     map[string]*models.Session
+	userTokens   map[string]*models.UserToken
+	counters     map[string]counter
+	invites      map[string]*models.Invite
+}
+
+type counter struct {
+	count   int64
+	expires time.Time
 }
 
 func NewStore() *Store {
@@ -33,6 +46,12 @@ func NewStore() *Store {
 		byShareToken: map[string]string{},
 		lists:        map[string]*models.List{},
 		items:        map[string]*models.ListItem{},
+		migrations:   map[int]models.MigrationRecord{},
+		jobs:         map[string]*models.Job{},
+		sessions:     map[string]*models.Session{},
+		userTokens:   map[string]*models.UserToken{},
+		counters:     map[string]counter{},
+		invites:      map[string]*models.Invite{},
 	}
 }
 
@@ -49,6 +68,65 @@ func Compose() (tx store.TxRunner, users store.UserRepository, rooms store.RoomR
 	return Tx{}, &UserRepo{st}, &RoomRepo{st}, &ListRepo{st}, &ListItemRepo{st}
 }
 
+// NewUserRepo returns the user repository of st.
+func NewUserRepo(st *Store) *UserRepo { return &UserRepo{st} }
+
+// NewRoomRepo returns the room repository of st.
+func NewRoomRepo(st *Store) *RoomRepo { return &RoomRepo{st} }
+
+// NewListRepo returns the list repository of st.
+func NewListRepo(st *Store) *ListRepo { return &ListRepo{st} }
+
+// NewListItemRepo returns the item repository of st.
+func NewListItemRepo(st *Store) *ListItemRepo { return &ListItemRepo{st} }
+
+// NewMigrationRepo returns the migration log of st.
+func NewMigrationRepo(st *Store) *MigrationRepo { return &MigrationRepo{st} }
+
+// NewJobRepo returns the job queue of st.
+func NewJobRepo(st *Store) *JobRepo { return &JobRepo{st} }
+
+func NewSessionRepo(st *Store) *SessionRepo { return &SessionRepo{st} }
+
+func NewUserTokenRepo(st *Store) *UserTokenRepo { return &UserTokenRepo{st} }
+
+func NewCounterRepo(st *Store) *CounterRepo { return &CounterRepo{st} }
+
+func NewInviteRepo(st *Store) *InviteRepo { return &InviteRepo{st} }
+
+// cloneUser copies u including its room IDs.
+func cloneUser(u *models.User) models.User {
+	cp := *u
+	cp.RoomIDs = append([]string(nil), u.RoomIDs...)
+	return cp
+}
+
+// cloneRoom copies rm including its slice and map, so callers never share state with the store.
+func cloneRoom(rm *models.Room) models.Room {
+	cp := *rm
+	cp.MemberIDs = append([]string(nil), rm.MemberIDs...)
+	cp.DeletionVotes = cloneVotes(rm.DeletionVotes)
+	cp.Roles = maps.Clone(rm.Roles)
+	return cp
+}
+
+func cloneList(l *models.List) models.List {
+	cp := *l
+	cp.DeletionVotes = cloneVotes(l.DeletionVotes)
+	return cp
+}
+
+func cloneVotes(v map[string]string) map[string]string {
+	if v == nil {
+		return nil
+	}
+	out := make(map[string]string, len(v))
+	for k, ts := range v {
+		out[k] = ts
+	}
+	return out
+}
+
 // UserRepo
 type UserRepo struct{ st *Store }
 
@@ -58,7 +136,7 @@ func (r *UserRepo) Put(_ context.Context, u *models.User) error {
 	if _, ok := r.st.users[u.UserID]; ok {
 		return errors.New("exists")
 	}
-	cp := *u
+	cp := cloneUser(u)
 	r.st.users[u.UserID] = &cp
 	if u.Username != "" {
 		r.st.byUsername[u.Username] = u.UserID
@@ -72,7 +150,7 @@ func (r *UserRepo) GetByID(_ context.Context, id string) (*models.User, error) {
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	if u, ok := r.st.users[id]; ok {
-		cp := *u
+		cp := cloneUser(u)
 		return &cp, nil
 	}
 	return nil, derr.ErrNotFound
@@ -81,7 +159,7 @@ func (r *UserRepo) GetByUsername(_ context.Context, username string) (*models.Us
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	if id, ok := r.st.byUsername[username]; ok {
-		cp := *r.st.users[id]
+		cp := cloneUser(r.st.users[id])
 		return &cp, nil
 	}
 	return nil, derr.ErrNotFound
@@ -90,12 +168,12 @@ func (r *UserRepo) GetByAPIKeyLookup(_ context.Context, lookup string) (*models.
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	if id, ok := r.st.byLookup[lookup]; ok {
-		cp := *r.st.users[id]
+		cp := cloneUser(r.st.users[id])
 		return &cp, nil
 	}
 	return nil, derr.ErrUnauthorized
 }
-func (r *UserRepo) SetAPIKey(_ context.Context, userID string, hash, lookup string, _ *time.Time, updatedAt time.Time) error {
+func (r *UserRepo) SetAPIKey(_ context.Context, userID string, hash, lookup string, expiresAt *time.Time, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
 	u, ok := r.st.users[userID]
@@ -103,6 +181,10 @@ func (r *UserRepo) SetAPIKey(_ context.Context, userID string, hash, lookup stri
 		return derr.ErrNotFound
 	}
 	u.APIKeyHash = hash
+	if expiresAt != nil {
+		exp := *expiresAt
+		u.APIKeyExpiresAt = &exp
+	}
 	if lookup != "" {
 		if u.APIKeyLookup != "" {
 			delete(r.st.byLookup, u.APIKeyLookup)
@@ -113,6 +195,17 @@ func (r *UserRepo) SetAPIKey(_ context.Context, userID string, hash, lookup stri
 	u.UpdatedAt = updatedAt
 	return nil
 }
+func (r *UserRepo) ClearAPIKey(_ context.Context, userID, lookup string) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	u, ok := r.st.users[userID]
+	if !ok || u.APIKeyLookup != lookup {
+		return derr.ErrNotFound
+	}
+	delete(r.st.byLookup, lookup)
+	u.APIKeyHash, u.APIKeyLookup, u.APIKeyExpiresAt = "", "", nil
+	return nil
+}
 func (r *UserRepo) UpdateName(_ context.Context, userID string, name string, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
@@ -135,6 +228,31 @@ func (r *UserRepo) SetRoomID(_ context.Context, userID string, roomID *string, u
 	u.UpdatedAt = updatedAt
 	return nil
 }
+func (r *UserRepo) AddRoom(_ context.Context, userID string, roomID string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	u, ok := r.st.users[userID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	if slices.Contains(u.RoomIDs, roomID) {
+		return derr.ErrConflict
+	}
+	u.RoomIDs = append(append([]string{}, u.RoomIDs...), roomID)
+	u.UpdatedAt = updatedAt
+	return nil
+}
+func (r *UserRepo) RemoveRoom(_ context.Context, userID string, roomID string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	u, ok := r.st.users[userID]
+	if !ok || !slices.Contains(u.RoomIDs, roomID) {
+		return derr.ErrNotFound
+	}
+	u.RoomIDs = slices.DeleteFunc(append([]string{}, u.RoomIDs...), func(id string) bool { return id == roomID })
+	u.UpdatedAt = updatedAt
+	return nil
+}
 func (r *UserRepo) UpdateUsername(_ context.Context, userID string, username string, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
@@ -147,26 +265,73 @@ func (r *UserRepo) UpdateUsername(_ context.Context, userID string, username str
 	}
 	u.Username = username
 	r.st.byUsername[username] = userID
+	u.EmailVerifiedAt = nil
 	u.UpdatedAt = updatedAt
 	return nil
 }
+func (r *UserRepo) MarkEmailVerified(_ context.Context, userID, username string, at time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	u, ok := r.st.users[userID]
+	if !ok || u.Username != username {
+		return derr.ErrNotFound
+	}
+	u.EmailVerifiedAt = &at
+	return nil
+}
 func (r *UserRepo) UpdatePasswordEnc(_ context.Context, userID string, enc string, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
 	u, ok := r.st.users[userID]
 	if !ok {
-		return errors.New("not found")
+		return derr.ErrNotFound
 	}
 	u.PasswordEnc = enc
 	u.UpdatedAt = updatedAt
 	return nil
 }
+func (r *UserRepo) SetTOTPSecret(_ context.Context, userID, secretEnc string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	u, ok := r.st.users[userID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	u.TOTPSecretEnc, u.TOTPEnabledAt = secretEnc, nil
+	u.UpdatedAt = updatedAt
+	return nil
+}
+func (r *UserRepo) EnableTOTP(_ context.Context, userID, secretEnc string, at time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	u, ok := r.st.users[userID]
+	if !ok || u.TOTPSecretEnc != secretEnc {
+		return derr.ErrNotFound
+	}
+	u.TOTPEnabledAt = &at
+	return nil
+}
+func (r *UserRepo) ReencryptSecrets(_ context.Context, userID, oldPassword, newPassword, oldTOTP, newTOTP string) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	u, ok := r.st.users[userID]
+	if !ok || (oldPassword != "" && u.PasswordEnc != oldPassword) || (oldTOTP != "" && u.TOTPSecretEnc != oldTOTP) {
+		return derr.ErrNotFound
+	}
+	if oldPassword != "" {
+		u.PasswordEnc = newPassword
+	}
+	if oldTOTP != "" {
+		u.TOTPSecretEnc = newTOTP
+	}
+	return nil
+}
 func (r *UserRepo) Delete(_ context.Context, userID string) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
 	u, ok := r.st.users[userID]
 	if !ok {
-		return errors.New("not found")
+		return derr.ErrNotFound
 	}
 	if u.Username != "" {
 		delete(r.st.byUsername, u.Username)
@@ -178,6 +343,19 @@ func (r *UserRepo) Delete(_ context.Context, userID string) error {
 	return nil
 }
 
+// checkVersion enforces the ifVersion of a conditional update.
+func checkVersion(have, want int64) error {
+	if want != store.AnyVersion && have != want {
+		return derr.ErrPreconditionFailed
+	}
+	return nil
+}
+
+// ScanAll calls fn with a copy of every user; the lock is not held during fn.
+func (r *UserRepo) ScanAll(_ context.Context, fn func(u models.User) error) error {
+	return scanAll(r.st, r.st.users, cloneUser, fn)
+}
+
 // RoomRepo
 type RoomRepo struct{ st *Store }
 
@@ -187,7 +365,7 @@ func (r *RoomRepo) Put(_ context.Context, rm *models.Room) error {
 	if _, ok := r.st.rooms[rm.RoomID]; ok {
 		return errors.New("exists")
 	}
-	cp := *rm
+	cp := cloneRoom(rm)
 	if cp.DeletionVotes == nil {
 		cp.DeletionVotes = map[string]string{}
 	}
@@ -198,7 +376,7 @@ func (r *RoomRepo) GetByID(_ context.Context, id string) (*models.Room, error) {
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	if rm, ok := r.st.rooms[id]; ok {
-		cp := *rm
+		cp := cloneRoom(rm)
 		return &cp, nil
 	}
 	return nil, derr.ErrNotFound
@@ -207,7 +385,7 @@ func (r *RoomRepo) GetByShareToken(_ context.Context, token string) (*models.Roo
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	if id, ok := r.st.byShareToken[token]; ok {
-		cp := *r.st.rooms[id]
+		cp := cloneRoom(r.st.rooms[id])
 		return &cp, nil
 	}
 	return nil, derr.ErrNotFound
@@ -219,8 +397,12 @@ func (r *RoomRepo) SetShareToken(_ context.Context, roomID string, _ string, tok
 	if !ok {
 		return derr.ErrNotFound
 	}
+	if rm.ShareToken != nil {
+		delete(r.st.byShareToken, *rm.ShareToken)
+	}
 	rm.ShareToken = &token
 	rm.UpdatedAt = updatedAt
+	rm.Version++
 	r.st.byShareToken[token] = roomID
 	return nil
 }
@@ -236,42 +418,73 @@ func (r *RoomRepo) RemoveShareToken(_ context.Context, roomID string, updatedAt
 		rm.ShareToken = nil
 	}
 	rm.UpdatedAt = updatedAt
+	rm.Version++
 	return nil
 }
-func (r *RoomRepo) UpdateDescription(_ context.Context, roomID string, _ string, description string, updatedAt time.Time) error {
+func (r *RoomRepo) UpdateDescription(_ context.Context, roomID string, _ string, description string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
 	rm, ok := r.st.rooms[roomID]
 	if !ok {
 		return derr.ErrNotFound
 	}
+	if err := checkVersion(rm.Version, ifVersion); err != nil {
+		return err
+	}
 	rm.Description = description
 	rm.UpdatedAt = updatedAt
+	rm.Version++
 	return nil
 }
-func (r *RoomRepo) UpdateDisplayName(_ context.Context, roomID string, _ string, displayName string, updatedAt time.Time) error {
+func (r *RoomRepo) UpdateDisplayName(_ context.Context, roomID string, _ string, displayName string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
 	rm, ok := r.st.rooms[roomID]
 	if !ok {
 		return derr.ErrNotFound
 	}
+	if err := checkVersion(rm.Version, ifVersion); err != nil {
+		return err
+	}
 	rm.DisplayName = displayName
 	rm.UpdatedAt = updatedAt
+	rm.Version++
 	return nil
 }
+func (r *RoomRepo) Patch(_ context.Context, roomID string, _ string, p store.RoomPatch, ifVersion int64, updatedAt time.Time) (*models.Room, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	rm, ok := r.st.rooms[roomID]
+	if !ok {
+		return nil, derr.ErrNotFound
+	}
+	if err := checkVersion(rm.Version, ifVersion); err != nil {
+		return nil, err
+	}
+	if p.DisplayName != nil {
+		rm.DisplayName = *p.DisplayName
+	}
+	if p.Description != nil {
+		rm.Description = *p.Description
+	}
+	rm.UpdatedAt = updatedAt
+	rm.Version++
+	cp := cloneRoom(rm)
+	return &cp, nil
+}
 func (r *RoomRepo) VoteDeletion(_ context.Context, roomID string, userID string, ts time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
 	rm, ok := r.st.rooms[roomID]
 	if !ok {
-		return errors.New("not found")
+		return derr.ErrNotFound
 	}
 	if rm.DeletionVotes == nil {
 		rm.DeletionVotes = map[string]string{}
 	}
 	rm.DeletionVotes[userID] = ts.UTC().Format(time.RFC3339)
 	rm.UpdatedAt = ts
+	rm.Version++
 	return nil
 }
 func (r *RoomRepo) RemoveDeletionVote(_ context.Context, roomID string, userID string) error {
@@ -279,16 +492,24 @@ func (r *RoomRepo) RemoveDeletionVote(_ context.Context, roomID string, userID s
 	defer r.st.mu.Unlock()
 	rm, ok := r.st.rooms[roomID]
 	if !ok {
-		return errors.New("not found")
+		return derr.ErrNotFound
 	}
 	if rm.DeletionVotes != nil {
 		delete(rm.DeletionVotes, userID)
 	}
+	rm.Version++
 	return nil
 }
 func (r *RoomRepo) Delete(_ context.Context, roomID string) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
+	rm, ok := r.st.rooms[roomID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	if rm.ShareToken != nil {
+		delete(r.st.byShareToken, *rm.ShareToken)
+	}
 	delete(r.st.rooms, roomID)
 	return nil
 }
@@ -297,16 +518,16 @@ func (r *RoomRepo) AddMember(_ context.Context, roomID string, userID string, up
 	defer r.st.mu.Unlock()
 	rm, ok := r.st.rooms[roomID]
 	if !ok {
-		return errors.New("not found")
+		return derr.ErrNotFound
 	}
 	for _, id := range rm.MemberIDs {
 		if id == userID {
-			return errors.New("conflict")
+			return derr.ErrConflict
 		}
 	}
-	rm.MemberIDs = append(rm.MemberIDs, userID)
-	sort.Strings(rm.MemberIDs)
+	rm.MemberIDs = append(append([]string{}, rm.MemberIDs...), userID)
 	rm.UpdatedAt = updatedAt
+	rm.Version++
 	return nil
 }
 func (r *RoomRepo) RemoveMember(_ context.Context, roomID string, userID string, updatedAt time.Time) error {
@@ -314,26 +535,68 @@ func (r *RoomRepo) RemoveMember(_ context.Context, roomID string, userID string,
 	defer r.st.mu.Unlock()
 	rm, ok := r.st.rooms[roomID]
 	if !ok {
-		return errors.New("not found")
+		return derr.ErrNotFound
 	}
-	filtered := rm.MemberIDs[:0]
+	filtered := make([]string, 0, len(rm.MemberIDs))
 	for _, id := range rm.MemberIDs {
 		if id != userID {
 			filtered = append(filtered, id)
 		}
 	}
+	if len(filtered) == len(rm.MemberIDs) {
+		return derr.ErrNotFound
+	}
 	rm.MemberIDs = filtered
+	delete(rm.Roles, userID)
+	rm.UpdatedAt = updatedAt
+	rm.Version++
+	return nil
+}
+func (r *RoomRepo) SetRoles(_ context.Context, roomID string, roles map[string]models.Role, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	rm, ok := r.st.rooms[roomID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	for uid := range roles {
+		if !slices.Contains(rm.MemberIDs, uid) {
+			return derr.ErrNotFound
+		}
+	}
+	if rm.Roles == nil {
+		rm.Roles = map[string]models.Role{}
+	}
+	maps.Copy(rm.Roles, roles)
 	rm.UpdatedAt = updatedAt
+	rm.Version++
 	return nil
 }
 
-// ListRepo (minimal implementation for cleanup tests)
+// ScanAll calls fn with a copy of every room; the lock is not held during fn.
+func (r *RoomRepo) ScanAll(_ context.Context, fn func(rm models.Room) error) error {
+	return scanAll(r.st, r.st.rooms, cloneRoom, fn)
+}
+
+// ListRepo
 type ListRepo struct{ st *Store }
 
+// live returns the list unless it is missing or soft-deleted. Callers hold the lock.
+func (r *ListRepo) live(listID string) (*models.List, error) {
+	l, ok := r.st.lists[listID]
+	if !ok || l.IsDeleted {
+		return nil, derr.ErrNotFound
+	}
+	return l, nil
+}
+
 func (r *ListRepo) Put(_ context.Context, l *models.List) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	cp := *l
+	if _, ok := r.st.lists[l.ListID]; ok {
+		return errors.New("exists")
+	}
+	cp := cloneList(l)
 	r.st.lists[l.ListID] = &cp
 	return nil
 }
@@ -341,121 +604,258 @@ func (r *ListRepo) GetByID(_ context.Context, id string) (*models.List, error) {
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	if l, ok := r.st.lists[id]; ok {
-		cp := *l
+		cp := cloneList(l)
 		return &cp, nil
 	}
 	return nil, derr.ErrNotFound
 }
-func (r *ListRepo) ListByRoom(_ context.Context, roomID string) ([]models.List, error) {
+func (r *ListRepo) ListByRoom(_ context.Context, roomID string, page store.Page) ([]models.List, string, error) {
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	out := []models.List{}
 	for _, l := range r.st.lists {
-		if l.RoomID == roomID {
-			cp := *l
+		if l.RoomID == roomID && !l.IsDeleted {
+			cp := cloneList(l)
 			out = append(out, cp)
 		}
 	}
-	return out, nil
+	return store.Paginate(out, page, store.ListKey, store.CompareAscending)
 }
-func (r *ListRepo) UpdateName(_ context.Context, listID string, name string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateName(_ context.Context, listID string, name string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	l, ok := r.st.lists[listID]
-	if !ok {
-		return derr.ErrNotFound
+	l, err := r.live(listID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(l.Version, ifVersion); err != nil {
+		return err
 	}
 	l.Name = name
 	l.UpdatedAt = updatedAt
+	l.Version++
 	return nil
 }
-func (r *ListRepo) UpdateDescription(_ context.Context, listID string, description string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateDescription(_ context.Context, listID string, description string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	l, ok := r.st.lists[listID]
-	if !ok {
-		return derr.ErrNotFound
+	l, err := r.live(listID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(l.Version, ifVersion); err != nil {
+		return err
 	}
 	l.Description = description
 	l.UpdatedAt = updatedAt
+	l.Version++
 	return nil
 }
-func (r *ListRepo) UpdateNotes(_ context.Context, listID string, notes string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateNotes(_ context.Context, listID string, notes string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	l, ok := r.st.lists[listID]
-	if !ok {
-		return derr.ErrNotFound
+	l, err := r.live(listID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(l.Version, ifVersion); err != nil {
+		return err
 	}
 	l.Notes = notes
 	l.UpdatedAt = updatedAt
+	l.Version++
 	return nil
 }
-func (r *ListRepo) UpdateIcon(_ context.Context, listID string, icon string, updatedAt time.Time) error {
+func (r *ListRepo) UpdateIcon(_ context.Context, listID string, icon string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	l, ok := r.st.lists[listID]
-	if !ok {
-		return errors.New("not found")
+	l, err := r.live(listID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(l.Version, ifVersion); err != nil {
+		return err
 	}
 	l.Icon = icon
 	l.UpdatedAt = updatedAt
+	l.Version++
 	return nil
 }
+func (r *ListRepo) Patch(_ context.Context, listID string, p store.ListPatch, ifVersion int64, updatedAt time.Time) (*models.List, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	l, err := r.live(listID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(l.Version, ifVersion); err != nil {
+		return nil, err
+	}
+	if p.Name != nil {
+		l.Name = *p.Name
+	}
+	if p.Description != nil {
+		l.Description = *p.Description
+	}
+	if p.Notes != nil {
+		l.Notes = *p.Notes
+	}
+	if p.Icon != nil {
+		l.Icon = *p.Icon
+	}
+	l.UpdatedAt = updatedAt
+	l.Version++
+	cp := cloneList(l)
+	return &cp, nil
+}
 func (r *ListRepo) AddDeletionVote(_ context.Context, listID string, userID string, ts time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	l, ok := r.st.lists[listID]
-	if !ok {
-		return errors.New("not found")
+	l, err := r.live(listID)
+	if err != nil {
+		return err
 	}
 	if l.DeletionVotes == nil {
 		l.DeletionVotes = map[string]string{}
 	}
 	l.DeletionVotes[userID] = ts.UTC().Format(time.RFC3339)
 	l.UpdatedAt = ts
+	l.Version++
 	return nil
 }
 func (r *ListRepo) RemoveDeletionVote(_ context.Context, listID string, userID string) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	l, ok := r.st.lists[listID]
-	if !ok {
-		return errors.New("not found")
+	l, err := r.live(listID)
+	if err != nil {
+		return err
 	}
 	if l.DeletionVotes != nil {
 		delete(l.DeletionVotes, userID)
 	}
+	l.Version++
 	return nil
 }
 func (r *ListRepo) FinalizeDeleteIfVotedByAll(_ context.Context, listID string, memberIDs []string, ts time.Time) (bool, error) {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	l, ok := r.st.lists[listID]
-	if !ok {
-		return false, derr.ErrNotFound
+	l, err := r.live(listID)
+	if err != nil {
+		return false, nil
 	}
 	for _, id := range memberIDs {
 		if l.DeletionVotes[id] == "" {
 			return false, nil
 		}
 	}
-	delete(r.st.lists, listID)
+	l.IsDeleted = true
+	l.UpdatedAt = ts
+	l.Version++
 	return true, nil
 }
+func (r *ListRepo) Restore(_ context.Context, listID string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	l, ok := r.st.lists[listID]
+	if !ok || !l.IsDeleted {
+		return derr.ErrNotFound
+	}
+	l.IsDeleted = false
+	l.DeletionVotes = map[string]string{}
+	l.UpdatedAt = updatedAt
+	l.Version++
+	return nil
+}
+
+// trashed returns copies of the soft-deleted lists matching keep, least
+// recently deleted first. Callers hold the lock.
+func (r *ListRepo) trashed(keep func(l *models.List) bool) []models.List {
+	out := []models.List{}
+	for _, l := range r.st.lists {
+		if l.IsDeleted && keep(l) {
+			out = append(out, cloneList(l))
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].UpdatedAt.Before(out[j].UpdatedAt)
+	})
+	return out
+}
+
+func (r *ListRepo) MoveToRoom(_ context.Context, listID string, roomID string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	l, ok := r.st.lists[listID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	l.RoomID = roomID
+	l.DeletionVotes = map[string]string{}
+	l.UpdatedAt = updatedAt
+	l.Version++
+	return nil
+}
+
+func (r *ListRepo) ListTrashedByRoom(_ context.Context, roomID string) ([]models.List, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	out := r.trashed(func(l *models.List) bool { return l.RoomID == roomID })
+	slices.Reverse(out)
+	return out, nil
+}
+
+func (r *ListRepo) ListDeletedBefore(_ context.Context, cutoff time.Time) ([]models.List, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	return r.trashed(func(l *models.List) bool { return l.UpdatedAt.Before(cutoff) }), nil
+}
+
 func (r *ListRepo) Delete(_ context.Context, listID string) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
+	if _, ok := r.st.lists[listID]; !ok {
+		return derr.ErrNotFound
+	}
 	delete(r.st.lists, listID)
 	return nil
 }
 
-// ListItemRepo (minimal)
+func (r *ListRepo) DeleteByRoom(_ context.Context, roomID string) (int, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for id, l := range r.st.lists {
+		if l.RoomID == roomID {
+			delete(r.st.lists, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ScanAll calls fn with a copy of every list; the lock is not held during fn.
+func (r *ListRepo) ScanAll(_ context.Context, fn func(l models.List) error) error {
+	return scanAll(r.st, r.st.lists, cloneList, fn)
+}
+
+// ListItemRepo
 type ListItemRepo struct{ st *Store }
 
+// live returns the item unless it is missing or trashed. Callers hold the lock.
+func (r *ListItemRepo) live(itemID string) (*models.ListItem, error) {
+	it, ok := r.st.items[itemID]
+	if !ok || it.IsDeleted {
+		return nil, derr.ErrNotFound
+	}
+	return it, nil
+}
+
 func (r *ListItemRepo) Put(_ context.Context, it *models.ListItem) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
+	if _, ok := r.st.items[it.ItemID]; ok {
+		return errors.New("exists")
+	}
 	cp := *it
 	r.st.items[it.ItemID] = &cp
 	return nil
@@ -469,142 +869,806 @@ func (r *ListItemRepo) GetByID(_ context.Context, id string) (*models.ListItem,
 	}
 	return nil, derr.ErrNotFound
 }
-func (r *ListItemRepo) ListByList(_ context.Context, listID string) ([]models.ListItem, error) {
+func (r *ListItemRepo) ListByList(_ context.Context, listID string, page store.Page) ([]models.ListItem, string, error) {
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	out := []models.ListItem{}
 	for _, it := range r.st.items {
-		if it.ListID == listID {
+		if it.ListID == listID && !it.IsDeleted {
 			cp := *it
 			out = append(out, cp)
 		}
 	}
-	// Sort by Order ascending, then CreatedAt as fallback
-	sort.SliceStable(out, func(i, j int) bool {
-		oi, oj := out[i].Order, out[j].Order
-		if oi == 0 && oj == 0 {
-			return out[i].CreatedAt.Before(out[j].CreatedAt)
-		}
-		if oi == oj {
-			return out[i].CreatedAt.Before(out[j].CreatedAt)
-		}
-		return oi < oj
-	})
-	return out, nil
+	return store.Paginate(out, page, store.ItemKey, store.CompareAscending)
 }
-func (r *ListItemRepo) UpdateCompletion(_ context.Context, itemID string, completed bool, updatedAt time.Time) error {
+func (r *ListItemRepo) UpdateCompletion(_ context.Context, itemID string, completed bool, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	it, ok := r.st.items[itemID]
-	if !ok {
-		return derr.ErrNotFound
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return err
 	}
 	it.Completed = completed
 	it.UpdatedAt = updatedAt
+	it.Version++
 	return nil
 }
-func (r *ListItemRepo) UpdateDescription(_ context.Context, itemID string, description string, updatedAt time.Time) error {
+func (r *ListItemRepo) UpdateDescription(_ context.Context, itemID string, description string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	it, ok := r.st.items[itemID]
-	if !ok {
-		return derr.ErrNotFound
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return err
 	}
 	it.Description = description
 	it.UpdatedAt = updatedAt
+	it.Version++
 	return nil
 }
-func (r *ListItemRepo) UpdateOrder(_ context.Context, itemID string, order float64, updatedAt time.Time) error {
+func (r *ListItemRepo) UpdateOrder(_ context.Context, itemID string, order float64, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	it, ok := r.st.items[itemID]
-	if !ok {
-		return derr.ErrNotFound
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return err
 	}
 	it.Order = order
 	it.UpdatedAt = updatedAt
+	it.Version++
 	return nil
 }
 
-func (r *ListItemRepo) UpdateQuantity(_ context.Context, itemID string, quantity string, updatedAt time.Time) error {
+func (r *ListItemRepo) UpdateQuantity(_ context.Context, itemID string, quantity string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	it, ok := r.st.items[itemID]
-	if !ok {
-		return derr.ErrNotFound
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return err
 	}
 	it.Quantity = quantity
 	it.UpdatedAt = updatedAt
+	it.Version++
 	return nil
 }
 
-func (r *ListItemRepo) UpdateUnit(_ context.Context, itemID string, unit string, updatedAt time.Time) error {
+func (r *ListItemRepo) UpdateUnit(_ context.Context, itemID string, unit string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	it, ok := r.st.items[itemID]
-	if !ok {
-		return derr.ErrNotFound
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return err
 	}
 	it.Unit = unit
 	it.UpdatedAt = updatedAt
+	it.Version++
 	return nil
 }
 
-func (r *ListItemRepo) UpdateCategory(_ context.Context, itemID string, category string, updatedAt time.Time) error {
+func (r *ListItemRepo) UpdateCategory(_ context.Context, itemID string, category string, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	it, ok := r.st.items[itemID]
-	if !ok {
-		return derr.ErrNotFound
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return err
 	}
 	it.Category = category
 	it.UpdatedAt = updatedAt
+	it.Version++
 	return nil
 }
 
-func (r *ListItemRepo) UpdateStarred(_ context.Context, itemID string, starred bool, updatedAt time.Time) error {
+func (r *ListItemRepo) UpdateStarred(_ context.Context, itemID string, starred bool, ifVersion int64, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
-	it, ok := r.st.items[itemID]
-	if !ok {
-		return derr.ErrNotFound
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return err
 	}
 	it.IsStarred = starred
 	it.UpdatedAt = updatedAt
+	it.Version++
 	return nil
 }
 
+func (r *ListItemRepo) Patch(_ context.Context, itemID string, p store.ItemPatch, ifVersion int64, updatedAt time.Time) (*models.ListItem, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	it, err := r.live(itemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(it.Version, ifVersion); err != nil {
+		return nil, err
+	}
+	if p.Description != nil {
+		it.Description = *p.Description
+	}
+	if p.Completed != nil {
+		it.Completed = *p.Completed
+	}
+	if p.Quantity != nil {
+		it.Quantity = *p.Quantity
+	}
+	if p.Unit != nil {
+		it.Unit = *p.Unit
+	}
+	if p.Category != nil {
+		it.Category = *p.Category
+	}
+	if p.Starred != nil {
+		it.IsStarred = *p.Starred
+	}
+	it.UpdatedAt = updatedAt
+	it.Version++
+	cp := *it
+	return &cp, nil
+}
 func (r *ListItemRepo) ArchiveCompletedByList(_ context.Context, listID string, updatedAt time.Time) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
 	for _, it := range r.st.items {
-		if it.ListID == listID && it.Completed && !it.IsArchived {
+		if it.ListID == listID && it.Completed && !it.IsArchived && !it.IsDeleted {
 			it.IsArchived = true
 			it.UpdatedAt = updatedAt
+			it.Version++
 		}
 	}
 	return nil
 }
-func (r *ListItemRepo) ListArchivedByRoom(_ context.Context, roomID string) ([]models.ListItem, error) {
+func (r *ListItemRepo) ListArchivedByRoom(_ context.Context, roomID string, page store.Page) ([]models.ListItem, string, error) {
 	r.st.mu.RLock()
 	defer r.st.mu.RUnlock()
 	out := []models.ListItem{}
 	for _, it := range r.st.items {
-		if it.RoomID == roomID && it.IsArchived {
+		if it.RoomID == roomID && it.IsArchived && !it.IsDeleted {
 			cp := *it
 			out = append(out, cp)
 		}
 	}
-	// Sort by UpdatedAt descending
+	return store.Paginate(out, page, store.ArchivedKey, store.CompareNewestFirst)
+}
+
+func (r *ListItemRepo) SoftDelete(_ context.Context, itemID string, ts time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	it, err := r.live(itemID)
+	if err != nil {
+		return err
+	}
+	it.IsDeleted = true
+	it.UpdatedAt = ts
+	it.Version++
+	return nil
+}
+
+func (r *ListItemRepo) Restore(_ context.Context, itemID string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	it, ok := r.st.items[itemID]
+	if !ok || !it.IsDeleted {
+		return derr.ErrNotFound
+	}
+	it.IsDeleted = false
+	it.UpdatedAt = updatedAt
+	it.Version++
+	return nil
+}
+
+func (r *ListItemRepo) ListTrashedByRoom(_ context.Context, roomID string) ([]models.ListItem, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	out := []models.ListItem{}
+	for _, it := range r.st.items {
+		if it.RoomID == roomID && it.IsDeleted {
+			out = append(out, *it)
+		}
+	}
 	sort.SliceStable(out, func(i, j int) bool {
 		return out[i].UpdatedAt.After(out[j].UpdatedAt)
 	})
 	return out, nil
 }
 
+func (r *ListItemRepo) PurgeDeletedBefore(_ context.Context, cutoff time.Time) (int, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for id, it := range r.st.items {
+		if it.IsDeleted && it.UpdatedAt.Before(cutoff) {
+			delete(r.st.items, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
 func (r *ListItemRepo) Delete(_ context.Context, itemID string) error {
 	r.st.mu.Lock()
 	defer r.st.mu.Unlock()
+	if _, ok := r.st.items[itemID]; !ok {
+		return derr.ErrNotFound
+	}
 	delete(r.st.items, itemID)
 	return nil
 }
+
+func (r *ListItemRepo) DeleteByList(_ context.Context, listID string) (int, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for id, it := range r.st.items {
+		if it.ListID == listID {
+			delete(r.st.items, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *ListItemRepo) MoveByList(_ context.Context, fromListID, toListID, roomID string, orderOffset float64, updatedAt time.Time) (int, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for _, it := range r.st.items {
+		if it.ListID == fromListID {
+			it.ListID, it.RoomID = toListID, roomID
+			it.Order += orderOffset
+			it.UpdatedAt = updatedAt
+			it.Version++
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *ListItemRepo) DeleteByRoom(_ context.Context, roomID string) (int, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for id, it := range r.st.items {
+		if it.RoomID == roomID {
+			delete(r.st.items, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ScanAll calls fn with a copy of every item; the lock is not held during fn.
+func (r *ListItemRepo) ScanAll(ctx context.Context, fn func(it models.ListItem) error) error {
+	return scanAll(r.st, r.st.items, func(it *models.ListItem) models.ListItem { return *it }, fn)
+}
+
+// scanAll copies every record of m under the read lock, then calls fn with
+// each copy after releasing it.
+func scanAll[T any](st *Store, m map[string]*T, clone func(*T) T, fn func(T) error) error {
+	st.mu.RLock()
+	all := make([]T, 0, len(m))
+	for _, v := range m {
+		all = append(all, clone(v))
+	}
+	st.mu.RUnlock()
+	for _, v := range all {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrationRepo implements store.MigrationRepository.
+type MigrationRepo struct{ st *Store }
+
+func (r *MigrationRepo) ListApplied(_ context.Context) ([]models.MigrationRecord, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	out := make([]models.MigrationRecord, 0, len(r.st.migrations))
+	for _, rec := range r.st.migrations {
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func (r *MigrationRepo) Record(_ context.Context, rec models.MigrationRecord) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	if _, ok := r.st.migrations[rec.Version]; ok {
+		return derr.ErrConflict
+	}
+	r.st.migrations[rec.Version] = rec
+	return nil
+}
+
+// JobRepo implements store.JobRepository.
+type JobRepo struct{ st *Store }
+
+func cloneJob(j *models.Job) models.Job {
+	cp := *j
+	if j.Payload != nil {
+		cp.Payload = make(map[string]string, len(j.Payload))
+		for k, v := range j.Payload {
+			cp.Payload[k] = v
+		}
+	}
+	return cp
+}
+
+// leased returns the job if it is still held under attempt. Callers hold the lock.
+func (r *JobRepo) leased(jobID string, attempt int) (*models.Job, error) {
+	j, ok := r.st.jobs[jobID]
+	if !ok {
+		return nil, derr.ErrNotFound
+	}
+	if j.Attempts != attempt || j.Status != models.JobRunning {
+		return nil, derr.ErrConflict
+	}
+	return j, nil
+}
+
+func (r *JobRepo) Enqueue(_ context.Context, j *models.Job) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	if _, ok := r.st.jobs[j.JobID]; ok {
+		return derr.ErrConflict
+	}
+	cp := cloneJob(j)
+	r.st.jobs[j.JobID] = &cp
+	return nil
+}
+
+func (r *JobRepo) GetByID(_ context.Context, id string) (*models.Job, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	j, ok := r.st.jobs[id]
+	if !ok {
+		return nil, derr.ErrNotFound
+	}
+	cp := cloneJob(j)
+	return &cp, nil
+}
+
+func (r *JobRepo) ClaimNext(_ context.Context, now, leaseUntil time.Time) (*models.Job, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	var next *models.Job
+	for _, j := range r.st.jobs {
+		if j.Status == models.JobDead || j.RunAt.After(now) {
+			continue
+		}
+		if next == nil || j.RunAt.Before(next.RunAt) || (j.RunAt.Equal(next.RunAt) && j.JobID < next.JobID) {
+			next = j
+		}
+	}
+	if next == nil {
+		return nil, derr.ErrNotFound
+	}
+	next.Status = models.JobRunning
+	next.RunAt = leaseUntil
+	next.Attempts++
+	next.UpdatedAt = now
+	cp := cloneJob(next)
+	return &cp, nil
+}
+
+func (r *JobRepo) Complete(_ context.Context, jobID string, attempt int) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	if _, err := r.leased(jobID, attempt); err != nil {
+		return err
+	}
+	delete(r.st.jobs, jobID)
+	return nil
+}
+
+func (r *JobRepo) Retry(_ context.Context, jobID string, attempt int, runAt time.Time, lastErr string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	j, err := r.leased(jobID, attempt)
+	if err != nil {
+		return err
+	}
+	j.Status = models.JobPending
+	j.RunAt = runAt
+	j.LastError = lastErr
+	j.UpdatedAt = updatedAt
+	return nil
+}
+
+func (r *JobRepo) Bury(_ context.Context, jobID string, attempt int, lastErr string, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	j, err := r.leased(jobID, attempt)
+	if err != nil {
+		return err
+	}
+	j.Status = models.JobDead
+	j.LastError = lastErr
+	j.UpdatedAt = updatedAt
+	return nil
+}
+
+func (r *JobRepo) ListDead(_ context.Context) ([]models.Job, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	out := []models.Job{}
+	for _, j := range r.st.jobs {
+		if j.Status == models.JobDead {
+			out = append(out, cloneJob(j))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].UpdatedAt.Equal(out[j].UpdatedAt) {
+			return out[i].UpdatedAt.Before(out[j].UpdatedAt)
+		}
+		return out[i].JobID < out[j].JobID
+	})
+	return out, nil
+}
+
+func (r *JobRepo) Requeue(_ context.Context, jobID string, runAt time.Time, updatedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	j, ok := r.st.jobs[jobID]
+	if !ok || j.Status != models.JobDead {
+		return derr.ErrNotFound
+	}
+	j.Status = models.JobPending
+	j.Attempts = 0
+	j.RunAt = runAt
+	j.UpdatedAt = updatedAt
+	return nil
+}
+
+// SessionRepo implements store.SessionRepository.
+type SessionRepo struct{ st *Store }
+
+func cloneSession(s *models.Session) models.Session {
+	cp := *s
+	if s.ExpiresAt != nil {
+		exp := *s.ExpiresAt
+		cp.ExpiresAt = &exp
+	}
+	return cp
+}
+
+func (r *SessionRepo) Create(_ context.Context, s *models.Session) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	if _, ok := r.st.sessions[s.SessionID]; ok {
+		return derr.ErrConflict
+	}
+	for _, o := range r.st.sessions {
+		if s.KeyLookup != "" && o.KeyLookup == s.KeyLookup {
+			return derr.ErrConflict
+		}
+	}
+	cp := cloneSession(s)
+	r.st.sessions[s.SessionID] = &cp
+	return nil
+}
+
+func (r *SessionRepo) GetByID(_ context.Context, id string) (*models.Session, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	s, ok := r.st.sessions[id]
+	if !ok {
+		return nil, derr.ErrNotFound
+	}
+	cp := cloneSession(s)
+	return &cp, nil
+}
+
+func (r *SessionRepo) GetByKeyLookup(_ context.Context, lookup string) (*models.Session, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	for _, s := range r.st.sessions {
+		if lookup != "" && s.KeyLookup == lookup {
+			cp := cloneSession(s)
+			return &cp, nil
+		}
+	}
+	return nil, derr.ErrNotFound
+}
+
+func (r *SessionRepo) ListByUser(_ context.Context, userID string) ([]models.Session, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	var out []models.Session
+	for _, s := range r.st.sessions {
+		if s.UserID == userID {
+			out = append(out, cloneSession(s))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].LastUsedAt.Equal(out[j].LastUsedAt) {
+			return out[i].LastUsedAt.After(out[j].LastUsedAt)
+		}
+		return out[i].SessionID < out[j].SessionID
+	})
+	return out, nil
+}
+
+func (r *SessionRepo) Touch(_ context.Context, sessionID string, lastUsedAt time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	s, ok := r.st.sessions[sessionID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	s.LastUsedAt = lastUsedAt
+	return nil
+}
+
+func (r *SessionRepo) Rotate(_ context.Context, sessionID string, gen int64, lastUsedAt time.Time, expiresAt *time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	s, ok := r.st.sessions[sessionID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	if s.RefreshGen != gen {
+		return derr.ErrConflict
+	}
+	s.RefreshGen++
+	s.LastUsedAt = lastUsedAt
+	s.ExpiresAt = nil
+	if expiresAt != nil {
+		exp := *expiresAt
+		s.ExpiresAt = &exp
+	}
+	return nil
+}
+
+func (r *SessionRepo) Delete(_ context.Context, sessionID string) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	if _, ok := r.st.sessions[sessionID]; !ok {
+		return derr.ErrNotFound
+	}
+	delete(r.st.sessions, sessionID)
+	return nil
+}
+
+func (r *SessionRepo) deleteWhere(match func(s *models.Session) bool) int {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for id, s := range r.st.sessions {
+		if match(s) {
+			delete(r.st.sessions, id)
+			n++
+		}
+	}
+	return n
+}
+
+func (r *SessionRepo) DeleteByUser(_ context.Context, userID string) (int, error) {
+	return r.deleteWhere(func(s *models.Session) bool { return s.UserID == userID }), nil
+}
+
+func (r *SessionRepo) DeleteExpired(_ context.Context, cutoff time.Time) (int, error) {
+	return r.deleteWhere(func(s *models.Session) bool { return s.ExpiresAt != nil && s.ExpiresAt.Before(cutoff) }), nil
+}
+
+// UserTokenRepo implements store.UserTokenRepository.
+type UserTokenRepo struct{ st *Store }
+
+func (r *UserTokenRepo) Create(_ context.Context, t *models.UserToken) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	if _, ok := r.st.userTokens[t.TokenHash]; ok {
+		return derr.ErrConflict
+	}
+	cp := *t
+	r.st.userTokens[t.TokenHash] = &cp
+	return nil
+}
+
+func (r *UserTokenRepo) Consume(_ context.Context, hash, purpose string) (*models.UserToken, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	t, ok := r.st.userTokens[hash]
+	if !ok || t.Purpose != purpose {
+		return nil, derr.ErrNotFound
+	}
+	delete(r.st.userTokens, hash)
+	return t, nil
+}
+
+func (r *UserTokenRepo) deleteWhere(match func(t *models.UserToken) bool) int {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for hash, t := range r.st.userTokens {
+		if match(t) {
+			delete(r.st.userTokens, hash)
+			n++
+		}
+	}
+	return n
+}
+
+func (r *UserTokenRepo) DeleteByUser(_ context.Context, userID, purpose string) (int, error) {
+	return r.deleteWhere(func(t *models.UserToken) bool { return t.UserID == userID && t.Purpose == purpose }), nil
+}
+
+func (r *UserTokenRepo) DeleteExpired(_ context.Context, cutoff time.Time) (int, error) {
+	return r.deleteWhere(func(t *models.UserToken) bool { return t.ExpiresAt.Before(cutoff) }), nil
+}
+
+// ScanAll calls fn with a copy of every token; the lock is not held during fn.
+func (r *UserTokenRepo) ScanAll(_ context.Context, fn func(t models.UserToken) error) error {
+	return scanAll(r.st, r.st.userTokens, func(t *models.UserToken) models.UserToken { return *t }, fn)
+}
+
+// CounterRepo implements store.CounterRepository.
+type CounterRepo struct{ st *Store }
+
+func (r *CounterRepo) Incr(_ context.Context, key string, expiresAt time.Time) (int64, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	c, ok := r.st.counters[key]
+	if !ok {
+		c.expires = expiresAt
+	}
+	c.count++
+	r.st.counters[key] = c
+	return c.count, nil
+}
+
+func (r *CounterRepo) Get(_ context.Context, key string, now time.Time) (int64, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	c, ok := r.st.counters[key]
+	if !ok || !now.Before(c.expires) {
+		return 0, nil
+	}
+	return c.count, nil
+}
+
+func (r *CounterRepo) Delete(_ context.Context, key string) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	delete(r.st.counters, key)
+	return nil
+}
+
+func (r *CounterRepo) DeleteExpired(_ context.Context, cutoff time.Time) (int, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for key, c := range r.st.counters {
+		if c.expires.Before(cutoff) {
+			delete(r.st.counters, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// InviteRepo implements store.InviteRepository.
+type InviteRepo struct{ st *Store }
+
+func cloneInvite(inv *models.Invite) *models.Invite {
+	cp := *inv
+	if inv.RevokedAt != nil {
+		t := *inv.RevokedAt
+		cp.RevokedAt = &t
+	}
+	return &cp
+}
+
+func (r *InviteRepo) Put(_ context.Context, inv *models.Invite) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	for id, cur := range r.st.invites {
+		if id == inv.InviteID || cur.Code == inv.Code || (inv.LinkToken != "" && cur.LinkToken == inv.LinkToken) {
+			return derr.ErrConflict
+		}
+	}
+	r.st.invites[inv.InviteID] = cloneInvite(inv)
+	return nil
+}
+
+func (r *InviteRepo) GetByID(_ context.Context, inviteID string) (*models.Invite, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	inv, ok := r.st.invites[inviteID]
+	if !ok {
+		return nil, derr.ErrNotFound
+	}
+	return cloneInvite(inv), nil
+}
+
+func (r *InviteRepo) GetByToken(_ context.Context, token string) (*models.Invite, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	for _, inv := range r.st.invites {
+		if token != "" && (inv.Code == token || inv.LinkToken == token) {
+			return cloneInvite(inv), nil
+		}
+	}
+	return nil, derr.ErrNotFound
+}
+
+func (r *InviteRepo) ListByRoom(_ context.Context, roomID string) ([]models.Invite, error) {
+	r.st.mu.RLock()
+	defer r.st.mu.RUnlock()
+	var out []models.Invite
+	for _, inv := range r.st.invites {
+		if inv.RoomID == roomID {
+			out = append(out, *cloneInvite(inv))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].InviteID < out[j].InviteID
+	})
+	return out, nil
+}
+
+func (r *InviteRepo) Use(_ context.Context, inviteID string) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	inv, ok := r.st.invites[inviteID]
+	if !ok || inv.RevokedAt != nil || (inv.MaxUses > 0 && inv.Uses >= inv.MaxUses) {
+		return derr.ErrConflict
+	}
+	inv.Uses++
+	return nil
+}
+
+func (r *InviteRepo) Revoke(_ context.Context, inviteID string, at time.Time) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	inv, ok := r.st.invites[inviteID]
+	if !ok {
+		return derr.ErrNotFound
+	}
+	if inv.RevokedAt == nil {
+		inv.RevokedAt = &at
+	}
+	return nil
+}
+
+// ScanAll calls fn with a copy of every invite; the lock is not held during fn.
+func (r *InviteRepo) ScanAll(_ context.Context, fn func(inv models.Invite) error) error {
+	return scanAll(r.st, r.st.invites, func(inv *models.Invite) models.Invite { return *cloneInvite(inv) }, fn)
+}
+
+func (r *InviteRepo) DeleteByRoom(_ context.Context, roomID string) (int, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	n := 0
+	for id, inv := range r.st.invites {
+		if inv.RoomID == roomID {
+			delete(r.st.invites, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,15 @@
+package memstore
+
+import (
+	"testing"
+
+	"github.com/janvillarosa/gracie-app/backend/internal/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Repos {
+		tx, users, rooms, lists, items := Compose()
+		st := NewStore()
+		return storetest.Repos{Tx: tx, Users: users, Rooms: rooms, Lists: lists, Items: items, Migrations: NewMigrationRepo(st), Jobs: NewJobRepo(st), Sessions: NewSessionRepo(st), UserTokens: NewUserTokenRepo(st), Counters: NewCounterRepo(st), Invites: NewInviteRepo(st)}
+	})
+}
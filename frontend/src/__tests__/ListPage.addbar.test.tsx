@@ -22,6 +22,9 @@ const setup = async (listItems: any[] = []) => {
         { status: 200, headers: { 'Content-Type': 'application/json' } }
       )
     }),
+    http.get('/api/rooms', () => {
+      return new Response(JSON.stringify({ rooms: [] }), { status: 200, headers: { 'Content-Type': 'application/json' } })
+    }),
     http.get(`/api/rooms/${roomId}/lists`, () => {
       return new Response(
         JSON.stringify([
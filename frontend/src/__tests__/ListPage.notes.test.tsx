@@ -17,6 +17,10 @@ const setup = async () => {
       JSON.stringify({ user_id: 'u1', name: 'Alice', room_id: roomId }),
       { status: 200, headers: { 'Content-Type': 'application/json' } }
     )),
+    http.get('/api/rooms', () => new Response(
+      JSON.stringify({ rooms: [] }),
+      { status: 200, headers: { 'Content-Type': 'application/json' } }
+    )),
     http.get(`/api/rooms/${roomId}/lists`, () => new Response(
       JSON.stringify([
         { list_id: listId, room_id: roomId, name: 'Groceries', description: '', notes: '', icon: 'APPLE', deletion_votes: {}, is_deleted: false, created_at: new Date().toISOString(), updated_at: new Date().toISOString() },
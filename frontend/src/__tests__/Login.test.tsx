@@ -21,13 +21,24 @@ vi.mock('react-router-dom', async () => {
 })
 
 describe('Login page', () => {
-  it('logs in and stores api key', async () => {
+  beforeEach(() => {
+    server.use(
+      http.get('/api/auth/oidc/providers', () =>
+        new Response(JSON.stringify({ providers: [] }), {
+          status: 200,
+          headers: { 'Content-Type': 'application/json' },
+        })
+      )
+    )
+  })
+
+  it('logs in and stores the tokens', async () => {
     server.use(
       http.post('/api/auth/login', async ({ request }) => {
         const body = await request.json() as any
         if (!body?.username || !body?.password)
           return new Response(null, { status: 400 })
-        return new Response(JSON.stringify({ api_key: 'k_123', user: { user_id: 'usr_1' } }), {
+        return new Response(JSON.stringify({ access_token: 'gat_123', refresh_token: 'grt_123', token_type: 'Bearer', expires_in: 900, user: { user_id: 'usr_1' } }), {
           status: 200,
           headers: { 'Content-Type': 'application/json' },
         })
@@ -49,6 +60,62 @@ describe('Login page', () => {
     expect(btn).not.toBeDisabled()
     fireEvent.click(btn)
     await new Promise((r) => setTimeout(r, 50))
-    expect(setApiKeyMock).toHaveBeenCalledWith('k_123')
+    expect(setApiKeyMock).toHaveBeenCalledWith('gat_123', 'grt_123')
+  })
+
+  it('asks for a code when the account has two-factor login', async () => {
+    setApiKeyMock.mockClear()
+    server.use(
+      http.post('/api/auth/login', () =>
+        new Response(JSON.stringify({ mfa_required: true, challenge: 'gmt_1' }), {
+          status: 200,
+          headers: { 'Content-Type': 'application/json' },
+        })
+      ),
+      http.post('/api/auth/login/totp', async ({ request }) => {
+        const body = await request.json() as any
+        if (body?.challenge !== 'gmt_1' || body?.code !== '123456')
+          return new Response(JSON.stringify({ error: 'unauthorized' }), { status: 401 })
+        return new Response(JSON.stringify({ access_token: 'gat_2fa', refresh_token: 'grt_2fa', token_type: 'Bearer', expires_in: 900, user: { user_id: 'usr_1' } }), {
+          status: 200,
+          headers: { 'Content-Type': 'application/json' },
+        })
+      })
+    )
+
+    render(
+      <MemoryRouter>
+        <Login />
+      </MemoryRouter>
+    )
+
+    fireEvent.change(screen.getByPlaceholderText('you@example.com'), { target: { value: 'a@b.com' } })
+    fireEvent.change(screen.getByPlaceholderText('Your password'), { target: { value: 'pw' } })
+    fireEvent.click(screen.getByRole('button', { name: /log in/i }))
+    const code = await screen.findByPlaceholderText('123456')
+    expect(setApiKeyMock).not.toHaveBeenCalled()
+    fireEvent.change(code, { target: { value: '123456' } })
+    fireEvent.click(screen.getByRole('button', { name: /verify/i }))
+    await new Promise((r) => setTimeout(r, 50))
+    expect(setApiKeyMock).toHaveBeenCalledWith('gat_2fa', 'grt_2fa')
+  })
+
+  it('offers single sign-on providers', async () => {
+    server.use(
+      http.get('/api/auth/oidc/providers', () =>
+        new Response(JSON.stringify({ providers: [{ id: 'google', name: 'Google' }] }), {
+          status: 200,
+          headers: { 'Content-Type': 'application/json' },
+        })
+      )
+    )
+
+    render(
+      <MemoryRouter>
+        <Login />
+      </MemoryRouter>
+    )
+
+    expect(await screen.findByRole('button', { name: /continue with google/i })).toBeInTheDocument()
   })
 })
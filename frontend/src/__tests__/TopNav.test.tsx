@@ -15,6 +15,10 @@ describe('TopNav', () => {
       http.get('/api/me', () => new Response(
         JSON.stringify({ user_id: 'u1', name: 'Alice', avatar_key: 'alice' }),
         { status: 200, headers: { 'Content-Type': 'application/json' } }
+      )),
+      http.get('/api/rooms', () => new Response(
+        JSON.stringify({ rooms: [] }),
+        { status: 200, headers: { 'Content-Type': 'application/json' } }
       ))
     )
 
@@ -36,9 +40,43 @@ describe('TopNav', () => {
     expect(await screen.findByText('Account Settings')).toBeInTheDocument()
     expect(screen.getByText('Logout')).toBeInTheDocument()
 
+    expect(screen.queryByText('Switch house')).toBeNull()
+
     await userEvent.click(screen.getByText('Logout'))
     // Key should be removed on logout
     expect(localStorage.getItem('gracie_api_key')).toBeNull()
   })
-})
 
+  it('switches between houses', async () => {
+    localStorage.setItem('gracie_api_key', 'k_test')
+    let switched = ''
+    const json = (body: unknown) => new Response(JSON.stringify(body), { status: 200, headers: { 'Content-Type': 'application/json' } })
+    server.use(
+      http.get('/api/me', () => json({ user_id: 'u1', name: 'Alice', room_id: 'r1' })),
+      http.get('/api/rooms', () => json({ rooms: [
+        { room_id: 'r1', display_name: 'Flat', members: ['Alice'], active: true },
+        { room_id: 'r2', display_name: 'Family', members: ['Alice', 'Bob'], active: false },
+      ] })),
+      http.put('/api/rooms/active', async ({ request }) => {
+        switched = ((await request.json()) as { room_id: string }).room_id
+        return json({ room_id: switched, display_name: 'Family', members: ['Alice', 'Bob'], active: true })
+      })
+    )
+
+    const qc = new QueryClient()
+    render(
+      <MemoryRouter>
+        <AuthProvider>
+          <QueryClientProvider client={qc}>
+            <TopNav />
+          </QueryClientProvider>
+        </AuthProvider>
+      </MemoryRouter>
+    )
+
+    await userEvent.click(await screen.findByRole('button', { name: /open account menu/i }))
+    expect(await screen.findByText('Switch house')).toBeInTheDocument()
+    await userEvent.click(screen.getByText('Family'))
+    await vi.waitFor(() => expect(switched).toBe('r2'))
+  })
+})
@@ -1,6 +1,6 @@
 import { server } from '../testServer'
 import { http } from 'msw'
-import { apiFetch } from '@api/client'
+import { apiFetch, API_KEY_STORAGE_KEY, REFRESH_STORAGE_KEY } from '@api/client'
 
 describe('apiFetch', () => {
   it('sends Authorization header when apiKey provided', async () => {
@@ -15,11 +15,45 @@ describe('apiFetch', () => {
     expect(auth).toBe('Bearer k_abc')
   })
 
+  it('refreshes an expired access token once and retries', async () => {
+    localStorage.setItem(REFRESH_STORAGE_KEY, 'grt_1')
+    const seen: string[] = []
+    let refreshes = 0
+    server.use(
+      http.get('/api/ping', ({ request }) => {
+        const auth = request.headers.get('authorization') || ''
+        seen.push(auth)
+        if (auth !== 'Bearer gat_2') return new Response(JSON.stringify({ error: 'unauthorized' }), { status: 401, headers: { 'Content-Type': 'application/json' } })
+        return new Response(JSON.stringify({ ok: true }), { status: 200, headers: { 'Content-Type': 'application/json' } })
+      }),
+      http.post('/api/auth/refresh', () => {
+        refreshes++
+        return new Response(JSON.stringify({ access_token: 'gat_2', refresh_token: 'grt_2' }), { status: 200, headers: { 'Content-Type': 'application/json' } })
+      })
+    )
+    const [a, b] = await Promise.all([
+      apiFetch<{ ok: boolean }>('/ping', { apiKey: 'gat_1' }),
+      apiFetch<{ ok: boolean }>('/ping', { apiKey: 'gat_1' }),
+    ])
+    expect(a.ok && b.ok).toBe(true)
+    expect(refreshes).toBe(1)
+    expect(seen.filter((s) => s === 'Bearer gat_2')).toHaveLength(2)
+    expect(localStorage.getItem(API_KEY_STORAGE_KEY)).toBe('gat_2')
+    expect(localStorage.getItem(REFRESH_STORAGE_KEY)).toBe('grt_2')
+  })
+
   it('throws ApiError with message and status on JSON error', async () => {
     server.use(
       http.get('/api/fail', () => new Response(JSON.stringify({ error: 'nope' }), { status: 403, headers: { 'Content-Type': 'application/json' } }))
     )
     await expect(apiFetch('/fail')).rejects.toMatchObject({ message: 'nope', status: 403 })
   })
+
+  it('carries the wait of a 429', async () => {
+    server.use(
+      http.post('/api/auth/login', () => new Response(JSON.stringify({ error: 'too many attempts', retry_after: 90 }), { status: 429, headers: { 'Content-Type': 'application/json', 'Retry-After': '90' } }))
+    )
+    await expect(apiFetch('/auth/login', { method: 'POST' })).rejects.toMatchObject({ status: 429, retryAfter: 90 })
+  })
 })
 
@@ -1,33 +1,85 @@
 const DEFAULT_BASE = '/api'
 
-export type ApiError = Error & { status?: number }
+// Where the bearer credential and refresh token are kept (see AuthProvider).
+export const API_KEY_STORAGE_KEY = 'gracie_api_key'
+export const REFRESH_STORAGE_KEY = 'gracie_refresh_token'
+// Dispatched on window with the new access token after a refresh.
+export const TOKENS_REFRESHED_EVENT = 'gracie:tokens-refreshed'
+
+export type ApiError = Error & { status?: number; retryAfter?: number }
+
+function apiBase(): string {
+  // Prefer absolute URL in VITE_API_BASE_URL; otherwise always use '/api' so Vercel rewrite applies
+  const envBase = (import.meta as any).env?.VITE_API_BASE_URL as string | undefined
+  const isAbsolute = typeof envBase === 'string' && /^(https?:)?\/\//.test(envBase)
+  return isAbsolute ? envBase! : DEFAULT_BASE
+}
+
+let refreshing: Promise<string | null> | null = null
+
+// refreshAccessToken redeems the stored refresh token once, however many
+// requests hit an expired access token at the same time. It resolves to the
+// new access token, or null when there is nothing to refresh with.
+function refreshAccessToken(): Promise<string | null> {
+  const refreshToken = localStorage.getItem(REFRESH_STORAGE_KEY)
+  if (!refreshToken) return Promise.resolve(null)
+  if (!refreshing) {
+    refreshing = (async () => {
+      const resp = await fetch(`${apiBase()}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      })
+      if (!resp.ok) {
+        localStorage.removeItem(REFRESH_STORAGE_KEY)
+        return null
+      }
+      const data = (await resp.json()) as { access_token: string; refresh_token: string }
+      localStorage.setItem(API_KEY_STORAGE_KEY, data.access_token)
+      localStorage.setItem(REFRESH_STORAGE_KEY, data.refresh_token)
+      window.dispatchEvent(new CustomEvent(TOKENS_REFRESHED_EVENT, { detail: data.access_token }))
+      return data.access_token
+    })().finally(() => {
+      refreshing = null
+    })
+  }
+  return refreshing
+}
 
 export async function apiFetch<T>(
   path: string,
   options: RequestInit & { apiKey?: string | null } = {}
 ): Promise<T> {
-  // Prefer absolute URL in VITE_API_BASE_URL; otherwise always use '/api' so Vercel rewrite applies
-  const envBase = (import.meta as any).env?.VITE_API_BASE_URL as string | undefined
-  const isAbsolute = typeof envBase === 'string' && /^(https?:)?\/\//.test(envBase)
-  const base = isAbsolute ? envBase! : DEFAULT_BASE
-  const url = `${base}${path}`
+  const url = `${apiBase()}${path}`
   const { apiKey, headers, ...rest } = options
-  const resp = await fetch(url, {
-    ...rest,
-    headers: {
-      'Content-Type': 'application/json',
-      ...(apiKey ? { Authorization: `Bearer ${apiKey}` } : {}),
-      ...(headers || {}),
-    },
-  })
+  const send = (key: string | null | undefined) =>
+    fetch(url, {
+      ...rest,
+      headers: {
+        'Content-Type': 'application/json',
+        ...(key ? { Authorization: `Bearer ${key}` } : {}),
+        ...(headers || {}),
+      },
+    })
+  let resp = await send(apiKey)
+  // Access tokens are short-lived: renew once and retry. If another request
+  // renewed it while this one was in flight, just use the new token.
+  if (resp.status === 401 && apiKey) {
+    const stored = localStorage.getItem(API_KEY_STORAGE_KEY)
+    const next = stored && stored !== apiKey ? stored : await refreshAccessToken()
+    if (next) resp = await send(next)
+  }
   if (!resp.ok) {
     let message = resp.statusText
+    let retryAfter: number | undefined
     try {
       const data = (await resp.json()) as any
       message = data?.error || message
+      retryAfter = data?.retry_after
     } catch {}
     const err = new Error(message) as ApiError
     err.status = resp.status
+    if (retryAfter) err.retryAfter = retryAfter
     throw err
   }
   const ct = resp.headers.get('content-type') || ''
@@ -1,5 +1,5 @@
 import { apiFetch, ApiError } from './client'
-import type { CreateUserResponse, RoomView, User, List, ListItem, ListIcon, PantryItem } from './types'
+import type { CreateUserResponse, RoomView, RoomSummary, RoomRole, Invite, User, List, ListItem, ListIcon, PantryItem, TokenResponse, MFAChallenge } from './types'
 
 export async function registerUser(name: string): Promise<CreateUserResponse> {
   return apiFetch<CreateUserResponse>('/users', {
@@ -15,13 +15,76 @@ export async function registerAuth(username: string, password: string, name: str
   })
 }
 
-export async function loginAuth(username: string, password: string): Promise<{ user: User; api_key: string }> {
-  return apiFetch<{ user: User; api_key: string }>('/auth/login', {
+export type LoginResponse = (TokenResponse & { user: User }) | MFAChallenge
+
+export function isMFAChallenge(res: LoginResponse): res is MFAChallenge {
+  return (res as MFAChallenge).mfa_required === true
+}
+
+export async function loginAuth(username: string, password: string): Promise<LoginResponse> {
+  return apiFetch<LoginResponse>('/auth/login', {
     method: 'POST',
     body: JSON.stringify({ username, password }),
   })
 }
 
+// code is a code from the authenticator app or a recovery code.
+export async function loginTOTP(challenge: string, code: string): Promise<TokenResponse & { user: User }> {
+  return apiFetch<TokenResponse & { user: User }>('/auth/login/totp', {
+    method: 'POST',
+    body: JSON.stringify({ challenge, code }),
+  })
+}
+
+export type OIDCProvider = { id: string; name: string }
+
+export async function listOIDCProviders(): Promise<OIDCProvider[]> {
+  const res = await apiFetch<{ providers: OIDCProvider[] }>('/auth/oidc/providers')
+  return res.providers
+}
+
+// flow must be kept until the provider redirects back to /oidc/callback.
+export async function startOIDC(provider: string): Promise<{ auth_url: string; flow: string }> {
+  return apiFetch<{ auth_url: string; flow: string }>(`/auth/oidc/${encodeURIComponent(provider)}/start`, { method: 'POST' })
+}
+
+export async function finishOIDC(provider: string, flow: string, code: string, state: string): Promise<LoginResponse> {
+  return apiFetch<LoginResponse>(`/auth/oidc/${encodeURIComponent(provider)}/callback`, {
+    method: 'POST',
+    body: JSON.stringify({ flow, code, state }),
+  })
+}
+
+// Always resolves for a well-formed request, whether or not the account exists.
+export async function requestPasswordReset(username: string): Promise<void> {
+  await apiFetch<void>('/auth/password/forgot', {
+    method: 'POST',
+    body: JSON.stringify({ username }),
+  })
+}
+
+export async function resetPassword(token: string, new_password: string): Promise<LoginResponse> {
+  return apiFetch<LoginResponse>('/auth/password/reset', {
+    method: 'POST',
+    body: JSON.stringify({ token, new_password }),
+  })
+}
+
+export async function verifyEmail(token: string): Promise<{ user: User }> {
+  return apiFetch<{ user: User }>('/auth/verify', {
+    method: 'POST',
+    body: JSON.stringify({ token }),
+  })
+}
+
+// Like requestPasswordReset, resolves whether or not the account exists.
+export async function resendVerification(username: string): Promise<void> {
+  await apiFetch<void>('/auth/verify/resend', {
+    method: 'POST',
+    body: JSON.stringify({ username }),
+  })
+}
+
 export async function getMe(apiKey: string): Promise<User> {
   return apiFetch<User>('/me', { apiKey })
 }
@@ -38,16 +101,56 @@ export async function getMyRoom(apiKey: string): Promise<RoomView> {
   return apiFetch<RoomView>('/rooms/me', { apiKey })
 }
 
+export async function listRooms(apiKey: string): Promise<RoomSummary[]> {
+  const res = await apiFetch<{ rooms: RoomSummary[] }>('/rooms', { apiKey })
+  return res.rooms
+}
+
+export async function switchRoom(apiKey: string, roomId: string): Promise<RoomSummary> {
+  return apiFetch<RoomSummary>('/rooms/active', { method: 'PUT', apiKey, body: JSON.stringify({ room_id: roomId }) })
+}
+
 export async function createRoom(apiKey: string): Promise<RoomView> {
   return apiFetch<RoomView>('/rooms', { method: 'POST', apiKey })
 }
 
+// leaveRoom leaves the active house for a new solo one. An owner may name who
+// takes over by avatar key; otherwise an admin or the longest member does.
+export async function leaveRoom(apiKey: string, newOwner?: string): Promise<RoomSummary> {
+  const body = newOwner ? JSON.stringify({ new_owner: newOwner }) : undefined
+  return apiFetch<RoomSummary>('/rooms/leave', { method: 'POST', apiKey, body })
+}
+
 export async function rotateShare(apiKey: string): Promise<{ token: string }> {
   return apiFetch<{ token: string }>('/rooms/share', { method: 'POST', apiKey })
 }
 
-export async function joinRoomByToken(apiKey: string, token: string): Promise<RoomView> {
-  return apiFetch<RoomView>(`/rooms/join`, { method: 'POST', apiKey, body: JSON.stringify({ token }) })
+export async function listInvites(apiKey: string): Promise<Invite[]> {
+  const res = await apiFetch<{ invites: Invite[] }>('/rooms/invites', { apiKey })
+  return res.invites
+}
+
+export async function createInvite(
+  apiKey: string,
+  params: { name?: string; max_uses?: number; expires_in_hours?: number; link?: boolean }
+): Promise<Invite> {
+  return apiFetch<Invite>('/rooms/invites', { method: 'POST', apiKey, body: JSON.stringify(params) })
+}
+
+export async function revokeInvite(apiKey: string, inviteId: string): Promise<void> {
+  await apiFetch<void>(`/rooms/invites/${encodeURIComponent(inviteId)}`, { method: 'DELETE', apiKey })
+}
+
+// What joining does with the house the user is alone in: keep it (the
+// default), merge its lists into the joined house, or discard it.
+export type SoloRoomAction = 'merge' | 'discard'
+
+export async function joinRoomByToken(
+  apiKey: string,
+  token: string,
+  opts?: { solo_room?: SoloRoomAction; dedupe_lists?: boolean }
+): Promise<RoomView> {
+  return apiFetch<RoomView>(`/rooms/join`, { method: 'POST', apiKey, body: JSON.stringify({ token, ...opts }) })
 }
 
 export async function voteDeletion(apiKey: string): Promise<{ deleted: boolean }> {
@@ -62,12 +165,34 @@ export async function updateRoomSettings(apiKey: string, params: { display_name?
   return apiFetch<RoomView>(`/rooms/settings`, { method: 'PUT', apiKey, body: JSON.stringify(params) })
 }
 
+// member is the avatar_key of the member in members_meta.
+export async function setMemberRole(apiKey: string, member: string, role: RoomRole): Promise<RoomView> {
+  return apiFetch<RoomView>(`/rooms/members/${encodeURIComponent(member)}/role`, { method: 'PUT', apiKey, body: JSON.stringify({ role }) })
+}
+
 export async function getRoomPantry(apiKey: string, roomId: string): Promise<PantryItem[]> {
   return apiFetch<PantryItem[]>(`/rooms/${roomId}/pantry`, { apiKey })
 }
 
-export async function changeMyPassword(apiKey: string, params: { current_password?: string; new_password: string }): Promise<{ api_key: string }> {
-  return apiFetch<{ api_key: string }>(`/me/password`, { method: 'POST', apiKey, body: JSON.stringify(params) })
+export async function changeMyPassword(apiKey: string, params: { current_password?: string; new_password: string }): Promise<TokenResponse> {
+  return apiFetch<TokenResponse>(`/me/password`, { method: 'POST', apiKey, body: JSON.stringify(params) })
+}
+
+export async function startTOTP(apiKey: string): Promise<{ secret: string; otpauth_uri: string }> {
+  return apiFetch<{ secret: string; otpauth_uri: string }>('/me/totp', { method: 'POST', apiKey })
+}
+
+// Turns two-factor login on; the recovery codes are only returned here.
+export async function confirmTOTP(apiKey: string, code: string): Promise<{ recovery_codes: string[] }> {
+  return apiFetch<{ recovery_codes: string[] }>('/me/totp/confirm', { method: 'POST', apiKey, body: JSON.stringify({ code }) })
+}
+
+export async function regenerateRecoveryCodes(apiKey: string, code: string): Promise<{ recovery_codes: string[] }> {
+  return apiFetch<{ recovery_codes: string[] }>('/me/totp/recovery-codes', { method: 'POST', apiKey, body: JSON.stringify({ code }) })
+}
+
+export async function disableTOTP(apiKey: string, code: string): Promise<void> {
+  await apiFetch<void>('/me/totp/disable', { method: 'POST', apiKey, body: JSON.stringify({ code }) })
 }
 
 export async function deleteMyAccount(apiKey: string): Promise<void> {
@@ -86,6 +211,19 @@ export function isConflict(err: unknown): err is ApiError {
   return typeof err === 'object' && err !== null && (err as any).status === 409
 }
 
+export function isRateLimited(err: unknown): err is ApiError {
+  return typeof err === 'object' && err !== null && (err as any).status === 429
+}
+
+// Words a 429 for the user, with the wait the server asked for.
+export function tooManyAttemptsMessage(err: ApiError): string {
+  const secs = err.retryAfter ?? 0
+  if (secs <= 0) return 'Too many attempts. Try again later.'
+  if (secs < 60) return `Too many attempts. Try again in ${secs} seconds.`
+  const mins = Math.ceil(secs / 60)
+  return `Too many attempts. Try again in ${mins === 1 ? '1 minute' : `${mins} minutes`}.`
+}
+
 // Lists API
 export async function getLists(apiKey: string, roomId: string): Promise<List[]> {
   return apiFetch<List[]>(`/rooms/${roomId}/lists`, { apiKey })
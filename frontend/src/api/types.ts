@@ -2,21 +2,62 @@ export type User = {
   user_id: string
   name: string
   username?: string
+  // False until the user follows the link mailed to username.
+  email_verified?: boolean
+  // True once two-factor login is confirmed.
+  totp_enabled?: boolean
+  // The active house; room_ids lists every house the user belongs to.
   room_id?: string | null
+  room_ids?: string[]
   created_at: string
   updated_at: string
   avatar_key?: string
   avatar_style?: string
 }
 
+export type RoomRole = 'owner' | 'admin' | 'member' | 'guest'
+
+export type RoomPermission =
+  | 'share_room'
+  | 'edit_room'
+  | 'delete_room'
+  | 'manage_roles'
+  | 'edit_lists'
+  | 'delete_lists'
+  | 'edit_items'
+  | 'check_items'
+
 export type RoomView = {
   display_name?: string
   description?: string
   members: string[]
-  members_meta?: { name: string; avatar_key: string }[]
+  members_meta?: { name: string; avatar_key: string; role?: RoomRole }[]
   created_at: string
   updated_at: string
   my_deletion_vote?: boolean
+  // Only on GET /rooms/me.
+  my_role?: RoomRole
+  my_permissions?: RoomPermission[]
+}
+
+// One of the houses returned by GET /rooms.
+export type RoomSummary = RoomView & {
+  room_id: string
+  active: boolean
+}
+
+// A house invite from GET /rooms/invites. Either the code or the link token
+// joins; max_uses 0 means unlimited.
+export type Invite = {
+  invite_id: string
+  name?: string
+  code: string
+  link_token?: string
+  max_uses: number
+  uses: number
+  created_by?: string
+  created_at: string
+  expires_at: string
 }
 
 export type CreateUserResponse = {
@@ -24,6 +65,22 @@ export type CreateUserResponse = {
   api_key: string
 }
 
+// Returned by login, password change and refresh. The access token is sent as
+// the bearer credential; the refresh token renews it once (see apiFetch).
+export type TokenResponse = {
+  access_token: string
+  refresh_token: string
+  token_type: string
+  expires_in: number
+}
+
+// What /auth/login answers instead of tokens when the account has
+// two-factor login on; /auth/login/totp redeems the challenge with a code.
+export type MFAChallenge = {
+  mfa_required: true
+  challenge: string
+}
+
 // Lists / Items
 export type List = {
   list_id: string
@@ -1,25 +1,35 @@
 import React, { createContext, useCallback, useContext, useEffect, useMemo, useState } from 'react'
 import { useNavigate } from 'react-router-dom'
 import { getMe } from '@api/endpoints'
+import { API_KEY_STORAGE_KEY, REFRESH_STORAGE_KEY, TOKENS_REFRESHED_EVENT } from '@api/client'
 
 type AuthContextType = {
   apiKey: string | null
-  setApiKey: (key: string | null) => void
+  // setApiKey stores the bearer credential: an access token with its refresh
+  // token, or an API key alone. null signs out.
+  setApiKey: (key: string | null, refreshToken?: string) => void
   isAuthed: boolean
 }
 
 const AuthContext = createContext<AuthContextType | undefined>(undefined)
 
-const STORAGE_KEY = 'gracie_api_key'
-
 export const AuthProvider: React.FC<React.PropsWithChildren> = ({ children }) => {
-  const [apiKey, setApiKeyState] = useState<string | null>(() => localStorage.getItem(STORAGE_KEY))
+  const [apiKey, setApiKeyState] = useState<string | null>(() => localStorage.getItem(API_KEY_STORAGE_KEY))
   const navigate = useNavigate()
 
-  const setApiKey = useCallback((key: string | null) => {
+  const setApiKey = useCallback((key: string | null, refreshToken?: string) => {
     setApiKeyState(key)
-    if (key) localStorage.setItem(STORAGE_KEY, key)
-    else localStorage.removeItem(STORAGE_KEY)
+    if (key) localStorage.setItem(API_KEY_STORAGE_KEY, key)
+    else localStorage.removeItem(API_KEY_STORAGE_KEY)
+    if (key && refreshToken) localStorage.setItem(REFRESH_STORAGE_KEY, refreshToken)
+    else localStorage.removeItem(REFRESH_STORAGE_KEY)
+  }, [])
+
+  // apiFetch renews expired access tokens on its own; pick up the new one.
+  useEffect(() => {
+    const onRefreshed = (e: Event) => setApiKeyState((e as CustomEvent<string>).detail)
+    window.addEventListener(TOKENS_REFRESHED_EVENT, onRefreshed)
+    return () => window.removeEventListener(TOKENS_REFRESHED_EVENT, onRefreshed)
   }, [])
 
   // Optional: verify key on mount
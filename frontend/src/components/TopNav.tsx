@@ -1,9 +1,9 @@
 import React from 'react'
-import { Dropdown } from 'antd'
+import { Dropdown, message } from 'antd'
 import type { MenuProps } from 'antd'
 import { useAuth } from '@auth/AuthProvider'
-import { useQuery } from '@tanstack/react-query'
-import { getMe } from '@api/endpoints'
+import { useQuery, useQueryClient } from '@tanstack/react-query'
+import { getMe, listRooms, switchRoom } from '@api/endpoints'
 import { BrandLogo } from '@components/BrandLogo'
 import { Avatar } from '@components/Avatar'
 import { useNavigate } from 'react-router-dom'
@@ -13,13 +13,36 @@ export const TopNav: React.FC = () => {
   const navigate = useNavigate()
   const meQuery = useQuery({ queryKey: ['me'], queryFn: () => getMe(apiKey!) })
   const me = meQuery.data
+  const roomsQuery = useQuery({ queryKey: ['rooms'], queryFn: () => listRooms(apiKey!) })
+  const rooms = roomsQuery.data ?? []
+  const qc = useQueryClient()
 
+  // Houses are offered only once there is another one to switch to.
+  const houseItems: MenuProps['items'] = rooms.length > 1 ? [
+    {
+      type: 'group' as const,
+      label: 'Switch house',
+      children: rooms.map((r) => ({ key: `room:${r.room_id}`, label: (r.active ? '✓ ' : '') + (r.display_name || 'House') })),
+    },
+    { type: 'divider' as const },
+  ] : []
   const items: MenuProps['items'] = [
+    ...houseItems,
     { key: 'account', label: 'Account Settings' },
     { type: 'divider' as const },
     { key: 'logout', label: 'Logout' },
   ]
+  const onSwitch = async (roomId: string) => {
+    try {
+      await switchRoom(apiKey!, roomId)
+      await Promise.all(['me', 'my-room', 'rooms'].map((k) => qc.invalidateQueries({ queryKey: [k] })))
+      navigate('/app')
+    } catch (e: any) {
+      message.error(e?.message || 'Failed to switch house')
+    }
+  }
   const onMenuClick: MenuProps['onClick'] = ({ key }) => {
+    if (key.startsWith('room:')) void onSwitch(key.slice('room:'.length))
     if (key === 'account') navigate('/app/account')
     if (key === 'logout') setApiKey(null)
   }
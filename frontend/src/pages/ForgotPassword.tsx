@@ -0,0 +1,65 @@
+import React, { useState } from 'react'
+import { Link } from 'react-router-dom'
+import { requestPasswordReset } from '@api/endpoints'
+import { Card, Typography, Form, Input, Button, message } from 'antd'
+import { useDocumentTitle } from '@lib/useDocumentTitle'
+import { BrandLogo } from '@components/BrandLogo'
+
+export const ForgotPassword: React.FC = () => {
+  useDocumentTitle('Forgot password')
+  const [username, setUsername] = useState('')
+  const [loading, setLoading] = useState(false)
+  const [sent, setSent] = useState(false)
+
+  async function onSubmit(e: React.FormEvent) {
+    e.preventDefault()
+    setLoading(true)
+    try {
+      await requestPasswordReset(username.trim())
+      setSent(true)
+    } catch (err: any) {
+      message.error(err?.message || 'Could not send reset link')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  return (
+    <div className="login-page">
+      <div className="container">
+      <div className="brand-banner">
+        <div className="brand-row">
+          <BrandLogo to="/login" size={80} />
+          <span className="brand-wordmark">Bauhouse</span>
+        </div>
+      </div>
+      <Card className="paper-card">
+        <Typography.Title level={2} style={{ marginTop: 0 }}>Forgot Password</Typography.Title>
+        {sent ? (
+          <Typography.Paragraph>
+            If an account exists for {username.trim()}, we sent it a link to reset the password. Check your email.
+          </Typography.Paragraph>
+        ) : (
+          <Form layout="vertical" onSubmitCapture={onSubmit}>
+            <Form.Item label="Email">
+              <Input
+                placeholder="you@example.com"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                autoComplete="email"
+                inputMode="email"
+              />
+            </Form.Item>
+            <Button type="primary" htmlType="submit" disabled={!username || loading} size="large" block>
+              Send Reset Link
+            </Button>
+          </Form>
+        )}
+        <Typography.Text type="secondary" style={{ display: 'inline-block', paddingTop: 40 }}>
+          Remembered it? <Link to="/login" className="link-primary">Log in</Link>
+        </Typography.Text>
+      </Card>
+      </div>
+    </div>
+  )
+}
@@ -0,0 +1,79 @@
+import React, { useCallback, useEffect, useRef, useState } from 'react'
+import { Link, useNavigate, useParams } from 'react-router-dom'
+import { useQuery, useQueryClient } from '@tanstack/react-query'
+import { useAuth } from '@auth/AuthProvider'
+import { getMyRoom, isConflict, isForbidden, isNotFound, isRateLimited, joinRoomByToken, tooManyAttemptsMessage } from '@api/endpoints'
+import type { SoloRoomAction } from '@api/endpoints'
+import { Card, Typography, Spin, Space, Button } from 'antd'
+import { useDocumentTitle } from '@lib/useDocumentTitle'
+import { TopNav } from '@components/TopNav'
+
+// JoinInvite joins the house of an invite link (/join/:token) and goes on to
+// the app. Someone alone in their current house first chooses whether to
+// bring its lists along.
+export const JoinInvite: React.FC = () => {
+  useDocumentTitle('Join a House')
+  const { apiKey } = useAuth()
+  const { token = '' } = useParams()
+  const navigate = useNavigate()
+  const qc = useQueryClient()
+  const [error, setError] = useState<string | null>(null)
+  const [joining, setJoining] = useState(false)
+  const started = useRef(false)
+  const roomQuery = useQuery({ queryKey: ['my-room'], queryFn: () => getMyRoom(apiKey!), retry: false })
+  const alone = roomQuery.isSuccess && roomQuery.data.members.length === 1
+
+  const join = useCallback((solo?: SoloRoomAction) => {
+    setJoining(true)
+    joinRoomByToken(apiKey!, token, solo ? { solo_room: solo, dedupe_lists: solo === 'merge' } : undefined)
+      .then(() => {
+        qc.invalidateQueries()
+        navigate('/app', { replace: true })
+      })
+      .catch((e: any) => {
+        setJoining(false)
+        if (isConflict(e)) {
+          navigate('/app', { replace: true })
+          return
+        }
+        if (isForbidden(e)) setError('This invite link is invalid, has expired or has been used up.')
+        else if (isRateLimited(e)) setError(tooManyAttemptsMessage(e))
+        else setError(e?.message || 'Failed to join house')
+      })
+  }, [apiKey, token, navigate, qc])
+
+  useEffect(() => {
+    // Without a choice to make, join once, even when effects run twice in
+    // development.
+    if (started.current || roomQuery.isLoading || alone) return
+    if (roomQuery.isError && !isNotFound(roomQuery.error)) return
+    started.current = true
+    join()
+  }, [roomQuery.isLoading, roomQuery.isError, roomQuery.error, alone, join])
+
+  return (
+    <div className="container">
+      <TopNav />
+      <Card>
+        <Typography.Title level={2} style={{ marginTop: 0 }}>Join a House</Typography.Title>
+        {error ? (
+          <Typography.Paragraph>
+            {error} Ask for a new one. <Link to="/app" className="link-primary">Continue to the app</Link>
+          </Typography.Paragraph>
+        ) : alone ? (
+          <Space direction="vertical" style={{ width: '100%' }}>
+            <Typography.Paragraph>
+              You are the only member of {roomQuery.data?.display_name || 'your house'}. Bring its lists along? Lists with the same name are combined.
+            </Typography.Paragraph>
+            <Space wrap>
+              <Button type="primary" loading={joining} onClick={() => join('merge')}>Bring my lists</Button>
+              <Button disabled={joining} onClick={() => join()}>Keep my house separate</Button>
+            </Space>
+          </Space>
+        ) : (
+          <Spin />
+        )}
+      </Card>
+    </div>
+  )
+}
@@ -1,33 +1,101 @@
-import React, { useState } from 'react'
-import { useNavigate, Link } from 'react-router-dom'
+import React, { useEffect, useState } from 'react'
+import { useNavigate, useLocation, Link } from 'react-router-dom'
 import { useAuth } from '@auth/AuthProvider'
-import { loginAuth } from '@api/endpoints'
-import { Card, Typography, Form, Input, Button, message } from 'antd'
+import { isMFAChallenge, isRateLimited, listOIDCProviders, loginAuth, loginTOTP, resendVerification, startOIDC, tooManyAttemptsMessage } from '@api/endpoints'
+import type { OIDCProvider } from '@api/endpoints'
+import { Alert, Card, Divider, Typography, Form, Input, Button, message } from 'antd'
 import { useDocumentTitle } from '@lib/useDocumentTitle'
 import { BrandLogo } from '@components/BrandLogo'
 
+// OIDC_FLOW_KEY holds the provider and flow of a single sign-on in progress.
+export const OIDC_FLOW_KEY = 'oidc_flow'
+
 export const Login: React.FC = () => {
   const { setApiKey } = useAuth()
   useDocumentTitle('Login')
   const [username, setUsername] = useState('')
   const [password, setPassword] = useState('')
   const [loading, setLoading] = useState(false)
+  const [unverified, setUnverified] = useState(false)
+  const location = useLocation()
+  // Set once the password is accepted for an account with two-factor login,
+  // or passed along by the single sign-on callback.
+  const [challenge, setChallenge] = useState<string | null>((location.state as { challenge?: string } | null)?.challenge ?? null)
+  const [code, setCode] = useState('')
+  // Where RequireAuth sent us from, e.g. an invite link.
+  const next = (location.state as { from?: { pathname: string } } | null)?.from?.pathname || '/app'
+  const [providers, setProviders] = useState<OIDCProvider[]>([])
   const navigate = useNavigate()
 
+  useEffect(() => {
+    listOIDCProviders().then(setProviders).catch(() => setProviders([]))
+  }, [])
+
   async function onLogin(e: React.FormEvent) {
     e.preventDefault()
     setLoading(true)
+    setUnverified(false)
     try {
       const res = await loginAuth(username.trim(), password)
-      setApiKey(res.api_key)
-      navigate('/app', { replace: true })
+      if (isMFAChallenge(res)) {
+        setChallenge(res.challenge)
+        return
+      }
+      setApiKey(res.access_token, res.refresh_token)
+      navigate(next, { replace: true })
     } catch (err: any) {
-      message.error(err?.message || 'Login failed')
+      if (err?.status === 403) setUnverified(true)
+      else if (isRateLimited(err)) message.error(tooManyAttemptsMessage(err))
+      else message.error(err?.message || 'Login failed')
     } finally {
       setLoading(false)
     }
   }
 
+  async function onVerifyCode(e: React.FormEvent) {
+    e.preventDefault()
+    if (!challenge) return
+    setLoading(true)
+    try {
+      const res = await loginTOTP(challenge, code.trim())
+      setApiKey(res.access_token, res.refresh_token)
+      navigate(next, { replace: true })
+    } catch (err: any) {
+      if (isRateLimited(err)) message.error(tooManyAttemptsMessage(err))
+      else message.error(err?.status === 401 ? 'That code did not work' : err?.message || 'Login failed')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  async function onSSO(provider: string) {
+    setLoading(true)
+    try {
+      const { auth_url, flow } = await startOIDC(provider)
+      sessionStorage.setItem(OIDC_FLOW_KEY, JSON.stringify({ provider, flow }))
+      window.location.assign(auth_url)
+    } catch (err: any) {
+      if (isRateLimited(err)) message.error(tooManyAttemptsMessage(err))
+      else message.error(err?.message || 'Could not reach the sign-in provider')
+      setLoading(false)
+    }
+  }
+
+  function onBackToPassword() {
+    setChallenge(null)
+    setCode('')
+    setPassword('')
+  }
+
+  async function onResend() {
+    try {
+      await resendVerification(username.trim())
+      message.success('Verification link sent. Check your email.')
+    } catch (err: any) {
+      message.error(err?.message || 'Could not send verification link')
+    }
+  }
+
   return (
     <div className="login-page">
       <div className="container">
@@ -39,28 +107,73 @@ export const Login: React.FC = () => {
       </div>
       <Card className="paper-card">
         <Typography.Title level={2} style={{ marginTop: 0 }}>Log In</Typography.Title>
-        <Form layout="vertical" onSubmitCapture={onLogin}>
-          <Form.Item label="Email">
-            <Input
-              placeholder="you@example.com"
-              value={username}
-              onChange={(e) => setUsername(e.target.value)}
-              autoComplete="email"
-              inputMode="email"
-            />
-          </Form.Item>
-          <Form.Item label="Password">
-            <Input.Password
-              placeholder="Your password"
-              value={password}
-              onChange={(e) => setPassword(e.target.value)}
-              autoComplete="current-password"
-            />
-          </Form.Item>
-          <Button type="primary" htmlType="submit" disabled={!username || !password || loading} size="large" block>
-            Log In
-          </Button>
-        </Form>
+        {unverified && (
+          <Alert
+            type="warning"
+            showIcon
+            style={{ marginBottom: 16 }}
+            message="Verify your email to log in"
+            description={<span>Follow the link we emailed to {username.trim()}. <a className="link-primary" onClick={onResend}>Send a new link</a></span>}
+          />
+        )}
+        {challenge ? (
+          <Form layout="vertical" onSubmitCapture={onVerifyCode}>
+            <Typography.Paragraph>
+              Enter the 6-digit code from your authenticator app, or one of your recovery codes.
+            </Typography.Paragraph>
+            <Form.Item label="Code">
+              <Input
+                placeholder="123456"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                autoComplete="one-time-code"
+                autoFocus
+              />
+            </Form.Item>
+            <Button type="primary" htmlType="submit" disabled={!code.trim() || loading} size="large" block>
+              Verify
+            </Button>
+            <Typography.Paragraph style={{ marginTop: 16 }}>
+              <a className="link-primary" onClick={onBackToPassword}>Back</a>
+            </Typography.Paragraph>
+          </Form>
+        ) : (
+          <Form layout="vertical" onSubmitCapture={onLogin}>
+            <Form.Item label="Email">
+              <Input
+                placeholder="you@example.com"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                autoComplete="email"
+                inputMode="email"
+              />
+            </Form.Item>
+            <Form.Item label="Password">
+              <Input.Password
+                placeholder="Your password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                autoComplete="current-password"
+              />
+            </Form.Item>
+            <Typography.Paragraph style={{ marginTop: -8 }}>
+              <Link to="/forgot-password" className="link-primary">Forgot password?</Link>
+            </Typography.Paragraph>
+            <Button type="primary" htmlType="submit" disabled={!username || !password || loading} size="large" block>
+              Log In
+            </Button>
+            {providers.length > 0 && (
+              <>
+                <Divider plain>or</Divider>
+                {providers.map((p) => (
+                  <Button key={p.id} size="large" block disabled={loading} onClick={() => onSSO(p.id)} style={{ marginBottom: 8 }}>
+                    Continue with {p.name}
+                  </Button>
+                ))}
+              </>
+            )}
+          </Form>
+        )}
         <Typography.Text type="secondary" style={{ display: 'inline-block', paddingTop: 40 }}>
           New here? <Link to="/register" className="link-primary">Create an account</Link>
         </Typography.Text>
@@ -1,6 +1,6 @@
 import React, { useMemo, useState } from 'react'
 import { useAuth } from '@auth/AuthProvider'
-import { createRoom, updateRoomSettings, isConflict, isForbidden, joinRoomByToken } from '@api/endpoints'
+import { createRoom, updateRoomSettings, isConflict, isForbidden, isRateLimited, joinRoomByToken, tooManyAttemptsMessage } from '@api/endpoints'
 import { Card, Typography, Form, Input, Button, Space, Grid, Divider, message } from 'antd'
 import { Plus, UsersThree } from '@phosphor-icons/react'
 import { CreateHouseModal } from '@components/CreateHouseModal'
@@ -47,6 +47,7 @@ export const NoRoomPage: React.FC = () => {
     } catch (e: any) {
       if (isForbidden(e)) message.error('Invalid code for this house.')
       else if (isConflict(e)) message.error('You’re already a member of this house.')
+      else if (isRateLimited(e)) message.error(tooManyAttemptsMessage(e))
       else message.error(e?.message || 'Failed to join house')
     } finally {
       setLoading(false)
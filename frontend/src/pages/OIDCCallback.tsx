@@ -0,0 +1,67 @@
+import React, { useEffect, useRef, useState } from 'react'
+import { Link, useNavigate, useSearchParams } from 'react-router-dom'
+import { useAuth } from '@auth/AuthProvider'
+import { finishOIDC, isMFAChallenge } from '@api/endpoints'
+import { Card, Typography, Spin } from 'antd'
+import { useDocumentTitle } from '@lib/useDocumentTitle'
+import { BrandLogo } from '@components/BrandLogo'
+import { OIDC_FLOW_KEY } from '@pages/Login'
+
+export const OIDCCallback: React.FC = () => {
+  useDocumentTitle('Signing in')
+  const { setApiKey } = useAuth()
+  const navigate = useNavigate()
+  const [params] = useSearchParams()
+  const [error, setError] = useState<string | null>(null)
+  const started = useRef(false)
+
+  useEffect(() => {
+    // Redeem the code once, even when effects run twice in development.
+    if (started.current) return
+    started.current = true
+    const saved = sessionStorage.getItem(OIDC_FLOW_KEY)
+    sessionStorage.removeItem(OIDC_FLOW_KEY)
+    const code = params.get('code') || ''
+    const state = params.get('state') || ''
+    if (params.get('error') || !saved || !code || !state) {
+      setError('Sign-in was cancelled or could not be completed.')
+      return
+    }
+    const { provider, flow } = JSON.parse(saved) as { provider: string; flow: string }
+    finishOIDC(provider, flow, code, state)
+      .then((res) => {
+        if (isMFAChallenge(res)) {
+          navigate('/login', { replace: true, state: { challenge: res.challenge } })
+          return
+        }
+        setApiKey(res.access_token, res.refresh_token)
+        navigate('/app', { replace: true })
+      })
+      .catch((err: any) => {
+        setError(err?.status === 403
+          ? 'Your sign-in provider has not verified your email address.'
+          : 'Sign-in could not be completed. It may have taken too long; try again.')
+      })
+  }, [params, navigate, setApiKey])
+
+  return (
+    <div className="login-page">
+      <div className="container">
+      <div className="brand-banner">
+        <div className="brand-row">
+          <BrandLogo to="/login" size={80} />
+          <span className="brand-wordmark">Bauhouse</span>
+        </div>
+      </div>
+      <Card className="paper-card">
+        <Typography.Title level={2} style={{ marginTop: 0 }}>Log In</Typography.Title>
+        {error ? (
+          <Typography.Paragraph>
+            {error} <Link to="/login" className="link-primary">Back to log in</Link>
+          </Typography.Paragraph>
+        ) : <Spin />}
+      </Card>
+      </div>
+    </div>
+  )
+}
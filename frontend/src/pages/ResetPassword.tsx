@@ -0,0 +1,84 @@
+import React, { useState } from 'react'
+import { useNavigate, useSearchParams, Link } from 'react-router-dom'
+import { useAuth } from '@auth/AuthProvider'
+import { isMFAChallenge, resetPassword } from '@api/endpoints'
+import { Card, Typography, Form, Input, Button, message } from 'antd'
+import { useDocumentTitle } from '@lib/useDocumentTitle'
+import { BrandLogo } from '@components/BrandLogo'
+
+export const ResetPassword: React.FC = () => {
+  const { setApiKey } = useAuth()
+  useDocumentTitle('Reset password')
+  const [params] = useSearchParams()
+  const token = params.get('token') || ''
+  const [password, setPassword] = useState('')
+  const [confirm, setConfirm] = useState('')
+  const [loading, setLoading] = useState(false)
+  const navigate = useNavigate()
+
+  async function onSubmit(e: React.FormEvent) {
+    e.preventDefault()
+    if (password !== confirm) {
+      message.error('Passwords do not match')
+      return
+    }
+    setLoading(true)
+    try {
+      const res = await resetPassword(token, password)
+      // Accounts with two-factor login still need a code to sign in.
+      if (isMFAChallenge(res)) {
+        message.success('Password updated. Log in with your new password.')
+        navigate('/login', { replace: true })
+        return
+      }
+      setApiKey(res.access_token, res.refresh_token)
+      message.success('Password updated')
+      navigate('/app', { replace: true })
+    } catch (err: any) {
+      message.error(err?.status === 401 ? 'This reset link is invalid or has expired' : err?.message || 'Reset failed')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  return (
+    <div className="login-page">
+      <div className="container">
+      <div className="brand-banner">
+        <div className="brand-row">
+          <BrandLogo to="/login" size={80} />
+          <span className="brand-wordmark">Bauhouse</span>
+        </div>
+      </div>
+      <Card className="paper-card">
+        <Typography.Title level={2} style={{ marginTop: 0 }}>Reset Password</Typography.Title>
+        {!token ? (
+          <Typography.Paragraph>
+            This link is missing its reset token. <Link to="/forgot-password" className="link-primary">Request a new one</Link>.
+          </Typography.Paragraph>
+        ) : (
+          <Form layout="vertical" onSubmitCapture={onSubmit}>
+            <Form.Item label="New password" extra="At least 8 characters">
+              <Input.Password
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                autoComplete="new-password"
+              />
+            </Form.Item>
+            <Form.Item label="Confirm password">
+              <Input.Password
+                value={confirm}
+                onChange={(e) => setConfirm(e.target.value)}
+                autoComplete="new-password"
+              />
+            </Form.Item>
+            <Button type="primary" htmlType="submit" disabled={password.length < 8 || !confirm || loading} size="large" block>
+              Set Password
+            </Button>
+          </Form>
+        )}
+      </Card>
+      </div>
+    </div>
+  )
+}
@@ -1,10 +1,11 @@
 import React, { useEffect, useMemo, useState } from 'react'
 import { useNavigate } from 'react-router-dom'
 import { useAuth } from '@auth/AuthProvider'
-import { rotateShare, voteDeletion, cancelDeletion, updateRoomSettings, getMyRoom } from '@api/endpoints'
+import { rotateShare, voteDeletion, leaveRoom, cancelDeletion, updateRoomSettings, getMe, getMyRoom, setMemberRole, listInvites, createInvite, revokeInvite } from '@api/endpoints'
+import type { Invite, RoomPermission, RoomRole } from '@api/types'
 import { useQuery, useQueryClient } from '@tanstack/react-query'
-import { Card, Typography, Space, Button, Input, Form, Grid, message } from 'antd'
-import { ArrowLeft, FloppyDisk, ShareNetwork, Trash, XCircle } from '@phosphor-icons/react'
+import { Card, Typography, Space, Button, Input, Form, Grid, List, Select, Tag, message } from 'antd'
+import { ArrowLeft, FloppyDisk, Link as LinkIcon, ShareNetwork, SignOut, Trash, XCircle } from '@phosphor-icons/react'
 import { isValidDisplayName, MAX_DESCRIPTION } from '@lib/validation'
 import { ShareCodeModal } from '@components/ShareCodeModal'
 import { useDocumentTitle } from '@lib/useDocumentTitle'
@@ -18,6 +19,7 @@ export const RoomSettings: React.FC = () => {
   useDocumentTitle('House Settings')
   const qc = useQueryClient()
   const roomQuery = useQuery({ queryKey: ['my-room'], queryFn: () => getMyRoom(apiKey!) })
+  const meQuery = useQuery({ queryKey: ['me'], queryFn: () => getMe(apiKey!) })
   const [displayName, setDisplayName] = useState('')
   const [description, setDescription] = useState('')
   const [initialized, setInitialized] = useState(false)
@@ -28,6 +30,10 @@ export const RoomSettings: React.FC = () => {
   const isMobile = !screens.md
 
   const nameValid = useMemo(() => !displayName || isValidDisplayName(displayName), [displayName])
+  const myRole = roomQuery.data?.my_role
+  // Older servers send no permissions; everything is allowed there.
+  const can = (p: RoomPermission) => !roomQuery.data?.my_permissions || roomQuery.data.my_permissions.includes(p)
+  const invitesQuery = useQuery({ queryKey: ['invites'], queryFn: () => listInvites(apiKey!), enabled: !!roomQuery.data && can('share_room') })
 
   // Initialize form fields with current values once room data is loaded
   useEffect(() => {
@@ -72,6 +78,7 @@ export const RoomSettings: React.FC = () => {
       const r = await rotateShare(apiKey!)
       setShareToken(r.token)
       setShareOpen(true)
+      qc.invalidateQueries({ queryKey: ['invites'] })
     } catch (e: any) {
       message.error(e?.message || 'Failed to rotate share code')
     }
@@ -92,6 +99,57 @@ export const RoomSettings: React.FC = () => {
     }
   }
 
+  const onLeave = async () => {
+    try {
+      await leaveRoom(apiKey!)
+      message.success('You left the house')
+      qc.invalidateQueries()
+      navigate('/app', { replace: true })
+    } catch (e: any) {
+      message.error(e?.message || 'Failed to leave house')
+    }
+  }
+
+  // Owners can hand over the house or change anyone else; admins only move
+  // people between member and guest.
+  const roleOptions = (current?: RoomRole): RoomRole[] => {
+    if (myRole === 'owner') return ['owner', 'admin', 'member', 'guest']
+    if (current === 'owner' || current === 'admin') return []
+    return ['member', 'guest']
+  }
+
+  const onRoleChange = async (member: string, role: RoomRole) => {
+    try {
+      await setMemberRole(apiKey!, member, role)
+      message.success('Role updated')
+      qc.invalidateQueries({ queryKey: ['my-room'] })
+    } catch (e: any) {
+      message.error(e?.message || 'Failed to change role')
+    }
+  }
+
+  const inviteLink = (inv: Invite) => `${window.location.origin}/join/${encodeURIComponent(inv.link_token!)}`
+
+  const onNewInviteLink = async () => {
+    try {
+      const inv = await createInvite(apiKey!, { link: true })
+      try { await navigator.clipboard.writeText(inviteLink(inv)) } catch {}
+      message.success('Invite link copied')
+      qc.invalidateQueries({ queryKey: ['invites'] })
+    } catch (e: any) {
+      message.error(e?.message || 'Failed to create invite')
+    }
+  }
+
+  const onRevokeInvite = async (inviteId: string) => {
+    try {
+      await revokeInvite(apiKey!, inviteId)
+      qc.invalidateQueries({ queryKey: ['invites'] })
+    } catch (e: any) {
+      message.error(e?.message || 'Failed to revoke invite')
+    }
+  }
+
   const onCancelVote = async () => {
     try {
       await cancelDeletion(apiKey!)
@@ -131,16 +189,70 @@ export const RoomSettings: React.FC = () => {
             <Form.Item label="Description">
               <Input.TextArea rows={4} value={description} onChange={(e) => setDescription(e.target.value)} />
             </Form.Item>
-            <Button type="primary" htmlType="submit" disabled={saving || !hasChanges || !nameValid} icon={<FloppyDisk />}>Save</Button>
+            <Button type="primary" htmlType="submit" disabled={saving || !hasChanges || !nameValid || !can('edit_room')} icon={<FloppyDisk />}>Save</Button>
           </Form>
           <Space wrap>
-            <Button type="primary" onClick={onShare} icon={<ShareNetwork />}>Get share code</Button>
-            {roomQuery.data?.my_deletion_vote ? (
+            {can('share_room') && <Button type="primary" onClick={onShare} icon={<ShareNetwork />}>Get share code</Button>}
+            {can('share_room') && <Button onClick={onNewInviteLink} icon={<LinkIcon />}>New invite link</Button>}
+            {!can('delete_room') ? null : roomQuery.data?.my_deletion_vote ? (
               <Button onClick={onCancelVote} icon={<XCircle />}>Cancel vote</Button>
             ) : (
               <Button danger onClick={onVoteDelete} icon={<Trash />}>Vote to delete house</Button>
             )}
+            {(roomQuery.data?.members.length ?? 0) > 1 && (
+              <Button danger onClick={onLeave} icon={<SignOut />}>Leave house</Button>
+            )}
           </Space>
+          {roomQuery.data?.members_meta?.length ? (
+            <List
+              header={<Typography.Text strong>Members</Typography.Text>}
+              dataSource={roomQuery.data.members_meta}
+              renderItem={(m) => {
+                const options = roleOptions(m.role)
+                const isMe = m.avatar_key === meQuery.data?.avatar_key
+                return (
+                  <List.Item
+                    actions={can('manage_roles') && !isMe && options.length ? [
+                      <Select
+                        key="role"
+                        aria-label={`Role of ${m.name}`}
+                        value={m.role}
+                        style={{ width: 120 }}
+                        options={options.map((r) => ({ value: r, label: r }))}
+                        onChange={(r: RoomRole) => onRoleChange(m.avatar_key, r)}
+                      />,
+                    ] : undefined}
+                  >
+                    <Space>
+                      {m.name}
+                      {m.role && <Tag>{m.role}</Tag>}
+                    </Space>
+                  </List.Item>
+                )
+              }}
+            />
+          ) : null}
+          {invitesQuery.data?.length ? (
+            <List
+              header={<Typography.Text strong>Invites</Typography.Text>}
+              dataSource={invitesQuery.data}
+              renderItem={(inv) => (
+                <List.Item
+                  actions={[
+                    inv.link_token ? (
+                      <Button key="copy" size="small" onClick={() => navigator.clipboard.writeText(inviteLink(inv)).catch(() => {})}>Copy link</Button>
+                    ) : null,
+                    <Button key="revoke" size="small" danger onClick={() => onRevokeInvite(inv.invite_id)}>Revoke</Button>,
+                  ].filter(Boolean)}
+                >
+                  <List.Item.Meta
+                    title={inv.name || inv.code}
+                    description={`Code ${inv.code} · ${inv.uses}/${inv.max_uses || '∞'} uses · expires ${new Date(inv.expires_at).toLocaleDateString()}${inv.created_by ? ` · by ${inv.created_by}` : ''}`}
+                  />
+                </List.Item>
+              )}
+            />
+          ) : null}
           <ShareCodeModal
             open={shareOpen}
             token={shareToken}
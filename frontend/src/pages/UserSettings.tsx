@@ -2,9 +2,9 @@ import React, { useEffect, useMemo, useState } from 'react'
 import { useAuth } from '@auth/AuthProvider'
 import { useNavigate } from 'react-router-dom'
 import { useQuery, useQueryClient } from '@tanstack/react-query'
-import { getMe, updateMyProfile, changeMyPassword, deleteMyAccount } from '@api/endpoints'
+import { getMe, updateMyProfile, changeMyPassword, deleteMyAccount, resendVerification, startTOTP, confirmTOTP, regenerateRecoveryCodes, disableTOTP } from '@api/endpoints'
 import type { User } from '@api/types'
-import { Card, Typography, Space, Button, Input, Form, Modal, Grid, Divider, message } from 'antd'
+import { Card, Typography, Space, Button, Input, Form, Modal, Grid, Divider, QRCode, message } from 'antd'
 import { Avatar } from '@components/Avatar'
 import { ArrowLeft, FloppyDisk, Trash } from '@phosphor-icons/react'
 import { useDocumentTitle } from '@lib/useDocumentTitle'
@@ -29,6 +29,11 @@ export const UserSettings: React.FC = () => {
   const [newPwd, setNewPwd] = useState('')
   const [newPwd2, setNewPwd2] = useState('')
   const [confirmOpen, setConfirmOpen] = useState(false)
+  const [totpSetup, setTotpSetup] = useState<{ secret: string; otpauth_uri: string } | null>(null)
+  const [totpCode, setTotpCode] = useState('')
+  const [totpBusy, setTotpBusy] = useState(false)
+  // Shown once, right after they are generated.
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null)
   const screens = Grid.useBreakpoint()
   const isMobile = !screens.md
 
@@ -50,6 +55,15 @@ export const UserSettings: React.FC = () => {
     return true
   }, [profileChanged, email])
 
+  async function onResendVerification() {
+    try {
+      await resendVerification(meQuery.data!.username!)
+      message.success(`Verification link sent to ${meQuery.data!.username}`)
+    } catch (e: any) {
+      message.error(e?.message || 'Failed to send verification link')
+    }
+  }
+
   async function onSaveProfile(e: React.FormEvent) {
     e.preventDefault()
     if (email && !isEmail(email)) { message.error('Invalid email'); return }
@@ -59,7 +73,7 @@ export const UserSettings: React.FC = () => {
       if (name !== meQuery.data?.name) body.name = name
       if (email !== meQuery.data?.username) body.username = email
       await updateMyProfile(apiKey!, body)
-      message.success('Profile updated')
+      message.success(body.username ? 'Profile updated. Check your inbox to verify the new email.' : 'Profile updated')
       await qc.invalidateQueries({ queryKey: ['me'] })
     } catch (e: any) {
       message.error(e?.message || 'Failed to update profile')
@@ -77,7 +91,7 @@ export const UserSettings: React.FC = () => {
     setPwdSaving(true)
     try {
       const res = await changeMyPassword(apiKey!, { current_password: curPwd || undefined, new_password: newPwd })
-      setApiKey(res.api_key)
+      setApiKey(res.access_token, res.refresh_token)
       setCurPwd('')
       setNewPwd('')
       setNewPwd2('')
@@ -89,6 +103,62 @@ export const UserSettings: React.FC = () => {
     }
   }
 
+  async function onStartTOTP() {
+    setTotpBusy(true)
+    try {
+      setTotpSetup(await startTOTP(apiKey!))
+      setTotpCode('')
+    } catch (e: any) {
+      message.error(e?.message || 'Failed to start two-factor setup')
+    } finally {
+      setTotpBusy(false)
+    }
+  }
+
+  // Confirms setup, replaces the recovery codes or turns two-factor login
+  // off, depending on the state; each needs a current code.
+  async function onSubmitTOTP(e: React.FormEvent) {
+    e.preventDefault()
+    const code = totpCode.trim()
+    if (!code) return
+    setTotpBusy(true)
+    try {
+      if (totpSetup) {
+        const res = await confirmTOTP(apiKey!, code)
+        setTotpSetup(null)
+        setRecoveryCodes(res.recovery_codes)
+        message.success('Two-factor login is on')
+      } else {
+        const res = await regenerateRecoveryCodes(apiKey!, code)
+        setRecoveryCodes(res.recovery_codes)
+        message.success('New recovery codes generated')
+      }
+      setTotpCode('')
+      await qc.invalidateQueries({ queryKey: ['me'] })
+    } catch (e: any) {
+      message.error(e?.status === 403 ? 'That code did not work' : e?.message || 'Failed to verify code')
+    } finally {
+      setTotpBusy(false)
+    }
+  }
+
+  async function onDisableTOTP() {
+    const code = totpCode.trim()
+    if (!code) return
+    setTotpBusy(true)
+    try {
+      await disableTOTP(apiKey!, code)
+      setTotpCode('')
+      setRecoveryCodes(null)
+      message.success('Two-factor login is off')
+      await qc.invalidateQueries({ queryKey: ['me'] })
+    } catch (e: any) {
+      message.error(e?.status === 403 ? 'That code did not work' : e?.message || 'Failed to turn off two-factor login')
+    } finally {
+      setTotpBusy(false)
+    }
+  }
+
   async function onConfirmDelete() {
     try {
       await deleteMyAccount(apiKey!)
@@ -127,7 +197,15 @@ export const UserSettings: React.FC = () => {
                 <Form.Item label="Display name">
                   <Input value={name} onChange={(e) => setName(e.target.value)} />
                 </Form.Item>
-                <Form.Item label="Email">
+                <Form.Item
+                  label="Email"
+                  extra={meQuery.data?.username && meQuery.data.email_verified === false ? (
+                    <span>
+                      Not verified.{' '}
+                      <a className="link-primary" onClick={onResendVerification}>Resend link</a>
+                    </span>
+                  ) : undefined}
+                >
                   <Input value={email} onChange={(e) => setEmail(e.target.value)} inputMode="email" />
                 </Form.Item>
               </div>
@@ -165,6 +243,56 @@ export const UserSettings: React.FC = () => {
 
           <Divider className="settings-divider" />
 
+          {needsCurrent && (
+            <>
+              <Form layout="vertical" onSubmitCapture={onSubmitTOTP}>
+                <Typography.Title level={4} style={{ marginTop: 0 }}>Two-factor login</Typography.Title>
+                {recoveryCodes && (
+                  <Space direction="vertical" style={{ width: '100%', marginBottom: 16 }}>
+                    <Typography.Text strong>Save these recovery codes somewhere safe. Each works once if you lose your authenticator, and they won’t be shown again.</Typography.Text>
+                    <Typography.Paragraph copyable={{ text: recoveryCodes.join('\n') }} style={{ fontFamily: 'monospace', marginBottom: 0 }}>
+                      {recoveryCodes.join('  ')}
+                    </Typography.Paragraph>
+                  </Space>
+                )}
+                {meQuery.data?.totp_enabled ? (
+                  <>
+                    <Typography.Paragraph>
+                      On. Enter a code from your authenticator app or a recovery code to get new recovery codes or to turn it off.
+                    </Typography.Paragraph>
+                    <Form.Item label="Code">
+                      <Input value={totpCode} onChange={(e) => setTotpCode(e.target.value)} autoComplete="one-time-code" />
+                    </Form.Item>
+                    <Space wrap>
+                      <Button htmlType="submit" disabled={!totpCode.trim() || totpBusy}>New recovery codes</Button>
+                      <Button danger onClick={onDisableTOTP} disabled={!totpCode.trim() || totpBusy}>Turn off</Button>
+                    </Space>
+                  </>
+                ) : totpSetup ? (
+                  <>
+                    <Typography.Paragraph>
+                      Scan this code with your authenticator app, or enter the key <Typography.Text code copyable>{totpSetup.secret}</Typography.Text>, then enter the 6-digit code it shows.
+                    </Typography.Paragraph>
+                    <QRCode value={totpSetup.otpauth_uri} style={{ marginBottom: 16 }} />
+                    <Form.Item label="Code">
+                      <Input value={totpCode} onChange={(e) => setTotpCode(e.target.value)} placeholder="123456" autoComplete="one-time-code" />
+                    </Form.Item>
+                    <Button type="primary" htmlType="submit" disabled={!totpCode.trim() || totpBusy}>Turn on</Button>
+                  </>
+                ) : (
+                  <>
+                    <Typography.Paragraph>
+                      Ask for a code from an authenticator app after your password when you log in.
+                    </Typography.Paragraph>
+                    <Button onClick={onStartTOTP} disabled={totpBusy}>Set up</Button>
+                  </>
+                )}
+              </Form>
+
+              <Divider className="settings-divider" />
+            </>
+          )}
+
           <div>
             <Typography.Title level={4} style={{ marginTop: 0 }}>Danger Zone</Typography.Title>
             <Button danger icon={<Trash />} onClick={() => setConfirmOpen(true)}>Permanently delete my account</Button>
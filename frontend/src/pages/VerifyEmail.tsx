@@ -0,0 +1,57 @@
+import React, { useEffect, useRef, useState } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { useQueryClient } from '@tanstack/react-query'
+import { useAuth } from '@auth/AuthProvider'
+import { verifyEmail } from '@api/endpoints'
+import { Card, Typography, Spin } from 'antd'
+import { useDocumentTitle } from '@lib/useDocumentTitle'
+import { BrandLogo } from '@components/BrandLogo'
+
+type State = 'verifying' | 'done' | 'failed'
+
+export const VerifyEmail: React.FC = () => {
+  useDocumentTitle('Verify email')
+  const { isAuthed } = useAuth()
+  const qc = useQueryClient()
+  const [params] = useSearchParams()
+  const token = params.get('token') || ''
+  const [state, setState] = useState<State>(token ? 'verifying' : 'failed')
+  const started = useRef(false)
+
+  useEffect(() => {
+    // Verify once, even when effects run twice in development.
+    if (!token || started.current) return
+    started.current = true
+    verifyEmail(token)
+      .then(() => {
+        setState('done')
+        if (isAuthed) qc.invalidateQueries({ queryKey: ['me'] })
+      })
+      .catch(() => setState('failed'))
+  }, [token, isAuthed, qc])
+
+  const next = isAuthed ? <Link to="/app" className="link-primary">Continue to the app</Link> : <Link to="/login" className="link-primary">Log in</Link>
+
+  return (
+    <div className="login-page">
+      <div className="container">
+      <div className="brand-banner">
+        <div className="brand-row">
+          <BrandLogo to="/login" size={80} />
+          <span className="brand-wordmark">Bauhouse</span>
+        </div>
+      </div>
+      <Card className="paper-card">
+        <Typography.Title level={2} style={{ marginTop: 0 }}>Verify Email</Typography.Title>
+        {state === 'verifying' && <Spin />}
+        {state === 'done' && <Typography.Paragraph>Your email address is verified. {next}</Typography.Paragraph>}
+        {state === 'failed' && (
+          <Typography.Paragraph>
+            This verification link is invalid or has expired, or your email has changed since it was sent. Log in or open your account settings to get a new one. {next}
+          </Typography.Paragraph>
+        )}
+      </Card>
+      </div>
+    </div>
+  )
+}
@@ -3,11 +3,16 @@ import { Navigate, Route, Routes, useLocation } from 'react-router-dom'
 import { useAuth } from '@auth/AuthProvider'
 import { Login } from '@pages/Login'
 import { Register } from '@pages/Register'
+import { ForgotPassword } from '@pages/ForgotPassword'
+import { ResetPassword } from '@pages/ResetPassword'
+import { VerifyEmail } from '@pages/VerifyEmail'
+import { OIDCCallback } from '@pages/OIDCCallback'
 import { Dashboard } from '@pages/Dashboard'
 import { RoomSettings } from '@pages/RoomSettings'
 import { UserSettings } from '@pages/UserSettings'
 import { ListPage } from '@pages/ListPage'
 import { ListsIndex } from '@pages/ListsIndex'
+import { JoinInvite } from '@pages/JoinInvite'
 
 const RequireAuth: React.FC<React.PropsWithChildren> = ({ children }) => {
   const { isAuthed } = useAuth()
@@ -31,6 +36,10 @@ export const AppRoutes: React.FC = () => {
     <Routes>
       <Route path="/login" element={<RequireGuest><Login /></RequireGuest>} />
       <Route path="/register" element={<RequireGuest><Register /></RequireGuest>} />
+      <Route path="/forgot-password" element={<RequireGuest><ForgotPassword /></RequireGuest>} />
+      <Route path="/reset-password" element={<ResetPassword />} />
+      <Route path="/verify-email" element={<VerifyEmail />} />
+      <Route path="/oidc/callback" element={<RequireGuest><OIDCCallback /></RequireGuest>} />
       <Route
         path="/app"
         element={
@@ -71,6 +80,14 @@ export const AppRoutes: React.FC = () => {
           </RequireAuth>
         }
       />
+      <Route
+        path="/join/:token"
+        element={
+          <RequireAuth>
+            <JoinInvite />
+          </RequireAuth>
+        }
+      />
       <Route path="/" element={<Navigate to="/login" replace />} />
       <Route path="*" element={<Navigate to="/app" replace />} />
     </Routes>